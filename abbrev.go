@@ -0,0 +1,124 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseAbbrevs parses the abbreviations of -abbrev, a comma separated list
+// of key=label, e.g. `c=compile,t="run tests"`. A label may be quoted, to
+// contain commas. Typing a key alone at the prompt records its label, see
+// checkAbbrev.
+func ParseAbbrevs(spec string) (map[string]string, error) {
+	abbrevs := make(map[string]string)
+	if spec == "" {
+		return abbrevs, nil
+	}
+	start, quoted, escaped := 0, false, false
+	for i, r := range spec {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			if err := addAbbrev(abbrevs, spec[start:i]); err != nil {
+				return nil, err
+			}
+			start = i + 1
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", spec)
+	}
+	if err := addAbbrev(abbrevs, spec[start:]); err != nil {
+		return nil, err
+	}
+	return abbrevs, nil
+}
+
+// ReadAbbrevsFile reads the abbreviations of -abbrev-file from path, see
+// ParseAbbrevsFile
+func ReadAbbrevsFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseAbbrevsFile(f)
+}
+
+// ParseAbbrevsFile parses abbreviations one per line, as key=label like in
+// ParseAbbrevs. Empty lines and lines starting with '#' are skipped.
+func ParseAbbrevsFile(r io.Reader) (map[string]string, error) {
+	abbrevs := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := addAbbrev(abbrevs, line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return abbrevs, scanner.Err()
+}
+
+// addAbbrev adds the abbreviation key=label of def into abbrevs. The key
+// must be a single word that is not a command, since the commands are
+// typed the same way.
+func addAbbrev(abbrevs map[string]string, def string) error {
+	key, label, ok := strings.Cut(def, "=")
+	key, label = strings.TrimSpace(key), strings.TrimSpace(label)
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=label, got %q", def)
+	}
+	if strings.HasPrefix(label, `"`) {
+		unquoted, err := strconv.Unquote(label)
+		if err != nil {
+			return fmt.Errorf("invalid quoted label %s", label)
+		}
+		label = unquoted
+	}
+	switch {
+	case strings.ContainsAny(key, " \t\""):
+		return fmt.Errorf("abbreviation %q is not a single word", key)
+	case strings.HasPrefix(key, `\`):
+		return fmt.Errorf("abbreviation %q starts with a backslash, which forces a label", key)
+	case key == repeatShort || key == repeatLong:
+		return fmt.Errorf("abbreviation %q repeats the previous label", key)
+	case label == "":
+		return fmt.Errorf("empty label for %q", key)
+	case isReserved(label):
+		return fmt.Errorf("label %q is reserved", label)
+	}
+	if cmd, _, ok := lookupSessionCommand(key); ok {
+		return fmt.Errorf("abbreviation %q is the command %q", key, cmd.names[0])
+	}
+	if _, dup := abbrevs[key]; dup {
+		return fmt.Errorf("abbreviation %q given twice", key)
+	}
+	abbrevs[key] = label
+	return nil
+}
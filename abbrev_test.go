@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAbbrevs(t *testing.T) {
+	abbrevs, err := ParseAbbrevs(`c=compile, t="run tests", q="say \"hi\", then go",d = deploy env=prod`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"c": "compile", "t": "run tests", "q": `say "hi", then go`, "d": "deploy env=prod"}
+	if !reflect.DeepEqual(abbrevs, want) {
+		t.Errorf("Expected %q, got %q", want, abbrevs)
+	}
+	if abbrevs, err := ParseAbbrevs(""); err != nil || len(abbrevs) != 0 {
+		t.Errorf("Expected no abbreviations, got %q, %v", abbrevs, err)
+	}
+	for _, spec := range []string{"c", "=compile", "c=", "c=a,c=b", `c="open`, "a b=c", `\c=compile`,
+		"c=exit", "pause=p", "?=help", ".=dot"} {
+		if _, err := ParseAbbrevs(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+	if _, err := ParseAbbrevs("stop=s"); err == nil || !strings.Contains(err.Error(), `the command "stop"`) {
+		t.Errorf("Expected the conflict with the command, got %v", err)
+	}
+
+	abbrevs, err = ParseAbbrevsFile(strings.NewReader("\ufeff# build steps\nc=compile\n\nt=\"run tests\"\r\n"))
+	if want := map[string]string{"c": "compile", "t": "run tests"}; err != nil || !reflect.DeepEqual(abbrevs, want) {
+		t.Errorf("Expected %q, got %q, %v", want, abbrevs, err)
+	}
+	if _, err := ParseAbbrevsFile(strings.NewReader("c=compile\nmark\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
+
+func TestSessionAbbrevs(t *testing.T) {
+	sess := newSession("", collectOptions{Abbrevs: map[string]string{"c": "compile", "t": "3 run tests"}})
+	sess.start()
+	for _, line := range []string{"c", "t", `\c`, "c x", " c "} {
+		sess.handleLine(line, io.Discard)
+	}
+	var labels []string
+	for _, evt := range sess.Events[1:] {
+		labels = append(labels, evt.What)
+	}
+	if want := []string{"compile", "run tests", "c", "c x", "compile"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("Expected %q, got %q", want, labels)
+	}
+	if v := sess.Events[2].Value; v == nil || *v != 3 {
+		t.Errorf("Expected the value of the expanded label, got %v", v)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Attribute styles, see OutputOptions.AttrsStyle
+const (
+	attrsStyleColumns = "columns"
+	attrsStyleJSON    = "json"
+)
+
+// attrCategory is the attribute tagging an event with a category, e.g.
+// "category=work:review": the account of the lap in the timeclock format,
+// and a tag in timew. "again" copies it with the other attributes.
+const attrCategory = "category"
+
+// attrsColumn is the name of the column holding all attributes of an event
+// as a JSON object. It can not be used as an attribute name.
+const attrsColumn = "attrs"
+
+// validateAttrKey checks that key can be used as an attribute name: it must
+// be non-empty, contain no '=' or whitespace, and not collide with the name
+// of a built-in column.
+func validateAttrKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("empty attribute name")
+	}
+	if strings.ContainsRune(key, '=') || strings.IndexFunc(key, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid attribute name %q", key)
+	}
+	if key == attrsColumn {
+		return fmt.Errorf("attribute name %q is reserved", key)
+	}
+	for _, col := range eventColumns() {
+		if col.name == key {
+			return fmt.Errorf("attribute name %q is reserved for a built-in column", key)
+		}
+	}
+	return nil
+}
+
+// AttrColumns returns the union of the attribute names in events, sorted
+func AttrColumns(events []Event) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, evt := range events {
+		for key := range evt.Attrs {
+			if !seen[key] {
+				seen[key] = true
+				names = append(names, key)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitAttrs splits the trailing key=value words off text. Words with '='
+// before the last plain word are part of the text.
+func splitAttrs(text string) (map[string]string, string, error) {
+	words := strings.Fields(text)
+	i := len(words)
+	for i > 0 && strings.ContainsRune(words[i-1], '=') {
+		i--
+	}
+	if i == len(words) {
+		return nil, text, nil
+	}
+	attrs := make(map[string]string)
+	for _, word := range words[i:] {
+		key, value, _ := strings.Cut(word, "=")
+		if err := validateAttrKey(key); err != nil {
+			return nil, text, err
+		}
+		attrs[key] = value
+	}
+	return attrs, strings.Join(words[:i], " "), nil
+}
+
+// marshalAttrs encodes attrs as a compact JSON object with sorted keys. An
+// empty set is encoded as the empty string rather than "{}".
+func marshalAttrs(attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(attrs)
+	return string(b)
+}
+
+// unmarshalAttrs is the inverse of marshalAttrs. Both "" and "{}" decode
+// to a nil map.
+func unmarshalAttrs(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var attrs map[string]string
+	if err := json.Unmarshal([]byte(s), &attrs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", attrsColumn, err)
+	}
+	for key := range attrs {
+		if err := validateAttrKey(key); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", attrsColumn, err)
+		}
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	return attrs, nil
+}
@@ -0,0 +1,283 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// cmdTick handles "tick -30s meeting started": a tick labeled with the
+// rest of the line, timestamped the duration before now. Without a leading
+// -<duration>, the line is recorded as a label, as if it was no command.
+func (s *Session) cmdTick(arg string, out io.Writer) {
+	first, label, _ := strings.Cut(arg, " ")
+	if len(first) < 2 || first[0] != '-' || first[1] < '0' || first[1] > '9' {
+		s.recordLabel(strings.TrimSpace(labelTick+" "+arg), out)
+		return
+	}
+	ago, err := time.ParseDuration(first)
+	if err != nil || ago == 0 {
+		fmt.Fprintf(out, "# Invalid duration %q, not recorded; e.g. 'tick -30s <label>'\n", first)
+		return
+	}
+	evt, err := parseTick(strings.TrimSpace(label))
+	if err != nil {
+		fmt.Fprintf(out, "# %v, not recorded\n", err)
+		return
+	}
+	s.recordBackdated(evt, ago, out)
+}
+
+// recordBackdated records evt as a tick at ago (negative) from now. The
+// event goes into Events at its place in time: before the previous events
+// only with -allow-out-of-order, and never before the start of the session
+// or of the lap group, or into a pause. It gets the next sequence number,
+// and is sent to the sinks, at once; the sequence numbers follow the order
+// of the events again once the session is finished.
+func (s *Session) recordBackdated(evt Event, ago time.Duration, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	if s.reacting() {
+		fmt.Fprintln(out, "# Back-dated ticks are not reactions, not recorded")
+		return
+	}
+	evt.What = s.cleanLabel(evt.What)
+	for k, v := range evt.Attrs {
+		evt.Attrs[k] = s.cleanLabel(v)
+	}
+	if evt.What == "" {
+		evt.What = s.opts.SourceLabels[s.source]
+	}
+	if evt.What == "" {
+		evt.What = s.plainLabel()
+	}
+	if isReserved(evt.What) {
+		fmt.Fprintf(out, "# Label %q is reserved, not recorded\n", evt.What)
+		return
+	}
+	evt.What = s.opts.SourcePrefixes[s.source] + evt.What
+	if s.full() {
+		fmt.Fprintf(out, "# Reached %d events, not recorded\n", s.opts.MaxEvents)
+		return
+	}
+	at := s.eventTime().Add(ago)
+	i := len(s.Events)
+	for i > 0 && s.Events[i-1].Timestamp.After(at) {
+		i--
+	}
+	when := formatDuration(-ago) + " ago"
+	switch {
+	case i == 0 || at.Before(s.startAt):
+		fmt.Fprintf(out, "# %s is before the start of the session, not recorded\n", when)
+		return
+	case i < len(s.Events) && !s.opts.AllowOutOfOrder:
+		fmt.Fprintf(out, "# %s is before the previous event, not recorded; see -allow-out-of-order\n", when)
+		return
+	case i <= s.groupStart:
+		fmt.Fprintf(out, "# %s is before the reset, not recorded\n", when)
+		return
+	case s.pausedBefore(i):
+		fmt.Fprintf(out, "# %s falls in a pause, not recorded\n", when)
+		return
+	}
+	evt.Timestamp = at
+	evt = s.stampEvent(evt)
+	// the group and phase of the place in time, not of now
+	evt.Group, evt.Phase = s.Events[i-1].Group, s.Events[i-1].Phase
+	later := len(s.Events) - i
+	s.appendEvent(evt)
+	// counted from the end, as -keep-last may have dropped the first
+	s.moveEvent(len(s.Events)-1, len(s.Events)-1-later)
+	for _, sink := range s.opts.Sinks {
+		sink.Send(evt)
+	}
+	fmt.Fprintf(out, "# Back-dated %s to %s\n", evt.What, at.Local().Format("15:04:05"))
+	if later == 0 {
+		s.checkLap(out)
+	} else {
+		s.refreshLaps()
+		s.edited()
+	}
+}
+
+// cmdShift handles "shift 4 -2s" and "shift last +500ms", moving the
+// timestamp of the event with the sequence number, or of the last one, by
+// the duration. An event moved past its neighbors is refused, unless it is
+// a tick and -allow-out-of-order is given; it is then moved to its place in
+// time, with the same limits as a back-dated tick. The sinks are not told
+// about the change.
+func (s *Session) cmdShift(arg string, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	seqText, offset, _ := strings.Cut(arg, " ")
+	offset = strings.TrimSpace(offset)
+	d, err := time.ParseDuration(offset)
+	if seqText == "" || err != nil || d == 0 || (offset[0] != '+' && offset[0] != '-') {
+		fmt.Fprintln(out, "# Usage: shift <seq>|last +<duration>|-<duration>")
+		return
+	}
+	i := len(s.Events) - 1
+	if seqText != "last" {
+		if i = reviewIndex(s.Events, seqText); i < 0 {
+			fmt.Fprintf(out, "# No event with seq %q\n", seqText)
+			return
+		}
+	}
+	evt := s.Events[i]
+	if i == 0 || isSentinel(evt.What) {
+		fmt.Fprintf(out, "# Event %d is %q, can not shift\n", evt.Seq, evt.What)
+		return
+	}
+	at := evt.Timestamp.Add(d)
+	if at.After(s.now()) {
+		fmt.Fprintf(out, "# Event %d would be in the future, not shifted\n", evt.Seq)
+		return
+	}
+	j := i
+	for j > 0 && s.Events[j-1].Timestamp.After(at) {
+		j--
+	}
+	for j < len(s.Events)-1 && s.Events[j+1].Timestamp.Before(at) {
+		j++
+	}
+	if j != i {
+		if why := s.unmovable(i, j); why != "" {
+			fmt.Fprintf(out, "# Event %d %s, not shifted\n", evt.Seq, why)
+			return
+		}
+	}
+	s.Events[i].Timestamp, s.Events[i].Zone = at, localZoneName(at)
+	if j != i {
+		before := j - 1
+		if j > i {
+			before = j
+		}
+		s.Events[i].Group, s.Events[i].Phase = s.Events[before].Group, s.Events[before].Phase
+		s.moveEvent(i, j)
+	}
+	if s.paused && evt.What == labelPause && !s.pauseControlAfter(j) {
+		s.pausedAt = at // the pause in progress
+	}
+	s.refreshLaps()
+	s.edited()
+	layout := "15:04:05.000"
+	fmt.Fprintf(out, "# Shifted %d %s: %s -> %s\n", evt.Seq, evt.What, evt.Timestamp.Local().Format(layout),
+		at.Local().Format(layout))
+}
+
+// unmovable tells why the event at index i can not be moved to index j,
+// past its neighbors, or "" if it can
+func (s *Session) unmovable(i, j int) string {
+	lo, hi := j, i
+	if j > i {
+		lo, hi = i+1, j+1
+	}
+	switch {
+	case !s.opts.AllowOutOfOrder:
+		return "would move past its neighbors; see -allow-out-of-order"
+	case isReserved(s.Events[i].What):
+		return "is not a tick, and can not move past other events"
+	case j == 0:
+		return "would go before the start of the session"
+	}
+	for _, evt := range s.Events[lo:hi] {
+		if isSentinel(evt.What) || isReset(evt.What) {
+			return fmt.Sprintf("would move past %q", evt.What)
+		}
+	}
+	// the last pause control before the new place, evt itself being none
+	if (j < i && s.pausedBefore(j)) || (j > i && s.pausedBefore(j+1)) {
+		return "would fall in a pause"
+	}
+	return ""
+}
+
+// moveEvent moves the event at index from to index to in Events, shifting
+// the events in between
+func (s *Session) moveEvent(from, to int) {
+	if from == to {
+		return
+	}
+	evt := s.Events[from]
+	if from < to {
+		copy(s.Events[from:to], s.Events[from+1:to+1])
+	} else {
+		copy(s.Events[to+1:from+1], s.Events[to:from])
+	}
+	s.Events[to] = evt
+	s.resequence = true
+}
+
+// refreshLaps recomputes the short lap flags and the deviations from the
+// target lap time of the events, see checkLap, once events were moved
+func (s *Session) refreshLaps() {
+	if s.opts.MinLap <= 0 && s.opts.Target <= 0 {
+		return
+	}
+	laps := make(map[int]time.Duration)
+	for _, span := range lapSpans(s.Events) {
+		laps[span.closer] += span.end.Sub(span.start)
+	}
+	for i, lap := range laps {
+		evt := &s.Events[i]
+		if s.opts.MinLap > 0 {
+			if lap < s.opts.MinLap {
+				evt.Flag = flagShort
+			} else if evt.Flag == flagShort {
+				evt.Flag = ""
+			}
+		}
+		if s.opts.Target > 0 {
+			secs := (lap - s.opts.Target).Round(time.Millisecond).Seconds()
+			evt.VsTarget = &secs
+		}
+	}
+}
+
+// pausedBefore reports whether the session is paused at the place of the
+// event with index i, i.e. whether the last pause control before it is a
+// pause
+func (s *Session) pausedBefore(i int) bool {
+	for i--; i >= 0; i-- {
+		if isPauseControl(s.Events[i].What) {
+			return s.Events[i].What == labelPause
+		}
+	}
+	return false
+}
+
+// pauseControlAfter reports whether a pause or resume follows the event
+// with index i
+func (s *Session) pauseControlAfter(i int) bool {
+	for _, evt := range s.Events[i+1:] {
+		if isPauseControl(evt.What) {
+			return true
+		}
+	}
+	return false
+}
+
+// renumber gives the events their sequence numbers in order and without
+// gaps, after events were moved or deleted, as the review does
+func (s *Session) renumber() {
+	for i := range s.Events {
+		s.Events[i].Seq = s.seq(s.Dropped + i)
+	}
+}
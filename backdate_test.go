@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setClock makes the clock of sess return start plus the seconds in at
+func setClock(sess *Session, at *int) {
+	start := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	sess.now = func() time.Time { return start.Add(time.Duration(*at) * time.Second) }
+}
+
+func TestSessionBackdatedTick(t *testing.T) {
+	at := 0
+	sess := newSession("", collectOptions{})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	for _, step := range []struct {
+		at   int
+		line string
+	}{
+		{60, "a"}, {100, "tick -30s meeting started"}, {110, "tick -50s x"}, {110, "tick -200s"},
+		{110, "tick -5"}, {110, "tick -30x"}, {110, "tick foo"}, {110, "tick"}, {120, "tick -5s"},
+	} {
+		at = step.at
+		sess.handleLine(step.line, &out)
+	}
+	var got []string
+	var offsets []int
+	for _, evt := range sess.Events {
+		got = append(got, evt.What)
+		offsets = append(offsets, int(evt.Timestamp.Sub(sess.startAt)/time.Second))
+	}
+	want := []string{labelEnter, "a", "meeting started", "tick foo", labelTick, labelTick}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(offsets, []int{0, 60, 70, 110, 110, 115}) {
+		t.Errorf("Expected %q at 0, 60, 70, 110 and 115, got %q at %v", want, got, offsets)
+	}
+	wantOut := "# Back-dated meeting started to " + sess.Events[2].Timestamp.Local().Format("15:04:05") + "\n" +
+		"# 50s ago is before the previous event, not recorded; see -allow-out-of-order\n" +
+		"# 3m20s ago is before the start of the session, not recorded\n" +
+		"# Invalid duration \"-5\", not recorded; e.g. 'tick -30s <label>'\n" +
+		"# Invalid duration \"-30x\", not recorded; e.g. 'tick -30s <label>'\n" +
+		"# Back-dated tick to " + sess.Events[5].Timestamp.Local().Format("15:04:05") + "\n"
+	if out.String() != wantOut {
+		t.Errorf("Expected:\n%s\ngot:\n%s", wantOut, out.String())
+	}
+}
+
+func TestSessionBackdatedOutOfOrder(t *testing.T) {
+	at := 0
+	var sent []int64
+	sess := newSession("", collectOptions{AllowOutOfOrder: true, Sinks: []eventSink{eventFunc(func(evt Event) {
+		sent = append(sent, evt.Seq)
+	})}})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	for _, step := range []struct {
+		at   int
+		line string
+	}{
+		{60, "a"}, {120, "b"}, {130, "tick -100s early k=v"}, {140, "pause"}, {200, "resume"},
+		{210, "tick -30s"}, {220, "reset"}, {230, "tick -20s"}, {240, "tick -15s late"},
+	} {
+		at = step.at
+		sess.handleLine(step.line, &out)
+	}
+	at = 250
+	sess.finish()
+
+	var got []string
+	for i, evt := range sess.Events {
+		got = append(got, evt.What)
+		if evt.Seq != int64(i) {
+			t.Errorf("Expected the events to be renumbered in order, got %d at %d", evt.Seq, i)
+		}
+	}
+	want := []string{labelEnter, "early", "a", "b", labelPause, labelResume, labelReset, "late", labelExit}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if evt := sess.Events[1]; evt.Attrs["k"] != "v" || evt.Group != 0 || sess.Events[7].Group != 1 {
+		t.Errorf("Expected the group of the place in time, got %+v", sess.Events)
+	}
+	// the sinks get the events as recorded
+	if wantSent := []int64{0, 1, 2, 3, 4, 5, 6, 7, 8}; !reflect.DeepEqual(sent, wantSent) {
+		t.Errorf("Expected %v sent, got %v", wantSent, sent)
+	}
+	if laps := LapDurations(sess.Events[:4]); !reflect.DeepEqual(laps, seconds(30, 30, 60)) {
+		t.Errorf("Expected the laps around the inserted tick, got %v", laps)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("# 30s ago falls in a pause, not recorded\n")) ||
+		!bytes.Contains(out.Bytes(), []byte("# 20s ago is before the reset, not recorded\n")) {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestSessionBackdatedKeepLast(t *testing.T) {
+	at := 0
+	sess := newSession("", collectOptions{AllowOutOfOrder: true, KeepLast: 3})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	for _, line := range []string{"a", "b", "c", "tick -25s x"} {
+		at += 10
+		sess.handleLine(line, &out)
+	}
+	at += 10
+	sess.finish()
+	var got []string
+	var seqs []int64
+	for _, evt := range sess.Events {
+		got, seqs = append(got, evt.What), append(seqs, evt.Seq)
+	}
+	// enter, a, x, b, c, exit: the first three are dropped
+	if !reflect.DeepEqual(got, []string{"b", "c", labelExit}) || !reflect.DeepEqual(seqs, []int64{3, 4, 5}) {
+		t.Errorf("Expected b, c and exit numbered from 3, got %q, %v", got, seqs)
+	}
+}
+
+func TestSessionShift(t *testing.T) {
+	at := 0
+	sess := newSession("", collectOptions{Target: 10 * time.Second})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	for _, line := range []string{"a", "b", "c"} {
+		at += 10
+		sess.handleLine(line, &out)
+	}
+	at = 40
+	out.Reset()
+	for _, line := range []string{"shift 2 -3s", "shift last +5s", "shift last +10s", "shift 1 +15s", "shift 0 -1s",
+		"shift 9 -1s", "shift x", "shift 1 2s"} {
+		sess.handleLine(line, &out)
+	}
+	var offsets []int
+	var vsTarget []float64
+	for _, evt := range sess.Events {
+		offsets = append(offsets, int(evt.Timestamp.Sub(sess.startAt)/time.Second))
+		if evt.VsTarget != nil {
+			vsTarget = append(vsTarget, *evt.VsTarget)
+		}
+	}
+	if !reflect.DeepEqual(offsets, []int{0, 10, 17, 35}) {
+		t.Errorf("Expected the events at 0, 10, 17 and 35, got %v", offsets)
+	}
+	// the laps are compared to the target again
+	if !reflect.DeepEqual(vsTarget, []float64{0, -3, 8}) {
+		t.Errorf("Expected the deviations to be recomputed, got %v", vsTarget)
+	}
+	clock := func(i int) string { return sess.Events[i].Timestamp.Local().Format("15:04:05.000") }
+	wantOut := "# Shifted 2 b: " + sess.Events[1].Timestamp.Add(10*time.Second).Local().Format("15:04:05.000") + " -> " + clock(2) + "\n" +
+		"# Shifted 3 c: " + sess.Events[3].Timestamp.Add(-5*time.Second).Local().Format("15:04:05.000") + " -> " + clock(3) + "\n" +
+		"# Event 3 would be in the future, not shifted\n" +
+		"# Event 1 would move past its neighbors; see -allow-out-of-order, not shifted\n" +
+		"# Event 0 is \"enter\", can not shift\n" +
+		"# No event with seq \"9\"\n" +
+		"# Usage: shift <seq>|last +<duration>|-<duration>\n" +
+		"# Usage: shift <seq>|last +<duration>|-<duration>\n"
+	if out.String() != wantOut {
+		t.Errorf("Expected:\n%s\ngot:\n%s", wantOut, out.String())
+	}
+}
+
+func TestSessionShiftOutOfOrder(t *testing.T) {
+	at := 0
+	sess := newSession("", collectOptions{AllowOutOfOrder: true})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	for _, line := range []string{"a", "b", "pause", "resume", "reset", "c"} {
+		at += 10
+		sess.handleLine(line, &out)
+	}
+	at = 70
+	out.Reset()
+	for _, line := range []string{"shift 1 +15s", "shift 2 +15s", "shift 6 -15s", "shift 3 -12s", "shift 3 -2s"} {
+		sess.handleLine(line, &out)
+	}
+	sess.finish()
+	var got []string
+	for i, evt := range sess.Events {
+		got = append(got, evt.What)
+		if evt.Seq != int64(i) {
+			t.Errorf("Expected the events to be renumbered in order, got %d at %d", evt.Seq, i)
+		}
+	}
+	want := []string{labelEnter, "b", "a", labelPause, labelResume, labelReset, "c", labelExit}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if laps := LapDurations(sess.Events); !reflect.DeepEqual(laps, seconds(20, 5, 10)) {
+		t.Errorf("Expected the laps in the new order, got %v", laps)
+	}
+	for _, msg := range []string{
+		"# Event 2 would fall in a pause, not shifted\n",
+		"# Event 6 would move past \"reset\", not shifted\n",
+		"# Event 3 is not a tick, and can not move past other events, not shifted\n",
+		"# Shifted 3 pause: ",
+	} {
+		if !bytes.Contains(out.Bytes(), []byte(msg)) {
+			t.Errorf("Expected %q in the output, got:\n%s", msg, out.String())
+		}
+	}
+}
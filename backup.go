@@ -0,0 +1,77 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// backupName returns the first of "<path>.bak", "<path>.1.bak",
+// "<path>.2.bak"... that does not exist
+func backupName(path string) (string, error) {
+	name := path + ".bak"
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+		name = fmt.Sprintf("%s.%d.bak", path, n)
+	}
+}
+
+// writeWithBackup writes the output of write into a temporary file next to
+// path, then renames the existing path to a backup (see backupName) and the
+// temporary file to path. If anything fails, the original file is left in
+// place and no backup remains. Returns the name of the backup.
+func writeWithBackup(path string, write func(io.Writer) error) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("could not create file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	err = write(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), fi.Mode().Perm())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	backup, err := backupName(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, backup); err != nil {
+		return "", fmt.Errorf("could not back up: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		if rerr := os.Rename(backup, path); rerr != nil {
+			return "", fmt.Errorf("%w; the original file is left in %s", err, backup)
+		}
+		return "", err
+	}
+	return backup, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// dirContents returns the names and contents of the files in dir
+func dirContents(t *testing.T, dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(data)
+	}
+	return files
+}
+
+func TestDumpEventsBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv")
+	opts := OutputOptions{Backup: true}
+
+	// the first write has nothing to back up
+	var contents []string
+	for i := 0; i < 4; i++ {
+		opts.Comment = string(rune('a' + i))
+		if err := DumpEvents(path, testEvents(time.Second), opts); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	files := dirContents(t, dir)
+	want := map[string]string{
+		"out.csv":       contents[3],
+		"out.csv.bak":   contents[0],
+		"out.csv.1.bak": contents[1],
+		"out.csv.2.bak": contents[2],
+	}
+	if !reflect.DeepEqual(files, want) {
+		var names []string
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		t.Errorf("Unexpected files %q", names)
+	}
+}
+
+func TestWriteWithBackupFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv")
+	if err := os.WriteFile(path, []byte("original"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	errWrite := errors.New("write failed")
+	_, err := writeWithBackup(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+	if files := dirContents(t, dir); !reflect.DeepEqual(files, map[string]string{"out.csv": "original"}) {
+		t.Errorf("Expected only the original file, got %v", files)
+	}
+
+	backup, err := writeWithBackup(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new")
+		return err
+	})
+	if err != nil || backup != path+".bak" {
+		t.Fatalf("Expected backup %s, got %q, %v", path+".bak", backup, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o640 {
+		t.Errorf("Expected the mode of the original file to be kept, got %v, %v", fi.Mode(), err)
+	}
+}
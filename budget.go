@@ -0,0 +1,266 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// labelBudget is a duration budget of -budget: the time the laps of a label
+// may add up to, attributed like in LapsByLabel
+type labelBudget struct {
+	Label  string
+	Budget time.Duration
+}
+
+// budgetMetaKey is the key of the metadata lines recording the budgets, so
+// that the report can compare the laps with them
+const budgetMetaKey = "budget"
+
+// parseBudget parses a budget given as label=duration. The label is the one
+// recorded, and may not be one of the stopwatch's own.
+func parseBudget(s string) (labelBudget, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return labelBudget{}, fmt.Errorf("%q is not label=duration", s)
+	}
+	label := s[:i]
+	if isReserved(label) {
+		return labelBudget{}, fmt.Errorf("label %q is reserved", label)
+	}
+	d, err := time.ParseDuration(s[i+1:])
+	if err != nil {
+		return labelBudget{}, fmt.Errorf("%q: %v", s, err)
+	}
+	if d <= 0 {
+		return labelBudget{}, fmt.Errorf("%q: the budget must be positive", s)
+	}
+	return labelBudget{Label: label, Budget: d}, nil
+}
+
+func (b labelBudget) String() string {
+	return b.Label + "=" + b.Budget.String()
+}
+
+// budgetList is the flag.Value of -budget, one budget per label
+type budgetList []labelBudget
+
+func (l *budgetList) String() string {
+	var parts []string
+	for _, b := range *l {
+		parts = append(parts, b.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *budgetList) Set(s string) error {
+	b, err := parseBudget(s)
+	if err != nil {
+		return err
+	}
+	if _, ok := l.find(b.Label); ok {
+		return fmt.Errorf("label %q already has a budget", b.Label)
+	}
+	*l = append(*l, b)
+	return nil
+}
+
+// find returns the budget of label, if it has one
+func (l budgetList) find(label string) (time.Duration, bool) {
+	for _, b := range l {
+		if b.Label == label {
+			return b.Budget, true
+		}
+	}
+	return 0, false
+}
+
+// meta returns the metadata lines recording the budgets. With r, the
+// labels are redacted like those of the events, and left out when dropped.
+func (l budgetList) meta(r *redactor) []metaField {
+	var fields []metaField
+	for _, b := range l {
+		if r != nil {
+			if b.Label = r.label(b.Label); b.Label == "" {
+				continue
+			}
+		}
+		fields = append(fields, metaField{Key: budgetMetaKey, Value: b.String()})
+	}
+	return fields
+}
+
+// splitBudgetMeta returns the budgets recorded in the metadata lines, and
+// the other lines. A budget repeated for a label, e.g. in several files,
+// replaces the earlier one.
+func splitBudgetMeta(meta []metaField) (budgetList, []metaField) {
+	var budgets budgetList
+	var rest []metaField
+	for _, f := range meta {
+		if f.Key != budgetMetaKey {
+			rest = append(rest, f)
+			continue
+		}
+		b, err := parseBudget(f.Value)
+		if err != nil {
+			rest = append(rest, f)
+			continue
+		}
+		budgets = budgets.with(b)
+	}
+	return budgets, rest
+}
+
+// with returns l with the budget b, replacing that of the same label
+func (l budgetList) with(b labelBudget) budgetList {
+	for i := range l {
+		if l[i].Label == b.Label {
+			out := append(budgetList(nil), l...)
+			out[i] = b
+			return out
+		}
+	}
+	return append(l, b)
+}
+
+// spentByLabel totals the laps in events by the label of the event closing
+// each lap, like LapsByLabel. With an active label, the lap still open at
+// now counts as closed by it, as the next plain tick would close it.
+func spentByLabel(events []Event, active string, now time.Time) map[string]time.Duration {
+	if active != "" && len(events) > 0 && events[len(events)-1].What != labelExit {
+		events = append(events[:len(events):len(events)], Event{Timestamp: now, What: active})
+	}
+	spent := make(map[string]time.Duration)
+	forEachLap(events, func(evt Event, lap time.Duration) {
+		spent[evt.What] += lap
+	})
+	return spent
+}
+
+// budgetBalance describes what is left of budget after spent, or by how
+// much it was overrun
+func budgetBalance(spent, budget time.Duration) string {
+	if spent > budget {
+		return formatDuration(spent-budget) + " over"
+	}
+	return formatDuration(budget-spent) + " left"
+}
+
+// activeLabel returns the label of the lap being timed: the one the next
+// plain tick typed would record, see recordTick
+func (s *Session) activeLabel() string {
+	label := s.opts.SourceLabels[sourceStdin]
+	if label == "" {
+		var ok bool
+		if label, ok = s.upcomingLabel(); !ok {
+			label = s.plainLabel()
+		}
+	}
+	return s.opts.SourcePrefixes[sourceStdin] + label
+}
+
+// spent returns the time attributed to each label so far, see spentByLabel
+func (s *Session) spent() map[string]time.Duration {
+	return spentByLabel(s.Events, s.activeLabel(), s.now())
+}
+
+// activeBudget returns the label of the lap being timed, and the budget it
+// has left; negative once overrun. It returns false if the label has no
+// -budget.
+func (s *Session) activeBudget() (string, time.Duration, bool) {
+	if s.armed || len(s.Events) == 0 || len(s.opts.Budgets) == 0 {
+		return "", 0, false
+	}
+	label := s.activeLabel()
+	budget, ok := s.opts.Budgets.find(label)
+	if !ok {
+		return "", 0, false
+	}
+	return label, budget - s.spent()[label], true
+}
+
+// nextBudget returns the time until the budget of the label being timed
+// runs out, if it has one that has not yet
+func (s *Session) nextBudget() (time.Duration, bool) {
+	if s.paused {
+		return 0, false
+	}
+	label, left, ok := s.activeBudget()
+	if !ok || s.overBudget[label] {
+		return 0, false
+	}
+	if left < 0 {
+		left = 0
+	}
+	return left, true
+}
+
+// checkBudgets records a "budget-exceeded:" event for every label whose
+// budget has run out since the last check, once per label, and reports
+// whether there were any
+func (s *Session) checkBudgets(out io.Writer) bool {
+	if s.armed || len(s.Events) == 0 || len(s.opts.Budgets) == 0 {
+		return false
+	}
+	now := s.now()
+	spent := s.spent()
+	exceeded := false
+	for _, b := range s.opts.Budgets {
+		if s.overBudget[b.Label] || spent[b.Label] < b.Budget {
+			continue
+		}
+		if s.overBudget == nil {
+			s.overBudget = make(map[string]bool)
+		}
+		s.overBudget[b.Label] = true
+		s.recordEvent(Event{Timestamp: now, What: labelBudgetPrefix + b.Label})
+		exceeded = true
+		msg := fmt.Sprintf("\n# WARNING: budget of %s for %s used up", formatDuration(b.Budget), b.Label)
+		fmt.Fprintln(out, colorize(msg, ansiBold+";"+ansiRed, s.opts.Color))
+		s.notify(fmt.Sprintf("Budget of %s for %s used up", formatDuration(b.Budget), b.Label), now, out)
+	}
+	return exceeded
+}
+
+// WriteBudgetSummary writes the time spent on each label of budgets against
+// its budget into out, prefixed like WriteSummary
+func WriteBudgetSummary(out io.Writer, events []Event, budgets budgetList) error {
+	spent := spentByLabel(events, "", time.Time{})
+	for _, b := range budgets {
+		_, err := fmt.Fprintf(out, "# Budget %s: %s of %s (%s)\n", b.Label, formatDuration(spent[b.Label]),
+			formatDuration(b.Budget), budgetBalance(spent[b.Label], b.Budget))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBudgets writes the budgets of the report as an aligned table
+func writeBudgets(out io.Writer, events []Event, budgets budgetList) error {
+	if _, err := fmt.Fprintln(out, "Budgets:"); err != nil {
+		return err
+	}
+	spent := spentByLabel(events, "", time.Time{})
+	rows := [][]string{{"what", "spent", "budget", "balance"}}
+	for _, b := range budgets {
+		rows = append(rows, []string{sanitizeLabel(b.Label), formatDuration(spent[b.Label]), formatDuration(b.Budget),
+			budgetBalance(spent[b.Label], b.Budget)})
+	}
+	return writeTable(out, rows, 1)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBudget(t *testing.T) {
+	var budgets budgetList
+	for _, value := range []string{"writing=30m", "code review=1h30m", "a=b=15s"} {
+		if err := budgets.Set(value); err != nil {
+			t.Errorf("%q: %v", value, err)
+		}
+	}
+	if got := budgets.String(); got != "writing=30m0s,code review=1h30m0s,a=b=15s" {
+		t.Errorf("Unexpected budgets: %q", got)
+	}
+	for _, value := range []string{"", "writing", "=30m", "writing=", "writing=soon", "writing=0s", "writing=-1m",
+		"pause=5m", "warn:x=5m", "writing=1m"} {
+		if err := budgets.Set(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+	// recorded as metadata, and read back
+	meta := append([]metaField{{Key: "host", Value: "box"}}, budgets.meta(nil)...)
+	back, rest := splitBudgetMeta(meta)
+	if back.String() != budgets.String() || len(rest) != 1 || rest[0].Key != "host" {
+		t.Errorf("Expected the budgets back, got %v, %v", back, rest)
+	}
+	// redacted like the labels of the events
+	r, _ := newRedactor(redactHash, "")
+	if got := budgets.meta(r)[0].Value; got != r.label("writing")+"=30m0s" {
+		t.Errorf("Expected the label redacted, got %q", got)
+	}
+	if r, _ = newRedactor(redactDrop, ""); len(budgets.meta(r)) != 0 {
+		t.Error("Expected no budgets with the labels dropped")
+	}
+}
+
+func TestSpentByLabel(t *testing.T) {
+	events := testEvents(time.Minute, 2*time.Minute, 3*time.Minute, 4*time.Minute)
+	for i, label := range []string{"writing", "mark:x", "review", "writing"} {
+		events[i+1].What = label
+	}
+	events = events[:len(events)-1]
+	// the mark closes no lap: the lap of review spans it
+	end := events[len(events)-1].Timestamp
+	spent := spentByLabel(events, "", time.Time{})
+	if spent["writing"] != time.Minute || spent["review"] != 5*time.Minute || len(spent) != 2 {
+		t.Errorf("Unexpected totals: %v", spent)
+	}
+	// the open lap counts for the active label
+	spent = spentByLabel(events, "writing", end.Add(90*time.Second))
+	if spent["writing"] != 150*time.Second {
+		t.Errorf("Expected the open lap in the total, got %v", spent)
+	}
+	if len(events) != 4 {
+		t.Error("Expected the events to be left as they were")
+	}
+}
+
+func TestSessionBudgets(t *testing.T) {
+	at := 0
+	sess := newSession("", collectOptions{
+		Labels:  []string{"writing", "review"},
+		Budgets: budgetList{{"writing", 10 * time.Minute}, {"review", 5 * time.Minute}},
+	})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	if wait, ok := sess.nextTimer(); !ok || wait != 10*time.Minute {
+		t.Errorf("Expected to wait for the writing budget, got %v, %v", wait, ok)
+	}
+	at = 4 * 60
+	if p := sess.promptState(); p.Budget != "writing" || p.Left != 6*time.Minute || !strings.Contains(p.State, "[writing 6m0s left] ") {
+		t.Errorf("Unexpected prompt state %+v", p)
+	}
+	at = 6 * 60
+	sess.handleLine("", &out) // writing: 6m
+	at = 8 * 60
+	// time paused is not spent
+	sess.handleLine("pause", &out)
+	at = 20 * 60
+	if _, ok := sess.nextBudget(); ok {
+		t.Error("Expected no budget to run out while paused")
+	}
+	sess.handleLine("resume", &out)
+	if wait, ok := sess.nextTimer(); !ok || wait != 3*time.Minute {
+		t.Errorf("Expected the review budget left, got %v, %v", wait, ok)
+	}
+	at = 23 * 60
+	if !sess.checkTimers(&out) {
+		t.Error("Expected the review budget to run out")
+	}
+	at = 30 * 60
+	sess.checkTimers(&out)
+	if p := sess.promptState(); p.Left != -7*time.Minute || !strings.Contains(p.State, "[review 7m0s over] ") {
+		t.Errorf("Unexpected prompt state %+v", p)
+	}
+	if _, ok := sess.nextBudget(); ok {
+		t.Error("Expected no more waiting for a budget already exceeded")
+	}
+	sess.handleLine("", &out) // review: 12m
+	// a lap typed with a label exceeds its budget at once, but only once
+	for _, step := range []int{32, 40, 50} {
+		at = step * 60
+		sess.handleLine("writing", &out)
+		sess.checkTimers(&out)
+	}
+
+	var got []string
+	for _, evt := range sess.Events {
+		if isAnnotation(evt.What) {
+			got = append(got, evt.What+"@"+evt.Timestamp.Sub(sess.startAt).String())
+		}
+	}
+	if want := "budget-exceeded:review@23m0s budget-exceeded:writing@40m0s"; strings.Join(got, " ") != want {
+		t.Errorf("Expected the budgets exceeded once:\n%s\ngot:\n%s", want, strings.Join(got, " "))
+	}
+	if n := strings.Count(out.String(), "# WARNING: budget of"); n != 2 {
+		t.Errorf("Expected two warnings, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	WriteBudgetSummary(&out, sess.Events, sess.opts.Budgets)
+	want := "# Budget writing: 26m0s of 10m0s (16m0s over)\n# Budget review: 12m0s of 5m0s (7m0s over)\n"
+	if out.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	// untracked labels have no budget to show
+	sess = newSession("", collectOptions{Budgets: budgetList{{"writing", time.Minute}}})
+	setClock(sess, &at)
+	sess.start()
+	if _, ok := sess.nextTimer(); ok || strings.Contains(sess.promptState().State, "[") {
+		t.Error("Expected the plain ticks to be untracked")
+	}
+}
+
+func TestWriteReportBudgets(t *testing.T) {
+	events := testEvents(time.Minute, 20*time.Minute, time.Second)
+	events[1].What, events[2].What = "review", "writing"
+	var b budgetList
+	b.Set("writing=15m")
+	b.Set("review=5m")
+	var out bytes.Buffer
+	if err := WriteReport(&out, events, "", ReportOptions{Budgets: b}); err != nil {
+		t.Fatal(err)
+	}
+	want := "Budgets:\n" +
+		"  what     spent  budget    balance\n" +
+		"  writing  20m0s   15m0s  5m0s over\n" +
+		"  review    1m0s    5m0s  4m0s left\n"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("Expected:\n%s\nin:\n%s", want, out.String())
+	}
+}
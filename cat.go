@@ -0,0 +1,163 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// catOptions controls the table printed by the cat command
+type catOptions struct {
+	Head  int  // print only the first rows; 0 for all
+	Tail  int  // print only the last rows; 0 for all
+	Color bool // highlight the header and the sentinel rows with ANSI colors
+
+	Order string // "desc" prints the newest event first, see OutputOptions.Order
+}
+
+// catLapColumn is the column of the lap durations added by the cat command
+const catLapColumn = "lap"
+
+func runCat(args []string) int {
+	fs := newFlagSet("cat", "<file.csv>")
+	head := fs.Int("head", 0, "Print only the first N rows")
+	tail := fs.Int("tail", 0, "Print only the last N rows")
+	order := fs.String("order", orderAsc, "Order of the rows: '"+orderAsc+"', the oldest first, or '"+orderDesc+"', the newest first")
+	completeValues(fs, "order", func() []string { return orders })
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if err := validateHeadTail(*head, *tail); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	if err := validateOrder(*order, ""); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	events, comment, err := LoadCSV(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem reading CSV:", err)
+		return 1
+	}
+	opts := catOptions{Head: *head, Tail: *tail, Color: useColor(os.Stdout), Order: *order}
+	if err := writeCatTable(os.Stdout, events, comment, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	return 0
+}
+
+// writeCatTable writes events into out as a table aligned for reading, with
+// the duration of the lap each event closes in the "lap" column before
+// "what", and the comment above. The cells are sanitized, so that a file
+// can not move the cursor or change the colors of the terminal.
+func writeCatTable(out io.Writer, events []Event, comment string, opts catOptions) error {
+	_, events, outOpts, err := prepareOutput(events, OutputOptions{})
+	if err != nil {
+		return err
+	}
+	var header []string
+	for _, name := range outOpts.Header() {
+		if name == "what" {
+			header = append(header, catLapColumn)
+		}
+		header = append(header, name)
+	}
+	laps := make([]string, len(events))
+	forEachLapIndex(events, func(i int, lap time.Duration) {
+		laps[i] = formatDuration(lap)
+	})
+	if opts.Order == orderDesc {
+		// -head and -tail count the rows as printed
+		events = reversed(events)
+		for i, j := 0, len(laps)-1; i < j; i, j = i+1, j-1 {
+			laps[i], laps[j] = laps[j], laps[i]
+		}
+	}
+
+	first, last := 0, len(events)
+	if opts.Head > 0 && opts.Head < last {
+		last = opts.Head
+	}
+	if opts.Tail > 0 && opts.Tail < last {
+		first = last - opts.Tail
+	}
+	rows := [][]string{header}
+	for i := first; i < last; i++ {
+		row := make([]string, len(header))
+		for j, name := range header {
+			if name == catLapColumn {
+				row[j] = laps[i]
+			} else {
+				row[j] = sanitizeLabel(events[i].Cell(name))
+			}
+		}
+		rows = append(rows, row)
+	}
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for j, cell := range row {
+			if n := displayWidth(cell); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	if comment != "" {
+		fmt.Fprintf(w, "# %s\n", sanitizeLabel(comment))
+	}
+	if first > 0 {
+		fmt.Fprintf(w, "# ... the last %d of %d rows:\n", last-first, len(events))
+	}
+	for i, row := range rows {
+		var line strings.Builder
+		for j, cell := range row {
+			pad := strings.Repeat(" ", widths[j]-displayWidth(cell))
+			if j > 0 {
+				line.WriteString("  ")
+			}
+			if header[j] == "seq" || header[j] == catLapColumn {
+				line.WriteString(pad + cell)
+			} else {
+				line.WriteString(cell + pad)
+			}
+		}
+		text := strings.TrimRight(line.String(), " ")
+		switch {
+		case i == 0:
+			w.WriteString(colorize(text, ansiBold, opts.Color))
+		case isSentinel(events[first+i-1].What):
+			w.WriteString(colorize(text, ansiGreen, opts.Color))
+		default:
+			w.WriteString(text)
+		}
+		w.WriteString("\n")
+	}
+	if last < len(events) {
+		fmt.Fprintf(w, "# ... the first %d of %d rows\n", last, len(events))
+	}
+	return w.Flush()
+}
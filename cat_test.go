@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteCatTable(t *testing.T) {
+	events := testEvents(time.Second, 1500*time.Millisecond, time.Second)
+	events[1].What = "lap \x1b[31mone"
+	events[2].Attrs = map[string]string{"lane": "3"}
+	var buf bytes.Buffer
+	if err := writeCatTable(&buf, events, "run 1", catOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "# run 1\n" +
+		"seq  ts                       lap  what         lane\n" +
+		"  0  2022-04-08T20:00:00Z          enter\n" +
+		"  1  2022-04-08T20:00:01Z      1s  lap [31mone\n" +
+		"  2  2022-04-08T20:00:02.5Z  1.5s  tick         3\n" +
+		"  3  2022-04-08T20:00:03.5Z        exit\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := writeCatTable(&buf, events, "", catOptions{Tail: 1, Color: true}); err != nil {
+		t.Fatal(err)
+	}
+	want = "# ... the last 1 of 4 rows:\n" +
+		"\x1b[1mseq  ts                      lap  what  lane\x1b[0m\n" +
+		"\x1b[32m  3  2022-04-08T20:00:03.5Z       exit\x1b[0m\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := writeCatTable(&buf, events, "", catOptions{Head: 2}); err != nil {
+		t.Fatal(err)
+	}
+	want = "seq  ts                    lap  what         lane\n" +
+		"  0  2022-04-08T20:00:00Z       enter\n" +
+		"  1  2022-04-08T20:00:01Z   1s  lap [31mone\n" +
+		"# ... the first 2 of 4 rows\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+
+	// the laps stay with their events, and -head counts the rows printed
+	buf.Reset()
+	if err := writeCatTable(&buf, events, "", catOptions{Head: 2, Order: orderDesc}); err != nil {
+		t.Fatal(err)
+	}
+	want = "seq  ts                       lap  what  lane\n" +
+		"  3  2022-04-08T20:00:03.5Z        exit\n" +
+		"  2  2022-04-08T20:00:02.5Z  1.5s  tick  3\n" +
+		"# ... the first 2 of 4 rows\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// defaultColumns are the names of the default columns, see Row
+var defaultColumns = GetEventColumnNames()
+
+// appendCell appends the named column of e to buf, for the columns
+// formatted from numbers, and reports whether it did. The other columns
+// are left to Cell.
+func (e Event) appendCell(buf []byte, name string) ([]byte, bool) {
+	switch name {
+	case "seq":
+		return strconv.AppendInt(buf, e.Seq, 10), true
+	case "ts":
+		return e.Timestamp.AppendFormat(buf, time.RFC3339Nano), true
+	case "ts_ns":
+		return strconv.AppendInt(buf, e.Timestamp.UnixNano(), 10), true
+	case "group":
+		return strconv.AppendInt(buf, int64(e.Group), 10), true
+	}
+	return buf, false
+}
+
+// appendCell is Event.appendCell, in the styles of opts; see cell
+func (opts OutputOptions) appendCell(buf []byte, evt Event, name string) ([]byte, bool) {
+	if name == "ts" && opts.startRelative() {
+		return buf, false
+	}
+	return evt.appendCell(buf, name)
+}
+
+// fillRows sets rows[j][i] to the column names[i] of events[j], see cell.
+// The rows are at least as long as the names, and as many as the events.
+// The cells formatted from numbers, such as seq and ts, are appended into
+// buf, which is then converted into a single string for all the rows,
+// instead of allocating a string for each cell; ends holds the end of each
+// of them in buf. Both are scratch space, returned grown for reuse.
+func (opts OutputOptions) fillRows(rows [][]string, events []Event, names []string, buf []byte, ends []int) ([]byte, []int) {
+	buf, ends = buf[:0], ends[:0]
+	for j, evt := range events {
+		for i, name := range names {
+			var ok bool
+			if buf, ok = opts.appendCell(buf, evt, name); ok {
+				ends = append(ends, len(buf))
+			} else {
+				rows[j][i] = opts.cell(evt, name)
+				ends = append(ends, -1)
+			}
+		}
+	}
+	text := string(buf)
+	start, k := 0, 0
+	for j := range events {
+		for i := range names {
+			if end := ends[k]; end >= 0 {
+				rows[j][i], start = text[start:end], end
+			}
+			k++
+		}
+	}
+	return buf, ends
+}
+
+// rowFiller fills rows of events with fillRows, rowFillChunk events at a
+// time, so that the scratch space stays small and is reused
+type rowFiller struct {
+	opts  OutputOptions
+	names []string
+	buf   []byte
+	ends  []int
+}
+
+// rowFillChunk is how many events rowFiller converts into one string
+const rowFillChunk = 1024
+
+// fill is fillRows with the scratch space of f
+func (f *rowFiller) fill(rows [][]string, events []Event) {
+	for len(events) > 0 {
+		n := len(events)
+		if n > rowFillChunk {
+			n = rowFillChunk
+		}
+		f.buf, f.ends = f.opts.fillRows(rows[:n], events[:n], f.names, f.buf, f.ends)
+		rows, events = rows[n:], events[n:]
+	}
+}
+
+// newRows returns n rows of width cells, sharing one backing array
+func newRows(n, width int) [][]string {
+	cells := make([]string, n*width)
+	rows := make([][]string, n)
+	for i := range rows {
+		rows[i] = cells[i*width : (i+1)*width : (i+1)*width]
+	}
+	return rows
+}
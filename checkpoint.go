@@ -0,0 +1,241 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// checkpointSuffix is the suffix of the checkpoint files, named by the
+// session ID
+const checkpointSuffix = ".json"
+
+// checkpointDir returns the directory of the crash-recovery checkpoints:
+// $XDG_STATE_HOME/stopwatch, or ~/.local/state/stopwatch
+func checkpointDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "stopwatch"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "stopwatch"), nil
+}
+
+// checkpoint is the contents of a checkpoint file. The events are stored as
+// records with every column of Event, as read by EventsFromRecords, so that
+// nothing is lost whatever the output options.
+type checkpoint struct {
+	Schema  int         `json:"schema"`
+	Session string      `json:"session"`
+	PID     int         `json:"pid"`
+	Name    string      `json:"name,omitempty"`
+	Comment string      `json:"comment,omitempty"`
+	Meta    []metaField `json:"meta,omitempty"`   // see -with-env
+	Output  string      `json:"output,omitempty"` // the -o of the session
+	Records [][]string  `json:"records"`
+}
+
+// checkpointColumns are the columns of the checkpoint records: those of
+// the fields of Event, without the derived ones, and the attributes
+func checkpointColumns() []string {
+	var names []string
+	for _, f := range structFields(reflect.TypeOf(Event{})) {
+		names = append(names, f.name)
+	}
+	return append(names, attrsColumn)
+}
+
+// events returns the events of the checkpoint
+func (c checkpoint) events() ([]Event, error) {
+	if c.Schema > schemaVersion {
+		_, err := lookupSchema(c.Schema)
+		return nil, err
+	}
+	return EventsFromRecords(c.Records)
+}
+
+// writeFileAtomic writes data into path through a temporary file renamed
+// over it, so that path is never left partially written
+func writeFileAtomic(path string, data []byte) error {
+	return writeAtomic(path, 0o600, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeAtomic is like writeFileAtomic, but with the output of write, and
+// the file permissions perm
+func writeAtomic(path string, perm os.FileMode, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	err = write(tmp)
+	if serr := tmp.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// checkpointSink writes the events recorded so far into a checkpoint file
+// after every event, so that a session is not lost if the program crashes
+// or is killed before writing the output. The file is written by a
+// goroutine of its own, only ever with the latest events, so that a slow
+// disk does not hold up recording.
+type checkpointSink struct {
+	path     string
+	header   checkpoint // without the records
+	keepLast int        // keep only this many of the last events, as -keep-last
+
+	events []Event // the events sent, owned by the caller of Send
+
+	mu     sync.Mutex
+	latest []Event // the events to write next, nil once written
+	wake   chan struct{}
+	done   chan struct{}
+	errs   *sinkErrors
+
+	writing sync.Mutex // held while writing the file
+	flushed bool       // written by flush, and not to be replaced
+}
+
+// newCheckpointSink creates the checkpoint file of session in dir. Errors
+// writing it later are reported into stderr.
+func newCheckpointSink(dir string, header checkpoint, keepLast int, stderr io.Writer) (*checkpointSink, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	header.Schema, header.PID = schemaVersion, os.Getpid()
+	s := &checkpointSink{
+		path:     filepath.Join(dir, header.Session+checkpointSuffix),
+		header:   header,
+		keepLast: keepLast,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		errs:     &sinkErrors{out: stderr, name: "checkpoint " + filepath.Join(dir, header.Session+checkpointSuffix)},
+	}
+	if err := s.write(nil); err != nil {
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *checkpointSink) Send(evt Event) {
+	s.events = append(s.events, evt)
+	if s.keepLast > 0 && len(s.events) > s.keepLast {
+		s.events = s.events[1:]
+	}
+	// Events are only ever appended, so the writer may read the slice
+	// while more are added
+	s.mu.Lock()
+	s.latest = s.events[:len(s.events):len(s.events)]
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default: // the writer is awake already, and will see the latest events
+	}
+}
+
+// Close waits for the last events to be written
+func (s *checkpointSink) Close(Stats) {
+	close(s.wake)
+	<-s.done
+}
+
+// run writes the latest events whenever woken up, until Close
+func (s *checkpointSink) run() {
+	defer close(s.done)
+	for range s.wake {
+		s.mu.Lock()
+		events := s.latest
+		s.latest = nil
+		s.mu.Unlock()
+		if events != nil {
+			s.errs.report(s.write(events))
+		}
+	}
+}
+
+// flush writes events, the latest ones, into the checkpoint file at once,
+// e.g. when the program is forced to quit. The file is not written again.
+func (s *checkpointSink) flush(events []Event) error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	s.flushed = true
+	return s.writeFile(events)
+}
+
+// write replaces the checkpoint file with one of events, unless flushed
+func (s *checkpointSink) write(events []Event) error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	if s.flushed {
+		return nil
+	}
+	return s.writeFile(events)
+}
+
+func (s *checkpointSink) writeFile(events []Event) error {
+	c := s.header
+	c.Records = EventsToRecordsWith(events, checkpointColumns())
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
+
+// removeCheckpoint removes the checkpoint file of s, if any, once the output
+// has been written. Must be called after Close.
+func removeCheckpoint(s *checkpointSink) {
+	if s == nil {
+		return
+	}
+	if err := os.Remove(s.path); err != nil {
+		fmt.Fprintln(os.Stderr, "# WARNING: could not remove the checkpoint:", err)
+	}
+}
+
+// readCheckpoint reads the checkpoint file path
+func readCheckpoint(path string) (checkpoint, error) {
+	var c checkpoint
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/var/state")
+	if dir, err := checkpointDir(); err != nil || dir != filepath.Join("/var/state", "stopwatch") {
+		t.Errorf("Expected $XDG_STATE_HOME/stopwatch, got %q, %v", dir, err)
+	}
+	// a relative path is ignored, as per the XDG base directory spec
+	t.Setenv("XDG_STATE_HOME", "state")
+	t.Setenv("HOME", "/home/me")
+	if dir, err := checkpointDir(); err != nil || dir != filepath.Join("/home/me", ".local", "state", "stopwatch") {
+		t.Errorf("Expected ~/.local/state/stopwatch, got %q, %v", dir, err)
+	}
+}
+
+func TestCheckpointSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "stopwatch")
+	v := 2.5
+	events := testEvents(time.Second, time.Second, time.Second)
+	events[1].Value, events[1].Attrs, events[1].Source = &v, map[string]string{"lane": "3"}, sourceStdin
+	events[2].Group, events[2].Phase, events[2].Zone = 1, "work", "Europe/Helsinki"
+
+	s, err := newCheckpointSink(dir, checkpoint{Session: "01SESSION", Name: "bench", Comment: "run 1"}, 0, os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the checkpoint exists from the start
+	c, err := readCheckpoint(filepath.Join(dir, "01SESSION.json"))
+	if err != nil || c.PID != os.Getpid() || c.Comment != "run 1" {
+		t.Errorf("Expected an empty checkpoint, got %+v, %v", c, err)
+	}
+	for _, evt := range events {
+		s.Send(evt)
+	}
+	s.Close(ComputeStats(events))
+	c, err = readCheckpoint(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Schema != schemaVersion || c.Session != "01SESSION" || c.Name != "bench" {
+		t.Errorf("Unexpected checkpoint %+v", c)
+	}
+	if got, err := c.events(); err != nil || !reflect.DeepEqual(got, events) {
+		t.Errorf("Expected the events back:\n%v\ngot:\n%v, %v", events, got, err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, ".*")); len(tmp) != 0 {
+		t.Errorf("Expected no temporary files left, got %v", tmp)
+	}
+
+	removeCheckpoint(s)
+	if _, err := os.Stat(s.path); !os.IsNotExist(err) {
+		t.Errorf("Expected the checkpoint to be removed, got %v", err)
+	}
+	removeCheckpoint(nil) // with -no-checkpoint
+
+	// with -keep-last, only the last events
+	s, err = newCheckpointSink(dir, checkpoint{Session: "01OTHER"}, 2, os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, evt := range events {
+		s.Send(evt)
+	}
+	s.Close(ComputeStats(events))
+	c, _ = readCheckpoint(s.path)
+	if got, err := c.events(); err != nil || !reflect.DeepEqual(got, events[2:]) {
+		t.Errorf("Expected the last two events, got %v, %v", got, err)
+	}
+}
+
+func TestCheckpointSinkError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "stopwatch")
+	var stderr bytes.Buffer
+	s, err := newCheckpointSink(dir, checkpoint{Session: "01SESSION"}, 0, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the directory is replaced by a file, so nothing can be written
+	os.RemoveAll(dir)
+	os.WriteFile(dir, nil, 0o600)
+	events := testEvents(time.Second, time.Second)
+	for _, evt := range events {
+		s.Send(evt)
+	}
+	s.Close(ComputeStats(events))
+	if !strings.Contains(stderr.String(), "# WARNING: checkpoint ") || strings.Count(stderr.String(), "WARNING") != 1 {
+		t.Errorf("Expected the failure to be reported once, got %q", stderr.String())
+	}
+	if _, err := newCheckpointSink(dir, checkpoint{Session: "01OTHER"}, 0, &stderr); err == nil {
+		t.Error("Expected error for a directory that can not be created")
+	}
+}
+
+func TestListCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	for i, session := range []string{"01AAA", "01AAB", "01B"} {
+		s, err := newCheckpointSink(dir, checkpoint{Session: session, Comment: "run " + session}, 0, os.Stderr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, evt := range testEvents(time.Second, time.Second)[:i+1] {
+			s.Send(evt)
+		}
+		s.Close(Stats{})
+		// as if written by a session that is gone
+		c, _ := readCheckpoint(s.path)
+		c.PID = 1 << 30
+		data, _ := json.Marshal(c)
+		os.WriteFile(s.path, data, 0o600)
+		when := time.Date(2022, 4, 8, 20, 0, 0, 0, time.Local).Add(-time.Duration(i) * time.Hour)
+		os.Chtimes(s.path, when, when)
+	}
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o600)
+
+	var stderr bytes.Buffer
+	files, err := listCheckpoints(dir, &stderr)
+	if err != nil || len(files) != 3 {
+		t.Fatalf("Expected 3 checkpoints, got %v, %v", files, err)
+	}
+	if !strings.Contains(stderr.String(), "broken.json") {
+		t.Errorf("Expected the broken file to be reported, got %q", stderr.String())
+	}
+	var out bytes.Buffer
+	if err := writeCheckpointList(&out, files); err != nil {
+		t.Fatal(err)
+	}
+	want := "session  updated              events  state     comment\n" +
+		"01B      2022-04-08 18:00:00  3       orphaned  run 01B\n" +
+		"01AAB    2022-04-08 19:00:00  2       orphaned  run 01AAB\n" +
+		"01AAA    2022-04-08 20:00:00  1       orphaned  run 01AAA\n"
+	if out.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	if f, err := findCheckpoint(files, "01b"); err != nil || f.Session != "01B" {
+		t.Errorf("Expected 01B, got %v, %v", f.Session, err)
+	}
+	for _, prefix := range []string{"01A", "01C"} {
+		if _, err := findCheckpoint(files, prefix); err == nil {
+			t.Errorf("%s: expected an error", prefix)
+		}
+	}
+}
@@ -0,0 +1,945 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// cliFlags are the flags of a session recorded by the command line, see
+// defineFlags
+type cliFlags struct {
+	fs               *flag.FlagSet
+	outFile          *string
+	outComment       *string
+	withEnv          *bool
+	outFlags         *outputFlags
+	summary          *bool
+	prompts          *bool
+	color            *bool
+	bellFlag         *bool
+	untilBar         *bool
+	ascii            *bool
+	startPaused      *bool
+	resumeOnTick     *bool
+	allowOutOfOrder  *bool
+	arm              *bool
+	debounce         *time.Duration
+	sampleRate       *string
+	sampleInterval   *time.Duration
+	minLap           *time.Duration
+	idleAfter        *time.Duration
+	heartbeat        *time.Duration
+	idleFlag         *bool
+	targetLap        *time.Duration
+	ghostFile        *string
+	ghostBy          *string
+	warnAt           durationList
+	budgets          budgetList
+	cycleSpec        *string
+	notify           *bool
+	reaction         *bool
+	reactionDelay    *string
+	trials           *int
+	reactionSeed     *int64
+	at               *string
+	atPastOK         *bool
+	after            *time.Duration
+	labelSpec        *string
+	labelsNoWrap     *bool
+	tickLabel        *string
+	sourceLabels     stringList
+	sourcePrefixes   stringList
+	useSyslog        *bool
+	syslogTag        *string
+	tcpAddr          *string
+	udpAddr          *string
+	strictSources    *bool
+	udpSender        *bool
+	overflowFlag     *string
+	watchFile        stringList
+	watchPID         stringList
+	exitOnPID        *bool
+	watchDirs        stringList
+	watchDirInitial  *bool
+	watchDirPath     *bool
+	watchDirInterval *time.Duration
+	control          *string
+	httpAddr         *string
+	httpControl      *bool
+	useJournal       *bool
+	noCheckpoint     *bool
+	slackWebhook     *string
+	slackLaps        *int
+	otelEndpoint     *string
+	dryRun           *bool
+	review           *bool
+	stream           *bool
+	lineTemplate     *string
+	splitDaily       *bool
+	labelsFile       *string
+	abbrevSpec       *string
+	abbrevFile       *string
+	labelsStrict     *bool
+	sanitize         *bool
+	normalize        *bool
+	keepLast         *int
+	seqStart         *int64
+	showVersion      *bool
+	quiet            *bool
+	excludeSuspended *bool
+	lineEdit         *bool
+	promptFlag       *string
+	until            *string
+}
+
+// defineFlags defines the flags of a recorded session in fs, with the
+// defaults of ui
+func defineFlags(fs *flag.FlagSet, ui uiCapabilities) *cliFlags {
+	f := &cliFlags{fs: fs}
+	f.outFile = fs.String("o", "", "Output file path (Optional, default: stdout)\n"+
+		"Values \"\" and \"-\" are interpreted as stdout, \"stderr\" as stderr and \"fd:N\"\n"+
+		"as the inherited file descriptor N, and an http:// or https:// URL is uploaded to\n"+
+		"at the end (see -upload-method). May be a template, e.g.\n"+
+		"'runs/{{.Date}}-{{.Time}}-{{.Name}}.csv' (see README)")
+	f.outComment = fs.String("c", "", "Comment for the output file. Optional")
+	f.withEnv = fs.Bool("with-env", false, "Record how the session was run in metadata lines of the output: the command line,\n"+
+		"working directory, host name, platform and version; values that look secret are left out")
+	f.outFlags = addOutputFlags(fs, "format")
+	f.summary = fs.Bool("summary", ui.Summary, "Print summary statistics to stderr at exit\n"+
+		"(default: true when stderr is a terminal)")
+	f.prompts = fs.Bool("prompts", ui.Prompts, "Show the prompts (default: true when stdin and stderr are terminals)")
+	f.color = fs.Bool("color", ui.Color, "Highlight the warnings with ANSI colors\n"+
+		"(default: true when stderr is a terminal, unless $NO_COLOR is set)")
+	f.bellFlag = fs.Bool("bell", ui.Bell, "Ring the terminal bell at -cycle phase changes and at the GO of -reaction\n"+
+		"(default: true when stderr is a terminal)")
+	f.untilBar = fs.Bool("until-bar", ui.LiveTimer, "Draw the -until progress bar with the prompt, instead of a line at each tenth\n"+
+		"(default: true when stderr is a terminal)")
+	f.ascii = fs.Bool("ascii", !unicodeLocale(), "Draw the summary sparkline with ASCII characters only\n"+
+		"(default: true unless $LC_ALL, $LC_CTYPE or $LANG specify UTF-8)")
+	f.startPaused = fs.Bool("start-paused", false, "Start the session paused; time is counted from the first 'resume'")
+	f.resumeOnTick = fs.Bool("resume-on-tick", false, "Resume a paused session on the next tick instead of refusing the tick")
+	f.allowOutOfOrder = fs.Bool("allow-out-of-order", false, "Let 'tick -<duration>' and 'shift' put a tick before or after other events")
+	f.arm = fs.Bool("arm", false, "Start the session at the first tick instead of at startup")
+	f.debounce = fs.Duration("debounce", 0, "Ignore ticks arriving within this time after the previous event, e.g. 200ms")
+	f.sampleRate = fs.String("sample", "", "Record only the first of every N ticks of the network, watched files and piped stdin, e.g. 1/10")
+	f.sampleInterval = fs.Duration("sample-interval", 0, "Record at most one tick per label in this time from the same inputs as -sample, e.g. 1s")
+	f.minLap = fs.Duration("min-lap", 0, "Warn about laps shorter than this, and flag them as 'short' in the output")
+	f.idleAfter = fs.Duration("idle-after", 0, "When a tick comes this long after the previous event, record an 'idle' event where the time\n"+
+		"was exceeded; the idle time is not counted in the lap")
+	f.heartbeat = fs.Duration("heartbeat", 0, "Record a 'heartbeat' event every this often, e.g. 1m, to tell an idle gap from one where\n"+
+		"the recorder was not running; not while paused, and not counted in the laps")
+	f.idleFlag = fs.Bool("idle-flag", false, "With -idle-after, flag the tick as 'idle' instead of recording an 'idle' event")
+	f.targetLap = fs.Duration("target-lap", 0, "Target lap time; each tick shows how far ahead or behind the target it is")
+	f.ghostFile = fs.String("ghost", "", "Reference recording, e.g. of the best run so far; each tick shows how far ahead\n"+
+		"or behind its lap it is")
+	f.ghostBy = fs.String("ghost-by", ghostBySeq, "What the laps of -ghost are matched by: '"+ghostBySeq+"', the lap closed by the event\n"+
+		"of the same seq, or '"+ghostByWhat+"', the same lap of the same label")
+	fs.Var(&f.warnAt, "warn-at", "Warn and record a 'warn:' event when the elapsed time reaches this;\n"+
+		"may be given more than once")
+	fs.Var(&f.budgets, "budget", "Time budget of a label, e.g. writing=30m: the laps it closes are totaled, a warning\n"+
+		"and a 'budget-exceeded:<label>' event are recorded when it runs out; may be given more than once")
+	f.cycleSpec = fs.String("cycle", "", "Repeat named phases from the start, e.g. work=25m,rest=5m; phase changes\n"+
+		"are recorded as 'phase:<name>' events")
+	f.notify = fs.Bool("notify", false, "Show a desktop notification at every -warn-at threshold, -cycle phase change and\n"+
+		"at the -until time")
+	f.reaction = fs.Bool("reaction", false, "Measure reaction times: show GO after a random delay, and record the time\n"+
+		"until the next tick as its value; ticks before GO are recorded as 'false-start'")
+	f.reactionDelay = fs.String("reaction-delay", "1s-4s", "Range of the random delay before each -reaction GO, or a fixed delay")
+	f.trials = fs.Int("n", 10, "Number of -reaction trials; 0 for no limit")
+	f.reactionSeed = fs.Int64("reaction-seed", 0, "Seed of the random -reaction delays, for a repeatable sequence (default: random)")
+	f.at = fs.String("at", "", "Wait until this time before starting: HH:MM[:SS] today, or an RFC 3339 timestamp")
+	f.atPastOK = fs.Bool("at-past-ok", false, "Start immediately if the -at time has already passed, instead of failing")
+	f.after = fs.Duration("after", 0, "Wait this long before starting, e.g. 10s")
+	f.labelSpec = fs.String("labels", "", "Label successive ticks from this comma separated list, e.g. warmup,run,cooldown")
+	f.labelsNoWrap = fs.Bool("labels-no-wrap", false, "Keep using the last of -labels instead of starting over")
+	f.tickLabel = fs.String("tick-label", labelTick, "Label of the plain ticks, once the -labels if any are used")
+	fs.Var(&f.sourceLabels, "source-label", "Label the plain ticks of an input, given as source=label, e.g. 'udp=sensor',\n"+
+		"instead of -labels and -tick-label. Can be repeated")
+	fs.Var(&f.sourcePrefixes, "source-prefix", "Prepend a prefix to the label of every tick of an input, given as source=prefix,\n"+
+		"e.g. 'tcp=remote/'. Can be repeated")
+	f.useSyslog = fs.Bool("syslog", false, "Also log every event into the system log")
+	f.syslogTag = fs.String("syslog-tag", "stopwatch", "Tag of the -syslog messages")
+	f.tcpAddr = fs.String("tcp", "", "Accept ticks over TCP at this address, one label per line, e.g. :7777")
+	f.udpAddr = fs.String("udp", "", "Record a tick for each UDP datagram received at this address, e.g. :7778")
+	f.strictSources = fs.Bool("strict-sources", false, "Stop the session, and exit with status 1, when a tick source such as -tcp or -udp\n"+
+		"fails, instead of warning and going on without it")
+	f.udpSender = fs.Bool("udp-sender", false, "Record the address of the sender of each -udp datagram in a 'sender' column")
+	f.overflowFlag = fs.String("overflow", overflowDropNewest, fmt.Sprintf("What to drop when ticks from outside the terminal, e.g. -udp, arrive faster than\n"+
+		"they can be recorded and %d are waiting: %s (the ticks arriving) or %s", inputQueueSize,
+		overflowDropNewest, overflowDropOldest))
+	fs.Var(&f.watchFile, "watch-file", "Record an event labeled 'file:<path>' whenever this file is modified (repeatable)")
+	fs.Var(&f.watchPID, "watch-pid", "Record an event labeled 'pid-exit:<pid>' when this process exits (repeatable)")
+	f.exitOnPID = fs.Bool("exit-on-pid", false, "Stop once every -watch-pid process has exited")
+	fs.Var(&f.watchDirs, "watch-dir", "Record an event labeled with the file name whenever a file appears in this directory (repeatable)")
+	f.watchDirInitial = fs.Bool("watch-dir-initial", false, "Also record the files already in the -watch-dir directories at startup")
+	f.watchDirPath = fs.Bool("watch-dir-path", false, "Record the path of each -watch-dir file in a 'path' column")
+	f.watchDirInterval = fs.Duration("watch-dir-interval", watchInterval, "How often the -watch-dir directories are checked")
+	f.control = fs.String("control", "", controlUsage)
+	f.httpAddr = fs.String("http", "", "Serve the events live over HTTP at this address, e.g. :8080 (see README)")
+	f.httpControl = fs.Bool("http-control", false, "With -http, also record ticks posted to /tick, and end the session on /stop")
+	f.useJournal = fs.Bool("journal", false, "Also log every event into the systemd journal, with structured fields")
+	f.noCheckpoint = fs.Bool("no-checkpoint", false, "Do not keep a crash-recovery checkpoint of the events under $XDG_STATE_HOME/stopwatch;\n"+
+		"see the 'recover' command")
+	f.slackWebhook = fs.String("slack-webhook", "", "Post a summary to this Slack incoming webhook URL after writing the output")
+	f.slackLaps = fs.Int("slack-laps", 0, "Include up to this many laps in the -slack-webhook message")
+	f.otelEndpoint = fs.String("otel-endpoint", "", "Export the session as an OpenTelemetry trace to this OTLP/HTTP endpoint after writing the output,\n"+
+		"e.g. localhost:4318 or https://collector/v1/traces")
+	f.dryRun = fs.Bool("dry-run", false, "Print the output to stderr instead of writing it, with the target file and format")
+	f.review = fs.Bool("review", false, "Review and edit the events at ctrl-d before they are written")
+	f.stream = fs.Bool("stream", false, "Write each event into the output as soon as it is recorded (csv or ndjson).\n"+
+		"A named pipe is written whenever it has a reader")
+	f.lineTemplate = fs.String("line-template", "", "With -stream, write each event as the line made by this Go template instead of CSV,\n"+
+		"e.g. '{{.Seq}} {{.Timestamp.Unix}} {{.What}}'")
+	f.splitDaily = fs.Bool("split-daily", false, "With -stream, write the events of each local calendar day into <base>.<YYYY-MM-DD>.<ext>")
+	f.labelsFile = fs.String("labels-file", "", "Label successive ticks from the lines of this file, once each")
+	f.abbrevSpec = fs.String("abbrev", "", "Record these labels for the keys typed alone at the prompt, e.g. 'c=compile,t=\"run tests\"'")
+	f.abbrevFile = fs.String("abbrev-file", "", "Read the -abbrev keys from this file, one key=label per line")
+	f.labelsStrict = fs.Bool("labels-strict", false, "Refuse ticks after the last step of -labels-file")
+	f.sanitize = fs.Bool("sanitize-labels", true, "Escape line breaks, remove control characters and cut overlong labels\n"+
+		"(labels typed on a terminal are only sanitized when given explicitly)")
+	f.normalize = fs.Bool("normalize", false, "Normalize labels into Unicode NFC, composing accents typed or pasted separately")
+	f.keepLast = fs.Int("keep-last", 0, "Keep only the last this many events, dropping older ones, for sessions left running for days")
+	f.seqStart = fs.Int64("seq-start", 0, "Number the events from this seq, e.g. to keep the numbers of several machines apart")
+	f.showVersion = fs.Bool("version", false, "Print the version and exit, like the version command")
+	f.quiet = fs.Bool("q", false, "Quiet: show no prompts, and no -until progress bar")
+	f.excludeSuspended = fs.Bool("exclude-suspended", false, "Do not count the time the process was suspended, e.g. by ctrl-z, in the laps")
+	f.lineEdit = fs.Bool("line-editor", ui.Editor, "Edit the lines typed, with the labels typed before on up and down,\n"+
+		"and completed by Tab (default: true when stdin and stderr are terminals)")
+	f.promptFlag = fs.String("prompt", defaultPrompt, "Go template of the prompt, e.g. '{{.Count}} laps, {{.Elapsed}} elapsed ({{.LastLabel}}) > '\n"+
+		"(see README for the fields)")
+	f.until = fs.String("until", "", "Stop the session at this time: HH:MM[:SS] today, or an RFC 3339 timestamp")
+	completeFiles(fs, "o", "labels-file", "abbrev-file", "watch-file")
+	completeDirs(fs, "watch-dir")
+	completeValues(fs, "control", func() []string { return []string{controlJSON} })
+	return f
+}
+
+// cliSession is a session recorded by the command line, as set up by its
+// flags, see newCLISession
+type cliSession struct {
+	flags        *cliFlags
+	ui           uiCapabilities
+	progressFile *os.File // of -progress-fd, see openProgressFD; nil if none
+
+	startAt  time.Time      // of -at or -after; zero starts at once
+	summary  bool           // -summary, off for -o stderr unless given
+	collect  collectOptions // the sinks are added by openSinks
+	output   OutputOptions
+	pids     []int
+	overflow string
+}
+
+// newCLISession validates the parsed flags, and returns the session they
+// set up. The error is a usage error, to exit with status 2.
+func newCLISession(flags *cliFlags, ui uiCapabilities, progressFile *os.File) (*cliSession, error) {
+	s := &cliSession{flags: flags, ui: ui, progressFile: progressFile, summary: *flags.summary}
+	for _, parse := range []func() error{s.parseSchedule, s.parseLabels, s.parseChecks, s.parseOutput, s.parseInputs} {
+		if err := parse(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseSchedule parses -at, -after and -until
+func (s *cliSession) parseSchedule() error {
+	f := s.flags
+	if *f.after < 0 {
+		return fmt.Errorf("-after must not be negative")
+	}
+	if *f.after > 0 && *f.at != "" {
+		return fmt.Errorf("-at and -after are mutually exclusive")
+	}
+	if *f.at != "" {
+		var err error
+		if s.startAt, err = parseAt(*f.at, time.Now()); err != nil {
+			return fmt.Errorf("invalid -at: %v", err)
+		}
+		if s.startAt.Before(time.Now()) && !*f.atPastOK {
+			return fmt.Errorf("-at time %s has already passed (use -at-past-ok to start anyway)",
+				s.startAt.Format(time.RFC3339))
+		}
+	}
+	if *f.until != "" {
+		stopAt, err := parseAt(*f.until, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid -until: %v", err)
+		}
+		// Round(0) drops the monotonic clock reading, see Session.expired
+		stopAt = stopAt.Round(0)
+		start := s.startAt
+		if *f.after > 0 {
+			start = time.Now().Add(*f.after)
+		}
+		if !stopAt.After(time.Now()) || (!start.IsZero() && !stopAt.After(start)) {
+			return fmt.Errorf("-until time %s has already passed or is before the start", stopAt.Format(time.RFC3339))
+		}
+		s.collect.Until = stopAt
+	}
+	return nil
+}
+
+// parseLabels parses the flags of how the ticks are labeled: -labels,
+// -labels-file, -tick-label, -source-label, -source-prefix and -abbrev
+func (s *cliSession) parseLabels() error {
+	f, c := s.flags, &s.collect
+	var err error
+	if c.Labels, err = ParseLabels(*f.labelSpec); err != nil {
+		return fmt.Errorf("invalid -labels: %v", err)
+	}
+	if isReserved(*f.tickLabel) || *f.tickLabel == "" {
+		return fmt.Errorf("invalid -tick-label %q: reserved or empty", *f.tickLabel)
+	}
+	inputs := s.inputs()
+	if c.SourceLabels, err = parseSourceMap("source-label", f.sourceLabels, inputs, true); err != nil {
+		return err
+	}
+	if c.SourcePrefixes, err = parseSourceMap("source-prefix", f.sourcePrefixes, inputs, false); err != nil {
+		return err
+	}
+	if *f.labelsNoWrap && len(c.Labels) == 0 {
+		return fmt.Errorf("-labels-no-wrap requires -labels")
+	}
+	if *f.labelsFile != "" {
+		if len(c.Labels) > 0 {
+			return fmt.Errorf("-labels and -labels-file are mutually exclusive")
+		}
+		if c.Labels, err = ReadLabelsFile(*f.labelsFile); err != nil {
+			return fmt.Errorf("invalid -labels-file: %v", err)
+		}
+	} else if *f.labelsStrict {
+		return fmt.Errorf("-labels-strict requires -labels-file")
+	}
+	if c.Abbrevs, err = ParseAbbrevs(*f.abbrevSpec); err != nil {
+		return fmt.Errorf("invalid -abbrev: %v", err)
+	}
+	if *f.abbrevFile != "" {
+		if len(c.Abbrevs) > 0 {
+			return fmt.Errorf("-abbrev and -abbrev-file are mutually exclusive")
+		}
+		if c.Abbrevs, err = ReadAbbrevsFile(*f.abbrevFile); err != nil {
+			return fmt.Errorf("invalid -abbrev-file: %v", err)
+		}
+	}
+	c.TickLabel, c.LabelsNoWrap, c.LabelSteps, c.LabelsStrict = *f.tickLabel, *f.labelsNoWrap, *f.labelsFile != "", *f.labelsStrict
+	return nil
+}
+
+// inputs returns the inputs of the session, as named in the source column
+func (s *cliSession) inputs() []string {
+	f := s.flags
+	inputs := []string{sourceStdin}
+	for _, input := range []struct {
+		source string
+		on     bool
+	}{
+		{sourceTCP, *f.tcpAddr != ""}, {sourceUDP, *f.udpAddr != ""},
+		{sourceWatch, len(f.watchFile) > 0 || len(f.watchDirs) > 0 || len(f.watchPID) > 0}, {sourceHTTP, *f.httpControl},
+	} {
+		if input.on {
+			inputs = append(inputs, input.source)
+		}
+	}
+	return inputs
+}
+
+// parseChecks parses the flags of what is checked as the session goes:
+// -ghost, -cycle, -notify, -min-lap, -idle-after, -target-lap, -reaction
+// and the like
+func (s *cliSession) parseChecks() error {
+	f, c := s.flags, &s.collect
+	var err error
+	if *f.ghostFile != "" {
+		if c.Ghost, err = LoadGhost(*f.ghostFile, *f.ghostBy); err != nil {
+			return fmt.Errorf("invalid -ghost: %v", err)
+		}
+	} else if flagWasSet(f.fs, "ghost-by") {
+		return fmt.Errorf("-ghost-by requires -ghost")
+	}
+	if c.Cycle, err = ParseCycle(*f.cycleSpec); err != nil {
+		return fmt.Errorf("invalid -cycle: %v", err)
+	}
+	if *f.notify {
+		if c.Notifier, err = newNotifier(runtime.GOOS); err != nil {
+			fmt.Fprintln(os.Stderr, "# WARNING: -notify ignored:", err)
+		}
+	}
+	if *f.debounce < 0 || *f.minLap < 0 || *f.targetLap < 0 || *f.idleAfter < 0 || *f.heartbeat < 0 {
+		return fmt.Errorf("-debounce, -min-lap, -target-lap, -idle-after and -heartbeat must not be negative")
+	}
+	if *f.idleFlag && *f.idleAfter == 0 {
+		return fmt.Errorf("-idle-flag requires -idle-after")
+	}
+	if *f.outFlags.withTarget && *f.targetLap == 0 {
+		return fmt.Errorf("-with-target-column requires -target-lap")
+	}
+	if *f.reaction {
+		min, max, err := parseDelayRange(*f.reactionDelay)
+		if err != nil {
+			return fmt.Errorf("invalid -reaction-delay: %v", err)
+		}
+		if *f.trials < 0 {
+			return fmt.Errorf("-n must not be negative")
+		}
+		if *f.arm {
+			return fmt.Errorf("-reaction and -arm are mutually exclusive")
+		}
+		seed := *f.reactionSeed
+		if !flagWasSet(f.fs, "reaction-seed") {
+			seed = time.Now().UnixNano()
+		}
+		c.Reaction = &reactionOptions{MinDelay: min, MaxDelay: max, Trials: *f.trials, Rand: rand.New(rand.NewSource(seed))}
+	}
+	if *f.arm && *f.startPaused {
+		return fmt.Errorf("-arm and -start-paused are mutually exclusive")
+	}
+	c.Debounce, c.MinLap, c.IdleAfter, c.IdleFlag, c.Heartbeat = *f.debounce, *f.minLap, *f.idleAfter, *f.idleFlag, *f.heartbeat
+	c.Target, c.WarnAt, c.Budgets = *f.targetLap, f.warnAt.sorted(), f.budgets
+	return nil
+}
+
+// parseOutput parses the flags of the output and of what is shown: -o,
+// the output flags, -stream and its companions, and -prompt
+func (s *cliSession) parseOutput() error {
+	f := s.flags
+	opts, err := f.outFlags.options()
+	if err != nil {
+		return err
+	}
+	if *f.withEnv {
+		opts.Meta = envSnapshot(os.Args, os.Environ())
+	}
+	opts.Meta = append(opts.Meta, f.budgets.meta(opts.Redact)...)
+	outFile, stream := *f.outFile, *f.stream
+	if (opts.RotateEvents > 0 || opts.RotateSize > 0 || opts.SplitByLabel || opts.Manifest) && (isStream(outFile) || isUpload(outFile)) {
+		return fmt.Errorf("-rotate-every, -rotate-size, -split-by-label and -manifest require an output file (-o),\n" +
+			"not a stream or a URL")
+	}
+	if err := checkOutputURL(outFile); err != nil {
+		return fmt.Errorf("invalid -o: %v", err)
+	}
+	if out, err := outputStream(outFile); err != nil {
+		return fmt.Errorf("invalid -o: %v", err)
+	} else if out != nil && out == s.progressFile {
+		// the output was asked for, not the progress stream
+		s.progressFile = nil
+	}
+	if stream && (opts.Passphrase != nil || opts.Checksum || opts.SignKey != nil || opts.StatsFooter ||
+		opts.Backup || opts.RotateEvents > 0 || opts.RotateSize > 0 || opts.SplitByLabel || opts.Manifest || *f.review || *f.dryRun) {
+		return fmt.Errorf("-stream can not be used with -encrypt, -checksum, -sign-key-file, -stats-footer,\n" +
+			"-backup, -rotate-every, -rotate-size, -split-by-label, -manifest, -review or -dry-run")
+	}
+	if stream && isUpload(outFile) {
+		return fmt.Errorf("-stream can not upload to a URL: the output is uploaded when the session ends")
+	}
+	if opts, err = withCompression(opts, outFile); err != nil {
+		return err
+	}
+	switch {
+	case stream && opts.Compress != "":
+		return fmt.Errorf("-stream can not compress the output")
+	case stream && len(opts.Computed) > 0:
+		return fmt.Errorf("-stream can not write -column: the columns are computed over the whole session")
+	case stream && opts.TSStyle == tsStyleOffsetSeconds:
+		return fmt.Errorf("-stream can not write -ts-style offset-seconds: the header is written before the start")
+	case stream && (opts.Head > 0 || opts.Tail > 0):
+		return fmt.Errorf("-stream writes every event, not only those of -head or -tail")
+	case stream && opts.Order == orderDesc:
+		return fmt.Errorf("-stream can not write -order desc: the newest event is not known until the session ends")
+	case *f.splitDaily && (!stream || isStream(outFile)):
+		return fmt.Errorf("-split-daily requires -stream and an output file (-o)")
+	}
+	opts.SplitDaily = *f.splitDaily
+	if *f.lineTemplate != "" {
+		if !stream || flagWasSet(f.fs, "format") {
+			return fmt.Errorf("-line-template requires -stream, and replaces -format")
+		}
+		if opts.LineTemplate, err = ParseLineTemplate(*f.lineTemplate); err != nil {
+			return fmt.Errorf("invalid -line-template: %v", err)
+		}
+	}
+	if stream && opts.Format != "csv" && opts.Format != "ndjson" && opts.LineTemplate == nil {
+		return fmt.Errorf("-stream supports the csv and ndjson formats, not %q", opts.Format)
+	}
+	if s.collect.Prompt, err = ParsePrompt(*f.promptFlag); err != nil {
+		return fmt.Errorf("invalid -prompt: %v", err)
+	}
+	if writesStderr(outFile) {
+		if !flagWasSet(f.fs, "summary") {
+			s.summary = false
+		}
+		fmt.Fprintln(os.Stderr, "# WARNING: the output goes to stderr (-o stderr): the prompts and, unless -summary\n"+
+			"# is given, the summary are not shown, so as not to mix with the output")
+	}
+	s.output = opts
+	return nil
+}
+
+// parseInputs parses the flags of the inputs: -sample, -watch-pid,
+// -control, -overflow, -http-control and the like
+func (s *cliSession) parseInputs() error {
+	f, c := s.flags, &s.collect
+	var err error
+	switch {
+	case *f.sampleRate != "" && *f.sampleInterval != 0:
+		return fmt.Errorf("-sample and -sample-interval can not be used together")
+	case *f.sampleRate != "":
+		if c.Sampler, err = ParseSampleRate(*f.sampleRate); err != nil {
+			return err
+		}
+	case *f.sampleInterval < 0:
+		return fmt.Errorf("-sample-interval must not be negative")
+	case *f.sampleInterval > 0:
+		c.Sampler = newPerInterval(*f.sampleInterval)
+	}
+	if *f.keepLast < 0 {
+		return fmt.Errorf("-keep-last must not be negative")
+	}
+	if *f.seqStart < 0 {
+		return fmt.Errorf("-seq-start must not be negative")
+	}
+	for _, arg := range f.watchPID {
+		pid, err := strconv.Atoi(arg)
+		if err != nil || pid <= 0 {
+			return fmt.Errorf("invalid -watch-pid %q, expected a process ID", arg)
+		}
+		s.pids = append(s.pids, pid)
+	}
+	if *f.control != "" && *f.control != controlJSON {
+		return fmt.Errorf("unknown -control %q (available: %s)", *f.control, controlJSON)
+	}
+	if *f.control == controlJSON && isStdout(*f.outFile) {
+		return fmt.Errorf("-control json replies on stdout, the output must go to a file (-o)")
+	}
+	if *f.watchDirInterval <= 0 {
+		return fmt.Errorf("-watch-dir-interval must be positive")
+	}
+	if s.overflow, err = parseOverflow(*f.overflowFlag); err != nil {
+		return err
+	}
+	if *f.exitOnPID && len(s.pids) == 0 {
+		return fmt.Errorf("-exit-on-pid requires -watch-pid")
+	}
+	if *f.httpControl && *f.httpAddr == "" {
+		return fmt.Errorf("-http-control requires -http")
+	}
+	return nil
+}
+
+// options completes the collect options with the rest of the flags and
+// the sinks
+func (s *cliSession) options(sinks []eventSink) collectOptions {
+	f, ui, c := s.flags, s.ui, s.collect
+	c.WithID = *f.outFlags.withID
+	c.StartPaused, c.ResumeOnTick, c.AllowOutOfOrder, c.Arm = *f.startPaused, *f.resumeOnTick, *f.allowOutOfOrder, *f.arm
+	c.SampleStdin = ui.pipeMode() && *f.control == ""
+	c.SanitizeLabels = *f.sanitize
+	c.SanitizeStdin = *f.sanitize && (flagWasSet(f.fs, "sanitize-labels") || ui.pipeMode())
+	c.Normalize, c.Color, c.Bell = *f.normalize, *f.color, *f.bellFlag
+	c.Name, c.Sinks = s.output.Name, sinks
+	c.Control, c.KeepLast, c.SeqStart = *f.control == controlJSON, *f.keepLast, *f.seqStart
+	c.NoPrompt = s.quietPrompt()
+	c.UntilBar = !c.Until.IsZero()
+	if *f.untilBar {
+		c.UntilBarWidth = ui.Width
+	}
+	c.Save = snapshotSaver(*f.outFile, s.output)
+	return c
+}
+
+// quietPrompt reports whether the prompts are not shown: with -q, without
+// -prompts, or as the output goes to stderr
+func (s *cliSession) quietPrompt() bool {
+	return *s.flags.quiet || !*s.flags.prompts || writesStderr(*s.flags.outFile)
+}
+
+// sessionSinks are the sinks of a session recorded by the command line,
+// see openSinks
+type sessionSinks struct {
+	all      []eventSink
+	memory   *memorySink // the events dumped by a forced quit
+	check    *checkpointSink
+	stream   *streamOutput
+	progress *progressStream
+}
+
+// record records the session, writes the output, and returns the exit
+// status
+func (s *cliSession) record() int {
+	f, opts := s.flags, &s.output
+	// capture signals and handle cancellation via Context. The signals stay
+	// captured until exit, so that a single one can not cut the output
+	// short while it is written; a second one forces the exit.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	stop := newShutdown(ctx, cancel, os.Stderr)
+	go stop.run(signals)
+
+	if *f.after > 0 {
+		s.startAt = time.Now().Add(*f.after)
+	}
+	if isPathTemplate(*f.outFile) {
+		start := s.startAt
+		if start.IsZero() {
+			start = time.Now()
+		}
+		var err error
+		if *f.outFile, err = expandOutputPath(*f.outFile, start, opts.Name); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR: invalid -o template:", err)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "# Output: %s\n", *f.outFile)
+	}
+	// an output that can not be written fails now, not after the session
+	if !*f.dryRun {
+		if err := probeOutput(*f.outFile, opts.MkDirs); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR: output can not be written:", err)
+			return 1
+		}
+	}
+	// Cancelling the wait leaves no partial output behind
+	if !s.startAt.IsZero() && !waitUntil(ctx, s.startAt, os.Stderr) {
+		fmt.Fprintln(os.Stderr, "# Cancelled before the start, nothing written")
+		return 1
+	}
+
+	remote := newRemoteInput()
+	remote.overflow = s.overflow
+	if err := s.startSources(remote, cancel); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	// The sinks identify the session with an ID of its own, since the
+	// events of several sessions may end up in the same log
+	sessionID, err := NewULID(time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: could not generate session ID:", err)
+		return 1
+	}
+	opts.Session = sessionID
+	sinks, err := s.openSinks(remote, cancel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	stop.onForce(func() {
+		events := sinks.memory.recorded()
+		if sinks.check != nil {
+			err := sinks.check.flush(events)
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "# The events are kept in a checkpoint, see: %s recover %s\n", os.Args[0], sessionID)
+				return
+			}
+			fmt.Fprintln(os.Stderr, "# WARNING: could not write the checkpoint:", err)
+		}
+		DumpEmergency(os.Stderr, events, OutputOptions{Comment: *f.outComment, Columns: opts.Columns})
+	})
+
+	collectOpts := s.options(sinks.all)
+	runOpts := []Option{WithInput(os.Stdin), WithComment(*f.outComment), WithMessages(os.Stderr), withRemote(remote)}
+	// the prompts redraw the line being typed, so both go to the terminal
+	var tty *cbreakMode
+	if *f.lineEdit && !collectOpts.NoPrompt && *f.control == "" {
+		tty = &cbreakMode{f: os.Stdin}
+		if err := tty.enable(); err != nil {
+			if flagWasSet(f.fs, "line-editor") {
+				fmt.Fprintln(os.Stderr, "# WARNING: -line-editor ignored:", err)
+			}
+			tty = nil
+		} else {
+			editor := newLineEditor(os.Stdin, os.Stderr, collectOpts.Labels)
+			collectOpts.Typed = editor.Pending
+			runOpts = append(runOpts, withLineEditor(editor))
+		}
+	}
+	watchSuspend(remote, *f.excludeSuspended, tty)
+	if sinks.progress != nil {
+		sinks.progress.start(time.Now())
+	}
+	// ctrl-d (or closed stdin) ends the session like a signal
+	sess, stdinEOF, err := run(ctx, append(runOpts, withCollectOptions(collectOpts))...)
+	tty.disable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	cancel() // from now on, a signal is late
+	events := sess.Events
+
+	// Only offered after ctrl-d: a signal must never hold the data hostage,
+	// and the stdin goroutine is done reading
+	if stdinEOF && *f.review && s.ui.StdinTTY && s.ui.StderrTTY {
+		// a signal ends the review, as if an empty line was typed
+		events = reviewEvents(bufio.NewReader(interruptible(os.Stdin, stop.late)), os.Stderr, events)
+	}
+	opts.Comment = droppedComment(sess.Comment, sess.Dropped)
+	overflowed := remote.overflowed()
+	opts.Meta = append(opts.Meta, overflowMeta(overflowed)...)
+
+	// If a signal ended the session, the reader of stdin may still be
+	// blocked in a read; closing stdin lets it return. Nothing it reads is
+	// recorded any more, as run has returned.
+	os.Stdin.Close()
+
+	if s.summary {
+		s.writeSummary(sess, events, overflowed)
+	}
+	for _, sink := range sinks.all {
+		sink.Close(ComputeStats(events))
+	}
+	if *f.dryRun {
+		return s.dryRun(events, sinks, stop)
+	}
+	if status := s.writeOutput(events, sinks, sessionID, stop); status != 0 {
+		return status
+	}
+
+	// The data is safe by now, a failure here does not change the exit status
+	if *f.slackWebhook != "" {
+		payload, err := SlackPayload(events, opts.Comment, *f.slackLaps)
+		if err == nil {
+			err = postSlack(*f.slackWebhook, payload)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "# WARNING: could not post to Slack:", err)
+		}
+	}
+	if *f.otelEndpoint != "" {
+		if err := exportTrace(*f.otelEndpoint, events, sessionID, opts.Name, opts.Comment); err != nil {
+			fmt.Fprintln(os.Stderr, "# WARNING: could not export the trace:", err)
+		}
+	}
+	if *f.strictSources && len(remote.failures()) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// startSources starts the listeners and the watchers of the flags, which
+// record into remote; cancel ends the session
+func (s *cliSession) startSources(remote *remoteInput, cancel context.CancelFunc) error {
+	f := s.flags
+	if *f.strictSources {
+		remote.onFail = func(name string, err error) {
+			fmt.Fprintf(os.Stderr, "\nERROR: %s stopped: %v; stopping the session (-strict-sources)\n", name, err)
+			cancel()
+		}
+	}
+	if *f.tcpAddr != "" {
+		l, err := listenLines(remote, *f.tcpAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen for ticks: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "# Accepting ticks at tcp://%s\n", l.Addr())
+	}
+	if *f.udpAddr != "" {
+		l, err := listenDatagrams(remote, *f.udpAddr, *f.udpSender)
+		if err != nil {
+			return fmt.Errorf("could not listen for ticks: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "# Accepting ticks at udp://%s\n", l.Addr())
+	}
+	if len(f.watchFile) > 0 {
+		watchFiles(remote, f.watchFile, watchInterval, os.Stderr)
+	}
+	for _, dir := range f.watchDirs {
+		if _, err := watchDir(remote, dir, *f.watchDirInitial, *f.watchDirPath, *f.watchDirInterval, os.Stderr); err != nil {
+			return fmt.Errorf("could not watch directory: %v", err)
+		}
+	}
+	if len(s.pids) > 0 {
+		var onExit func()
+		if *f.exitOnPID {
+			onExit = func() {
+				fmt.Fprintln(os.Stderr, "\n# Every watched process has exited, stopping")
+				cancel()
+			}
+		}
+		watchPIDs(remote, s.pids, watchPIDInterval, onExit, os.Stderr)
+	}
+	return nil
+}
+
+// openSinks opens the sinks of the flags: the events to dump if the
+// program is forced to quit, -syslog, -http, the checkpoint, -stream, the
+// progress stream and -journal. The -http-control requests record into
+// remote, and /stop calls cancel.
+func (s *cliSession) openSinks(remote *remoteInput, cancel context.CancelFunc) (sessionSinks, error) {
+	f, opts := s.flags, s.output
+	// first, so that no other sink can hold up the events to dump
+	sinks := sessionSinks{memory: &memorySink{keepLast: *f.keepLast}}
+	sinks.all = []eventSink{sinks.memory}
+	if *f.useSyslog {
+		sink, err := newSyslogSink("", "", *f.syslogTag, opts.Session, os.Stderr)
+		if err != nil {
+			return sinks, fmt.Errorf("could not connect to syslog: %v", err)
+		}
+		sinks.all = append(sinks.all, sink)
+	}
+	if *f.httpAddr != "" {
+		ln, err := net.Listen("tcp", *f.httpAddr)
+		if err != nil {
+			return sinks, fmt.Errorf("could not start the HTTP server: %v", err)
+		}
+		live := newLiveServer(opts.Columns, *f.keepLast)
+		if *f.httpControl {
+			live.enableControl(remote, func() {
+				fmt.Fprintln(os.Stderr, "\n# Stopped over HTTP")
+				cancel()
+			})
+		}
+		go live.serve(ln)
+		fmt.Fprintf(os.Stderr, "# Live events at ws://%[1]s/ws and http://%[1]s/sse\n", ln.Addr())
+		if *f.httpControl {
+			fmt.Fprintf(os.Stderr, "# Accepting ticks at http://%s/tick\n", ln.Addr())
+		}
+		sinks.all = append(sinks.all, live)
+	}
+	if !*f.noCheckpoint {
+		dir, err := checkpointDir()
+		if err == nil {
+			sinks.check, err = newCheckpointSink(dir, checkpoint{Session: opts.Session, Name: opts.Name, Comment: *f.outComment,
+				Meta: opts.Meta, Output: *f.outFile}, *f.keepLast, os.Stderr)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "# WARNING: no crash-recovery checkpoint:", err)
+		} else {
+			sinks.all = append(sinks.all, sinks.check)
+		}
+	}
+	if *f.stream {
+		streamOpts := opts
+		streamOpts.Comment = *f.outComment
+		var err error
+		if sinks.stream, err = newStreamOutput(*f.outFile, streamOpts, os.Stderr); err != nil {
+			return sinks, fmt.Errorf("could not open the output: %v", err)
+		}
+		sinks.all = append(sinks.all, sinks.stream)
+	}
+	if s.progressFile != nil {
+		sinks.progress = newProgressStream(s.progressFile, opts.Session, opts.Columns)
+		sinks.all = append(sinks.all, sinks.progress)
+	}
+	if *f.useJournal {
+		// unlike -syslog, a missing journal is not an error: the same
+		// command line may be used on systems with and without systemd
+		if sink, err := newJournalSink(journalSocket, opts.Session, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, "# systemd journal not available, -journal ignored:", err)
+		} else {
+			sinks.all = append(sinks.all, sink)
+		}
+	}
+	return sinks, nil
+}
+
+// writeSummary writes the summary statistics of -summary into stderr
+func (s *cliSession) writeSummary(sess *Session, events []Event, overflowed map[string]int) {
+	f, c := s.flags, s.collect
+	spark := SparkOptions{Width: s.ui.Width, ASCII: *f.ascii}
+	stats := ComputeStats(events)
+	WriteSummary(os.Stderr, stats, spark)
+	if c.Target > 0 {
+		WriteTargetSummary(os.Stderr, stats, c.Target)
+	}
+	if c.Ghost != nil {
+		WriteGhostSummary(os.Stderr, events, c.Ghost)
+	}
+	if sess.Dropped > 0 {
+		fmt.Fprintf(os.Stderr, "# %d earlier events dropped by -keep-last; the statistics cover the last %d\n",
+			sess.Dropped, len(sess.Events))
+	}
+	WriteOverflowSummary(os.Stderr, overflowed, s.overflow)
+	WriteTimerSummary(os.Stderr, NamedTimers(events))
+	if *f.reaction {
+		WriteReactionSummary(os.Stderr, events)
+	}
+	if len(c.Labels) > 0 {
+		WriteLabelSummary(os.Stderr, LapsByLabel(events))
+	}
+	WriteBudgetSummary(os.Stderr, events, c.Budgets)
+}
+
+// dryRun prints the output of -dry-run into stderr instead of writing
+// it, and returns the exit status
+func (s *cliSession) dryRun(events []Event, sinks sessionSinks, stop *shutdown) int {
+	err := DryRunEvents(os.Stderr, *s.flags.outFile, events, s.output)
+	if sinks.progress != nil {
+		sinks.progress.end("", err)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: output could not be written:", err)
+		return 1
+	}
+	stop.onForce(nil)
+	removeCheckpoint(sinks.check)
+	return 0
+}
+
+// writeOutput writes the events into the output, and returns the exit
+// status: 1 if the output could not be written, after trying to save the
+// events elsewhere
+func (s *cliSession) writeOutput(events []Event, sinks sessionSinks, sessionID string, stop *shutdown) int {
+	outFile, opts, progress := *s.flags.outFile, s.output, sinks.progress
+	outPath := outFile
+	if isStdout(outPath) {
+		outPath = "-"
+	}
+
+	// Write events into file; either stdout or. With -stream, they were
+	// written already, unless the pipe had no reader.
+	var err error
+	if sinks.stream != nil {
+		err = sinks.stream.failed()
+	} else {
+		err = DumpEvents(outFile, events, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem writing output:", err)
+		DumpEmergency(os.Stderr, events, opts)
+		if path, err := writeFallback(outFile, events, opts); err != nil {
+			fmt.Fprintln(os.Stderr, "# WARNING: could not write a copy into a temporary file either:", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "# A copy of the output was written into %s\n", path)
+		}
+		if sinks.check != nil {
+			fmt.Fprintf(os.Stderr, "# The events are kept in a checkpoint, see: %s recover %s\n", os.Args[0], sessionID)
+		}
+		if progress != nil {
+			progress.end(outPath, err)
+		}
+		return 1
+	}
+	if progress != nil {
+		progress.written(outPath)
+		progress.end(outPath, nil)
+	}
+	stop.onForce(nil)
+	removeCheckpoint(sinks.check)
+	return 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseCLI parses args as the flags of a session recorded by the command
+// line
+func parseCLI(t *testing.T, args ...string) (*cliSession, error) {
+	fs := flag.NewFlagSet("stopwatch-go", flag.ContinueOnError)
+	flags := defineFlags(fs, uiCapabilities{})
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return newCLISession(flags, uiCapabilities{}, nil)
+}
+
+func TestParseSchedule(t *testing.T) {
+	inHour := time.Now().Add(time.Hour).Format(time.RFC3339)
+	for _, tc := range []struct {
+		args []string
+		err  string // a part of the error, "" for none
+	}{
+		{[]string{"-after", "10s"}, ""},
+		{[]string{"-after", "10s", "-until", inHour}, ""},
+		{[]string{"-after", "-1s"}, "-after must not be negative"},
+		{[]string{"-after", "10s", "-at", "14:00"}, "mutually exclusive"},
+		{[]string{"-after", "2h", "-until", inHour}, "before the start"},
+		{[]string{"-until", inHour}, ""},
+		{[]string{"-until", time.Now().Add(-time.Hour).Format(time.RFC3339)}, "has already passed"},
+		{[]string{"-until", "tomorrow"}, "invalid -until"},
+	} {
+		_, err := parseCLI(t, tc.args...)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tc.args, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q: expected error %q, got %v", tc.args, tc.err, err)
+		}
+	}
+}
+
+func TestRecordAfter(t *testing.T) {
+	// the session ends at once with stdin, but starts only after -after
+	path := filepath.Join(t.TempDir(), "after.csv")
+	s, err := parseCLI(t, "-after", "200ms", "-o", path, "-no-checkpoint", "-summary=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, stderr := os.Stdin, os.Stderr
+	defer func() { os.Stdin, os.Stderr = stdin, stderr }()
+	if os.Stdin, err = os.Open(os.DevNull); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Stdin.Close()
+	if os.Stderr, err = os.Create(filepath.Join(t.TempDir(), "stderr")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Stderr.Close()
+
+	begin := time.Now()
+	if status := s.record(); status != 0 {
+		t.Fatalf("Expected status 0, got %d", status)
+	}
+	events, _, err := loadCSV(path, ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || events[0].What != labelEnter {
+		t.Fatalf("Expected enter first, got %v", events)
+	}
+	if late := events[0].Timestamp.Sub(begin); late < 200*time.Millisecond {
+		t.Errorf("Expected enter at least 200ms after the start, got %v", late)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// serverEnv is the environment variable giving the default of -server for
+// the client subcommands
+const serverEnv = "STOPWATCH_SERVER"
+
+const clientTimeout = 10 * time.Second // for a request of the client subcommands
+
+// Exit statuses of the client subcommands, besides 2 for invalid flags
+const (
+	exitUnreachable = 3 // the server could not be reached, or its reply read
+	exitRefused     = 4 // the server replied with an error, e.g. 422 for a reserved label
+)
+
+// clientFlags adds the -server flag of the subcommands talking to a session
+// recorded with -http
+func clientFlags(fs *flag.FlagSet) *string {
+	return fs.String("server", os.Getenv(serverEnv), "Address of the session recorded with -http, e.g. http://host:8080\n"+
+		"(default: $"+serverEnv+")")
+}
+
+// runTick records a tick into a session recorded with -http-control
+func runTick(args []string) int {
+	fs := newFlagSet("tick", "")
+	server := clientFlags(fs)
+	what := fs.String("what", "", "Label of the tick, with an optional value and attributes as typed at the prompt")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	var evt struct {
+		Seq int64     `json:"seq"`
+		TS  time.Time `json:"ts"`
+	}
+	if status := callServer(os.Stderr, *server, http.MethodPost, "/tick", url.Values{"what": {*what}}, &evt); status != 0 {
+		return status
+	}
+	fmt.Println(evt.Seq, evt.TS.Format(time.RFC3339Nano))
+	return 0
+}
+
+// runStatus prints the summary of a session recorded with -http
+func runStatus(args []string) int {
+	fs := newFlagSet("status", "")
+	server := clientFlags(fs)
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	var status liveStatus
+	if code := callServer(os.Stderr, *server, http.MethodGet, "/status", nil, &status); code != 0 {
+		return code
+	}
+	state := "recording"
+	if status.Ended {
+		state = "ended"
+	}
+	fmt.Printf("Session %s, elapsed: %s\n", state, formatDuration(secondsDuration(status.Elapsed)))
+	fmt.Printf("Events: %d, laps: %d\n", status.Events, status.Laps)
+	if status.LastLap != nil {
+		fmt.Printf("Last lap: %s, avg: %s\n", formatDuration(secondsDuration(*status.LastLap)), formatDuration(secondsDuration(*status.Mean)))
+	}
+	if len(status.Last) > 0 {
+		fmt.Printf("Last event: %s\n", status.Last)
+	}
+	return 0
+}
+
+// runStop ends a session recorded with -http-control, or by the daemon
+// subcommand
+func runStop(args []string) int {
+	fs := newFlagSet("stop", "")
+	server := clientFlags(fs)
+	name := fs.String("name", "", "End the daemon of this name instead, see the daemon subcommand")
+	outFile := fs.String("o", "", "With -name, write the events into this file instead of the -o of the daemon\n"+
+		"(\"-\" is stdout)")
+	completeFiles(fs, "o")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if *name != "" {
+		return stopDaemon(*name, *outFile)
+	}
+	if *outFile != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -o requires -name")
+		return 2
+	}
+	if status := callServer(os.Stderr, *server, http.MethodPost, "/stop", nil, nil); status != 0 {
+		return status
+	}
+	fmt.Println("Stopping")
+	return 0
+}
+
+// callServer sends a request for path to server, with form as the body,
+// and decodes the JSON reply into reply, unless nil. Returns the exit
+// status, the error written to errs.
+func callServer(errs io.Writer, server, method, path string, form url.Values, reply interface{}) int {
+	if server == "" {
+		fmt.Fprintf(errs, "ERROR: no server given with -server or $%s\n", serverEnv)
+		return 2
+	}
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(server, "/")+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		fmt.Fprintln(errs, "ERROR: invalid -server:", err)
+		return 2
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	client := http.Client{Timeout: clientTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(errs, "ERROR: could not reach the session:", err)
+		return exitUnreachable
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		fmt.Fprintln(errs, "ERROR: could not read the reply:", err)
+		return exitUnreachable
+	}
+	if resp.StatusCode/100 != 2 {
+		fmt.Fprintf(errs, "ERROR: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return exitRefused
+	}
+	if reply != nil {
+		if err := json.Unmarshal(body, reply); err != nil {
+			fmt.Fprintln(errs, "ERROR: invalid reply:", err)
+			return exitUnreachable
+		}
+	}
+	return 0
+}
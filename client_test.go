@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	live := newLiveServer(nil, 0)
+	remote := newRemoteInput()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	live.enableControl(remote, cancel)
+	srv := httptest.NewServer(live.handler())
+	defer srv.Close()
+	done := make(chan []Event)
+	go func() {
+		events, _ := Run(ctx, withRemote(remote), WithEventFunc(live.Send))
+		live.Close(ComputeStats(events))
+		done <- events
+	}()
+
+	var evt struct {
+		Seq int    `json:"seq"`
+		TS  string `json:"ts"`
+	}
+	if status := callServer(io.Discard, srv.URL, http.MethodPost, "/tick", url.Values{"what": {"3 build-done"}}, &evt); status != 0 || evt.Seq != 1 {
+		t.Fatalf("Expected event 1, got %+v, status %d", evt, status)
+	}
+	if status := callServer(io.Discard, srv.URL, http.MethodPost, "/tick", url.Values{"what": {labelEnter}}, nil); status != exitRefused {
+		t.Errorf("Expected a reserved label to be refused, got status %d", status)
+	}
+	var status liveStatus
+	if code := callServer(io.Discard, strings.TrimPrefix(srv.URL, "http://"), http.MethodGet, "/status", nil, &status); code != 0 ||
+		status.Events != 2 || status.Laps != 1 || status.Ended || !strings.Contains(string(status.Last), `"what":"build-done"`) {
+		t.Errorf("Unexpected status %+v, %s, status %d", status, status.Last, code)
+	}
+	if status := callServer(io.Discard, srv.URL, http.MethodPost, "/stop", nil, nil); status != 0 {
+		t.Errorf("Expected the session to be stopped, got status %d", status)
+	}
+	events := <-done
+	if len(events) != 3 || events[1].What != "build-done" || *events[1].Value != 3 || events[1].Source != sourceHTTP {
+		t.Errorf("Unexpected events %+v", events)
+	}
+	if status := callServer(io.Discard, srv.URL, http.MethodPost, "/tick", nil, nil); status != exitRefused {
+		t.Errorf("Expected a tick after the end to be refused, got status %d", status)
+	}
+	if status := callServer(io.Discard, srv.URL, http.MethodGet, "/tick", nil, nil); status != exitRefused {
+		t.Errorf("Expected GET /tick to be refused, got status %d", status)
+	}
+
+	srv.Close()
+	if status := callServer(io.Discard, srv.URL, http.MethodGet, "/status", nil, nil); status != exitUnreachable {
+		t.Errorf("Expected an unreachable server, got status %d", status)
+	}
+	if status := callServer(io.Discard, "", http.MethodGet, "/status", nil, nil); status != 2 {
+		t.Errorf("Expected a missing server to be a usage error, got status %d", status)
+	}
+}
+
+func TestLiveServerNoControl(t *testing.T) {
+	srv := httptest.NewServer(newLiveServer(nil, 0).handler())
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/stop", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 Forbidden without -http-control, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// structField is a field of a struct with a "csv" tag: "name" or
+// "name,optional"
+type structField struct {
+	name     string
+	optional bool         // not included by ColumnNames and RowOf
+	index    []int        // path to the field, through embedded structs
+	typ      reflect.Type // type of the field, pointers dereferenced
+	pointer  bool         // the field is a pointer, nil giving an empty cell
+}
+
+// structFields lists the csv tagged fields of the struct type t in field
+// order. The fields of embedded structs without a tag are included in
+// place of the embedded struct.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft := f.Type
+		pointer := ft.Kind() == reflect.Ptr
+		if pointer {
+			ft = ft.Elem()
+		}
+		tag, tagged := f.Tag.Lookup("csv")
+		if f.Anonymous && !tagged && ft.Kind() == reflect.Struct {
+			for _, sf := range structFields(ft) {
+				sf.index = append([]int{i}, sf.index...)
+				fields = append(fields, sf)
+			}
+			continue
+		}
+		if !tagged || tag == "-" || !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fields = append(fields, structField{name: name, optional: opts == "optional", index: []int{i}, typ: ft, pointer: pointer})
+	}
+	return fields
+}
+
+// ColumnNames returns the CSV header of the struct type T: the names in
+// the "csv" tags of its fields, in field order, except the ones tagged
+// "optional". Embedded structs are flattened. GetEventColumnNames is
+// ColumnNames[Event].
+func ColumnNames[T any]() []string {
+	var names []string
+	for _, f := range structFields(reflect.TypeOf((*T)(nil)).Elem()) {
+		if !f.optional {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// RowOf returns the CSV record of v, with the columns of ColumnNames[T].
+// Pointers are dereferenced, nil ones giving empty cells, and timestamps
+// are formatted as RFC 3339. Fields holding any other struct are an error.
+func RowOf[T any](v T) ([]string, error) {
+	rv := reflect.ValueOf(&v).Elem()
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", rv.Type())
+	}
+	var row []string
+	for _, f := range structFields(rv.Type()) {
+		if f.optional {
+			continue
+		}
+		cell, err := formatField(rv, f.index)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", f.name, err)
+		}
+		row = append(row, cell)
+	}
+	return row, nil
+}
+
+// formatField formats the field of v at index as a CSV cell
+func formatField(v reflect.Value, index []int) (string, error) {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return "", nil
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return formatValue(v.Float()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
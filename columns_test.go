@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testBase struct {
+	ID   int    `csv:"id"`
+	Note string `csv:"note,optional"`
+}
+
+type testRun struct {
+	testBase
+	*testExtra
+	At      time.Time `csv:"at"`
+	Runner  string    `csv:"runner"`
+	Speed   *float64  `csv:"speed"`
+	OK      bool      `csv:"ok"`
+	Ignored string
+	hidden  string `csv:"hidden"`
+}
+
+type testExtra struct {
+	Lane uint `csv:"lane"`
+}
+
+func TestColumnNames(t *testing.T) {
+	want := []string{"id", "lane", "at", "runner", "speed", "ok"}
+	if got := ColumnNames[testRun](); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := ColumnNames[Event](); !reflect.DeepEqual(got, []string{"seq", "ts", "what"}) {
+		t.Errorf("Expected the default Event columns, got %q", got)
+	}
+}
+
+func TestRowOf(t *testing.T) {
+	speed := 4.25
+	run := testRun{testBase: testBase{ID: 7}, At: time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC),
+		Runner: "a, b", Speed: &speed, OK: true, hidden: "x"}
+	row, err := RowOf(run)
+	if want := []string{"7", "", "2022-04-08T20:00:00Z", "a, b", "4.25", "true"}; err != nil || !reflect.DeepEqual(row, want) {
+		t.Errorf("Expected %q, got %q (%v)", want, row, err)
+	}
+	run.testExtra, run.Speed = &testExtra{Lane: 3}, nil
+	if row, _ := RowOf(&run); row[1] != "3" || row[4] != "" {
+		t.Errorf("Expected lane 3 and no speed, got %q", row)
+	}
+
+	for _, evt := range testEvents(time.Second) {
+		if row, err := RowOf(evt); err != nil || !reflect.DeepEqual(row, evt.Row()) {
+			t.Errorf("Expected %q, got %q (%v)", evt.Row(), row, err)
+		}
+	}
+
+	type nested struct {
+		Run testExtra `csv:"run"`
+	}
+	if _, err := RowOf(nested{}); err == nil || !strings.Contains(err.Error(), `column "run"`) {
+		t.Errorf("Expected an error for a nested struct, got %v", err)
+	}
+	if _, err := RowOf(42); err == nil {
+		t.Error("Expected an error for a non-struct")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// subcommand is a mode of operation other than recording a new session
+type subcommand struct {
+	synopsis string                  // one line description shown in usage
+	run      func(args []string) int // returns the exit status; nil if run by main itself
+}
+
+// subcommands maps the first command line argument to a subcommand.
+// Without a subcommand, the program records a new session.
+var subcommands map[string]subcommand
+
+func init() {
+	subcommands = map[string]subcommand{
+		"cat":        {"Print a recorded CSV file as an aligned table with the lap durations", runCat},
+		"completion": {"Print a shell completion script for bash, zsh or fish", nil},
+		"convert":    {"Convert a recorded CSV file into another output format", runConvert},
+		"daemon":     {"Record a session in the background, with the laps sent by the lap subcommand", runDaemon},
+		"decrypt":    {"Decrypt a file written with -encrypt", runDecrypt},
+		"follow":     {"Print the laps of a CSV file as they are recorded into it", runFollow},
+		"import":     {"Convert the time entries exported from another program, e.g. Toggl, into a CSV file", runImport},
+		"lap":        {"Record a tick into a session recorded by the daemon subcommand", runLap},
+		"normalize":  {"Repair and rewrite a recorded CSV file in the canonical format", runNormalize},
+		"recover":    {"List the checkpoints of sessions that did not finish, and write them out", runRecover},
+		"relabel":    {"Rewrite the labels of a recorded CSV file", runRelabel},
+		"report":     {"Print statistics of recorded CSV files", runReport},
+		"schema":     {"Print a JSON Schema of the events written by -format json or ndjson", runSchema},
+		"status":     {"Print the summary of a session recorded with -http", runStatus},
+		"stop":       {"End a session recorded by the daemon subcommand, or with -http-control", runStop},
+		"tick":       {"Record a tick into a session recorded with -http-control", runTick},
+		"total":      {"Print the active time of each recorded CSV file and the sum of all", runTotal},
+		"validate":   {"Check that recorded CSV files are complete and consistent", runValidate},
+		"verify":     {"Verify the checksum of recorded CSV files", runVerify},
+		"version":    {"Print the version, build and capabilities of the program", runVersion},
+	}
+}
+
+// subcommandNames returns the names of all subcommands in sorted order
+func subcommandNames() []string {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// usage prints the top level usage message, including the subcommands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s <command> [flags] [args]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	for _, name := range subcommandNames() {
+		fmt.Fprintf(out, "  %-10s %s\n", name, subcommands[name].synopsis)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// newFlagSet creates a FlagSet for subcommand name. The argsUsage describes
+// the positional arguments in the usage message.
+func newFlagSet(name, argsUsage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n\n%s.\n\nFlags:\n",
+			os.Args[0], name, argsUsage, subcommands[name].synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args with fs. Returns false and the exit status to use
+// if the program should not continue.
+func parseFlags(fs *flag.FlagSet, args []string) (bool, int) {
+	if inspectFlags != nil {
+		inspectFlags(fs)
+		return false, 0
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return false, 0
+		}
+		return false, 2
+	}
+	return true, 0
+}
+
+// flagWasSet reports whether the named flag was given explicitly in fs
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// durationList is a flag.Value collecting the durations of a repeated flag
+type durationList []time.Duration
+
+func (l *durationList) String() string {
+	var parts []string
+	for _, d := range *l {
+		parts = append(parts, d.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *durationList) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		return fmt.Errorf("negative duration %v", d)
+	}
+	*l = append(*l, d)
+	return nil
+}
+
+// sorted returns the durations in increasing order
+func (l durationList) sorted() []time.Duration {
+	ds := append([]time.Duration(nil), l...)
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds
+}
+
+// stringList is a flag.Value collecting the values of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
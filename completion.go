@@ -0,0 +1,280 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// flagCompletion tells how the value of a flag is completed: from a closed
+// set of values, as a file path or as a directory. Other flags taking a
+// value are not completed.
+type flagCompletion struct {
+	values func() []string
+	files  bool
+	dirs   bool
+}
+
+// flagCompletions holds the completions of the flags defined in each
+// FlagSet, registered next to the flag definitions, see runCompletion
+var flagCompletions = map[*flag.FlagSet]map[string]flagCompletion{}
+
+func setCompletion(fs *flag.FlagSet, name string, c flagCompletion) {
+	if flagCompletions[fs] == nil {
+		flagCompletions[fs] = make(map[string]flagCompletion)
+	}
+	flagCompletions[fs][name] = c
+}
+
+// completeValues completes the value of the flag name of fs from values
+func completeValues(fs *flag.FlagSet, name string, values func() []string) {
+	setCompletion(fs, name, flagCompletion{values: values})
+}
+
+// completeFiles completes the values of the named flags of fs as paths
+func completeFiles(fs *flag.FlagSet, names ...string) {
+	for _, name := range names {
+		setCompletion(fs, name, flagCompletion{files: true})
+	}
+}
+
+// completeDirs completes the values of the named flags of fs as directories
+func completeDirs(fs *flag.FlagSet, names ...string) {
+	for _, name := range names {
+		setCompletion(fs, name, flagCompletion{dirs: true})
+	}
+}
+
+// inspectFlags, when set, is given the FlagSet of a subcommand by
+// parseFlags instead of parsing the arguments, see commandFlags
+var inspectFlags func(fs *flag.FlagSet)
+
+// completionShells lists the shells runCompletion writes scripts for
+var completionShells = map[string]func(out io.Writer, name string, cmds []completionCommand) error{
+	"bash": writeBashCompletion,
+	"fish": writeFishCompletion,
+	"zsh":  writeZshCompletion,
+}
+
+// shellNames returns the names of completionShells in sorted order
+func shellNames() []string {
+	var names []string
+	for name := range completionShells {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completionCommand describes the flags of the program without a
+// subcommand (name ""), or of a subcommand
+type completionCommand struct {
+	name     string
+	synopsis string
+	flags    []completionFlag
+	args     []string // the values of the arguments; nil completes paths
+}
+
+// completionFlag describes one flag for completion
+type completionFlag struct {
+	name    string
+	usage   string // first line of the usage
+	boolean bool   // the flag takes no value
+	flagCompletion
+}
+
+// commandFlags returns the flags of the program and of every subcommand.
+// The subcommands define their flags as usual, and hand them over in
+// parseFlags.
+func commandFlags(main *flag.FlagSet) []completionCommand {
+	cmds := []completionCommand{{flags: describeFlags(main)}}
+	defer func() { inspectFlags = nil }()
+	for _, name := range subcommandNames() {
+		cmd := completionCommand{name: name, synopsis: subcommands[name].synopsis}
+		if run := subcommands[name].run; run != nil {
+			inspectFlags = func(fs *flag.FlagSet) { cmd.flags = describeFlags(fs) }
+			run(nil)
+		} else if name == "completion" {
+			cmd.args = shellNames()
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func describeFlags(fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		usage, _, _ := strings.Cut(f.Usage, "\n")
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:           f.Name,
+			usage:          usage,
+			boolean:        ok && b.IsBoolFlag(),
+			flagCompletion: flagCompletions[fs][f.Name],
+		})
+	})
+	return flags
+}
+
+// runCompletion writes the completion script of the shell named in args.
+// Unlike the other subcommands, it is run by main once the flags of the
+// program are defined in mainFlags.
+func runCompletion(args []string, mainFlags *flag.FlagSet) int {
+	fs := newFlagSet("completion", strings.Join(shellNames(), "|"))
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	write, ok := completionShells[fs.Arg(0)]
+	if fs.NArg() != 1 || !ok {
+		fs.Usage()
+		return 2
+	}
+	if err := write(os.Stdout, filepath.Base(os.Args[0]), commandFlags(mainFlags)); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	return 0
+}
+
+// shellIdent makes name usable as part of a shell function name
+var shellIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// bashWords quotes words as a single argument of compgen -W
+func bashWords(words []string) string {
+	return "'" + strings.ReplaceAll(strings.Join(words, " "), "'", `'\''`) + "'"
+}
+
+func writeBashCompletion(out io.Writer, name string, cmds []completionCommand) error {
+	fn := "_" + shellIdent.ReplaceAllString(name, "_")
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s, generated by '%s completion bash'\n", name, name)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd=\n")
+	var names []string
+	for _, cmd := range cmds[1:] {
+		names = append(names, cmd.name)
+	}
+	fmt.Fprintf(&b, "\tcase ${COMP_WORDS[1]} in %s) cmd=${COMP_WORDS[1]} ;; esac\n", strings.Join(names, "|"))
+	b.WriteString("\tCOMPREPLY=()\n")
+	// the value of the previous flag
+	b.WriteString("\tcase $cmd:${prev#-} in\n")
+	for _, cmd := range cmds {
+		for _, f := range cmd.flags {
+			if f.boolean {
+				continue
+			}
+			fmt.Fprintf(&b, "\t%s:%s|%s:-%s)\n", cmd.name, f.name, cmd.name, f.name)
+			switch {
+			case f.values != nil:
+				fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\")) ;;\n", bashWords(f.values()))
+			case f.files:
+				b.WriteString("\t\tCOMPREPLY=($(compgen -f -- \"$cur\")) ;;\n")
+			case f.dirs:
+				b.WriteString("\t\tCOMPREPLY=($(compgen -d -- \"$cur\")) ;;\n")
+			default:
+				b.WriteString("\t\t;;\n")
+			}
+		}
+	}
+	b.WriteString("\t*)\n")
+	// a flag, a subcommand or an argument
+	b.WriteString("\t\tcase $cmd in\n")
+	for _, cmd := range cmds {
+		var flags []string
+		for _, f := range cmd.flags {
+			flags = append(flags, "-"+f.name)
+		}
+		fmt.Fprintf(&b, "\t\t%q)\n", cmd.name)
+		if cmd.name == "" {
+			fmt.Fprintf(&b, "\t\t\tif [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then\n")
+			fmt.Fprintf(&b, "\t\t\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n", bashWords(names))
+			fmt.Fprintf(&b, "\t\t\telse\n\t\t\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n\t\t\tfi ;;\n", bashWords(flags))
+			continue
+		}
+		fmt.Fprintf(&b, "\t\t\tif [[ $cur == -* ]]; then\n")
+		fmt.Fprintf(&b, "\t\t\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n", bashWords(flags))
+		args := "-f"
+		if cmd.args != nil {
+			args = "-W " + bashWords(cmd.args)
+		}
+		fmt.Fprintf(&b, "\t\t\telse\n\t\t\t\tCOMPREPLY=($(compgen %s -- \"$cur\"))\n\t\t\tfi ;;\n", args)
+	}
+	b.WriteString("\t\tesac ;;\n\tesac\n}\n")
+	fmt.Fprintf(&b, "complete -o filenames -F %s %s\n", fn, name)
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// writeZshCompletion writes the bash completion, loaded through zsh's
+// bash completion emulation
+func writeZshCompletion(out io.Writer, name string, cmds []completionCommand) error {
+	if _, err := fmt.Fprintf(out, "# zsh completion for %s, generated by '%s completion zsh'\n"+
+		"autoload -U +X bashcompinit && bashcompinit\n", name, name); err != nil {
+		return err
+	}
+	return writeBashCompletion(out, name, cmds)
+}
+
+// fishQuote quotes s as a single fish argument
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func writeFishCompletion(out io.Writer, name string, cmds []completionCommand) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s, generated by '%s completion fish'\n", name, name)
+	var names []string
+	for _, cmd := range cmds[1:] {
+		names = append(names, cmd.name)
+	}
+	fmt.Fprintf(&b, "complete -c %s -f\n", name)
+	for _, cmd := range cmds {
+		cond := "__fish_use_subcommand"
+		if cmd.name != "" {
+			fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", name, cmd.name, fishQuote(cmd.synopsis))
+			cond = "__fish_seen_subcommand_from " + cmd.name
+			if cmd.args != nil {
+				fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n", name, fishQuote(cond), fishQuote(strings.Join(cmd.args, " ")))
+			} else {
+				fmt.Fprintf(&b, "complete -c %s -n %s -F\n", name, fishQuote(cond))
+			}
+		}
+		for _, f := range cmd.flags {
+			fmt.Fprintf(&b, "complete -c %s -n %s -o %s -d %s", name, fishQuote(cond), f.name, fishQuote(f.usage))
+			switch {
+			case f.boolean:
+			case f.values != nil:
+				fmt.Fprintf(&b, " -x -a %s", fishQuote(strings.Join(f.values(), " ")))
+			case f.files:
+				b.WriteString(" -r -F")
+			case f.dirs:
+				b.WriteString(" -x -a '(__fish_complete_directories)'")
+			default:
+				b.WriteString(" -x")
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCommandFlags(t *testing.T) {
+	main := flag.NewFlagSet("stopwatch-go", flag.ContinueOnError)
+	main.String("o", "", "output file")
+	main.Bool("q", false, "quiet")
+	completeFiles(main, "o")
+	defer delete(flagCompletions, main)
+
+	cmds := commandFlags(main)
+	if inspectFlags != nil {
+		t.Fatal("Expected inspectFlags to be reset")
+	}
+	if cmds[0].name != "" || len(cmds[0].flags) != 2 || !cmds[0].flags[0].files || !cmds[0].flags[1].boolean {
+		t.Errorf("Unexpected flags of the program: %+v", cmds[0])
+	}
+	byName := make(map[string]completionCommand)
+	for _, cmd := range cmds[1:] {
+		byName[cmd.name] = cmd
+	}
+	if len(byName["convert"].flags) == 0 {
+		t.Error("Expected the flags of convert")
+	}
+	for _, f := range byName["convert"].flags {
+		if f.name == "to" && (f.values == nil || strings.Join(f.values(), " ") != strings.Join(formatNames(), " ")) {
+			t.Errorf("Expected -to of convert to complete the formats")
+		}
+	}
+	if strings.Join(byName["completion"].args, " ") != "bash fish zsh" {
+		t.Errorf("Expected completion to complete the shells, got %v", byName["completion"].args)
+	}
+}
+
+func TestCompletionNamesDefined(t *testing.T) {
+	commandFlags(flag.CommandLine)
+	for fs, names := range flagCompletions {
+		for name := range names {
+			if fs.Lookup(name) == nil {
+				t.Errorf("Completion registered for undefined flag -%s of %s", name, fs.Name())
+			}
+		}
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	cmds := commandFlags(flag.CommandLine)
+	for _, shell := range shellNames() {
+		var buf bytes.Buffer
+		if err := completionShells[shell](&buf, "stopwatch-go", cmds); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"convert", "csv ics json"} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("Expected %q in the %s script", want, shell)
+			}
+		}
+		// check the syntax, if the shell is installed
+		if path, err := exec.LookPath(shell); err == nil && shell != "fish" {
+			cmd := exec.Command(path, "-n")
+			cmd.Stdin = &buf
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("Invalid %s script: %v\n%s", shell, err, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// compression is a format the output files can be compressed with
+type compression struct {
+	suffix string // of the file names, e.g. ".gz"
+	magic  []byte // the first bytes of the compressed data
+	media  string // the Content-Type of an upload
+
+	minLevel, maxLevel, defaultLevel int
+
+	writer func(w io.Writer, level int) (io.WriteCloser, error)
+	reader func(r io.Reader) (io.Reader, error)
+}
+
+// compressions is the registry of compression formats, keyed by the name
+// given to -compress
+var compressions = map[string]compression{
+	"gzip": {
+		suffix: ".gz", magic: []byte{0x1f, 0x8b}, media: "application/gzip",
+		minLevel: gzip.BestSpeed, maxLevel: gzip.BestCompression, defaultLevel: 6,
+		writer: func(w io.Writer, level int) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, level) },
+		reader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	},
+	"zstd": {
+		suffix: ".zst", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, media: "application/zstd",
+		minLevel: zstdMinLevel, maxLevel: zstdMaxLevel, defaultLevel: zstdDefaultLevel,
+		writer: func(w io.Writer, level int) (io.WriteCloser, error) { return newZstdWriter(w, level) },
+		reader: func(r io.Reader) (io.Reader, error) { return newZstdReader(r) },
+	},
+}
+
+// compressNone disables the compression chosen by the suffix
+const compressNone = "none"
+
+// compressionNames returns the names of the compression formats in sorted
+// order
+func compressionNames() []string {
+	var names []string
+	for name := range compressions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compressionBySuffix returns the name of the compression format path
+// ends with the suffix of, or ""
+func compressionBySuffix(path string) string {
+	for name, c := range compressions {
+		if strings.HasSuffix(path, c.suffix) {
+			return name
+		}
+	}
+	return ""
+}
+
+// withCompression resolves the compression of the output outFile: the
+// format given in opts.Compress, or by the suffix of the file name.
+// The open files named by isStream are compressed only if asked for, and
+// a URL by the suffix of its path.
+func withCompression(opts OutputOptions, outFile string) (OutputOptions, error) {
+	if isUpload(outFile) {
+		outFile = uploadName(outFile)
+	}
+	if opts.Compress == "" && !isStream(outFile) {
+		opts.Compress = compressionBySuffix(outFile)
+	}
+	if opts.Compress == compressNone {
+		opts.Compress = ""
+	}
+	if opts.Compress == "" {
+		if opts.CompressLevel != 0 {
+			return opts, fmt.Errorf("-compress-level requires -compress, or an output file ending in .gz or .zst")
+		}
+		return opts, nil
+	}
+	c, ok := compressions[opts.Compress]
+	if !ok {
+		return opts, fmt.Errorf("unknown compression %q (available: %s, %s)", opts.Compress,
+			strings.Join(compressionNames(), ", "), compressNone)
+	}
+	if level := opts.CompressLevel; level != 0 && (level < c.minLevel || level > c.maxLevel) {
+		return opts, fmt.Errorf("%s compression level %d out of range %d-%d", opts.Compress, level, c.minLevel, c.maxLevel)
+	}
+	return opts, nil
+}
+
+// writeCompressed calls write with a writer compressing into out in the
+// named format, at level or at the default level of the format if 0. The
+// compressed data is complete only once write returns without an error.
+func writeCompressed(out io.Writer, name string, level int, write func(w io.Writer) error) error {
+	c, ok := compressions[name]
+	if !ok {
+		return fmt.Errorf("unknown compression %q", name)
+	}
+	if level == 0 {
+		level = c.defaultLevel
+	}
+	cw, err := c.writer(out, level)
+	if err != nil {
+		return fmt.Errorf("could not initialize %s compression: %w", name, err)
+	}
+	if err := write(cw); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("%s compression: %w", name, err)
+	}
+	return nil
+}
+
+// decompressed returns a reader of r, decompressing it if it starts with
+// the magic bytes of one of the compression formats
+func decompressed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4)
+	for name, c := range compressions {
+		if bytes.HasPrefix(head, c.magic) {
+			dr, err := c.reader(br)
+			if err != nil {
+				return nil, fmt.Errorf("could not read %s data: %w", name, err)
+			}
+			return dr, nil
+		}
+	}
+	return br, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpEventsCompressed(t *testing.T) {
+	dir := t.TempDir()
+	events := testEvents(time.Second, 2*time.Second)
+	var plain bytes.Buffer
+	if err := EncodeCSV(&plain, events, OutputOptions{Comment: "run 1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range compressionNames() {
+		// by the suffix
+		path := filepath.Join(dir, "out.csv"+compressions[name].suffix)
+		opts, err := withCompression(OutputOptions{Comment: "run 1", CompressLevel: compressions[name].maxLevel}, path)
+		if err != nil || opts.Compress != name {
+			t.Fatalf("%s: expected the compression by the suffix, got %q, %v", name, opts.Compress, err)
+		}
+		if err := DumpEvents(path, events, opts); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		if !bytes.HasPrefix(data, compressions[name].magic) {
+			t.Errorf("%s: expected compressed output, got %q", name, data)
+		}
+		r, err := decompressed(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, plain.Bytes()) {
+			t.Errorf("%s: expected the CSV back, got %q, %v", name, got, err)
+		}
+		loaded, comment, err := LoadCSV(path)
+		if err != nil || comment != "run 1" || !reflect.DeepEqual(loaded, events) {
+			t.Errorf("%s: unexpected events read back: %v, %q, %v", name, loaded, comment, err)
+		}
+	}
+
+	// none overrides the suffix
+	opts, err := withCompression(OutputOptions{Compress: compressNone}, "out.csv.gz")
+	if err != nil || opts.Compress != "" {
+		t.Errorf("Expected no compression, got %q, %v", opts.Compress, err)
+	}
+	// and stdout is compressed only if asked
+	if opts, _ := withCompression(OutputOptions{}, "-"); opts.Compress != "" {
+		t.Errorf("Expected stdout not to be compressed, got %q", opts.Compress)
+	}
+	for _, opts := range []OutputOptions{
+		{Compress: "xz"},
+		{Compress: "gzip", CompressLevel: 10},
+		{Compress: "zstd", CompressLevel: -1},
+		{CompressLevel: 3},
+	} {
+		if _, err := withCompression(opts, "out.csv"); err == nil {
+			t.Errorf("Expected error for %+v", opts)
+		}
+	}
+}
+
+func TestDecompressedPlain(t *testing.T) {
+	for _, in := range []string{"", "#", "seq,ts,what\n"} {
+		r, err := decompressed(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(r); string(got) != in {
+			t.Errorf("Expected %q as is, got %q", in, got)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// ComputedColumn is a column of -column, computed for each event by a
+// template executed with its TemplateEvent
+type ComputedColumn struct {
+	Name     string
+	Template *template.Template
+}
+
+// ParseComputedColumn parses the -column spec "name={{template}}". The
+// name is validated like an attribute name, as the values are written as
+// attributes, see withComputedColumns.
+func ParseComputedColumn(spec string) (ComputedColumn, error) {
+	name, text, ok := strings.Cut(spec, "=")
+	if !ok {
+		return ComputedColumn{}, fmt.Errorf("invalid -column %q: expected name={{template}}", spec)
+	}
+	if err := validateAttrKey(name); err != nil {
+		return ComputedColumn{}, fmt.Errorf("invalid -column %q: %w", spec, err)
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return ComputedColumn{}, fmt.Errorf("invalid -column %q: %w", spec, err)
+	}
+	return ComputedColumn{Name: name, Template: tmpl}, nil
+}
+
+// withComputedColumns returns events with the values of columns as their
+// attributes, replacing any attribute of the same name. A value that can
+// not be computed is left out, i.e. written as an empty cell; they are all
+// reported in a single warning into msgs, unless nil.
+func withComputedColumns(events []Event, columns []ComputedColumn, msgs io.Writer) []Event {
+	computed := make([]Event, len(events))
+	failed := 0
+	var first error
+	var buf strings.Builder
+	for i, evt := range NewTemplateData(events, OutputOptions{}).Events {
+		attrs := make(map[string]string, len(evt.Attrs)+len(columns))
+		for key, value := range evt.Attrs {
+			attrs[key] = value
+		}
+		for _, col := range columns {
+			buf.Reset()
+			delete(attrs, col.Name)
+			if err := col.Template.Execute(&buf, evt); err != nil {
+				if failed++; first == nil {
+					first = fmt.Errorf("event %d: %w", evt.Seq, err)
+				}
+				continue
+			}
+			if buf.Len() > 0 {
+				attrs[col.Name] = buf.String()
+			}
+		}
+		computed[i] = evt.Event
+		computed[i].Attrs = attrs
+	}
+	if failed > 0 && msgs != nil {
+		fmt.Fprintf(msgs, "# WARNING: could not compute -column, cells left empty: %d; the first: %v\n", failed, first)
+	}
+	return computed
+}
+
+// computedLast returns the attribute columns attrs with the columns of
+// -column at the end, in their order, whether any event has a value or not
+func computedLast(attrs []string, columns []ComputedColumn) []string {
+	names := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		names[col.Name] = true
+	}
+	var ordered []string
+	for _, name := range attrs {
+		if !names[name] {
+			ordered = append(ordered, name)
+		}
+	}
+	for _, col := range columns {
+		ordered = append(ordered, col.Name)
+	}
+	return ordered
+}
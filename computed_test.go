@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputedColumns(t *testing.T) {
+	var cols []ComputedColumn
+	for _, spec := range []string{"lap_ms={{.Delta.Milliseconds}}", "lane={{index .Attrs \"lane\" | printf \"%s!\"}}",
+		"bad={{if eq .Seq 1}}{{.Nope}}{{end}}x"} {
+		col, err := ParseComputedColumn(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, col)
+	}
+	events := testEvents(1500*time.Millisecond, 250*time.Millisecond)
+	events[1].Attrs = map[string]string{"lane": "3", "arch": "arm64"}
+	var buf, msgs bytes.Buffer
+	encode, prepared, opts, err := prepareOutput(events, OutputOptions{Computed: cols, Messages: &msgs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encode(&buf, prepared, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := "# stopwatch-schema: 2\nseq,ts,what,arch,lap_ms,lane,bad\n" +
+		"0,2022-04-08T20:00:00Z,enter,,0,!,x\n" +
+		"1,2022-04-08T20:00:01.5Z,tick,arm64,1500,3!,\n" +
+		"2,2022-04-08T20:00:01.75Z,exit,,250,!,x\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+	if got := msgs.String(); !strings.HasPrefix(got, "# WARNING: could not compute -column, cells left empty: 1;") ||
+		strings.Count(got, "\n") != 1 || !strings.Contains(got, "event 1:") {
+		t.Errorf("Expected a single warning, got %q", got)
+	}
+	if events[1].Attrs["lane"] != "3" || len(events[1].Attrs) != 2 {
+		t.Errorf("Expected the recorded attributes to be left as they were, got %v", events[1].Attrs)
+	}
+
+	for _, spec := range []string{"lap_ms", "seq={{.Seq}}", "attrs={{.Seq}}", "=x", "x={{.Seq"} {
+		if _, err := ParseComputedColumn(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
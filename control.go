@@ -0,0 +1,92 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// controlJSON is the -control protocol reading commands as JSON lines
+const controlJSON = "json"
+
+// controlUsage documents the -control json protocol in the usage message
+const controlUsage = "Set to 'json' to read commands from stdin as JSON objects, one per line,\n" +
+	"instead of interactive input, and acknowledge each on stdout. Requires -o:\n" +
+	"  {\"cmd\":\"tick\",\"what\":\"<label>\"}  record a tick; \"what\" is optional\n" +
+	"  {\"cmd\":\"note\",\"text\":\"<text>\"}   record a milestone, like 'mark'\n" +
+	"  {\"cmd\":\"stop\"}                   end the session\n" +
+	"Replies: {\"ok\":true,\"cmd\":\"tick\",\"seq\":1} or {\"ok\":false,\"cmd\":\"...\",\"error\":\"...\"}"
+
+// controlCommand is a command of the -control json protocol
+type controlCommand struct {
+	Cmd  string `json:"cmd"`
+	What string `json:"what"` // tick: the label
+	Text string `json:"text"` // note: the text of the milestone
+}
+
+// controlReply acknowledges a controlCommand
+type controlReply struct {
+	OK    bool   `json:"ok"`
+	Cmd   string `json:"cmd,omitempty"`
+	Seq   *int64 `json:"seq,omitempty"` // the event recorded
+	Error string `json:"error,omitempty"`
+}
+
+// handleControl runs the -control json command on line, writing the reply
+// into out and any messages into msgs. Returns true if the session should
+// end.
+func (s *Session) handleControl(line string, out, msgs io.Writer) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false
+	}
+	var cmd controlCommand
+	var reply controlReply
+	stop := false
+	record := func(record func(out io.Writer)) {
+		evt, err := s.recorded(msgs, record)
+		if err != nil {
+			reply.Error = err.Error()
+			return
+		}
+		reply.OK, reply.Seq = true, &evt.Seq
+	}
+	if err := json.Unmarshal([]byte(line), &cmd); err != nil {
+		reply.Error = fmt.Sprintf("invalid command: %v", err)
+	} else {
+		reply.Cmd = cmd.Cmd
+		switch cmd.Cmd {
+		case "tick":
+			record(func(out io.Writer) { s.recordTick(Event{What: cmd.What}, out) })
+		case "note":
+			if cmd.Text == "" {
+				reply.Error = "note requires text"
+				break
+			}
+			record(func(out io.Writer) { s.cmdMark(cmd.Text, out) })
+		case "stop":
+			reply.OK, stop = true, true
+		default:
+			reply.Error = fmt.Sprintf("unknown command %q", cmd.Cmd)
+		}
+	}
+	if err := json.NewEncoder(out).Encode(reply); err != nil {
+		fmt.Fprintln(msgs, "\n# WARNING: could not write the reply:", err)
+	}
+	return stop
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestHandleControl(t *testing.T) {
+	sess := newSession("", collectOptions{Control: true})
+	sess.start()
+
+	// drive the session through pipes, like collect with stdin and stdout
+	cmdR, cmdW := io.Pipe()
+	ackR, ackW := io.Pipe()
+	stopped := make(chan bool)
+	go func() {
+		in := bufio.NewReader(cmdR)
+		for {
+			line, err := in.ReadString('\n')
+			if err != nil {
+				stopped <- false
+				return
+			}
+			if sess.handleControl(line, ackW, io.Discard) {
+				stopped <- true
+				return
+			}
+		}
+	}()
+	acks := bufio.NewReader(ackR)
+	send := func(cmd string) controlReply {
+		t.Helper()
+		go io.WriteString(cmdW, cmd+"\n")
+		line, err := acks.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply controlReply
+		if err := json.Unmarshal([]byte(line), &reply); err != nil {
+			t.Fatalf("Invalid reply %q: %v", line, err)
+		}
+		return reply
+	}
+
+	for _, tc := range []struct {
+		cmd   string
+		ok    bool
+		seq   int64
+		error string
+	}{
+		{`{"cmd":"tick","what":"phase1"}`, true, 1, ""},
+		{`{"cmd":"tick"}`, true, 2, ""},
+		{`{"cmd":"tick","what":"exit"}`, false, 0, `Label "exit" is reserved, not recorded`},
+		{`{"cmd":"note","text":"door opened"}`, true, 3, ""},
+		{`{"cmd":"note"}`, false, 0, "note requires text"},
+		{`{"cmd":"lap"}`, false, 0, `unknown command "lap"`},
+		{`not json`, false, 0, "invalid command: invalid character 'o' in literal null (expecting 'u')"},
+	} {
+		reply := send(tc.cmd)
+		if reply.OK != tc.ok || reply.Error != tc.error || (tc.ok && (reply.Seq == nil || *reply.Seq != tc.seq)) {
+			t.Errorf("%s: expected ok: %v, seq: %d, error: %q, got %+v", tc.cmd, tc.ok, tc.seq, tc.error, reply)
+		}
+	}
+	want := []string{labelEnter, "phase1", labelTick, labelMarkPrefix + "door opened"}
+	for i, evt := range sess.Events {
+		if i >= len(want) || evt.What != want[i] {
+			t.Errorf("Expected events %q, got %v", want, sess.Events)
+			break
+		}
+	}
+
+	if reply := send(`{"cmd":"stop"}`); !reply.OK || reply.Cmd != "stop" {
+		t.Errorf("Expected stop to be acknowledged, got %+v", reply)
+	}
+	if !<-stopped {
+		t.Error("Expected stop to end the session")
+	}
+}
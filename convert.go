@@ -0,0 +1,72 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runConvert(args []string) int {
+	fs := newFlagSet("convert", "<file.csv>")
+	outFile := fs.String("o", "", "Output file path, stderr, fd:N or an http(s):// URL to upload to (default: stdout)")
+	inDelimiter := fs.String("in-delimiter", "", "CSV field delimiter of the file read (use \\t for tab; default: detected from the header)")
+	var inRename stringList
+	fs.Var(&inRename, "in-rename", "Read the column source from the header text target, given as source=target,\n"+
+		"as written by -rename. Can be repeated")
+	outFlags := addOutputFlags(fs, "to")
+	completeFiles(fs, "o")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	opts, err := outFlags.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	if (opts.RotateEvents > 0 || opts.RotateSize > 0 || opts.SplitByLabel || opts.Manifest) && (isStream(*outFile) || isUpload(*outFile)) {
+		fmt.Fprintln(os.Stderr, "ERROR: -rotate-every, -rotate-size, -split-by-label and -manifest require an output file (-o),\n"+
+			"not a stream or a URL")
+		return 2
+	}
+	if opts, err = withCompression(opts, *outFile); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	var in ReadOptions
+	if in.Delimiter, err = parseInputDelimiter(*inDelimiter); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: -in-delimiter:", err)
+		return 2
+	}
+	if in.Rename, err = parseRenames(inRename); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: -in-rename:", err)
+		return 2
+	}
+	events, p, err := loadCSV(fs.Arg(0), in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem reading CSV:", err)
+		return 1
+	}
+	opts.Comment, opts.Meta = p.comment, p.meta
+	if err := DumpEvents(*outFile, events, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem writing output:", err)
+		return 1
+	}
+	return 0
+}
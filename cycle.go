@@ -0,0 +1,97 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// cyclePhase is a named phase of a -cycle
+type cyclePhase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// ParseCycle parses a cycle definition: comma separated name=duration
+// pairs, e.g. "work=25m,rest=5m". Any number of phases is accepted, and
+// names may repeat. An empty spec is no cycle.
+func ParseCycle(spec string) ([]cyclePhase, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var phases []cyclePhase
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name == "" || strings.IndexAny(name, " \t") >= 0 {
+			return nil, fmt.Errorf("expected name=duration, got %q", part)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("phase %s: %w", name, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("phase %s: duration must be positive", name)
+		}
+		phases = append(phases, cyclePhase{Name: name, Duration: d})
+	}
+	return phases, nil
+}
+
+// startCycle records the first phase of the cycle, at the "enter" event
+func (s *Session) startCycle() {
+	if len(s.opts.Cycle) == 0 {
+		return
+	}
+	s.phaseStart = s.startAt
+	s.recordEvent(Event{Timestamp: s.phaseStart, What: labelPhasePrefix + s.opts.Cycle[0].Name})
+}
+
+// currentPhase returns the active phase and the time left in it
+func (s *Session) currentPhase() (cyclePhase, time.Duration, bool) {
+	if len(s.opts.Cycle) == 0 || s.phaseStart.IsZero() {
+		return cyclePhase{}, 0, false
+	}
+	p := s.opts.Cycle[s.phase%len(s.opts.Cycle)]
+	left := s.phaseStart.Add(p.Duration).Sub(s.now())
+	if left < 0 {
+		left = 0
+	}
+	return p, left, true
+}
+
+// checkPhases records the phase transitions that are due, timestamped at
+// the scheduled moment, and rings the terminal bell if enabled. Reports whether any
+// transition happened.
+func (s *Session) checkPhases(out io.Writer) bool {
+	if len(s.opts.Cycle) == 0 || s.phaseStart.IsZero() {
+		return false
+	}
+	now := s.now()
+	for changed := false; ; changed = true {
+		end := s.phaseStart.Add(s.opts.Cycle[s.phase%len(s.opts.Cycle)].Duration)
+		if end.After(now) {
+			return changed
+		}
+		s.phase++
+		s.phaseStart = end
+		p := s.opts.Cycle[s.phase%len(s.opts.Cycle)]
+		s.recordEvent(Event{Timestamp: end, What: labelPhasePrefix + p.Name})
+		fmt.Fprintf(out, "%s\n# Phase: %s (%s)\n", bell(s.opts.Bell), p.Name, formatDuration(p.Duration))
+		s.notify(fmt.Sprintf("Phase: %s (%s)", p.Name, formatDuration(p.Duration)), end, out)
+	}
+}
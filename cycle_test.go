@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCycle(t *testing.T) {
+	phases, err := ParseCycle("work=25m, rest=5m,work=25m,long-rest=15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []cyclePhase{{"work", 25 * time.Minute}, {"rest", 5 * time.Minute},
+		{"work", 25 * time.Minute}, {"long-rest", 15 * time.Minute}}
+	if !reflect.DeepEqual(phases, expect) {
+		t.Errorf("Expected %v, got %v", expect, phases)
+	}
+	if phases, err := ParseCycle(""); err != nil || phases != nil {
+		t.Errorf("Expected no cycle, got %v, %v", phases, err)
+	}
+	for _, spec := range []string{"work", "=5m", "work=", "work=5", "work=0s", "rest=-1m", "a b=1m", "work=25m,"} {
+		if _, err := ParseCycle(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestSessionCycle(t *testing.T) {
+	steps := []time.Duration{0, 10 * time.Minute, 0, 0, 16 * time.Minute, 0, 0, 10 * time.Minute, 0}
+	cycle := []cyclePhase{{"work", 25 * time.Minute}, {"rest", 5 * time.Minute}}
+	sess := newSession("", collectOptions{Cycle: cycle, Bell: true})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+
+	sess.start()
+	if wait, ok := sess.nextTimer(); !ok || wait != 15*time.Minute {
+		t.Errorf("Expected next phase in 15m, got %v %v", wait, ok)
+	}
+	if got := sess.prompt(); got != "# [work 15m0s left] Waiting for [2]> " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	sess.handleLine("", &out) // at 10m
+	sess.checkTimers(&out)    // at 26m
+	sess.handleLine("", &out) // at 26m
+	sess.checkTimers(&out)    // at 36m, past the first cycle
+	sess.finish()
+
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.Timestamp.Sub(sess.Events[0].Timestamp).String()+" "+evt.What+" "+evt.Phase)
+	}
+	want := []string{
+		"0s enter ",
+		"0s phase:work work",
+		"10m0s tick work",
+		"25m0s phase:rest rest",
+		"26m0s tick rest",
+		"30m0s phase:work work",
+		"36m0s exit work",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, got)
+	}
+	if out.String() != "\a\n# Phase: rest (5m0s)\n\a\n# Phase: work (25m0s)\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	if laps := LapDurations(sess.Events); !reflect.DeepEqual(laps, []time.Duration{10 * time.Minute, 16 * time.Minute}) {
+		t.Errorf("Expected phase events not to affect laps, got %v", laps)
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	daemonTimeout      = 10 * time.Second // for a command to the daemon, and its reply
+	daemonStartTimeout = 5 * time.Second  // for the daemon to accept commands after being started
+)
+
+// Commands of the daemon, one per connection to its socket
+const (
+	daemonLap      = "lap"       // "lap <text>" records a tick, replying "ok <seq>" or "error <reason>"
+	daemonStop     = "stop"      // ends the session, writing the -o of the daemon, replying "ok <path>"
+	daemonStopData = "stop data" // ends the session, replying "ok" and the events as CSV instead
+)
+
+// runDaemon starts a session recording in the background, with the ticks
+// sent by the lap subcommand over a unix socket, see daemonSocket
+func runDaemon(args []string) int {
+	fs := newFlagSet("daemon", "")
+	name := fs.String("name", "", "Name of the session, used by the lap and stop subcommands. Required")
+	outFile := fs.String("o", "", "Output file, written when the daemon is stopped without -o, or by SIGTERM\n"+
+		"(default: <name>.csv in the current directory)")
+	comment := fs.String("c", "", "Comment for the output file. Optional")
+	foreground := fs.Bool("foreground", false, "Record in the foreground instead, e.g. under a service manager")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if err := validateDaemonName(*name); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: invalid -name:", err)
+		return 2
+	}
+	if *outFile == "" {
+		*outFile = *name + ".csv"
+	}
+	out, err := filepath.Abs(*outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: invalid -o:", err)
+		return 2
+	}
+	if err := probeOutput(out, false); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: output can not be written:", err)
+		return 1
+	}
+	sock, err := daemonSocket(*name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: no directory for the socket:", err)
+		return 1
+	}
+	if *foreground {
+		ln, err := listenDaemon(sock)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			return 1
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		defer stop()
+		fmt.Fprintf(os.Stderr, "# Daemon %s recording, pid %d\n", *name, os.Getpid())
+		if err := recordDaemon(ctx, ln, out, OutputOptions{Comment: *comment, Name: *name}, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			return 1
+		}
+		return 0
+	}
+	return startDaemon(*name, sock, out, *comment)
+}
+
+// startDaemon starts the daemon name in the background, and waits until
+// it accepts commands at sock. Its messages go into a log file next to
+// the socket.
+func startDaemon(name, sock, out, comment string) int {
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		fmt.Fprintf(os.Stderr, "ERROR: daemon %s is already running\n", name)
+		return 1
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	logPath := strings.TrimSuffix(sock, ".sock") + ".log"
+	log, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: could not create the log:", err)
+		return 1
+	}
+	defer log.Close()
+	cmd := exec.Command(exe, "daemon", "-foreground", "-name", name, "-o", out, "-c", comment)
+	cmd.Stdout, cmd.Stderr = log, log
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: could not start the daemon:", err)
+		return 1
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	deadline := time.Now().Add(daemonStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-exited:
+			msg, _ := os.ReadFile(logPath)
+			fmt.Fprintf(os.Stderr, "ERROR: the daemon exited: %s\n", strings.TrimSpace(string(msg)))
+			return 1
+		case <-time.After(50 * time.Millisecond):
+		}
+		if conn, err := net.Dial("unix", sock); err == nil {
+			conn.Close()
+			fmt.Fprintf(os.Stderr, "# Daemon %s recording, pid %d, log in %s\n", name, cmd.Process.Pid, logPath)
+			return 0
+		}
+	}
+	fmt.Fprintf(os.Stderr, "ERROR: the daemon did not start in %s, see %s\n", daemonStartTimeout, logPath)
+	return 1
+}
+
+// runLap records a tick into a daemon
+func runLap(args []string) int {
+	fs := newFlagSet("lap", "[label]")
+	name := fs.String("name", "", "Name of the daemon, see the daemon subcommand. Required")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	reply, _, status := callDaemon(os.Stderr, *name, daemonLap+" "+strings.Join(fs.Args(), " "))
+	if status != 0 {
+		return status
+	}
+	fmt.Println(reply)
+	return 0
+}
+
+// stopDaemon ends the daemon name, for the stop subcommand. The events are
+// written into outFile, or by the daemon into its own -o if empty.
+func stopDaemon(name, outFile string) int {
+	if outFile == "" {
+		reply, _, status := callDaemon(os.Stderr, name, daemonStop)
+		if status == 0 {
+			fmt.Fprintln(os.Stderr, "# Written to", reply)
+		}
+		return status
+	}
+	_, data, status := callDaemon(os.Stderr, name, daemonStopData)
+	if status != 0 {
+		return status
+	}
+	events, comment, err := UnmarshalEventsCSV(strings.NewReader(data))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: invalid events from the daemon:", err)
+		return exitUnreachable
+	}
+	if err := DumpEvents(outFile, events, OutputOptions{Comment: comment, Name: name}); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: could not write output:", err)
+		return 1
+	}
+	return 0
+}
+
+// callDaemon sends command to the daemon name, and returns its reply
+// without the "ok", the data following it, and the exit status, the error
+// written to errs. The exit statuses are those of callServer.
+func callDaemon(errs io.Writer, name, command string) (string, string, int) {
+	if err := validateDaemonName(name); err != nil {
+		fmt.Fprintln(errs, "ERROR: invalid -name:", err)
+		return "", "", 2
+	}
+	sock, err := daemonSocket(name)
+	if err != nil {
+		fmt.Fprintln(errs, "ERROR: no directory for the socket:", err)
+		return "", "", exitUnreachable
+	}
+	reply, data, err := sendDaemon(sock, command)
+	if err != nil {
+		fmt.Fprintf(errs, "ERROR: could not reach daemon %s: %v\n", name, err)
+		return "", "", exitUnreachable
+	}
+	if reply != "ok" && !strings.HasPrefix(reply, "ok ") {
+		fmt.Fprintln(errs, "ERROR:", strings.TrimPrefix(reply, "error "))
+		return "", "", exitRefused
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(reply, "ok"), " "), data, 0
+}
+
+// sendDaemon sends command to the daemon listening at sock, and returns
+// the first line of the reply, and the rest of it
+func sendDaemon(sock, command string) (string, string, error) {
+	conn, err := net.DialTimeout("unix", sock, daemonTimeout)
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(daemonTimeout))
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return "", "", err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", "", err
+	}
+	first, rest, ok := strings.Cut(string(reply), "\n")
+	if !ok {
+		return "", "", errors.New("no reply")
+	}
+	return first, rest, nil
+}
+
+// validateDaemonName checks that name can be used in the file name of the
+// socket of a daemon
+func validateDaemonName(name string) error {
+	if name == "" {
+		return errors.New("a name is required")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("%q: only letters, digits, '-', '_' and '.' are allowed", name)
+		}
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("%q is not a name", name)
+	}
+	return nil
+}
+
+// daemonSocket returns the path of the socket of the daemon name under
+// $XDG_RUNTIME_DIR/stopwatch, creating the directory. Without
+// $XDG_RUNTIME_DIR, a directory of the user under the temporary directory
+// is used instead.
+func daemonSocket(name string) (string, error) {
+	dir := filepath.Join(os.TempDir(), "stopwatch-"+strconv.Itoa(os.Getuid()))
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(runtime) {
+		dir = filepath.Join(runtime, "stopwatch")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".sock"), nil
+}
+
+// listenDaemon listens at sock, refusing to if another daemon is running
+// there. The socket of a daemon that did not exit cleanly is removed
+// first.
+func listenDaemon(sock string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already running at %s", sock)
+	}
+	if err := os.Remove(sock); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", sock)
+}
+
+// daemonRequest is a stop command waiting for the events
+type daemonRequest struct {
+	conn net.Conn
+	data bool // reply with the events, see daemonStopData
+}
+
+// daemonListener serves the commands of the clients of a daemon: each
+// connection sends one command, and gets its reply
+type daemonListener struct {
+	in   *remoteInput
+	ln   net.Listener
+	stop func() // ends the session
+
+	mu    sync.Mutex
+	stops []daemonRequest
+}
+
+// listenCommands passes the laps received at ln on into in, and calls stop
+// on the stop commands. Like the other listeners, it stops accepting
+// connections at the end of the session, closing ln, which removes the
+// socket.
+func listenCommands(in *remoteInput, ln net.Listener, stop func()) *daemonListener {
+	l := &daemonListener{in: in, ln: ln, stop: stop}
+	in.stops = append(in.stops, func() { ln.Close() })
+	in.wg.Add(1)
+	go l.accept()
+	return l
+}
+
+func (l *daemonListener) accept() {
+	defer l.in.wg.Done()
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			return
+		}
+		l.in.wg.Add(1)
+		go l.serveConn(conn)
+	}
+}
+
+// serveConn handles the command on conn. A client going away before it is
+// done only misses the reply.
+func (l *daemonListener) serveConn(conn net.Conn) {
+	defer l.in.wg.Done()
+	conn.SetDeadline(time.Now().Add(daemonTimeout))
+	line, err := bufio.NewReaderSize(conn, tcpMaxLine).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		conn.Close()
+		return
+	}
+	command := strings.TrimSpace(line)
+	switch {
+	case command == daemonStop || command == daemonStopData:
+		// replied to once the session has ended, see recordDaemon
+		l.mu.Lock()
+		l.stops = append(l.stops, daemonRequest{conn: conn, data: command == daemonStopData})
+		l.mu.Unlock()
+		l.stop()
+		return
+	case command == daemonLap || strings.HasPrefix(command, daemonLap+" "):
+		text := strings.TrimSpace(strings.TrimPrefix(command, daemonLap))
+		fmt.Fprintln(conn, l.in.send(remoteLine{text: text, source: sourceSocket, at: time.Now()}))
+	default:
+		fmt.Fprintf(conn, "error unknown command %q\n", command)
+	}
+	conn.Close()
+}
+
+// requests returns the stop commands received
+func (l *daemonListener) requests() []daemonRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stops
+}
+
+// recordDaemon records a session with the laps sent to ln, until ctx is
+// cancelled or a stop command is received. The events are written into
+// outFile, unless all the stop commands asked for them and got them.
+func recordDaemon(ctx context.Context, ln net.Listener, outFile string, opts OutputOptions, msgs io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	remote := newRemoteInput()
+	l := listenCommands(remote, ln, cancel)
+	events, err := Run(ctx, withCollectOptions(collectOptions{NoPrompt: true}), withRemote(remote), WithComment(opts.Comment),
+		WithMessages(msgs))
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	encode, prepared, prepOpts, err := prepareOutput(events, opts)
+	if err == nil {
+		err = encode(&data, prepared, prepOpts)
+	}
+	if err != nil {
+		return err
+	}
+	// the file is written unless the events were sent to a client, and
+	// none asked for the file; a client gone away gets nothing
+	sent, plain := false, false
+	requests := l.requests()
+	for _, req := range requests {
+		if !req.data {
+			plain = true
+			continue
+		}
+		req.conn.SetWriteDeadline(time.Now().Add(daemonTimeout))
+		if _, err := io.WriteString(req.conn, "ok\n"+data.String()); err == nil {
+			sent = true
+		}
+	}
+	own := !sent || plain
+	if own {
+		err = DumpEvents(outFile, events, opts)
+	}
+	for _, req := range requests {
+		if !req.data {
+			req.conn.SetWriteDeadline(time.Now().Add(daemonTimeout))
+			if err != nil {
+				fmt.Fprintln(req.conn, "error could not write output:", err)
+			} else {
+				fmt.Fprintln(req.conn, "ok", outFile)
+			}
+		}
+		req.conn.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("could not write output: %w", err)
+	}
+	if own {
+		fmt.Fprintln(msgs, "# Written to", outFile)
+	}
+	return nil
+}
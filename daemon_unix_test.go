@@ -0,0 +1,111 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemon(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	sock, err := daemonSocket("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := listenDaemon(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "build.csv")
+	done := make(chan error)
+	go func() {
+		done <- recordDaemon(context.Background(), ln, out, OutputOptions{Comment: "nightly"}, io.Discard)
+	}()
+
+	if _, err := listenDaemon(sock); err == nil {
+		t.Error("Expected a second daemon of the same name to be refused")
+	}
+	if reply, _, status := callDaemon(io.Discard, "build", "lap 2 compile done"); status != 0 || reply != "1" {
+		t.Errorf("Expected event 1, got %q, status %d", reply, status)
+	}
+	if _, _, status := callDaemon(io.Discard, "build", "lap exit"); status != exitRefused {
+		t.Errorf("Expected a reserved label to be refused, got status %d", status)
+	}
+	if _, _, status := callDaemon(io.Discard, "build", "reset"); status != exitRefused {
+		t.Errorf("Expected an unknown command to be refused, got status %d", status)
+	}
+	// a client going away without a command
+	if conn, err := net.Dial("unix", sock); err != nil {
+		t.Fatal(err)
+	} else {
+		conn.Close()
+	}
+	reply, data, status := callDaemon(io.Discard, "build", daemonStopData)
+	if status != 0 || reply != "" {
+		t.Fatalf("Expected the events, got %q, status %d", reply, status)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	events, comment, err := UnmarshalEventsCSV(strings.NewReader(data))
+	if err != nil || comment != "nightly" || len(events) != 3 || events[1].What != "compile done" || *events[1].Value != 2 {
+		t.Errorf("Unexpected events %+v, %q, %v", events, comment, err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("Expected no file, as the client took the events")
+	}
+	if _, err := os.Stat(sock); err == nil {
+		t.Error("Expected the socket to be removed")
+	}
+	if _, _, status := callDaemon(io.Discard, "build", daemonStop); status != exitUnreachable {
+		t.Errorf("Expected the daemon to be gone, got status %d", status)
+	}
+}
+
+func TestDaemonSignal(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "d.sock")
+	ln, err := listenDaemon(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.csv")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- recordDaemon(ctx, ln, out, OutputOptions{}, io.Discard) }()
+	if reply, _, err := sendDaemon(sock, "lap"); err != nil || reply != "ok 1" {
+		t.Errorf("Expected a tick, got %q, %v", reply, err)
+	}
+	// as by SIGTERM
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the daemon to stop")
+	}
+	if events, _, err := LoadCSV(out); err != nil || len(events) != 3 {
+		t.Errorf("Expected the events in the file, got %v, %v", events, err)
+	}
+}
+
+func TestValidateDaemonName(t *testing.T) {
+	for _, name := range []string{"build", "ci-2.run_1"} {
+		if err := validateDaemonName(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "a/b", "x y"} {
+		if err := validateDaemonName(name); err == nil {
+			t.Errorf("Expected error for %q", name)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runDecrypt(args []string) int {
+	fs := newFlagSet("decrypt", "<file.enc>")
+	outFile := fs.String("o", "", "Output file path, stderr or fd:N (default: stdout)")
+	completeFiles(fs, "o")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: could not open file:", err)
+		return 1
+	}
+	defer in.Close()
+	pass, err := readPassphrase(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: could not read passphrase:", err)
+		return 2
+	}
+	plain, err := Decrypt(in, pass)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	if f, ferr := outputStream(*outFile); ferr != nil {
+		err = ferr
+	} else if f != nil {
+		_, err = f.Write(plain)
+	} else {
+		err = os.WriteFile(*outFile, plain, 0666)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem writing output:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// cmdDel deletes the event with the sequence number arg, once the next
+// line confirms it
+func (s *Session) cmdDel(arg string, out io.Writer) {
+	s.deleteCommand(arg, true, out)
+}
+
+// cmdDelNow deletes the event with the sequence number arg at once
+func (s *Session) cmdDelNow(arg string, out io.Writer) {
+	s.deleteCommand(arg, false, out)
+}
+
+// deleteCommand handles "del" and "del!". Only ticks and marks can be
+// deleted; the events recorded by the stopwatch itself, such as the
+// sentinels and the pause controls, are kept.
+func (s *Session) deleteCommand(arg string, confirm bool, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	if arg == "" {
+		fmt.Fprintln(out, "# Usage: del <seq>")
+		return
+	}
+	i := reviewIndex(s.Events, arg)
+	if i < 0 {
+		fmt.Fprintf(out, "# No event with seq %q\n", arg)
+		return
+	}
+	evt := s.Events[i]
+	if isReserved(evt.What) && !isMark(evt.What) {
+		fmt.Fprintf(out, "# Event %d is %q, can not delete\n", evt.Seq, evt.What)
+		return
+	}
+	desc := fmt.Sprintf("%d %s %s", evt.Seq, evt.Timestamp.Local().Format("15:04:05.000"), evt.What)
+	if !confirm {
+		s.deleteEvent(i)
+		fmt.Fprintf(out, "# Deleted %s\n", desc)
+		return
+	}
+	fmt.Fprintf(out, "# Delete %s? Type y to confirm\n", desc)
+	s.askConfirm("# Delete? [y/N]> ", func(yes bool, out io.Writer) {
+		// other inputs may have recorded events in the meantime
+		i := reviewIndex(s.Events, strconv.FormatInt(evt.Seq, 10))
+		switch {
+		case !yes:
+			fmt.Fprintln(out, "# Not deleted")
+		case i < 0:
+			fmt.Fprintf(out, "# Event %d was dropped by -keep-last meanwhile\n", evt.Seq)
+		default:
+			s.deleteEvent(i)
+			fmt.Fprintf(out, "# Deleted %s\n", desc)
+		}
+	})
+}
+
+// askConfirm shows prompt instead of the usual one, and passes answer
+// whether the next input line is "y" (or "yes"). That line is not handled
+// otherwise; an empty line answers no.
+func (s *Session) askConfirm(prompt string, answer func(yes bool, out io.Writer)) {
+	s.confirm, s.confirmPrompt = answer, prompt
+}
+
+// deleteEvent removes the event at index i from Events. The sequence
+// numbers of the later events are kept until the session is finished, so
+// that they still name the same events; the laps are computed over the
+// gap, as the neighbors of the event now follow each other.
+func (s *Session) deleteEvent(i int) {
+	s.Events = append(s.Events[:i], s.Events[i+1:]...)
+	if i < s.groupStart {
+		s.groupStart--
+	}
+	s.deleted++
+	s.resequence = true
+	s.refreshLaps()
+	s.edited()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionDelete(t *testing.T) {
+	at := 0
+	sess := newSession("", collectOptions{MinLap: 15 * time.Second, MaxEvents: 5})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	for _, line := range []string{"a", "oops", "b", "mark m"} {
+		at += 10
+		sess.handleLine(line, &out)
+	}
+	if sess.Events[3].Flag != flagShort {
+		t.Fatalf("Expected a short lap before the deletion, got %+v", sess.Events[3])
+	}
+	out.Reset()
+	for _, line := range []string{"del 2", "", "del 2", "y", "del! 4", "del 0", "del 9", "del"} {
+		sess.handleLine(line, &out)
+	}
+	want := "# Delete 2 " + sess.startAt.Add(20*time.Second).Local().Format("15:04:05.000") + " oops? Type y to confirm\n" +
+		"# Not deleted\n" +
+		"# Delete 2 " + sess.startAt.Add(20*time.Second).Local().Format("15:04:05.000") + " oops? Type y to confirm\n" +
+		"# Deleted 2 " + sess.startAt.Add(20*time.Second).Local().Format("15:04:05.000") + " oops\n" +
+		"# Deleted 4 " + sess.startAt.Add(40*time.Second).Local().Format("15:04:05.000") + " mark:m\n" +
+		"# Event 0 is \"enter\", can not delete\n" +
+		"# No event with seq \"9\"\n" +
+		"# Usage: del <seq>\n"
+	if out.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+	// the lap of b spans the gap, and is no longer short
+	if evt := sess.Events[2]; evt.What != "b" || evt.Flag != "" {
+		t.Errorf("Expected the flag of b to be cleared, got %+v", evt)
+	}
+	if laps := LapDurations(sess.Events); !reflect.DeepEqual(laps, seconds(10, 20)) {
+		t.Errorf("Expected the laps across the gap, got %v", laps)
+	}
+
+	// the next event gets a new sequence number, and deleted events do not
+	// count towards -max-events
+	at = 50
+	for _, line := range []string{"c", "d", "e", "f"} {
+		sess.handleLine(line, &out)
+	}
+	var seqs []int64
+	for _, evt := range sess.Events {
+		seqs = append(seqs, evt.Seq)
+	}
+	if !reflect.DeepEqual(seqs, []int64{0, 1, 3, 5, 6, 7}) || !strings.HasSuffix(out.String(), "# Reached 5 events, not recorded\n") {
+		t.Errorf("Expected the sequence numbers to be kept, got %v, %q", seqs, out.String())
+	}
+	sess.finish()
+	for i, evt := range sess.Events {
+		if evt.Seq != int64(i) {
+			t.Errorf("Expected the events to be renumbered at the end, got %d at %d", evt.Seq, i)
+		}
+	}
+}
+
+func TestSessionDeletePrompt(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	var out bytes.Buffer
+	sess.start()
+	sess.handleLine("a", &out)
+	sess.handleLine("del 1", &out)
+	if got := sess.prompt(); got != "# Delete? [y/N]> " {
+		t.Errorf("Expected the question as the prompt, got %q", got)
+	}
+	// the answer is not recorded, whatever it is
+	sess.handleLine("pause", &out)
+	if got := sess.prompt(); got != "# Waiting for [2]> " || len(sess.Events) != 2 {
+		t.Errorf("Expected the usual prompt and no new event, got %q, %v", got, sess.Events)
+	}
+}
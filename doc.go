@@ -0,0 +1,32 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Stopwatch-go records the time of events typed into the terminal, or
+// received from the network, watched files and processes, into CSV and
+// other formats. Run "stopwatch-go -h" for the flags and the subcommands.
+//
+// The recording can also be embedded: Run records a session with the tick
+// sources and limits given as options, and returns its events. For
+// example, ticking automatically ten times a second for three seconds:
+//
+//	events, err := Run(ctx,
+//		WithInterval(100*time.Millisecond),
+//		WithTimeout(3*time.Second),
+//		WithEventFunc(func(evt Event) { fmt.Println(evt.Seq, evt.What) }),
+//	)
+//
+// Cancelling ctx ends the run like ctrl-c: the events recorded so far are
+// returned, with "exit" as the last one. A Stopwatch records into the same
+// session from several goroutines at once.
+package main
@@ -0,0 +1,145 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Styles of the duration columns, see OutputOptions.DurationStyle
+const (
+	durationStyleSeconds = "seconds" // the default: e.g. 83.4
+	durationStyleGo      = "go"      // as time.Duration.String: e.g. 1m23.4s
+	durationStyleBoth    = "both"    // Go style, with the seconds in a "<column>_s" column after it
+)
+
+// durationStyles lists the values of -duration-style
+var durationStyles = []string{durationStyleSeconds, durationStyleGo, durationStyleBoth}
+
+// secondsSuffix is appended to the name of a duration column to name the
+// column of its seconds, see durationStyleBoth
+const secondsSuffix = "_s"
+
+// durationColumns are the columns holding durations
+var durationColumns = []string{"offset", "vs_target"}
+
+// isDurationColumn reports whether name is one of durationColumns
+func isDurationColumn(name string) bool {
+	for _, col := range durationColumns {
+		if name == col {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSeconds formats seconds in the shortest form that parses back to
+// the same number, without an exponent: e.g. "83.4" or "0.000001"
+func formatSeconds(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// secondsDuration converts seconds into a duration, rounded to the nearest
+// nanosecond
+func secondsDuration(v float64) time.Duration {
+	return time.Duration(math.Round(v * float64(time.Second)))
+}
+
+// parseDurationCell parses a duration column: a number of seconds, such as
+// "83.4", or a Go style duration, such as "1m23.4s"
+func parseDurationCell(value string) (time.Duration, error) {
+	if strings.Trim(value, "+-.0123456789") == "" {
+		return parseOffset(value)
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: expected seconds or e.g. 1m23.4s", value)
+	}
+	return d, nil
+}
+
+// parseSecondsCell is like parseDurationCell, but returns seconds: the
+// plain ones as they are, for the full precision of a float
+func parseSecondsCell(value string) (float64, error) {
+	if strings.Trim(value, "+-.0123456789") == "" {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: expected seconds or e.g. 1m23.4s", value)
+		}
+		return v, nil
+	}
+	d, err := parseDurationCell(value)
+	return d.Seconds(), err
+}
+
+// duration returns the named duration column of e, and false if the
+// column is not a duration or e has none
+func (e Event) duration(name string) (time.Duration, bool) {
+	switch {
+	case name == "offset" && e.Offset != nil:
+		return *e.Offset, true
+	case name == "vs_target" && e.VsTarget != nil:
+		return secondsDuration(*e.VsTarget), true
+	}
+	return 0, false
+}
+
+// goDurations reports whether opts writes the duration columns in Go style
+func (opts OutputOptions) goDurations() bool {
+	return opts.DurationStyle == durationStyleGo || opts.DurationStyle == durationStyleBoth
+}
+
+// startRelative reports whether opts writes the "ts" column as seconds
+// since opts.Start
+func (opts OutputOptions) startRelative() bool {
+	return opts.TSStyle == tsStyleOffsetSeconds && !opts.Start.IsZero()
+}
+
+// cell returns the named column of evt, with the durations written in the
+// style of opts.DurationStyle and the timestamp in that of opts.TSStyle
+func (opts OutputOptions) cell(evt Event, name string) string {
+	if name == "ts" && opts.startRelative() {
+		return formatOffset(evt.Timestamp.Sub(opts.Start))
+	}
+	if opts.goDurations() {
+		if d, ok := evt.duration(name); ok {
+			return d.String()
+		}
+	}
+	return evt.Cell(name)
+}
+
+// fillCells sets row[i] to the named column names[i] of evt, see cell
+func (opts OutputOptions) fillCells(evt Event, row []string, names []string) {
+	for i, name := range names {
+		row[i] = opts.cell(evt, name)
+	}
+}
+
+// records converts events into records with the columns of opts.Header,
+// the header first, see cell
+func (opts OutputOptions) records(events []Event) [][]string {
+	header := opts.Header()
+	records := make([][]string, 0, len(events)+1)
+	records = append(records, opts.headerText(header))
+	records = append(records, newRows(len(events), len(header))...)
+	f := rowFiller{opts: opts, names: header}
+	f.fill(records[1:], events)
+	return records
+}
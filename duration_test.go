@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatSeconds(t *testing.T) {
+	for v, want := range map[float64]string{
+		83.4: "83.4", -0.5: "-0.5", 0.000001: "0.000001", 1e21: "1000000000000000000000",
+	} {
+		if got := formatSeconds(v); got != want {
+			t.Errorf("%v: expected %q, got %q", v, want, got)
+		}
+	}
+}
+
+func TestParseDurationCell(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"83.4": 83400 * time.Millisecond, "1m23.4s": 83400 * time.Millisecond, "-0.25": -250 * time.Millisecond,
+		"-250ms": -250 * time.Millisecond, "1m": time.Minute, "0s": 0,
+	} {
+		if got, err := parseDurationCell(value); err != nil || got != want {
+			t.Errorf("%q: expected %v, got %v, %v", value, want, got, err)
+		}
+	}
+	for _, value := range []string{"", "1e3", "x", "1.2.3", "1 m"} {
+		if _, err := parseDurationCell(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+	if v, err := parseSecondsCell("0.1"); err != nil || v != 0.1 {
+		t.Errorf("Expected 0.1 exactly, got %v, %v", v, err)
+	}
+}
+
+func TestEncodeCSVDurationStyle(t *testing.T) {
+	events := testEvents(time.Second, 82400*time.Millisecond)
+	vs := -0.000001
+	events[1].VsTarget = &vs
+	opts := OutputOptions{Since: events[0].Timestamp, Columns: []string{"vs_target"}}
+	for style, want := range map[string]string{
+		durationStyleSeconds: "# stopwatch-schema: 2\nseq,ts,offset,what,vs_target\n" +
+			"0,2022-04-08T20:00:00Z,0,enter,\n" +
+			"1,2022-04-08T20:00:01Z,1,tick,-0.000001\n" +
+			"2,2022-04-08T20:01:23.4Z,83.4,exit,\n",
+		durationStyleGo: "# stopwatch-schema: 2\nseq,ts,offset,what,vs_target\n" +
+			"0,2022-04-08T20:00:00Z,0s,enter,\n" +
+			"1,2022-04-08T20:00:01Z,1s,tick,-1µs\n" +
+			"2,2022-04-08T20:01:23.4Z,1m23.4s,exit,\n",
+		durationStyleBoth: "# stopwatch-schema: 2\nseq,ts,offset,offset_s,what,vs_target,vs_target_s\n" +
+			"0,2022-04-08T20:00:00Z,0s,0,enter,,\n" +
+			"1,2022-04-08T20:00:01Z,1s,1,tick,-1µs,-0.000001\n" +
+			"2,2022-04-08T20:01:23.4Z,1m23.4s,83.4,exit,,\n",
+	} {
+		opts.DurationStyle = style
+		var buf bytes.Buffer
+		encode, prepared, opts, err := prepareOutput(events, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := encode(&buf, prepared, opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", style, want, got)
+		}
+		back, _, err := UnmarshalEventsCSV(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", style, err)
+		}
+		if !reflect.DeepEqual(back, prepared) {
+			t.Errorf("%s: expected the events back:\n%v\ngot:\n%v", style, prepared, back)
+		}
+	}
+}
+
+func TestEncodeNDJSONDurationStyle(t *testing.T) {
+	events := testEvents(1500 * time.Millisecond)
+	opts := OutputOptions{Since: events[0].Timestamp, DurationStyle: durationStyleBoth, Format: "ndjson"}
+	var buf bytes.Buffer
+	encode, prepared, opts, err := prepareOutput(events, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encode(&buf, prepared, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"seq":0,"ts":"2022-04-08T20:00:00Z","offset":"0s","offset_s":0,"what":"enter"}` + "\n" +
+		`{"seq":1,"ts":"2022-04-08T20:00:01.5Z","offset":"1.5s","offset_s":1.5,"what":"exit"}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
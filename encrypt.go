@@ -0,0 +1,228 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// passphraseEnv is the environment variable consulted before prompting
+const passphraseEnv = "STOPWATCH_PASSPHRASE"
+
+// Encrypted file format, version 1:
+//
+//	header: "SWENC" | version (1) | scrypt log2(N) | scrypt r | scrypt p | salt (16)
+//	chunks: ChaCha20-Poly1305 sealed chunks of encChunkSize plaintext bytes;
+//	        the last chunk may be shorter (or empty)
+//
+// The key is derived from the passphrase with scrypt, using a random salt
+// per file. Each chunk is sealed with the nonce: counter (11 bytes, big
+// endian) | last-chunk flag (1 byte), and the header as additional data, so
+// that reordering, truncating and header tampering are all detected.
+const (
+	encMagic     = "SWENC"
+	encVersion   = 1
+	encSaltSize  = 16
+	encChunkSize = 64 * 1024
+	encHeaderLen = len(encMagic) + 4 + encSaltSize
+
+	// scrypt parameters for new files
+	encLogN = 15
+	encR    = 8
+	encP    = 1
+)
+
+// errDecrypt is returned when decryption fails: wrong passphrase or
+// corrupted data, which can not be told apart.
+var errDecrypt = errors.New("decryption failed: wrong passphrase or corrupted file")
+
+// encryptWriter encrypts everything written to it into an underlying writer.
+// Close must be called to write the final chunk.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	buf     []byte
+	counter uint64
+}
+
+// NewEncryptWriter returns a writer that encrypts into w with a key derived
+// from passphrase. The header is written immediately.
+func NewEncryptWriter(w io.Writer, passphrase []byte) (io.WriteCloser, error) {
+	header := make([]byte, 0, encHeaderLen)
+	header = append(header, encMagic...)
+	header = append(header, encVersion, encLogN, encR, encP)
+	salt := make([]byte, encSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+
+	aead, err := encDeriveAEAD(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := encChunkSize - len(e.buf)
+		if take > len(p) {
+			take = len(p)
+		}
+		e.buf = append(e.buf, p[:take]...)
+		p = p[take:]
+		// a full chunk is only sealed once more data arrives, since the
+		// last chunk must be marked as such
+		if len(e.buf) == encChunkSize && len(p) > 0 {
+			if err := e.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close seals and writes the final chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	out := e.aead.Seal(nil, encNonce(e.counter, last), e.buf, e.header)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+// encNonce builds the nonce of chunk number counter
+func encNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encDeriveAEAD derives the cipher from passphrase and the parameters in header
+func encDeriveAEAD(passphrase, header []byte) (cipher.AEAD, error) {
+	logN, r, p := header[len(encMagic)+1], header[len(encMagic)+2], header[len(encMagic)+3]
+	if logN < 10 || logN > 22 || r == 0 || p == 0 {
+		return nil, fmt.Errorf("unsupported key derivation parameters")
+	}
+	salt := header[len(encMagic)+4:]
+	key, err := scrypt.Key(passphrase, salt, 1<<logN, int(r), int(p), chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+// Decrypt decrypts data produced by an encryptWriter. The whole input is
+// authenticated before anything is returned, so a wrong passphrase never
+// yields partial plaintext.
+func Decrypt(r io.Reader, passphrase []byte) ([]byte, error) {
+	in := bufio.NewReader(r)
+	header := make([]byte, encHeaderLen)
+	if _, err := io.ReadFull(in, header); err != nil || !bytes.HasPrefix(header, []byte(encMagic)) {
+		return nil, fmt.Errorf("not an encrypted stopwatch file")
+	}
+	if v := header[len(encMagic)]; v != encVersion {
+		return nil, fmt.Errorf("unsupported encryption format version %d", v)
+	}
+	aead, err := encDeriveAEAD(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var plain []byte
+	chunk := make([]byte, encChunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(in, chunk)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return nil, err
+		}
+		if !last {
+			// a full sized chunk is the last one only if nothing follows
+			if _, err := in.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		plain, err = aead.Open(plain, encNonce(counter, last), chunk[:n], header)
+		if err != nil {
+			return nil, errDecrypt
+		}
+		if last {
+			return plain, nil
+		}
+	}
+}
+
+// readPassphrase returns the passphrase from $STOPWATCH_PASSPHRASE, or
+// prompts for it on the terminal. With confirm, the passphrase is asked
+// twice and the answers must match.
+func readPassphrase(confirm bool) ([]byte, error) {
+	if pass, ok := os.LookupEnv(passphraseEnv); ok {
+		if pass == "" {
+			return nil, fmt.Errorf("$%s is empty", passphraseEnv)
+		}
+		return []byte(pass), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("stdin is not a terminal; set the passphrase in $%s", passphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "# Passphrase: ")
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "# Repeat passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+	return pass, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func encryptBytes(t *testing.T, plain, passphrase []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	pass := []byte("hunter2")
+	for _, size := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3 * encChunkSize} {
+		plain := bytes.Repeat([]byte("x"), size)
+		enc := encryptBytes(t, plain, pass)
+		if bytes.Contains(enc, []byte("xxxx")) {
+			t.Errorf("size %d: Expected ciphertext not to contain plaintext", size)
+		}
+		got, err := Decrypt(bytes.NewReader(enc), pass)
+		if err != nil {
+			t.Errorf("size %d: Expected decryption to succeed, got: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: Decrypted %d bytes, expected %d", size, len(got), len(plain))
+		}
+	}
+}
+
+func TestDecryptFailures(t *testing.T) {
+	pass := []byte("hunter2")
+	enc := encryptBytes(t, bytes.Repeat([]byte("x"), 2*encChunkSize+10), pass)
+	chunk := encChunkSize + 16
+
+	tampered := append([]byte(nil), enc...)
+	tampered[len(tampered)-1] ^= 1
+
+	badHeader := append([]byte(nil), enc...)
+	badHeader[encHeaderLen-1] ^= 1
+
+	for name, data := range map[string][]byte{
+		"wrong passphrase": enc,
+		"tampered":         tampered,
+		"header tampered":  badHeader,
+		"truncated":        enc[:encHeaderLen+chunk],
+		"chunk removed":    append(append([]byte(nil), enc[:encHeaderLen+chunk]...), enc[encHeaderLen+2*chunk:]...),
+		"header only":      enc[:encHeaderLen],
+	} {
+		key := pass
+		if name == "wrong passphrase" {
+			key = []byte("hunter3")
+		}
+		got, err := Decrypt(bytes.NewReader(data), key)
+		if err != errDecrypt {
+			t.Errorf("%s: Expected errDecrypt, got: %v", name, err)
+		}
+		if got != nil {
+			t.Errorf("%s: Expected no plaintext, got %d bytes", name, len(got))
+		}
+	}
+}
+
+func TestDecryptHeader(t *testing.T) {
+	enc := encryptBytes(t, []byte("seq,ts,what\n"), []byte("pw"))
+
+	if _, err := Decrypt(strings.NewReader("seq,ts,what\n"), []byte("pw")); err == nil {
+		t.Error("Expected plain CSV to be rejected")
+	}
+
+	future := append([]byte(nil), enc...)
+	future[len(encMagic)] = encVersion + 1
+	_, err := Decrypt(bytes.NewReader(future), []byte("pw"))
+	if err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("Expected version error, got: %v", err)
+	}
+}
+
+func TestDumpEventsEncrypted(t *testing.T) {
+	path := t.TempDir() + "/out.enc"
+	opts := OutputOptions{Format: "org", Passphrase: []byte("pw")}
+	if err := DumpEvents(path, testEvents(), opts); err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	if err := EncodeOrg(&want, testEvents(), opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := Decrypt(f, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("Expected decrypted output:\n%s\ngot:\n%s", want.Bytes(), got)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// metaPrefix starts the metadata lines of a recording, such as those of
+// -with-env: "# meta.<key>: <value>", written after the schema line
+const metaPrefix = "# meta."
+
+// metaField is a line of metadata of a recording
+type metaField struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// metaEscapes escapes the line breaks of the metadata values, so that each
+// stays on its line
+var metaEscapes = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// formatMetaLine formats the line of metaPrefix, without the line end
+func formatMetaLine(f metaField) string {
+	return metaPrefix + f.Key + ": " + metaEscapes.Replace(f.Value)
+}
+
+// parseMetaLine parses a line written by formatMetaLine, and returns false
+// if it is not one
+func parseMetaLine(line string) (metaField, bool) {
+	if !strings.HasPrefix(line, metaPrefix) {
+		return metaField{}, false
+	}
+	// the empty fields of a line written as a CSV record are ignored
+	key, value, ok := strings.Cut(strings.TrimRight(line[len(metaPrefix):], ",;\t\r\n"), ": ")
+	if !ok || key == "" || strings.ContainsAny(key, " :") {
+		return metaField{}, false
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return metaField{key, b.String()}, true
+}
+
+// sensitiveName matches the names of the flags and the environment
+// variables whose values are left out of the snapshot of -with-env
+var sensitiveName = regexp.MustCompile(`(?i)token|secret|password|passphrase|webhook|header`)
+
+// redactedValue replaces a value left out of the snapshot of -with-env
+const redactedValue = "REDACTED"
+
+// envPrefix starts the names of the environment variables of the program,
+// included in the snapshot of -with-env
+const envPrefix = "STOPWATCH_"
+
+// envSnapshot describes how the program was run, for -with-env: the command
+// line args, the working directory, the host name, the platform and the
+// version of the program, and the environment variables of envPrefix
+// among environ. The values of the flags and the variables whose names
+// look like they hold a secret, see sensitiveName, are left out.
+func envSnapshot(args, environ []string) []metaField {
+	var meta []metaField
+	add := func(key, value string) {
+		meta = append(meta, metaField{key, value})
+	}
+	add("command", strings.Join(redactArgs(args), " "))
+	if dir, err := os.Getwd(); err == nil {
+		add("cwd", dir)
+	}
+	if host, err := os.Hostname(); err == nil {
+		add("host", host)
+	}
+	add("platform", runtime.GOOS+"/"+runtime.GOARCH)
+	v := newVersionInfo(debug.ReadBuildInfo())
+	add("version", v.Version)
+	if v.Revision != "" {
+		add("revision", v.Revision)
+	}
+	var vars []string
+	for _, kv := range environ {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, envPrefix) && !sensitiveName.MatchString(name) {
+			vars = append(vars, kv)
+		}
+	}
+	sort.Strings(vars)
+	for _, kv := range vars {
+		name, value, _ := strings.Cut(kv, "=")
+		add("env."+name, value)
+	}
+	return meta
+}
+
+// redactArgs returns the command line args quoted for a shell, with the
+// values of the flags named like secrets, see sensitiveName, replaced by
+// redactedValue, and so are the query and the password of the URLs, see
+// redactURL
+func redactArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	redactNext := false
+	for i, arg := range args {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch {
+		case redactNext:
+			arg = redactedValue
+			redactNext = false
+		case arg == "--" || !strings.HasPrefix(arg, "-") || !sensitiveName.MatchString(name):
+			arg = redactURL(arg)
+		case hasValue:
+			arg = arg[:strings.Index(arg, "=")+1] + redactedValue
+		default:
+			redactNext = true
+		}
+		quoted[i] = shellQuote(arg)
+	}
+	return quoted
+}
+
+// redactURL replaces the query and the password of an http:// or https://
+// URL given as arg, or as the value of a flag -name=URL, by redactedValue:
+// the query of a presigned -o URL is the signature giving access to it
+func redactURL(arg string) string {
+	prefix, value := "", arg
+	if name, v, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(name, "-") {
+		prefix, value = name+"=", v
+	}
+	if !isUpload(value) {
+		return arg
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return prefix + redactedValue
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redactedValue
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+	return prefix + u.String()
+}
+
+// shellQuote quotes s for a POSIX shell, unless it needs no quoting
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+/.,:@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
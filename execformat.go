@@ -0,0 +1,96 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execFormatPrefix starts the -format of an external encoder program,
+// e.g. "exec:./my-encoder"
+const execFormatPrefix = "exec:"
+
+// execEncoderTimeout limits the time an external encoder may run
+const execEncoderTimeout = time.Minute
+
+// execEncoder returns the Encoder running program: the events are written
+// into its stdin as NDJSON, with the comment and the session name in the
+// environment (see execEnv), and its stdout is the output. It fails if the
+// program exits with an error, or runs longer than timeout, and
+// the error includes what the program wrote into stderr.
+//
+// The stdin, stdout and stderr of the program are temporary files rather
+// than pipes: the program is killed at the timeout, and with pipes, waiting
+// for it would still hang as long as anything it started kept them open.
+// The output is only copied once the program has succeeded.
+func execEncoder(program string, timeout time.Duration) Encoder {
+	return func(out io.Writer, events []Event, opts OutputOptions) error {
+		var files [3]*os.File
+		for i := range files {
+			f, err := os.CreateTemp("", "stopwatch-exec-*")
+			if err != nil {
+				return fmt.Errorf("could not create a temporary file: %w", err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			files[i] = f
+		}
+		stdin, stdout, stderr := files[0], files[1], files[2]
+		if err := EncodeNDJSON(stdin, events, opts); err != nil {
+			return err
+		}
+		if _, err := stdin.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, program)
+		cmd.Env = append(os.Environ(), execEnv(opts)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				err = fmt.Errorf("did not finish within %s", timeout)
+			}
+			msg, _ := os.ReadFile(stderr.Name())
+			if msg := strings.TrimSpace(string(msg)); msg != "" {
+				return fmt.Errorf("encoder %s: %v: %s", program, err, msg)
+			}
+			return fmt.Errorf("encoder %s: %v", program, err)
+		}
+		if _, err := stdout.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.Copy(out, stdout)
+		return err
+	}
+}
+
+// execEnv returns the environment variables telling an external encoder
+// about the session
+func execEnv(opts OutputOptions) []string {
+	return []string{
+		"STOPWATCH_COMMENT=" + opts.Comment,
+		"STOPWATCH_NAME=" + opts.Name,
+		"STOPWATCH_SCHEMA=" + strconv.Itoa(schemaVersion),
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript writes an executable shell script into dir
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecEncoder(t *testing.T) {
+	dir := t.TempDir()
+	events := testEvents(time.Second)
+	var ndjson bytes.Buffer
+	EncodeNDJSON(&ndjson, events, OutputOptions{})
+
+	path := filepath.Join(dir, "out.txt")
+	enc := writeScript(t, dir, "enc", `echo "$STOPWATCH_NAME: $STOPWATCH_COMMENT ($STOPWATCH_SCHEMA)"; cat`)
+	if err := DumpEvents(path, events, OutputOptions{Format: execFormatPrefix + enc, Name: "run-1", Comment: "warm up"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "run-1: warm up (2)\n"+ndjson.String() {
+		t.Errorf("Expected the header and the events, got:\n%s", got)
+	}
+
+	// reading only a part of the events is fine
+	head := writeScript(t, dir, "head", "head -c 1 >/dev/null; echo done")
+	var buf bytes.Buffer
+	if err := execEncoder(head, time.Minute)(&buf, testEvents(make([]time.Duration, 20000)...), OutputOptions{}); err != nil ||
+		buf.String() != "done\n" {
+		t.Errorf("Expected the output of an encoder reading only a part, got %q, %v", buf.String(), err)
+	}
+
+	fail := writeScript(t, dir, "fail", "echo partial; echo 'no can do' >&2; exit 3")
+	buf.Reset()
+	if err := execEncoder(fail, time.Minute)(&buf, events, OutputOptions{}); err == nil ||
+		!strings.Contains(err.Error(), "exit status 3: no can do") || buf.Len() != 0 {
+		t.Errorf("Expected the failure with its stderr and no output, got %v, %q", err, buf.String())
+	}
+
+	// a background process keeping the stdout open does not hold it up
+	hang := writeScript(t, dir, "hang", "sleep 5 & sleep 5")
+	start := time.Now()
+	if err := execEncoder(hang, 100*time.Millisecond)(&buf, events, OutputOptions{}); err == nil ||
+		!strings.Contains(err.Error(), "did not finish within 100ms") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Expected the encoder to be killed at the timeout, took %s", d)
+	}
+
+	if err := (OutputOptions{Format: execFormatPrefix}).Validate(); err == nil {
+		t.Error("Expected an error without a program")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// eventFilter is a condition of -filter: the named column of an event
+// equal to value, or with negate, different from it. Names other than the
+// built-in columns are attributes, see Event.Cell.
+type eventFilter struct {
+	column string
+	value  string
+	negate bool
+}
+
+// parseFilter parses a -filter condition, column=value or column!=value
+func parseFilter(s string) (eventFilter, error) {
+	var f eventFilter
+	var ok bool
+	if f.column, f.value, ok = strings.Cut(s, "!="); ok {
+		f.negate = true
+	} else if f.column, f.value, ok = strings.Cut(s, "="); !ok {
+		return f, fmt.Errorf("%q: expected column=value or column!=value, e.g. what!=heartbeat", s)
+	}
+	if !knownColumns()[f.column] && validateAttrKey(f.column) != nil {
+		return f, fmt.Errorf("%q: %q is not a column nor an attribute name", s, f.column)
+	}
+	return f, nil
+}
+
+// match reports whether evt meets the condition
+func (f eventFilter) match(evt Event) bool {
+	return (evt.Cell(f.column) == f.value) != f.negate
+}
+
+// keep reports whether evt is written with the conditions of -filter: the
+// "enter" and "exit" events always are, so that the output still reads
+// back as a session
+func (opts OutputOptions) keep(evt Event) bool {
+	if isSentinel(evt.What) {
+		return true
+	}
+	for _, f := range opts.Filters {
+		if !f.match(evt) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterEvents returns the events kept by opts.keep
+func filterEvents(events []Event, opts OutputOptions) []Event {
+	if len(opts.Filters) == 0 {
+		return events
+	}
+	kept := make([]Event, 0, len(events))
+	for _, evt := range events {
+		if opts.keep(evt) {
+			kept = append(kept, evt)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilterEvents(t *testing.T) {
+	events := testEvents(time.Second, time.Second, time.Second, time.Second)
+	events[1].What = labelHeartbeat
+	events[2].Attrs = map[string]string{"lane": "3"}
+	var opts OutputOptions
+	for _, s := range []string{"what!=heartbeat", "lane=3"} {
+		f, err := parseFilter(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Filters = append(opts.Filters, f)
+	}
+	var seqs []int64
+	for _, evt := range filterEvents(events, opts) {
+		seqs = append(seqs, evt.Seq)
+	}
+	if want := []int64{0, 2, 4}; !reflect.DeepEqual(seqs, want) {
+		t.Errorf("Expected the events %v, got %v", want, seqs)
+	}
+
+	for _, s := range []string{"what", "lane~3", "=x", "a b=c"} {
+		if _, err := parseFilter(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func runFollow(args []string) int {
+	fs := newFlagSet("follow", "<file.csv>")
+	interval := fs.Duration("interval", 250*time.Millisecond, "How often to check the file for new records")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() != 1 || *interval <= 0 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	t := &fileTail{path: path, out: os.Stderr}
+	var p lapPrinter
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true // after reading what is left
+		case <-ticker.C:
+		}
+		events, err := t.poll()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			return 1
+		}
+		p.print(os.Stdout, events)
+	}
+	fmt.Println()
+	spark := SparkOptions{Width: terminalWidth(os.Stdout), ASCII: !unicodeLocale()}
+	if err := writeSummary(os.Stdout, "", ComputeStats(p.events), spark); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	return 0
+}
+
+// lapPrinter prints the laps closed by the events followed, with running
+// statistics
+type lapPrinter struct {
+	events []Event
+	laps   int
+	sum    time.Duration
+}
+
+// print prints the laps closed by events, appended to the events seen so far
+func (p *lapPrinter) print(out io.Writer, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	p.events = append(p.events, events...)
+	n := 0
+	forEachLap(p.events, func(evt Event, lap time.Duration) {
+		if n++; n <= p.laps {
+			return
+		}
+		p.laps, p.sum = n, p.sum+lap
+		elapsed := evt.Timestamp.Sub(p.events[0].Timestamp)
+		fmt.Fprintf(out, "Lap %d: %s %s (avg: %s, elapsed: %s)\n", n, formatDuration(lap), evt.What,
+			formatDuration(p.sum/time.Duration(n)), formatDuration(elapsed))
+	})
+}
+
+// fileTail reads the records appended to a CSV file, like "tail -F": a
+// file truncated or replaced, e.g. by rotation, is read again from the
+// start
+type fileTail struct {
+	path   string
+	out    io.Writer // notes on the file changing
+	f      *os.File
+	offset int64
+	csv    csvTail
+}
+
+// poll returns the events of the records appended since the previous call
+func (t *fileTail) poll() ([]Event, error) {
+	if t.f == nil {
+		f, err := os.Open(t.path)
+		if os.IsNotExist(err) {
+			return nil, nil // still waiting for it to reappear
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.f, t.offset, t.csv = f, 0, csvTail{}
+	}
+	data, err := io.ReadAll(t.f)
+	if err != nil {
+		return nil, err
+	}
+	t.offset += int64(len(data))
+	events := t.parse(data)
+
+	// the rest of the old file has been read above
+	current, err := t.f.Stat()
+	if err != nil {
+		return events, err
+	}
+	switch fi, err := os.Stat(t.path); {
+	case os.IsNotExist(err):
+		fmt.Fprintf(t.out, "# %s removed, waiting for it to reappear\n", t.path)
+		t.close()
+	case err != nil:
+		return events, err
+	case !os.SameFile(fi, current):
+		fmt.Fprintf(t.out, "# %s replaced, reading from the start\n", t.path)
+		t.close()
+	case current.Size() < t.offset:
+		fmt.Fprintf(t.out, "# %s truncated, reading from the start\n", t.path)
+		if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+			return events, err
+		}
+		t.offset, t.csv = 0, csvTail{}
+	}
+	return events, nil
+}
+
+// parse parses data with t.csv, skipping the invalid records with a warning
+func (t *fileTail) parse(data []byte) []Event {
+	var events []Event
+	for {
+		evts, err := t.csv.feed(data)
+		events = append(events, evts...)
+		if err == nil {
+			return events
+		}
+		fmt.Fprintf(t.out, "# WARNING: %s: %v\n", t.path, err)
+		data = nil // continue after the invalid record
+	}
+}
+
+func (t *fileTail) close() {
+	t.f.Close()
+	t.f = nil
+}
+
+// csvTail parses CSV data written by MarshallEventsCSV incrementally, as
+// it is appended. Only complete lines are parsed; the rest is kept until
+// the newline arrives.
+type csvTail struct {
+	header   []string
+	preamble csvPreamble // the comment and metadata lines before the header
+	line     int         // number of complete lines seen
+	partial  []byte      // the data after the last complete line
+	record   []byte      // the lines of a record with a quoted newline
+	skip     bool        // the schema is not supported, so nothing more is parsed
+}
+
+// feed parses the complete lines in the data seen so far and data. On an
+// invalid record, the events parsed before it are returned with the error;
+// feed may then be called again to continue after it.
+func (t *csvTail) feed(data []byte) ([]Event, error) {
+	t.partial = append(t.partial, data...)
+	var events []Event
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			return events, nil
+		}
+		line := t.partial[:i+1]
+		t.partial = t.partial[i+1:]
+		t.line++
+		if t.skip {
+			continue
+		}
+		if t.record == nil {
+			trimmed := bytes.TrimSpace(line)
+			if len(trimmed) == 0 {
+				continue
+			}
+			if trimmed[0] == '#' {
+				if t.header == nil && t.preamble.lines == t.line-1 {
+					if _, err := t.preamble.add(string(trimmed)); err != nil {
+						t.skip = true
+						return events, fmt.Errorf("line %d: %w", t.line, err)
+					}
+				}
+				continue
+			}
+		}
+		t.record = append(t.record, line...)
+		if bytes.Count(t.record, []byte(`"`))%2 != 0 {
+			continue // a quoted field continues on the next line
+		}
+		evt, err := t.parseRecord()
+		t.record = nil
+		if err != nil {
+			return events, fmt.Errorf("line %d: %w", t.line, err)
+		}
+		if evt != nil {
+			events = append(events, *evt)
+		}
+	}
+}
+
+// parseRecord parses t.record as the header, or as an event once the
+// header is known
+func (t *csvTail) parseRecord() (*Event, error) {
+	record, err := csv.NewReader(bytes.NewReader(t.record)).Read()
+	if err != nil {
+		return nil, err
+	}
+	if t.header == nil {
+		if err := checkHeader(record, t.preamble.schema()); err != nil {
+			return nil, err
+		}
+		t.header = record
+		return nil, nil
+	}
+	if len(record) != len(t.header) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(t.header), len(record))
+	}
+	if err := absoluteTimestamp(t.header, record, t.preamble.start); err != nil {
+		return nil, err
+	}
+	evt, err := parseEventRow(t.header, record)
+	if err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
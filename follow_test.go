@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVTail(t *testing.T) {
+	events := testEvents(seconds(1, 2, 3)...)
+	events[2].What = "two\nlines"
+	var buf bytes.Buffer
+	if err := MarshallEventsCSV(&buf, events, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// fed a byte at a time, each event is returned once its line is complete
+	var tail csvTail
+	var got []Event
+	for i := range data {
+		evts, err := tail.feed(data[i : i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, evts...)
+	}
+	if len(got) != len(events) || got[2].What != "two\nlines" {
+		t.Errorf("Expected %v, got %v", events, got)
+	}
+	if tail.preamble.comment != "hello" {
+		t.Errorf("Expected the comment, got %q", tail.preamble.comment)
+	}
+	if evts, _ := tail.feed([]byte("4,2022-01-01T00:00:00Z")); len(evts) != 0 {
+		t.Errorf("Expected the partial line to wait, got %v", evts)
+	}
+
+	// the invalid records are skipped
+	evts, err := tail.feed([]byte(",x\nnot,valid\n# footer\n5,yesterday,tick\n6,2022-01-01T00:00:01Z,y\n"))
+	if len(evts) != 1 || evts[0].What != "x" || err == nil || !strings.Contains(err.Error(), "fields") {
+		t.Errorf("Expected x and an error on the next line, got %v (%v)", evts, err)
+	}
+	if evts, err = tail.feed(nil); err == nil || !strings.Contains(err.Error(), "invalid ts") {
+		t.Errorf("Expected an error on the invalid timestamp, got %v (%v)", evts, err)
+	}
+	if evts, err = tail.feed(nil); err != nil || len(evts) != 1 || evts[0].What != "y" {
+		t.Errorf("Expected y, got %v (%v)", evts, err)
+	}
+}
+
+func TestFileTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.csv")
+	events := testEvents(seconds(1, 2, 3, 4)...)
+	var buf bytes.Buffer
+	if err := MarshallEventsCSV(&buf, events, ""); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	half := bytes.Index(data, []byte("\n2,")) + 3 // in the middle of a record
+
+	if err := os.WriteFile(path, data[:half], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var notes bytes.Buffer
+	tail := &fileTail{path: path, out: &notes}
+	poll := func() []Event {
+		t.Helper()
+		evts, err := tail.poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return evts
+	}
+	if evts := poll(); len(evts) != 2 {
+		t.Errorf("Expected 2 complete records, got %v", evts)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(data[half:])
+	f.Close()
+	if evts := poll(); len(evts) != 3 {
+		t.Errorf("Expected the other 3 records, got %v", evts)
+	}
+
+	// truncated, then rewritten
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	poll()
+	if err := os.WriteFile(path, data[:half], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if evts := poll(); len(evts) != 2 {
+		t.Errorf("Expected 2 records after truncation, got %v", evts)
+	}
+
+	// replaced, e.g. by rotation, after the rest was appended to the old file
+	f, _ = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.Write(data[half:])
+	f.Close()
+	os.Rename(path, path+".1")
+	os.WriteFile(path, data, 0o644)
+	if evts := poll(); len(evts) != 3 {
+		t.Errorf("Expected the rest of the old file, got %v", evts)
+	}
+	if evts := poll(); len(evts) != 5 {
+		t.Errorf("Expected all of the new file, got %v", evts)
+	}
+	for _, note := range []string{"truncated", "replaced"} {
+		if !strings.Contains(notes.String(), note) {
+			t.Errorf("Expected a note on the file being %s, got %q", note, notes.String())
+		}
+	}
+}
+
+func TestLapPrinter(t *testing.T) {
+	events := testEvents(seconds(1, 2, 3)...)
+	var p lapPrinter
+	var out bytes.Buffer
+	p.print(&out, events[:2])
+	p.print(&out, events[2:])
+	p.print(io.Discard, nil)
+	want := "Lap 1: 1s tick (avg: 1s, elapsed: 1s)\n" +
+		"Lap 2: 2s tick (avg: 1.5s, elapsed: 3s)\n"
+	if !strings.HasPrefix(out.String(), want) {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
+
+func TestCSVTailTSStart(t *testing.T) {
+	var tail csvTail
+	evts, err := tail.feed([]byte("# run\n# stopwatch-schema: 2\n# ts-start: 2022-04-08T20:00:00Z\nseq,ts,what\n0,0,enter\n1,1.5,tick\n"))
+	want := time.Date(2022, 4, 8, 20, 0, 1, 500000000, time.UTC)
+	if err != nil || len(evts) != 2 || !evts[1].Timestamp.Equal(want) || tail.preamble.comment != "run" {
+		t.Errorf("Expected the tick at %v, got %v, %q, %v", want, evts, tail.preamble.comment, err)
+	}
+}
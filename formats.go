@@ -0,0 +1,519 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoder writes events into out in some output format
+type Encoder func(out io.Writer, events []Event, opts OutputOptions) error
+
+// format describes an output format available via the -format flag
+type format struct {
+	encode      Encoder
+	description string
+	mediaType   string // the Content-Type of an upload, see uploadContentType
+}
+
+// formats is the registry of output formats, keyed by the format name
+var formats = map[string]format{
+	"csv":       {EncodeCSV, "Comma separated values (default)", "text/csv"},
+	"ics":       {EncodeICS, "iCalendar with one event spanning the session", "text/calendar"},
+	"json":      {EncodeJSON, "JSON object with the comment and an array of events", "application/json"},
+	"latex":     {EncodeLaTeX, "LaTeX tabular with booktabs style rules", "application/x-latex"},
+	"ndjson":    {EncodeNDJSON, "Newline delimited JSON, one object per event", "application/x-ndjson"},
+	"org":       {EncodeOrg, "Emacs org-mode table", "text/org"},
+	"template":  {EncodeTemplate, "Output of the text/template given with -template-file", "text/plain"},
+	"timeclock": {EncodeTimeclock, "hledger/ledger timeclock, checked in for each lap", "text/plain"},
+	"timew":     {EncodeTimew, "Timewarrior intervals for 'timew import', one per lap", "application/json"},
+}
+
+// formatNames returns the names of the registered formats in sorted order
+func formatNames() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupEncoder returns the encoder of the named format. An empty name
+// selects CSV.
+func lookupEncoder(name string) (Encoder, error) {
+	if name == "" {
+		name = "csv"
+	}
+	if program := strings.TrimPrefix(name, execFormatPrefix); program != name {
+		if program == "" {
+			return nil, fmt.Errorf("no program given with %q", name)
+		}
+		return execEncoder(program, execEncoderTimeout), nil
+	}
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (available: %s, or %s<program>)", name, strings.Join(formatNames(), ", "),
+			execFormatPrefix)
+	}
+	return f.encode, nil
+}
+
+// DumpEvents writes a sequence of events into output file, encoded in the
+// format given by opts.Format. Filenames "" and "-" are interpreted as stdout,
+// and "stderr" and "fd:N" as the already open files, see outputStream.
+// An http:// or https:// URL is uploaded to, see uploadEvents.
+// With opts.SplitByLabel, opts.RotateEvents or opts.RotateSize, the events
+// are split into several files, see outputFiles; the open files and the
+// URLs are never split.
+func DumpEvents(outFile string, events []Event, opts OutputOptions) error {
+	if err := checkOutputURL(outFile); err != nil {
+		return err
+	}
+	encode, events, opts, err := prepareOutput(events, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Manifest && (isUpload(outFile) || isStream(outFile)) {
+		return fmt.Errorf("-manifest requires an output file, not a stream or a URL")
+	}
+	if isUpload(outFile) {
+		if err := uploadEvents(outFile, encode, events, opts); err != nil {
+			return err
+		}
+		if opts.Redact != nil {
+			return opts.Redact.writeMap()
+		}
+		return nil
+	}
+	if f, err := outputStream(outFile); err != nil {
+		return err
+	} else if f != nil {
+		return writeEncoded(f, encode, events, opts)
+	}
+	files, err := outputFiles(outFile, encode, events, opts)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := dumpFile(file.path, encode, file.events, opts); err != nil {
+			return err
+		}
+	}
+	if opts.Redact != nil {
+		return opts.Redact.writeMap()
+	}
+	return nil
+}
+
+// dumpFile writes events into the file path, see DumpEvents. With a
+// manifest, the file is written through a temporary file renamed over it,
+// and the manifest only once the file is complete.
+func dumpFile(path string, encode Encoder, events []Event, opts OutputOptions) error {
+	if opts.MkDirs {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+	}
+	write := func(w io.Writer) error {
+		return writeEncoded(w, encode, events, opts)
+	}
+	if !opts.Manifest {
+		return writeFile(path, write, opts)
+	}
+	sum := sha256.New()
+	err := writeFile(path, func(w io.Writer) error {
+		return write(io.MultiWriter(w, sum))
+	}, opts)
+	if err != nil {
+		return err
+	}
+	m, err := newManifest(path, events, opts, sum)
+	if err != nil {
+		return err
+	}
+	return writeManifest(path, m)
+}
+
+// writeFile writes the output of write into the file path, see dumpFile
+func writeFile(path string, write func(io.Writer) error, opts OutputOptions) error {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() && opts.Backup {
+		_, err := writeWithBackup(path, write)
+		return err
+	}
+	// a device or a link is written as it is
+	if fi, err := os.Lstat(path); opts.Manifest && (os.IsNotExist(err) || err == nil && fi.Mode().IsRegular()) {
+		perm := os.FileMode(0o644)
+		if err == nil {
+			perm = fi.Mode().Perm()
+		}
+		if err := writeAtomic(path, perm, write); err != nil {
+			return fmt.Errorf("could not write file: %w", err)
+		}
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	defer f.Close()
+	return write(f)
+}
+
+// DryRunEvents writes into out what DumpEvents would write into outFile,
+// between delimiter lines, without touching the filesystem. The output is
+// shown unencrypted. Returns an error if the file evidently could not be
+// written.
+func DryRunEvents(out io.Writer, outFile string, events []Event, opts OutputOptions) error {
+	encode, events, opts, err := prepareOutput(events, opts)
+	if err != nil {
+		return err
+	}
+	format := opts.Format
+	if format == "" {
+		format = "csv"
+	}
+	if opts.Compress != "" && opts.Compress != compressNone {
+		format += ", " + opts.Compress
+	}
+	if opts.Passphrase != nil {
+		format += ", encrypted"
+	}
+	if isStdout(outFile) {
+		return dryRunFile(out, "stdout", format, encode, events, opts)
+	}
+	if err := checkOutputURL(outFile); err != nil {
+		return err
+	}
+	if isUpload(outFile) {
+		return dryRunFile(out, outFile, format+", "+opts.Upload.method()+" "+uploadContentType(opts), encode, events, opts)
+	}
+	if isStream(outFile) {
+		if _, err := outputStream(outFile); err != nil {
+			return err
+		}
+		return dryRunFile(out, outFile, format, encode, events, opts)
+	}
+	files, err := outputFiles(outFile, encode, events, opts)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		target, err := filepath.Abs(file.path)
+		if err != nil {
+			return err
+		}
+		if err := dryRunFile(out, target, format, encode, file.events, opts); err != nil {
+			return err
+		}
+		if err := checkWritable(target, opts.MkDirs); err != nil {
+			return err
+		}
+		if fi, err := os.Stat(target); err == nil && fi.Mode().IsRegular() && opts.Backup {
+			backup, err := backupName(target)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "# Dry run: would back up the existing file to %s\n", backup)
+		}
+	}
+	return nil
+}
+
+// dryRunFile writes the would-be contents of target into out
+func dryRunFile(out io.Writer, target, format string, encode Encoder, events []Event, opts OutputOptions) error {
+	fmt.Fprintf(out, "# Dry run: would write %s (%s)\n", target, format)
+	fmt.Fprintln(out, "# ---- begin output ----")
+	if err := encode(out, events, opts); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "# ---- end output ----")
+	return nil
+}
+
+// checkWritable checks what can be checked without writing: that the
+// directory of path exists (or with mkdirs, that it could be created), and
+// that path is not a directory
+func checkWritable(path string, mkdirs bool) error {
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	for mkdirs && os.IsNotExist(err) && dir != filepath.Dir(dir) {
+		dir = filepath.Dir(dir)
+		fi, err = os.Stat(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("could not create file: %s is not a directory", dir)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return fmt.Errorf("could not create file: %s is a directory", path)
+	}
+	return nil
+}
+
+// probeOutput checks, before anything is recorded, that outFile can be
+// written: that its directory exists, or with mkdirs, creates it, and that
+// a file can be created there, tried with a probe file removed at once. An
+// existing outFile is opened for writing but left as it is, so that it is
+// kept until the output replaces it, or -backup renames it. The standard
+// streams and fd:N are not checked, and neither are the URLs uploaded to,
+// beyond their scheme.
+func probeOutput(outFile string, mkdirs bool) error {
+	if isStream(outFile) {
+		return nil
+	}
+	if urlScheme.MatchString(outFile) {
+		return checkOutputURL(outFile)
+	}
+	if err := checkWritable(outFile, mkdirs); err != nil {
+		return err
+	}
+	dir := filepath.Dir(outFile)
+	if mkdirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+	}
+	probe, err := os.CreateTemp(dir, "."+filepath.Base(outFile)+".probe*")
+	if err != nil {
+		return fmt.Errorf("could not create file in %s: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	if fi, err := os.Stat(outFile); err == nil && fi.Mode().IsRegular() {
+		f, err := os.OpenFile(outFile, os.O_WRONLY, 0)
+		if err != nil {
+			return fmt.Errorf("could not write file: %w", err)
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// prepareOutput looks up the encoder of opts.Format, sets the offsets of
+// opts.Since into events, and adds the columns needed by events into opts.
+// With tsStyleOffsetSeconds, opts.Start defaults to the first event, the
+// enter, so that the parts of a split output count from the same instant.
+func prepareOutput(events []Event, opts OutputOptions) (Encoder, []Event, OutputOptions, error) {
+	encode, err := lookupEncoder(opts.Format)
+	if err != nil {
+		return nil, events, opts, err
+	}
+	if opts.TSStyle == tsStyleOffsetSeconds && opts.Start.IsZero() && len(events) > 0 {
+		opts.Start = events[0].Timestamp
+	}
+	if !opts.Since.IsZero() {
+		events = withOffsets(events, opts.Since)
+	}
+	// the labels as recorded, and before -column and -head count them
+	events = filterEvents(events, opts)
+	if opts.Redact != nil {
+		// before the columns of -column see them
+		events = opts.Redact.events(events)
+	}
+	if len(opts.Computed) > 0 {
+		events = withComputedColumns(events, opts.Computed, opts.Messages)
+	}
+	events = limitEvents(events, opts.Head, opts.Tail)
+	opts.Columns = dataColumns(events, opts.Columns)
+	opts.Attrs = computedLast(AttrColumns(events), opts.Computed)
+	if err := opts.checkHeaderText(opts.Header()); err != nil {
+		return nil, events, opts, err
+	}
+	if opts.Order == orderDesc {
+		events = reversed(events)
+	}
+	return encode, events, opts, nil
+}
+
+// limitEvents returns the first head or the last tail events, or all of
+// them if there are not as many; 0 does not limit. The last events end in
+// the "exit" of a session, and the first ones start with its "enter".
+func limitEvents(events []Event, head, tail int) []Event {
+	switch {
+	case head > 0 && head < len(events):
+		return events[:head]
+	case tail > 0 && tail < len(events):
+		return events[len(events)-tail:]
+	}
+	return events
+}
+
+// validateHeadTail checks the -head and -tail flags
+func validateHeadTail(head, tail int) error {
+	if head < 0 || tail < 0 {
+		return fmt.Errorf("-head and -tail must not be negative")
+	}
+	if head > 0 && tail > 0 {
+		return fmt.Errorf("-head and -tail are mutually exclusive")
+	}
+	return nil
+}
+
+// DumpEmergency writes events into out as plain CSV, between the markers,
+// after the output could not be written, so that the session can still be
+// recovered by copying it. Encrypted output is not shown in clear.
+func DumpEmergency(out io.Writer, events []Event, opts OutputOptions) error {
+	if opts.Passphrase != nil {
+		_, err := fmt.Fprintln(out, "# The events are not shown, as the output was to be encrypted")
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "# The events, to be recovered by copying the lines between the markers:\n"+
+		emergencyBegin); err != nil {
+		return err
+	}
+	_, events, opts, _ = prepareOutput(events, OutputOptions{Comment: opts.Comment, Columns: opts.Columns})
+	if err := EncodeCSV(out, events, opts); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(out, emergencyEnd)
+	return err
+}
+
+// The markers around the events of DumpEmergency
+const (
+	emergencyBegin = "# ---- BEGIN EVENTS ----"
+	emergencyEnd   = "# ---- END EVENTS ----"
+)
+
+// writeFallback writes events, as opts would write them into outFile, into
+// a new file in the temporary directory instead, after outFile could not be
+// written, and returns its path. The events all go into the one file.
+func writeFallback(outFile string, events []Event, opts OutputOptions) (string, error) {
+	name := "stopwatch.csv"
+	if isUpload(outFile) {
+		if base := uploadName(outFile); base != "" {
+			name = base
+		}
+	} else if !isStream(outFile) {
+		name = filepath.Base(outFile)
+	}
+	f, err := os.CreateTemp("", "stopwatch-*-"+name)
+	if err != nil {
+		return "", err
+	}
+	encode, events, opts, err := prepareOutput(events, singleFile(opts))
+	if err == nil {
+		err = writeEncoded(f, encode, events, opts)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// singleFile returns opts without the options writing the events into
+// several files, or renaming an existing one
+func singleFile(opts OutputOptions) OutputOptions {
+	opts.SplitByLabel, opts.SplitDaily, opts.RotateEvents, opts.RotateSize, opts.Backup = false, false, 0, 0, false
+	return opts
+}
+
+// isStdout reports whether outFile names the standard output
+func isStdout(outFile string) bool {
+	return outFile == "-" || outFile == "" || outFile == "fd:1"
+}
+
+// isStream reports whether outFile names an already open file instead of a
+// path: stdout, "stderr" or an inherited file descriptor "fd:N". A file
+// named like that can still be given as e.g. "./stderr".
+func isStream(outFile string) bool {
+	return isStdout(outFile) || outFile == "stderr" || strings.HasPrefix(outFile, "fd:")
+}
+
+// writesStderr reports whether outFile names the standard error, where the
+// messages go as well
+func writesStderr(outFile string) bool {
+	return outFile == "stderr" || outFile == "fd:2"
+}
+
+// outputStream returns the already open file outFile names, see isStream,
+// or nil if outFile is a path. The file is not to be closed: it was opened
+// by the parent process.
+func outputStream(outFile string) (*os.File, error) {
+	switch {
+	case isStdout(outFile):
+		return os.Stdout, nil
+	case writesStderr(outFile):
+		return os.Stderr, nil
+	case !isStream(outFile):
+		return nil, nil
+	}
+	fd, err := strconv.ParseUint(strings.TrimPrefix(outFile, "fd:"), 10, 31)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file descriptor in %q, expected fd:N", outFile)
+	} else if fd == 0 {
+		return nil, fmt.Errorf("file descriptor 0 is the standard input")
+	}
+	f := inheritedFile(uintptr(fd), outFile)
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("file descriptor %d is not open", fd)
+	}
+	return f, nil
+}
+
+// inheritedFiles holds the files of inheritedFile. Kept referenced, as an
+// unreachable *os.File would close its descriptor when garbage collected.
+var inheritedFiles = map[uintptr]*os.File{}
+
+// inheritedFile returns a file for the descriptor fd inherited from the
+// parent process, the same one on every call
+func inheritedFile(fd uintptr, name string) *os.File {
+	f, ok := inheritedFiles[fd]
+	if !ok {
+		f = os.NewFile(fd, name)
+		inheritedFiles[fd] = f
+	}
+	return f
+}
+
+// writeEncoded encodes events into out, compressing the output if
+// opts.Compress is set and then encrypting it if opts.Passphrase is set.
+// Since both wrap the writer given to the encoder, they work the same for
+// every format.
+func writeEncoded(out io.Writer, encode Encoder, events []Event, opts OutputOptions) error {
+	write := func(w io.Writer) error {
+		return encode(w, events, opts)
+	}
+	if opts.Compress != "" && opts.Compress != compressNone {
+		plain := write
+		write = func(w io.Writer) error {
+			return writeCompressed(w, opts.Compress, opts.CompressLevel, plain)
+		}
+	}
+	if opts.Passphrase == nil {
+		return write(out)
+	}
+	ew, err := NewEncryptWriter(out, opts.Passphrase)
+	if err != nil {
+		return fmt.Errorf("could not initialize encryption: %w", err)
+	}
+	if err := write(ew); err != nil {
+		return err
+	}
+	return ew.Close()
+}
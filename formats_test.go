@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestDryRunEvents(t *testing.T) {
+	dir := t.TempDir()
+	events := testEvents(time.Second, time.Second)
+	for _, format := range formatNames() {
+		var buf bytes.Buffer
+		path := filepath.Join(dir, "out."+format)
+		opts := OutputOptions{Format: format}
+		if format == "template" {
+			opts.Template = template.Must(template.New(format).Parse("{{len .Events}} events\n"))
+		}
+		if err := DryRunEvents(&buf, path, events, opts); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		var expect bytes.Buffer
+		if err := writeEncoded(&expect, formats[format].encode, events, opts); err != nil {
+			t.Fatal(err)
+		}
+		want := "# Dry run: would write " + path + " (" + format + ")\n" +
+			"# ---- begin output ----\n" + expect.String() + "# ---- end output ----\n"
+		if buf.String() != want {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", format, want, buf.String())
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected no files to be created, got %v", entries)
+	}
+
+	var buf bytes.Buffer
+	if err := DryRunEvents(&buf, "-", events, OutputOptions{Passphrase: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "# Dry run: would write stdout (csv, encrypted)\n") ||
+		!strings.Contains(buf.String(), ",enter\n") {
+		t.Errorf("Expected the plaintext of stdout output, got:\n%s", buf.String())
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing", "out.csv"), dir} {
+		if err := DryRunEvents(&buf, path, events, OutputOptions{}); err == nil {
+			t.Errorf("Expected error for %s", path)
+		}
+	}
+	if err := DryRunEvents(&buf, "-", events, OutputOptions{Format: "bogus"}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestDumpEmergency(t *testing.T) {
+	events := testEvents(time.Second)
+	events[1].Attrs = map[string]string{"lane": "3"}
+	var buf bytes.Buffer
+	// the requested format does not matter: CSV is the easiest to recover
+	if err := DumpEmergency(&buf, events, OutputOptions{Format: "latex", Comment: "run 1"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "# The events, to be recovered by copying the lines between the markers:\n" + emergencyBegin + "\n" +
+		"# run 1\n# stopwatch-schema: 2\nseq,ts,what,lane\n" +
+		"0,2022-04-08T20:00:00Z,enter,\n1,2022-04-08T20:00:01Z,exit,3\n" + emergencyEnd + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	if err := DumpEmergency(&buf, events, OutputOptions{Passphrase: []byte("secret")}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "enter") {
+		t.Errorf("Expected no events in clear, got %q", buf.String())
+	}
+}
+
+// failingWriter fails once n bytes have been written
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errors.New("no space left on device")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteFallback(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	events := goldenEvents()
+	opts := OutputOptions{Format: "json", Comment: "golden", RotateEvents: 2}
+	encode, prepared, prepOpts, _ := prepareOutput(events, opts)
+	if err := writeEncoded(&failingWriter{n: 40}, encode, prepared, prepOpts); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+
+	// the events between the markers read back
+	var buf bytes.Buffer
+	if err := DumpEmergency(&buf, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	_, block, _ := strings.Cut(buf.String(), emergencyBegin+"\n")
+	block, _, _ = strings.Cut(block, emergencyEnd)
+	if back, comment, err := UnmarshalEventsCSV(strings.NewReader(block)); err != nil || comment != "golden" ||
+		!reflect.DeepEqual(back, events) {
+		t.Errorf("Expected the events back from the dump, got %v, %q, %v", back, comment, err)
+	}
+
+	// and so does the copy, in the format asked for and in one file
+	path, err := writeFallback("runs/out.json", events, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != os.TempDir() || !strings.HasSuffix(path, "-out.json") {
+		t.Errorf("Expected a file named after the output in %s, got %s", os.TempDir(), path)
+	}
+	want, _ := os.ReadFile("testdata/events.json")
+	if got, _ := os.ReadFile(path); string(got) != string(want) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+	if path, err := writeFallback("-", events, OutputOptions{}); err != nil || !strings.HasSuffix(path, "-stopwatch.csv") {
+		t.Errorf("Expected a CSV copy of stdout, got %s, %v", path, err)
+	}
+}
+
+func TestDumpEventsToDescriptor(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	outFile := fmt.Sprintf("fd:%d", w.Fd())
+	events := testEvents(time.Second)
+	for i := 0; i < 2; i++ {
+		if err := DumpEvents(outFile, events, OutputOptions{Format: "ndjson"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime.GC() // the file of the descriptor must not close it either
+	if _, err := w.Write([]byte("end\n")); err != nil {
+		t.Fatalf("Expected the descriptor to stay open: %v", err)
+	}
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(out), `"what":"enter"`); n != 2 || !strings.HasSuffix(string(out), "\nend\n") {
+		t.Errorf("Expected the events twice, then the end, got:\n%s", out)
+	}
+
+	var buf bytes.Buffer
+	if err := DryRunEvents(&buf, "stderr", events, OutputOptions{}); err != nil ||
+		!strings.HasPrefix(buf.String(), "# Dry run: would write stderr (csv)\n") {
+		t.Errorf("Unexpected dry run of stderr: %v\n%s", err, buf.String())
+	}
+}
+
+func TestOutputStream(t *testing.T) {
+	for outFile, want := range map[string]*os.File{"": os.Stdout, "-": os.Stdout, "fd:1": os.Stdout,
+		"stderr": os.Stderr, "fd:2": os.Stderr, "out.csv": nil, "./stderr": nil} {
+		if f, err := outputStream(outFile); err != nil || f != want {
+			t.Errorf("%q: expected %v, got %v, %v", outFile, want, f, err)
+		}
+	}
+	for _, outFile := range []string{"fd:", "fd:x", "fd:-1", "fd:0", "fd:1000000"} {
+		if _, err := outputStream(outFile); err == nil {
+			t.Errorf("Expected error for %q", outFile)
+		}
+	}
+}
+
+func TestPrepareOutputHeadTail(t *testing.T) {
+	events := testEvents(time.Second, 2*time.Second, 3*time.Second)
+	col, err := ParseComputedColumn("lap={{seconds .Delta}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		head, tail int
+		want       string
+	}{
+		// the columns are computed before the events are left out
+		{0, 2, "seq,ts,what,lap\n2,2022-04-08T20:00:03Z,tick,2\n3,2022-04-08T20:00:06Z,exit,3\n"},
+		{2, 0, "seq,ts,what,lap\n0,2022-04-08T20:00:00Z,enter,0\n1,2022-04-08T20:00:01Z,tick,1\n"},
+		{0, 10, "seq,ts,what,lap\n0,2022-04-08T20:00:00Z,enter,0\n1,2022-04-08T20:00:01Z,tick,1\n" +
+			"2,2022-04-08T20:00:03Z,tick,2\n3,2022-04-08T20:00:06Z,exit,3\n"},
+	} {
+		var buf bytes.Buffer
+		opts := OutputOptions{Head: test.head, Tail: test.tail, Computed: []ComputedColumn{col}}
+		encode, prepared, opts, err := prepareOutput(events, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := encode(&buf, prepared, opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimPrefix(buf.String(), "# stopwatch-schema: 2\n"); got != test.want {
+			t.Errorf("-head %d -tail %d: expected:\n%s\ngot:\n%s", test.head, test.tail, test.want, got)
+		}
+	}
+	if len(events) != 4 {
+		t.Error("Expected the recorded events to be left as they were")
+	}
+	for _, opts := range []OutputOptions{{Head: -1}, {Head: 1, Tail: 1}} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+}
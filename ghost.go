@@ -0,0 +1,112 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// What the laps of -ghost are matched by, see -ghost-by
+const (
+	ghostBySeq  = "seq"  // the lap closed by the event of the same seq
+	ghostByWhat = "what" // the lap closed by the same occurrence of the same label
+)
+
+// ghostBys are the values of -ghost-by
+var ghostBys = []string{ghostBySeq, ghostByWhat}
+
+// ghost is the reference recording of -ghost, the laps of a session are
+// compared against
+type ghost struct {
+	by      string
+	bySeq   map[int64]time.Duration    // the laps, by the seq of the event closing each
+	byLabel map[string][]time.Duration // the laps closed by each label, in order
+	active  time.Duration              // the time of the reference, pauses not counted
+}
+
+// newGhost returns the ghost of the reference events, with its laps
+// matched as by tells
+func newGhost(events []Event, by string) (*ghost, error) {
+	if by != ghostBySeq && by != ghostByWhat {
+		return nil, fmt.Errorf("unknown -ghost-by %q (available: %s)", by, strings.Join(ghostBys, ", "))
+	}
+	g := &ghost{by: by, bySeq: make(map[int64]time.Duration), byLabel: make(map[string][]time.Duration),
+		active: ComputeStats(events).Active}
+	forEachLap(events, func(evt Event, lap time.Duration) {
+		g.bySeq[evt.Seq] = lap
+		g.byLabel[evt.What] = append(g.byLabel[evt.What], lap)
+	})
+	return g, nil
+}
+
+// LoadGhost reads the reference recording of -ghost from path
+func LoadGhost(path, by string) (*ghost, error) {
+	events, _, err := LoadCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	return newGhost(events, by)
+}
+
+// ghostRun compares the laps of a session with the ghost, as they are
+// closed
+type ghostRun struct {
+	g         *ghost
+	seen      map[string]int // laps closed by each label so far
+	compared  int            // laps with one of the ghost to compare to
+	deviation time.Duration  // sum of the differences of those laps
+}
+
+func newGhostRun(g *ghost) *ghostRun {
+	return &ghostRun{g: g, seen: make(map[string]int)}
+}
+
+// lap returns the difference of lap, closed by evt, to the lap of the
+// ghost it is matched with; positive when behind the ghost. There is none
+// once the session has more laps than the reference.
+func (r *ghostRun) lap(evt Event, lap time.Duration) (time.Duration, bool) {
+	n := r.seen[evt.What]
+	r.seen[evt.What]++
+	var ref time.Duration
+	var ok bool
+	if r.g.by == ghostByWhat {
+		if laps := r.g.byLabel[evt.What]; n < len(laps) {
+			ref, ok = laps[n], true
+		}
+	} else {
+		ref, ok = r.g.bySeq[evt.Seq]
+	}
+	if !ok {
+		return 0, false
+	}
+	r.compared++
+	r.deviation += lap - ref
+	return lap - ref, true
+}
+
+// WriteGhostSummary compares the totals of events with those of g into
+// out, prefixed like WriteSummary
+func WriteGhostSummary(out io.Writer, events []Event, g *ghost) error {
+	r := newGhostRun(g)
+	forEachLap(events, func(evt Event, lap time.Duration) { r.lap(evt, lap) })
+	active := ComputeStats(events).Active
+	_, err := fmt.Fprintf(out, "# Ghost: %s, this run: %s (%s), laps compared: %d, cumulative: %s\n",
+		formatDuration(g.active), formatDuration(active), formatDeviation(active-g.active), r.compared,
+		formatDeviation(r.deviation))
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionGhost(t *testing.T) {
+	start := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		by    string
+		ticks []string
+		want  []string
+	}{
+		{ghostBySeq, []string{"run", "climb", "run", "run"}, []string{
+			"# Lap 1: 12s (+2s vs ghost, cumulative +2s)",
+			"# Lap 2: 15s (-5s vs ghost, cumulative -3s)",
+			"# Lap 3: 9s (-1s vs ghost, cumulative -4s)",
+		}},
+		// matched by the label, and without a second climb in the ghost
+		{ghostByWhat, []string{"climb", "run", "climb", "run"}, []string{
+			"# Lap 1: 12s (-8s vs ghost, cumulative -8s)",
+			"# Lap 2: 15s (+5s vs ghost, cumulative -3s)",
+			"# Lap 4: 14s (+4s vs ghost, cumulative +1s)",
+		}},
+	} {
+		g, err := LoadGhost("testdata/ghost.csv", test.by)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var at time.Duration
+		sess := newSession("", collectOptions{Ghost: g})
+		sess.now = func() time.Time { return start.Add(at) }
+		var out bytes.Buffer
+		sess.start()
+		for i, label := range test.ticks {
+			at = []time.Duration{12 * time.Second, 27 * time.Second, 36 * time.Second, 50 * time.Second}[i]
+			sess.handleLine(label, &out)
+		}
+		sess.finish()
+		var got []string
+		for _, line := range strings.Split(out.String(), "\n") {
+			if strings.Contains(line, "ghost") {
+				got = append(got, line)
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("-ghost-by %s: expected:\n%s\ngot:\n%s", test.by, strings.Join(test.want, "\n"), out.String())
+		}
+
+		var summary bytes.Buffer
+		WriteGhostSummary(&summary, sess.Events, g)
+		if test.by == ghostBySeq {
+			if want := "# Ghost: 45s, this run: 50s (+5s), laps compared: 3, cumulative: -4s\n"; summary.String() != want {
+				t.Errorf("Expected %q, got %q", want, summary.String())
+			}
+		}
+	}
+
+	if _, err := LoadGhost("testdata/ghost.csv", "value"); err == nil {
+		t.Error("Expected error for an unknown -ghost-by")
+	}
+}
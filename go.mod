@@ -1,3 +1,12 @@
 module github.com/MawKKe/stopwatch-go
 
 go 1.18
+
+require (
+	github.com/klauspost/compress v1.16.7
+	golang.org/x/crypto v0.24.0
+	golang.org/x/term v0.21.0
+	golang.org/x/text v0.16.0
+)
+
+require golang.org/x/sys v0.21.0 // indirect
@@ -0,0 +1,50 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "time"
+
+// nextHeartbeat returns the time until the next -heartbeat is due. The
+// heartbeats are scheduled at the multiples of the interval since "enter",
+// not from the previous one, so that they do not drift from the wall clock.
+func (s *Session) nextHeartbeat() (time.Duration, bool) {
+	if s.opts.Heartbeat <= 0 || s.armed || len(s.Events) == 0 {
+		return 0, false
+	}
+	wait := s.startAt.Add(time.Duration(s.beats+1) * s.opts.Heartbeat).Sub(s.now())
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// checkHeartbeat records a "heartbeat" event if one is due, timestamped
+// when it was recorded, to tell that the recorder was alive then. After a
+// longer gap, e.g. while suspended, the heartbeats missed are not made up
+// for, and none are recorded while paused.
+func (s *Session) checkHeartbeat() {
+	if _, due := s.nextHeartbeat(); !due {
+		return
+	}
+	now := s.now()
+	beats := int(now.Sub(s.startAt) / s.opts.Heartbeat)
+	if beats <= s.beats {
+		return
+	}
+	s.beats = beats
+	if !s.paused {
+		s.recordEvent(Event{Timestamp: now, What: labelHeartbeat})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSessionHeartbeat(t *testing.T) {
+	start := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	var at time.Duration
+	sess := newSession("", collectOptions{Heartbeat: time.Minute, Debounce: 10 * time.Second})
+	sess.now = func() time.Time { return start.Add(at) }
+	var out bytes.Buffer
+	step := func(to time.Duration) {
+		at = to
+		sess.checkTimers(&out)
+	}
+
+	sess.start()
+	if wait, ok := sess.nextTimer(); !ok || wait != time.Minute {
+		t.Errorf("Expected the heartbeat in 1m, got %v %v", wait, ok)
+	}
+	step(30 * time.Second)
+	step(time.Minute + 200*time.Millisecond) // a late timer
+	if wait, _ := sess.nextTimer(); wait != time.Minute-200*time.Millisecond {
+		t.Errorf("Expected the next heartbeat on the minute, got %v", wait)
+	}
+	at = time.Minute + 5*time.Second
+	sess.handleLine("", &out) // not debounced by the heartbeat
+	step(2 * time.Minute)
+	sess.handleLine("pause", &out)
+	step(3 * time.Minute)
+	sess.handleLine("resume", &out)
+	step(7*time.Minute + time.Second) // e.g. suspended
+	sess.finish()
+
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.Timestamp.Sub(start).String()+" "+evt.What)
+	}
+	want := []string{"0s enter", "1m0.2s heartbeat", "1m5s tick", "2m0s heartbeat", "2m0s pause", "3m0s resume",
+		"7m1s heartbeat", "7m1s exit"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if stats := ComputeStats(sess.Events); !reflect.DeepEqual(stats.Laps, []time.Duration{65 * time.Second}) {
+		t.Errorf("Expected the heartbeats not to be laps, got %v", stats.Laps)
+	}
+}
+
+func TestSessionHeartbeatArmed(t *testing.T) {
+	sess := newSession("", collectOptions{Heartbeat: time.Minute, Arm: true})
+	sess.start()
+	if _, ok := sess.nextTimer(); ok {
+		t.Error("Expected no heartbeat before the session starts")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Bucket is a single bin of a histogram, covering durations in [Lo, Hi)
+type Bucket struct {
+	Lo, Hi time.Duration
+	Count  int
+}
+
+// minBarWidth is the narrowest bar area a histogram is rendered with,
+// regardless of the available width.
+const minBarWidth = 10
+
+// Histogram sorts laps into buckets. If width is positive, it is used as the
+// bucket width and the buckets are aligned to its multiples. Otherwise the
+// range of laps is split into n buckets; if n is not positive, it is chosen
+// with Sturges' rule (ceil(log2(len(laps))) + 1). Empty buckets are included
+// so that the shape of the distribution is preserved. The last bucket also
+// contains its upper bound.
+func Histogram(laps []time.Duration, n int, width time.Duration) []Bucket {
+	if len(laps) == 0 {
+		return nil
+	}
+	lo, hi := laps[0], laps[0]
+	for _, lap := range laps {
+		if lap < lo {
+			lo = lap
+		}
+		if lap > hi {
+			hi = lap
+		}
+	}
+	if width > 0 {
+		lo = lo / width * width
+		n = int((hi-lo)/width) + 1
+	} else {
+		if n <= 0 {
+			n = int(math.Ceil(math.Log2(float64(len(laps))))) + 1
+		}
+		width = (hi - lo + time.Duration(n) - 1) / time.Duration(n)
+		if width == 0 {
+			// all laps are equal; a single bucket is enough
+			n, width = 1, time.Millisecond
+		}
+	}
+	buckets := make([]Bucket, n)
+	for i := range buckets {
+		buckets[i].Lo = lo + time.Duration(i)*width
+		buckets[i].Hi = buckets[i].Lo + width
+	}
+	for _, lap := range laps {
+		i := int((lap - lo) / width)
+		if i >= n {
+			i = n - 1
+		}
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// RenderHistogram draws buckets into out as horizontal bars. Each line
+// shows the bucket range and count, and the bars are scaled so that the
+// lines fit into width columns.
+func RenderHistogram(out io.Writer, buckets []Bucket, width int) error {
+	var labels []string
+	var labelWidth, maxCount int
+	for _, b := range buckets {
+		label := fmt.Sprintf("[%s, %s)", formatDuration(b.Lo), formatDuration(b.Hi))
+		labels = append(labels, label)
+		if len(label) > labelWidth {
+			labelWidth = len(label)
+		}
+		if b.Count > maxCount {
+			maxCount = b.Count
+		}
+	}
+	countWidth := len(fmt.Sprint(maxCount))
+	barWidth := width - labelWidth - countWidth - 3
+	if barWidth < minBarWidth {
+		barWidth = minBarWidth
+	}
+	for i, b := range buckets {
+		bar := 0
+		if maxCount > 0 {
+			bar = (b.Count*barWidth + maxCount/2) / maxCount
+		}
+		line := fmt.Sprintf("%-*s %*d |%s", labelWidth, labels[i], countWidth, b.Count, strings.Repeat("#", bar))
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	laps := seconds(1, 1.5, 2, 4.5, 5, 9)
+
+	// Sturges: ceil(log2(6)) + 1 = 4 buckets of 2s
+	got := Histogram(laps, 0, 0)
+	expect := []Bucket{
+		{Lo: 1 * time.Second, Hi: 3 * time.Second, Count: 3},
+		{Lo: 3 * time.Second, Hi: 5 * time.Second, Count: 1},
+		{Lo: 5 * time.Second, Hi: 7 * time.Second, Count: 1},
+		{Lo: 7 * time.Second, Hi: 9 * time.Second, Count: 1},
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("Automatic buckets:\nexpected %v\ngot      %v", expect, got)
+	}
+
+	got = Histogram(laps, 0, 4*time.Second)
+	expect = []Bucket{
+		{Lo: 0, Hi: 4 * time.Second, Count: 3},
+		{Lo: 4 * time.Second, Hi: 8 * time.Second, Count: 2},
+		{Lo: 8 * time.Second, Hi: 12 * time.Second, Count: 1},
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("Fixed width buckets:\nexpected %v\ngot      %v", expect, got)
+	}
+
+	if got := Histogram(seconds(2, 2), 5, 0); len(got) != 1 || got[0].Count != 2 {
+		t.Errorf("Equal laps: expected a single bucket, got %v", got)
+	}
+	if got := Histogram(nil, 0, 0); got != nil {
+		t.Errorf("No laps: expected no buckets, got %v", got)
+	}
+}
+
+func TestRenderHistogram(t *testing.T) {
+	var buf bytes.Buffer
+	buckets := Histogram(seconds(1, 1.5, 2, 2.5, 5, 9, 9.5), 3, 0)
+	if err := RenderHistogram(&buf, buckets, 40); err != nil {
+		t.Fatal(err)
+	}
+	expect := "" +
+		"[1s, 3.833s)     4 |####################\n" +
+		"[3.833s, 6.667s) 1 |#####\n" +
+		"[6.667s, 9.5s)   2 |##########\n"
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestRenderHistogramEmptyBuckets(t *testing.T) {
+	var buf bytes.Buffer
+	buckets := Histogram(seconds(1, 1, 9), 0, 2*time.Second)
+	if err := RenderHistogram(&buf, buckets, 0); err != nil {
+		t.Fatal(err)
+	}
+	expect := "" +
+		"[0s, 2s)  2 |##########\n" +
+		"[2s, 4s)  0 |\n" +
+		"[4s, 6s)  0 |\n" +
+		"[6s, 8s)  0 |\n" +
+		"[8s, 10s) 1 |#####\n"
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icsTimeLayout is the RFC 5545 DATE-TIME form in UTC
+const icsTimeLayout = "20060102T150405Z"
+
+// icsMaxLine is the maximum length of a content line in octets, excluding
+// the line break (RFC 5545, section 3.1)
+const icsMaxLine = 75
+
+// EncodeICS writes events into out as an iCalendar (RFC 5545) object. The
+// session becomes a single VEVENT spanning from the first to the last event,
+// with the comment as its SUMMARY. Every event is listed in the DESCRIPTION
+// along with its offset from the start of the session.
+func EncodeICS(out io.Writer, events []Event, opts OutputOptions) error {
+	if len(events) == 0 {
+		return fmt.Errorf("no events to export")
+	}
+	start, end := events[0].Timestamp, events[len(events)-1].Timestamp
+
+	summary := opts.Comment
+	if summary == "" {
+		summary = "Stopwatch session"
+	}
+	var desc []string
+	for _, evt := range events {
+		fields := []string{fmt.Sprintf("%d +%s", evt.Seq, formatDuration(evt.Timestamp.Sub(start)))}
+		if evt.Value != nil {
+			fields = append(fields, formatValue(*evt.Value))
+		}
+		fields = append(fields, evt.What)
+		for _, key := range AttrColumns([]Event{evt}) {
+			fields = append(fields, key+"="+evt.Attrs[key])
+		}
+		desc = append(desc, strings.Join(fields, " "))
+	}
+
+	w := bufio.NewWriter(out)
+	for _, line := range []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//MawKKe//stopwatch-go//EN",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:%d@stopwatch-go", start.UnixNano()),
+		"DTSTAMP:" + icsTime(end),
+		"DTSTART:" + icsTime(start),
+		"DTEND:" + icsTime(end),
+		"SUMMARY:" + icsEscape(summary),
+		"DESCRIPTION:" + icsEscape(strings.Join(desc, "\n")),
+		"END:VEVENT",
+		"END:VCALENDAR",
+	} {
+		icsWriteLine(w, line)
+	}
+	return w.Flush()
+}
+
+// icsTime formats t in UTC, as required for the "Z" suffixed form
+func icsTime(t time.Time) string {
+	return t.UTC().Format(icsTimeLayout)
+}
+
+// icsEscape escapes s as an RFC 5545 TEXT value
+var icsEscape = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+).Replace
+
+// icsWriteLine writes a content line terminated by CRLF, folding it into
+// multiple lines of at most icsMaxLine octets. Continuation lines start with
+// a single space. Multi-byte UTF-8 sequences are never split.
+func icsWriteLine(w *bufio.Writer, line string) {
+	limit := icsMaxLine
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		limit = icsMaxLine - 1 // account for the leading space
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
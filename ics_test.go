@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeICSGolden(t *testing.T) {
+	events := testEvents(time.Second, 2500*time.Millisecond, 90*time.Second, time.Second)
+	events[2].What = "semi;colon, comma and \\backslash"
+	var buf bytes.Buffer
+	opts := OutputOptions{Comment: "Cold-start latency, round 2"}
+	if err := EncodeICS(&buf, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	expect, err := os.ReadFile("testdata/session.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Fatalf("Output differs from golden file.\nexpected:\n%s\ngot:\n%s", expect, buf.Bytes())
+	}
+}
+
+func TestICSLineFolding(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	line := "DESCRIPTION:" + strings.Repeat("ä", 100)
+	icsWriteLine(w, line)
+	w.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	var unfolded string
+	for i, l := range lines {
+		if len(l) > icsMaxLine {
+			t.Errorf("Line %d is %d octets long", i, len(l))
+		}
+		if i > 0 {
+			if !strings.HasPrefix(l, " ") {
+				t.Fatalf("Continuation line %d does not start with a space: %q", i, l)
+			}
+			l = l[1:]
+		}
+		unfolded += l
+	}
+	if unfolded != line {
+		t.Fatalf("Unfolded line differs from the original:\n%q\n%q", line, unfolded)
+	}
+}
+
+func TestEncodeICSNoEvents(t *testing.T) {
+	if err := EncodeICS(&bytes.Buffer{}, nil, OutputOptions{}); err == nil {
+		t.Fatal("Expected error")
+	}
+}
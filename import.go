@@ -0,0 +1,249 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// flagOverlap flags the start of an imported entry starting before the
+// previous one ended
+const flagOverlap = "overlap"
+
+// importer reads the entries of another time tracker from r as events,
+// with the local times in loc. Entries that can not be imported are
+// skipped, with a warning into warn.
+type importer func(r io.Reader, loc *time.Location, warn io.Writer) ([]Event, error)
+
+// importers are the sources of the import subcommand, by -from name
+var importers = map[string]importer{
+	"toggl": importToggl,
+}
+
+func runImport(args []string) int {
+	fs := newFlagSet("import", "<file.csv>")
+	from := fs.String("from", "", "The program the file was exported from: "+strings.Join(importerNames(), ", "))
+	outFile := fs.String("o", "", "Output file path, stderr, fd:N or an http(s):// URL to upload to (default: stdout)")
+	zone := fs.String("tz", "", "Time zone of the times in the file, e.g. Europe/Helsinki (default: the local time zone)")
+	outFlags := addOutputFlags(fs, "to")
+	completeFiles(fs, "o")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	read, ok := importers[*from]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown -from %q, expected one of: %s\n", *from, strings.Join(importerNames(), ", "))
+		return 2
+	}
+	loc := time.Local
+	if *zone != "" {
+		var err error
+		if loc, err = time.LoadLocation(*zone); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR: invalid -tz:", err)
+			return 2
+		}
+	}
+	opts, err := outFlags.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	if (opts.RotateEvents > 0 || opts.RotateSize > 0 || opts.SplitByLabel || opts.Manifest) && (isStream(*outFile) || isUpload(*outFile)) {
+		fmt.Fprintln(os.Stderr, "ERROR: -rotate-every, -rotate-size, -split-by-label and -manifest require an output file (-o),\n"+
+			"not a stream or a URL")
+		return 2
+	}
+	if opts, err = withCompression(opts, *outFile); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	defer f.Close()
+	events, err := read(f, loc, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: problem reading %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	opts.Comment = fmt.Sprintf("Imported from %s: %s", *from, filepath.Base(fs.Arg(0)))
+	if err := DumpEvents(*outFile, events, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem writing output:", err)
+		return 1
+	}
+	return 0
+}
+
+// importerNames returns the names of the importers in sorted order
+func importerNames() []string {
+	var names []string
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// timeEntry is an entry of a time tracker, see entryEvents
+type timeEntry struct {
+	name       string
+	start, end time.Time
+	attrs      map[string]string
+}
+
+// entryEvents turns entries into a session: a "start:<name>" event at the
+// start of each entry and a "stop:<name>" event at its end, in
+// chronological order between "enter" at the first start and "exit" at
+// the last end. A stop comes before a start at the same time. The start of
+// an entry beginning before the previous ones ended is flagged "overlap".
+func entryEvents(entries []timeEntry) []Event {
+	if len(entries) == 0 {
+		return nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].start.Before(entries[j].start) })
+	events := []Event{{Timestamp: entries[0].start, What: labelEnter}}
+	var busyUntil time.Time
+	for _, e := range entries {
+		start := Event{Timestamp: e.start, What: labelStartPrefix + e.name, Attrs: e.attrs}
+		if e.start.Before(busyUntil) {
+			start.Flag = flagOverlap
+		}
+		if e.end.After(busyUntil) {
+			busyUntil = e.end
+		}
+		events = append(events, start, Event{Timestamp: e.end, What: labelStopPrefix + e.name})
+	}
+	sort.SliceStable(events[1:], func(i, j int) bool {
+		a, b := events[1+i], events[1+j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return strings.HasPrefix(a.What, labelStopPrefix) && !strings.HasPrefix(b.What, labelStopPrefix)
+	})
+	events = append(events, Event{Timestamp: busyUntil, What: labelExit})
+	for i := range events {
+		events[i].Seq = int64(i)
+	}
+	return events
+}
+
+// togglColumns are the columns of a Toggl Track CSV export read by
+// importToggl; the others, e.g. "Billable" and "Amount", are ignored
+var togglColumns = []string{"Description", "Start date", "Start time", "End date", "End time"}
+
+// Attributes taken from the optional columns of a Toggl export
+var togglAttrs = map[string]string{"Project": "project", "Client": "client", "Task": "task", "Tags": "tags"}
+
+// importToggl reads a CSV export of the detailed report of Toggl Track.
+// The dates are YYYY-MM-DD and the times HH:MM:SS, in the time zone of
+// the Toggl profile, given as loc. An entry without a description is
+// named by its project. Entries without an end time, i.e. still running
+// when exported, and entries ending before they start are skipped; the
+// Duration column is not needed, as it is rounded.
+func importToggl(r io.Reader, loc *time.Location, warn io.Writer) ([]Event, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file")
+	} else if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		col[strings.TrimSpace(strings.TrimPrefix(name, utf8BOM))] = i
+	}
+	for _, name := range togglColumns {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("not a Toggl export: no %q column", name)
+		}
+	}
+	var entries []timeEntry
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		e := timeEntry{name: field("Description")}
+		for column, key := range togglAttrs {
+			if value := field(column); value != "" {
+				if e.attrs == nil {
+					e.attrs = make(map[string]string)
+				}
+				e.attrs[key] = value
+			}
+		}
+		if e.name == "" {
+			e.name = field("Project")
+		}
+		if e.name == "" {
+			e.name = "(no description)"
+		}
+		if field("End date") == "" || field("End time") == "" {
+			fmt.Fprintf(warn, "# WARNING: line %d: %q has no end time, skipped\n", line, e.name)
+			continue
+		}
+		if e.start, err = togglTime(field("Start date"), field("Start time"), loc); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if e.end, err = togglTime(field("End date"), field("End time"), loc); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if e.end.Before(e.start) {
+			fmt.Fprintf(warn, "# WARNING: line %d: %q ends before it starts, skipped\n", line, e.name)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	events := entryEvents(entries)
+	for _, evt := range events {
+		if evt.Flag == flagOverlap {
+			fmt.Fprintf(warn, "# WARNING: %q at %s starts before the previous entry ended, flagged %q\n",
+				strings.TrimPrefix(evt.What, labelStartPrefix), evt.Timestamp.Format(time.RFC3339), flagOverlap)
+		}
+	}
+	return events, nil
+}
+
+// togglTime parses the date and the time of a Toggl export in loc
+func togglTime(date, clock string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", date+" "+clock, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date or time %q, expected YYYY-MM-DD HH:MM:SS", date+" "+clock)
+	}
+	return t, nil
+}
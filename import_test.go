@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportToggl(t *testing.T) {
+	f, err := os.Open("testdata/toggl.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	loc := time.FixedZone("EET", 2*60*60)
+	var warn bytes.Buffer
+	events, err := importToggl(f, loc, &warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i, evt := range events {
+		if evt.Seq != int64(i) || (i > 0 && evt.Timestamp.Before(events[i-1].Timestamp)) {
+			t.Errorf("Expected the events in order, got %+v", evt)
+		}
+		got = append(got, evt.What+"/"+evt.Flag)
+	}
+	want := []string{"enter/", "start:Code review/", "stop:Code review/", "start:Fix login, again/", "start:Standup/overlap",
+		"stop:Fix login, again/", "stop:Standup/", "start:Internal/", "stop:Internal/", "start:Deploy/", "stop:Deploy/", "exit/"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, got)
+	}
+	if first := events[1]; !first.Timestamp.Equal(time.Date(2023, 3, 6, 9, 0, 0, 0, loc)) ||
+		first.Attrs["project"] != "Website" || first.Attrs["client"] != "Acme" || first.Attrs["tags"] != "review" {
+		t.Errorf("Unexpected first entry %+v", first)
+	}
+	// the entry over midnight ends on the next day
+	if exit := events[len(events)-1]; !exit.Timestamp.Equal(time.Date(2023, 3, 7, 0, 20, 0, 0, loc)) {
+		t.Errorf("Expected the exit at the last end, got %v", exit.Timestamp)
+	}
+	wantWarn := "# WARNING: line 7: \"Code review\" has no end time, skipped\n" +
+		"# WARNING: \"Standup\" at 2023-03-06T11:00:00+02:00 starts before the previous entry ended, flagged \"overlap\"\n"
+	if warn.String() != wantWarn {
+		t.Errorf("Expected:\n%s\ngot:\n%s", wantWarn, warn.String())
+	}
+	timers := NamedTimers(events)
+	if len(timers) != 5 || timers[1].Name != "Fix login, again" || timers[1].Total != 89*time.Minute+30*time.Second {
+		t.Errorf("Expected the entries as timers, got %+v", timers)
+	}
+}
+
+func TestImportTogglErrors(t *testing.T) {
+	for data, want := range map[string]string{
+		"":                         "empty file",
+		"Description,Start date\n": `no "Start time" column`,
+		"Description,Start date,Start time,End date,End time\nx,06/03/2023,09:00:00,2023-03-06,10:00:00\n": "line 2: invalid date",
+	} {
+		if _, err := importToggl(strings.NewReader(data), time.UTC, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected %q, got %v", data, want, err)
+		}
+	}
+	var warn bytes.Buffer
+	data := "Description,Start date,Start time,End date,End time\nx,2023-03-06,10:00:00,2023-03-06,09:00:00\n"
+	if events, err := importToggl(strings.NewReader(data), time.UTC, &warn); err != nil || events != nil || !strings.Contains(warn.String(), "ends before it starts") {
+		t.Errorf("Expected the entry to be skipped, got %v, %v, %q", events, err, warn.String())
+	}
+}
+
+func TestRunImport(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.csv")
+	if status := runImport([]string{"-from", "toggl", "-tz", "UTC", "-o", out, "testdata/toggl.csv"}); status != 0 {
+		t.Fatalf("Expected status 0, got %d", status)
+	}
+	events, comment, err := LoadCSV(out)
+	if err != nil || comment != "Imported from toggl: toggl.csv" || len(events) != 12 || events[4].Flag != flagOverlap {
+		t.Errorf("Unexpected output %v, %q, %v", events, comment, err)
+	}
+	if status := runImport([]string{"-from", "harvest", "testdata/toggl.csv"}); status != 2 {
+		t.Errorf("Expected status 2 for an unknown source, got %d", status)
+	}
+}
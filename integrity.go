@@ -0,0 +1,135 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Prefixes of the integrity lines. When both are enabled, the checksum line
+// is written first and the signature line last, so that the signature also
+// covers the checksum.
+const (
+	checksumPrefix  = "# sha256: "      // appended with -checksum
+	signaturePrefix = "# hmac-sha256: " // appended with -sign-key-file
+)
+
+// Errors returned when verifying a file without the expected integrity line
+var (
+	errNoChecksum  = errors.New("no checksum line found")
+	errNoSignature = errors.New("no signature line found")
+)
+
+// writeWithChecksum calls encode with a writer that forwards to out and
+// hashes everything written. Afterwards, the hash is appended to out as a
+// checksum line terminated with eol. Since the hash is computed
+// incrementally, it covers exactly the bytes emitted by encode.
+func writeWithChecksum(out io.Writer, eol string, encode func(w io.Writer) error) error {
+	h := sha256.New()
+	if err := encode(io.MultiWriter(out, h)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%s%x%s", checksumPrefix, h.Sum(nil), eol)
+	return err
+}
+
+// writeWithSignature is like writeWithChecksum, but appends an HMAC-SHA256
+// signature computed with key.
+func writeWithSignature(out io.Writer, key []byte, eol string, encode func(w io.Writer) error) error {
+	mac := hmac.New(sha256.New, key)
+	if err := encode(io.MultiWriter(out, mac)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%s%x%s", signaturePrefix, mac.Sum(nil), eol)
+	return err
+}
+
+// splitTrailer splits the last line off data, if it starts with prefix.
+// Returns the data preceding the line and the rest of the line after prefix.
+func splitTrailer(data []byte, prefix string) (body []byte, value string, ok bool) {
+	trimmed := bytes.TrimRight(data, "\r\n")
+	start := bytes.LastIndexByte(trimmed, '\n') + 1
+	line := trimmed[start:]
+	if !bytes.HasPrefix(line, []byte(prefix)) {
+		return data, "", false
+	}
+	return data[:start], string(bytes.TrimSpace(line[len(prefix):])), true
+}
+
+// VerifyChecksum checks the checksum line at the end of data, as written
+// with the Checksum output option.
+func VerifyChecksum(data []byte) error {
+	body, value, ok := splitTrailer(data, checksumPrefix)
+	if !ok {
+		return errNoChecksum
+	}
+	expect, err := hex.DecodeString(value)
+	if err != nil || len(expect) != sha256.Size {
+		return fmt.Errorf("malformed checksum %q", value)
+	}
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], expect) {
+		return fmt.Errorf("checksum mismatch: file has %s, content hashes to %x", value, sum)
+	}
+	return nil
+}
+
+// VerifySignature checks the HMAC-SHA256 signature line at the end of data
+// using key. The comparison is done in constant time. On success, returns the
+// signed data preceding the signature line.
+func VerifySignature(data, key []byte) ([]byte, error) {
+	body, value, ok := splitTrailer(data, signaturePrefix)
+	if !ok {
+		return nil, errNoSignature
+	}
+	expect, err := hex.DecodeString(value)
+	if err != nil || len(expect) != sha256.Size {
+		return nil, fmt.Errorf("malformed signature %q", value)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expect) {
+		return nil, errors.New("signature mismatch")
+	}
+	return body, nil
+}
+
+// LoadKeyFile reads a signing key from path. Trailing newlines are not part
+// of the key. A warning is written to warn if the file is readable by all
+// users.
+func LoadKeyFile(path string, warn io.Writer) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+	if fi.Mode().Perm()&0o004 != 0 {
+		fmt.Fprintf(warn, "# WARNING: key file %s is world-readable (mode %v)\n", path, fi.Mode().Perm())
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", path)
+	}
+	return key, nil
+}
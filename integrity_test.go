@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestChecksumRoundTrip(t *testing.T) {
+	for _, opts := range []OutputOptions{
+		{Checksum: true, Comment: "run 1", StatsFooter: true},
+		ExcelPreset(OutputOptions{Checksum: true}),
+	} {
+		var buf bytes.Buffer
+		if err := EncodeCSV(&buf, testEvents(time.Second, time.Second), opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyChecksum(buf.Bytes()); err != nil {
+			t.Errorf("Expected valid checksum, got: %v\n%s", err, buf.Bytes())
+		}
+		if opts.BOM {
+			continue
+		}
+		if _, _, err := UnmarshalEventsCSV(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("Expected checksummed file to parse, got: %v", err)
+		}
+	}
+}
+
+func TestVerifyChecksumFixtures(t *testing.T) {
+	if err := VerifyChecksum(testdataChecksum(t)); err != nil {
+		t.Errorf("Expected valid checksum, got: %v", err)
+	}
+
+	// same file with a single digit of a timestamp changed
+	bad, err := os.ReadFile("testdata/checksum-corrupted.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyChecksum(bad); err == nil {
+		t.Error("Expected corrupted file to fail verification")
+	}
+}
+
+func TestVerifyChecksumMissing(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeCSV(&buf, testEvents(time.Second), OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyChecksum(buf.Bytes()); err != errNoChecksum {
+		t.Fatalf("Expected errNoChecksum, got: %v", err)
+	}
+	if err := VerifyChecksum([]byte("# sha256: nothex\n")); err == nil || err == errNoChecksum {
+		t.Fatalf("Expected malformed checksum error, got: %v", err)
+	}
+}
+
+func TestSignatureRoundTrip(t *testing.T) {
+	key := []byte("secret")
+	var buf bytes.Buffer
+	opts := OutputOptions{Checksum: true, SignKey: key}
+	if err := EncodeCSV(&buf, testEvents(time.Second, time.Second), opts); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// the signature is the last line and covers the checksum line
+	body, err := VerifySignature(data, key)
+	if err != nil {
+		t.Fatalf("Expected valid signature, got: %v\n%s", err, data)
+	}
+	if err := VerifyChecksum(body); err != nil {
+		t.Fatalf("Expected checksum line before the signature, got: %v", err)
+	}
+	if err := verifyData(data, key); err != nil {
+		t.Errorf("verifyData with key: %v", err)
+	}
+	if err := verifyData(data, nil); err != nil {
+		t.Errorf("verifyData without key: %v", err)
+	}
+	if err := verifyData(data, []byte("wrong")); err == nil {
+		t.Error("Expected wrong key to fail verification")
+	}
+
+	tampered := bytes.Replace(data, []byte("tick"), []byte("tock"), 1)
+	if _, err := VerifySignature(tampered, key); err == nil {
+		t.Error("Expected tampered file to fail verification")
+	}
+}
+
+func TestSignatureWithoutChecksum(t *testing.T) {
+	key := []byte("secret")
+	var buf bytes.Buffer
+	if err := EncodeCSV(&buf, testEvents(time.Second), OutputOptions{SignKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyData(buf.Bytes(), key); err != nil {
+		t.Errorf("Expected signature alone to verify, got: %v", err)
+	}
+	if err := verifyData(buf.Bytes(), nil); err != errNoChecksum {
+		t.Errorf("Expected errNoChecksum without key, got: %v", err)
+	}
+	if _, err := VerifySignature(testdataChecksum(t), key); err != errNoSignature {
+		t.Errorf("Expected errNoSignature, got: %v", err)
+	}
+}
+
+func testdataChecksum(t *testing.T) []byte {
+	data, err := os.ReadFile("testdata/checksum.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	path := t.TempDir() + "/key"
+	if err := os.WriteFile(path, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var warn bytes.Buffer
+	key, err := LoadKeyFile(path, &warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "secret" {
+		t.Errorf("Expected trailing newline to be trimmed, got %q", key)
+	}
+	if warn.Len() != 0 {
+		t.Errorf("Expected no warning, got %q", warn.String())
+	}
+
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyFile(path, &warn); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(warn.Bytes(), []byte("world-readable")) {
+		t.Errorf("Expected world-readable warning, got %q", warn.String())
+	}
+
+	if err := os.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyFile(path, &warn); err == nil {
+		t.Error("Expected error for empty key")
+	}
+}
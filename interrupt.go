@@ -0,0 +1,147 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// forceDumpTimeout bounds the dump of the events when a second signal
+// forces the program to quit, in case the dump hangs as well
+const forceDumpTimeout = 2 * time.Second
+
+// forceQuitWindow is how long after a signal another one forces the
+// program to quit, see shutdown
+const forceQuitWindow = 10 * time.Second
+
+// shutdown handles the signals of a session: the first one ends the
+// session, or if it ended already, marks the signal late, and the output is
+// written as usual. Another signal within forceQuitWindow forces the
+// program to quit at once, after a best-effort dump of the events, in case
+// something hangs while finishing the output; a later one is taken as a
+// first one again. The exit status is then 128 plus the signal number, as
+// by a shell, or 1 where signals have no number.
+type shutdown struct {
+	ctx    context.Context // the session, ended by cancel
+	cancel context.CancelFunc
+	late   chan struct{} // a signal after the session ended, see interruptible
+	out    io.Writer
+	exit   func(code int) // os.Exit
+	now    func() time.Time
+
+	mu       sync.Mutex
+	signaled time.Time // of the first signal, zero before it
+	dump     func()    // saves the events recorded so far, see onForce
+}
+
+// newShutdown returns the shutdown of the session of ctx, ended by cancel
+func newShutdown(ctx context.Context, cancel context.CancelFunc, out io.Writer) *shutdown {
+	return &shutdown{ctx: ctx, cancel: cancel, late: make(chan struct{}, 1), out: out, exit: os.Exit,
+		now: time.Now}
+}
+
+// onForce sets the dump of the events done by a forced quit; nil once the
+// output is written, and there is nothing to lose
+func (s *shutdown) onForce(dump func()) {
+	s.mu.Lock()
+	s.dump = dump
+	s.mu.Unlock()
+}
+
+// run handles the signals until the channel is closed
+func (s *shutdown) run(signals <-chan os.Signal) {
+	for sig := range signals {
+		s.signal(sig)
+	}
+}
+
+// signal handles a received signal
+func (s *shutdown) signal(sig os.Signal) {
+	now := s.now()
+	s.mu.Lock()
+	again := !s.signaled.IsZero() && now.Sub(s.signaled) <= forceQuitWindow
+	if !again {
+		s.signaled = now
+	}
+	s.mu.Unlock()
+	switch {
+	case again:
+		s.forceQuit(sig)
+	case s.ctx.Err() == nil:
+		fmt.Fprintln(s.out, "\n# Stopping, interrupt again to force quit")
+		s.cancel()
+	default:
+		fmt.Fprintln(s.out, "\n# Finishing the output first, interrupt again to force quit")
+		select {
+		case s.late <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forceQuit exits with the status of sig, once the events are dumped or
+// forceDumpTimeout has passed
+func (s *shutdown) forceQuit(sig os.Signal) {
+	s.mu.Lock()
+	dump := s.dump
+	s.mu.Unlock()
+	fmt.Fprintln(s.out, "\n# Forced to quit")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if dump != nil {
+			dump()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(forceDumpTimeout):
+		fmt.Fprintln(s.out, "# WARNING: the events could not be saved in time")
+	}
+	s.exit(signalExitCode(sig))
+}
+
+// memorySink keeps the events recorded so far, for the dump of a forced
+// quit while the session or the output hangs. With keepLast, only as many
+// of the last events are kept, as by -keep-last.
+type memorySink struct {
+	keepLast int
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *memorySink) Send(evt Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, evt)
+	if s.keepLast > 0 && len(s.events) > s.keepLast {
+		s.events = s.events[1:]
+	}
+}
+
+func (s *memorySink) Close(Stats) {}
+
+// recorded returns the events sent so far
+func (s *memorySink) recorded() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events[:len(s.events):len(s.events)]
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var out bytes.Buffer
+	s := newShutdown(ctx, cancel, &out)
+	code, dumped := -1, 0
+	s.exit = func(c int) { code = c }
+	s.onForce(func() { dumped++ })
+
+	s.signal(syscall.SIGINT)
+	if ctx.Err() == nil || code != -1 {
+		t.Fatalf("Expected the first signal to end the session, got exit %d", code)
+	}
+	if !strings.Contains(out.String(), "interrupt again to force quit") {
+		t.Errorf("Expected the hint, got %q", out.String())
+	}
+	select {
+	case <-s.late:
+		t.Error("Expected a signal during the session not to be late")
+	default:
+	}
+	s.signal(syscall.SIGTERM)
+	if code != 128+int(syscall.SIGTERM) || dumped != 1 {
+		t.Errorf("Expected the second signal to dump and exit with %d, got %d after %d dumps", 128+int(syscall.SIGTERM), code, dumped)
+	}
+
+	// after the session ended, the first signal is late
+	s = newShutdown(ctx, cancel, &out)
+	code = -1
+	s.exit = func(c int) { code = c }
+	s.signal(syscall.SIGINT)
+	select {
+	case <-s.late:
+	default:
+		t.Error("Expected a late signal")
+	}
+	if code != -1 {
+		t.Errorf("Expected no exit, got %d", code)
+	}
+	// once the output is written, there is nothing to dump
+	s.onForce(nil)
+	s.signal(syscall.SIGINT)
+	if code != 130 {
+		t.Errorf("Expected exit 130, got %d", code)
+	}
+
+	// a signal after the window starts it again, rather than forcing
+	at := time.Now()
+	s = newShutdown(ctx, cancel, &out)
+	s.now = func() time.Time { return at }
+	code = -1
+	s.exit = func(c int) { code = c }
+	s.signal(syscall.SIGINT)
+	at = at.Add(forceQuitWindow + time.Second)
+	out.Reset()
+	s.signal(syscall.SIGINT)
+	if code != -1 || !strings.Contains(out.String(), "interrupt again to force quit") {
+		t.Errorf("Expected a signal after the window not to force, got exit %d, %q", code, out.String())
+	}
+	at = at.Add(forceQuitWindow)
+	if s.signal(syscall.SIGINT); code != 130 {
+		t.Errorf("Expected a signal within the window to force, got exit %d", code)
+	}
+}
+
+func TestMemorySink(t *testing.T) {
+	events := testEvents(time.Second, time.Second, time.Second)
+	s := &memorySink{keepLast: 2}
+	for _, evt := range events {
+		s.Send(evt)
+	}
+	if got := s.recorded(); len(got) != 2 || got[0].Seq != 2 || got[1].Seq != 3 {
+		t.Errorf("Expected the last 2 events, got %v", got)
+	}
+}
+
+func TestCheckpointSinkFlush(t *testing.T) {
+	events := testEvents(time.Second, time.Second)
+	s, err := newCheckpointSink(filepath.Join(t.TempDir(), "stopwatch"), checkpoint{Session: "01SESSION"}, 0, os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flush(events[:2]); err != nil {
+		t.Fatal(err)
+	}
+	// the writer does not replace the flushed events
+	s.Send(events[0])
+	s.Close(ComputeStats(events))
+	c, err := readCheckpoint(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.events(); err != nil || len(got) != 2 {
+		t.Errorf("Expected the flushed events, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalSocket is where systemd-journald receives native protocol messages
+const journalSocket = "/run/systemd/journal/socket"
+
+func init() {
+	sinkKinds["journal"] = "systemd journal, with structured fields"
+}
+
+// journalSink writes every event into the systemd journal with structured
+// fields, using the native protocol:
+// https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
+type journalSink struct {
+	conn    *net.UnixConn
+	session string
+	errs    sinkErrors
+}
+
+// newJournalSink connects to the journal socket at path. Failures after
+// that are reported into errOut.
+func newJournalSink(path, session string, errOut io.Writer) (eventSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalSink{conn: conn, session: session, errs: sinkErrors{out: errOut, name: "journal"}}, nil
+}
+
+func (j *journalSink) Send(evt Event) {
+	j.send(eventMessage(evt, j.session), [][2]string{
+		{"STOPWATCH_SEQ", strconv.FormatInt(evt.Seq, 10)},
+		{"STOPWATCH_WHAT", evt.What},
+		{"STOPWATCH_TIMESTAMP", evt.Timestamp.Format(time.RFC3339Nano)},
+	})
+}
+
+func (j *journalSink) Close(s Stats) {
+	j.send(summaryMessage(s, j.session), [][2]string{
+		{"STOPWATCH_TOTAL", formatDuration(s.Total)},
+		{"STOPWATCH_LAPS", strconv.Itoa(len(s.Laps))},
+	})
+	j.conn.Close()
+}
+
+func (j *journalSink) send(message string, fields [][2]string) {
+	fields = append([][2]string{
+		{"MESSAGE", message},
+		{"PRIORITY", "6"}, // info
+		{"SYSLOG_IDENTIFIER", "stopwatch"},
+		{"STOPWATCH_SESSION", j.session},
+	}, fields...)
+	_, err := j.conn.Write(encodeJournalFields(fields))
+	j.errs.report(err)
+}
+
+// encodeJournalFields encodes fields as a native protocol datagram. Values
+// containing newlines are written length prefixed, the rest as KEY=value.
+func encodeJournalFields(fields [][2]string) []byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		key, value := f[0], f[1]
+		if !strings.Contains(value, "\n") {
+			buf.WriteString(key + "=" + value + "\n")
+			continue
+		}
+		buf.WriteString(key + "\n")
+		var size [8]byte
+		binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+		buf.Write(size[:])
+		buf.WriteString(value + "\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeJournalFields(t *testing.T) {
+	got := encodeJournalFields([][2]string{{"A", "1"}, {"B", "two\nlines"}, {"C", ""}})
+	want := []byte("A=1\nB\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nC=\n")
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// decodeJournalFields decodes a native protocol datagram into a map
+func decodeJournalFields(t *testing.T, data []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i]
+			data = data[i+1:]
+		} else {
+			data = nil
+		}
+		if i := bytes.IndexByte(line, '='); i >= 0 {
+			fields[string(line[:i])] = string(line[i+1:])
+			continue
+		}
+		if len(data) < 8 {
+			t.Fatalf("Truncated field %q", line)
+		}
+		size := binary.LittleEndian.Uint64(data)
+		fields[string(line)] = string(data[8 : 8+size])
+		data = data[8+size+1:]
+	}
+	return fields
+}
+
+func TestJournalSink(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Skip("unix datagram sockets not available:", err)
+	}
+	defer conn.Close()
+
+	var errOut bytes.Buffer
+	sink, err := newJournalSink(addr, "01SESSION", &errOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := testEvents(time.Second, 2*time.Second)
+	events[1].What = "two\nlines"
+	sink.Send(events[1])
+	sink.Close(ComputeStats(events))
+
+	buf := make([]byte, 4096)
+	var got []map[string]string
+	for i := 0; i < 2; i++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, decodeJournalFields(t, buf[:n]))
+	}
+	want := map[string]string{
+		"MESSAGE":             `seq=1 ts=2022-04-08T20:00:01Z what="two\nlines" session=01SESSION`,
+		"PRIORITY":            "6",
+		"SYSLOG_IDENTIFIER":   "stopwatch",
+		"STOPWATCH_SESSION":   "01SESSION",
+		"STOPWATCH_SEQ":       "1",
+		"STOPWATCH_WHAT":      "two\nlines",
+		"STOPWATCH_TIMESTAMP": "2022-04-08T20:00:01Z",
+	}
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("Expected fields %q, got %q", want, got[0])
+	}
+	if got[1]["STOPWATCH_LAPS"] != "1" || got[1]["STOPWATCH_TOTAL"] != "3s" {
+		t.Errorf("Unexpected summary fields %q", got[1])
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("Unexpected errors: %q", errOut.String())
+	}
+
+	if _, err := newJournalSink(filepath.Join(t.TempDir(), "missing"), "", &errOut); err == nil {
+		t.Error("Expected error for a missing socket")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// MarshallEventsJSON writes events into out in the JSON format written by
+// the CLI with "-format json". Like with MarshallEventsCSV, the comment is
+// included if non-empty.
+func MarshallEventsJSON(out io.Writer, events []Event, comment string) error {
+	return EncodeJSON(out, events, OutputOptions{Comment: comment, Columns: dataColumns(events, nil)})
+}
+
+// MarshallEventsNDJSON writes events into out in the newline delimited
+// JSON format written by the CLI with "-format ndjson"
+func MarshallEventsNDJSON(out io.Writer, events []Event) error {
+	return EncodeNDJSON(out, events, OutputOptions{Columns: dataColumns(events, nil)})
+}
+
+// EncodeJSON writes events into out as a JSON object with the schema version
+// (see schemaVersion), the session name and comment (if any), a "meta"
+// object of the metadata (if any) and an "events" array, one event per line.
+func EncodeJSON(out io.Writer, events []Event, opts OutputOptions) error {
+	w := bufio.NewWriter(out)
+	w.WriteString("{\n")
+	w.WriteString(`  "schema": ` + strconv.Itoa(schemaVersion) + ",\n")
+	for _, field := range []struct{ key, value string }{{"name", opts.Name}, {"comment", opts.Comment}} {
+		if field.value != "" {
+			w.WriteString("  " + jsonString(field.key) + ": " + jsonString(field.value) + ",\n")
+		}
+	}
+	if len(opts.Meta) > 0 {
+		// in the order of the metadata lines, like the columns
+		w.WriteString(`  "meta": {`)
+		for i, f := range opts.Meta {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(jsonString(f.Key) + ":" + jsonString(f.Value))
+		}
+		w.WriteString("},\n")
+	}
+	w.WriteString(`  "events": [`)
+	names := opts.eventColumnNames()
+	for i, evt := range events {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString("\n    ")
+		w.Write(marshalEventJSON(evt, names, opts))
+	}
+	if len(events) > 0 {
+		w.WriteString("\n  ")
+	}
+	w.WriteString("]\n}\n")
+	return w.Flush()
+}
+
+// EncodeNDJSON writes events into out as newline delimited JSON, one object
+// per event. The comment is not included.
+func EncodeNDJSON(out io.Writer, events []Event, opts OutputOptions) error {
+	w := bufio.NewWriter(out)
+	names := opts.eventColumnNames()
+	for _, evt := range events {
+		w.Write(marshalEventJSON(evt, names, opts))
+		w.WriteByte('\n')
+	}
+	return w.Flush()
+}
+
+// marshalEventJSON encodes the named columns of evt as a JSON object, with
+// keys in column order. Numeric columns become JSON numbers, and an absent
+// value becomes null; Go style durations are strings, see
+// OutputOptions.DurationStyle. Attributes are nested in an "attrs" object.
+func marshalEventJSON(evt Event, names []string, opts OutputOptions) []byte {
+	cols := make(map[string]eventColumn)
+	for _, col := range eventColumns() {
+		cols[col.name] = col
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(jsonString(opts.headerName(name)) + ":")
+		cell := opts.cell(evt, name)
+		switch col := cols[name]; {
+		case cell == "" && col.nullable:
+			b.WriteString("null")
+		case opts.jsonType(col) == "string":
+			b.WriteString(jsonString(cell))
+		default:
+			b.WriteString(cell)
+		}
+	}
+	if len(evt.Attrs) > 0 {
+		attrs, _ := json.Marshal(evt.Attrs) // keys are sorted
+		b.WriteString("," + jsonString(opts.headerName(attrsColumn)) + ":")
+		b.Write(attrs)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// jsonType returns the JSON type of the values of col written by
+// marshalEventJSON, besides the null of an empty nullable column
+func (opts OutputOptions) jsonType(col eventColumn) string {
+	switch {
+	case opts.goDurations() && isDurationColumn(col.name):
+		return "string"
+	case isDurationColumn(col.name), col.kind == reflect.Float64:
+		return "number"
+	case col.kind == reflect.Int, col.kind == reflect.Int64:
+		return "integer"
+	}
+	return "string"
+}
+
+// jsonString encodes s as a JSON string
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
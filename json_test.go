@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	v := 42.5
+	events := testEvents(time.Second, time.Second)
+	events[1].Value = &v
+	events[1].Attrs = map[string]string{"build": "release", "arch": "arm64"}
+
+	var buf bytes.Buffer
+	opts := OutputOptions{Comment: `say "hi"`, Columns: dataColumns(events, nil)}
+	if err := EncodeJSON(&buf, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	expect := `{
+  "schema": 2,
+  "comment": "say \"hi\"",
+  "events": [
+    {"seq":0,"ts":"2022-04-08T20:00:00Z","what":"enter","value":null},
+    {"seq":1,"ts":"2022-04-08T20:00:01Z","what":"tick","value":42.5,"attrs":{"arch":"arm64","build":"release"}},
+    {"seq":2,"ts":"2022-04-08T20:00:02Z","what":"exit","value":null}
+  ]
+}
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Error("Expected valid JSON")
+	}
+
+	buf.Reset()
+	if err := EncodeJSON(&buf, nil, OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "{\n  \"schema\": 2,\n  \"events\": []\n}\n" {
+		t.Errorf("Unexpected output for no events: %q", got)
+	}
+}
+
+func TestEncodeNDJSON(t *testing.T) {
+	events := testEvents(time.Second)
+	events[1].Attrs = map[string]string{"k": "v"}
+	var buf bytes.Buffer
+	if err := EncodeNDJSON(&buf, events, OutputOptions{Columns: []string{"ts_ns"}}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"seq":0,"ts":"2022-04-08T20:00:00Z","ts_ns":1649448000000000000,"what":"enter"}
+{"seq":1,"ts":"2022-04-08T20:00:01Z","ts_ns":1649448001000000000,"what":"exit","attrs":{"k":"v"}}
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+// goldenEvents are the events of testdata/events.json
+func goldenEvents() []Event {
+	v := 1.5
+	events := testEvents(time.Second, 2*time.Second, time.Second)
+	events[1].What = `lap "one", first`
+	events[2].Value = &v
+	events[2].Attrs = map[string]string{"runner": "a"}
+	return events
+}
+
+func TestMarshallEventsJSON(t *testing.T) {
+	expect, err := os.ReadFile("testdata/events.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := MarshallEventsJSON(&buf, goldenEvents(), "golden"); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(expect) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+
+	// the CLI writes the same
+	path := filepath.Join(t.TempDir(), "out.json")
+	if err := DumpEvents(path, goldenEvents(), OutputOptions{Format: "json", Comment: "golden"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(expect) {
+		t.Errorf("Expected -format json to match, got:\n%s", got)
+	}
+}
+
+func TestMarshallEventsNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := MarshallEventsNDJSON(&buf, goldenEvents()); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "out.ndjson")
+	if err := DumpEvents(path, goldenEvents(), OutputOptions{Format: "ndjson"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != buf.String() {
+		t.Errorf("Expected -format ndjson to match:\n%s\ngot:\n%s", buf.String(), got)
+	}
+}
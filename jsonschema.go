@@ -0,0 +1,165 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// jsonSchemaDialect is the JSON Schema version of the schema subcommand
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+func runSchema(args []string) int {
+	fs := newFlagSet("schema", "")
+	outFlags := addOutputFlags(fs, "format")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	opts, err := outFlags.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	if !flagWasSet(fs, "format") {
+		opts.Format = "ndjson"
+	}
+	if opts.Format != "json" && opts.Format != "ndjson" {
+		fmt.Fprintf(os.Stderr, "ERROR: -format %q has no JSON Schema (available: json, ndjson)\n", opts.Format)
+		return 2
+	}
+	out, _ := json.MarshalIndent(outputJSONSchema(opts), "", "  ")
+	fmt.Printf("%s\n", out)
+	return 0
+}
+
+// jsonSchema is a JSON Schema, with the keywords needed to describe the
+// output of EncodeJSON and EncodeNDJSON
+type jsonSchema struct {
+	Schema     string           `json:"$schema,omitempty"`
+	Ref        string           `json:"$ref,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Type       interface{}      `json:"type,omitempty"` // a type name, or a list of them
+	Format     string           `json:"format,omitempty"`
+	Const      interface{}      `json:"const,omitempty"`
+	Properties schemaProperties `json:"properties,omitempty"`
+	Required   []string         `json:"required,omitempty"`
+	Items      *jsonSchema      `json:"items,omitempty"`
+
+	// false, or the schema of the properties not listed
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+
+	Defs map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+// schemaProperties are the properties of an object, encoded in the order
+// the output has them
+type schemaProperties []schemaProperty
+
+type schemaProperty struct {
+	name   string
+	schema *jsonSchema
+}
+
+func (p schemaProperties) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, prop := range p {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value, err := json.Marshal(prop.schema)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(jsonString(prop.name) + ":")
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// outputJSONSchema returns the schema of the output of opts.Format: that
+// of each line for ndjson, and of the whole document for json
+func outputJSONSchema(opts OutputOptions) *jsonSchema {
+	event := eventJSONSchema(opts)
+	if opts.Format != "json" {
+		event.Schema = jsonSchemaDialect
+		return event
+	}
+	event.Title = ""
+	return &jsonSchema{
+		Schema: jsonSchemaDialect,
+		Title:  "stopwatch-go events",
+		Type:   "object",
+		Properties: schemaProperties{
+			{"schema", &jsonSchema{Type: "integer", Const: schemaVersion}},
+			{"name", &jsonSchema{Type: "string"}},
+			{"comment", &jsonSchema{Type: "string"}},
+			{"meta", &jsonSchema{Type: "object", AdditionalProperties: &jsonSchema{Type: "string"}}},
+			{"events", &jsonSchema{Type: "array", Items: &jsonSchema{Ref: "#/$defs/event"}}},
+		},
+		Required:             []string{"schema", "events"},
+		AdditionalProperties: false,
+		Defs:                 map[string]*jsonSchema{"event": event},
+	}
+}
+
+// eventJSONSchema returns the schema of an event object written by
+// marshalEventJSON with opts. The columns of opts, and the offsets of
+// -since, are required; the columns of dataColumns are written only when
+// the events have such data; and the attributes only when an event has
+// them. The types are those of the columns, see OutputOptions.jsonType.
+func eventJSONSchema(opts OutputOptions) *jsonSchema {
+	columns := append([]string(nil), opts.Columns...)
+	if !opts.Since.IsZero() {
+		columns = append(columns, "offset")
+	}
+	opts.Columns = columns
+	required := opts.eventColumnNames()
+	for _, col := range presentColumns {
+		columns = append(columns, col.name)
+	}
+	opts.Columns = columns
+	cols := make(map[string]eventColumn)
+	for _, col := range eventColumns() {
+		cols[col.name] = col
+	}
+
+	s := &jsonSchema{Title: "stopwatch-go event", Type: "object", AdditionalProperties: false}
+	for _, name := range opts.eventColumnNames() {
+		col := cols[name]
+		prop := &jsonSchema{Type: opts.jsonType(col)}
+		if col.nullable {
+			prop.Type = []string{opts.jsonType(col), "null"}
+		}
+		if name == "ts" {
+			prop.Format = "date-time"
+		}
+		s.Properties = append(s.Properties, schemaProperty{opts.headerName(name), prop})
+	}
+	for _, name := range required {
+		s.Required = append(s.Required, opts.headerName(name))
+	}
+	s.Properties = append(s.Properties, schemaProperty{opts.headerName(attrsColumn),
+		&jsonSchema{Type: "object", AdditionalProperties: &jsonSchema{Type: "string"}}})
+	return s
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validateJSON checks value, as decoded by encoding/json, against the
+// keywords of schema that jsonSchema has. A $ref is resolved in root.
+func validateJSON(value interface{}, schema, root map[string]interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		def, ok := root["$defs"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: unresolved $ref %q", path, ref)
+		}
+		return validateJSON(value, def, root, path)
+	}
+	if typ, ok := schema["type"]; ok {
+		types, ok := typ.([]interface{})
+		if !ok {
+			types = []interface{}{typ}
+		}
+		matched := false
+		for _, t := range types {
+			matched = matched || jsonTypeOf(value, t.(string))
+		}
+		if !matched {
+			return fmt.Errorf("%s: %v is not of type %v", path, value, typ)
+		}
+	}
+	if c, ok := schema["const"]; ok && c != value {
+		return fmt.Errorf("%s: %v is not %v", path, value, c)
+	}
+	if schema["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339Nano, value.(string)); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range value.([]interface{}) {
+			if err := validateJSON(item, items, root, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	required, _ := schema["required"].([]interface{})
+	for _, name := range required {
+		if _, ok := obj[name.(string)]; !ok {
+			return fmt.Errorf("%s: missing %q", path, name)
+		}
+	}
+	props, _ := schema["properties"].(map[string]interface{})
+	for name, v := range obj {
+		prop, ok := props[name].(map[string]interface{})
+		if !ok {
+			switch more := schema["additionalProperties"].(type) {
+			case bool:
+				return fmt.Errorf("%s: unexpected %q", path, name)
+			case map[string]interface{}:
+				prop = more
+			}
+		}
+		if err := validateJSON(v, prop, root, path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonTypeOf reports whether value decoded by encoding/json is of the JSON
+// Schema type typ
+func jsonTypeOf(value interface{}, typ string) bool {
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || typ == "integer" && v == float64(int64(v))
+	case []interface{}:
+		return typ == "array"
+	case map[string]interface{}:
+		return typ == "object"
+	}
+	return false
+}
+
+// decodeSchema returns the schema of opts as encoding/json decodes it
+func decodeSchema(t *testing.T, opts OutputOptions) map[string]interface{} {
+	b, err := json.Marshal(outputJSONSchema(opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestEventJSONSchema(t *testing.T) {
+	v, d := 2.5, -0.25
+	events := testEvents(time.Second, 1500*time.Millisecond, time.Second)
+	for i := range events {
+		events[i].ID, _ = NewULID(events[i].Timestamp)
+		events[i].Source = "stdin"
+	}
+	events[1].Value = &v
+	events[1].Attrs = map[string]string{"lane": "3"}
+	events[2].VsTarget = &d
+	events[2].Phase = "work"
+
+	for _, opts := range []OutputOptions{
+		{Format: "ndjson", Columns: []string{"id", "source"}},
+		{Format: "ndjson", Columns: []string{"ts_ns", "vs_target", "tz"}, DurationStyle: durationStyleBoth},
+		{Format: "ndjson", Since: events[1].Timestamp, DurationStyle: durationStyleGo, Rename: map[string]string{"ts": "time", "attrs": "tags"}},
+		{Format: "json", Name: "run", Comment: "synthetic", Meta: []metaField{{"host", "vm"}}, Columns: []string{"id"}},
+	} {
+		schema := decodeSchema(t, opts)
+		if schema["$schema"] != jsonSchemaDialect {
+			t.Errorf("Expected the dialect, got %v", schema["$schema"])
+		}
+		encode, prepared, prepOpts, err := prepareOutput(events, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := encode(&buf, prepared, prepOpts); err != nil {
+			t.Fatal(err)
+		}
+		var docs []string
+		if opts.Format == "json" {
+			docs = []string{buf.String()}
+		} else {
+			for sc := bufio.NewScanner(&buf); sc.Scan(); {
+				docs = append(docs, sc.Text())
+			}
+		}
+		for _, doc := range docs {
+			var value interface{}
+			if err := json.Unmarshal([]byte(doc), &value); err != nil {
+				t.Fatal(err)
+			}
+			if err := validateJSON(value, schema, schema, "$"); err != nil {
+				t.Errorf("%+v: %s: %v", opts, doc, err)
+			}
+		}
+	}
+
+	// the columns of the flags are required, and no others are allowed
+	schema := decodeSchema(t, OutputOptions{Format: "ndjson", Columns: []string{"id", "source"}})
+	for doc, want := range map[string]string{
+		`{"seq":0,"ts":"2022-04-08T20:00:00Z","what":"enter","source":"stdin"}`:                     `missing "id"`,
+		`{"seq":0,"ts":"2022-04-08T20:00:00Z","what":"enter","id":"x","source":"stdin","tz":"UTC"}`: `unexpected "tz"`,
+		`{"seq":0.5,"ts":"2022-04-08T20:00:00Z","what":"enter","id":"x","source":"stdin"}`:          "not of type integer",
+		`{"seq":0,"ts":"yesterday","what":"enter","id":"x","source":"stdin"}`:                       "cannot parse",
+		`{"seq":0,"ts":"2022-04-08T20:00:00Z","what":"a","id":"x","source":"tcp","attrs":{"n":1}}`:  "not of type string",
+	} {
+		var value interface{}
+		if err := json.Unmarshal([]byte(doc), &value); err != nil {
+			t.Fatal(err)
+		}
+		if err := validateJSON(value, schema, schema, "$"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error containing %q, got %v", doc, want, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// labelMaxLength is the length, in characters, sanitizeLabel cuts labels to
+const labelMaxLength = 200
+
+// ParseLabels parses a comma separated list of labels for -labels, e.g.
+// "warmup,run,cooldown". Labels may repeat. An empty spec is no list.
+func ParseLabels(spec string) ([]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var labels []string
+	for _, part := range strings.Split(spec, ",") {
+		label := strings.TrimSpace(part)
+		if label == "" {
+			return nil, fmt.Errorf("empty label in %q", spec)
+		}
+		if isReserved(label) {
+			return nil, fmt.Errorf("label %q is reserved", label)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// tickSources are the inputs whose ticks can be labeled by -source-label
+// and -source-prefix, by the Source of their events
+var tickSources = []string{sourceStdin, sourceTCP, sourceUDP, sourceWatch, sourceHTTP, sourceSocket, sourceSignal, sourceTimer}
+
+// parseSourceMap parses the source=value pairs of the flag name, e.g.
+// "udp=sensor/" of -source-prefix, into a map keyed by the source. The
+// source must be among configured, the inputs of the session; any of
+// tickSources is known. With labels, the values are plain tick labels,
+// so not reserved.
+func parseSourceMap(name string, pairs, configured []string, labels bool) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for _, pair := range pairs {
+		source, value, ok := strings.Cut(pair, "=")
+		if !ok || source == "" || value == "" {
+			return nil, fmt.Errorf("-%s %q: expected source=value, e.g. udp=sensor", name, pair)
+		}
+		if !containsString(tickSources, source) {
+			return nil, fmt.Errorf("-%s %q: unknown source %q (known: %s)", name, pair, source, strings.Join(tickSources, ", "))
+		}
+		if !containsString(configured, source) {
+			return nil, fmt.Errorf("-%s %q: no %s input in this session (inputs: %s)", name, pair, source, strings.Join(configured, ", "))
+		}
+		if _, ok := m[source]; ok {
+			return nil, fmt.Errorf("-%s %q: %s given twice", name, pair, source)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("-%s %q: the value must be on one line", name, pair)
+		}
+		if labels && isReserved(value) {
+			return nil, fmt.Errorf("-%s %q: label %q is reserved", name, pair, value)
+		}
+		m[source] = value
+	}
+	return m, nil
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadLabelsFile reads the steps of -labels-file from path, see ParseLabelsFile
+func ReadLabelsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseLabelsFile(f)
+}
+
+// ParseLabelsFile parses a list of labels, one per line. Empty lines and
+// lines starting with '#' are skipped. A UTF-8 byte order mark and CRLF line
+// endings are accepted.
+func ParseLabelsFile(r io.Reader) ([]string, error) {
+	var labels []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		label := strings.TrimSpace(line)
+		if label == "" || strings.HasPrefix(label, "#") {
+			continue
+		}
+		if isReserved(label) {
+			return nil, fmt.Errorf("line %d: label %q is reserved", n, label)
+		}
+		labels = append(labels, label)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("no labels found")
+	}
+	return labels, nil
+}
+
+// sanitizeLabel makes label safe to write as a single line of the output:
+// surrounding white space is trimmed, line breaks within the label are
+// replaced by a visible "\n" or "\r", other control
+// characters are removed, and labels longer than labelMaxLength are cut
+// short with an ellipsis. Labels of only spaces and control characters
+// become empty, i.e. a plain tick.
+func sanitizeLabel(label string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(label) {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < ' ' || r == 0x7f:
+			continue
+		default:
+			b.WriteRune(r)
+		}
+	}
+	label = strings.TrimSpace(b.String())
+	if utf8.RuneCountInString(label) > labelMaxLength {
+		label = string([]rune(label)[:labelMaxLength-1]) + "\u2026"
+	}
+	return label
+}
+
+// cleanLabel prepares a label received from the input being handled for
+// recording: invalid UTF-8 is replaced by U+FFFD, and with -normalize and
+// -sanitize-labels, the label is normalized and sanitized
+func (s *Session) cleanLabel(label string) string {
+	label = validUTF8(label)
+	if s.opts.Normalize {
+		label = normalizeNFC(label)
+	}
+	if s.sanitizes() {
+		label = sanitizeLabel(label)
+	}
+	return label
+}
+
+// sanitizes reports whether the labels of the input being handled are
+// passed through sanitizeLabel, see -sanitize-labels
+func (s *Session) sanitizes() bool {
+	switch s.source {
+	case sourceStdin, "":
+		return s.opts.SanitizeStdin
+	case sourceTimer:
+		return false
+	}
+	return s.opts.SanitizeLabels
+}
+
+// plainLabel returns the label of a plain tick past the -labels, see
+// recordTick
+func (s *Session) plainLabel() string {
+	if s.opts.TickLabel != "" {
+		return s.opts.TickLabel
+	}
+	return labelTick
+}
+
+// upcomingLabel returns the label the next plain tick takes from -labels
+func (s *Session) upcomingLabel() (string, bool) {
+	n := len(s.opts.Labels)
+	if n == 0 {
+		return "", false
+	}
+	i := s.labelIndex % n
+	if s.labelIndex >= n {
+		if s.opts.LabelSteps {
+			return "", false
+		}
+		if s.opts.LabelsNoWrap {
+			i = n - 1
+		}
+	}
+	return s.opts.Labels[i], true
+}
+
+// stepsDone reports whether all the -labels-file steps have been recorded
+func (s *Session) stepsDone() bool {
+	return s.opts.LabelSteps && s.labelIndex >= len(s.opts.Labels)
+}
+
+// nextLabel describes the next tick in the prompt: its number, or the
+// step and its label
+func (s *Session) nextLabel() string {
+	n := len(s.Events) - s.groupStart
+	switch label, ok := s.upcomingLabel(); {
+	case s.opts.LabelSteps && ok:
+		return fmt.Sprintf("step %d/%d: %s", s.labelIndex+1, len(s.opts.Labels), label)
+	case s.stepsDone() && s.opts.LabelsStrict:
+		return "all steps done"
+	case ok:
+		return fmt.Sprintf("%d: %s", n, label)
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("warmup, run,cooldown,run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"warmup", "run", "cooldown", "run"}; !reflect.DeepEqual(labels, expect) {
+		t.Errorf("Expected %q, got %q", expect, labels)
+	}
+	if labels, err := ParseLabels(""); err != nil || labels != nil {
+		t.Errorf("Expected no labels, got %q, %v", labels, err)
+	}
+	for _, spec := range []string{"run,", ",run", "run,,rest", "run,exit", "mark:x"} {
+		if _, err := ParseLabels(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestSessionLabelList(t *testing.T) {
+	for _, noWrap := range []bool{false, true} {
+		sess := newSession("", collectOptions{Labels: []string{"warmup", "run"}, LabelsNoWrap: noWrap})
+		var out bytes.Buffer
+		sess.start()
+		if got := sess.prompt(); got != "# Waiting for [1: warmup]> " {
+			t.Errorf("Unexpected prompt: %q", got)
+		}
+		// a typed label does not advance the list, a value alone does
+		for _, line := range []string{"", "water", "42", "", ""} {
+			sess.handleLine(line, &out)
+		}
+		sess.finish()
+
+		var got []string
+		for _, evt := range sess.Events {
+			got = append(got, evt.What)
+		}
+		want := []string{labelEnter, "warmup", "water", "run", "warmup", "run", labelExit}
+		if noWrap {
+			want[5], want[4] = "run", "run"
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %q (no-wrap %v), got %q", want, noWrap, got)
+		}
+		if out.Len() != 0 {
+			t.Errorf("Unexpected output: %q", out.String())
+		}
+	}
+}
+
+func TestSessionLabelListArm(t *testing.T) {
+	sess := newSession("", collectOptions{Arm: true, Labels: []string{"a", "b"}})
+	var out bytes.Buffer
+	sess.start()
+	sess.handleLine("", &out)
+	if got := sess.prompt(); got != "# Waiting for [2: b]> " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	if sess.Events[1].What != "a" {
+		t.Errorf("Expected the arming tick to take the first label, got %v", sess.Events)
+	}
+}
+
+func TestLapsByLabel(t *testing.T) {
+	events := testEvents(seconds(60, 300, 90, 240, 60, 5)...)
+	for i, label := range []string{"warmup", "run", "rest", "run", "cooldown"} {
+		events[i+1].What = label
+	}
+	labels := LapsByLabel(events)
+	expect := []LabelLaps{
+		{"warmup", seconds(60)},
+		{"run", seconds(300, 240)},
+		{"rest", seconds(90)},
+		{"cooldown", seconds(60)},
+	}
+	if !reflect.DeepEqual(labels, expect) {
+		t.Fatalf("Expected %v, got %v", expect, labels)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteLabelSummary(&buf, labels[:2]); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Label warmup: laps: 1, total: 1m0s, avg: 1m0s\n# Label run: laps: 2, total: 9m0s, avg: 4m30s\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+	if got := LapsByLabel(nil); got != nil {
+		t.Errorf("Expected no labels, got %v", got)
+	}
+}
+
+func TestParseLabelsFile(t *testing.T) {
+	input := "\ufeff# protocol v2\r\nwash\r\n\r\n  rinse  \r\n#dry\r\nspin cycle"
+	labels, err := ParseLabelsFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"wash", "rinse", "spin cycle"}; !reflect.DeepEqual(labels, expect) {
+		t.Errorf("Expected %q, got %q", expect, labels)
+	}
+	for _, input := range []string{"", "# nothing\n\n", "wash\nexit\n"} {
+		if _, err := ParseLabelsFile(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestSessionLabelSteps(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		sess := newSession("", collectOptions{Labels: []string{"wash", "rinse"}, LabelSteps: true, LabelsStrict: strict})
+		var out bytes.Buffer
+		sess.start()
+		sess.handleLine("", &out)
+		if got := sess.prompt(); got != "# Waiting for [step 2/2: rinse]> " {
+			t.Errorf("Unexpected prompt: %q", got)
+		}
+		sess.handleLine("", &out)
+		sess.handleLine("", &out)
+		sess.handleLine("dry", &out)
+		sess.finish()
+
+		var got []string
+		for _, evt := range sess.Events {
+			got = append(got, evt.What)
+		}
+		want := []string{labelEnter, "wash", "rinse", labelTick, "dry", labelExit}
+		prompt, msg := "# Waiting for [5]> ", ""
+		if strict {
+			want = []string{labelEnter, "wash", "rinse", labelExit}
+			prompt = "# Waiting for [all steps done]> "
+			msg = "# All 2 steps done, not recorded\n# All 2 steps done, not recorded\n"
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %q (strict %v), got %q", want, strict, got)
+		}
+		sess.Events = sess.Events[:len(sess.Events)-1]
+		if got := sess.prompt(); got != prompt {
+			t.Errorf("Expected prompt %q (strict %v), got %q", prompt, strict, got)
+		}
+		if out.String() != msg {
+			t.Errorf("Unexpected output (strict %v): %q", strict, out.String())
+		}
+	}
+}
+
+func TestSanitizeLabel(t *testing.T) {
+	long := strings.Repeat("é", labelMaxLength+1)
+	tests := []struct{ label, want string }{
+		{"lap 1", "lap 1"},
+		{"two\nlines", `two\nlines`},
+		{"crlf\r\nend\r\n", `crlf\r\nend`},
+		{"bell\a tab\there\x1b[31m", "bell tabhere[31m"},
+		{"\x00\x01\x7f\x1f", ""},
+		{" \t \n  ", ""},
+		{"\x02 x \x03", "x"},
+		{long, strings.Repeat("é", labelMaxLength-1) + "…"},
+		{long[:2*labelMaxLength], long[:2*labelMaxLength]},
+	}
+	for _, tt := range tests {
+		if got := sanitizeLabel(tt.label); got != tt.want {
+			t.Errorf("sanitizeLabel(%q): expected %q, got %q", tt.label, tt.want, got)
+		}
+	}
+}
+
+func TestSessionSanitizeLabels(t *testing.T) {
+	sess := newSession("", collectOptions{SanitizeLabels: true})
+	sess.start()
+	var out bytes.Buffer
+	remote := func(text string) {
+		sess.handleInput(sourceTCP, time.Time{}, func() { sess.handleRemote(remoteLine{text: text, exact: true}, &out) })
+	}
+	remote("a\nb")
+	remote("\x01\x02")
+	remote("   ")
+	sess.handleInput(sourceWatch, time.Time{}, func() {
+		sess.recordTick(Event{What: "file:x\ny", Attrs: map[string]string{"path": "d/x\ny"}}, &out)
+	})
+	// typed on a terminal: kept as is
+	sess.handleLine("c\x01", &out)
+
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.What)
+	}
+	want := []string{labelEnter, `a\nb`, labelTick, labelTick, `file:x\ny`, "c\x01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if path := sess.Events[4].Attrs["path"]; path != `d/x\ny` {
+		t.Errorf("Expected the path attribute to be sanitized, got %q", path)
+	}
+
+	sess.opts.SanitizeStdin = true
+	sess.handleLine("mark m\x1b", &out)
+	if got := sess.Events[len(sess.Events)-1].What; got != "mark:m" {
+		t.Errorf("Expected mark:m, got %q", got)
+	}
+}
+
+func TestSessionSourceLabels(t *testing.T) {
+	sess := newSession("", collectOptions{
+		Labels: []string{"warmup", "run"}, LabelsNoWrap: true, TickLabel: "lap",
+		SourceLabels:   map[string]string{sourceUDP: "door"},
+		SourcePrefixes: map[string]string{sourceUDP: "sensor/", sourceTCP: "remote/"},
+	})
+	at := 0
+	setClock(sess, &at)
+	sess.start()
+	var out bytes.Buffer
+	remote := func(source, text string) {
+		sess.handleInput(source, time.Time{}, func() { sess.handleRemote(remoteLine{text: text, exact: true}, &out) })
+	}
+	remote(sourceUDP, "")       // the source label, without advancing -labels
+	remote(sourceUDP, "opened") // typed, with the prefix
+	remote(sourceTCP, "")       // -labels, with the prefix
+	sess.handleLine("", &out)   // -labels
+	sess.handleLine("x", &out)  // typed
+	remote(sourceTCP, "exit")   // reserved before the prefix
+	sess.opts.LabelsNoWrap = false
+	sess.opts.Labels = nil
+	remote(sourceTCP, "")     // -tick-label
+	sess.handleLine("", &out) // -tick-label
+	at = 10
+	sess.handleInput(sourceUDP, time.Time{}, func() { sess.handleLine("tick -1s", &out) })
+
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.What)
+	}
+	want := []string{labelEnter, "sensor/door", "sensor/opened", "remote/warmup", "run", "x", "remote/lap", "lap", "sensor/door"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if !strings.Contains(out.String(), `Label "exit" is reserved`) {
+		t.Errorf("Expected the reserved label to be refused, got %q", out.String())
+	}
+}
+
+func TestParseSourceMap(t *testing.T) {
+	inputs := []string{sourceStdin, sourceUDP}
+	m, err := parseSourceMap("source-prefix", []string{"udp=sensor/", "stdin=me:"}, inputs, false)
+	if err != nil || !reflect.DeepEqual(m, map[string]string{sourceUDP: "sensor/", sourceStdin: "me:"}) {
+		t.Errorf("Unexpected map %v, %v", m, err)
+	}
+	for _, pair := range []string{"udp", "=x", "udp=", "serial=x", "tcp=x", "udp=exit", "udp=a\nb"} {
+		if _, err := parseSourceMap("source-label", []string{pair}, inputs, true); err == nil {
+			t.Errorf("Expected an error for %q", pair)
+		}
+	}
+	if _, err := parseSourceMap("source-label", []string{"udp=a", "udp=b"}, inputs, true); err == nil {
+		t.Error("Expected an error for a source given twice")
+	}
+	if _, err := parseSourceMap("source-prefix", []string{"udp=exit"}, inputs, false); err != nil {
+		t.Errorf("Expected a prefix to be anything, got %v", err)
+	}
+}
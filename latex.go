@@ -0,0 +1,90 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// latexEscape escapes the characters that are special in LaTeX text
+var latexEscape = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"&", `\&`,
+	"%", `\%`,
+	"$", `\$`,
+	"#", `\#`,
+	"_", `\_`,
+	"{", `\{`,
+	"}", `\}`,
+	"~", `\textasciitilde{}`,
+	"^", `\textasciicircum{}`,
+	"\r", " ",
+	"\n", " ",
+).Replace
+
+// latexColumnSpec derives the tabular column specification from the types
+// of the Event fields in header: numbers are right aligned, everything else
+// left.
+func latexColumnSpec(header []string) string {
+	kinds := make(map[string]reflect.Kind)
+	for _, col := range eventColumns() {
+		kinds[col.name] = col.kind
+	}
+	var spec strings.Builder
+	for _, name := range header {
+		switch kinds[name] {
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			spec.WriteByte('r')
+		default:
+			spec.WriteByte('l')
+		}
+	}
+	return spec.String()
+}
+
+// EncodeLaTeX writes events into out as a LaTeX tabular environment with
+// booktabs style rules. With opts.LaTeXFloat, the tabular is wrapped in a
+// table float with the comment as its caption; otherwise the comment is
+// written as a LaTeX comment line.
+func EncodeLaTeX(out io.Writer, events []Event, opts OutputOptions) error {
+	w := bufio.NewWriter(out)
+	if opts.LaTeXFloat {
+		w.WriteString("\\begin{table}\n\\centering\n")
+		if opts.Comment != "" {
+			w.WriteString("\\caption{" + latexEscape(opts.Comment) + "}\n")
+		}
+	} else if opts.Comment != "" {
+		w.WriteString("% " + strings.NewReplacer("\r", " ", "\n", " ").Replace(opts.Comment) + "\n")
+	}
+	header := opts.Header()
+	w.WriteString("\\begin{tabular}{" + latexColumnSpec(header) + "}\n\\toprule\n")
+	for i, rec := range opts.records(events) {
+		for j, cell := range rec {
+			rec[j] = latexEscape(cell)
+		}
+		w.WriteString(strings.Join(rec, " & ") + " \\\\\n")
+		if i == 0 {
+			w.WriteString("\\midrule\n")
+		}
+	}
+	w.WriteString("\\bottomrule\n\\end{tabular}\n")
+	if opts.LaTeXFloat {
+		w.WriteString("\\end{table}\n")
+	}
+	return w.Flush()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEncodeLaTeX(t *testing.T) {
+	events := testEvents(time.Second, time.Second)
+	events[1].What = "50% of a_b & c"
+
+	var buf bytes.Buffer
+	if err := EncodeLaTeX(&buf, events, OutputOptions{Comment: "run #1", LaTeXFloat: true}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `\begin{table}
+\centering
+\caption{run \#1}
+\begin{tabular}{rll}
+\toprule
+seq & ts & what \\
+\midrule
+0 & 2022-04-08T20:00:00Z & enter \\
+1 & 2022-04-08T20:00:01Z & 50\% of a\_b \& c \\
+2 & 2022-04-08T20:00:02Z & exit \\
+\bottomrule
+\end{tabular}
+\end{table}
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestEncodeLaTeXNoFloat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeLaTeX(&buf, testEvents(time.Second), OutputOptions{Comment: "run 1"}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `% run 1
+\begin{tabular}{rll}
+\toprule
+seq & ts & what \\
+\midrule
+0 & 2022-04-08T20:00:00Z & enter \\
+1 & 2022-04-08T20:00:01Z & exit \\
+\bottomrule
+\end{tabular}
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
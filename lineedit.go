@@ -0,0 +1,350 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lineEditor reads the lines typed into a terminal in cbreak mode (see
+// cbreak), echoing them itself: a minimal readline, with the cursor moved
+// by the arrow keys, the lines typed before recalled by up and down, and
+// Tab completing the line from them and from words.
+type lineEditor struct {
+	in    *bufio.Reader
+	out   io.Writer
+	words []string // completed besides the history, e.g. the -labels-file labels
+
+	mu      sync.Mutex // the line is redrawn by the prompt too, see Pending
+	line    []rune     // being typed
+	pos     int        // of the cursor in line
+	history []string   // oldest first
+}
+
+func newLineEditor(in io.Reader, out io.Writer, words []string) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out, words: words}
+}
+
+// the keys handled, besides the escape sequences of the arrows, Home, End
+// and Delete
+const (
+	keyCtrlA     = 0x01 // home
+	keyCtrlB     = 0x02 // left
+	keyCtrlD     = 0x04 // delete, or EOF on an empty line
+	keyCtrlE     = 0x05 // end
+	keyCtrlF     = 0x06 // right
+	keyBackspace = 0x08
+	keyTab       = '\t'
+	keyCtrlK     = 0x0b // delete to the end
+	keyCtrlN     = 0x0e // down
+	keyCtrlP     = 0x10 // up
+	keyCtrlU     = 0x15 // delete to the start
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f // sent by the backspace key of most terminals
+)
+
+// ReadLine returns the next line typed, without the newline. Ctrl-D on an
+// empty line returns io.EOF, as does the end of the input; a line typed
+// without its newline is returned first.
+func (e *lineEditor) ReadLine() (string, error) {
+	hist := -1 // the entry of the history shown; -1 for the line typed
+	var draft []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return e.finish(err)
+		}
+		e.mu.Lock()
+		switch r {
+		case '\r', '\n':
+			e.mu.Unlock()
+			return e.finish(nil)
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				e.mu.Unlock()
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+		case keyBackspace, keyDelete:
+			e.deleteAt(e.pos - 1)
+		case keyCtrlA:
+			e.moveTo(0)
+		case keyCtrlE:
+			e.moveTo(len(e.line))
+		case keyCtrlB:
+			e.moveTo(e.pos - 1)
+		case keyCtrlF:
+			e.moveTo(e.pos + 1)
+		case keyCtrlK:
+			e.replace(e.line[:e.pos:e.pos], e.pos)
+		case keyCtrlU:
+			e.replace(e.line[e.pos:], 0)
+		case keyCtrlP, keyCtrlN:
+			hist, draft = e.recall(r == keyCtrlP, hist, draft)
+		case keyTab:
+			e.complete()
+		case keyEscape:
+			e.mu.Unlock()
+			key := e.escape()
+			e.mu.Lock()
+			switch key {
+			case 'A', 'B':
+				hist, draft = e.recall(key == 'A', hist, draft)
+			case 'C':
+				e.moveTo(e.pos + 1)
+			case 'D':
+				e.moveTo(e.pos - 1)
+			case 'H':
+				e.moveTo(0)
+			case 'F':
+				e.moveTo(len(e.line))
+			case '~':
+				e.deleteAt(e.pos)
+			}
+		default:
+			if r >= ' ' {
+				e.insert(r)
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// finish ends the line typed, adding it to the history; err is returned
+// if nothing was typed
+func (e *lineEditor) finish(err error) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	line := string(e.line)
+	if err != nil && line == "" {
+		return "", err
+	}
+	e.moveTo(len(e.line))
+	fmt.Fprint(e.out, "\n")
+	e.line, e.pos = nil, 0
+	if text := strings.TrimSpace(line); text != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != text) {
+		e.history = append(e.history, text)
+	}
+	return line, nil
+}
+
+// escape reads the rest of an escape sequence, and returns the key: 'A' to
+// 'D' for the arrows, 'H' and 'F' for Home and End, '~' for Delete, or 0
+// for any other key
+func (e *lineEditor) escape() byte {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	var param strings.Builder
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0
+		}
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+		param.WriteRune(r)
+	}
+	switch {
+	case r != '~':
+		if strings.ContainsRune("ABCDHF", r) {
+			return byte(r)
+		}
+	case param.String() == "1" || param.String() == "7":
+		return 'H'
+	case param.String() == "4" || param.String() == "8":
+		return 'F'
+	case param.String() == "3":
+		return '~'
+	}
+	return 0
+}
+
+// recall shows the previous entry of the history if up is set, else the
+// next one, the line typed being kept as draft. Returns the entry shown,
+// and the draft.
+func (e *lineEditor) recall(up bool, hist int, draft []rune) (int, []rune) {
+	if hist == -1 {
+		hist = len(e.history)
+	}
+	switch {
+	case up && hist > 0:
+		if hist == len(e.history) {
+			draft = append([]rune(nil), e.line...)
+		}
+		hist--
+	case !up && hist < len(e.history):
+		hist++
+	default:
+		return hist, draft
+	}
+	line := draft
+	if hist < len(e.history) {
+		line = []rune(e.history[hist])
+	}
+	e.replace(line, len(line))
+	if hist == len(e.history) {
+		hist = -1
+	}
+	return hist, draft
+}
+
+// complete extends the text before the cursor with the longest prefix
+// shared by the entries of the history and the words starting with it
+func (e *lineEditor) complete() {
+	typed := string(e.line[:e.pos])
+	var common []rune
+	found := false
+	for _, candidates := range [][]string{e.history, e.words} {
+		for _, c := range candidates {
+			if !strings.HasPrefix(c, typed) {
+				continue
+			}
+			if !found {
+				common, found = []rune(c), true
+				continue
+			}
+			rc := []rune(c)
+			n := 0
+			for n < len(common) && n < len(rc) && common[n] == rc[n] {
+				n++
+			}
+			common = common[:n]
+		}
+	}
+	if len(common) <= e.pos {
+		return
+	}
+	line := append(common, e.line[e.pos:]...)
+	e.replace(line, len(common))
+}
+
+func (e *lineEditor) insert(r rune) {
+	if e.pos == len(e.line) {
+		e.line = append(e.line, r)
+		e.pos++
+		fmt.Fprint(e.out, string(r))
+		return
+	}
+	line := append(append(append([]rune(nil), e.line[:e.pos]...), r), e.line[e.pos:]...)
+	e.replace(line, e.pos+1)
+}
+
+// deleteAt removes the rune at i, if any
+func (e *lineEditor) deleteAt(i int) {
+	if i < 0 || i >= len(e.line) {
+		return
+	}
+	line := append(append([]rune(nil), e.line[:i]...), e.line[i+1:]...)
+	e.replace(line, i)
+}
+
+// moveTo moves the cursor to pos, within the line
+func (e *lineEditor) moveTo(pos int) {
+	if pos < 0 || pos > len(e.line) || pos == e.pos {
+		return
+	}
+	if pos < e.pos {
+		fmt.Fprint(e.out, cursorBack(displayWidth(string(e.line[pos:e.pos]))))
+	} else {
+		fmt.Fprint(e.out, string(e.line[e.pos:pos]))
+	}
+	e.pos = pos
+}
+
+// replace redraws the line as line, with the cursor at pos
+func (e *lineEditor) replace(line []rune, pos int) {
+	fmt.Fprint(e.out, cursorBack(displayWidth(string(e.line[:e.pos])))+"\x1b[K"+string(line)+
+		cursorBack(displayWidth(string(line[pos:]))))
+	e.line, e.pos = line, pos
+}
+
+// Pending returns the line being typed, for the prompt drawn again to show
+// it, with the cursor where it was
+func (e *lineEditor) Pending() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return string(e.line) + cursorBack(displayWidth(string(e.line[e.pos:])))
+}
+
+// cursorBack moves the cursor of a terminal n columns left
+func cursorBack(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\x1b[%dD", n)
+}
+
+// cbreakMode is the mode of the terminal of the line editor, left while
+// the process is suspended, see watchSuspend. Its methods do nothing on a
+// nil cbreakMode.
+type cbreakMode struct {
+	f       *os.File
+	mu      sync.Mutex
+	restore func() // nil when not in cbreak mode
+}
+
+func (m *cbreakMode) enable() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.restore != nil {
+		return nil
+	}
+	restore, err := cbreak(m.f)
+	if err != nil {
+		return err
+	}
+	m.restore = restore
+	return nil
+}
+
+func (m *cbreakMode) disable() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.restore != nil {
+		m.restore()
+		m.restore = nil
+	}
+}
+
+// readEdited is readLines for the lines typed into e
+func readEdited(ctx context.Context, e *lineEditor, lines chan<- inputLine) bool {
+	for {
+		text, err := e.ReadLine()
+		if err != nil {
+			return true
+		}
+		select {
+		case lines <- inputLine{text: strings.TrimSpace(text), at: time.Now()}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	const (
+		up, down, left, right = "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C"
+		home, end, del        = "\x1b[1~", "\x1bOF", "\x1b[3~"
+	)
+	var out bytes.Buffer
+	keys := "tock\x7f\x7f\x7fick\r" + // backspace
+		"lap" + left + left + "x" + end + "s\n" + // lxaps
+		up + up + up + down + "!\n" + // lxaps!
+		"ux" + home + "b\x06" + del + "\t\n" + // completed from the words
+		"b\t" + "\n" + // build and break: only "b" is shared
+		"lx\t\n" +
+		"tick\x01\x0b" + "done\n" + // ctrl-a, ctrl-k
+		"\n" + "half"
+	e := newLineEditor(strings.NewReader(keys), &out, []string{"build", "break"})
+	for _, want := range []string{"tick", "lxaps", "lxaps!", "build", "b", "lxaps", "done", "", "half"} {
+		got, err := e.ReadLine()
+		if err != nil || got != want {
+			t.Fatalf("Expected %q, got %q, %v", want, got, err)
+		}
+	}
+	if _, err := e.ReadLine(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+	if want := []string{"tick", "lxaps", "lxaps!", "build", "b", "lxaps", "done", "half"}; strings.Join(e.history, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected the history %q, got %q", want, e.history)
+	}
+	// what was echoed, the cursor kept in place by the redraws
+	if got := out.String(); !strings.HasPrefix(got, "tock\x1b[4D\x1b[Ktoc\x1b[3D\x1b[Kto\x1b[2D\x1b[Ktick\n") {
+		t.Errorf("Unexpected echo %q", got)
+	}
+
+	// ctrl-d ends the input only on an empty line
+	e = newLineEditor(strings.NewReader("ab"+left+"\x04\x04\x04\r\x04"), io.Discard, nil)
+	if got, err := e.ReadLine(); got != "a" || err != nil {
+		t.Errorf("Expected ctrl-d to delete, got %q, %v", got, err)
+	}
+	if _, err := e.ReadLine(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestLineEditorPending(t *testing.T) {
+	e := newLineEditor(strings.NewReader(""), io.Discard, nil)
+	e.line, e.pos = []rune("läp"), 1
+	if got := e.Pending(); got != "läp\x1b[2D" {
+		t.Errorf("Expected the line with the cursor moved back, got %q", got)
+	}
+	e.pos = 3
+	if got := e.Pending(); got != "läp" {
+		t.Errorf("Expected the line only, got %q", got)
+	}
+}
+
+func TestReadEdited(t *testing.T) {
+	e := newLineEditor(strings.NewReader("  one \ntwo\n"), io.Discard, nil)
+	lines := make(chan inputLine, 2)
+	if !readEdited(context.Background(), e, lines) {
+		t.Error("Expected the end of the input")
+	}
+	if got := (<-lines).text + "," + (<-lines).text; got != "one,two" {
+		t.Errorf("Expected trimmed lines, got %q", got)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// listLabelWidth is the widest label column of writeEventList; longer
+// labels push the lap out of line
+const listLabelWidth = 30
+
+// writeEventList lists events[first:] as a table of the sequence number,
+// the clock time, the offset from the first event and the label, followed
+// by the lap the event closes, if any. The laps are those of all events.
+func writeEventList(out io.Writer, events []Event, first int) {
+	if len(events) == 0 {
+		return
+	}
+	laps := make(map[int]time.Duration)
+	forEachLapIndex(events, func(i int, lap time.Duration) { laps[i] = lap })
+	width := 0
+	for _, evt := range events[first:] {
+		if n := utf8.RuneCountInString(evt.What); n > width && n <= listLabelWidth {
+			width = n
+		}
+	}
+	for i := first; i < len(events); i++ {
+		evt := events[i]
+		offset := evt.Timestamp.Sub(events[0].Timestamp)
+		line := fmt.Sprintf("# %4d %s +%-10s %s", evt.Seq, evt.Timestamp.Local().Format("15:04:05"),
+			formatDuration(offset), evt.What)
+		if lap, ok := laps[i]; ok {
+			pad := width - utf8.RuneCountInString(evt.What)
+			if pad < 0 {
+				pad = 0
+			}
+			line += strings.Repeat(" ", pad) + "  " + formatDuration(lap)
+		}
+		fmt.Fprintln(out, line)
+	}
+}
+
+// cmdList lists the events recorded so far, or with a number, the last ones
+func (s *Session) cmdList(arg string, out io.Writer) {
+	first := 0
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			fmt.Fprintln(out, "# Usage: list [<count>]")
+			return
+		}
+		if n < len(s.Events) {
+			first = len(s.Events) - n
+		}
+	}
+	if len(s.Events) == 0 {
+		fmt.Fprintln(out, "# No events yet")
+		return
+	}
+	writeEventList(out, s.Events, first)
+}
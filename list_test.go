@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSessionList(t *testing.T) {
+	at := 0
+	sess := newSession("", collectOptions{})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.handleLine("ls", &out)
+	sess.start()
+	for _, line := range []string{"warmup", "mark m", "pause", "resume", "a very long label that does not fit", "b"} {
+		at += 10
+		sess.handleLine(line, &out)
+	}
+	out.Reset()
+	sess.handleLine("list", &out)
+	clock := func(i int) string { return sess.Events[i].Timestamp.Local().Format("15:04:05") }
+	want := "#    0 " + clock(0) + " +0s         enter\n" +
+		"#    1 " + clock(1) + " +10s        warmup  10s\n" +
+		"#    2 " + clock(2) + " +20s        mark:m\n" +
+		"#    3 " + clock(3) + " +30s        pause\n" +
+		"#    4 " + clock(4) + " +40s        resume\n" +
+		"#    5 " + clock(5) + " +50s        a very long label that does not fit  30s\n" +
+		"#    6 " + clock(6) + " +1m0s       b       10s\n"
+	if out.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	// the laps are of the whole session
+	out.Reset()
+	sess.handleLine("list 2", &out)
+	if lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); len(lines) != 2 ||
+		!strings.HasSuffix(lines[0], "does not fit  30s") || !strings.HasSuffix(lines[1], " b  10s") {
+		t.Errorf("Expected the last two events, got:\n%s", out.String())
+	}
+	out.Reset()
+	for _, line := range []string{"list 0", "ls x", "list 99"} {
+		sess.handleLine(line, &out)
+	}
+	if !strings.HasPrefix(out.String(), "# Usage: list [<count>]\n# Usage: list [<count>]\n#    0 ") || len(sess.Events) != 7 {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	liveBuffer       = 256              // events buffered per client before it is dropped
+	liveWriteTimeout = 10 * time.Second // for writing a single message to a client
+	liveCloseTimeout = 2 * time.Second  // for the clients to receive the end of the session
+	sseHeartbeat     = 15 * time.Second // between comments keeping idle connections open
+)
+
+// eventHub keeps a copy of the recorded events and passes new ones on to
+// subscribers. A subscriber that does not keep up is dropped instead of
+// blocking the recording.
+type eventHub struct {
+	mu       sync.Mutex
+	events   []Event // replayed to new subscribers, as the session has them
+	keepLast int     // keep only this many of the last events, as -keep-last
+	subs     map[*liveSub]bool
+	ended    bool
+}
+
+// liveSub is a subscription to an eventHub. Its channel is closed when the
+// session ends, or when the subscriber has fallen behind (lagged).
+type liveSub struct {
+	ch     chan Event
+	lagged bool
+}
+
+// newEventHub returns a hub keeping the last keepLast events, or all of
+// them if keepLast is 0
+func newEventHub(keepLast int) *eventHub {
+	return &eventHub{keepLast: keepLast, subs: make(map[*liveSub]bool)}
+}
+
+// subscribe returns the events recorded so far, and a subscription to the
+// ones recorded after them
+func (h *eventHub) subscribe() ([]Event, *liveSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := &liveSub{ch: make(chan Event, liveBuffer)}
+	if h.ended {
+		close(sub.ch)
+	} else {
+		h.subs[sub] = true
+	}
+	return append([]Event(nil), h.events...), sub
+}
+
+// unsubscribe stops sending events to sub
+func (h *eventHub) unsubscribe(sub *liveSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// isLagged reports whether sub was dropped for falling behind
+func (h *eventHub) isLagged(sub *liveSub) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return sub.lagged
+}
+
+// snapshot returns the events recorded so far, and whether the session
+// has ended
+func (h *eventHub) snapshot() ([]Event, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Event(nil), h.events...), h.ended
+}
+
+// event returns the event numbered seq, if it was recorded
+func (h *eventHub) event(seq int64) (Event, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.events) - 1; i >= 0; i-- {
+		if h.events[i].Seq == seq {
+			return h.events[i], true
+		}
+	}
+	return Event{}, false
+}
+
+// Send stores evt and passes it on to the subscribers, never blocking
+func (h *eventHub) Send(evt Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, evt)
+	if h.keepLast > 0 && len(h.events) > h.keepLast {
+		h.events = h.events[1:]
+	}
+	for sub := range h.subs {
+		select {
+		case sub.ch <- evt:
+		default:
+			sub.lagged = true
+			close(sub.ch)
+			delete(h.subs, sub)
+		}
+	}
+}
+
+// Edited replaces the events replayed to new subscribers with events, the
+// ones of the session after some were deleted, moved or relabeled. The
+// subscribers already streaming are not told.
+func (h *eventHub) Edited(events []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.keepLast > 0 && len(events) > h.keepLast {
+		events = events[len(events)-h.keepLast:]
+	}
+	h.events = append([]Event(nil), events...)
+}
+
+// Close ends all subscriptions
+func (h *eventHub) Close(Stats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ended = true
+	for sub := range h.subs {
+		close(sub.ch)
+		delete(h.subs, sub)
+	}
+}
+
+// liveServer serves the events of the session over HTTP while it is being
+// recorded
+type liveServer struct {
+	hub     *eventHub
+	srv     *http.Server
+	columns []string       // optional columns included in the event messages
+	clients sync.WaitGroup // connections taken over from srv, which does not track them
+
+	remote *remoteInput // records the ticks of /tick; nil unless enabled, see enableControl
+	stop   func()       // ends the session for /stop
+}
+
+func init() {
+	sinkKinds["http"] = "live events over WebSocket and server-sent events"
+}
+
+// newLiveServer returns a server of live events, see handler, replaying
+// the last keepLast events to new clients, or all of them if keepLast is 0
+func newLiveServer(columns []string, keepLast int) *liveServer {
+	l := &liveServer{hub: newEventHub(keepLast), columns: columns}
+	l.srv = &http.Server{Handler: l.handler(), ReadHeaderTimeout: liveWriteTimeout}
+	return l
+}
+
+// handler returns the HTTP handler serving the endpoints:
+//
+//	/ws      WebSocket pushing the events, see serveWebSocket
+//	/sse     Server-Sent Events stream of the events, see serveSSE
+//	/status  summary of the session so far, see serveStatus
+//	/tick    records a tick, see serveTick
+//	/stop    ends the session, see serveStop
+func (l *liveServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", l.serveWebSocket)
+	mux.HandleFunc("/sse", l.serveSSE)
+	mux.HandleFunc("/status", l.serveStatus)
+	mux.HandleFunc("/tick", l.serveTick)
+	mux.HandleFunc("/stop", l.serveStop)
+	return mux
+}
+
+// serve accepts connections on ln until the server is closed
+func (l *liveServer) serve(ln net.Listener) {
+	l.srv.Serve(ln)
+}
+
+func (l *liveServer) Send(evt Event) {
+	l.hub.Send(evt)
+}
+
+func (l *liveServer) Edited(events []Event) {
+	l.hub.Edited(events)
+}
+
+// Close ends the streams of the clients, waits a moment for them to get
+// the end of the session, and stops the server
+func (l *liveServer) Close(s Stats) {
+	l.hub.Close(s)
+	ctx, cancel := context.WithTimeout(context.Background(), liveCloseTimeout)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		l.clients.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	l.srv.Shutdown(ctx)
+}
+
+// eventJSON encodes evt with the columns it has data for
+func (l *liveServer) eventJSON(evt Event) []byte {
+	return marshalEventJSON(evt, EventColumnNames(dataColumns([]Event{evt}, l.columns)), OutputOptions{})
+}
+
+// liveMessage encodes a message to the clients: an event, or the end of the
+// session if evt is nil
+func (l *liveServer) liveMessage(evt *Event) []byte {
+	if evt == nil {
+		return []byte(`{"type":"end"}`)
+	}
+	return append(append([]byte(`{"type":"event","event":`), l.eventJSON(*evt)...), '}')
+}
+
+// serveWebSocket pushes the events recorded so far, and then every new one,
+// as JSON text messages: {"type":"event","event":{...}}. At the end of the
+// session, {"type":"end"} is sent and the connection closed. A client
+// falling behind is disconnected.
+func (l *liveServer) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	l.clients.Add(1)
+	defer l.clients.Done()
+	defer conn.Close()
+
+	var mu sync.Mutex // the reader answers pings
+	write := func(opcode byte, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+		return writeWSFrame(rw, opcode, payload)
+	}
+	gone := make(chan struct{})
+	go wsReadLoop(rw.Reader, write, gone)
+
+	backlog, sub := l.hub.subscribe()
+	defer l.hub.unsubscribe(sub)
+	for i := range backlog {
+		if write(wsText, l.liveMessage(&backlog[i])) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case evt, ok := <-sub.ch:
+			if !ok {
+				if l.hub.isLagged(sub) {
+					write(wsClose, wsClosePayload(wsClosePolicy, "too slow"))
+					return
+				}
+				write(wsText, l.liveMessage(nil))
+				write(wsClose, wsClosePayload(wsCloseNormal, ""))
+				return
+			}
+			if write(wsText, l.liveMessage(&evt)) != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// wsReadLoop reads the frames sent by a client, answering pings, until the
+// client closes the connection or fails; then closes gone
+func wsReadLoop(r *bufio.Reader, write func(byte, []byte) error, gone chan<- struct{}) {
+	defer close(gone)
+	for {
+		opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsClose:
+			write(wsClose, payload)
+			return
+		case wsPing:
+			write(wsPong, payload)
+		}
+	}
+}
+
+// serveSSE streams the events as Server-Sent Events: "tick" events with the
+// event as JSON data and the sequence number as the ID, and an "end" event
+// at the end of the session. A client reconnecting with Last-Event-ID gets
+// the events it missed. A client falling behind is disconnected, and may
+// then reconnect the same way.
+func (l *liveServer) serveSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	last := int64(-1)
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		last = n
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	backlog, sub := l.hub.subscribe()
+	defer l.hub.unsubscribe(sub)
+	send := func(evt Event) {
+		fmt.Fprintf(w, "id: %d\nevent: tick\ndata: %s\n\n", evt.Seq, l.eventJSON(evt))
+		flusher.Flush()
+	}
+	for _, evt := range backlog {
+		if evt.Seq > last {
+			send(evt)
+		}
+	}
+	flusher.Flush() // send the headers even without a backlog
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case evt, ok := <-sub.ch:
+			if !ok {
+				if !l.hub.isLagged(sub) {
+					fmt.Fprint(w, "event: end\ndata: {}\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			send(evt)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
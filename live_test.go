@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := newEventHub(0)
+	events := testEvents(seconds(1, 1, 1)...)
+	hub.Send(events[0])
+	backlog, sub := hub.subscribe()
+	if len(backlog) != 1 || backlog[0].What != labelEnter {
+		t.Errorf("Expected the backlog to hold enter, got %v", backlog)
+	}
+	hub.Send(events[1])
+	if evt := <-sub.ch; evt.Seq != 1 {
+		t.Errorf("Expected event 1, got %v", evt)
+	}
+
+	// a subscriber not reading is dropped, without blocking Send
+	hub.unsubscribe(sub)
+	_, lagging := hub.subscribe()
+	for i := 0; i <= liveBuffer; i++ {
+		hub.Send(events[2])
+	}
+	if backlog, sub = hub.subscribe(); len(backlog) != liveBuffer+3 {
+		t.Errorf("Expected all events in the backlog, got %d", len(backlog))
+	}
+	if !hub.isLagged(lagging) {
+		t.Error("Expected the subscriber to lag")
+	}
+	n := 0
+	for range lagging.ch {
+		n++
+	}
+	if n != liveBuffer {
+		t.Errorf("Expected %d buffered events, got %d", liveBuffer, n)
+	}
+
+	hub.Close(Stats{})
+	for range sub.ch {
+	}
+	if hub.isLagged(sub) {
+		t.Error("Expected the end of the session, not lagging")
+	}
+	if _, late := hub.subscribe(); !isClosed(late.ch) {
+		t.Error("Expected a subscription after the end to be closed")
+	}
+}
+
+func TestEventHubReplay(t *testing.T) {
+	// -keep-last caps the replay, and the edits of the session replace it
+	hub := newEventHub(2)
+	live := &liveServer{hub: hub}
+	sess := newSession("", collectOptions{KeepLast: 2, Sinks: []eventSink{live}})
+	at := 0
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	for _, line := range []string{"a", "b", "c"} {
+		at += 10
+		sess.handleLine(line, &out)
+	}
+	if backlog, _ := hub.subscribe(); len(backlog) != 2 || backlog[0].What != "b" {
+		t.Fatalf("Expected the last 2 events, got %v", backlog)
+	}
+	for _, line := range []string{"relabel c d", "del! 2"} {
+		sess.handleLine(line, &out)
+	}
+	if backlog, _ := hub.subscribe(); len(backlog) != 1 || backlog[0].What != "d" {
+		t.Errorf("Expected the edited events, got %v", backlog)
+	}
+}
+
+// isClosed reports whether ch is closed and empty
+func isClosed(ch chan Event) bool {
+	select {
+	case _, ok := <-ch:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func TestWSAcceptKey(t *testing.T) {
+	// the example of RFC 6455
+	if got := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept key %q", got)
+	}
+}
+
+// wsClient is the client end of a test WebSocket connection
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWS(t *testing.T, srv *httptest.Server) *wsClient {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected handshake response %v", resp)
+	}
+	return &wsClient{conn, r}
+}
+
+// read reads a frame sent by the server
+func (c *wsClient) read(t *testing.T) (byte, string) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	size := int(header[1] & 0x7f)
+	if size == 126 {
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, string(payload)
+}
+
+// write writes a masked frame, like clients must
+func (c *wsClient) write(opcode byte, payload string) {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask...)
+	for i := range payload {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func TestLiveServerWebSocket(t *testing.T) {
+	live := newLiveServer(nil, 0)
+	srv := httptest.NewServer(live.handler())
+	defer srv.Close()
+	events := testEvents(seconds(1, 1)...)
+	v := 2.5
+	events[1].Value = &v
+	live.Send(events[0])
+
+	client := dialWS(t, srv)
+	if op, msg := client.read(t); op != wsText || msg != `{"type":"event","event":{"seq":0,"ts":"2022-04-08T20:00:00Z","what":"enter"}}` {
+		t.Errorf("Unexpected backlog message %x %s", op, msg)
+	}
+	client.write(wsPing, "hello")
+	if op, msg := client.read(t); op != wsPong || msg != "hello" {
+		t.Errorf("Expected pong, got %x %q", op, msg)
+	}
+	live.Send(events[1])
+	if _, msg := client.read(t); msg != `{"type":"event","event":{"seq":1,"ts":"2022-04-08T20:00:01Z","what":"tick","value":2.5}}` {
+		t.Errorf("Unexpected event message %s", msg)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		live.Close(Stats{})
+		close(done)
+	}()
+	if _, msg := client.read(t); msg != `{"type":"end"}` {
+		t.Errorf("Expected the end message, got %s", msg)
+	}
+	if op, msg := client.read(t); op != wsClose || msg != "\x03\xe8" {
+		t.Errorf("Expected a normal close, got %x %q", op, msg)
+	}
+	select {
+	case <-done:
+	case <-time.After(liveCloseTimeout + time.Second):
+		t.Error("Expected Close to return")
+	}
+}
+
+func TestLiveServerNotWebSocket(t *testing.T) {
+	srv := httptest.NewServer(newLiveServer(nil, 0).handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %s", resp.Status)
+	}
+}
+
+// readSSE reads one Server-Sent Event, skipping comments
+func readSSE(t *testing.T, r *bufio.Reader) string {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.HasPrefix(line, ":"):
+		case line == "\n" && len(lines) > 0:
+			return strings.Join(lines, "")
+		case line != "\n":
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestLiveServerSSE(t *testing.T) {
+	live := newLiveServer(nil, 0)
+	srv := httptest.NewServer(live.handler())
+	defer srv.Close()
+	events := testEvents(seconds(1, 1, 1)...)
+	live.Send(events[0])
+	live.Send(events[1])
+
+	get := func(lastID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/sse", nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+			t.Fatalf("Unexpected response %s, %s", resp.Status, ct)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+	resp, r := get("")
+	for i, want := range []string{
+		"id: 0\nevent: tick\ndata: {\"seq\":0,\"ts\":\"2022-04-08T20:00:00Z\",\"what\":\"enter\"}\n",
+		"id: 1\nevent: tick\ndata: {\"seq\":1,\"ts\":\"2022-04-08T20:00:01Z\",\"what\":\"tick\"}\n",
+	} {
+		if got := readSSE(t, r); got != want {
+			t.Errorf("Event %d: expected %q, got %q", i, want, got)
+		}
+	}
+	resp.Body.Close()
+
+	// reconnecting replays only the missed events
+	live.Send(events[2])
+	resp, r = get("1")
+	defer resp.Body.Close()
+	if got := readSSE(t, r); !strings.HasPrefix(got, "id: 2\n") {
+		t.Errorf("Expected event 2 after reconnecting, got %q", got)
+	}
+	live.Send(events[3])
+	if got := readSSE(t, r); !strings.HasPrefix(got, "id: 3\n") {
+		t.Errorf("Expected event 3, got %q", got)
+	}
+	go live.Close(Stats{})
+	if got := readSSE(t, r); got != "event: end\ndata: {}\n" {
+		t.Errorf("Expected the end event, got %q", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/sse", nil)
+	req.Header.Set("Last-Event-ID", "x")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid Last-Event-ID, got %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// enableControl lets the clients of the server record ticks into in, and
+// end the session by calling stop, see -http-control. Must be called
+// before serve.
+func (l *liveServer) enableControl(in *remoteInput, stop func()) {
+	l.remote, l.stop = in, stop
+}
+
+// liveStatus is the reply of /status
+type liveStatus struct {
+	Events  int             `json:"events"`
+	Laps    int             `json:"laps"`
+	Elapsed float64         `json:"elapsed"` // seconds since "enter", until now or until "exit"
+	LastLap *float64        `json:"last_lap,omitempty"`
+	Mean    *float64        `json:"mean,omitempty"`
+	Ended   bool            `json:"ended"`
+	Last    json.RawMessage `json:"last,omitempty"` // the last event
+}
+
+// serveStatus replies to GET /status with the summary of the events
+// recorded so far, as a liveStatus
+func (l *liveServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	events, ended := l.hub.snapshot()
+	status := liveStatus{Events: len(events), Ended: ended}
+	if len(events) > 0 {
+		stats := ComputeStats(events)
+		status.Elapsed = stats.Total.Seconds()
+		if !ended {
+			status.Elapsed = time.Since(events[0].Timestamp).Seconds()
+		}
+		status.Laps = len(stats.Laps)
+		if len(stats.Laps) > 0 {
+			last, mean := stats.Laps[len(stats.Laps)-1].Seconds(), stats.Mean.Seconds()
+			status.LastLap, status.Mean = &last, &mean
+		}
+		status.Last = l.eventJSON(events[len(events)-1])
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// serveTick records a tick for POST /tick, with the form value "what"
+// parsed like a line typed at the prompt: a label, with an optional value
+// and attributes. The reply is the event recorded, as in /ws; or if it was
+// not recorded, 422 Unprocessable Entity with the reason.
+func (l *liveServer) serveTick(w http.ResponseWriter, r *http.Request) {
+	if !l.controlled(w, r) {
+		return
+	}
+	reply := l.remote.send(remoteLine{text: strings.TrimSpace(r.FormValue("what")), source: sourceHTTP, at: time.Now()})
+	if !strings.HasPrefix(reply, "ok ") {
+		http.Error(w, strings.TrimPrefix(reply, "error "), http.StatusUnprocessableEntity)
+		return
+	}
+	seq, _ := strconv.ParseInt(strings.TrimPrefix(reply, "ok "), 10, 64)
+	evt, ok := l.hub.event(seq)
+	if !ok {
+		// dropped by -keep-last, or not passed to the sinks
+		evt = Event{Seq: seq}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(l.eventJSON(evt), '\n'))
+}
+
+// serveStop ends the session for POST /stop, replying 202 Accepted: the
+// session stops, and the output is written, once the request is done
+func (l *liveServer) serveStop(w http.ResponseWriter, r *http.Request) {
+	if !l.controlled(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]bool{"stopping": true})
+	go l.stop()
+}
+
+// controlled reports whether r may control the session, replying with the
+// error if not: the method must be POST, control must have been enabled,
+// and the session must still go on
+func (l *liveServer) controlled(w http.ResponseWriter, r *http.Request) bool {
+	if !allowMethod(w, r, http.MethodPost) {
+		return false
+	}
+	if l.remote == nil {
+		http.Error(w, "the session is not controlled over HTTP, see -http-control", http.StatusForbidden)
+		return false
+	}
+	if _, ended := l.hub.snapshot(); ended {
+		http.Error(w, "the session has ended", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// allowMethod reports whether r uses method, replying 405 Method Not
+// Allowed if not
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "use "+method, http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestSuffix is appended to the name of an output file for that of its
+// manifest, see -manifest
+const manifestSuffix = ".manifest.json"
+
+// Manifest describes an output file written with -manifest. It is written
+// next to the file, into <file>.manifest.json, once the file is complete
+// under its name, so that automation picking up the recordings can take the
+// manifest appearing as the sign that the file is done.
+type Manifest struct {
+	File      string      `json:"file"`                // base name of the output file, in the directory of the manifest
+	Format    string      `json:"format"`              // output format, see formats
+	Compress  string      `json:"compress,omitempty"`  // compression of the file, see compressions
+	Encrypted bool        `json:"encrypted,omitempty"` // the file was written with -encrypt
+	Schema    int         `json:"schema"`              // version of the columns, see schemaVersion
+	Events    int         `json:"events"`              // number of events in the file
+	First     *time.Time  `json:"first,omitempty"`     // timestamp of the earliest event
+	Last      *time.Time  `json:"last,omitempty"`      // timestamp of the latest event
+	Seconds   float64     `json:"seconds"`             // time from First to Last
+	Session   string      `json:"session,omitempty"`   // ID of the recording session, as in its checkpoint
+	Name      string      `json:"name,omitempty"`      // of the session, see -name
+	Comment   string      `json:"comment,omitempty"`   // comment of the file
+	Meta      []metaField `json:"meta,omitempty"`      // the metadata lines of the file, e.g. of -with-env
+	Size      int64       `json:"size"`                // size of the file in bytes
+	SHA256    string      `json:"sha256"`              // hex SHA-256 of the file as written
+}
+
+// manifestPath returns the name of the manifest of the output file path
+func manifestPath(path string) string {
+	return path + manifestSuffix
+}
+
+// newManifest describes the file path written with events, whose content
+// hashed into sum
+func newManifest(path string, events []Event, opts OutputOptions, sum hash.Hash) (Manifest, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := Manifest{
+		File:      filepath.Base(path),
+		Format:    opts.Format,
+		Compress:  opts.Compress,
+		Encrypted: opts.Passphrase != nil,
+		Schema:    schemaVersion,
+		Events:    len(events),
+		Session:   opts.Session,
+		Name:      opts.Name,
+		Comment:   opts.Comment,
+		Meta:      opts.Meta,
+		Size:      fi.Size(),
+		SHA256:    hex.EncodeToString(sum.Sum(nil)),
+	}
+	if m.Format == "" {
+		m.Format = "csv"
+	}
+	m.First, m.Last = timeSpan(events)
+	if m.First != nil {
+		m.Seconds = m.Last.Sub(*m.First).Seconds()
+	}
+	return m, nil
+}
+
+// timeSpan returns the earliest and the latest timestamps of events, in
+// whichever order they are; nil without events
+func timeSpan(events []Event) (first, last *time.Time) {
+	for i := range events {
+		ts := events[i].Timestamp
+		if first == nil || ts.Before(*first) {
+			first = &ts
+		}
+		if last == nil || ts.After(*last) {
+			last = &ts
+		}
+	}
+	return first, last
+}
+
+// writeManifest writes m as the manifest of the output file path, through a
+// temporary file renamed into place
+func writeManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeAtomic(manifestPath(path), 0o644, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not write the manifest: %w", err)
+	}
+	return nil
+}
+
+// loadManifest reads the manifest of the output file path. It returns
+// false, and no error, if the file has none.
+func loadManifest(path string) (Manifest, bool, error) {
+	var m Manifest
+	data, err := os.ReadFile(manifestPath(path))
+	if os.IsNotExist(err) {
+		return m, false, nil
+	} else if err != nil {
+		return m, false, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, true, fmt.Errorf("%s: %w", manifestPath(path), err)
+	}
+	return m, true, nil
+}
+
+// checkManifest compares the manifest m with the output file path, whose
+// events were read as events, and returns the differences
+func checkManifest(path string, m Manifest, events []Event) []finding {
+	var findings []finding
+	add := func(format string, a ...interface{}) {
+		findings = append(findings, finding{Message: "manifest: " + fmt.Sprintf(format, a...)})
+	}
+	if m.File != filepath.Base(path) {
+		add("describes the file %q", m.File)
+	}
+	if m.Events != len(events) {
+		add("%d events, the file has %d", m.Events, len(events))
+	}
+	first, last := timeSpan(events)
+	if !sameTime(m.First, first) || !sameTime(m.Last, last) {
+		add("the events span %s to %s, the file %s to %s", formatTimePtr(m.First), formatTimePtr(m.Last),
+			formatTimePtr(first), formatTimePtr(last))
+	}
+	if data, err := os.ReadFile(path); err != nil {
+		add("%v", err)
+	} else if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != m.SHA256 || int64(len(data)) != m.Size {
+		add("the file has changed: sha256 %x, size %d; expected %s, size %d", sum, len(data), m.SHA256, m.Size)
+	}
+	return findings
+}
+
+// sameTime reports whether a and b are the same instant, or both nil
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// formatTimePtr formats t like the "ts" column, or "-" if nil
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpEventsManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv.gz")
+	events := testEvents(time.Second, 2*time.Second)
+	opts := OutputOptions{Comment: "run 1", Meta: []metaField{{Key: "host", Value: "box"}}, Session: "01ABC", Manifest: true}
+	opts, _ = withCompression(opts, path)
+	if err := os.WriteFile(path, []byte("old"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := DumpEvents(path, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	m, ok, err := loadManifest(path)
+	if err != nil || !ok {
+		t.Fatalf("Expected a manifest, got %v, %v", ok, err)
+	}
+	data, _ := os.ReadFile(path)
+	sum := sha256.Sum256(data)
+	if m.File != "out.csv.gz" || m.Format != "csv" || m.Compress != "gzip" || m.Schema != schemaVersion || m.Events != 3 ||
+		m.Seconds != 3 || m.Session != "01ABC" || m.Comment != "run 1" || len(m.Meta) != 1 ||
+		m.Size != int64(len(data)) || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+	if !m.First.Equal(events[0].Timestamp) || !m.Last.Equal(events[2].Timestamp) {
+		t.Errorf("Expected the first and last timestamps, got %v, %v", m.First, m.Last)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o640 {
+		t.Errorf("Expected the permissions of the file replaced, got %v", fi.Mode())
+	}
+	if v := validateFile(path); !v.Pass {
+		t.Errorf("Expected the file to match its manifest, got %v", v)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, ".*")); len(tmp) != 0 {
+		t.Errorf("Expected no temporary files left, got %v", tmp)
+	}
+
+	// each file of a rotated output has its own, newest first
+	path = filepath.Join(dir, "rotated.csv")
+	if err := DumpEvents(path, events, OutputOptions{Manifest: true, Order: orderDesc, RotateEvents: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for name, n := range map[string]int{"rotated.csv": 2, "rotated.1.csv": 1} {
+		m, ok, err := loadManifest(filepath.Join(dir, name))
+		if err != nil || !ok || m.File != name || m.Events != n || m.First.After(*m.Last) {
+			t.Errorf("%s: unexpected manifest %+v, %v, %v", name, m, ok, err)
+		}
+	}
+
+	if err := DumpEvents("-", events, OutputOptions{Manifest: true}); err == nil {
+		t.Error("Expected an error for a manifest of stdout")
+	}
+}
+
+func TestValidateFileManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv")
+	events := testEvents(time.Second, time.Second)
+	if err := DumpEvents(path, events, OutputOptions{Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	m, _, _ := loadManifest(path)
+	m.Events = 4
+	m.First = nil
+	if err := writeManifest(path, m); err != nil {
+		t.Fatal(err)
+	}
+	v := validateFile(path)
+	var got []string
+	for _, f := range v.Findings {
+		got = append(got, f.Message)
+	}
+	want := "manifest: 4 events, the file has 3|" +
+		"manifest: the events span - to 2022-04-08T20:00:02Z, the file 2022-04-08T20:00:00Z to 2022-04-08T20:00:02Z"
+	if v.Pass || strings.Join(got, "|") != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, strings.Join(got, "|"))
+	}
+
+	os.WriteFile(manifestPath(path), []byte("{"), 0o644)
+	if v := validateFile(path); v.Pass || !strings.Contains(v.String(), "out.csv.manifest.json") {
+		t.Errorf("Expected an unreadable manifest to fail, got %v", v)
+	}
+	os.Remove(manifestPath(path))
+	if v := validateFile(path); !v.Pass {
+		t.Errorf("Expected a file without a manifest to pass, got %v", v)
+	}
+}
@@ -0,0 +1,435 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func runNormalize(args []string) int {
+	fs := newFlagSet("normalize", "<file.csv>")
+	outFile := fs.String("o", "", "Output file path, stderr or fd:N (default: stdout). May be the input file")
+	check := fs.Bool("check", false, "Only report what would be fixed, without writing anything;\n"+
+		"exit with status 1 if the file is not canonical")
+	completeFiles(fs, "o")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	inFile := fs.Arg(0)
+	opts, err := withCompression(OutputOptions{}, *outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	data, err := readInput(inFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem reading CSV:", err)
+		return 1
+	}
+	l, err := parseLenient(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: FAILED: %v\n", inFile, err)
+		return 1
+	}
+	l.canonicalize()
+	if canonical, err := l.encode(); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	} else if len(l.problems) == 0 && !bytes.Equal(canonical, data) {
+		l.formatProblem("rewritten", "rows not in the canonical format")
+	}
+	for _, p := range l.problems {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", inFile, p, p.fix)
+	}
+	if len(l.problems) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: OK\n", inFile)
+	}
+	if *check {
+		if len(l.problems) > 0 {
+			return 1
+		}
+		return 0
+	}
+	l.opts.Compress, l.opts.CompressLevel = opts.Compress, opts.CompressLevel
+	if err := DumpEvents(*outFile, l.events, l.opts); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem writing output:", err)
+		return 1
+	}
+	return 0
+}
+
+// readInput reads all of the named file, "" and "-" meaning stdin,
+// decompressed if need be
+func readInput(inFile string) ([]byte, error) {
+	in := os.Stdin
+	if inFile != "-" && inFile != "" {
+		f, err := os.Open(inFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not open file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := decompressed(in)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// lenientCSV holds the events of a CSV file read by parseLenient, and the
+// problems that were fixed to read them
+type lenientCSV struct {
+	events   []Event
+	lines    []int         // line numbers of the events in the file
+	opts     OutputOptions // the comment and the columns of the file
+	problems []csvProblem
+}
+
+// csvProblem is a problem found in a CSV file, see parseLenient
+type csvProblem struct {
+	line   int    // the line number, 0 if not of a line
+	text   string // what is wrong, e.g. `invalid seq "x"`
+	fix    string // what normalize does about it, e.g. "renumbered"
+	format bool   // not a problem in a valid file, only not canonical
+}
+
+func (p csvProblem) String() string {
+	if p.line > 0 {
+		return fmt.Sprintf("line %d: %s", p.line, p.text)
+	}
+	return p.text
+}
+
+// problem records a problem in line (or 0), fixed by fix
+func (l *lenientCSV) problem(line int, fix, format string, a ...interface{}) {
+	l.problems = append(l.problems, csvProblem{line: line, text: fmt.Sprintf(format, a...), fix: fix})
+}
+
+// formatProblem records a deviation from the canonical format, fixed by fix
+func (l *lenientCSV) formatProblem(fix, format string, a ...interface{}) {
+	l.problems = append(l.problems, csvProblem{text: fmt.Sprintf(format, a...), fix: fix, format: true})
+}
+
+// parseLenient reads data written by DumpCSV, possibly damaged by an
+// interrupted session, manual editing or an older version, fixing what it
+// can instead of failing: a byte order mark, CRLF line endings, another
+// delimiter, a missing header, misspelled, invalid or duplicate header
+// columns, rows with missing or extra fields, and invalid cells, which are
+// cleared. Rows without a valid timestamp are dropped. Returns an error
+// only if the data is not an event CSV at all.
+func parseLenient(data []byte) (*lenientCSV, error) {
+	l := &lenientCSV{}
+	if bytes.HasPrefix(data, []byte("\ufeff")) {
+		data = data[len("\ufeff"):]
+		l.formatProblem("removed", "UTF-8 byte order mark")
+	}
+	if bytes.Contains(data, []byte("\r\n")) {
+		l.formatProblem("converted to LF", "CRLF line endings")
+	}
+	var p csvPreamble
+	for bytes.HasPrefix(data, []byte("#")) {
+		line, rest := data, []byte(nil)
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, rest = data[:i], data[i+1:]
+		}
+		if ok, err := p.add(string(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.lines+1, err)
+		} else if !ok {
+			break
+		}
+		data = rest
+	}
+	lineOffset := p.lines
+	// kept as they are, the comment, the ts-start and the metadata
+	l.opts.Comment, l.opts.Meta = p.comment, p.meta
+	if !p.start.IsZero() {
+		l.opts.TSStyle, l.opts.Start = tsStyleOffsetSeconds, p.start
+	}
+	if p.version == 0 {
+		l.formatProblem("added", "missing schema version line %q", formatSchemaLine())
+	}
+	if n := commentLines(data); n > 0 {
+		l.formatProblem("dropped", "comment lines after the header (%d), e.g. a statistics footer or a checksum", n)
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	if delim := guessDelimiter(data); delim != ',' {
+		r.Comma = delim
+		l.formatProblem("converted to commas", "fields delimited by %q", delim)
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no header nor rows")
+	}
+	header, keep, err := l.header(records[0])
+	if err != nil {
+		return nil, err
+	}
+	width := len(records[0]) // of the records, before dropping columns
+	if keep == nil {
+		// the first record is data
+		keep = make([]int, len(header))
+		for i := range keep {
+			keep[i] = i
+		}
+	} else {
+		records, lineOffset = records[1:], lineOffset+1
+	}
+
+	known := knownColumns()
+	for i, record := range records {
+		line := i + 1 + lineOffset
+		if n := len(record) - width; n > 0 && strings.Join(record[width:], "") != "" {
+			l.problem(line, "dropped", "extra fields (%d)", n)
+		} else if n < 0 {
+			l.problem(line, "left empty", "missing fields (%d)", -n)
+		}
+		row := make([]string, len(header))
+		for j, k := range keep {
+			if k < len(record) {
+				row[j] = record[k]
+			}
+		}
+		// an invalid ts is left as it is, to be dealt with below
+		absoluteTimestamp(header, row, l.opts.Start)
+		evt, err := parseEventRow(header, row)
+		if err != nil {
+			l.clearInvalid(line, header, row, known)
+			if evt, err = parseEventRow(header, row); err != nil {
+				l.problem(line, "row dropped", "unreadable row: %v", err)
+				continue
+			}
+		}
+		l.events, l.lines = append(l.events, evt), append(l.lines, line)
+	}
+	return l, nil
+}
+
+// header canonicalizes the header record of a file: the names of the
+// built-in columns are matched regardless of case and surrounding spaces,
+// and columns that can not be read are dropped. Returns the names of the
+// columns and the indexes of their fields in the records. If record is not
+// a header but data, returns the default columns and nil indexes.
+func (l *lenientCSV) header(record []string) ([]string, []int, error) {
+	known := knownColumns()
+	canonical := func(field string) string {
+		if name := strings.ToLower(strings.TrimSpace(field)); known[name] || name == attrsColumn {
+			return name
+		}
+		return ""
+	}
+	isHeader := false
+	for _, field := range record {
+		isHeader = isHeader || canonical(field) != ""
+	}
+	if !isHeader {
+		if len(record) != len(GetEventColumnNames()) {
+			return nil, nil, fmt.Errorf("missing header, and the rows do not have the default columns %q",
+				GetEventColumnNames())
+		}
+		l.problem(0, "added", "missing header")
+		return GetEventColumnNames(), nil, nil
+	}
+
+	var header []string
+	var keep []int
+	seen := make(map[string]bool)
+	for i, field := range record {
+		name := canonical(field)
+		if name != "" && name != field {
+			l.problem(0, "renamed", "column %q, expected %q", field, name)
+		} else if name == "" {
+			if name = strings.TrimSpace(field); validateAttrKey(name) != nil {
+				l.problem(0, "dropped", "column %q is not a valid attribute name", field)
+				continue
+			}
+		}
+		if seen[name] {
+			l.problem(0, "dropped", "duplicate column %q", name)
+			continue
+		}
+		seen[name] = true
+		header, keep = append(header, name), append(keep, i)
+	}
+	for _, name := range []string{"ts", "what"} {
+		if !seen[name] && !(name == "ts" && seen["ts_ns"]) {
+			return nil, nil, fmt.Errorf("missing column %q in header %q", name, record)
+		}
+	}
+	if !seen["seq"] {
+		l.problem(0, "added", "missing column \"seq\"")
+	}
+	if !seen["ts"] {
+		l.problem(0, "added from ts_ns", "missing column \"ts\"")
+	}
+	for _, name := range header {
+		if known[name] && !isDefaultColumn(name) {
+			l.opts.Columns = append(l.opts.Columns, name)
+		}
+		if name == attrsColumn {
+			l.opts.AttrsStyle = attrsStyleJSON
+		}
+	}
+	return header, keep, nil
+}
+
+// isDefaultColumn reports whether name is one of the columns always written
+func isDefaultColumn(name string) bool {
+	for _, n := range GetEventColumnNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// clearInvalid clears the cells of row that can not be parsed. An invalid
+// seq becomes 0, to be renumbered, and an invalid ts is replaced by a valid
+// ts_ns, if any.
+func (l *lenientCSV) clearInvalid(line int, header, row []string, known map[string]bool) {
+	ts, tsNS := -1, -1
+	for i, name := range header {
+		var scratch Event
+		var err error
+		switch {
+		case name == attrsColumn:
+			_, err = unmarshalAttrs(row[i])
+		case known[name]:
+			err = scratch.SetCell(name, row[i])
+		}
+		switch {
+		case name == "ts":
+			ts = i
+		case name == "ts_ns" && err == nil:
+			tsNS = i
+		case err != nil && name == "seq":
+			l.problem(line, "renumbered", "invalid seq %q", row[i])
+			row[i] = "0"
+		case err != nil:
+			l.problem(line, "cleared", "invalid %s %q", name, row[i])
+			row[i] = ""
+		}
+	}
+	if ts >= 0 && tsNS >= 0 {
+		var scratch Event
+		if scratch.SetCell("ts", row[ts]) != nil {
+			scratch.SetCell("ts_ns", row[tsNS])
+			l.problem(line, "replaced by ts_ns", "invalid ts %q", row[ts])
+			row[ts] = scratch.Timestamp.Format(time.RFC3339Nano)
+		}
+	}
+}
+
+// canonicalize sorts the events by timestamp, keeping the order of events
+// with the same timestamp, and renumbers them from zero, or from the seq of
+// a first "enter" written with -seq-start. The line numbers of the events
+// are forgotten.
+func (l *lenientCSV) canonicalize() {
+	l.lines = nil
+	unordered := 0
+	for i := 1; i < len(l.events); i++ {
+		if l.events[i].Timestamp.Before(l.events[i-1].Timestamp) {
+			unordered++
+		}
+	}
+	if unordered > 0 {
+		sort.SliceStable(l.events, func(i, j int) bool {
+			return l.events[i].Timestamp.Before(l.events[j].Timestamp)
+		})
+		l.problem(0, "sorted", "rows out of timestamp order (%d)", unordered)
+	}
+	var start int64
+	if len(l.events) > 0 && l.events[0].What == labelEnter && l.events[0].Seq > 0 {
+		start = l.events[0].Seq
+	}
+	renumbered, duplicates := 0, 0
+	seen := make(map[int64]bool)
+	for i := range l.events {
+		if seen[l.events[i].Seq] {
+			duplicates++
+		}
+		seen[l.events[i].Seq] = true
+		if want := start + int64(i); l.events[i].Seq != want {
+			l.events[i].Seq = want
+			renumbered++
+		}
+	}
+	if renumbered > 0 {
+		from := "zero"
+		if start > 0 {
+			from = strconv.FormatInt(start, 10)
+		}
+		l.problem(0, "renumbered from "+from, "seq not numbered from %s (%d changed, %d duplicates)", from, renumbered, duplicates)
+	}
+}
+
+// encode returns the canonical CSV of the events
+func (l *lenientCSV) encode() ([]byte, error) {
+	encode, events, opts, err := prepareOutput(l.events, l.opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = encode(&buf, events, opts)
+	return buf.Bytes(), err
+}
+
+// commentLines counts the lines starting with '#' in data
+func commentLines(data []byte) int {
+	n := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("#")) {
+			n++
+		}
+	}
+	return n
+}
+
+// guessDelimiter returns the field delimiter of the first line of data that
+// is not a comment: ',', ';' or tab, whichever occurs the most
+func guessDelimiter(data []byte) rune {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 || line[0] == '#' {
+			continue
+		}
+		delim, most := ',', bytes.Count(line, []byte(","))
+		for _, d := range []rune{';', '\t'} {
+			if n := bytes.Count(line, []byte(string(d))); n > most {
+				delim, most = d, n
+			}
+		}
+		return delim
+	}
+	return ','
+}
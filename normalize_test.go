@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseLenient(t *testing.T) {
+	data := "\ufeff# run 1\r\nSeq;TS;What;value;bad key\r\n" +
+		"2;2022-04-08T20:00:03Z;exit;;x\r\n" +
+		"0;2022-04-08T20:00:00Z;enter;;\r\n" +
+		"x;1649448001.5;tick;3\r\n" +
+		"1;nope;tick;4;;\r\n" +
+		"# sha256: abc\r\n"
+	l, err := parseLenient([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.canonicalize()
+	got, err := l.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# run 1\n# stopwatch-schema: 2\nseq,ts,what,value\n" +
+		"0,2022-04-08T20:00:00Z,enter,\n" +
+		"1,2022-04-08T20:00:01.5Z,tick,3\n" +
+		"2,2022-04-08T20:00:03Z,exit,\n"
+	if string(got) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+	want = "UTF-8 byte order mark: removed\n" +
+		"CRLF line endings: converted to LF\n" +
+		`missing schema version line "# stopwatch-schema: 2": added` + "\n" +
+		"comment lines after the header (1), e.g. a statistics footer or a checksum: dropped\n" +
+		"fields delimited by ';': converted to commas\n" +
+		`column "Seq", expected "seq": renamed` + "\n" +
+		`column "TS", expected "ts": renamed` + "\n" +
+		`column "What", expected "what": renamed` + "\n" +
+		`column "bad key" is not a valid attribute name: dropped` + "\n" +
+		"line 5: missing fields (1): left empty\n" +
+		`line 5: invalid seq "x": renumbered` + "\n" +
+		`line 6: unreadable row: invalid ts: parsing time "nope" as "2006-01-02T15:04:05.999999999Z07:00": ` +
+		`cannot parse "nope" as "2006": row dropped` + "\n" +
+		"rows out of timestamp order (1): sorted\n" +
+		"seq not numbered from zero (1 changed, 1 duplicates): renumbered from zero\n"
+	var fixes strings.Builder
+	for _, p := range l.problems {
+		fmt.Fprintf(&fixes, "%s: %s\n", p, p.fix)
+	}
+	if fixes.String() != want {
+		t.Errorf("Expected the fixes:\n%s\ngot:\n%s", want, fixes.String())
+	}
+
+	// the canonical file needs no fixes
+	l, err = parseLenient(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.canonicalize()
+	if again, _ := l.encode(); len(l.problems) != 0 || string(again) != string(got) {
+		t.Errorf("Expected the canonical file as is, got %v:\n%s", l.problems, again)
+	}
+}
+
+func TestParseLenientColumns(t *testing.T) {
+	// without a header, with the timestamp in ts_ns only, and with attributes
+	for data, want := range map[string]string{
+		"0,2022-04-08T20:00:00Z,enter\n": "# stopwatch-schema: 2\nseq,ts,what\n0,2022-04-08T20:00:00Z,enter\n",
+		"ts_ns,what,tz\n1649448000000000000,enter,UTC\n": "# stopwatch-schema: 2\nseq,ts,ts_ns,what,tz\n" +
+			"0,2022-04-08T20:00:00Z,1649448000000000000,enter,UTC\n",
+		"seq,ts,ts_ns,what,attrs\n0,bad,1649448000000000000,enter,{\"lane\":\"3\"}\n": "# stopwatch-schema: 2\nseq,ts,ts_ns,what,attrs\n" +
+			"0,2022-04-08T20:00:00Z,1649448000000000000,enter,\"{\"\"lane\"\":\"\"3\"\"}\"\n",
+		"# run\n# stopwatch-schema: 2\n# ts-start: 2022-04-08T20:00:00Z\nseq,ts,what\n0,0,enter\n1,1.5,exit\n": "# run\n" +
+			"# stopwatch-schema: 2\n# ts-start: 2022-04-08T20:00:00Z\nseq,ts,what\n0,0,enter\n1,1.5,exit\n",
+		// renumbered from the -seq-start of enter
+		"seq,ts,what\n8589934592,2022-04-08T20:00:00Z,enter\n9,2022-04-08T20:00:01Z,exit\n": "# stopwatch-schema: 2\nseq,ts,what\n" +
+			"8589934592,2022-04-08T20:00:00Z,enter\n8589934593,2022-04-08T20:00:01Z,exit\n",
+	} {
+		l, err := parseLenient([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.canonicalize()
+		if got, err := l.encode(); err != nil || string(got) != want {
+			t.Errorf("%q: expected:\n%s\ngot:\n%s%v", data, want, got, err)
+		}
+	}
+	for _, data := range []string{"", "# only a comment\n", "a,b\n1,2\n", "seq,what\n0,enter\n"} {
+		if _, err := parseLenient([]byte(data)); err == nil {
+			t.Errorf("Expected error for %q", data)
+		}
+	}
+}
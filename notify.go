@@ -0,0 +1,101 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// notifyTimeout limits the time spent showing a desktop notification
+const notifyTimeout = 5 * time.Second
+
+// notifier shows desktop notifications, see -notify
+type notifier interface {
+	Notify(title, body string) error
+}
+
+// commandNotifier shows notifications by running a command, with the
+// arguments given by args
+type commandNotifier struct {
+	name string
+	args func(title, body string) []string
+}
+
+func (n commandNotifier) Notify(title, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, n.name, n.args(title, body)...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %v: %s", n.name, err, msg)
+		}
+		return fmt.Errorf("%s: %v", n.name, err)
+	}
+	return nil
+}
+
+// newNotifier returns the notifier of the operating system goos: notify-send
+// on Linux and the BSDs, osascript on macOS
+func newNotifier(goos string) (notifier, error) {
+	switch goos {
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly":
+		return commandNotifier{name: "notify-send", args: func(title, body string) []string {
+			return []string{"--app-name=stopwatch-go", "--", title, body}
+		}}, nil
+	case "darwin":
+		return commandNotifier{name: "osascript", args: func(title, body string) []string {
+			return []string{"-e", fmt.Sprintf("display notification %s with title %s",
+				appleScriptString(body), appleScriptString(title))}
+		}}, nil
+	}
+	return nil, fmt.Errorf("desktop notifications are not supported on %s", goos)
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// notifyTitle returns the title of the notifications of a session: the
+// session name, or else the comment
+func notifyTitle(name, comment string) string {
+	if name == "" {
+		name = comment
+	}
+	if name == "" {
+		return "stopwatch-go"
+	}
+	return "stopwatch-go: " + name
+}
+
+// notify shows msg as a desktop notification with -notify, together with
+// the time elapsed at the moment at. Only the first failure is reported
+// into out, as with the sinks; a failure never interrupts recording.
+func (s *Session) notify(msg string, at time.Time, out io.Writer) {
+	if s.opts.Notifier == nil {
+		return
+	}
+	body := fmt.Sprintf("%s\nElapsed: %s", msg, formatDuration(at.Sub(s.startAt)))
+	err := s.opts.Notifier.Notify(notifyTitle(s.opts.Name, s.Comment), body)
+	if err != nil && !s.notifyFailed {
+		fmt.Fprintf(out, "\n# WARNING: -notify: %v (further errors are not shown)\n", err)
+		s.notifyFailed = true
+	}
+}
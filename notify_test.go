@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records the notifications, and fails with err
+type fakeNotifier struct {
+	shown []string
+	err   error
+}
+
+func (n *fakeNotifier) Notify(title, body string) error {
+	n.shown = append(n.shown, title+"|"+body)
+	return n.err
+}
+
+func TestSessionNotify(t *testing.T) {
+	steps := []time.Duration{0, 22 * time.Minute, 0, 4 * time.Minute}
+	n := &fakeNotifier{}
+	sess := newSession("run 1", collectOptions{
+		WarnAt:   []time.Duration{20 * time.Minute, 25 * time.Minute},
+		Cycle:    []cyclePhase{{"work", 25 * time.Minute}, {"rest", 5 * time.Minute}},
+		Notifier: n,
+	})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+	sess.start()
+	sess.checkTimers(&out) // 22m
+	sess.checkTimers(&out) // 26m
+	want := []string{
+		"stopwatch-go: run 1|Warning: 20m0s elapsed\nElapsed: 22m0s",
+		"stopwatch-go: run 1|Phase: rest (5m0s)\nElapsed: 25m0s",
+		"stopwatch-go: run 1|Warning: 25m0s elapsed\nElapsed: 26m0s",
+	}
+	if !reflect.DeepEqual(n.shown, want) {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, n.shown)
+	}
+	if strings.Contains(out.String(), "-notify") {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	// failures are reported once, and the events are recorded anyway
+	steps = []time.Duration{0, 22 * time.Minute, 4 * time.Minute}
+	n = &fakeNotifier{err: errors.New("no display")}
+	sess = newSession("", collectOptions{WarnAt: []time.Duration{20 * time.Minute, 25 * time.Minute}, Notifier: n, Name: "bench"})
+	sess.now = fakeClock(&steps)
+	out.Reset()
+	sess.start()
+	sess.checkTimers(&out)
+	sess.checkTimers(&out)
+	if len(n.shown) != 2 || !strings.HasPrefix(n.shown[0], "stopwatch-go: bench|") {
+		t.Errorf("Expected two notifications of the session name, got %q", n.shown)
+	}
+	if got := strings.Count(out.String(), "# WARNING: -notify: no display"); got != 1 {
+		t.Errorf("Expected the failure to be reported once, got %q", out.String())
+	}
+	if len(sess.Events) != 3 {
+		t.Errorf("Expected the warnings to be recorded, got %v", sess.Events)
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	for goos, want := range map[string]string{"linux": "notify-send", "freebsd": "notify-send", "darwin": "osascript"} {
+		n, err := newNotifier(goos)
+		if cmd, ok := n.(commandNotifier); err != nil || !ok || cmd.name != want {
+			t.Errorf("%s: expected %s, got %#v, %v", goos, want, n, err)
+		}
+	}
+	if _, err := newNotifier("windows"); err == nil {
+		t.Error("Expected error for windows")
+	}
+	n, _ := newNotifier("darwin")
+	args := n.(commandNotifier).args(`say "hi"`, `a\b`)
+	if want := []string{"-e", `display notification "a\\b" with title "say \"hi\""`}; !reflect.DeepEqual(args, want) {
+		t.Errorf("Expected %q, got %q", want, args)
+	}
+
+	err := commandNotifier{name: "stopwatch-go-no-such-command", args: func(string, string) []string { return nil }}.Notify("t", "b")
+	if err == nil || !strings.HasPrefix(err.Error(), "stopwatch-go-no-such-command: ") {
+		t.Errorf("Expected error for a missing command, got %v", err)
+	}
+}
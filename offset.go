@@ -0,0 +1,124 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// withOffsets returns events with the "offset" column set to the time of
+// each event since the instant since, see -since. The events are copied,
+// leaving the recorded ones as they were.
+func withOffsets(events []Event, since time.Time) []Event {
+	offset := make([]Event, len(events))
+	for i, evt := range events {
+		offset[i] = withOffset(evt, since)
+	}
+	return offset
+}
+
+// withOffset returns evt with the offset of evt since the instant since
+func withOffset(evt Event, since time.Time) Event {
+	d := evt.Timestamp.Sub(since)
+	evt.Offset = &d
+	return evt
+}
+
+// formatOffset formats d as exact seconds with the shortest fraction, e.g.
+// "90", "-0.25" or "3600.000000001"
+func formatOffset(d time.Duration) string {
+	sign, abs := "", uint64(d)
+	if d < 0 {
+		sign, abs = "-", -abs
+	}
+	s := sign + strconv.FormatUint(abs/uint64(time.Second), 10)
+	if frac := abs % uint64(time.Second); frac != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%09d", frac), "0")
+	}
+	return s
+}
+
+// parseOffset parses an "offset" cell, the inverse of formatOffset
+func parseOffset(value string) (time.Duration, error) {
+	// plain seconds only, not e.g. "1m" that would make "1ms"
+	d, err := time.ParseDuration(value + "s")
+	if err != nil || strings.Trim(value, "+-.0123456789") != "" {
+		return 0, fmt.Errorf("invalid offset %q", value)
+	}
+	return d, nil
+}
+
+// parseSince parses the instant of -since: an RFC 3339 timestamp, or the
+// number of seconds since the Unix epoch, with an optional fraction
+func parseSince(value string) (time.Time, error) {
+	t, err := parseTimestamp(strings.TrimSpace(value))
+	if err != nil {
+		return t, fmt.Errorf("invalid -since %q: expected an RFC 3339 timestamp or Unix seconds", value)
+	}
+	return t, nil
+}
+
+// Styles of the "ts" column, see OutputOptions.TSStyle
+const (
+	tsStyleRFC3339       = "rfc3339"        // the default: e.g. 2022-04-08T20:00:01.5Z
+	tsStyleOffsetSeconds = "offset-seconds" // seconds since OutputOptions.Start: e.g. 1.5
+)
+
+// tsStyles lists the values of -ts-style
+var tsStyles = []string{tsStyleRFC3339, tsStyleOffsetSeconds}
+
+// tsStartPrefix starts the comment line holding the instant the seconds of
+// tsStyleOffsetSeconds count from, written after the comment
+const tsStartPrefix = "# ts-start: "
+
+// formatTSStart formats the line of tsStartPrefix, without the line end
+func formatTSStart(start time.Time) string {
+	return tsStartPrefix + start.Format(time.RFC3339Nano)
+}
+
+// parseTSStart parses a line written by formatTSStart, and returns false
+// if line is not one. The empty fields of a line written as a CSV record
+// are ignored, see OutputOptions.CommentAsRecord.
+func parseTSStart(line string) (time.Time, bool) {
+	if !strings.HasPrefix(line, tsStartPrefix) {
+		return time.Time{}, false
+	}
+	value := strings.TrimRight(line[len(tsStartPrefix):], ",;\t\r\n")
+	start, err := time.Parse(time.RFC3339Nano, value)
+	return start, err == nil
+}
+
+// absoluteTimestamp replaces the "ts" cell of record, in the seconds since
+// start of tsStyleOffsetSeconds, with its RFC 3339 timestamp. Does nothing
+// if start is zero.
+func absoluteTimestamp(header, record []string, start time.Time) error {
+	if start.IsZero() {
+		return nil
+	}
+	for i, name := range header {
+		if name != "ts" || i >= len(record) {
+			continue
+		}
+		d, err := parseOffset(record[i])
+		if err != nil {
+			return fmt.Errorf("invalid ts %q: expected the seconds since the ts-start", record[i])
+		}
+		record[i] = start.Add(d).Format(time.RFC3339Nano)
+	}
+	return nil
+}
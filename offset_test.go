@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatOffset(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0: "0", 90 * time.Second: "90", -250 * time.Millisecond: "-0.25",
+		time.Hour + time.Nanosecond: "3600.000000001", -time.Hour - 1500*time.Millisecond: "-3601.5",
+		-1 << 63: "-9223372036.854775808",
+	} {
+		got := formatOffset(d)
+		if got != want {
+			t.Errorf("%v: expected %q, got %q", d, want, got)
+		}
+		if back, err := parseOffset(got); err != nil || back != d {
+			t.Errorf("%q: expected %v back, got %v, %v", got, d, back, err)
+		}
+	}
+	for _, value := range []string{"", "1m", "1e3", "x", "1.2.3"} {
+		if _, err := parseOffset(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 0, 0, 500000000, time.UTC)
+	for _, value := range []string{"2024-05-01T12:00:00.5Z", "2024-05-01T14:00:00.5+02:00", "1714564800.5"} {
+		if got, err := parseSince(value); err != nil || !got.Equal(want) {
+			t.Errorf("%q: expected %v, got %v, %v", value, want, got, err)
+		}
+	}
+	for _, value := range []string{"", "yesterday", "2024-05-01"} {
+		if _, err := parseSince(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestEncodeCSVSince(t *testing.T) {
+	events := testEvents(time.Second, 2*time.Second)
+	since := events[0].Timestamp.Add(1500 * time.Millisecond)
+	var buf bytes.Buffer
+	encode, prepared, opts, err := prepareOutput(events, OutputOptions{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encode(&buf, prepared, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := "# stopwatch-schema: 2\nseq,ts,offset,what\n" +
+		"0,2022-04-08T20:00:00Z,-1.5,enter\n" +
+		"1,2022-04-08T20:00:01Z,-0.5,tick\n" +
+		"2,2022-04-08T20:00:03Z,1.5,exit\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+	if events[0].Offset != nil {
+		t.Error("Expected the recorded events to be left as they were")
+	}
+	// the offsets are read back, and kept by a conversion
+	loaded, _, err := UnmarshalEventsCSV(strings.NewReader(want))
+	if err != nil || !reflect.DeepEqual(loaded, prepared) {
+		t.Errorf("Expected the events with offsets back, got %v, %v", loaded, err)
+	}
+	if _, _, opts, _ := prepareOutput(loaded, OutputOptions{}); !reflect.DeepEqual(opts.Columns, []string{"offset"}) {
+		t.Errorf("Expected the offset column to be kept, got %v", opts.Columns)
+	}
+
+	buf.Reset()
+	_, prepared, opts, _ = prepareOutput(events, OutputOptions{Since: since, RelativeOnly: true, Format: "ndjson"})
+	if err := EncodeNDJSON(&buf, prepared[:1], opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != `{"seq":0,"offset":-1.5,"what":"enter"}`+"\n" {
+		t.Errorf("Expected the offset only, got %s", got)
+	}
+	if err := (OutputOptions{RelativeOnly: true}).Validate(); err == nil {
+		t.Error("Expected -relative-only to require -since")
+	}
+}
+
+func TestEncodeCSVTSStyle(t *testing.T) {
+	events := testEvents(1500*time.Millisecond, time.Nanosecond)
+	for _, test := range []struct {
+		opts OutputOptions
+		want string
+	}{
+		{OutputOptions{Comment: "run 1", TSStyle: tsStyleOffsetSeconds}, "# run 1\n# stopwatch-schema: 2\n# ts-start: 2022-04-08T20:00:00Z\n" +
+			"seq,ts,what\n0,0,enter\n1,1.5,tick\n2,1.500000001,exit\n"},
+		{ExcelPreset(OutputOptions{TSStyle: tsStyleOffsetSeconds}), "\ufeff# stopwatch-schema: 2;;\r\n# ts-start: 2022-04-08T20:00:00Z;;\r\n" +
+			"seq;ts;what\r\n0;0;enter\r\n1;1.5;tick\r\n2;1.500000001;exit\r\n"},
+	} {
+		var buf bytes.Buffer
+		encode, prepared, opts, err := prepareOutput(events, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := encode(&buf, prepared, opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("Expected:\n%q\ngot:\n%q", test.want, got)
+		}
+		back, comment, err := UnmarshalEventsCSV(&buf)
+		if err != nil || comment != opts.Comment || !reflect.DeepEqual(back, events) {
+			t.Errorf("Expected the events back, got %v, %q, %v", back, comment, err)
+		}
+	}
+}
+
+func TestUnmarshalEventsCSVTSStart(t *testing.T) {
+	// without a comment, and with a comment line that is not a ts-start
+	data := "# stopwatch-schema: 2\n# ts-start: 2022-04-08T22:00:00+02:00\nseq,ts,what\n0,-0.5,enter\n"
+	events, comment, err := UnmarshalEventsCSV(strings.NewReader(data))
+	want := time.Date(2022, 4, 8, 19, 59, 59, 500000000, time.UTC)
+	if err != nil || comment != "" || len(events) != 1 || !events[0].Timestamp.Equal(want) {
+		t.Errorf("Expected %v, got %v, %q, %v", want, events, comment, err)
+	}
+	data = "# ts-start: yesterday\n# stopwatch-schema: 2\n# ts-start: 2022-04-08T20:00:00Z\n" +
+		"seq,ts,what\n0,2022-04-08T20:00:00Z,enter\n"
+	if _, comment, err = UnmarshalEventsCSV(strings.NewReader(data)); err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Errorf("Expected an error on line 5, got %v", err)
+	} else if comment != "ts-start: yesterday" {
+		t.Errorf("Expected the invalid ts-start as the comment, got %q", comment)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "fmt"
+
+// The orders of the events in the output, see OutputOptions.Order
+const (
+	orderAsc  = "asc"  // the default: the oldest event first
+	orderDesc = "desc" // the newest event first, keeping the seq of each
+)
+
+// orders lists the values of -order
+var orders = []string{orderAsc, orderDesc}
+
+// descFormats are the formats writing one row per event in the order
+// given, which -order desc can reverse; the others derive the laps from
+// the order of the events
+var descFormats = map[string]bool{"": true, "csv": true, "json": true, "ndjson": true, "latex": true, "org": true}
+
+// validateOrder checks the -order of the output in the format
+func validateOrder(order, format string) error {
+	switch order {
+	case "", orderAsc:
+	case orderDesc:
+		if !descFormats[format] {
+			return fmt.Errorf("-order %s is only supported with the csv, json, ndjson, latex and org formats", orderDesc)
+		}
+	default:
+		return fmt.Errorf("unknown order %q (available: %s, %s)", order, orderAsc, orderDesc)
+	}
+	return nil
+}
+
+// reversed returns a copy of events in the reverse order
+func reversed(events []Event) []Event {
+	r := make([]Event, len(events))
+	for i, evt := range events {
+		r[len(events)-1-i] = evt
+	}
+	return r
+}
+
+// descending reports whether events are those of a file written with
+// -order desc: more than one, with the seq of each decreasing
+func descending(events []Event) bool {
+	if len(events) < 2 {
+		return false
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq >= events[i-1].Seq {
+			return false
+		}
+	}
+	return true
+}
+
+// inRecordedOrder returns events in the order they were recorded, the
+// descending ones reversed
+func inRecordedOrder(events []Event) []Event {
+	if descending(events) {
+		return reversed(events)
+	}
+	return events
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputOrderDesc(t *testing.T) {
+	events := testEvents(time.Second, 2*time.Second)
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if err := DumpEvents(path, events, OutputOptions{Order: orderDesc, StatsFooter: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := "# stopwatch-schema: 2\nseq,ts,what\n" +
+		"2,2022-04-08T20:00:03Z,exit\n" +
+		"1,2022-04-08T20:00:01Z,tick\n" +
+		"0,2022-04-08T20:00:00Z,enter\n" +
+		"# total: 3\n"
+	if b, _ := os.ReadFile(path); !strings.HasPrefix(string(b), want) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, b)
+	}
+	if events[0].What != labelEnter {
+		t.Error("Expected the recorded events to be left as they were")
+	}
+	// read back in the order recorded
+	loaded, _, err := LoadCSV(path)
+	if err != nil || !reflect.DeepEqual(loaded, events) {
+		t.Errorf("Expected the events back in order, got %v, %v", loaded, err)
+	}
+	if v := validateFile(path); !v.Pass {
+		t.Errorf("Expected PASS, got %v", v)
+	}
+
+	for _, opts := range []OutputOptions{{Order: "newest"}, {Order: orderDesc, Format: "ics"}} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+	if _, err := newStreamOutput(path, OutputOptions{Order: orderDesc}, io.Discard); err == nil {
+		t.Error("Expected an error for -stream -order desc")
+	}
+}
+
+func TestInRecordedOrder(t *testing.T) {
+	events := testEvents(time.Second, time.Second)
+	for _, test := range []struct {
+		events []Event
+		want   []Event
+	}{
+		{events, events},
+		{reversed(events), events},
+		{events[:1], events[:1]},
+		// not in any order, as e.g. edited by hand
+		{[]Event{events[1], events[0], events[2]}, []Event{events[1], events[0], events[2]}},
+	} {
+		if got := inRecordedOrder(test.events); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %v, got %v", test.events, test.want, got)
+		}
+	}
+}
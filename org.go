@@ -0,0 +1,69 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// orgEscape escapes text for use inside an org-mode table cell
+var orgEscape = strings.NewReplacer("|", `\vert{}`, "\r", " ", "\n", " ").Replace
+
+// orgKeyword makes text safe for a single line #+KEYWORD: value
+var orgKeyword = strings.NewReplacer("\r", " ", "\n", " ").Replace
+
+// EncodeOrg writes events into out as an org-mode table. The session name
+// (if any) is written as a #+NAME: line and the comment as #+CAPTION:.
+func EncodeOrg(out io.Writer, events []Event, opts OutputOptions) error {
+	records := opts.records(events)
+	widths := make([]int, len(records[0]))
+	for _, rec := range records {
+		for i, cell := range rec {
+			rec[i] = orgEscape(cell)
+			if n := displayWidth(rec[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	if opts.Name != "" {
+		w.WriteString("#+NAME: " + orgKeyword(opts.Name) + "\n")
+	}
+	if opts.Comment != "" {
+		w.WriteString("#+CAPTION: " + orgKeyword(opts.Comment) + "\n")
+	}
+	for i, rec := range records {
+		w.WriteString("|")
+		for j, cell := range rec {
+			w.WriteString(" " + cell + strings.Repeat(" ", widths[j]-displayWidth(cell)) + " |")
+		}
+		w.WriteString("\n")
+		if i == 0 {
+			// separator between the header and the data rows
+			w.WriteString("|")
+			for j := range rec {
+				if j > 0 {
+					w.WriteString("+")
+				}
+				w.WriteString(strings.Repeat("-", widths[j]+2))
+			}
+			w.WriteString("|\n")
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEncodeOrg(t *testing.T) {
+	events := testEvents(time.Second, 500*time.Millisecond)
+	events[1].What = "a|b"
+	var buf bytes.Buffer
+	opts := OutputOptions{Name: "run-1", Comment: "pipes | everywhere"}
+	if err := EncodeOrg(&buf, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	expect := `#+NAME: run-1
+#+CAPTION: pipes | everywhere
+| seq | ts                     | what      |
+|-----+------------------------+-----------|
+| 0   | 2022-04-08T20:00:00Z   | enter     |
+| 1   | 2022-04-08T20:00:01Z   | a\vert{}b |
+| 2   | 2022-04-08T20:00:01.5Z | exit      |
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
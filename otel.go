@@ -0,0 +1,273 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otelTimeout limits the time spent exporting the trace of -otel-endpoint
+const otelTimeout = 10 * time.Second
+
+func init() {
+	sinkKinds["otel"] = "the session as an OpenTelemetry trace, over OTLP/HTTP at the end"
+}
+
+// otelSpan is a span of the trace of a session, see SessionSpans
+type otelSpan struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte // zero for the root span
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    []otelAttr
+}
+
+// otelAttr is an attribute of a span or of the resource; Value is a
+// string, an int64 or a float64
+type otelAttr struct {
+	Key   string
+	Value interface{}
+}
+
+// SessionSpans builds the trace of a session from its events: a root span
+// from the first event to the last one, named by name (or "stopwatch"),
+// and a child span per lap, from the event before it to the event closing
+// it and named by its label. Marks, warnings and pauses do not start laps,
+// see LapDurations. The IDs are derived from sessionID, so the same events
+// give the same trace.
+func SessionSpans(events []Event, sessionID, name, comment string) []otelSpan {
+	if len(events) == 0 {
+		return nil
+	}
+	if name == "" {
+		name = "stopwatch"
+	}
+	var traceID [16]byte
+	copy(traceID[:], otelID(sessionID, -1))
+	root := otelSpan{
+		TraceID: traceID,
+		Name:    name,
+		Start:   events[0].Timestamp,
+		End:     events[len(events)-1].Timestamp,
+	}
+	copy(root.SpanID[:], otelID(sessionID, 0))
+	if comment != "" {
+		root.Attrs = append(root.Attrs, otelAttr{"stopwatch.comment", comment})
+	}
+	stats := ComputeStats(events)
+	root.Attrs = append(root.Attrs,
+		otelAttr{"stopwatch.events", int64(len(events))},
+		otelAttr{"stopwatch.laps", int64(len(stats.Laps))},
+		otelAttr{"stopwatch.active_s", stats.Active.Seconds()})
+
+	spans := []otelSpan{root}
+	forEachLapIndex(events, func(i int, lap time.Duration) {
+		evt := events[i]
+		span := otelSpan{
+			TraceID:  traceID,
+			ParentID: root.SpanID,
+			Name:     evt.What,
+			Start:    events[lapStart(events, i)].Timestamp,
+			End:      evt.Timestamp,
+			Attrs: []otelAttr{
+				{"stopwatch.seq", evt.Seq},
+				{"stopwatch.lap", int64(len(spans))},
+				{"stopwatch.lap_s", lap.Seconds()},
+			},
+		}
+		copy(span.SpanID[:], otelID(sessionID, evt.Seq+1))
+		if evt.Value != nil {
+			span.Attrs = append(span.Attrs, otelAttr{"stopwatch.value", *evt.Value})
+		}
+		if evt.Group != 0 {
+			span.Attrs = append(span.Attrs, otelAttr{"stopwatch.group", int64(evt.Group)})
+		}
+		if evt.Phase != "" {
+			span.Attrs = append(span.Attrs, otelAttr{"stopwatch.phase", evt.Phase})
+		}
+		for _, key := range AttrColumns([]Event{evt}) {
+			span.Attrs = append(span.Attrs, otelAttr{"stopwatch.attr." + key, evt.Attrs[key]})
+		}
+		spans = append(spans, span)
+	})
+	return spans
+}
+
+// lapStart returns the index of the event starting the lap closed by
+// events[i]: the one before it, skipping the events that do not start a
+// lap
+func lapStart(events []Event, i int) int {
+	j := i - 1
+	for j > 0 && (isAnnotation(events[j].What) || isPauseControl(events[j].What) || isIdle(events[j].What)) {
+		j--
+	}
+	return j
+}
+
+// otelID derives an ID of the trace of session from its ID: the trace ID
+// for n -1, and span IDs otherwise
+func otelID(sessionID string, n int64) []byte {
+	sum := sha256.Sum256([]byte(sessionID + "/" + strconv.FormatInt(n, 10)))
+	return sum[:]
+}
+
+// otelResource returns the resource attributes of the trace of a session
+func otelResource(sessionID, version string) []otelAttr {
+	return []otelAttr{
+		{"service.name", "stopwatch-go"},
+		{"service.version", version},
+		{"stopwatch.session_id", sessionID},
+	}
+}
+
+// OTLP/HTTP JSON encoding of a trace, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID      string         `json:"traceId"`
+		SpanID       string         `json:"spanId"`
+		ParentSpanID string         `json:"parentSpanId,omitempty"`
+		Name         string         `json:"name"`
+		Kind         int            `json:"kind"`
+		Start        string         `json:"startTimeUnixNano"`
+		End          string         `json:"endTimeUnixNano"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		String *string  `json:"stringValue,omitempty"`
+		Int    *string  `json:"intValue,omitempty"` // int64 is a string in JSON
+		Double *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// otlpSpanKindInternal is SPAN_KIND_INTERNAL
+const otlpSpanKindInternal = 1
+
+// EncodeOTLP encodes spans as an OTLP/HTTP JSON export request
+func EncodeOTLP(spans []otelSpan, resource []otelAttr, version string) ([]byte, error) {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "stopwatch-go", Version: version}, Spans: []otlpSpan{}}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:    hex.EncodeToString(s.TraceID[:]),
+			SpanID:     hex.EncodeToString(s.SpanID[:]),
+			Name:       s.Name,
+			Kind:       otlpSpanKindInternal,
+			Start:      strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes: otlpAttributes(s.Attrs),
+		}
+		if s.ParentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	return json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(resource)},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+}
+
+func otlpAttributes(attrs []otelAttr) []otlpKeyValue {
+	var kvs []otlpKeyValue
+	for _, a := range attrs {
+		kv := otlpKeyValue{Key: a.Key}
+		switch v := a.Value.(type) {
+		case string:
+			kv.Value.String = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			kv.Value.Int = &s
+		case float64:
+			kv.Value.Double = &v
+		default:
+			s := fmt.Sprint(v)
+			kv.Value.String = &s
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
+
+// otlpURL returns the URL the traces are posted to for -otel-endpoint: a
+// host:port gets http:// and the default path /v1/traces, and so does a
+// URL without a path
+func otlpURL(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	if scheme, rest, _ := strings.Cut(endpoint, "://"); !strings.Contains(rest, "/") {
+		return scheme + "://" + rest + "/v1/traces"
+	}
+	return endpoint
+}
+
+// postOTLP posts payload, an OTLP/HTTP JSON export request, to url
+func postOTLP(url string, payload []byte) error {
+	client := http.Client{Timeout: otelTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// exportTrace exports the trace of a session to the OTLP/HTTP endpoint
+func exportTrace(endpoint string, events []Event, sessionID, name, comment string) error {
+	version := newVersionInfo(debug.ReadBuildInfo()).Version
+	payload, err := EncodeOTLP(SessionSpans(events, sessionID, name, comment), otelResource(sessionID, version), version)
+	if err != nil {
+		return err
+	}
+	return postOTLP(otlpURL(endpoint), payload)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSessionSpans(t *testing.T) {
+	v := 2.5
+	// enter, a, mark, b, pause, resume, c, exit
+	events := testEvents(seconds(1, 1, 1, 1, 5, 1, 1)...)
+	events[1].What = "a"
+	events[2].What = labelMarkPrefix + "half"
+	events[3].What, events[3].Value, events[3].Attrs = "b", &v, map[string]string{"lane": "3", "by": "x"}
+	events[4].What, events[5].What, events[6].What = labelPause, labelResume, "c"
+
+	spans := SessionSpans(events, "01HZX", "sprint", "run 1")
+	if len(spans) != 4 {
+		t.Fatalf("Expected a root and 3 laps, got %+v", spans)
+	}
+	root := spans[0]
+	if root.Name != "sprint" || root.ParentID != ([8]byte{}) || !root.Start.Equal(events[0].Timestamp) || !root.End.Equal(events[7].Timestamp) {
+		t.Errorf("Unexpected root span %+v", root)
+	}
+	if root.Attrs[0] != (otelAttr{"stopwatch.comment", "run 1"}) {
+		t.Errorf("Expected the comment on the root span, got %v", root.Attrs)
+	}
+	for i, want := range []struct {
+		name       string
+		start, end int
+	}{{"a", 0, 1}, {"b", 1, 3}, {"c", 3, 6}} {
+		span := spans[i+1]
+		if span.Name != want.name || span.ParentID != root.SpanID || span.TraceID != root.TraceID ||
+			!span.Start.Equal(events[want.start].Timestamp) || !span.End.Equal(events[want.end].Timestamp) {
+			t.Errorf("Expected %s from event %d to %d, got %+v", want.name, want.start, want.end, span)
+		}
+		if span.SpanID == root.SpanID || (i > 0 && span.SpanID == spans[i].SpanID) {
+			t.Errorf("Expected unique span IDs, got %+v", spans)
+		}
+	}
+	want := []otelAttr{{"stopwatch.seq", int64(3)}, {"stopwatch.lap", int64(2)}, {"stopwatch.lap_s", 2.0},
+		{"stopwatch.value", 2.5}, {"stopwatch.attr.by", "x"}, {"stopwatch.attr.lane", "3"}}
+	if !reflect.DeepEqual(spans[2].Attrs, want) {
+		t.Errorf("Expected %v, got %v", want, spans[2].Attrs)
+	}
+	// the pause is left out of the lap, not of the span
+	if spans[3].Attrs[2] != (otelAttr{"stopwatch.lap_s", 2.0}) {
+		t.Errorf("Expected the active time of the lap, got %v", spans[3].Attrs)
+	}
+
+	if again := SessionSpans(events, "01HZX", "sprint", "run 1"); !reflect.DeepEqual(again, spans) {
+		t.Error("Expected the same trace for the same session")
+	}
+	if other := SessionSpans(events, "01HZY", "", ""); other[0].TraceID == root.TraceID || other[0].Name != "stopwatch" {
+		t.Errorf("Expected a trace of its own, got %+v", other[0])
+	}
+	if spans := SessionSpans(nil, "01HZX", "", ""); spans != nil {
+		t.Errorf("Expected no spans without events, got %v", spans)
+	}
+}
+
+func TestEncodeOTLP(t *testing.T) {
+	spans := SessionSpans(testEvents(seconds(1, 1)...), "s", "", "")
+	data, err := EncodeOTLP(spans, otelResource("s", "v1.0.0"), "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value map[string]interface{}
+				}
+			}
+			ScopeSpans []struct {
+				Spans []map[string]interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	rs := req.ResourceSpans[0]
+	if a := rs.Resource.Attributes[0]; a.Key != "service.name" || a.Value["stringValue"] != "stopwatch-go" {
+		t.Errorf("Unexpected resource attribute %+v", a)
+	}
+	got := rs.ScopeSpans[0].Spans
+	if len(got) != 2 || got[1]["parentSpanId"] != got[0]["spanId"] || got[0]["parentSpanId"] != nil ||
+		len(got[0]["traceId"].(string)) != 32 || len(got[1]["spanId"].(string)) != 16 {
+		t.Fatalf("Unexpected spans %v", got)
+	}
+	if got[1]["startTimeUnixNano"] != "1649448000000000000" || got[1]["endTimeUnixNano"] != "1649448001000000000" {
+		t.Errorf("Unexpected times %v", got[1])
+	}
+	if !strings.Contains(string(data), `{"key":"stopwatch.seq","value":{"intValue":"1"}}`) {
+		t.Errorf("Expected the integers as strings, got %s", data)
+	}
+}
+
+func TestOTLPURL(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"localhost:4318":                   "http://localhost:4318/v1/traces",
+		"https://collector":                "https://collector/v1/traces",
+		"https://collector/otlp/v1/traces": "https://collector/otlp/v1/traces",
+	} {
+		if got := otlpURL(endpoint); got != want {
+			t.Errorf("%s: expected %s, got %s", endpoint, want, got)
+		}
+	}
+}
+
+func TestExportTrace(t *testing.T) {
+	var path string
+	var spans int
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		spans = strings.Count(string(body), `"spanId"`)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	events := testEvents(seconds(1, 2, 1)...)
+	if err := exportTrace(strings.TrimPrefix(srv.URL, "http://"), events, "s", "", ""); err != nil || path != "/v1/traces" || spans != 3 {
+		t.Errorf("Expected the trace to be posted, got %s, %d spans, %v", path, spans, err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := exportTrace(srv.URL, events, "s", "", ""); err == nil || !strings.HasPrefix(err.Error(), "503") {
+		t.Errorf("Expected the status in the error, got %v", err)
+	}
+}
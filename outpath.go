@@ -0,0 +1,56 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// outputPathData is available to the templates of -o, e.g.
+// "runs/{{.Date}}-{{.Time}}-{{.Name}}.csv"
+type outputPathData struct {
+	Start time.Time // start of the session
+	Date  string    // Start as 2006-01-02
+	Time  string    // Start as 150405
+	Name  string    // the -name flag
+}
+
+// isPathTemplate reports whether path contains template actions
+func isPathTemplate(path string) bool {
+	return strings.Contains(path, "{{")
+}
+
+// expandOutputPath expands the template path for a session starting at
+// start, in the local time zone
+func expandOutputPath(path string, start time.Time, name string) (string, error) {
+	tmpl, err := template.New("-o").Option("missingkey=error").Parse(path)
+	if err != nil {
+		return "", err
+	}
+	start = start.Local()
+	data := outputPathData{Start: start, Date: start.Format("2006-01-02"), Time: start.Format("150405"), Name: name}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("template expands to an empty path")
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandOutputPath(t *testing.T) {
+	start := time.Date(2022, 4, 8, 14, 5, 9, 0, time.Local)
+	for tmpl, want := range map[string]string{
+		"runs/{{.Date}}-{{.Time}}-{{.Name}}.csv": "runs/2022-04-08-140509-sprint.csv",
+		`{{.Start.Format "200601"}}/x.csv`:       "202204/x.csv",
+		"plain.csv":                              "plain.csv",
+	} {
+		got, err := expandOutputPath(tmpl, start, "sprint")
+		if err != nil || got != want {
+			t.Errorf("%q: expected %q, got %q, %v", tmpl, want, got, err)
+		}
+	}
+	for _, tmpl := range []string{"{{.Date", "{{.Bogus}}.csv", "{{.Name}}", `{{.Start.Format 1}}`} {
+		if got, err := expandOutputPath(tmpl, start, ""); err == nil {
+			t.Errorf("Expected error for %q, got %q", tmpl, got)
+		}
+	}
+}
+
+func TestDumpEventsMkDirs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a", "b", "out.csv")
+	events := testEvents(time.Second)
+	if err := DumpEvents(path, events, OutputOptions{}); err == nil {
+		t.Error("Expected error without -mkdirs")
+	}
+	var discard countWriter
+	if err := DryRunEvents(&discard, path, events, OutputOptions{MkDirs: true}); err != nil {
+		t.Errorf("Expected the dry run to accept a missing directory, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("Expected the dry run not to create directories, got %v", err)
+	}
+	if err := DumpEvents(path, events, OutputOptions{MkDirs: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProbeOutput(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a", "b", "out.csv")
+	if err := probeOutput(path, false); err == nil {
+		t.Error("Expected error for a missing directory without -mkdirs")
+	}
+	if err := probeOutput(path, true); err != nil {
+		t.Fatal(err)
+	}
+	// the directory is created, and no file is left behind
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 0 {
+		t.Errorf("Expected an empty directory, got %v, %v", entries, err)
+	}
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := probeOutput(path, false); err != nil {
+		t.Error(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "old" {
+		t.Errorf("Expected the existing file to be left alone, got %q", b)
+	}
+	for _, bad := range []string{filepath.Join(dir, "a"), filepath.Join(path, "x.csv")} {
+		if err := probeOutput(bad, true); err == nil {
+			t.Errorf("Expected error for %s", bad)
+		}
+	}
+	for _, stream := range []string{"-", "stderr", "fd:3"} {
+		if err := probeOutput(stream, false); err != nil {
+			t.Errorf("Expected %s not to be checked, got %v", stream, err)
+		}
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("permissions do not apply to root")
+	}
+	ro := filepath.Join(dir, "ro")
+	if err := os.Mkdir(ro, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	if err := probeOutput(filepath.Join(ro, "out.csv"), false); err == nil {
+		t.Error("Expected error for a read-only directory")
+	}
+	os.Chmod(path, 0o444)
+	if err := probeOutput(path, false); err == nil {
+		t.Error("Expected error for a read-only file")
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// outputFlags holds the command line flags shared by all commands that
+// write events, and translates them into OutputOptions.
+type outputFlags struct {
+	fs          *flag.FlagSet
+	format      *string
+	name        *string
+	delimiter   *string
+	excel       *bool
+	latexFloat  *bool
+	template    *string
+	statsFooter *bool
+	checksum    *bool
+	signKeyFile *string
+	encrypt     *bool
+	compress    *string
+	level       *int
+	backup      *bool
+	mkdirs      *bool
+	manifest    *bool
+	rotateEvery *int
+	rotateSize  byteSize
+	split       *bool
+	withTZ      *bool
+	withEpochNS *bool
+	withID      *bool
+	withTarget  *bool
+	withSource  *bool
+	attrsStyle  *string
+	since       *string
+	relative    *bool
+	durations   *string
+	tsStyle     *string
+	order       *string
+	head        *int
+	redact      *string
+	redactMap   *string
+	tail        *int
+	computed    stringList
+	rename      stringList
+	filters     stringList
+
+	uploadMethod  *string
+	uploadHeaders stringList
+	uploadTimeout *time.Duration
+	uploadRetries *int
+}
+
+// addOutputFlags defines the output flags in fs. The output format flag is
+// named formatFlag.
+func addOutputFlags(fs *flag.FlagSet, formatFlag string) *outputFlags {
+	f := &outputFlags{
+		fs: fs,
+		format: fs.String(formatFlag, "csv", "Output format, one of: "+strings.Join(formatNames(), ", ")+",\n"+
+			"or "+execFormatPrefix+"<program> to run an encoder reading NDJSON from its stdin"),
+		name:      fs.String("name", "", "Name of the session, used by some output formats. Optional"),
+		delimiter: fs.String("delimiter", ",", "CSV field delimiter (use \\t for tab)"),
+		excel: fs.Bool("excel", false, "Write CSV for Microsoft Excel: UTF-8 BOM, CRLF line endings,\n"+
+			"comment as a padded first record and ';' as the default delimiter"),
+		latexFloat: fs.Bool("latex-float", false, "Wrap the LaTeX table in a table float with the comment as caption"),
+		template: fs.String("template-file", "", "Go text/template file executed by -"+formatFlag+" template, see the\n"+
+			"examples directory"),
+		statsFooter: fs.Bool("stats-footer", false, "Append summary statistics as comment lines after the records"),
+		checksum: fs.Bool("checksum", false, "Append a SHA-256 checksum of the output as the last line (csv only)\n"+
+			"Use the 'verify' command to check it"),
+		signKeyFile: fs.String("sign-key-file", "", "Append an HMAC-SHA256 signature of the output made with the key\n"+
+			"read from this file (csv only). Use 'verify -key-file' to check it"),
+		encrypt: fs.Bool("encrypt", false, "Encrypt the output with a passphrase read from $"+passphraseEnv+"\n"+
+			"or prompted for at startup. Use the 'decrypt' command to read it"),
+		compress: fs.String("compress", "", "Compress the output: "+strings.Join(compressionNames(), ", ")+" or "+compressNone+"\n"+
+			"(default: by the suffix of the output file, .gz or .zst)"),
+		level: fs.Int("compress-level", 0, "Compression level: 1-9 for gzip, 1-19 for zstd (default: 6 and 3)"),
+		backup: fs.Bool("backup", false, "Rename an existing output file to <name>.bak (or <name>.1.bak, ...)\n"+
+			"instead of overwriting it"),
+		withTZ:      fs.Bool("with-tz", false, "Add a 'tz' column with the local time zone name of each event"),
+		withEpochNS: fs.Bool("with-epoch-ns", false, "Add a 'ts_ns' column with the timestamp as integer nanoseconds since the Unix epoch"),
+		withID:      fs.Bool("with-id", false, "Add an 'id' column with a unique ULID of each event"),
+		withTarget:  fs.Bool("with-target-column", false, "Add a 'vs_target' column with the difference of each lap to -target-lap in seconds"),
+		withSource:  fs.Bool("with-source", false, "Add a 'source' column telling which input recorded each event, e.g. stdin or tcp"),
+		since: fs.String("since", "", "Add an 'offset' column with the seconds of each event since this instant,\n"+
+			"given as an RFC 3339 timestamp or Unix seconds, e.g. 2024-05-01T12:00:00Z"),
+		relative: fs.Bool("relative-only", false, "Leave out the 'ts' column, keeping only the 'offset' of -since"),
+		tsStyle: fs.String("ts-style", tsStyleRFC3339, "How the 'ts' column is written: '"+tsStyleRFC3339+"', or '"+tsStyleOffsetSeconds+"' for the seconds\n"+
+			"since the first event, with the start in a '"+strings.TrimSpace(tsStartPrefix)+"' line (csv only)"),
+		durations: fs.String("duration-style", durationStyleSeconds, "How the 'offset' and 'vs_target' durations are written: '"+durationStyleSeconds+"', e.g. 83.4,\n"+
+			"'"+durationStyleGo+"', e.g. 1m23.4s, or '"+durationStyleBoth+"' for Go style and a '<column>"+secondsSuffix+"' column of the seconds"),
+		order: fs.String("order", orderAsc, "Order of the events: '"+orderAsc+"', the oldest first, or '"+orderDesc+"', the newest first,\n"+
+			"keeping the seq of each (not with -stream)"),
+		head: fs.Int("head", 0, "Write only the first N events (not with -stream)"),
+		tail: fs.Int("tail", 0, "Write only the last N events, ending in the exit (not with -stream)"),
+		redact: fs.String("redact", "", "Redact the labels and attribute values: '"+redactHash+"' replaces each with a token,\n"+
+			"the same within the session, and '"+redactDrop+"' leaves them empty"),
+		redactMap: fs.String("redact-map", "", "Write the tokens of -redact hash and the texts they replace into this CSV file"),
+		attrsStyle: fs.String("attrs-style", attrsStyleColumns, "How event attributes are written in tables: '"+attrsStyleColumns+"' for one column\n"+
+			"per attribute, or '"+attrsStyleJSON+"' for a single 'attrs' column with a JSON object"),
+		mkdirs: fs.Bool("mkdirs", false, "Create the missing directories of the output file"),
+		manifest: fs.Bool("manifest", false, "Write <output>"+manifestSuffix+" describing each output file once it is complete,\n"+
+			"e.g. for a watcher picking up the recordings; 'validate' checks the file against it"),
+		rotateEvery: fs.Int("rotate-every", 0, "Split the output file after this many events into <base>.1.<ext>, <base>.2.<ext>, ..."),
+		split: fs.Bool("split-by-label", false, "Write the events of each label into <base>.<label>.<ext>, and the enter, exit\n"+
+			"and other events recorded by the stopwatch itself into <base>.session.<ext>"),
+		uploadMethod:  fs.String("upload-method", http.MethodPut, "HTTP method of uploading the output to an -o URL: "+strings.Join(uploadMethods, " or ")),
+		uploadTimeout: fs.Duration("upload-timeout", defaultUploadTimeout, "Time limit of each attempt to upload the output to an -o URL"),
+		uploadRetries: fs.Int("upload-retries", defaultUploadRetries, "Times to retry a failed upload to an -o URL"),
+	}
+	fs.Var(&f.uploadHeaders, "upload-header", "Add this header to the upload to an -o URL, given as 'Name: value',\n"+
+		"e.g. 'Authorization: Bearer ...'. Can be repeated")
+	fs.Var(&f.computed, "column", "Add a column computed for each event by a Go template, given as name={{template}},\n"+
+		"e.g. 'lap_ms={{.Delta.Milliseconds}}'. Can be repeated")
+	fs.Var(&f.rename, "rename", "Write the header text target for the column source, given as source=target,\n"+
+		"e.g. 'ts=timestamp'. Can be repeated")
+	fs.Var(&f.filters, "filter", "Write only the events meeting this condition, column=value or column!=value,\n"+
+		"e.g. 'what!=heartbeat'; enter and exit are always written. Can be repeated, all must hold")
+	fs.Var(&f.rotateSize, "rotate-size", "Split the output file into files of at most this size, e.g. 50MB or 64KiB")
+	completeValues(fs, formatFlag, formatNames)
+	completeValues(fs, "upload-method", func() []string { return uploadMethods })
+	completeValues(fs, "compress", func() []string { return append(compressionNames(), compressNone) })
+	completeValues(fs, "ts-style", func() []string { return tsStyles })
+	completeValues(fs, "order", func() []string { return orders })
+	completeValues(fs, "redact", func() []string { return []string{redactHash, redactDrop} })
+	completeFiles(fs, "redact-map")
+	completeValues(fs, "duration-style", func() []string { return durationStyles })
+	completeValues(fs, "attrs-style", func() []string { return []string{attrsStyleColumns, attrsStyleJSON} })
+	completeFiles(fs, "sign-key-file")
+	completeFiles(fs, "template-file")
+	return f
+}
+
+// options builds and validates OutputOptions from the parsed flags
+func (f *outputFlags) options() (OutputOptions, error) {
+	opts := OutputOptions{
+		Format:        *f.format,
+		Name:          *f.name,
+		StatsFooter:   *f.statsFooter,
+		LaTeXFloat:    *f.latexFloat,
+		Messages:      os.Stderr,
+		Checksum:      *f.checksum,
+		AttrsStyle:    *f.attrsStyle,
+		DurationStyle: *f.durations,
+		TSStyle:       *f.tsStyle,
+		Order:         *f.order,
+		Head:          *f.head,
+		Tail:          *f.tail,
+		Backup:        *f.backup,
+		MkDirs:        *f.mkdirs,
+		Manifest:      *f.manifest,
+
+		Compress:      *f.compress,
+		CompressLevel: *f.level,
+
+		RotateEvents: *f.rotateEvery,
+		RotateSize:   int64(f.rotateSize),
+		SplitByLabel: *f.split,
+	}
+	if opts.RotateEvents < 0 {
+		return opts, fmt.Errorf("-rotate-every must not be negative")
+	}
+	opts.Upload = uploadOptions{Method: strings.ToUpper(*f.uploadMethod), Timeout: *f.uploadTimeout, Retries: *f.uploadRetries}
+	if opts.Upload.Method != http.MethodPut && opts.Upload.Method != http.MethodPost {
+		return opts, fmt.Errorf("-upload-method must be %s", strings.Join(uploadMethods, " or "))
+	}
+	if opts.Upload.Timeout <= 0 || opts.Upload.Retries < 0 {
+		return opts, fmt.Errorf("-upload-timeout must be positive, and -upload-retries not negative")
+	}
+	for _, s := range f.uploadHeaders {
+		name, value, err := parseUploadHeader(s)
+		if err != nil {
+			return opts, fmt.Errorf("-upload-header: %w", err)
+		}
+		if opts.Upload.Header == nil {
+			opts.Upload.Header = http.Header{}
+		}
+		opts.Upload.Header.Add(name, value)
+	}
+	if *f.since != "" {
+		since, err := parseSince(*f.since)
+		if err != nil {
+			return opts, err
+		}
+		opts.Since = since
+	}
+	opts.RelativeOnly = *f.relative
+	renames, err := parseRenames(f.rename)
+	if err != nil {
+		return opts, fmt.Errorf("-rename: %w", err)
+	}
+	opts.Rename = renames
+	for _, s := range f.filters {
+		filter, err := parseFilter(s)
+		if err != nil {
+			return opts, fmt.Errorf("-filter: %w", err)
+		}
+		opts.Filters = append(opts.Filters, filter)
+	}
+	if flagWasSet(f.fs, "delimiter") {
+		delim, err := ParseDelimiter(*f.delimiter)
+		if err != nil {
+			return opts, err
+		}
+		opts.Delimiter = delim
+	}
+	if *f.template != "" {
+		tmpl, err := ParseTemplateFile(*f.template)
+		if err != nil {
+			return opts, err
+		}
+		opts.Template = tmpl
+	}
+	for _, spec := range f.computed {
+		col, err := ParseComputedColumn(spec)
+		if err != nil {
+			return opts, err
+		}
+		for _, prev := range opts.Computed {
+			if prev.Name == col.Name {
+				return opts, fmt.Errorf("-column %s given twice", col.Name)
+			}
+		}
+		opts.Computed = append(opts.Computed, col)
+	}
+	if *f.redact != "" {
+		r, err := newRedactor(*f.redact, *f.redactMap)
+		if err != nil {
+			return opts, err
+		}
+		opts.Redact = r
+	} else if *f.redactMap != "" {
+		return opts, fmt.Errorf("-redact-map requires -redact %s", redactHash)
+	}
+	if *f.signKeyFile != "" {
+		key, err := LoadKeyFile(*f.signKeyFile, os.Stderr)
+		if err != nil {
+			return opts, err
+		}
+		opts.SignKey = key
+	}
+	if *f.encrypt {
+		pass, err := readPassphrase(true)
+		if err != nil {
+			return opts, fmt.Errorf("could not read passphrase: %w", err)
+		}
+		opts.Passphrase = pass
+	}
+	if *f.withTZ {
+		opts.Columns = append(opts.Columns, "tz")
+	}
+	if *f.withEpochNS {
+		opts.Columns = append(opts.Columns, "ts_ns")
+	}
+	if *f.withID {
+		opts.Columns = append(opts.Columns, "id")
+	}
+	if *f.withSource {
+		opts.Columns = append(opts.Columns, "source")
+	}
+	if *f.withTarget {
+		opts.Columns = append(opts.Columns, "vs_target")
+	}
+	if *f.excel {
+		opts = ExcelPreset(opts)
+	}
+	return opts, opts.Validate()
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the policies of -overflow, for a tick received while the input queue is
+// full
+const (
+	overflowDropNewest = "drop-newest" // the tick received is dropped, and the sender told so
+	overflowDropOldest = "drop-oldest" // the oldest tick waiting is dropped to make room
+)
+
+// inputQueueSize is the number of lines received from outside the
+// terminal that may wait for the collector, see remoteInput
+const inputQueueSize = 1024
+
+// overflowMetaKey is the metadata line of the number of ticks dropped
+// by -overflow
+const overflowMetaKey = "overflow-dropped"
+
+var (
+	errInputQueueFull = errors.New("input queue full, dropped")
+	errInputStopped   = errors.New("the session is ending, not recorded")
+)
+
+// parseOverflow checks the -overflow policy
+func parseOverflow(policy string) (string, error) {
+	switch policy {
+	case overflowDropNewest, overflowDropOldest:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown -overflow %q, expected %s or %s", policy, overflowDropNewest, overflowDropOldest)
+}
+
+// oldestTick returns the index of the first line of queue that may be
+// dropped, i.e. is not a suspension, or -1
+func oldestTick(queue []queuedLine) int {
+	for i, line := range queue {
+		if !line.req.suspension {
+			return i
+		}
+	}
+	return -1
+}
+
+// warnOverflow warns that the queue overflowed for the first time; the
+// later drops are only counted
+func (in *remoteInput) warnOverflow() {
+	out := in.warn
+	if out == nil {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "\n# WARNING: ticks arrive faster than they can be recorded, and the input queue of %d is full;\n"+
+		"# dropping the %s ones (-overflow). The count is given at the end\n", in.queueSize,
+		strings.TrimPrefix(in.overflow, "drop-"))
+}
+
+// overflowed returns the number of ticks dropped from the full queue so
+// far, by source
+func (in *remoteInput) overflowed() map[string]int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	dropped := make(map[string]int, len(in.dropped))
+	for source, n := range in.dropped {
+		dropped[source] = n
+	}
+	return dropped
+}
+
+// overflowTotal returns the sum of the counts of dropped
+func overflowTotal(dropped map[string]int) int {
+	total := 0
+	for _, n := range dropped {
+		total += n
+	}
+	return total
+}
+
+// overflowMeta returns the metadata line of the ticks dropped, if any
+func overflowMeta(dropped map[string]int) []metaField {
+	if total := overflowTotal(dropped); total > 0 {
+		return []metaField{{Key: overflowMetaKey, Value: strconv.Itoa(total)}}
+	}
+	return nil
+}
+
+// WriteOverflowSummary writes the number of ticks dropped by -overflow,
+// by source, if any
+func WriteOverflowSummary(w io.Writer, dropped map[string]int, policy string) {
+	total := overflowTotal(dropped)
+	if total == 0 {
+		return
+	}
+	var sources []string
+	for source := range dropped {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	var counts []string
+	for _, source := range sources {
+		name := source
+		if name == "" {
+			name = "other"
+		}
+		counts = append(counts, fmt.Sprintf("%s %d", name, dropped[source]))
+	}
+	fmt.Fprintf(w, "# %d ticks dropped by -overflow %s, the input queue being full (%s)\n", total, policy,
+		strings.Join(counts, ", "))
+}
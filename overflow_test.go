@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoteInputOverflow(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		first  int    // of the lines recorded
+		reply  string // to the line sent when the queue is full
+	}{
+		{overflowDropNewest, 0, "error input queue full, dropped"}, {overflowDropOldest, 13, "ok 8"},
+	} {
+		sess := newSession("", collectOptions{})
+		sess.start()
+		sw := NewStopwatch(sess, io.Discard)
+		in := newRemoteInput()
+		var warn bytes.Buffer
+		in.queueSize, in.overflow, in.warn = 8, test.policy, &warn
+
+		// nothing is recorded before attach, so the queue fills up
+		at := make(map[string]time.Time)
+		for i := 0; i < 20; i++ {
+			req := remoteLine{text: fmt.Sprint("s", i), source: sourceUDP, at: time.Now(), exact: true, async: true}
+			at[req.text] = req.at
+			if reply := in.send(req); reply != "" {
+				t.Errorf("Expected no reply to an async line, got %q", reply)
+			}
+		}
+		reply := make(chan string)
+		go func() { reply <- in.send(remoteLine{text: "waiting", source: sourceTCP, exact: true}) }()
+		for deadline := time.Now().Add(5 * time.Second); overflowTotal(in.overflowed()) < 13 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		in.attach(func(req remoteLine) string {
+			time.Sleep(time.Millisecond) // a slow collector
+			return sw.remote(req)
+		})
+		if got := <-reply; got != test.reply {
+			t.Errorf("%s: expected the reply %q, got %q", test.policy, test.reply, got)
+		}
+		in.stop()
+		sess.finish()
+
+		events := sess.Events[1 : len(sess.Events)-1]
+		if len(events) != 8 {
+			t.Fatalf("%s: expected the 8 lines queued to be recorded, got %v", test.policy, events)
+		}
+		for i, evt := range events[:7] {
+			if want := fmt.Sprint("s", test.first+i); evt.What != want || !evt.Timestamp.Equal(at[want]) {
+				t.Errorf("%s: expected %s at %v, the time it was queued, got %+v", test.policy, want, at[want], evt)
+			}
+		}
+		dropped := in.overflowed()
+		if dropped[sourceUDP]+dropped[sourceTCP] != 13 {
+			t.Errorf("%s: expected 13 ticks dropped, got %v", test.policy, dropped)
+		}
+		if n := strings.Count(warn.String(), "WARNING: ticks arrive faster"); n != 1 {
+			t.Errorf("%s: expected a single warning, got %q", test.policy, warn.String())
+		}
+		var summary bytes.Buffer
+		WriteOverflowSummary(&summary, dropped, test.policy)
+		if !strings.HasPrefix(summary.String(), "# 13 ticks dropped by -overflow "+test.policy) {
+			t.Errorf("Unexpected summary %q", summary.String())
+		}
+		if meta := overflowMeta(dropped); len(meta) != 1 || meta[0] != (metaField{Key: overflowMetaKey, Value: "13"}) {
+			t.Errorf("Expected the count in the metadata, got %v", meta)
+		}
+	}
+	if _, err := parseOverflow("block"); err == nil {
+		t.Error("Expected an error for an unknown -overflow")
+	}
+}
+
+func TestRemoteInputBursts(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	sess.start()
+	sw := NewStopwatch(sess, io.Discard)
+	in := newRemoteInput()
+	in.queueSize, in.warn = 16, io.Discard
+	in.attach(func(req remoteLine) string {
+		time.Sleep(200 * time.Microsecond)
+		return sw.remote(req)
+	})
+
+	// several sources flooding far faster than the collector records
+	const sources, bursts, burst = 4, 5, 200
+	var mu sync.Mutex
+	at := make(map[string]time.Time)
+	for i := 0; i < sources; i++ {
+		i := i
+		in.start(fmt.Sprint("source ", i), funcSource(func(ctx context.Context, emit func(req remoteLine) string) error {
+			for b := 0; b < bursts; b++ {
+				for j := 0; j < burst; j++ {
+					req := remoteLine{text: fmt.Sprintf("s%d-%d-%d", i, b, j), source: fmt.Sprint(i), at: time.Now(),
+						exact: true, async: true}
+					mu.Lock()
+					at[req.text] = req.at
+					mu.Unlock()
+					emit(req)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			<-ctx.Done()
+			return nil
+		}))
+	}
+	time.Sleep(20 * time.Millisecond)
+	in.stop()
+	sess.finish()
+
+	recorded := len(sess.Events) - 2
+	dropped := in.overflowed()
+	if total := overflowTotal(dropped); total == 0 || recorded+total != sources*bursts*burst {
+		t.Fatalf("Expected %d lines recorded or dropped, got %d recorded and %v dropped", sources*bursts*burst, recorded, dropped)
+	}
+	for i, evt := range sess.Events {
+		if evt.Seq != int64(i) || (i > 0 && evt.Timestamp.Before(sess.Events[i-1].Timestamp)) {
+			t.Fatalf("Expected the events in the order recorded, got %+v after %+v", evt, sess.Events[i-1])
+		}
+		if !isSentinel(evt.What) && !evt.Timestamp.Equal(at[evt.What]) {
+			t.Errorf("Expected %s at %v, the time it was received, got %v", evt.What, at[evt.What], evt.Timestamp)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UnmarshalEventsCSV parses events from CSV data produced by MarshallEventsCSV.
+// The comment on the first line (if any) is returned without the "# " prefix.
+// The metadata lines after it are read as their schema version allows, see
+// schemas: the timestamps of -ts-style offset-seconds are converted back
+// with the "# ts-start: " line. Any other comment lines, such as the
+// statistics footer, are skipped. The field delimiter is sniffed from the
+// header, see sniffDelimiter, and a UTF-8 byte order mark and CRLF line
+// endings are accepted, so that files saved by spreadsheets can be read.
+func UnmarshalEventsCSV(in io.Reader) (events []Event, comment string, err error) {
+	return UnmarshalEventsCSVWith(in, ReadOptions{})
+}
+
+// ReadOptions tell how the CSV files read were written
+type ReadOptions struct {
+	Delimiter rune              // field delimiter, or 0 to sniff it from the header
+	Rename    map[string]string // header text of the renamed columns, see parseRenames
+}
+
+// UnmarshalEventsCSVWith is UnmarshalEventsCSV with the dialect of the file
+// given in opts
+func UnmarshalEventsCSVWith(in io.Reader, opts ReadOptions) (events []Event, comment string, err error) {
+	events, p, err := unmarshalCSV(in, opts)
+	return events, p.comment, err
+}
+
+// unmarshalCSV is UnmarshalEventsCSVWith returning the whole preamble, with
+// the comment and the metadata lines
+func unmarshalCSV(in io.Reader, opts ReadOptions) (events []Event, p csvPreamble, err error) {
+	delim, columns := opts.Delimiter, renamedColumns(opts.Rename)
+	br := bufio.NewReader(in)
+	lineOffset := 0
+	if bom, _ := br.Peek(len(utf8BOM)); string(bom) == utf8BOM {
+		br.Discard(len(bom))
+	}
+
+	// Only the preamble is meaningful; csv.Reader discards the rest.
+	for {
+		if first, err := br.Peek(1); err != nil || first[0] != '#' {
+			break
+		}
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, p, err
+		}
+		lineOffset++
+		if ok, err := p.add(line); err != nil {
+			return nil, p, fmt.Errorf("line %d: %w", lineOffset, err)
+		} else if !ok {
+			break
+		}
+	}
+
+	// the header line is sniffed, and then read again by the csv.Reader
+	var headerLine string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, p, err
+		}
+		if trimmed := strings.TrimRight(line, "\r\n"); trimmed != "" && trimmed[0] != '#' || err == io.EOF {
+			headerLine = line
+			break
+		}
+		lineOffset++
+	}
+	if delim == 0 {
+		if delim, err = sniffDelimiter(headerLine, p.schema(), columns); err != nil {
+			return nil, p, err
+		}
+	}
+	r := csv.NewReader(io.MultiReader(strings.NewReader(headerLine), br))
+	r.Comment = '#'
+	r.Comma = delim
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, p, fmt.Errorf("missing header")
+	}
+	if err != nil {
+		return nil, p, err
+	}
+	header = unrename(header, columns)
+	if err := checkHeader(header, p.schema()); err != nil {
+		return nil, p, err
+	}
+	// the empty fields of a comment written as a CSV record, which is not
+	// quoted, see OutputOptions.CommentAsRecord; a comment ending in the
+	// delimiter keeps it
+	p.comment = strings.TrimSuffix(p.comment, strings.Repeat(string(delim), len(header)-1))
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, p, err
+		}
+		line, _ := r.FieldPos(0)
+		if err := absoluteTimestamp(header, record, p.start); err != nil {
+			return nil, p, fmt.Errorf("line %d: %w", line+lineOffset, err)
+		}
+		evt, err := parseEventRow(header, record)
+		if err != nil {
+			return nil, p, fmt.Errorf("line %d: %w", line+lineOffset, err)
+		}
+		events = append(events, evt)
+	}
+	return events, p, nil
+}
+
+// csvDelimiters are the field delimiters recognized by sniffDelimiter
+var csvDelimiters = []rune{',', ';', '\t'}
+
+// sniffDelimiter returns the field delimiter of the header line: the one of
+// csvDelimiters splitting it into a header valid in the schema s, see
+// checkHeader, with the renamed columns of renamedColumns. If none of them
+// does, it is ',', for checkHeader to tell what is wrong; if several do,
+// the file is ambiguous.
+func sniffDelimiter(line string, s schema, columns map[string]string) (rune, error) {
+	var found []string
+	delim := ','
+	for _, d := range csvDelimiters {
+		r := csv.NewReader(strings.NewReader(line))
+		r.Comma = d
+		if header, err := r.Read(); err == nil && checkHeader(unrename(header, columns), s) == nil {
+			found, delim = append(found, fmt.Sprintf("%q", d)), d
+		}
+	}
+	if len(found) > 1 {
+		return 0, fmt.Errorf("ambiguous header %q: the columns are delimited by any of %s; give the delimiter explicitly",
+			strings.TrimRight(line, "\r\n"), strings.Join(found, ", "))
+	}
+	return delim, nil
+}
+
+// parseInputDelimiter validates the field delimiter of the files read given
+// on the command line; empty is 0, for sniffDelimiter
+func parseInputDelimiter(s string) (rune, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return ParseDelimiter(s)
+}
+
+// checkHeader verifies that header contains every column required by the
+// schema s, and that the rest are known optional columns or valid attribute
+// names
+func checkHeader(header []string, s schema) error {
+	known := knownColumns()
+	seen := make(map[string]bool)
+	for _, name := range header {
+		if !known[name] && name != attrsColumn && validateAttrKey(name) != nil {
+			return fmt.Errorf("unexpected column %q in header %q", name, header)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate column %q in header %q", name, header)
+		}
+		seen[name] = true
+	}
+	for _, name := range s.required {
+		if !seen[name] {
+			return fmt.Errorf("missing column %q in header %q", name, header)
+		}
+	}
+	return nil
+}
+
+// knownColumns returns the set of built-in column names
+func knownColumns() map[string]bool {
+	known := make(map[string]bool)
+	for _, col := range eventColumns() {
+		known[col.name] = true
+	}
+	return known
+}
+
+// parseEventRow is the inverse of Event.Cells. If both "ts" and "ts_ns"
+// are present, the exact integer timestamp takes precedence. Columns other
+// than the built-in ones are attributes; empty cells are left out.
+func parseEventRow(header, record []string) (Event, error) {
+	var evt Event
+	known := knownColumns()
+	nsIndex := -1
+	for i, name := range header {
+		if name == "ts_ns" {
+			nsIndex = i
+			continue
+		}
+		if name == attrsColumn {
+			attrs, err := unmarshalAttrs(record[i])
+			if err != nil {
+				return Event{}, fmt.Errorf("invalid %s: %w", attrsColumn, err)
+			}
+			for key, value := range attrs {
+				if evt.Attrs == nil {
+					evt.Attrs = make(map[string]string)
+				}
+				evt.Attrs[key] = value
+			}
+			continue
+		}
+		if !known[name] {
+			if record[i] != "" {
+				if evt.Attrs == nil {
+					evt.Attrs = make(map[string]string)
+				}
+				evt.Attrs[name] = record[i]
+			}
+			continue
+		}
+		if err := evt.SetCell(name, record[i]); err != nil {
+			return Event{}, err
+		}
+	}
+	if nsIndex >= 0 {
+		if err := evt.SetCell("ts_ns", record[nsIndex]); err != nil {
+			return Event{}, err
+		}
+	}
+	return evt, nil
+}
+
+// EventsFromRecords is the inverse of EventsToRecords: it parses the
+// records following the header record into events. The header must have
+// the default columns, and may have any other built-in or attribute
+// columns.
+func EventsFromRecords(records [][]string) ([]Event, error) {
+	return EventsFromRecordsWith(records, nil)
+}
+
+// EventsFromRecordsWith is like EventsFromRecords, but unless allowed is
+// nil, the header may only have the columns in allowed besides the default
+// ones
+func EventsFromRecordsWith(records [][]string, allowed []string) ([]Event, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header")
+	}
+	header := records[0]
+	if err := checkHeader(header, schemas[schemaVersion]); err != nil {
+		return nil, err
+	}
+	if allowed != nil {
+		ok := make(map[string]bool)
+		for _, name := range append(GetEventColumnNames(), allowed...) {
+			ok[name] = true
+		}
+		for _, name := range header {
+			if !ok[name] {
+				return nil, fmt.Errorf("column %q not allowed in header %q", name, header)
+			}
+		}
+	}
+	var events []Event
+	for i, record := range records[1:] {
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("record %d: expected %d fields, got %d", i+1, len(header), len(record))
+		}
+		evt, err := parseEventRow(header, record)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		events = append(events, evt)
+	}
+	return events, nil
+}
+
+// LoadCSV reads events from a file written by DumpCSV. Filenames "" and "-"
+// are interpreted as stdin. Compressed input is recognized and decompressed,
+// see compressions. The events of a file written with -order desc are
+// returned in the order they were recorded, see inRecordedOrder.
+func LoadCSV(inFile string) ([]Event, string, error) {
+	return LoadCSVWith(inFile, ReadOptions{})
+}
+
+// LoadCSVWith is LoadCSV with the dialect of the file given in opts
+func LoadCSVWith(inFile string, opts ReadOptions) ([]Event, string, error) {
+	events, p, err := loadCSV(inFile, opts)
+	return events, p.comment, err
+}
+
+// loadCSV is LoadCSVWith returning the whole preamble, see unmarshalCSV
+func loadCSV(inFile string, opts ReadOptions) ([]Event, csvPreamble, error) {
+	in := os.Stdin
+	if inFile != "-" && inFile != "" {
+		f, err := os.Open(inFile)
+		if err != nil {
+			return nil, csvPreamble{}, fmt.Errorf("could not open file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := decompressed(in)
+	if err != nil {
+		return nil, csvPreamble{}, err
+	}
+	events, p, err := unmarshalCSV(r, opts)
+	return inRecordedOrder(events), p, err
+}
+
+// LoadCSVFiles reads and concatenates the events of several CSV files, such
+// as the parts of a rotated output. Each pattern may be a glob (see
+// filepath.Match). The events are sorted by timestamp, and then by sequence
+// number, as in the files of -split-by-label; the comment of the first file
+// having one is returned.
+func LoadCSVFiles(patterns []string) ([]Event, string, error) {
+	return LoadCSVFilesWith(patterns, ReadOptions{})
+}
+
+// LoadCSVFilesWith is LoadCSVFiles with the dialect of the files given in
+// opts
+func LoadCSVFilesWith(patterns []string, opts ReadOptions) ([]Event, string, error) {
+	events, p, err := loadCSVFiles(patterns, opts)
+	return events, p.comment, err
+}
+
+// loadCSVFiles is LoadCSVFilesWith returning the preamble of the files: the
+// comment, and the metadata lines, of the first file having them
+func loadCSVFiles(patterns []string, opts ReadOptions) ([]Event, csvPreamble, error) {
+	var preamble csvPreamble
+	files, err := expandPatterns(patterns)
+	if err != nil {
+		return nil, preamble, err
+	}
+	var all []Event
+	for _, file := range files {
+		events, p, err := loadCSV(file, opts)
+		if err != nil {
+			return nil, csvPreamble{}, fmt.Errorf("%s: %w", file, err)
+		}
+		if preamble.comment == "" {
+			preamble.comment = p.comment
+		}
+		if preamble.meta == nil {
+			preamble.meta = p.meta
+		}
+		all = append(all, events...)
+	}
+	if len(files) > 1 {
+		sort.SliceStable(all, func(i, j int) bool {
+			if !all[i].Timestamp.Equal(all[j].Timestamp) {
+				return all[i].Timestamp.Before(all[j].Timestamp)
+			}
+			return all[i].Seq < all[j].Seq
+		})
+	}
+	return all, preamble, nil
+}
+
+// expandPatterns returns the files matching the glob patterns, see
+// filepath.Match. A pattern without matches is returned as is, for opening
+// it to report the error.
+func expandPatterns(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if matches == nil {
+			matches = []string{pattern}
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalEventsCSVRoundTrip(t *testing.T) {
+	events := testEvents(time.Second, 2500*time.Millisecond, time.Second)
+	events[1].What = "with, comma and \"quotes\""
+
+	for _, footer := range []bool{false, true} {
+		var buf bytes.Buffer
+		opts := OutputOptions{Comment: "hello world", StatsFooter: footer}
+		if err := EncodeCSV(&buf, events, opts); err != nil {
+			t.Fatal(err)
+		}
+		got, comment, err := UnmarshalEventsCSV(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment != "hello world" {
+			t.Errorf("Expected comment %q, got %q", "hello world", comment)
+		}
+		if !reflect.DeepEqual(events, got) {
+			t.Errorf("Round trip mismatch (footer=%v):\nexpected: %v\ngot: %v", footer, events, got)
+		}
+	}
+}
+
+func TestUnmarshalEventsCSVErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"# only a comment\n",
+		"seq,when,what\n",
+		"seq,ts,what\nx,2022-04-08T20:00:00Z,enter\n",
+		"seq,ts,what\n0,yesterday,enter\n",
+	} {
+		if _, _, err := UnmarshalEventsCSV(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected error for input %q", input)
+		}
+	}
+}
+
+func TestUnmarshalEventsCSVDialects(t *testing.T) {
+	want := testEvents(time.Second)
+	for _, data := range []string{
+		"# run 1\nseq;ts;what\n0;2022-04-08T20:00:00Z;enter\n1;2022-04-08T20:00:01Z;exit\n",
+		"# run 1\n# another comment\n\nseq\tts\twhat\n0\t2022-04-08T20:00:00Z\tenter\n1\t2022-04-08T20:00:01Z\texit\n",
+		"\ufeff# run 1;;\r\n# stopwatch-schema: 2;;\r\nseq;ts;what\r\n0;2022-04-08T20:00:00Z;enter\r\n1;2022-04-08T20:00:01Z;exit\r\n",
+	} {
+		events, comment, err := UnmarshalEventsCSV(strings.NewReader(data))
+		if err != nil || !reflect.DeepEqual(events, want) {
+			t.Errorf("%q: expected %v, got %v, %v", data, want, events, err)
+		}
+		if comment != "run 1" {
+			t.Errorf("%q: expected the comment without padding, got %q", data, comment)
+		}
+	}
+
+	// both delimiters split the header into valid columns
+	data := "seq,ts,what,a;seq;ts;what\n"
+	if _, _, err := UnmarshalEventsCSV(strings.NewReader(data)); err == nil || !strings.Contains(err.Error(), `',', ';'`) {
+		t.Errorf("Expected an ambiguous header, got %v", err)
+	}
+	if _, _, err := UnmarshalEventsCSVWith(strings.NewReader(data), ReadOptions{Delimiter: ';'}); err != nil {
+		t.Errorf("Expected the delimiter given to be used, got %v", err)
+	}
+	// the delimiter given is not sniffed over
+	if _, _, err := UnmarshalEventsCSVWith(strings.NewReader("seq;ts;what\n"), ReadOptions{Delimiter: ','}); err == nil {
+		t.Error("Expected an error for the delimiter given")
+	}
+}
+
+func TestStatsFooter(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(time.Second, 2*time.Second, 500*time.Millisecond)
+	if err := EncodeCSV(&buf, events, OutputOptions{StatsFooter: true}); err != nil {
+		t.Fatal(err)
+	}
+	expect := "# total: 3.5\n# ticks: 2\n# laps: 2\n# min_lap: 1\n# mean_lap: 1.5\n# max_lap: 2\n"
+	if got := buf.String(); !strings.HasSuffix(got, expect) {
+		t.Fatalf("Expected footer %q, got: %q", expect, got)
+	}
+
+	// in the style of the duration columns
+	for style, expect := range map[string]string{
+		durationStyleGo:   "# total: 3.5s\n# ticks: 2\n# laps: 2\n# min_lap: 1s\n# mean_lap: 1.5s\n# max_lap: 2s\n",
+		durationStyleBoth: "# total: 3.5s\n# total_s: 3.5\n# ticks: 2\n# laps: 2\n# min_lap: 1s\n# min_lap_s: 1\n",
+	} {
+		buf.Reset()
+		if err := EncodeCSV(&buf, events, OutputOptions{StatsFooter: true, DurationStyle: style}); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); !strings.Contains(got, expect) {
+			t.Errorf("%s: expected footer %q, got: %q", style, expect, got)
+		}
+	}
+}
+
+func TestUnmarshalEventsCSVOptionalColumns(t *testing.T) {
+	events := testEvents(time.Second, time.Second)
+	for i := range events {
+		events[i].Zone = "Europe/Helsinki"
+	}
+	var buf bytes.Buffer
+	if err := EncodeCSV(&buf, events, OutputOptions{Columns: []string{"tz"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "# stopwatch-schema: 2\nseq,ts,what,tz\n") {
+		t.Fatalf("Expected tz column in header, got: %q", buf.String())
+	}
+	got, _, err := UnmarshalEventsCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, got) {
+		t.Fatalf("Round trip mismatch:\nexpected: %v\ngot: %v", events, got)
+	}
+
+	// column order does not matter, but the default columns are required
+	input := "tz,what,seq,ts\nUTC,enter,0,2022-04-08T20:00:00Z\n"
+	if _, _, err := UnmarshalEventsCSV(strings.NewReader(input)); err != nil {
+		t.Errorf("Expected reordered columns to parse, got: %v", err)
+	}
+	for _, input := range []string{"seq,ts,tz\n", "seq,ts,what,what\n", "seq,ts,what,bad key\n", "seq,ts,what,a=b\n"} {
+		if _, _, err := UnmarshalEventsCSV(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected error for header %q", input)
+		}
+	}
+}
+
+func TestUnmarshalEventsCSVEpochNS(t *testing.T) {
+	events := testEvents(1234*time.Microsecond+5600, time.Second)
+	events[1].Timestamp = events[1].Timestamp.In(time.FixedZone("", 3*3600))
+	var buf bytes.Buffer
+	if err := EncodeCSV(&buf, events, OutputOptions{Columns: []string{"ts_ns"}}); err != nil {
+		t.Fatal(err)
+	}
+	expect := "# stopwatch-schema: 2\nseq,ts,ts_ns,what\n" +
+		"0,2022-04-08T20:00:00Z,1649448000000000000,enter\n" +
+		"1,2022-04-08T23:00:00.0012396+03:00,1649448000001239600,tick\n"
+	if !strings.HasPrefix(buf.String(), expect) {
+		t.Fatalf("Expected prefix %q, got: %q", expect, buf.String())
+	}
+	got, _, err := UnmarshalEventsCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range events {
+		if !events[i].Timestamp.Equal(got[i].Timestamp) || events[i].Timestamp.String() != got[i].Timestamp.String() {
+			t.Errorf("Event %d: expected %v, got %v", i, events[i].Timestamp, got[i].Timestamp)
+		}
+	}
+
+	// the integer column wins, regardless of column order
+	input := "ts_ns,seq,ts,what\n1649448000000000001,0,2022-04-08T23:00:00+03:00,enter\n"
+	got, _, err = UnmarshalEventsCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := got[0].Timestamp.UnixNano(); ns != 1649448000000000001 {
+		t.Errorf("Expected ts_ns to take precedence, got %d", ns)
+	}
+	if _, offset := got[0].Timestamp.Zone(); offset != 3*3600 {
+		t.Errorf("Expected the offset of ts to be preserved, got %d", offset)
+	}
+}
+
+func TestUnmarshalEventsCSVValue(t *testing.T) {
+	v, w := 42.5, -1e-7
+	events := testEvents(time.Second, time.Second, time.Second)
+	events[1].Value, events[2].Value = &v, &w
+
+	var buf bytes.Buffer
+	if err := EncodeCSV(&buf, events, OutputOptions{Columns: dataColumns(events, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "seq,ts,what,value\n") || !strings.Contains(buf.String(), ",tick,42.5\n") {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+	got, _, err := UnmarshalEventsCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, got) {
+		t.Errorf("Round trip mismatch:\nexpected: %v\ngot: %v", events, got)
+	}
+
+	if cols := dataColumns(testEvents(time.Second), []string{"tz"}); !reflect.DeepEqual(cols, []string{"tz"}) {
+		t.Errorf("Expected no value column without values, got %q", cols)
+	}
+}
+
+func TestUnmarshalEventsCSVAttrs(t *testing.T) {
+	events := testEvents(time.Second, time.Second, time.Second)
+	events[1].Attrs = map[string]string{"build": "release", "arch": "arm64"}
+	events[2].Attrs = map[string]string{"build": "debug", "cc": "a,b"}
+
+	var buf bytes.Buffer
+	if err := EncodeCSV(&buf, events, OutputOptions{Attrs: AttrColumns(events)}); err != nil {
+		t.Fatal(err)
+	}
+	expect := "# stopwatch-schema: 2\nseq,ts,what,arch,build,cc\n" +
+		"0,2022-04-08T20:00:00Z,enter,,,\n" +
+		"1,2022-04-08T20:00:01Z,tick,arm64,release,\n" +
+		"2,2022-04-08T20:00:02Z,tick,,debug,\"a,b\"\n" +
+		"3,2022-04-08T20:00:03Z,exit,,,\n"
+	if buf.String() != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, buf.String())
+	}
+	got, _, err := UnmarshalEventsCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, got) {
+		t.Errorf("Round trip mismatch:\nexpected: %v\ngot: %v", events, got)
+	}
+}
+
+func TestUnmarshalEventsCSVAttrsJSON(t *testing.T) {
+	events := testEvents(time.Second, time.Second)
+	events[1].Attrs = map[string]string{"build": "release", "arch": "arm64"}
+
+	var buf bytes.Buffer
+	opts := OutputOptions{Attrs: AttrColumns(events), AttrsStyle: attrsStyleJSON}
+	if err := EncodeCSV(&buf, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	// no attributes is an empty cell, not {}
+	expect := "# stopwatch-schema: 2\nseq,ts,what,attrs\n" +
+		"0,2022-04-08T20:00:00Z,enter,\n" +
+		`1,2022-04-08T20:00:01Z,tick,"{""arch"":""arm64"",""build"":""release""}"` + "\n" +
+		"2,2022-04-08T20:00:02Z,exit,\n"
+	if buf.String() != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, buf.String())
+	}
+	got, _, err := UnmarshalEventsCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, got) {
+		t.Errorf("Round trip mismatch:\nexpected: %v\ngot: %v", events, got)
+	}
+
+	// converting back to one column per attribute
+	buf.Reset()
+	if err := EncodeCSV(&buf, got, OutputOptions{Attrs: AttrColumns(got)}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "seq,ts,what,arch,build\n") {
+		t.Errorf("Expected attribute columns, got:\n%s", buf.String())
+	}
+
+	// without any attributes, the column is left out
+	buf.Reset()
+	if err := EncodeCSV(&buf, testEvents(time.Second), OutputOptions{AttrsStyle: attrsStyleJSON}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "# stopwatch-schema: 2\nseq,ts,what\n") {
+		t.Errorf("Expected no attrs column, got:\n%s", buf.String())
+	}
+
+	for _, cell := range []string{`{}`, `not json`, `{"a b":"c"}`, `{"seq":"1"}`, `{"a":1}`} {
+		input := "seq,ts,what,attrs\n0,2022-04-08T20:00:00Z,enter," + csvQuote(cell) + "\n"
+		events, _, err := UnmarshalEventsCSV(strings.NewReader(input))
+		if cell == `{}` {
+			if err != nil || events[0].Attrs != nil {
+				t.Errorf("Expected {} to decode to no attributes, got %v, %v", events, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Expected error for attrs cell %s", cell)
+		}
+	}
+}
+
+// csvQuote quotes s as a CSV field
+func csvQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// randomLabel returns a label mixing letters with the characters needing
+// quoting in CSV
+func randomLabel(r *rand.Rand) string {
+	const chars = "ab ,\"\n\r\tä'#="
+	runes := []rune(chars)
+	label := make([]rune, r.Intn(12))
+	for i := range label {
+		label[i] = runes[r.Intn(len(runes))]
+	}
+	return string(label)
+}
+
+func TestEventsFromRecordsRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		t0 := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+		var events []Event
+		for i := 0; i < r.Intn(10); i++ {
+			t0 = t0.Add(time.Duration(r.Int63n(int64(time.Hour))))
+			events = append(events, Event{Seq: int64(i), Timestamp: t0, What: randomLabel(r)})
+		}
+		got, err := EventsFromRecords(EventsToRecords(events))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(events, got) {
+			t.Fatalf("Round trip mismatch:\nexpected: %v\ngot: %v", events, got)
+		}
+	}
+
+	// the optional and attribute columns too
+	v := 2.5
+	events := testEvents(time.Second, time.Second)
+	events[1].Value, events[1].Flag = &v, flagShort
+	events[1].Attrs = map[string]string{"runner": "a,b"}
+	records := EventsToRecordsWith(events, append(EventColumnNames([]string{"value", "flag"}), "runner"))
+	if got, err := EventsFromRecords(records); err != nil || !reflect.DeepEqual(events, got) {
+		t.Errorf("Round trip mismatch: %v\nexpected: %v\ngot: %v", err, events, got)
+	}
+	if _, err := EventsFromRecordsWith(records, []string{"value"}); err == nil || !strings.Contains(err.Error(), `"flag"`) {
+		t.Errorf("Expected flag not to be allowed, got %v", err)
+	}
+	if _, err := EventsFromRecordsWith(records, []string{"value", "flag", "runner"}); err != nil {
+		t.Errorf("Expected the columns to be allowed, got %v", err)
+	}
+}
+
+func TestEventsFromRecordsErrors(t *testing.T) {
+	header := []string{"seq", "ts", "what"}
+	for _, tc := range []struct {
+		records [][]string
+		err     string
+	}{
+		{nil, "missing header"},
+		{[][]string{{"seq", "what"}}, `missing column "ts"`},
+		{[][]string{header, {"0", "2022-04-08T20:00:00Z", "enter"}, {"1", "2022-04-08T20:00:01Z"}}, "record 2: expected 3 fields, got 2"},
+		{[][]string{header, {"x", "2022-04-08T20:00:00Z", "enter"}}, "record 1: invalid seq"},
+		{[][]string{header, {"0", "yesterday", "enter"}}, "record 1: invalid ts"},
+	} {
+		if _, err := EventsFromRecords(tc.records); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error %q, got %v", tc.records, tc.err, err)
+		}
+	}
+}
+
+func TestParseTimestampEpoch(t *testing.T) {
+	for value, want := range map[string]time.Time{
+		"1649448000":                time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC),
+		"1649448000.25":             time.Date(2022, 4, 8, 20, 0, 0, 250000000, time.UTC),
+		"1649448000.000000001":      time.Date(2022, 4, 8, 20, 0, 0, 1, time.UTC),
+		"2022-04-08T23:00:00+03:00": time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC),
+	} {
+		if got, err := parseTimestamp(value); err != nil || !got.Equal(want) {
+			t.Errorf("%s: expected %v, got %v (%v)", value, want, got, err)
+		}
+	}
+	for _, value := range []string{"", ".5", "1e9", "-1", "1649448000.0000000001"} {
+		if _, err := parseTimestamp(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
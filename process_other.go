@@ -0,0 +1,36 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows || plan9
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// processAlive reports whether the process pid exists, as far as
+// os.FindProcess can tell
+func processAlive(pid int) (bool, error) {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false, nil
+	}
+	p.Release()
+	return true, nil
+}
+
+// detach does nothing: the processes started outlive their parent anyway
+func detach(cmd *exec.Cmd) {}
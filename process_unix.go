@@ -0,0 +1,41 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// processAlive reports whether the process pid exists. An error such as
+// EPERM means that the process exists, but belongs to someone else.
+func processAlive(pid int) (bool, error) {
+	switch err := syscall.Kill(pid, 0); err {
+	case nil:
+		return true, nil
+	case syscall.ESRCH:
+		return false, nil
+	default:
+		return true, err
+	}
+}
+
+// detach starts cmd in a session of its own, so that it is not stopped or
+// hung up with the terminal it was started from
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
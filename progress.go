@@ -0,0 +1,125 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+const (
+	progressFD      = 3           // the conventional extra file descriptor
+	progressBuffer  = 1024        // messages queued for a slow reader before dropping
+	progressTimeout = time.Second // waited at exit for a slow reader
+)
+
+// progressMessage is a line of the progress stream
+type progressMessage struct {
+	Type    string          `json:"type"` // start, event, written or end
+	Session string          `json:"session,omitempty"`
+	TS      string          `json:"ts,omitempty"`
+	Event   json.RawMessage `json:"event,omitempty"`
+	Path    string          `json:"path,omitempty"` // the output, "-" for stdout
+	Ticks   *int            `json:"ticks,omitempty"`
+	Laps    *int            `json:"laps,omitempty"`
+	Total   *float64        `json:"total,omitempty"` // seconds
+	Error   string          `json:"error,omitempty"`
+	Dropped int             `json:"dropped,omitempty"` // messages dropped before this one
+}
+
+// progressStream writes the progress of the session as JSON lines, for a
+// program running stopwatch: the start, each event, the output written
+// and the end. It is an eventSink. The lines are written in the
+// background, so that a reader not keeping up can not stall the
+// recording: when the queue is full, messages are dropped, and the next
+// message written tells how many.
+type progressStream struct {
+	session string
+	columns []string // optional columns included in the events
+	lines   chan []byte
+	dropped int // since the last message queued
+	stats   Stats
+	done    chan struct{}
+}
+
+func newProgressStream(w io.Writer, session string, columns []string) *progressStream {
+	p := &progressStream{session: session, columns: columns,
+		lines: make(chan []byte, progressBuffer), done: make(chan struct{})}
+	go p.write(w)
+	return p
+}
+
+func (p *progressStream) write(w io.Writer) {
+	defer close(p.done)
+	failed := false
+	for line := range p.lines {
+		// a failed reader is not retried; the queue is still drained
+		if !failed {
+			_, err := w.Write(line)
+			failed = err != nil
+		}
+	}
+}
+
+func (p *progressStream) send(msg progressMessage) {
+	msg.Dropped = p.dropped
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case p.lines <- append(line, '\n'):
+		p.dropped = 0
+	default:
+		p.dropped++
+	}
+}
+
+// start announces the start of the session
+func (p *progressStream) start(now time.Time) {
+	p.send(progressMessage{Type: "start", Session: p.session, TS: now.Format(time.RFC3339Nano)})
+}
+
+func (p *progressStream) Send(evt Event) {
+	event := marshalEventJSON(evt, EventColumnNames(dataColumns([]Event{evt}, p.columns)), OutputOptions{})
+	p.send(progressMessage{Type: "event", Event: event})
+}
+
+// Close keeps the statistics for end, as the output is written after it
+func (p *progressStream) Close(s Stats) {
+	p.stats = s
+}
+
+// written announces that the output has been written into path
+func (p *progressStream) written(path string) {
+	p.send(progressMessage{Type: "written", Path: path})
+}
+
+// end announces the end of the session, with the error writing the output
+// into path if it failed, and waits a while for the reader to catch up
+func (p *progressStream) end(path string, err error) {
+	ticks, laps, total := p.stats.Ticks, len(p.stats.Laps), p.stats.Total.Seconds()
+	msg := progressMessage{Type: "end", Session: p.session, Path: path, Ticks: &ticks, Laps: &laps, Total: &total}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	p.send(msg)
+	close(p.lines)
+	select {
+	case <-p.done:
+	case <-time.After(progressTimeout):
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressStream(t *testing.T) {
+	r, w := io.Pipe()
+	p := newProgressStream(w, "S1", nil)
+	lines := make(chan progressMessage, 10)
+	go func() {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			var msg progressMessage
+			if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+				t.Errorf("Invalid line %q: %v", sc.Text(), err)
+			}
+			lines <- msg
+		}
+		close(lines)
+	}()
+	events := testEvents(seconds(1, 2)...)
+	p.start(events[0].Timestamp)
+	for _, evt := range events {
+		p.Send(evt)
+	}
+	p.Close(ComputeStats(events))
+	p.written("run.csv")
+	p.end("run.csv", nil)
+	w.Close()
+
+	var types []string
+	var last progressMessage
+	for msg := range lines {
+		types = append(types, msg.Type)
+		last = msg
+	}
+	if want := "start event event event written end"; strings.Join(types, " ") != want {
+		t.Errorf("Expected %q, got %q", want, types)
+	}
+	if last.Path != "run.csv" || last.Session != "S1" || *last.Ticks != 1 || *last.Laps != 1 {
+		t.Errorf("Unexpected end %+v", last)
+	}
+}
+
+func TestProgressStreamStalled(t *testing.T) {
+	r, w := io.Pipe() // never read
+	defer r.Close()
+	p := newProgressStream(w, "S1", nil)
+	start := time.Now()
+	for i := 0; i < 2*progressBuffer; i++ {
+		p.Send(Event{Seq: int64(i), What: labelTick})
+	}
+	if p.dropped == 0 {
+		t.Error("Expected messages to be dropped")
+	}
+	p.end("", nil)
+	if elapsed := time.Since(start); elapsed > progressTimeout+time.Second {
+		t.Errorf("Expected a stalled reader not to block, took %v", elapsed)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func init() {
+	sinkKinds["fd3"] = "progress as JSON lines on file descriptor 3"
+}
+
+// openProgressFD returns the file descriptor of the progress stream if it
+// was open at startup, or nil. Must be called before any file is opened.
+func openProgressFD() *os.File {
+	var st syscall.Stat_t
+	if syscall.Fstat(progressFD, &st) != nil {
+		return nil
+	}
+	return inheritedFile(progressFD, "progress")
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultPrompt is the template of the prompt without -prompt
+const defaultPrompt = "# {{.State}}Waiting for [{{.Next}}]> "
+
+// defaultPromptTemplate is defaultPrompt parsed
+var defaultPromptTemplate = template.Must(ParsePrompt(defaultPrompt))
+
+// PromptState is what the template of the prompt is executed with, see
+// also its methods
+type PromptState struct {
+	Count     int           // laps recorded so far
+	LastLap   time.Duration // the last lap, zero before the first one
+	LastLabel string        // of the event closing the last lap
+	Next      string        // the number of the next tick, or its label, see Session.nextLabel
+	Phase     string        // of -cycle, the current phase; "" without a cycle
+	NextPhase string        // of -cycle, the phase after the current one
+	Paused    bool          // the clock is paused
+	Budget    string        // the label being timed, if it has a -budget
+	Left      time.Duration // of that -budget, the time left; negative once overrun
+	State     string        // the group, paused, timers, trial, phase and budget, as in the default prompt
+
+	start time.Time
+	now   func() time.Time
+}
+
+// Elapsed returns the time since the start of the session, in whole
+// seconds. The clock is only read by the templates that show it.
+func (p PromptState) Elapsed() time.Duration {
+	if p.now == nil {
+		return 0
+	}
+	return p.now().Sub(p.start).Truncate(time.Second)
+}
+
+// ParsePrompt parses the template of -prompt. It is executed once with an
+// empty PromptState, so that a field that does not exist is an error at
+// startup rather than at every prompt.
+func ParsePrompt(text string) (*template.Template, error) {
+	tmpl, err := template.New("prompt").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(new(strings.Builder), PromptState{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// promptState returns the state of s shown by the prompt
+func (s *Session) promptState() PromptState {
+	p := PromptState{Next: s.nextLabel(), Paused: s.paused, start: s.startAt, now: s.now}
+	forEachLap(s.Events, func(evt Event, lap time.Duration) {
+		p.Count++
+		p.LastLap, p.LastLabel = lap, evt.What
+	})
+	if s.group > 0 {
+		name := s.groupName
+		if name == "" {
+			name = fmt.Sprintf("group %d", s.group)
+		}
+		p.State = "[" + name + "] "
+	}
+	if s.paused {
+		p.State += "[PAUSED] "
+	}
+	if len(s.timers) > 0 {
+		p.State += "(" + strings.Join(s.timers, ", ") + ") "
+	}
+	if s.reacting() && s.opts.Reaction.Trials > 0 {
+		p.State += fmt.Sprintf("[trial %d/%d] ", s.trials+1, s.opts.Reaction.Trials)
+	}
+	if phase, left, ok := s.currentPhase(); ok {
+		p.State += fmt.Sprintf("[%s %s left] ", phase.Name, formatDuration(left.Round(time.Second)))
+		p.Phase, p.NextPhase = phase.Name, s.opts.Cycle[(s.phase+1)%len(s.opts.Cycle)].Name
+	}
+	if label, left, ok := s.activeBudget(); ok {
+		p.State += fmt.Sprintf("[%s %s] ", label, budgetBalance(0, left.Round(time.Second)))
+		p.Budget, p.Left = label, left
+	}
+	return p
+}
+
+// prompt returns the prompt shown while waiting for the next event, made
+// by the -prompt template, or else defaultPrompt
+func (s *Session) prompt() string {
+	if s.armed {
+		return "# Armed — press enter to start> "
+	}
+	if s.confirm != nil {
+		return s.confirmPrompt
+	}
+	tmpl := s.opts.Prompt
+	if tmpl == nil {
+		tmpl = defaultPromptTemplate
+	}
+	var b strings.Builder
+	state := s.promptState()
+	if err := tmpl.Execute(&b, state); err != nil {
+		b.Reset()
+		defaultPromptTemplate.Execute(&b, state)
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSessionPromptTemplate(t *testing.T) {
+	tmpl, err := ParsePrompt("{{.Count}} laps, {{.Elapsed}} elapsed ({{.LastLabel}} {{duration .LastLap}}){{if .Paused}} paused{{end}}" +
+		"{{with .Phase}} {{.}} then {{$.NextPhase}}{{end}} > ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	now := t0
+	cycle := []cyclePhase{{"work", 25 * time.Minute}, {"rest", 5 * time.Minute}}
+	sess := newSession("", collectOptions{Prompt: tmpl, Cycle: cycle, Until: t0.Add(time.Hour), UntilBar: true, UntilBarWidth: 80})
+	sess.now = func() time.Time { return now }
+	var out bytes.Buffer
+	sess.start()
+	if got := sess.prompt(); got != "0 laps, 0s elapsed ( 0s) work then rest > " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	now = now.Add(90 * time.Second)
+	sess.handleLine("warm up", &out)
+	now = now.Add(1500 * time.Millisecond)
+	sess.handleLine("pause", &out)
+	if got := sess.prompt(); got != "1 laps, 1m31s elapsed (warm up 1m30s) paused work then rest > " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	// the progress bar is drawn in front of the same prompt
+	out.Reset()
+	sess.drawPrompt(&out, false)
+	if want := "\r\x1b[K[..........]   2% 1 laps, 1m31s elapsed (warm up 1m30s) paused work then rest > "; out.String() != want {
+		t.Errorf("Expected the bar with the prompt, got %q", out.String())
+	}
+
+	for _, text := range []string{"{{.Count", "{{.Nope}}", "{{.LastLap.Nope}}"} {
+		if _, err := ParsePrompt(text); err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+}
+
+func TestDefaultPrompt(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	sess.start()
+	if got := sess.prompt(); got != "# Waiting for [1]> " {
+		t.Errorf("Unexpected default prompt: %q", got)
+	}
+	sess.handleLine("pause", new(bytes.Buffer))
+	if got := sess.prompt(); got != "# [PAUSED] Waiting for [2]> " {
+		t.Errorf("Unexpected default prompt: %q", got)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Labels of the -reaction events
+const (
+	labelGo         = "GO"          // recorded when GO is shown; like "reset", starts a lap but does not close one
+	labelFalseStart = "false-start" // a tick before GO; like marks, does not start or close laps
+)
+
+// isGo reports whether label is a -reaction GO event
+func isGo(label string) bool {
+	return label == labelGo
+}
+
+// reactionOptions controls the -reaction trials
+type reactionOptions struct {
+	MinDelay, MaxDelay time.Duration // range of the random delay before GO
+	Trials             int           // number of trials, after which the session ends; 0 for no limit
+	Rand               *rand.Rand    // source of the delays, seeded with -reaction-seed
+}
+
+// parseDelayRange parses a -reaction-delay range such as "1s-4s", or a
+// single duration for a fixed delay
+func parseDelayRange(s string) (time.Duration, time.Duration, error) {
+	lo, hi, ranged := strings.Cut(s, "-")
+	min, err := time.ParseDuration(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, err
+	}
+	max := min
+	if ranged {
+		if max, err = time.ParseDuration(strings.TrimSpace(hi)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if min <= 0 || max < min {
+		return 0, 0, fmt.Errorf("expected a positive range such as 1s-4s, got %q", s)
+	}
+	return min, max, nil
+}
+
+// reacting reports whether the session measures reaction times
+func (s *Session) reacting() bool {
+	return s.opts.Reaction != nil
+}
+
+// scheduleGo picks the random moment of the next GO, counted from now
+func (s *Session) scheduleGo() {
+	r := s.opts.Reaction
+	delay := r.MinDelay
+	if span := r.MaxDelay - r.MinDelay; span > 0 {
+		delay += time.Duration(r.Rand.Int63n(int64(span) + 1))
+	}
+	s.goAt, s.goShown = s.now().Add(delay), time.Time{}
+}
+
+// nextGo returns the time until the next GO is due, if one is
+func (s *Session) nextGo() (time.Duration, bool) {
+	if !s.reacting() || s.goAt.IsZero() || s.paused {
+		return 0, false
+	}
+	wait := s.goAt.Sub(s.now())
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// checkGo shows GO and records a "go" event once the delay is over, and
+// reports whether it did. The event gets the moment GO was shown, rather
+// than the scheduled one, as that is what the reaction is measured from.
+func (s *Session) checkGo(out io.Writer) bool {
+	if _, due := s.nextGo(); !due || s.now().Before(s.goAt) {
+		return false
+	}
+	msg := "\n#\n#   >>>>>>>>>>  GO  <<<<<<<<<<\n#"
+	fmt.Fprintln(out, bell(s.opts.Bell)+colorize(msg, ansiBold+";"+ansiGreen, s.opts.Color))
+	s.goShown, s.goAt = s.now(), time.Time{}
+	s.recordEvent(Event{Timestamp: s.goShown, What: labelGo})
+	return true
+}
+
+// reactionTick turns evt, a tick about to be recorded at now, into a
+// reaction: its value is the time since GO. A tick before GO is recorded as
+// a false start instead, and the delay starts over. Reports whether evt is
+// to be recorded as a tick.
+func (s *Session) reactionTick(evt *Event, now time.Time, out io.Writer) bool {
+	if s.goShown.IsZero() {
+		s.recordEvent(Event{Timestamp: now, What: labelFalseStart, Attrs: evt.Attrs})
+		fmt.Fprintln(out, colorize("# False start! Wait for GO", ansiRed, s.opts.Color))
+		s.scheduleGo()
+		return false
+	}
+	reaction := now.Sub(s.goShown)
+	secs := reaction.Seconds()
+	evt.Value = &secs
+	s.goShown = time.Time{}
+	s.trials++
+	fmt.Fprintf(out, "# Reaction: %s\n", formatDuration(reaction))
+	if !s.trialsDone() {
+		s.scheduleGo()
+	}
+	return true
+}
+
+// trialsDone reports whether all the -reaction trials have been made
+func (s *Session) trialsDone() bool {
+	return s.reacting() && s.opts.Reaction.Trials > 0 && s.trials >= s.opts.Reaction.Trials
+}
+
+// ReactionStats summarizes the reaction times of a -reaction session
+type ReactionStats struct {
+	Times       []time.Duration // from each GO to the tick following it
+	FalseStarts int
+	Mean, Best  time.Duration
+}
+
+// ComputeReactionStats returns the reaction times in events: the time from
+// each "go" event to the next tick. False starts are counted, but do not
+// affect the times.
+func ComputeReactionStats(events []Event) ReactionStats {
+	var r ReactionStats
+	var goAt time.Time
+	for _, evt := range events {
+		switch {
+		case isGo(evt.What):
+			goAt = evt.Timestamp
+		case evt.What == labelFalseStart:
+			r.FalseStarts++
+		case !goAt.IsZero() && !isReserved(evt.What):
+			r.Times = append(r.Times, evt.Timestamp.Sub(goAt))
+			goAt = time.Time{}
+		}
+	}
+	var sum time.Duration
+	for i, d := range r.Times {
+		sum += d
+		if i == 0 || d < r.Best {
+			r.Best = d
+		}
+	}
+	if len(r.Times) > 0 {
+		r.Mean = sum / time.Duration(len(r.Times))
+	}
+	return r
+}
+
+// writeReaction writes the reaction statistics into out, each line
+// prefixed with prefix; nothing if there were no GO events
+func writeReaction(out io.Writer, prefix string, r ReactionStats) error {
+	if len(r.Times) == 0 && r.FalseStarts == 0 {
+		return nil
+	}
+	line := fmt.Sprintf("%sReaction: %d trials", prefix, len(r.Times))
+	if len(r.Times) > 0 {
+		line += fmt.Sprintf(", mean: %s, best: %s", formatDuration(r.Mean), formatDuration(r.Best))
+	}
+	_, err := fmt.Fprintf(out, "%s, false starts: %d\n", line, r.FalseStarts)
+	return err
+}
+
+// WriteReactionSummary writes the reaction statistics of events into out,
+// with the "# " prefix like WriteSummary
+func WriteReactionSummary(out io.Writer, events []Event) error {
+	return writeReaction(out, "# ", ComputeReactionStats(events))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDelayRange(t *testing.T) {
+	for value, want := range map[string][2]time.Duration{
+		"1s-4s": {time.Second, 4 * time.Second}, "500ms - 1s": {500 * time.Millisecond, time.Second},
+		"2s": {2 * time.Second, 2 * time.Second},
+	} {
+		min, max, err := parseDelayRange(value)
+		if err != nil || [2]time.Duration{min, max} != want {
+			t.Errorf("%q: expected %v, got %v %v, %v", value, want, min, max, err)
+		}
+	}
+	for _, value := range []string{"", "4s-1s", "0s", "-1s", "1s-", "1s-4s-5s", "x"} {
+		if _, _, err := parseDelayRange(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestSessionReaction(t *testing.T) {
+	start := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	now := start
+	sess := newSession("", collectOptions{Reaction: &reactionOptions{
+		MinDelay: 2 * time.Second, MaxDelay: 2 * time.Second, Trials: 2, Rand: rand.New(rand.NewSource(1)),
+	}})
+	sess.now = func() time.Time { return now }
+	at := func(d time.Duration) { now = start.Add(d) }
+	var out bytes.Buffer
+	sess.start()
+	if wait, ok := sess.nextTimer(); !ok || wait != 2*time.Second {
+		t.Errorf("Expected GO in 2s, got %v %v", wait, ok)
+	}
+	at(time.Second)
+	sess.handleLine("", &out) // false start, the delay starts over
+	at(2 * time.Second)
+	sess.checkTimers(&out)
+	at(3 * time.Second)
+	sess.checkTimers(&out) // GO
+	at(3300 * time.Millisecond)
+	sess.handleLine("", &out)
+	if sess.trialsDone() {
+		t.Error("Expected a trial to be left")
+	}
+	if got := sess.prompt(); got != "# [trial 2/2] Waiting for [4]> " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	at(5300 * time.Millisecond)
+	sess.checkTimers(&out) // GO
+	at(8 * time.Second)
+	sess.handleLine("", &out)
+	if !sess.trialsDone() {
+		t.Error("Expected all trials done")
+	}
+	sess.finish()
+
+	var got []string
+	for _, evt := range sess.Events {
+		s := evt.Timestamp.Sub(sess.Events[0].Timestamp).String() + " " + evt.What
+		if evt.Value != nil {
+			s += " " + formatValue(*evt.Value)
+		}
+		got = append(got, s)
+	}
+	want := []string{"0s enter", "1s false-start", "3s GO", "3.3s tick 0.3", "5.3s GO", "8s tick 2.7", "8s exit"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, got)
+	}
+	for _, msg := range []string{"GO", "# False start! Wait for GO\n", "# Reaction: 300ms\n", "# Reaction: 2.7s\n"} {
+		if !strings.Contains(out.String(), msg) {
+			t.Errorf("Expected %q in the output, got %q", msg, out.String())
+		}
+	}
+
+	// the laps are the reaction times
+	if laps := LapDurations(sess.Events); !reflect.DeepEqual(laps, []time.Duration{300 * time.Millisecond, 2700 * time.Millisecond}) {
+		t.Errorf("Expected the reaction times as laps, got %v", laps)
+	}
+	r := ComputeReactionStats(sess.Events)
+	if r.FalseStarts != 1 || r.Best != 300*time.Millisecond || r.Mean != 1500*time.Millisecond {
+		t.Errorf("Unexpected stats %+v", r)
+	}
+	out.Reset()
+	WriteReactionSummary(&out, sess.Events)
+	if want := "# Reaction: 2 trials, mean: 1.5s, best: 300ms, false starts: 1\n"; out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+	out.Reset()
+	if err := WriteReport(&out, sess.Events, "", ReportOptions{}); err != nil || !strings.Contains(out.String(), "\nReaction: 2 trials, mean: 1.5s, best: 300ms, false starts: 1\n") {
+		t.Errorf("Expected the reaction times in the report, got %q, %v", out.String(), err)
+	}
+	out.Reset()
+	WriteReactionSummary(&out, testEvents(time.Second))
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing without -reaction, got %q", out.String())
+	}
+}
+
+func TestScheduleGo(t *testing.T) {
+	delays := func(seed int64) []time.Duration {
+		sess := newSession("", collectOptions{Reaction: &reactionOptions{
+			MinDelay: time.Second, MaxDelay: 4 * time.Second, Rand: rand.New(rand.NewSource(seed)),
+		}})
+		now := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+		sess.now = func() time.Time { return now }
+		var got []time.Duration
+		for i := 0; i < 20; i++ {
+			sess.scheduleGo()
+			got = append(got, sess.goAt.Sub(now))
+		}
+		return got
+	}
+	a := delays(42)
+	for _, d := range a {
+		if d < time.Second || d > 4*time.Second {
+			t.Errorf("Delay %v out of range", d)
+		}
+	}
+	if b := delays(42); !reflect.DeepEqual(a, b) {
+		t.Errorf("Expected the same delays from the same seed, got %v and %v", a, b)
+	}
+	if c := delays(43); reflect.DeepEqual(a, c) {
+		t.Errorf("Expected other delays from another seed, got %v", c)
+	}
+}
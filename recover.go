@@ -0,0 +1,208 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func runRecover(args []string) int {
+	fs := newFlagSet("recover", "[<session>]")
+	outFile := fs.String("o", "", "Output file path, stderr, fd:N or an http(s):// URL to upload to (default: stdout)")
+	outFlags := addOutputFlags(fs, "format")
+	keep := fs.Bool("keep", false, "Keep the checkpoint after writing the output, instead of removing it")
+	force := fs.Bool("force", false, "Recover the session even if it seems to be still running")
+	completeFiles(fs, "o")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	opts, err := outFlags.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	if (opts.RotateEvents > 0 || opts.RotateSize > 0 || opts.SplitByLabel || opts.Manifest) && (isStream(*outFile) || isUpload(*outFile)) {
+		fmt.Fprintln(os.Stderr, "ERROR: -rotate-every, -rotate-size, -split-by-label and -manifest require an output file (-o),\n"+
+			"not a stream or a URL")
+		return 2
+	}
+	if opts, err = withCompression(opts, *outFile); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	dir, err := checkpointDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: no checkpoint directory:", err)
+		return 1
+	}
+	files, err := listCheckpoints(dir, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	if fs.NArg() == 0 {
+		if len(files) == 0 {
+			fmt.Fprintf(os.Stderr, "# No checkpoints in %s\n", dir)
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "# Checkpoints in %s:\n", dir)
+		if err := writeCheckpointList(os.Stdout, files); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			return 1
+		}
+		return 0
+	}
+
+	f, err := findCheckpoint(files, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	if f.running() && !*force {
+		fmt.Fprintf(os.Stderr, "ERROR: session %s is still running as pid %d; use -force to recover it anyway\n", f.Session, f.PID)
+		return 1
+	}
+	events, err := f.events()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: problem reading %s: %v\n", f.path, err)
+		return 1
+	}
+	if !flagWasSet(fs, "name") {
+		opts.Name = f.Name
+	}
+	opts.Comment, opts.Meta, opts.Session = f.Comment, f.Meta, f.Session
+	if len(events) > 0 && events[len(events)-1].What != labelExit {
+		// as if the session had ended at the last event recorded
+		events = append(events, Event{Seq: events[len(events)-1].Seq + 1, Timestamp: events[len(events)-1].Timestamp,
+			What: labelExit, Zone: events[len(events)-1].Zone, Group: events[len(events)-1].Group})
+	}
+	if err := DumpEvents(*outFile, events, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem writing output:", err)
+		return 1
+	}
+	if !*keep {
+		if err := os.Remove(f.path); err != nil {
+			fmt.Fprintln(os.Stderr, "# WARNING: could not remove the checkpoint:", err)
+		}
+	}
+	return 0
+}
+
+// checkpointFile is a checkpoint file found in the checkpoint directory
+type checkpointFile struct {
+	path    string
+	updated time.Time
+	checkpoint
+}
+
+// listCheckpoints returns the checkpoint files in dir, the oldest first.
+// Files that can not be read are reported into stderr, and skipped.
+func listCheckpoints(dir string, stderr io.Writer) ([]checkpointFile, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+checkpointSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var files []checkpointFile
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue // removed by its session meanwhile
+		}
+		c, err := readCheckpoint(path)
+		if err != nil {
+			fmt.Fprintln(stderr, "# WARNING: skipped", err)
+			continue
+		}
+		files = append(files, checkpointFile{path: path, updated: fi.ModTime(), checkpoint: c})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].updated.Before(files[j].updated) })
+	return files, nil
+}
+
+// running reports whether the session of the checkpoint is still recording
+func (f checkpointFile) running() bool {
+	alive, _ := processAlive(f.PID)
+	return alive && f.PID != os.Getpid()
+}
+
+// findCheckpoint returns the checkpoint of files whose session ID starts
+// with prefix
+func findCheckpoint(files []checkpointFile, prefix string) (checkpointFile, error) {
+	var found []checkpointFile
+	for _, f := range files {
+		if strings.HasPrefix(f.Session, strings.ToUpper(prefix)) {
+			found = append(found, f)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return checkpointFile{}, fmt.Errorf("no checkpoint of session %q", prefix)
+	case 1:
+		return found[0], nil
+	}
+	return checkpointFile{}, fmt.Errorf("%q matches %d sessions, give more of the ID", prefix, len(found))
+}
+
+// writeCheckpointList writes a table of the checkpoint files into out
+func writeCheckpointList(out io.Writer, files []checkpointFile) error {
+	rows := [][]string{{"session", "updated", "events", "state", "comment"}}
+	for _, f := range files {
+		state := "orphaned"
+		if f.running() {
+			state = fmt.Sprintf("running (pid %d)", f.PID)
+		}
+		events := len(f.Records) - 1
+		if events < 0 {
+			events = 0
+		}
+		comment := f.Comment
+		if f.Name != "" {
+			comment = strings.TrimSpace(f.Name + ": " + comment)
+		}
+		rows = append(rows, []string{f.Session, f.updated.Format("2006-01-02 15:04:05"),
+			fmt.Sprint(events), state, sanitizeLabel(comment)})
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := displayWidth(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			if i > 0 {
+				line.WriteString("  ")
+			}
+			line.WriteString(cell + strings.Repeat(" ", widths[i]-displayWidth(cell)))
+		}
+		if _, err := fmt.Fprintln(out, strings.TrimRight(line.String(), " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
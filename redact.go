@@ -0,0 +1,163 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The policies of -redact, see redactor
+const (
+	redactHash = "hash" // each distinct text becomes a token, the same within the session
+	redactDrop = "drop" // the texts are left empty
+)
+
+// redactTokenLen is the number of hex digits in the tokens of -redact hash
+const redactTokenLen = 12
+
+// redactedPrefixes start the labels of the stopwatch's own events with a
+// name given by the user, such as that of a mark; only the name is redacted
+var redactedPrefixes = []string{labelMarkPrefix, labelStartPrefix, labelStopPrefix, labelReset + ":", labelPhasePrefix,
+	labelFilePrefix, labelBudgetPrefix, labelPIDExitPrefix}
+
+// redactor replaces the texts given by the user in the events written:
+// labels, the names of marks (and so of the notes of -control), timers,
+// lap groups and -cycle phases, and the values of attributes. The labels
+// and attributes of the stopwatch's own events, such as the sentinels, are
+// left as they are. The hash tokens are an HMAC of the text keyed with a
+// salt drawn for the session, so that the same text gets the same token
+// within the session, but the tokens of other sessions can not be compared
+// nor a list of likely labels hashed to reverse them.
+type redactor struct {
+	policy  string
+	salt    []byte
+	MapFile string // where the tokens and their texts are written, see writeMap; "" for nowhere
+
+	mu     sync.Mutex
+	tokens map[string]string // the token of each text redacted
+}
+
+// newRedactor returns a redactor with the policy of -redact, and a new
+// salt for the hash tokens
+func newRedactor(policy, mapFile string) (*redactor, error) {
+	switch policy {
+	case redactHash:
+	case redactDrop:
+		if mapFile != "" {
+			return nil, fmt.Errorf("-redact-map requires -redact %s", redactHash)
+		}
+	default:
+		return nil, fmt.Errorf("unknown -redact %q (available: %s, %s)", policy, redactHash, redactDrop)
+	}
+	r := &redactor{policy: policy, MapFile: mapFile, tokens: make(map[string]string)}
+	if policy == redactHash {
+		r.salt = make([]byte, 16)
+		if _, err := rand.Read(r.salt); err != nil {
+			return nil, fmt.Errorf("could not generate the salt: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// text returns the redacted text; empty texts stay empty
+func (r *redactor) text(text string) string {
+	if text == "" || r.policy == redactDrop {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if token, ok := r.tokens[text]; ok {
+		return token
+	}
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(text))
+	token := hex.EncodeToString(mac.Sum(nil))[:redactTokenLen]
+	r.tokens[text] = token
+	return token
+}
+
+// label returns label redacted, see redactor
+func (r *redactor) label(label string) string {
+	for _, prefix := range redactedPrefixes {
+		if name := strings.TrimPrefix(label, prefix); name != label {
+			return prefix + r.text(name)
+		}
+	}
+	if isReserved(label) || label == labelTick {
+		return label
+	}
+	return r.text(label)
+}
+
+// event returns evt redacted, see redactor
+func (r *redactor) event(evt Event) Event {
+	evt.What, evt.Phase = r.label(evt.What), r.text(evt.Phase)
+	if len(evt.Attrs) > 0 {
+		attrs := make(map[string]string, len(evt.Attrs))
+		for k, v := range evt.Attrs {
+			if k != attrSuppressed && k != attrExcluded {
+				v = r.text(v)
+			}
+			attrs[k] = v
+		}
+		evt.Attrs = attrs
+	}
+	return evt
+}
+
+// events returns a redacted copy of events
+func (r *redactor) events(events []Event) []Event {
+	redacted := make([]Event, len(events))
+	for i, evt := range events {
+		redacted[i] = r.event(evt)
+	}
+	return redacted
+}
+
+// writeMap writes the tokens given so far and their texts into MapFile, as
+// CSV sorted by the text; like the checkpoint, the file is readable by the
+// user only. Nothing is written without a MapFile.
+func (r *redactor) writeMap() error {
+	if r.MapFile == "" {
+		return nil
+	}
+	r.mu.Lock()
+	texts := make([]string, 0, len(r.tokens))
+	for text := range r.tokens {
+		texts = append(texts, text)
+	}
+	sort.Strings(texts)
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"token", "text"})
+	for _, text := range texts {
+		w.Write([]string{r.tokens[text], text})
+	}
+	r.mu.Unlock()
+	w.Flush()
+	if err := writeFileAtomic(r.MapFile, buf.Bytes()); err != nil {
+		return fmt.Errorf("could not write -redact-map: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactor(t *testing.T) {
+	events := testEvents(time.Second, time.Second, time.Second, time.Second, time.Second)
+	events[1].What, events[1].Attrs = "customer call", map[string]string{"who": "alice", attrSuppressed: "3"}
+	events[2].What, events[2].Phase = labelMarkPrefix+"customer call", "focus"
+	events[3].What = labelPause
+	events[4].What = "customer call"
+	r, err := newRedactor(redactHash, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := r.events(events)
+	token := got[1].What
+	if len(token) != redactTokenLen || strings.Contains(token, "customer") {
+		t.Fatalf("Expected a token, got %q", token)
+	}
+	if got[4].What != token || got[2].What != labelMarkPrefix+token {
+		t.Errorf("Expected the same token for the same text, got %v", got)
+	}
+	if got[0].What != labelEnter || got[3].What != labelPause || got[5].What != labelExit {
+		t.Errorf("Expected the stopwatch's own labels as they were, got %v", got)
+	}
+	if got[1].Attrs["who"] == "alice" || got[1].Attrs[attrSuppressed] != "3" || got[2].Phase == "focus" {
+		t.Errorf("Expected the attribute values and phases redacted, got %v", got)
+	}
+	if events[1].What != "customer call" || events[1].Attrs["who"] != "alice" {
+		t.Error("Expected the recorded events to be left as they were")
+	}
+	// salted per session
+	other, _ := newRedactor(redactHash, "")
+	if other.label("customer call") == token {
+		t.Error("Expected another token in another session")
+	}
+
+	drop, _ := newRedactor(redactDrop, "")
+	got = drop.events(events)
+	if got[1].What != "" || got[2].What != labelMarkPrefix || got[1].Attrs["who"] != "" || got[3].What != labelPause {
+		t.Errorf("Expected the texts left empty, got %v", got)
+	}
+
+	for _, test := range [][2]string{{"md5", ""}, {redactDrop, "map.csv"}} {
+		if _, err := newRedactor(test[0], test[1]); err == nil {
+			t.Errorf("%q: expected an error", test)
+		}
+	}
+}
+
+func TestRedactMap(t *testing.T) {
+	dir := t.TempDir()
+	mapFile := filepath.Join(dir, "map.csv")
+	r, err := newRedactor(redactHash, mapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := testEvents(time.Second)
+	events[1].What = "b, quoted"
+	path := filepath.Join(dir, "out.csv")
+	if err := DumpEvents(path, events, OutputOptions{Redact: r}); err != nil {
+		t.Fatal(err)
+	}
+	token := r.label("b, quoted")
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "quoted") || !strings.Contains(string(b), ","+token+"\n") {
+		t.Errorf("Expected the label redacted, got:\n%s", b)
+	}
+	want := "token,text\n" + token + `,"b, quoted"` + "\n"
+	if b, err := os.ReadFile(mapFile); err != nil || string(b) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s%v", want, b, err)
+	}
+	if fi, err := os.Stat(mapFile); runtime.GOOS != "windows" && (err != nil || fi.Mode().Perm() != 0o600) {
+		t.Errorf("Expected the map readable by the user only, got %v, %v", fi.Mode(), err)
+	}
+}
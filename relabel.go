@@ -0,0 +1,244 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// relabeler rewrites the labels of the events, see the relabel command and
+// subcommand
+type relabeler struct {
+	old, new string
+	re       *regexp.Regexp // with -regex, old compiled; new may refer to its groups as $1 or ${name}
+	force    bool           // also relabel the events that would change what they are, see protectedRelabel
+}
+
+// newRelabeler returns the relabeler of old to new. Without regex, a label
+// matches if it is old as a whole.
+func newRelabeler(old, new string, regex, force bool) (*relabeler, error) {
+	if old == "" {
+		return nil, fmt.Errorf("the label to replace is empty")
+	}
+	r := &relabeler{old: old, new: new, force: force}
+	if regex {
+		re, err := regexp.Compile(old)
+		if err != nil {
+			return nil, err
+		}
+		r.re = re
+	} else if new == "" {
+		return nil, fmt.Errorf("the new label is empty")
+	}
+	return r, nil
+}
+
+// label returns the new label of label, and whether it matched
+func (r *relabeler) label(label string) (string, bool) {
+	if r.re == nil {
+		return r.new, label == r.old
+	}
+	if !r.re.MatchString(label) {
+		return label, false
+	}
+	return r.re.ReplaceAllString(label, r.new), true
+}
+
+// apply relabels events in place, and returns the number of events changed
+// and of those matching but left as they were: the ones protected without
+// force, and the ones that would get an empty label
+func (r *relabeler) apply(events []Event) (changed, kept int) {
+	for i := range events {
+		label, ok := r.label(events[i].What)
+		switch {
+		case !ok || label == events[i].What:
+		case label == "" || (!r.force && protectedRelabel(events[i].What, label)):
+			kept++
+		default:
+			events[i].What = label
+			changed++
+		}
+	}
+	return changed, kept
+}
+
+// protectedRelabel reports whether relabeling an event from old to new
+// would change what it is: a sentinel, or another event recorded by the
+// stopwatch itself, into a tick or the other way around. The name of a
+// mark, a timer or a phase can be changed, as long as the prefix stays.
+func protectedRelabel(old, new string) bool {
+	if isSentinel(old) || isSentinel(new) {
+		return true
+	}
+	if !isReserved(old) && !isReserved(new) {
+		return false
+	}
+	for _, prefix := range redactedPrefixes {
+		if strings.HasPrefix(old, prefix) && strings.HasPrefix(new, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// relabelSummary describes the outcome of apply
+func relabelSummary(changed, kept int) string {
+	msg := fmt.Sprintf("Relabeled %d events", changed)
+	if kept > 0 {
+		msg += fmt.Sprintf("; %d left as they were: the stopwatch's own events (see -force), or an empty label", kept)
+	}
+	return msg
+}
+
+// quotedFields splits text into words at spaces, a word in double quotes
+// being unquoted like a Go string, so that it may contain spaces
+func quotedFields(text string) ([]string, error) {
+	var words []string
+	for text = strings.TrimSpace(text); text != ""; text = strings.TrimLeft(text, " \t") {
+		if text[0] != '"' {
+			i := strings.IndexAny(text, " \t")
+			if i < 0 {
+				i = len(text)
+			}
+			words, text = append(words, text[:i]), text[i:]
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted word %s", text)
+		}
+		word, _ := strconv.Unquote(quoted)
+		if text = text[len(quoted):]; text != "" && text[0] != ' ' && text[0] != '\t' {
+			return nil, fmt.Errorf("no space after the quoted word %s", quoted)
+		}
+		words = append(words, word)
+	}
+	return words, nil
+}
+
+// cmdRelabel replaces the label old with new in the events recorded so
+// far: "relabel [-regex] [-force] <old> <new>". A running timer whose start
+// is relabeled goes on under its new name. Of the sinks, only those keeping
+// the events are told about the change, see editSink.
+func (s *Session) cmdRelabel(arg string, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	words, err := quotedFields(arg)
+	if err != nil {
+		fmt.Fprintln(out, "# relabel:", err)
+		return
+	}
+	regex, force := false, false
+	for len(words) > 0 && (words[0] == "-regex" || words[0] == "-force") {
+		regex, force = regex || words[0] == "-regex", force || words[0] == "-force"
+		words = words[1:]
+	}
+	if len(words) != 2 {
+		fmt.Fprintln(out, `# Usage: relabel [-regex] [-force] <old> <new>; quote a label with spaces, e.g. "code review"`)
+		return
+	}
+	r, err := newRelabeler(words[0], words[1], regex, force)
+	if err != nil {
+		fmt.Fprintln(out, "# relabel:", err)
+		return
+	}
+	changed, kept := r.apply(s.Events)
+	for i, name := range s.timers {
+		if label, ok := r.label(labelStartPrefix + name); ok && strings.HasPrefix(label, labelStartPrefix) {
+			s.timers[i] = strings.TrimPrefix(label, labelStartPrefix)
+		}
+	}
+	if changed > 0 {
+		s.edited()
+	}
+	fmt.Fprintf(out, "# %s\n", relabelSummary(changed, kept))
+}
+
+func runRelabel(args []string) int {
+	fs := newFlagSet("relabel", "<old> <new> <file.csv>")
+	outFile := fs.String("o", "", "Output file path, - for stdout, stderr or fd:N (default: the file itself)")
+	regex := fs.Bool("regex", false, "Match the labels with old as a regular expression, anywhere in the label;\n"+
+		"new may refer to its groups as $1 or ${name}")
+	force := fs.Bool("force", false, "Also relabel enter, exit and the other events of the stopwatch itself")
+	completeFiles(fs, "o")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return 2
+	}
+	inFile := fs.Arg(2)
+	if *outFile == "" {
+		*outFile = inFile
+	}
+	r, err := newRelabeler(fs.Arg(0), fs.Arg(1), *regex, *force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	opts, err := withCompression(OutputOptions{}, *outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	data, err := readInput(inFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem reading CSV:", err)
+		return 1
+	}
+	l, err := relabelFile(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s: %v\n", inFile, err)
+		return 1
+	}
+	changed, kept := r.apply(l.events)
+	fmt.Fprintf(os.Stderr, "# %s: %s\n", inFile, relabelSummary(changed, kept))
+	if changed == 0 && *outFile == inFile {
+		return 0
+	}
+	l.opts.Compress, l.opts.CompressLevel = opts.Compress, opts.CompressLevel
+	if err := DumpEvents(*outFile, l.events, l.opts); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem writing output:", err)
+		return 1
+	}
+	return 0
+}
+
+// relabelFile parses the recorded file data for relabel. Only the label of
+// an event is to change, so the file must be in the canonical format, which
+// the events are written back in: any other field, the comment and the
+// metadata then come out byte for byte as they were.
+func relabelFile(data []byte) (*lenientCSV, error) {
+	l, err := parseLenient(data)
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := l.encode()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(canonical, data) {
+		return nil, fmt.Errorf("not in the canonical format, so it could not be written back as it is; see normalize")
+	}
+	return l, nil
+}
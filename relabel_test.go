@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelabeler(t *testing.T) {
+	events := []Event{{What: labelEnter}, {What: "bulid"}, {What: "bulid x"}, {What: "mark:bulid"},
+		{What: labelStartPrefix + "bulid"}, {What: labelExit}}
+	r, _ := newRelabeler("bulid", "build", false, false)
+	if changed, kept := r.apply(events); changed != 1 || kept != 0 || events[1].What != "build" || events[2].What != "bulid x" {
+		t.Errorf("Expected the whole label only to match, got %d, %d, %v", changed, kept, events)
+	}
+	// a regexp matches anywhere; a mark stays a mark, a tick can not
+	// become one, nor a sentinel a tick
+	r, _ = newRelabeler(`^(mark:|start:)?bu(li|il)d( x)?$`, "${1}compile$3", true, false)
+	changed, kept := r.apply(events)
+	want := []string{labelEnter, "compile", "compile x", "mark:compile", labelStartPrefix + "compile", labelExit}
+	for i, evt := range events {
+		if evt.What != want[i] {
+			t.Errorf("Expected %q, got %q", want[i], evt.What)
+		}
+	}
+	if changed != 4 || kept != 0 {
+		t.Errorf("Expected 4 changed, got %d, %d", changed, kept)
+	}
+	for _, test := range []struct {
+		old, new string
+		force    bool
+		changed  int
+	}{
+		{"^compile$", "mark:compile", false, 0}, {"^exit$", "tick", false, 0}, {"^compile$", "", false, 0},
+		{"^exit$", "tick", true, 1},
+	} {
+		r, _ := newRelabeler(test.old, test.new, true, test.force)
+		if changed, kept := r.apply(events); changed != test.changed || changed+kept == 0 {
+			t.Errorf("%q -> %q: expected %d changed, got %d, %d kept", test.old, test.new, test.changed, changed, kept)
+		}
+	}
+	for _, args := range [][2]string{{"", "x"}, {"x", ""}} {
+		if _, err := newRelabeler(args[0], args[1], false, false); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+	if _, err := newRelabeler("(", "x", true, false); err == nil {
+		t.Error("Expected an error for an invalid regexp")
+	}
+}
+
+func TestRelabelFile(t *testing.T) {
+	v := 2.5
+	events := testEvents(time.Second, 1500*time.Millisecond, time.Second)
+	events[1].What, events[1].Value, events[1].Attrs = "bulid", &v, map[string]string{"host": "bulid-1"}
+	events[2].What, events[2].Flag = "test, \"unit\"", flagShort
+	path := filepath.Join(t.TempDir(), "out.csv")
+	opts := OutputOptions{Comment: "bulid log", Meta: []metaField{{Key: "host", Value: "box"}}, TSStyle: tsStyleOffsetSeconds,
+		Columns: []string{"zone", "offset_s"}}
+	if err := DumpEvents(path, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	l, err := relabelFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := newRelabeler("bulid", "build", false, false)
+	if changed, _ := r.apply(l.events); changed != 1 {
+		t.Errorf("Expected 1 event changed, got %d", changed)
+	}
+	if err := DumpEvents(path, l.events, l.opts); err != nil {
+		t.Fatal(err)
+	}
+	// only the label of the row changes, not the attribute nor the comment
+	got, _ := os.ReadFile(path)
+	lines, gotLines := strings.Split(string(data), "\n"), strings.Split(string(got), "\n")
+	if len(lines) != len(gotLines) {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", data, got)
+	}
+	for i := range lines {
+		want := lines[i]
+		if strings.Contains(want, ",bulid,") {
+			want = strings.Replace(want, ",bulid,", ",build,", 1)
+		}
+		if gotLines[i] != want {
+			t.Errorf("Line %d: expected %q, got %q", i+1, want, gotLines[i])
+		}
+	}
+	if !strings.Contains(string(got), "bulid log") || !strings.Contains(string(got), "bulid-1") {
+		t.Errorf("Expected the comment and the attribute to be kept, got:\n%s", got)
+	}
+
+	// a file normalize would change is refused rather than rewritten
+	if _, err := relabelFile(bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))); err == nil ||
+		!strings.Contains(err.Error(), "normalize") {
+		t.Errorf("Expected a file not in the canonical format to be refused, got %v", err)
+	}
+}
+
+func TestSessionRelabel(t *testing.T) {
+	at := 0
+	sess := newSession("", collectOptions{})
+	setClock(sess, &at)
+	var out bytes.Buffer
+	sess.start()
+	for _, line := range []string{"code reviw", "start bulid", "code reviw"} {
+		at += 10
+		sess.handleLine(line, &out)
+	}
+	out.Reset()
+	for _, line := range []string{`relabel "code reviw" "code review"`, "relabel -regex ^start:bulid$ start:build",
+		"relabel -regex ^enter$ tick", "relabel x", `relabel "x`} {
+		sess.handleLine(line, &out)
+	}
+	want := "# Relabeled 2 events\n# Relabeled 1 events\n" +
+		"# Relabeled 0 events; 1 left as they were: the stopwatch's own events (see -force), or an empty label\n" +
+		"# Usage: relabel [-regex] [-force] <old> <new>; quote a label with spaces, e.g. \"code review\"\n" +
+		"# relabel: invalid quoted word \"x\n"
+	if out.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+	if sess.Events[1].What != "code review" || sess.Events[3].What != "code review" {
+		t.Errorf("Expected the ticks to be relabeled, got %v", sess.Events)
+	}
+	// the timer goes on under its new name
+	out.Reset()
+	sess.handleLine("stop build", &out)
+	if out.Len() != 0 || sess.Events[len(sess.Events)-1].What != labelStopPrefix+"build" {
+		t.Errorf("Expected the renamed timer to stop, got %q, %v", out.String(), sess.Events)
+	}
+	sess.finish()
+}
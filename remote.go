@@ -0,0 +1,285 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteLine is a line received from outside the terminal, to be recorded
+// as a tick
+type remoteLine struct {
+	text       string
+	source     string            // see Event.Source
+	at         time.Time         // when the line was received
+	attrs      map[string]string // attributes added to the event
+	exact      bool              // text is the label as is, without a value or attributes
+	suspension bool              // text is "suspended" or "resumed", not a tick; see watchSuspend
+	async      bool              // the sender does not wait for the reply, see remoteInput.send
+}
+
+// tickSource is an input of ticks from outside the terminal, see
+// remoteInput.start. run passes the lines received on to emit, which
+// records each and returns the reply to the sender, until ctx is done, and
+// then returns nil. An error tells that the source failed, and has stopped.
+type tickSource interface {
+	run(ctx context.Context, emit func(req remoteLine) string) error
+}
+
+// remoteInput gathers the labels received from outside the terminal: from
+// the network listeners (-tcp, -udp), the watchers (-watch-file,
+// -watch-dir, -watch-pid) and the timers and signals of Run, each a
+// tickSource, and the requests of -http-control and the daemon socket. The
+// sources pass each line to send, from their own goroutines, and the lines
+// are recorded in the order they arrive, each with the time the source
+// received it. While the collector is busy, they wait in a queue of at
+// most queueSize lines; a source waits for the reply to its line, unless
+// the line is async. When the queue is full, a tick is dropped as told by
+// overflow, see -overflow.
+type remoteInput struct {
+	record func(req remoteLine) string // set by attach
+	ready  chan struct{}               // closed by attach
+	wg     sync.WaitGroup              // the goroutines calling send
+	stops  []func()
+
+	queueSize int       // inputQueueSize by default
+	overflow  string    // overflowDropNewest by default
+	warn      io.Writer // the first drop is warned of here; os.Stderr if nil
+
+	// called when a source fails, with the name given to start; by
+	// default, a warning is written into stderr
+	onFail func(name string, err error)
+
+	mu      sync.Mutex
+	failed  []string // the names of the sources failed
+	queue   []queuedLine
+	queued  *sync.Cond     // signalled when a line is queued, or the queue closed
+	closed  bool           // set by stop; the lines queued until then are still recorded
+	done    bool           // set once the queue is closed and empty; later lines are refused
+	drained chan struct{}  // closed once done
+	dropped map[string]int // the ticks dropped from the queue, by source
+}
+
+// queuedLine is a line waiting to be recorded, with the channel of the
+// reply to its sender, nil for an async line
+type queuedLine struct {
+	req   remoteLine
+	reply chan string
+}
+
+func newRemoteInput() *remoteInput {
+	in := &remoteInput{ready: make(chan struct{}), queueSize: inputQueueSize, overflow: overflowDropNewest,
+		drained: make(chan struct{})}
+	in.queued = sync.NewCond(&in.mu)
+	return in
+}
+
+// start runs src until in is stopped. A failure is reported to onFail.
+func (in *remoteInput) start(name string, src tickSource) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in.stops = append(in.stops, cancel)
+	in.wg.Add(1)
+	go func() {
+		defer in.wg.Done()
+		if err := src.run(ctx, in.send); err != nil && ctx.Err() == nil {
+			in.fail(name, err)
+		}
+	}()
+}
+
+// fail reports that the source name failed with err
+func (in *remoteInput) fail(name string, err error) {
+	in.mu.Lock()
+	in.failed = append(in.failed, name)
+	in.mu.Unlock()
+	if in.onFail != nil {
+		in.onFail(name, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n# WARNING: %s stopped: %v\n", name, err)
+}
+
+// failures returns the names of the sources failed so far
+func (in *remoteInput) failures() []string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return append([]string(nil), in.failed...)
+}
+
+// attach passes the lines received on to record, e.g. Stopwatch.remote.
+// Until then, the lines wait in the queue, so that nothing is recorded
+// before the session has started.
+func (in *remoteInput) attach(record func(req remoteLine) string) {
+	in.record = record
+	close(in.ready)
+	go in.recordQueued()
+}
+
+// send queues req to be recorded, timestamped now if the source did not,
+// and returns the reply to the sender once recorded: "" at once for an
+// async line, or an "error" reply if req was dropped from a full queue.
+// Once stop has recorded the last of the queue, req is refused with an
+// "error" reply, so that no line is recorded ahead of those queued before.
+func (in *remoteInput) send(req remoteLine) string {
+	if req.at.IsZero() {
+		req.at = time.Now()
+	}
+	line := queuedLine{req: req}
+	if !req.async {
+		line.reply = make(chan string, 1)
+	}
+	in.mu.Lock()
+	if in.done {
+		in.mu.Unlock()
+		return "error " + errInputStopped.Error()
+	}
+	var dropped []queuedLine
+	switch {
+	case len(in.queue) < in.queueSize || req.suspension:
+		in.queue = append(in.queue, line)
+	case in.overflow == overflowDropOldest && oldestTick(in.queue) >= 0:
+		i := oldestTick(in.queue)
+		dropped = append(dropped, in.queue[i])
+		in.queue = append(append(in.queue[:i:i], in.queue[i+1:]...), line)
+	default:
+		dropped = append(dropped, line)
+	}
+	first := false
+	for _, line := range dropped {
+		if in.dropped == nil {
+			in.dropped, first = make(map[string]int), true
+		}
+		in.dropped[line.req.source]++
+	}
+	in.queued.Signal()
+	in.mu.Unlock()
+
+	if first {
+		in.warnOverflow()
+	}
+	for _, line := range dropped {
+		if line.reply != nil {
+			line.reply <- "error " + errInputQueueFull.Error()
+		}
+	}
+	if line.reply == nil {
+		return ""
+	}
+	return <-line.reply
+}
+
+// recordQueued records the queued lines in turn, until the queue is
+// closed and empty
+func (in *remoteInput) recordQueued() {
+	defer close(in.drained)
+	in.mu.Lock()
+	for {
+		for len(in.queue) == 0 && !in.closed {
+			in.queued.Wait()
+		}
+		if len(in.queue) == 0 {
+			in.done = true
+			in.mu.Unlock()
+			return
+		}
+		line := in.queue[0]
+		in.queue = in.queue[1:]
+		in.mu.Unlock()
+		reply := in.record(line.req)
+		if line.reply != nil {
+			line.reply <- reply
+		}
+		in.mu.Lock()
+	}
+}
+
+// stop stops the listeners, and returns once the lines already received
+// have been recorded. Must be called after attach.
+func (in *remoteInput) stop() {
+	for _, stop := range in.stops {
+		stop()
+	}
+	in.wg.Wait()
+	in.mu.Lock()
+	in.closed = true
+	in.queued.Signal()
+	in.mu.Unlock()
+	<-in.drained
+}
+
+// handleRemote records the label received from outside, copying any
+// messages into out, and returns the reply to the sender: "ok <seq>", or
+// "error <message>" if nothing was recorded
+func (s *Session) handleRemote(req remoteLine, out io.Writer) string {
+	return remoteReply(s.remoteEvent(req, out))
+}
+
+// remoteReply returns the reply to the sender of a line recorded as evt,
+// or not recorded because of err
+func remoteReply(evt Event, err error) string {
+	if err != nil {
+		return "error " + err.Error()
+	}
+	return fmt.Sprintf("ok %d", evt.Seq)
+}
+
+// remoteEvent records the label received from outside, copying any
+// messages into out, and returns the event recorded. If nothing was
+// recorded, the reason is returned as the error.
+func (s *Session) remoteEvent(req remoteLine, out io.Writer) (Event, error) {
+	if req.suspension {
+		return s.recorded(out, func(out io.Writer) { s.recordSuspension(req, out) })
+	}
+	evt := Event{What: req.text}
+	if !req.exact {
+		var err error
+		if evt, err = parseTick(req.text); err != nil {
+			fmt.Fprintf(out, "# %v, not recorded\n", err)
+			return Event{}, fmt.Errorf("%v, not recorded", err)
+		}
+	}
+	for key, value := range req.attrs {
+		if evt.Attrs == nil {
+			evt.Attrs = make(map[string]string)
+		}
+		evt.Attrs[key] = value
+	}
+	return s.recorded(out, func(out io.Writer) { s.recordTick(evt, out) })
+}
+
+// recorded calls record, and returns the event it recorded. If nothing was
+// recorded, the first message record wrote into out is returned as the
+// error.
+func (s *Session) recorded(out io.Writer, record func(out io.Writer)) (Event, error) {
+	var msg bytes.Buffer
+	n := s.count()
+	record(io.MultiWriter(out, &msg))
+	if s.count() > n {
+		return s.Events[len(s.Events)-1], nil
+	}
+	reason := strings.TrimPrefix(strings.TrimSpace(msg.String()), "# ")
+	if reason == "" {
+		reason = "not recorded"
+	}
+	return Event{}, errors.New(strings.SplitN(reason, "\n", 2)[0])
+}
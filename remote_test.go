@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// funcSource is a tickSource running a function
+type funcSource func(ctx context.Context, emit func(req remoteLine) string) error
+
+func (f funcSource) run(ctx context.Context, emit func(req remoteLine) string) error {
+	return f(ctx, emit)
+}
+
+func TestRemoteInputSources(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	sess.start()
+	sw := NewStopwatch(sess, io.Discard)
+	in := newRemoteInput()
+	in.attach(sw.remote)
+
+	const sources, lines = 8, 100
+	for i := 0; i < sources; i++ {
+		i := i
+		in.start(fmt.Sprint("source ", i), funcSource(func(ctx context.Context, emit func(req remoteLine) string) error {
+			for j := 0; j < lines; j++ {
+				emit(remoteLine{text: fmt.Sprintf("s%d-%d", i, j), source: fmt.Sprint(i), at: time.Now(), exact: true})
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+	}
+	in.stop()
+	sess.finish()
+
+	if len(sess.Events) != sources*lines+2 {
+		t.Fatalf("Expected %d events, got %d", sources*lines+2, len(sess.Events))
+	}
+	next := make(map[string]int)
+	for i, evt := range sess.Events {
+		if evt.Seq != int64(i) || (i > 0 && evt.Timestamp.Before(sess.Events[i-1].Timestamp)) {
+			t.Fatalf("Expected the events in the order recorded, got %+v after %+v", evt, sess.Events[i-1])
+		}
+		if isSentinel(evt.What) {
+			continue
+		}
+		if want := fmt.Sprintf("s%s-%d", evt.Source, next[evt.Source]); evt.What != want {
+			t.Errorf("Expected %q from source %s, got %q", want, evt.Source, evt.What)
+		}
+		next[evt.Source]++
+	}
+	if failed := in.failures(); len(failed) > 0 {
+		t.Errorf("Expected no failures once stopped, got %v", failed)
+	}
+}
+
+func TestRemoteInputSlowCollector(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	sess.start()
+	sw := NewStopwatch(sess, io.Discard)
+	in := newRemoteInput()
+	in.attach(sw.remote)
+
+	at := time.Now()
+	emitted := make(chan string)
+	sw.do(func(sess *Session) {
+		// the collector is busy while the source receives the line
+		in.start("slow", funcSource(func(ctx context.Context, emit func(req remoteLine) string) error {
+			emitted <- emit(remoteLine{text: "late", at: at, exact: true})
+			return nil
+		}))
+		time.Sleep(50 * time.Millisecond)
+	})
+	if reply := <-emitted; reply != "ok 1" {
+		t.Errorf("Expected the reply once recorded, got %q", reply)
+	}
+	in.stop()
+	if evt := sess.Events[1]; evt.What != "late" || !evt.Timestamp.Equal(at) {
+		t.Errorf("Expected the time the source received the line, got %+v", evt)
+	}
+}
+
+func TestRemoteInputStop(t *testing.T) {
+	requests := make(chan remoteLine, 10)
+	in := newRemoteInput()
+	in.attach(func(req remoteLine) string {
+		time.Sleep(10 * time.Millisecond)
+		requests <- req
+		return "ok"
+	})
+	var failed []string
+	var mu sync.Mutex
+	in.onFail = func(name string, err error) {
+		mu.Lock()
+		failed = append(failed, name+": "+err.Error())
+		mu.Unlock()
+	}
+	in.start("draining", funcSource(func(ctx context.Context, emit func(req remoteLine) string) error {
+		<-ctx.Done()
+		emit(remoteLine{text: "drained"})
+		return ctx.Err()
+	}))
+	in.start("broken", funcSource(func(ctx context.Context, emit func(req remoteLine) string) error {
+		return errors.New("connection refused")
+	}))
+	reported := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(failed)
+	}
+	for deadline := time.Now().Add(time.Second); reported() == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	if want := []string{"broken: connection refused"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("Expected the failure reported, got %v", failed)
+	}
+	mu.Unlock()
+
+	in.stop()
+	// the lines emitted until the sources returned are recorded by then
+	select {
+	case req := <-requests:
+		if req.text != "drained" {
+			t.Errorf("Unexpected line %+v", req)
+		}
+	default:
+		t.Error("Expected the line emitted at stop to be recorded")
+	}
+	if got := in.failures(); !reflect.DeepEqual(got, []string{"broken"}) {
+		t.Errorf("Expected only the broken source to have failed, got %v", got)
+	}
+}
+
+func TestRemoteInputLateSend(t *testing.T) {
+	in := newRemoteInput()
+	for i := 0; i < 5; i++ {
+		in.send(remoteLine{text: fmt.Sprint("s", i), async: true})
+	}
+	gate := make(chan struct{})
+	var recorded []string
+	in.attach(func(req remoteLine) string {
+		<-gate
+		recorded = append(recorded, req.text)
+		return "ok"
+	})
+	stopped := make(chan struct{})
+	go func() {
+		in.stop()
+		close(stopped)
+	}()
+	closed := func() bool {
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		return in.closed
+	}
+	for deadline := time.Now().Add(5 * time.Second); !closed() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	// a line sent while the queue drains goes after the ones queued before
+	reply := make(chan string)
+	go func() { reply <- in.send(remoteLine{text: "late"}) }()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		in.mu.Lock()
+		n := len(in.queue)
+		in.mu.Unlock()
+		if n == 5 || time.Now().After(deadline) {
+			break
+		}
+	}
+	close(gate)
+	if got := <-reply; got != "ok" {
+		t.Errorf("Expected the late line to be recorded, got %q", got)
+	}
+	<-stopped
+	if want := []string{"s0", "s1", "s2", "s3", "s4", "late"}; !reflect.DeepEqual(recorded, want) {
+		t.Errorf("Expected %v, got %v", want, recorded)
+	}
+	// once drained, a line is refused
+	if got := in.send(remoteLine{text: "later"}); got != "error the session is ending, not recorded" {
+		t.Errorf("Expected the line after stop to be refused, got %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseRenames parses the source=target pairs of the repeated -rename flag
+// into the header text of the columns renamed. The sources are the
+// built-in columns and "attrs"; attributes and the columns of -column are
+// named where they are given. A target may not be the name of another
+// column, unless that one is renamed too.
+func parseRenames(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	known := knownColumns()
+	known[attrsColumn] = true
+	renames := make(map[string]string)
+	sources := make(map[string]string)
+	for _, pair := range pairs {
+		source, target, ok := strings.Cut(pair, "=")
+		if !ok || source == "" || target == "" {
+			return nil, fmt.Errorf("%q: expected source=target, e.g. ts=timestamp", pair)
+		}
+		if !known[source] {
+			return nil, fmt.Errorf("%q: unknown column %q", pair, source)
+		}
+		if strings.ContainsAny(target, "\r\n") {
+			return nil, fmt.Errorf("%q: the name must be on one line", pair)
+		}
+		if _, ok := renames[source]; ok {
+			return nil, fmt.Errorf("%q: %q is renamed twice", pair, source)
+		}
+		if other, ok := sources[target]; ok {
+			return nil, fmt.Errorf("%q: %q is also the name of %q", pair, target, other)
+		}
+		renames[source], sources[target] = target, source
+	}
+	for target, source := range sources {
+		if _, renamed := renames[target]; known[target] && !renamed {
+			return nil, fmt.Errorf("%q: %q is already a column", source+"="+target, target)
+		}
+	}
+	return renames, nil
+}
+
+// headerText returns the header text of the columns names, see -rename
+func (opts OutputOptions) headerText(names []string) []string {
+	if len(opts.Rename) == 0 {
+		return names
+	}
+	text := make([]string, len(names))
+	for i, name := range names {
+		text[i] = opts.headerName(name)
+	}
+	return text
+}
+
+// headerName returns the header text of the column name
+func (opts OutputOptions) headerName(name string) string {
+	if target, ok := opts.Rename[name]; ok {
+		return target
+	}
+	return name
+}
+
+// checkHeaderText verifies that the header text of the columns names has
+// no duplicates, e.g. when a column is renamed to the name of an attribute
+func (opts OutputOptions) checkHeaderText(names []string) error {
+	seen := make(map[string]string)
+	for _, name := range names {
+		text := opts.headerName(name)
+		if other, ok := seen[text]; ok {
+			return fmt.Errorf("-rename: %q would be the header of both %q and %q", text, other, name)
+		}
+		seen[text] = name
+	}
+	return nil
+}
+
+// renamedColumns is the inverse of the renames of parseRenames: the column
+// names of the header text in a file read
+func renamedColumns(renames map[string]string) map[string]string {
+	columns := make(map[string]string)
+	for source, target := range renames {
+		columns[target] = source
+	}
+	return columns
+}
+
+// unrename replaces the header text of renamed columns in header with the
+// column names, see renamedColumns
+func unrename(header []string, columns map[string]string) []string {
+	if len(columns) == 0 {
+		return header
+	}
+	names := make([]string, len(header))
+	for i, text := range header {
+		if name, ok := columns[text]; ok {
+			names[i] = name
+		} else {
+			names[i] = text
+		}
+	}
+	return names
+}
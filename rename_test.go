@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRenames(t *testing.T) {
+	got, err := parseRenames([]string{"ts=timestamp", "what=event", "attrs=tags"})
+	if want := map[string]string{"ts": "timestamp", "what": "event", "attrs": "tags"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v, %v", want, got, err)
+	}
+	// a column may take the name of another one renamed
+	if _, err := parseRenames([]string{"ts=what", "what=event"}); err != nil {
+		t.Error(err)
+	}
+	for _, pairs := range [][]string{
+		{"ts"}, {"=timestamp"}, {"ts="},
+		{"when=timestamp"},
+		{"ts=time\nstamp"},
+		{"ts=a", "ts=b"},
+		{"ts=event", "what=event"},
+		{"ts=what"},
+	} {
+		if _, err := parseRenames(pairs); err == nil {
+			t.Errorf("Expected error for %q", pairs)
+		}
+	}
+}
+
+func TestEncodeRenamed(t *testing.T) {
+	events := testEvents(time.Second)
+	events[1].Attrs = map[string]string{"lane": "3"}
+	renames := map[string]string{"ts": "timestamp", "what": "event"}
+	encoded := func(opts OutputOptions) string {
+		var buf bytes.Buffer
+		opts.Rename = renames
+		encode, prepared, opts, err := prepareOutput(events, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := encode(&buf, prepared, opts); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	csv := encoded(OutputOptions{})
+	if want := "# stopwatch-schema: 2\nseq,timestamp,event,lane\n0,2022-04-08T20:00:00Z,enter,\n1,2022-04-08T20:00:01Z,exit,3\n"; csv != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, csv)
+	}
+	if _, _, err := UnmarshalEventsCSV(strings.NewReader(csv)); err == nil {
+		t.Error("Expected the renamed columns to be missing without the renames")
+	}
+	back, _, err := UnmarshalEventsCSVWith(strings.NewReader(csv), ReadOptions{Rename: renames})
+	if err != nil || !reflect.DeepEqual(back, events) {
+		t.Errorf("Expected the events back, got %v, %v", back, err)
+	}
+
+	ndjson := encoded(OutputOptions{Format: "ndjson"})
+	if want := `{"seq":1,"timestamp":"2022-04-08T20:00:01Z","event":"exit","attrs":{"lane":"3"}}`; !strings.Contains(ndjson, want) {
+		t.Errorf("Expected %s, got:\n%s", want, ndjson)
+	}
+	if org := encoded(OutputOptions{Format: "org"}); !strings.HasPrefix(org, "| seq | timestamp ") {
+		t.Errorf("Expected the renamed header, got:\n%s", org)
+	}
+	data := NewTemplateData(events, OutputOptions{Rename: renames, Columns: []string{"value"}})
+	if want := []string{"seq", "timestamp", "event", "value"}; !reflect.DeepEqual(data.Metadata.Columns, want) {
+		t.Errorf("Expected the template data to have the columns %v, got %v", want, data.Metadata.Columns)
+	}
+
+	// the header text of an attribute
+	renames = map[string]string{"what": "lane"}
+	if _, _, _, err := prepareOutput(events, OutputOptions{Rename: renames}); err == nil {
+		t.Error("Expected an error for two columns with the same header")
+	}
+}
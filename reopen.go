@@ -0,0 +1,116 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The waits between the attempts to open a lost -stream output again, see
+// reopener
+var (
+	reopenMinWait = time.Second
+	reopenMaxWait = 30 * time.Second
+)
+
+// reopener opens an output lost while streaming again, e.g. after its
+// directory was removed or its filesystem remounted read-only: it calls
+// open until it succeeds, waiting min before the first attempt, and twice
+// as long after each failed one, at most max
+type reopener struct {
+	open     func() (*os.File, error)
+	min, max time.Duration
+	after    func(time.Duration) <-chan time.Time // time.After, replaced by the tests
+}
+
+// run returns the file of the first successful open, or nil once stop is
+// closed
+func (r reopener) run(stop <-chan struct{}) *os.File {
+	wait := r.min
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-r.after(wait):
+		}
+		if f, err := r.open(); err == nil {
+			return f
+		}
+		if wait *= 2; wait > r.max {
+			wait = r.max
+		}
+	}
+}
+
+// openStreamTarget opens the file path again, for writing the events kept
+// while it was lost. An existing file is appended to, after a line break
+// if it ends in a partly written line. A missing one is created with the
+// header: written into a temporary file, which is then renamed to path, so
+// the file never appears without it. With mkdirs, the missing directories
+// are created.
+func openStreamTarget(path string, header []byte, mkdirs bool) (*os.File, error) {
+	if f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0); err == nil {
+		if err := endLine(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	if mkdirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Chmod(0o644); err == nil {
+		_, err = f.Write(header)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// endLine writes a line break into the end of f, unless f is empty or
+// ends in one
+func endLine(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 || !fi.Mode().IsRegular() {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, fi.Size()-1); err != nil && err != io.EOF {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = f.Write([]byte("\n"))
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReopener(t *testing.T) {
+	var waits []time.Duration
+	attempts := 0
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := reopener{min: time.Second, max: 5 * time.Second,
+		after: func(d time.Duration) <-chan time.Time {
+			waits = append(waits, d)
+			c := make(chan time.Time, 1)
+			c <- time.Time{}
+			return c
+		},
+		open: func() (*os.File, error) {
+			if attempts++; attempts < 5 {
+				return nil, errors.New("read-only file system")
+			}
+			return f, nil
+		}}
+	if got := r.run(make(chan struct{})); got != f {
+		t.Fatalf("Expected the file opened, got %v", got)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(waits, want) {
+		t.Errorf("Expected the waits %v, got %v", want, waits)
+	}
+
+	// stopped while waiting
+	stop := make(chan struct{})
+	close(stop)
+	r = reopener{min: time.Hour, max: time.Hour, after: time.After, open: func() (*os.File, error) {
+		t.Error("Expected no attempt after the stop")
+		return nil, nil
+	}}
+	if got := r.run(stop); got != nil {
+		t.Errorf("Expected nil once stopped, got %v", got)
+	}
+}
+
+func TestOpenStreamTarget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "out.csv")
+	if _, err := openStreamTarget(path, []byte("seq,ts,what\n"), false); err == nil {
+		t.Error("Expected an error without the directory")
+	}
+	f, err := openStreamTarget(path, []byte("seq,ts,what\n"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("0,x,enter\n1,x,ti")
+	f.Close()
+	// appended to, after a line break ending the partly written line
+	if f, err = openStreamTarget(path, []byte("not written\n"), false); err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("2,x,exit\n")
+	f.Close()
+	if b, _ := os.ReadFile(path); string(b) != "seq,ts,what\n0,x,enter\n1,x,ti\n2,x,exit\n" {
+		t.Errorf("Unexpected file:\n%s", b)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("Expected no temporary file left, got %v", entries)
+	}
+}
@@ -0,0 +1,483 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportOptions controls the contents of the report
+type ReportOptions struct {
+	Percentiles []float64 // lap percentiles to compute, see Percentile
+
+	Histogram   bool          // draw a histogram of lap durations
+	Buckets     int           // number of histogram buckets; 0 = automatic
+	BucketWidth time.Duration // width of histogram buckets; overrides Buckets
+	Width       int           // width of the output in columns
+	ASCII       bool          // draw the sparkline with ASCII characters only
+
+	By string // group the laps by this column of the events closing them, see lapsBy
+
+	Order string // "desc" lists the groups and marks newest first, see OutputOptions.Order
+
+	Provenance []metaField // the metadata lines of the files, e.g. of -with-env
+
+	Budgets budgetList // the -budget of the labels, to compare their laps with
+}
+
+// reportFormats are the output formats of the report command
+var reportFormats = []string{"text", "csv"}
+
+func runReport(args []string) int {
+	fs := newFlagSet("report", "<file.csv>...")
+	percentiles := fs.String("percentiles", "50,90,99", "Comma separated list of lap duration percentiles")
+	histogram := fs.Bool("histogram", false, "Draw a histogram of lap durations")
+	buckets := fs.Int("buckets", 0, "Number of histogram buckets (default: automatic)")
+	bucketWidth := fs.Duration("bucket-width", 0, "Width of histogram buckets, e.g. 5s (default: automatic)")
+	width := fs.Int("width", 0, "Width of the histogram and sparkline in columns (default: terminal width)")
+	ascii := fs.Bool("ascii", !unicodeLocale(), "Draw the sparkline with ASCII characters only\n"+
+		"(default: true unless $LC_ALL, $LC_CTYPE or $LANG specify UTF-8)")
+	by := fs.String("by", "", "Total the laps per value of this column of the event closing each lap,\n"+
+		"e.g. 'what' for the labels, or an attribute")
+	format := fs.String("format", "text", "Output format: 'text' for the report, or 'csv' for the table of -by")
+	head := fs.Int("head", 0, "Report only the first N events of the files")
+	tail := fs.Int("tail", 0, "Report only the last N events of the files, e.g. the end of a long recording")
+	delimiter := fs.String("delimiter", "", "CSV field delimiter of the files (use \\t for tab; default: detected from the header)")
+	var rename stringList
+	fs.Var(&rename, "rename", "Read the column source from the header text target, given as source=target,\n"+
+		"as written by -rename. Can be repeated")
+	var budgets budgetList
+	fs.Var(&budgets, "budget", "Compare the laps of a label with this time budget, e.g. writing=30m, instead\n"+
+		"of the one recorded by -budget. Can be repeated")
+	order := fs.String("order", orderAsc, "Order of the groups and marks listed: '"+orderAsc+"', the oldest first, or '"+orderDesc+"'")
+	completeValues(fs, "order", func() []string { return orders })
+	completeValues(fs, "format", func() []string { return reportFormats })
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	ps, err := ParsePercentiles(*percentiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	if *buckets != 0 && *bucketWidth != 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -buckets and -bucket-width are mutually exclusive")
+		return 2
+	}
+	if *buckets < 0 || *bucketWidth < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -buckets and -bucket-width must be positive")
+		return 2
+	}
+	if *width <= 0 {
+		*width = terminalWidth(os.Stdout)
+	}
+	if *by != "" && !knownColumns()[*by] && validateAttrKey(*by) != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: -by %q is not a column nor an attribute name\n", *by)
+		return 2
+	}
+	if *format != "text" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown -format %q (available: %s)\n", *format, strings.Join(reportFormats, ", "))
+		return 2
+	}
+	if *format == "csv" && *by == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -format csv requires -by")
+		return 2
+	}
+	if err := validateOrder(*order, ""); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	if err := validateHeadTail(*head, *tail); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	var in ReadOptions
+	if in.Delimiter, err = parseInputDelimiter(*delimiter); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: -delimiter:", err)
+		return 2
+	}
+	if in.Rename, err = parseRenames(rename); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: -rename:", err)
+		return 2
+	}
+	events, p, err := loadCSVFiles(fs.Args(), in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem reading CSV:", err)
+		return 1
+	}
+	events = limitEvents(events, *head, *tail)
+	recorded, provenance := splitBudgetMeta(p.meta)
+	for _, b := range budgets {
+		recorded = recorded.with(b)
+	}
+	opts := ReportOptions{
+		Percentiles: ps,
+		Histogram:   *histogram,
+		Buckets:     *buckets,
+		BucketWidth: *bucketWidth,
+		Width:       *width,
+		ASCII:       *ascii,
+		By:          *by,
+		Order:       *order,
+		Provenance:  provenance,
+		Budgets:     recorded,
+	}
+	if *format == "csv" {
+		err = writeLapTotalsCSV(os.Stdout, opts.By, lapsBy(events, opts.By), ComputeStats(events).Active)
+	} else {
+		err = WriteReport(os.Stdout, events, p.comment, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: problem writing report:", err)
+		return 1
+	}
+	return 0
+}
+
+// WriteReport writes a human readable report of events into out
+func WriteReport(out io.Writer, events []Event, comment string, opts ReportOptions) error {
+	if comment != "" {
+		if _, err := fmt.Fprintf(out, "Comment: %s\n", comment); err != nil {
+			return err
+		}
+	}
+	if len(opts.Provenance) > 0 {
+		if _, err := fmt.Fprintln(out, "Provenance:"); err != nil {
+			return err
+		}
+		var rows [][]string
+		for _, f := range opts.Provenance {
+			rows = append(rows, []string{sanitizeLabel(f.Key), sanitizeLabel(f.Value)})
+		}
+		if err := writeTable(out, rows, 2); err != nil {
+			return err
+		}
+	}
+	s := ComputeStats(events)
+	if err := writeSummary(out, "", s, SparkOptions{Width: opts.Width, ASCII: opts.ASCII}); err != nil {
+		return err
+	}
+	if flags := flagCounts(events); flags != "" {
+		if _, err := fmt.Fprintf(out, "Flagged laps: %s\n", flags); err != nil {
+			return err
+		}
+	}
+	if idle, gaps := IdleDuration(events); gaps > 0 {
+		if _, err := fmt.Fprintf(out, "Idle: %s (gaps: %d)\n", formatDuration(idle), gaps); err != nil {
+			return err
+		}
+	}
+	if len(events) > 0 && events[len(events)-1].Group > 0 {
+		if err := writeGroups(out, events, opts.Order); err != nil {
+			return err
+		}
+	}
+	if timers := NamedTimers(events); len(timers) > 0 {
+		if _, err := fmt.Fprintln(out, "Timers:"); err != nil {
+			return err
+		}
+		if err := writeTimers(out, "  ", timers); err != nil {
+			return err
+		}
+	}
+	if err := writeReaction(out, "", ComputeReactionStats(events)); err != nil {
+		return err
+	}
+	if s.Marks > 0 {
+		if err := writeMarks(out, events, opts.Order); err != nil {
+			return err
+		}
+	}
+	if values := valuesByLabel(events); len(values) > 0 {
+		if err := writeValues(out, values); err != nil {
+			return err
+		}
+	}
+	if len(opts.Budgets) > 0 {
+		if err := writeBudgets(out, events, opts.Budgets); err != nil {
+			return err
+		}
+	}
+	if len(s.Laps) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(out, "Stddev: %s\n", formatDuration(s.StdDev)); err != nil {
+		return err
+	}
+	if len(opts.Percentiles) > 0 {
+		var fields []string
+		for i, d := range Percentiles(s.Laps, opts.Percentiles) {
+			fields = append(fields, fmt.Sprintf("%s: %s", formatPercentile(opts.Percentiles[i]), formatDuration(d)))
+		}
+		if _, err := fmt.Fprintf(out, "Percentiles: %s\n", strings.Join(fields, ", ")); err != nil {
+			return err
+		}
+	}
+	if opts.By != "" {
+		if err := writeLapTotals(out, opts.By, lapsBy(events, opts.By), s.Active); err != nil {
+			return err
+		}
+	}
+	if opts.Histogram {
+		if _, err := fmt.Fprintln(out, "Histogram:"); err != nil {
+			return err
+		}
+		return RenderHistogram(out, Histogram(s.Laps, opts.Buckets, opts.BucketWidth), opts.Width)
+	}
+	return nil
+}
+
+// writeMarks lists the milestones in events with their offsets from the
+// first event, in the order given, see writeInOrder
+func writeMarks(out io.Writer, events []Event, order string) error {
+	lines := []string{"Marks:"}
+	for _, evt := range events {
+		if !isMark(evt.What) {
+			continue
+		}
+		offset := evt.Timestamp.Sub(events[0].Timestamp)
+		name := strings.TrimPrefix(evt.What, labelMarkPrefix)
+		lines = append(lines, fmt.Sprintf("  +%s %s", formatDuration(offset), name))
+	}
+	return writeInOrder(out, lines, order)
+}
+
+// writeInOrder writes the heading lines[0] into out, and then the other
+// lines, the last one first with -order desc
+func writeInOrder(out io.Writer, lines []string, order string) error {
+	if order == orderDesc {
+		for i, j := 1, len(lines)-1; i < j; i, j = i+1, j-1 {
+			lines[i], lines[j] = lines[j], lines[i]
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelValues summarizes the values recorded with one label
+type labelValues struct {
+	label         string
+	n             int
+	min, max, sum float64
+}
+
+// valuesByLabel summarizes the event values per label, in the order the
+// labels first appear in events
+func valuesByLabel(events []Event) []labelValues {
+	var values []labelValues
+	index := make(map[string]int)
+	for _, evt := range events {
+		if evt.Value == nil {
+			continue
+		}
+		v := *evt.Value
+		i, ok := index[evt.What]
+		if !ok {
+			i = len(values)
+			index[evt.What] = i
+			values = append(values, labelValues{label: evt.What, min: v, max: v})
+		}
+		lv := &values[i]
+		lv.n++
+		lv.sum += v
+		lv.min = math.Min(lv.min, v)
+		lv.max = math.Max(lv.max, v)
+	}
+	return values
+}
+
+func writeValues(out io.Writer, values []labelValues) error {
+	if _, err := fmt.Fprintln(out, "Values:"); err != nil {
+		return err
+	}
+	for _, lv := range values {
+		_, err := fmt.Fprintf(out, "  %s: n: %d, min: %s, avg: %s, max: %s\n", lv.label, lv.n,
+			formatValue(lv.min), formatValue(lv.sum/float64(lv.n)), formatValue(lv.max))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flagCounts counts the flagged events per flag, formatted as
+// "flag: count" pairs in the order the flags first appear
+func flagCounts(events []Event) string {
+	var flags []string
+	counts := make(map[string]int)
+	for _, evt := range events {
+		if evt.Flag == "" {
+			continue
+		}
+		if counts[evt.Flag] == 0 {
+			flags = append(flags, evt.Flag)
+		}
+		counts[evt.Flag]++
+	}
+	var fields []string
+	for _, flag := range flags {
+		fields = append(fields, fmt.Sprintf("%s: %d", flag, counts[flag]))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// writeGroups writes the lap statistics of each lap group in events, in
+// the order given. Groups are named after their "reset" events.
+func writeGroups(out io.Writer, events []Event, order string) error {
+	lines := []string{"Groups:"}
+	for start := 0; start < len(events); {
+		end := start
+		for end < len(events) && events[end].Group == events[start].Group {
+			end++
+		}
+		group := events[start:end]
+		name := fmt.Sprint(group[0].Group)
+		if _, n, ok := strings.Cut(group[0].What, ":"); ok && isReset(group[0].What) {
+			name += " " + n
+		}
+		line := "no laps recorded"
+		if s := ComputeStats(group); len(s.Laps) > 0 {
+			line = fmt.Sprintf("laps: %d, min: %s, avg: %s, max: %s", len(s.Laps),
+				formatDuration(s.Min), formatDuration(s.Mean), formatDuration(s.Max))
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", name, line))
+		start = end
+	}
+	return writeInOrder(out, lines, order)
+}
+
+// lapTotal sums the laps closed by the events with the same value in a
+// column
+type lapTotal struct {
+	value string
+	laps  int
+	total time.Duration
+}
+
+// lapsBy totals the laps in events by the value in the named column of the
+// event closing each lap: a lap is attributed to the label (or e.g. the
+// attribute) of the event that ends it, like in LapsByLabel. Values equal
+// to "enter" or "exit" are left out. Sorted by the total, longest first;
+// equal totals in the order the values first appear.
+func lapsBy(events []Event, column string) []lapTotal {
+	var totals []lapTotal
+	index := make(map[string]int)
+	forEachLap(events, func(evt Event, lap time.Duration) {
+		value := evt.Cell(column)
+		if isSentinel(value) {
+			return
+		}
+		i, ok := index[value]
+		if !ok {
+			i = len(totals)
+			index[value] = i
+			totals = append(totals, lapTotal{value: value})
+		}
+		totals[i].laps++
+		totals[i].total += lap
+	})
+	sort.SliceStable(totals, func(i, j int) bool { return totals[i].total > totals[j].total })
+	return totals
+}
+
+// share returns the share of t in active time as a percentage
+func (t lapTotal) share(active time.Duration) float64 {
+	if active <= 0 {
+		return 0
+	}
+	return 100 * float64(t.total) / float64(active)
+}
+
+// writeLapTotals writes the totals of lapsBy into out as an aligned table,
+// with the share of each of the active time of the session
+func writeLapTotals(out io.Writer, column string, totals []lapTotal, active time.Duration) error {
+	if _, err := fmt.Fprintf(out, "By %s:\n", column); err != nil {
+		return err
+	}
+	rows := [][]string{{column, "laps", "total", "avg", "share"}}
+	for _, t := range totals {
+		value := sanitizeLabel(t.value)
+		if value == "" {
+			value = "-"
+		}
+		rows = append(rows, []string{value, strconv.Itoa(t.laps), formatDuration(t.total),
+			formatDuration(t.total / time.Duration(t.laps)), fmt.Sprintf("%.1f%%", t.share(active))})
+	}
+	return writeTable(out, rows, 1)
+}
+
+// writeTable writes rows into out as an indented table aligned by the
+// display width of the cells: the first left columns to the left, and the
+// rest, such as numbers and durations, to the right
+func writeTable(out io.Writer, rows [][]string, left int) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := displayWidth(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for _, row := range rows {
+		line := ""
+		for i, cell := range row {
+			pad := strings.Repeat(" ", widths[i]-displayWidth(cell))
+			switch {
+			case i == len(row)-1 && i < left:
+				line += "  " + cell
+			case i < left:
+				line += "  " + cell + pad
+			default:
+				line += "  " + pad + cell
+			}
+		}
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLapTotalsCSV writes the totals of lapsBy into out as CSV, with the
+// durations in seconds and the share as a percentage
+func writeLapTotalsCSV(out io.Writer, column string, totals []lapTotal, active time.Duration) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{column, "laps", "total", "avg", "share"})
+	for _, t := range totals {
+		w.Write([]string{t.value, strconv.Itoa(t.laps), formatValue(t.total.Seconds()),
+			formatValue((t.total / time.Duration(t.laps)).Seconds()), strconv.FormatFloat(t.share(active), 'f', 1, 64)})
+	}
+	w.Flush()
+	return w.Error()
+}
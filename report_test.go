@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(append(seconds(2, 4, 4, 4, 5, 5, 7, 9), seconds(1)...)...)
+	opts := ReportOptions{Percentiles: []float64{50, 90, 99.9}}
+	if err := WriteReport(&buf, events, "run 1", opts); err != nil {
+		t.Fatal(err)
+	}
+	expect := `Comment: run 1
+Total: 41s, ticks: 8
+Laps: 8, min: 2s, avg: 5s, max: 9s
+Stddev: 2s
+Percentiles: p50: 4s, p90: 9s, p99.9: 9s
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestWriteReportIdle(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(seconds(2, 10, 50, 1)...)
+	events[2].What = labelIdle
+	if err := WriteReport(&buf, events, "", ReportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `Total: 1m3s, ticks: 2
+Laps: 2, min: 2s, avg: 6s, max: 10s
+Idle: 50s (gaps: 1)
+Stddev: 4s
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestWriteReportMarks(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(seconds(2, 1.5, 3, 1)...)
+	events[1].What = labelMarkPrefix + "warm"
+	events[3].What = labelMarkPrefix + "phase2-start"
+	if err := WriteReport(&buf, events, "", ReportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `Total: 7.5s, ticks: 1, marks: 2
+Laps: 1, min: 3.5s, avg: 3.5s, max: 3.5s
+Marks:
+  +2s warm
+  +6.5s phase2-start
+Stddev: 0s
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+
+	buf.Reset()
+	if err := WriteReport(&buf, events, "", ReportOptions{Order: orderDesc}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, "Marks:\n  +6.5s phase2-start\n  +2s warm\n") {
+		t.Errorf("Expected the newest mark first, got:\n%s", got)
+	}
+}
+
+func TestWriteReportValues(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(seconds(1, 1, 1, 1)...)
+	for i, v := range []float64{40, 3, 45} {
+		v := v
+		events[i+1].Value = &v
+	}
+	events[2].What = "load"
+	if err := WriteReport(&buf, events, "", ReportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect := "Values:\n  tick: n: 2, min: 40, avg: 42.5, max: 45\n  load: n: 1, min: 3, avg: 3, max: 3\n"
+	if got := buf.String(); !strings.Contains(got, expect) {
+		t.Fatalf("Expected report to contain:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestWriteReportFlags(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(seconds(6, 2, 1, 7, 1)...)
+	events[2].Flag, events[3].Flag = flagShort, flagShort
+	if err := WriteReport(&buf, events, "", ReportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Laps: 4, min: 1s, avg: 4s, max: 7s\nFlagged laps: short: 2\n") {
+		t.Errorf("Expected flagged lap count, got:\n%s", buf.String())
+	}
+}
+
+func TestWriteReportGroups(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(seconds(1, 3, 5, 2, 4, 1)...)
+	for i := 3; i < len(events); i++ {
+		events[i].Group = 1
+	}
+	events[3].What = labelReset + ":trial-2"
+	if err := WriteReport(&buf, events, "", ReportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect := "Groups:\n  0: laps: 2, min: 1s, avg: 2s, max: 3s\n  1 trial-2: laps: 2, min: 2s, avg: 3s, max: 4s\n"
+	if !strings.Contains(buf.String(), expect) {
+		t.Errorf("Expected report to contain:\n%s\ngot:\n%s", expect, buf.String())
+	}
+}
+
+func TestWriteReportBy(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(seconds(10, 30, 20, 5, 1)...)
+	events[1].What, events[2].What, events[3].What, events[4].What = "warmup", "run", "run", "cooldown"
+	if err := WriteReport(&buf, events, "", ReportOptions{By: "what"}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `By what:
+  what      laps  total  avg  share
+  run          2    50s  25s  75.8%
+  warmup       1    10s  10s  15.2%
+  cooldown     1     5s   5s   7.6%
+`
+	if !strings.Contains(buf.String(), expect) {
+		t.Errorf("Expected report to contain:\n%s\ngot:\n%s", expect, buf.String())
+	}
+}
+
+func TestLapsByAttribute(t *testing.T) {
+	events := testEvents(seconds(1, 2, 4, 1)...)
+	events[1].Attrs = map[string]string{"step": "a"}
+	events[2].Attrs = map[string]string{"step": "b"}
+	events[3].Attrs = map[string]string{"step": "a"}
+	var buf bytes.Buffer
+	if err := writeLapTotalsCSV(&buf, "step", lapsBy(events, "step"), ComputeStats(events).Active); err != nil {
+		t.Fatal(err)
+	}
+	expect := "step,laps,total,avg,share\na,2,5,2.5,62.5\nb,1,2,2,25.0\n"
+	if got := buf.String(); got != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
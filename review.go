@@ -0,0 +1,119 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// reviewHelp lists the commands of reviewEvents
+const reviewHelp = "# Review: 'd <seq>' deletes, 'e <seq> <label>' relabels, 'l' lists, <enter> writes"
+
+// reviewEvents lets the user delete and relabel events before they are
+// written, reading commands from in until an empty line or EOF. The events
+// keep their sequence numbers during the review, and are renumbered at the
+// end, from the first one on, so that events dropped by -keep-last still
+// show as a gap. The sentinels can not be deleted, and only labels typed at
+// the prompt can be changed.
+func reviewEvents(in *bufio.Reader, out io.Writer, events []Event) []Event {
+	events = append([]Event(nil), events...)
+	first := int64(0)
+	if len(events) > 0 {
+		first = events[0].Seq
+	}
+	writeEventList(out, events, 0)
+	fmt.Fprintln(out, reviewHelp)
+	for {
+		fmt.Fprint(out, "# review> ")
+		line, err := in.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if err != nil {
+				fmt.Fprintln(out)
+			}
+			break
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		seqText, label, _ := strings.Cut(strings.TrimSpace(arg), " ")
+		i := reviewIndex(events, seqText)
+		switch {
+		case cmd == "l" && arg == "":
+			writeEventList(out, events, 0)
+		case cmd != "d" && cmd != "e":
+			fmt.Fprintln(out, reviewHelp)
+		case i < 0:
+			fmt.Fprintf(out, "# No event with seq %q\n", seqText)
+		case cmd == "d" && label == "":
+			if isSentinel(events[i].What) {
+				fmt.Fprintf(out, "# Event %d is %q, can not delete\n", events[i].Seq, events[i].What)
+				break
+			}
+			fmt.Fprintf(out, "# Deleted %d %s\n", events[i].Seq, events[i].What)
+			events = append(events[:i], events[i+1:]...)
+		case cmd == "e" && label != "":
+			label = strings.TrimSpace(label)
+			if isReserved(events[i].What) || isReserved(label) {
+				fmt.Fprintf(out, "# Can not relabel %q as %q\n", events[i].What, label)
+				break
+			}
+			fmt.Fprintf(out, "# Relabeled %d %s -> %s\n", events[i].Seq, events[i].What, label)
+			events[i].What = label
+		default:
+			fmt.Fprintln(out, reviewHelp)
+		}
+		if err != nil {
+			fmt.Fprintln(out)
+			break
+		}
+	}
+	for i := range events {
+		events[i].Seq = first + int64(i)
+	}
+	return events
+}
+
+// reviewIndex returns the index of the event with the sequence number in
+// text, or -1
+func reviewIndex(events []Event, text string) int {
+	seq, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return -1
+	}
+	for i, evt := range events {
+		if evt.Seq == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+// interruptible returns a reader of r that reaches EOF once stop is
+// signalled, even while a read from r is blocked
+func interruptible(r io.Reader, stop <-chan struct{}) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, r)
+		pw.CloseWithError(err)
+	}()
+	go func() {
+		<-stop
+		pw.Close()
+	}()
+	return pr
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReviewEvents(t *testing.T) {
+	events := testEvents(seconds(1, 2, 3, 4)...)
+	events[2].What = labelMarkPrefix + "x"
+	input := "d 1\nd 0\ne 3 third lap\ne 2 y\nd 9\nx\nd 3\n"
+	var out bytes.Buffer
+	got := reviewEvents(bufio.NewReader(strings.NewReader(input)), &out, events)
+
+	var whats []string
+	for i, evt := range got {
+		if evt.Seq != int64(i) {
+			t.Errorf("Expected events to be renumbered, got %+v", evt)
+		}
+		whats = append(whats, evt.What)
+	}
+	if want := []string{labelEnter, "mark:x", labelExit}; !reflect.DeepEqual(whats, want) {
+		t.Errorf("Expected %q, got %q", want, whats)
+	}
+	if events[1].What != labelTick || events[3].Seq != 3 {
+		t.Error("Expected the original events to be left alone")
+	}
+	for _, msg := range []string{
+		"#    1 " + events[1].Timestamp.Local().Format("15:04:05") + " +1s         tick    1s\n",
+		"# Deleted 1 tick\n",
+		"# Event 0 is \"enter\", can not delete\n",
+		"# Relabeled 3 tick -> third lap\n",
+		"# Can not relabel \"mark:x\" as \"y\"\n",
+		"# No event with seq \"9\"\n",
+		reviewHelp + "\n",
+		"# Deleted 3 third lap\n",
+	} {
+		if !strings.Contains(out.String(), msg) {
+			t.Errorf("Expected output to contain %q, got:\n%s", msg, out.String())
+		}
+	}
+
+	// an empty line ends the review
+	got = reviewEvents(bufio.NewReader(strings.NewReader("\nd 1\n")), &out, testEvents(time.Second, time.Second))
+	if len(got) != 3 {
+		t.Errorf("Expected the review to end at the empty line, got %v", got)
+	}
+}
+
+func TestReviewInterrupted(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	stop := make(chan struct{}, 1)
+	done := make(chan []Event)
+	events := testEvents(time.Second, time.Second)
+	events[1].What = "run"
+	go func() {
+		done <- reviewEvents(bufio.NewReader(interruptible(r, stop)), io.Discard, events)
+	}()
+	io.WriteString(w, "e 1 walk\n")
+	// once read, the edit has been passed on to the review
+	io.WriteString(w, "l\n")
+	// the next read blocks until the signal
+	stop <- struct{}{}
+	select {
+	case got := <-done:
+		if got[1].What != "walk" {
+			t.Errorf("Expected the edit before the signal to be kept, got %v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Review did not end at the signal")
+	}
+}
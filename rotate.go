@@ -0,0 +1,115 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag.Value for sizes such as "50MB" or "64KiB". Decimal
+// (kB, MB, GB) and binary (KiB, MiB, GiB) units are accepted; plain numbers
+// are bytes.
+type byteSize int64
+
+var byteUnits = map[string]int64{
+	"": 1, "B": 1,
+	"K": 1000, "KB": 1000, "M": 1000 * 1000, "MB": 1000 * 1000, "G": 1000 * 1000 * 1000, "GB": 1000 * 1000 * 1000,
+	"KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30,
+}
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	digits := strings.TrimRight(s, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")
+	unit, ok := byteUnits[strings.ToUpper(strings.TrimSpace(s[len(digits):]))]
+	n, err := strconv.ParseInt(strings.TrimSpace(digits), 10, 64)
+	if !ok || err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * unit)
+	return nil
+}
+
+// rotateParts splits events into the parts written into separate files,
+// each of at most opts.RotateEvents events and opts.RotateSize bytes when
+// encoded. A part always holds at least one event, so an event larger than
+// the size limit gets a file of its own. The size is measured without
+// compression and encryption.
+func rotateParts(encode Encoder, events []Event, opts OutputOptions) ([][]Event, error) {
+	if opts.RotateEvents <= 0 && opts.RotateSize <= 0 {
+		return [][]Event{events}, nil
+	}
+	var parts [][]Event
+	for start := 0; start < len(events); {
+		n := len(events) - start
+		if opts.RotateEvents > 0 && n > opts.RotateEvents {
+			n = opts.RotateEvents
+		}
+		if opts.RotateSize > 0 {
+			// the largest part that fits: sizes grow with the event count
+			var err error
+			k := sort.Search(n, func(k int) bool {
+				if err != nil {
+					return true
+				}
+				var size int64
+				size, err = encodedSize(encode, events[start:start+k+1], opts)
+				return size > opts.RotateSize
+			})
+			if err != nil {
+				return nil, err
+			}
+			if k > 0 {
+				n = k
+			} else {
+				n = 1
+			}
+		}
+		parts = append(parts, events[start:start+n])
+		start += n
+	}
+	return parts, nil
+}
+
+// encodedSize returns the size of events encoded with encode
+func encodedSize(encode Encoder, events []Event, opts OutputOptions) (int64, error) {
+	var c countWriter
+	err := encode(&c, events, opts)
+	return int64(c), err
+}
+
+// countWriter counts the bytes written into it
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
+
+// rotatedName returns the file name of part i of a rotated output: the
+// first part is written into path itself, part i into "<base>.<i><ext>"
+func rotatedName(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), i, ext)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestByteSize(t *testing.T) {
+	for input, want := range map[string]int64{"0": 0, "512": 512, "10B": 10, "50MB": 50e6, "50mb": 50e6,
+		"2k": 2000, "64KiB": 64 << 10, "1 GiB": 1 << 30} {
+		var b byteSize
+		if err := b.Set(input); err != nil || int64(b) != want {
+			t.Errorf("%q: expected %d, got %d, %v", input, want, b, err)
+		}
+	}
+	for _, input := range []string{"", "MB", "1.5MB", "-1", "10XB", "5 M B"} {
+		var b byteSize
+		if err := b.Set(input); err == nil {
+			t.Errorf("Expected error for %q, got %d", input, b)
+		}
+	}
+}
+
+func TestRotateParts(t *testing.T) {
+	events := testEvents(seconds(1, 1, 1, 1, 1, 1, 1)...)
+	count := func(parts [][]Event) []int {
+		var n []int
+		for _, p := range parts {
+			n = append(n, len(p))
+		}
+		return n
+	}
+	parts, err := rotateParts(EncodeCSV, events, OutputOptions{RotateEvents: 3})
+	if err != nil || !reflect.DeepEqual(count(parts), []int{3, 3, 2}) {
+		t.Errorf("Expected parts of 3, 3 and 2 events, got %v, %v", count(parts), err)
+	}
+
+	// header (12 bytes), comment (5 bytes) and schema (22 bytes) plus 3
+	// events of 34 bytes
+	opts := OutputOptions{Comment: "ab", RotateSize: 12 + 5 + 22 + 3*34}
+	parts, err = rotateParts(EncodeCSV, events, opts)
+	if err != nil || !reflect.DeepEqual(count(parts), []int{3, 3, 2}) {
+		t.Errorf("Expected parts of 3, 3 and 2 events, got %v, %v", count(parts), err)
+	}
+	for _, part := range parts {
+		if size, _ := encodedSize(EncodeCSV, part, opts); size > opts.RotateSize {
+			t.Errorf("Part of %d bytes exceeds the limit", size)
+		}
+	}
+	opts.RotateSize, opts.RotateEvents = 1, 5
+	if parts, _ := rotateParts(EncodeCSV, events, opts); len(parts) != len(events) {
+		t.Errorf("Expected one event per part when none fits, got %v", count(parts))
+	}
+}
+
+func TestDumpEventsRotate(t *testing.T) {
+	dir := t.TempDir()
+	events := testEvents(seconds(1, 1, 1, 1, 1, 1, 1)...)
+	path := filepath.Join(dir, "out.csv")
+	if err := DumpEvents(path, events, OutputOptions{Comment: "run", RotateEvents: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"out.csv", "out.1.csv", "out.2.csv"} {
+		got, comment, err := LoadCSV(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment != "run" || !reflect.DeepEqual(got, events[3*i:minInt(3*i+3, len(events))]) {
+			t.Errorf("%s: unexpected contents %q %v", name, comment, got)
+		}
+	}
+
+	// the parts are put back together in order, even past ten of them
+	events = testEvents(seconds(1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1)...)
+	if err := DumpEvents(path, events, OutputOptions{RotateEvents: 1}); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := LoadCSVFiles([]string{filepath.Join(dir, "out*.csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, events) {
+		t.Errorf("Expected the original events, got %v", got)
+	}
+	if _, _, err := LoadCSVFiles([]string{filepath.Join(dir, "missing*.csv")}); err == nil {
+		t.Error("Expected error for a pattern without matches")
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,280 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// Option configures Run, see the With functions
+type Option func(*runConfig)
+
+type runConfig struct {
+	input    io.Reader
+	editor   *lineEditor // reads the input instead, see withLineEditor
+	interval time.Duration
+	signals  []os.Signal
+	timeout  time.Duration
+	msgs     io.Writer
+	comment  string
+	opts     collectOptions
+	remote   *remoteInput // the listeners set up by the caller, if any
+
+	sourceLabels, sourcePrefixes []string // source=value pairs, see parseSourceMap
+}
+
+// WithInput reads ticks and interactive commands from r, one per line, as
+// typed into the terminal. The run ends at the end of r.
+func WithInput(r io.Reader) Option {
+	return func(c *runConfig) { c.input = r }
+}
+
+// WithInterval records a tick every d, timed from the start of the run
+func WithInterval(d time.Duration) Option {
+	return func(c *runConfig) { c.interval = d }
+}
+
+// WithSignals records a tick whenever one of the signals is received, with
+// the signal in a "signal" attribute. The signals are captured only while
+// the run goes on.
+func WithSignals(signals ...os.Signal) Option {
+	return func(c *runConfig) { c.signals = append(c.signals, signals...) }
+}
+
+// WithLabels gives the plain ticks these labels in turn, starting over
+// after the last one, like -labels
+func WithLabels(labels ...string) Option {
+	return func(c *runConfig) { c.opts.Labels = append(c.opts.Labels, labels...) }
+}
+
+// WithTickLabel gives the plain ticks the label instead of "tick", like
+// -tick-label
+func WithTickLabel(label string) Option {
+	return func(c *runConfig) { c.opts.TickLabel = label }
+}
+
+// WithSourceLabel gives the plain ticks of the input source, e.g. "signal"
+// for those of WithSignals, the label instead of WithLabels or
+// WithTickLabel, like -source-label
+func WithSourceLabel(source, label string) Option {
+	return func(c *runConfig) { c.sourceLabels = append(c.sourceLabels, source+"="+label) }
+}
+
+// WithSourcePrefix prepends prefix to the labels of the ticks of the input
+// source, like -source-prefix
+func WithSourcePrefix(source, prefix string) Option {
+	return func(c *runConfig) { c.sourcePrefixes = append(c.sourcePrefixes, source+"="+prefix) }
+}
+
+// WithMaxEvents ends the run once n events have been recorded after
+// "enter"
+func WithMaxEvents(n int) Option {
+	return func(c *runConfig) { c.opts.MaxEvents = n }
+}
+
+// WithTimeout ends the run after d
+func WithTimeout(d time.Duration) Option {
+	return func(c *runConfig) { c.timeout = d }
+}
+
+// WithEventFunc calls fn with every event as soon as it is recorded,
+// including "enter" and "exit". The calls are made one at a time, from the
+// goroutine recording the event; fn should return quickly, as the recording
+// waits for it.
+func WithEventFunc(fn func(Event)) Option {
+	return func(c *runConfig) { c.opts.Sinks = append(c.opts.Sinks, eventFunc(fn)) }
+}
+
+// WithMessages writes the prompts and messages a terminal user would see
+// into w, e.g. "not recorded" for a reserved label. They are discarded by
+// default.
+func WithMessages(w io.Writer) Option {
+	return func(c *runConfig) { c.msgs = w }
+}
+
+// WithComment sets the comment of the session, written into the output
+func WithComment(comment string) Option {
+	return func(c *runConfig) { c.comment = comment }
+}
+
+// withCollectOptions replaces the collect options set so far, for the CLI
+func withCollectOptions(opts collectOptions) Option {
+	return func(c *runConfig) { c.opts = opts }
+}
+
+// withRemote records the lines received by in, whose listeners are set up
+// by the caller; see remoteInput
+func withRemote(in *remoteInput) Option {
+	return func(c *runConfig) { c.remote = in }
+}
+
+// withLineEditor reads the lines with e, from the terminal of -line-editor,
+// instead of from the WithInput reader
+func withLineEditor(e *lineEditor) Option {
+	return func(c *runConfig) { c.editor = e }
+}
+
+// sources returns the inputs of the run: stdin, the interval timer and
+// the signal handler, as they are given
+func (c *runConfig) sources() []string {
+	var sources []string
+	if c.input != nil || c.editor != nil {
+		sources = append(sources, sourceStdin)
+	}
+	if c.interval > 0 {
+		sources = append(sources, sourceTimer)
+	}
+	if len(c.signals) > 0 {
+		sources = append(sources, sourceSignal)
+	}
+	return sources
+}
+
+// eventFunc is the sink of WithEventFunc
+type eventFunc func(Event)
+
+func (fn eventFunc) Send(evt Event) { fn(evt) }
+func (eventFunc) Close(Stats)       {}
+
+// Run records a session until ctx is cancelled, or the run ends by one of
+// the options, and returns its events. Whatever ends the run, the events
+// recorded so far are returned with the "exit" event, and the error is
+// nil: an error is returned only for invalid options, before anything is
+// recorded.
+func Run(ctx context.Context, opts ...Option) ([]Event, error) {
+	sess, _, err := run(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return sess.Events, nil
+}
+
+// run is Run returning the session, and whether it ended at the end of the
+// WithInput reader
+func run(ctx context.Context, opts ...Option) (*Session, bool, error) {
+	c := runConfig{msgs: io.Discard}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	switch {
+	case c.interval < 0:
+		return nil, false, errors.New("the interval must not be negative")
+	case c.timeout < 0:
+		return nil, false, errors.New("the timeout must not be negative")
+	case c.opts.MaxEvents < 0:
+		return nil, false, errors.New("the maximum number of events must not be negative")
+	case isReserved(c.opts.TickLabel):
+		return nil, false, fmt.Errorf("the tick label %q is reserved", c.opts.TickLabel)
+	}
+	var err error
+	if c.sourceLabels != nil {
+		if c.opts.SourceLabels, err = parseSourceMap("source-label", c.sourceLabels, c.sources(), true); err != nil {
+			return nil, false, err
+		}
+	}
+	if c.sourcePrefixes != nil {
+		if c.opts.SourcePrefixes, err = parseSourceMap("source-prefix", c.sourcePrefixes, c.sources(), false); err != nil {
+			return nil, false, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	var lines chan inputLine
+	inputEnded := make(chan struct{})
+	if c.input != nil || c.editor != nil {
+		lines = make(chan inputLine)
+		go func() {
+			read := func() bool { return readLines(ctx, c.input, lines) }
+			if c.editor != nil {
+				read = func() bool { return readEdited(ctx, c.editor, lines) }
+			}
+			if read() {
+				close(inputEnded)
+				cancel()
+			}
+		}()
+	}
+	remote := c.remote
+	if remote == nil {
+		remote = newRemoteInput()
+	}
+	if c.interval > 0 {
+		remote.start("the interval timer", intervalSource(c.interval))
+	}
+	if len(c.signals) > 0 {
+		remote.start("the signal handler", newSignalSource(c.signals))
+	}
+
+	sess := newSession(c.comment, c.opts)
+	collect(ctx, lines, remote, sess, c.msgs)
+	select {
+	case <-inputEnded:
+		return sess, true, nil
+	default:
+		return sess, false, nil
+	}
+}
+
+// intervalSource is a tickSource of a tick every interval
+type intervalSource time.Duration
+
+func (interval intervalSource) run(ctx context.Context, emit func(req remoteLine) string) error {
+	ticker := time.NewTicker(time.Duration(interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case at := <-ticker.C:
+			emit(remoteLine{source: sourceTimer, at: at, exact: true, async: true})
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// signalSource is a tickSource of a tick for each of the signals received.
+// They are caught from newSignalSource on, so none is missed before run.
+type signalSource struct {
+	received chan os.Signal
+}
+
+func newSignalSource(signals []os.Signal) signalSource {
+	s := signalSource{received: make(chan os.Signal, 1)}
+	signal.Notify(s.received, signals...)
+	return s
+}
+
+func (s signalSource) run(ctx context.Context, emit func(req remoteLine) string) error {
+	defer signal.Stop(s.received)
+	for {
+		select {
+		case sig := <-s.received:
+			emit(remoteLine{source: sourceSignal, at: time.Now(), exact: true, async: true,
+				attrs: map[string]string{"signal": sig.String()}})
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ExampleRun() {
+	// three ticks, one every 10ms, named in turn
+	events, err := Run(context.Background(),
+		WithInterval(10*time.Millisecond),
+		WithLabels("lap-1", "lap-2", "lap-3"),
+		WithMaxEvents(3),
+	)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, evt := range events {
+		fmt.Println(evt.Seq, evt.What)
+	}
+	// Output:
+	// 0 enter
+	// 1 lap-1
+	// 2 lap-2
+	// 3 lap-3
+	// 4 exit
+}
+
+func TestRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var seen []string
+	ticks := 0
+	events, err := Run(ctx, WithInterval(time.Millisecond), WithEventFunc(func(evt Event) {
+		seen = append(seen, evt.What)
+		if evt.What == labelTick {
+			if ticks++; ticks == 2 {
+				cancel()
+			}
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 4 || events[0].What != labelEnter || events[len(events)-1].What != labelExit {
+		t.Fatalf("Expected the ticks between enter and exit, got %v", events)
+	}
+	if len(seen) != len(events) {
+		t.Errorf("Expected every event to be passed on, got %v", seen)
+	}
+
+	// cancelled before the start, there is still enter and exit
+	events, err = Run(ctx)
+	if err != nil || len(events) != 2 || events[1].What != labelExit {
+		t.Errorf("Expected enter and exit, got %v, %v", events, err)
+	}
+}
+
+func TestRunInput(t *testing.T) {
+	input := strings.NewReader("first\nexit\nlast\nsecond\n")
+	var msgs strings.Builder
+	events, err := Run(context.Background(), WithInput(input), WithMaxEvents(2), WithComment("run 1"), WithMessages(&msgs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, evt := range events {
+		got = append(got, evt.What)
+	}
+	if want := "enter first last exit"; strings.Join(got, " ") != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if !strings.Contains(msgs.String(), `Label "exit" is reserved`) || !strings.Contains(msgs.String(), "# Reached 2 events, stopping") {
+		t.Errorf("Expected the messages, got %q", msgs.String())
+	}
+
+	// the end of the input ends the run
+	sess, ended, err := run(context.Background(), WithInput(strings.NewReader("a\n")), WithTimeout(time.Minute))
+	if err != nil || !ended || sess.Comment != "" || len(sess.Events) != 3 {
+		t.Errorf("Expected the run to end with the input, got %v, %v, %v", sess.Events, ended, err)
+	}
+	if _, err := Run(context.Background(), WithInterval(-time.Second)); err == nil {
+		t.Error("Expected an error for a negative interval")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	start := time.Now()
+	events, err := Run(context.Background(), WithTimeout(20*time.Millisecond))
+	if err != nil || len(events) != 2 || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Expected enter and exit after the timeout, got %v, %v", events, err)
+	}
+}
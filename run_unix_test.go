@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunSignals(t *testing.T) {
+	events, err := Run(context.Background(), WithSignals(syscall.SIGUSR1), WithMaxEvents(1), WithTimeout(5*time.Second),
+		WithEventFunc(func(evt Event) {
+			if evt.What == labelEnter {
+				go syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[1].What != labelTick || events[1].Source != sourceSignal || events[1].Attrs["signal"] != "user defined signal 1" {
+		t.Errorf("Expected a tick on the signal, got %+v", events)
+	}
+
+	events, err = Run(context.Background(), WithSignals(syscall.SIGUSR1), WithMaxEvents(1), WithTimeout(5*time.Second),
+		WithTickLabel("lap"), WithSourceLabel(sourceSignal, "checkpoint"), WithSourcePrefix(sourceSignal, "sig/"),
+		WithEventFunc(func(evt Event) {
+			if evt.What == labelEnter {
+				go syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+			}
+		}))
+	if err != nil || len(events) != 3 || events[1].What != "sig/checkpoint" {
+		t.Errorf("Expected the label of the source, got %+v, %v", events, err)
+	}
+	if _, err := Run(context.Background(), WithSourceLabel(sourceUDP, "door")); err == nil {
+		t.Error("Expected an error for a source not in the run")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attrSuppressed is the attribute telling how many ticks a sampler
+// suppressed before the one recorded
+const attrSuppressed = "suppressed"
+
+// sampler decides which ticks of the high-volume inputs are recorded, see
+// -sample and -sample-interval. Sentinels, commands and the ticks typed on
+// a terminal are never sampled.
+type sampler interface {
+	// sample reports whether the tick labeled label at the time at is
+	// recorded, and if so, how many ticks were suppressed before it
+	sample(label string, at time.Time) (keep bool, suppressed int)
+}
+
+// everyNth keeps the first of every n ticks
+type everyNth struct {
+	n    int
+	seen int // ticks seen since the last one kept, including it
+}
+
+// ParseSampleRate parses the rate of -sample, e.g. "1/10" to keep every
+// 10th tick
+func ParseSampleRate(spec string) (sampler, error) {
+	one, n, found := strings.Cut(spec, "/")
+	count, err := strconv.Atoi(n)
+	if !found || strings.TrimSpace(one) != "1" || err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid sample rate %q, expected 1/N", spec)
+	}
+	return &everyNth{n: count}, nil
+}
+
+func (s *everyNth) sample(label string, at time.Time) (bool, int) {
+	if s.seen > 0 && s.seen < s.n {
+		s.seen++
+		return false, 0
+	}
+	suppressed := 0
+	if s.seen > 0 {
+		suppressed = s.seen - 1
+	}
+	s.seen = 1
+	return true, suppressed
+}
+
+// perInterval keeps at most one tick per interval of each label
+type perInterval struct {
+	interval time.Duration
+	last     map[string]time.Time // when each label was last kept
+	skipped  map[string]int       // ticks of each label suppressed since
+}
+
+func newPerInterval(interval time.Duration) *perInterval {
+	return &perInterval{interval: interval, last: make(map[string]time.Time), skipped: make(map[string]int)}
+}
+
+func (s *perInterval) sample(label string, at time.Time) (bool, int) {
+	if last, ok := s.last[label]; ok && at.Sub(last) < s.interval {
+		s.skipped[label]++
+		return false, 0
+	}
+	suppressed := s.skipped[label]
+	s.last[label], s.skipped[label] = at, 0
+	return true, suppressed
+}
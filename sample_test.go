@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseSampleRate(t *testing.T) {
+	for _, spec := range []string{"1/10", "1/1", " 1/3"} {
+		if _, err := ParseSampleRate(spec); err != nil {
+			t.Errorf("ParseSampleRate(%q): unexpected error %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "10", "2/10", "1/0", "1/-2", "1/x"} {
+		if _, err := ParseSampleRate(spec); err == nil {
+			t.Errorf("ParseSampleRate(%q): expected an error", spec)
+		}
+	}
+}
+
+// sampleAll runs the ticks labeled labels, one per second, through s and
+// returns the indexes of those kept with the counts of suppressed ticks
+func sampleAll(s sampler, labels ...string) map[int]int {
+	t0 := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	kept := make(map[int]int)
+	for i, label := range labels {
+		if keep, suppressed := s.sample(label, t0.Add(time.Duration(i)*time.Second)); keep {
+			kept[i] = suppressed
+		}
+	}
+	return kept
+}
+
+func TestEveryNth(t *testing.T) {
+	s, _ := ParseSampleRate("1/3")
+	got := sampleAll(s, "a", "b", "a", "a", "b", "a", "a")
+	want := map[int]int{0: 0, 3: 2, 6: 2}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i, n := range want {
+		if got[i] != n {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	}
+	if got := sampleAll(&everyNth{n: 1}, "a", "a", "a"); len(got) != 3 {
+		t.Errorf("Expected 1/1 to keep all, got %v", got)
+	}
+}
+
+func TestPerInterval(t *testing.T) {
+	// a tick per second: every third of each label is kept
+	got := sampleAll(newPerInterval(2500*time.Millisecond), "a", "b", "a", "b", "a", "a", "b", "a")
+	want := map[int]int{0: 0, 1: 0, 4: 1, 6: 1, 7: 1}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i, n := range want {
+		if got[i] != n {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSessionSample(t *testing.T) {
+	sess := newSession("", collectOptions{Sampler: &everyNth{n: 2}})
+	sess.start()
+	var out bytes.Buffer
+	for i := 0; i < 5; i++ {
+		sess.handleInput(sourceUDP, time.Time{}, func() { sess.handleLine("door", &out) })
+		// typed on a terminal, never sampled
+		sess.handleLine("typed", &out)
+	}
+	sess.handleInput(sourceUDP, time.Time{}, func() { sess.handleLine("mark m", &out) })
+	sess.finish()
+
+	var udp, typed int
+	for _, evt := range sess.Events {
+		switch evt.What {
+		case "door":
+			udp++
+			if evt.Seq > 1 && evt.Attrs[attrSuppressed] != "1" {
+				t.Errorf("Expected 1 suppressed before %+v", evt)
+			}
+		case "typed":
+			typed++
+		}
+	}
+	if udp != 3 || typed != 5 {
+		t.Errorf("Expected 3 sampled and 5 typed ticks, got %d and %d: %v", udp, typed, sess.Events)
+	}
+	if last := sess.Events[len(sess.Events)-2]; last.What != "mark:m" {
+		t.Errorf("Expected the mark to be recorded, got %v", last)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// partialSuffix is appended to the -o file name for the snapshots of the
+// "save" command
+const partialSuffix = ".partial"
+
+// snapshotSaver returns the function of collectOptions.Save, writing the
+// events into path, or else into outFile with partialSuffix, as opts
+// would write the output
+func snapshotSaver(outFile string, opts OutputOptions) func(path string, events []Event, comment string) (string, error) {
+	return func(path string, events []Event, comment string) (string, error) {
+		if path == "" {
+			if isStream(outFile) {
+				return "", errors.New("the output is not a file; give a path, e.g. 'save partial.csv'")
+			}
+			path = outFile + partialSuffix
+		}
+		snap := opts
+		snap.Comment = comment
+		return path, saveSnapshot(path, events, snap)
+	}
+}
+
+// saveSnapshot writes events into the file path in one go: encoded first,
+// and then renamed over path, so that a snapshot is never seen partially
+// written. Unlike DumpEvents, the events always go into the one file.
+func saveSnapshot(path string, events []Event, opts OutputOptions) error {
+	encode, events, opts, err := prepareOutput(events, singleFile(opts))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeEncoded(&buf, encode, events, opts); err != nil {
+		return err
+	}
+	if opts.MkDirs {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// cmdSave writes the events recorded so far into the file arg, or next to
+// the output file, see snapshotSaver. The session goes on as before.
+func (s *Session) cmdSave(arg string, out io.Writer) {
+	if s.opts.Save == nil {
+		fmt.Fprintln(out, "# Saving is not available here")
+		return
+	}
+	events := append([]Event(nil), s.Events...)
+	if s.resequence {
+		for i := range events {
+			events[i].Seq = s.seq(s.Dropped + i)
+		}
+	}
+	path, err := s.opts.Save(arg, events, droppedComment(s.Comment, s.Dropped))
+	if err != nil {
+		if path == "" {
+			fmt.Fprintln(out, "# WARNING: could not save:", err)
+		} else {
+			fmt.Fprintf(out, "# WARNING: could not save into %s: %v\n", path, err)
+		}
+		return
+	}
+	fmt.Fprintf(out, "# Saved %d events into %s\n", len(events), path)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionSave(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.csv")
+	sess := newSession("run 1", collectOptions{Save: snapshotSaver(out, OutputOptions{Format: "csv"})})
+	var msgs bytes.Buffer
+	sess.start()
+	for _, line := range []string{"a", "save", "b", "save", "save " + filepath.Join(dir, "mid.csv"),
+		"save " + filepath.Join(dir, "missing", "x.csv")} {
+		sess.handleLine(line, &msgs)
+	}
+	if len(sess.Events) != 3 {
+		t.Errorf("Expected the session to go on, got %v", sess.Events)
+	}
+	// the second save replaced the first
+	for _, path := range []string{out + partialSuffix, filepath.Join(dir, "mid.csv")} {
+		events, comment, err := LoadCSV(path)
+		if err != nil || comment != "run 1" || len(events) != 3 || events[2].What != "b" {
+			t.Errorf("%s: expected the 3 events so far, got %v, %q, %v", path, events, comment, err)
+		}
+	}
+	want := "# Saved 2 events into " + out + partialSuffix + "\n" +
+		"# Saved 3 events into " + out + partialSuffix + "\n" +
+		"# Saved 3 events into " + filepath.Join(dir, "mid.csv") + "\n" +
+		"# WARNING: could not save into " + filepath.Join(dir, "missing", "x.csv") + ": "
+	if !strings.HasPrefix(msgs.String(), want) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, msgs.String())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected no temporary files left, got %v", entries)
+	}
+
+	msgs.Reset()
+	sess.opts.Save = snapshotSaver("-", OutputOptions{})
+	sess.handleLine("save", &msgs)
+	if got := msgs.String(); !strings.Contains(got, "could not save: the output is not a file") {
+		t.Errorf("Expected the path to be asked for, got %q", got)
+	}
+}
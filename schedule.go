@@ -0,0 +1,69 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// parseAt parses the -at flag: a time of day today (15:04:05 or 15:04, in
+// local time) or a full RFC 3339 timestamp
+func parseAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			y, m, d := now.Date()
+			return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected HH:MM[:SS] or an RFC 3339 timestamp, got %q", s)
+}
+
+// countdownInterval is how often waitUntil updates the countdown and
+// re-checks the clock
+const countdownInterval = time.Second
+
+// waitUntil waits until the wall clock reaches t, showing a countdown on
+// out. The clock is re-checked at every countdownInterval, so adjustments
+// of the system clock during the wait are followed. Returns false if ctx
+// is cancelled first.
+func waitUntil(ctx context.Context, t time.Time, out io.Writer) bool {
+	for {
+		// Round(0) drops the monotonic reading, to compare wall clock times
+		left := t.Sub(time.Now().Round(0))
+		if left <= 0 {
+			fmt.Fprintln(out)
+			return true
+		}
+		fmt.Fprintf(out, "\r# Starting in %s ", formatDuration(left.Round(time.Second)))
+		sleep := left
+		if sleep > countdownInterval {
+			sleep = countdownInterval
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			fmt.Fprintln(out)
+			return false
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseAt(t *testing.T) {
+	loc := time.FixedZone("EEST", 3*60*60)
+	now := time.Date(2022, 4, 8, 13, 30, 0, 0, loc)
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"14:00:00", time.Date(2022, 4, 8, 14, 0, 0, 0, loc)},
+		{"14:00", time.Date(2022, 4, 8, 14, 0, 0, 0, loc)},
+		{"09:15:30", time.Date(2022, 4, 8, 9, 15, 30, 0, loc)},
+		{"2022-04-09T14:00:00Z", time.Date(2022, 4, 9, 14, 0, 0, 0, time.UTC)},
+	} {
+		got, err := parseAt(tc.in, now)
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("%q: expected %v, got %v (%v)", tc.in, tc.want, got, err)
+		}
+	}
+	for _, in := range []string{"", "14", "25:00", "tomorrow", "2022-04-09"} {
+		if _, err := parseAt(in, now); err == nil {
+			t.Errorf("Expected error for %q", in)
+		}
+	}
+}
+
+func TestWaitUntil(t *testing.T) {
+	var out bytes.Buffer
+	start := time.Now()
+	if !waitUntil(context.Background(), start.Add(30*time.Millisecond), &out) {
+		t.Error("Expected the wait to complete")
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Returned too early, after %v", elapsed)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("# Starting in ")) {
+		t.Errorf("Expected a countdown, got %q", out.String())
+	}
+
+	// in the past: returns at once
+	if !waitUntil(context.Background(), start.Add(-time.Hour), &out) {
+		t.Error("Expected a past time not to wait")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if waitUntil(ctx, time.Now().Add(time.Hour), &out) {
+		t.Error("Expected a cancelled wait to return false")
+	}
+}
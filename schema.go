@@ -0,0 +1,119 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schemaVersion is the version of the layout of the CSV files written,
+// given in their "# stopwatch-schema: N" line. It is to be bumped, with a
+// new entry in schemas, when the layout changes so that older versions of
+// the program would misread the files.
+const schemaVersion = 2
+
+// schemaPrefix starts the line of the schema version, written after the
+// comment
+const schemaPrefix = "# stopwatch-schema: "
+
+// legacySchema is the version of the files without a schema line
+const legacySchema = 1
+
+// schema describes a version of the layout of the CSV files
+type schema struct {
+	required []string // the columns every file has
+	metadata bool     // whether lines such as the ts-start may follow the comment
+}
+
+// schemas are the layouts by version:
+//
+//  1. the legacy layout, without the schema line: a header with the seq,
+//     ts and what columns, and any optional or attribute columns after the
+//     comment line
+//  2. the current layout: like 1, with the schema line and the other
+//     metadata lines, such as the ts-start of -ts-style offset-seconds and
+//     the environment of -with-env, after the comment. Older versions of
+//     the program skip the metadata lines they do not know.
+var schemas = map[int]schema{
+	1: {required: []string{"seq", "ts", "what"}},
+	2: {required: []string{"seq", "ts", "what"}, metadata: true},
+}
+
+// formatSchemaLine formats the line of schemaPrefix, without the line end
+func formatSchemaLine() string {
+	return schemaPrefix + strconv.Itoa(schemaVersion)
+}
+
+// lookupSchema returns the layout of the schema version, or an error for
+// a version written by a newer version of the program
+func lookupSchema(version int) (schema, error) {
+	s, ok := schemas[version]
+	if !ok {
+		return s, fmt.Errorf("unsupported schema version %d, expected at most %d: written by a newer stopwatch-go?",
+			version, schemaVersion)
+	}
+	return s, nil
+}
+
+// csvPreamble holds what the comment lines before the header tell: the
+// comment on the first line, and the metadata lines after it
+type csvPreamble struct {
+	comment string
+	version int         // the schema version, 0 until known
+	start   time.Time   // the ts-start, see tsStartPrefix
+	meta    []metaField // the lines of metaPrefix, in order
+	lines   int         // number of lines taken
+}
+
+// add takes the next line starting with '#' before the header, and reports
+// whether it belongs to the preamble. A line that does not is a comment
+// line to be skipped, and so are the lines after it. The line end may be
+// included in line.
+func (p *csvPreamble) add(line string) (bool, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, schemaPrefix) && p.version == 0 {
+		// the empty fields of a line written as a CSV record are ignored
+		version, err := strconv.Atoi(strings.TrimRight(line[len(schemaPrefix):], ",;\t"))
+		if err != nil {
+			return false, fmt.Errorf("invalid schema version in %q", line)
+		}
+		if _, err := lookupSchema(version); err != nil {
+			return false, err
+		}
+		p.version = version
+	} else if start, ok := parseTSStart(line); ok && p.schema().metadata {
+		p.start = start
+	} else if field, ok := parseMetaLine(line); ok && p.schema().metadata {
+		p.meta = append(p.meta, field)
+	} else if p.lines == 0 {
+		p.comment = strings.TrimPrefix(strings.TrimPrefix(line, "#"), " ")
+	} else {
+		return false, nil
+	}
+	p.lines++
+	return true, nil
+}
+
+// schema returns the layout of the file, the legacy one without a schema
+// line
+func (p *csvPreamble) schema() schema {
+	if p.version == 0 {
+		return schemas[legacySchema]
+	}
+	return schemas[p.version]
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalEventsCSVLegacy(t *testing.T) {
+	// without a schema line, the ts-start line is just a comment
+	events, comment, err := LoadCSV("testdata/legacy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := 3.0
+	want := testEvents(1500*time.Millisecond, 1500*time.Millisecond)
+	want[1].Value = &v
+	for i := range want {
+		want[i].Timestamp = want[i].Timestamp.UTC()
+	}
+	if comment != "written before the schema line" || !reflect.DeepEqual(events, want) {
+		t.Errorf("Expected %v, got %v, %q", want, events, comment)
+	}
+}
+
+func TestUnmarshalEventsCSVSchema(t *testing.T) {
+	for data, want := range map[string]string{
+		"# stopwatch-schema: 3\nseq,ts,what\n":           "line 1: unsupported schema version 3, expected at most 2",
+		"# run\n# stopwatch-schema: x\nseq,ts,what\n":    "line 2: invalid schema version",
+		"# stopwatch-schema: 2\nseq,what\n0,enter\n":     `missing column "ts"`,
+		"# stopwatch-schema: 2\nseq,ts,what\n0,1,exit\n": "",
+	} {
+		_, _, err := UnmarshalEventsCSV(strings.NewReader(data))
+		if want == "" && err != nil || want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Errorf("%q: expected error %q, got %v", data, want, err)
+		}
+	}
+	// a second schema line is not a part of the preamble
+	var p csvPreamble
+	for _, line := range []string{"# run", "# stopwatch-schema: 2"} {
+		if ok, err := p.add(line); !ok || err != nil {
+			t.Errorf("%q: expected it in the preamble, got %v", line, err)
+		}
+	}
+	if ok, err := p.add("# stopwatch-schema: 2"); ok || err != nil || p.comment != "run" || p.version != 2 {
+		t.Errorf("Expected a second schema line to end the preamble, got %v, %v, %+v", ok, err, p)
+	}
+}
+
+func TestValidateFileSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "future.csv")
+	data := "# stopwatch-schema: 3\nseq,ts,what\n0,2022-04-08T20:00:00Z,enter\n1,2022-04-08T20:00:01Z,exit\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v := validateFile(path)
+	if v.Pass || len(v.Findings) != 1 || !strings.Contains(v.Findings[0].Message, "unsupported schema version 3") {
+		t.Errorf("Expected the version to fail, got %v", v)
+	}
+	if v := validateFile("testdata/legacy.csv"); !v.Pass {
+		t.Errorf("Expected the legacy file to pass, got %v", v)
+	}
+}
@@ -0,0 +1,1022 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Flags of the events closing a lap, see Event.Flag
+const (
+	flagShort = "short" // the lap is shorter than -min-lap
+	flagIdle  = "idle"  // the lap ends an idle gap, see -idle-after and -idle-flag
+)
+
+// Session holds the state of a recording session. It is updated by the
+// input loop and consulted when writing the output.
+type Session struct {
+	Events  []Event // with -keep-last, only the last events recorded
+	Comment string  // comment of the output file; initially the -c flag
+	Dropped int     // number of events discarded by -keep-last
+
+	opts     collectOptions
+	now      func() time.Time // clock, replaced in tests
+	paused   bool
+	pausedAt time.Time // timestamp of the pause, while paused
+	armed    bool      // waiting for the first tick to record "enter"
+	warned   int       // number of opts.WarnAt thresholds already crossed
+
+	overBudget map[string]bool // the labels whose opts.Budgets have run out, see checkBudgets
+
+	notifyFailed bool // a desktop notification failed, see notify
+
+	startAt  time.Time // timestamp of "enter"
+	eventBuf []Event   // storage of Events with -keep-last, see appendEvent
+
+	group      int    // current lap group
+	groupName  string // name given to the current group, if any
+	groupStart int    // index of the first event of the current group
+
+	timers []string // names of the running named timers, in start order
+
+	phase int // number of -cycle phase transitions so far
+	beats int // number of -heartbeat intervals passed since "enter"
+
+	ghostRun   *ghostRun // the laps compared with -ghost so far
+	phaseStart time.Time // start of the current phase; zero before the cycle starts
+
+	labelIndex int // number of ticks labeled from opts.Labels so far
+
+	resequence bool // events were moved or deleted, see renumber
+	deleted    int  // number of events deleted by the "del" command
+
+	confirm       func(yes bool, out io.Writer) // takes the answer of the next line, see askConfirm
+	confirmPrompt string                        // the prompt asking for that answer
+
+	untilTenths int // tenths of the time until -until reported, see untilLines
+
+	goAt    time.Time // when the next -reaction GO is due; zero once shown
+	goShown time.Time // when GO was shown; zero until then, and after the reaction
+	trials  int       // number of -reaction trials made
+
+	source  string    // the input being handled, see handleInput
+	inputAt time.Time // when that input was received
+}
+
+// Sources of the events, see Event.Source
+const (
+	sourceStdin  = "stdin"
+	sourceTimer  = "timer" // -warn-at, -cycle, -heartbeat, and the ticks of WithInterval
+	sourceTCP    = "tcp"
+	sourceUDP    = "udp"
+	sourceWatch  = "watch"  // -watch-file, -watch-dir, -watch-pid
+	sourceSignal = "signal" // see WithSignals
+	sourceHTTP   = "http"   // POST /tick of -http-control
+	sourceSocket = "socket" // the lap subcommand, see runDaemon
+)
+
+// handleInput calls handle to record the events of an input received
+// from source at the time at. The events get source as their Source and,
+// unless events have been recorded since, at as their timestamp, so that
+// an input waiting for the collector is not recorded late.
+func (s *Session) handleInput(source string, at time.Time, handle func()) {
+	s.source, s.inputAt = source, at
+	defer func() { s.source, s.inputAt = "", time.Time{} }()
+	handle()
+}
+
+// eventTime returns the timestamp of an event recorded now, see
+// handleInput
+func (s *Session) eventTime() time.Time {
+	if s.inputAt.IsZero() || (len(s.Events) > 0 && s.inputAt.Before(s.Events[len(s.Events)-1].Timestamp)) {
+		return s.now()
+	}
+	return s.inputAt
+}
+
+func newSession(comment string, opts collectOptions) *Session {
+	return &Session{Comment: comment, opts: opts, now: time.Now}
+}
+
+// count returns the number of events recorded so far, including the
+// dropped and deleted ones
+func (s *Session) count() int {
+	return s.Dropped + len(s.Events) + s.deleted
+}
+
+// seq returns the sequence number of the n'th event of the session
+func (s *Session) seq(n int) int64 {
+	return s.opts.SeqStart + int64(n)
+}
+
+// full reports whether opts.MaxEvents events have been recorded after
+// "enter", not counting the deleted ones
+func (s *Session) full() bool {
+	return s.opts.MaxEvents > 0 && s.count()-s.deleted-1 >= s.opts.MaxEvents
+}
+
+// appendEvent appends evt to Events. With -keep-last, the oldest event is
+// dropped first when the limit is reached. Events is then a window into
+// eventBuf, twice as large as the limit: the window slides towards its end,
+// and is copied back to the beginning when it gets there.
+func (s *Session) appendEvent(evt Event) {
+	limit := s.opts.KeepLast
+	if limit <= 0 {
+		s.Events = append(s.Events, evt)
+		return
+	}
+	if s.eventBuf == nil {
+		s.eventBuf = make([]Event, 2*limit)
+		s.Events = s.eventBuf[:copy(s.eventBuf, s.Events)]
+	}
+	if len(s.Events) == limit {
+		s.Events = s.Events[1:]
+		s.Dropped++
+		if s.groupStart > 0 {
+			s.groupStart--
+		}
+	}
+	if len(s.Events) == cap(s.Events) {
+		s.Events = s.eventBuf[:copy(s.eventBuf, s.Events)]
+	}
+	s.Events = append(s.Events, evt)
+}
+
+// droppedComment returns the comment of the output file, noting the number
+// of events dropped by -keep-last, if any
+func droppedComment(comment string, dropped int) string {
+	if dropped == 0 {
+		return comment
+	}
+	note := fmt.Sprintf("%d earlier events dropped by -keep-last", dropped)
+	if comment == "" {
+		return note
+	}
+	return comment + " (" + note + ")"
+}
+
+// start records the "enter" event, and pauses the session right away if
+// requested. When armed, recording "enter" is postponed to the first tick.
+func (s *Session) start() {
+	if s.opts.Arm {
+		s.armed = true
+		return
+	}
+	s.record(labelEnter)
+	s.startCycle()
+	if s.reacting() {
+		s.scheduleGo()
+	}
+	if s.opts.StartPaused {
+		// same timestamp as "enter", so that no time is counted as active
+		s.recordEvent(Event{What: labelPause, Timestamp: s.startAt})
+		s.paused, s.pausedAt = true, s.startAt
+	}
+}
+
+// finish records the "exit" event. A session that was never started is
+// given an "enter" event first, so that the output is still valid. The
+// events are renumbered in their order if events were moved or deleted.
+func (s *Session) finish() {
+	if s.armed {
+		s.armed = false
+		s.record(labelEnter)
+	}
+	s.record(labelExit)
+	if s.resequence {
+		s.renumber()
+	}
+}
+
+// started reports whether "enter" has been recorded, and tells the user
+// otherwise
+func (s *Session) started(out io.Writer) bool {
+	if s.armed {
+		fmt.Fprintln(out, "# Not started yet; press enter to start")
+	}
+	return !s.armed
+}
+
+// record appends a new event labeled what
+func (s *Session) record(what string) {
+	s.recordEvent(Event{What: what})
+}
+
+// recordEvent appends evt, filling in the sequence number and, unless
+// already set, the timestamp
+func (s *Session) recordEvent(evt Event) {
+	evt = s.stampEvent(evt)
+	s.appendEvent(evt)
+	for _, sink := range s.opts.Sinks {
+		sink.Send(evt)
+	}
+}
+
+// edited passes the events to the sinks keeping them, once some were
+// deleted, moved or relabeled, see editSink
+func (s *Session) edited() {
+	for _, sink := range s.opts.Sinks {
+		if sink, ok := sink.(editSink); ok {
+			sink.Edited(s.Events)
+		}
+	}
+}
+
+// stampEvent fills in the fields of evt that recordEvent sets
+func (s *Session) stampEvent(evt Event) Event {
+	if evt.Timestamp.IsZero() {
+		evt.Timestamp = s.eventTime()
+	}
+	if evt.Source == "" {
+		evt.Source = s.source
+	}
+	now := evt.Timestamp
+	first := s.count() == 0
+	evt.Seq, evt.Zone, evt.Group = s.seq(s.count()), localZoneName(now), s.group
+	if len(s.opts.Cycle) > 0 && !s.phaseStart.IsZero() {
+		evt.Phase = s.opts.Cycle[s.phase%len(s.opts.Cycle)].Name
+	}
+	if s.opts.WithID {
+		id, err := NewULID(now)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "\n# WARNING: could not generate event ID:", err)
+		}
+		evt.ID = id
+	}
+	if first {
+		s.startAt = evt.Timestamp
+	}
+	return evt
+}
+
+// sessionCommand is an interactive command typed at the prompt, see
+// handleLine
+type sessionCommand struct {
+	names []string // the name, and any aliases
+	args  string   // the argument, as shown by help, e.g. "<name>"
+	alone bool     // the name must be the whole line, otherwise it is a label
+	help  string   // one line description, as shown by help
+
+	// run handles the command; arg is the rest of the input line. Messages
+	// are written to out.
+	run func(s *Session, arg string, out io.Writer)
+}
+
+// sessionCommands are the interactive commands, in the order help lists
+// them
+var sessionCommands []sessionCommand
+
+func init() {
+	sessionCommands = []sessionCommand{
+		{names: []string{"comment"}, args: "[<text>]", help: "show the comment, or replace it with text", run: (*Session).cmdComment},
+		{names: []string{"mark"}, args: "<name>", help: "record a milestone, which does not close a lap", run: (*Session).cmdMark},
+		{names: []string{"pause"}, help: "stop the clock", run: (*Session).cmdPause},
+		{names: []string{"resume"}, help: "continue after a pause", run: (*Session).cmdResume},
+		{names: []string{"reset"}, args: "[<name>]", help: "start a new group of laps", run: (*Session).cmdReset},
+		{names: []string{"start"}, args: "<timer>", help: "start a named timer", run: (*Session).cmdStart},
+		{names: []string{"stop"}, args: "<timer>", help: "stop a named timer", run: (*Session).cmdStop},
+		{names: []string{repeatShort, repeatLong}, alone: true, help: "record an event with the label of the previous one",
+			run: func(s *Session, _ string, out io.Writer) { s.repeatLabel(out) }},
+		{names: []string{"tick"}, args: "[-<duration>] [<label>]", help: "record a tick, back-dated by the duration",
+			run: (*Session).cmdTick},
+		{names: []string{"shift"}, args: "<seq>|last <+/-duration>", help: "move the timestamp of an event",
+			run: (*Session).cmdShift},
+		{names: []string{"del"}, args: "<seq>", help: "delete an event, once confirmed", run: (*Session).cmdDel},
+		{names: []string{"del!"}, args: "<seq>", help: "delete an event without asking", run: (*Session).cmdDelNow},
+		{names: []string{"again"}, alone: true, help: "record a copy of the previous event, with its category and attributes",
+			run: func(s *Session, _ string, out io.Writer) { s.again(out) }},
+		{names: []string{"relabel"}, args: "[-regex] [-force] <old> <new>", help: "replace a label in the events so far",
+			run: (*Session).cmdRelabel},
+		{names: []string{"list", "ls"}, args: "[<count>]", help: "list the events so far, or the last count of them", run: (*Session).cmdList},
+		{names: []string{"save"}, args: "[<file>]", help: "write the events so far into a file", run: (*Session).cmdSave},
+		{names: []string{"help", "?"}, help: "show this list", run: (*Session).cmdHelp},
+	}
+}
+
+// lookupSessionCommand returns the command of the input line, and the rest
+// of the line as its argument
+func lookupSessionCommand(line string) (*sessionCommand, string, bool) {
+	name, arg, _ := strings.Cut(line, " ")
+	for i, cmd := range sessionCommands {
+		for _, n := range cmd.names {
+			if n == name && (arg == "" || !cmd.alone) {
+				return &sessionCommands[i], strings.TrimSpace(arg), true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// handleLine runs the command on an input line, see sessionCommands. Any
+// other line records an event labeled with the line, or "tick" if the line
+// is empty, or with the label of an abbreviation typed alone, see -abbrev.
+// A leading backslash is removed, and makes the rest of the line a label
+// even if it starts with a command name or is an abbreviation. A line
+// answering a question of askConfirm is taken as the answer only.
+func (s *Session) handleLine(line string, out io.Writer) {
+	line = strings.TrimSpace(line)
+	if s.confirm != nil {
+		confirm := s.confirm
+		s.confirm, s.confirmPrompt = nil, ""
+		confirm(line == "y" || line == "yes", out)
+		return
+	}
+	if strings.HasPrefix(line, `\`) {
+		s.recordLabel(line[1:], out)
+		return
+	}
+	if label, ok := s.opts.Abbrevs[line]; ok {
+		s.recordLabel(label, out)
+		return
+	}
+	if cmd, arg, ok := lookupSessionCommand(line); ok {
+		cmd.run(s, arg, out)
+		return
+	}
+	word, _, _ := strings.Cut(line, " ")
+	name, typo := closestCommand(word)
+	typo = typo && !s.knownLabel(word)
+	s.recordLabel(line, out)
+	if typo {
+		fmt.Fprintf(out, "# %q was taken as a label; did you mean %q? Type 'help' for the commands\n", word, name)
+	}
+}
+
+// knownLabel reports whether word is one of -labels, or starts a label
+// already recorded, so that it is evidently not a mistyped command
+func (s *Session) knownLabel(word string) bool {
+	for _, label := range s.opts.Labels {
+		if label == word {
+			return true
+		}
+	}
+	for _, evt := range s.Events {
+		if evt.What == word || strings.HasPrefix(evt.What, word+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// cmdHelp lists the commands
+func (s *Session) cmdHelp(arg string, out io.Writer) {
+	var usages []string
+	width := 0
+	for _, cmd := range sessionCommands {
+		usage := strings.Join(cmd.names, " or ")
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		usages = append(usages, usage)
+		if n := displayWidth(usage); n > width {
+			width = n
+		}
+	}
+	fmt.Fprintln(out, "# Commands:")
+	for i, cmd := range sessionCommands {
+		fmt.Fprintf(out, "#   %s%s  %s\n", usages[i], strings.Repeat(" ", width-displayWidth(usages[i])), cmd.help)
+	}
+	fmt.Fprintln(out, "# Any other text is recorded as the label of an event, and \\<text> records the text")
+	fmt.Fprintln(out, "# even if it starts with a command name. Exit: <ctrl+d> or <ctrl+c>")
+}
+
+// closestCommand returns the command name that word is likely a typo of:
+// the same but for the case or for one edit, two in names of six letters
+// or more. In names shorter than five letters, which are a single edit from
+// many words, the edit must be a swap of two letters.
+func closestCommand(word string) (string, bool) {
+	best, bestDist := "", 0
+	lower := strings.ToLower(word)
+	for _, cmd := range sessionCommands {
+		for _, name := range cmd.names {
+			if len(name) < 3 || name == word {
+				continue
+			}
+			limit := 1
+			if len(name) >= 6 {
+				limit = 2
+			}
+			d := editDistance(lower, name)
+			if d > 0 && len(name) < 5 && !sameLetters(lower, name) {
+				continue
+			}
+			if d <= limit && (best == "" || d < bestDist) {
+				best, bestDist = name, d
+			}
+		}
+	}
+	return best, best != ""
+}
+
+// sameLetters reports whether a and b have the same letters in some order
+func sameLetters(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) != len(rb) {
+		return false
+	}
+	sort.Slice(ra, func(i, j int) bool { return ra[i] < ra[j] })
+	sort.Slice(rb, func(i, j int) bool { return rb[i] < rb[j] })
+	return string(ra) == string(rb)
+}
+
+// editDistance returns the number of edits turning a into b: runes
+// inserted, deleted, replaced or swapped with the next one
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// the rows of the distances of the prefixes of a to those of b
+	prev2, prev := []int(nil), make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = cur[j-1] + 1
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := prev[j-1] + cost; d < cur[j] {
+				cur[j] = d
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+		}
+		prev2, prev = prev, cur
+	}
+	return prev[len(rb)]
+}
+
+// recordLabel records an event with text typed by the user: an optional
+// leading number as the value, trailing key=value words as attributes and
+// the rest as the label. Labels used by the collector itself are refused.
+func (s *Session) recordLabel(text string, out io.Writer) {
+	evt, err := parseTick(text)
+	if err != nil {
+		fmt.Fprintf(out, "# %v, not recorded\n", err)
+		return
+	}
+	s.recordTick(evt, out)
+}
+
+// parseTick splits text into the label, value and attributes of an event,
+// see recordLabel
+func parseTick(text string) (Event, error) {
+	value, text := splitValue(text)
+	attrs, label, err := splitAttrs(text)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{What: label, Value: value, Attrs: attrs}, nil
+}
+
+// recordTick records evt, given by the user or another input, as a tick.
+// An empty label is replaced by the -source-label of the input, the next
+// of -labels, or the -tick-label, in this order; then the -source-prefix
+// of the input is prepended.
+func (s *Session) recordTick(evt Event, out io.Writer) {
+	evt.What = s.cleanLabel(evt.What)
+	for k, v := range evt.Attrs {
+		evt.Attrs[k] = s.cleanLabel(v)
+	}
+	label := evt.What
+	// typed labels override the -labels list without advancing it
+	cycled := false
+	if label == "" {
+		label = s.opts.SourceLabels[s.source]
+	}
+	if label == "" {
+		label, cycled = s.upcomingLabel()
+		if !cycled {
+			label = s.plainLabel()
+		}
+	}
+	if isReserved(label) {
+		fmt.Fprintf(out, "# Label %q is reserved, not recorded\n", label)
+		return
+	}
+	label = s.opts.SourcePrefixes[s.source] + label
+	evt.What = label
+	if s.full() {
+		fmt.Fprintf(out, "# Reached %d events, not recorded\n", s.opts.MaxEvents)
+		return
+	}
+	if s.stepsDone() && s.opts.LabelsStrict {
+		fmt.Fprintf(out, "# All %d steps done, not recorded\n", len(s.opts.Labels))
+		return
+	}
+	if s.armed {
+		// the first tick starts the session: "enter" gets its timestamp
+		s.armed = false
+		s.record(labelEnter)
+		s.startCycle()
+		evt.Timestamp = s.startAt
+		s.recordEvent(evt)
+		if cycled {
+			s.labelIndex++
+		}
+		return
+	}
+	if s.paused && !s.opts.ResumeOnTick {
+		fmt.Fprintln(out, "# Paused, not recorded; type 'resume' to continue")
+		return
+	}
+	now := s.eventTime()
+	if s.debounced(now) {
+		fmt.Fprintln(out, "# (debounced)")
+		return
+	}
+	if s.samples() {
+		keep, suppressed := s.opts.Sampler.sample(label, now)
+		if !keep {
+			fmt.Fprintln(out, "# (sampled)")
+			return
+		}
+		if suppressed > 0 {
+			if evt.Attrs == nil {
+				evt.Attrs = make(map[string]string)
+			}
+			evt.Attrs[attrSuppressed] = strconv.Itoa(suppressed)
+		}
+	}
+	if s.paused {
+		s.recordEvent(Event{Timestamp: now, What: labelResume})
+		s.paused = false
+	}
+	if s.reacting() && !s.reactionTick(&evt, now, out) {
+		return
+	}
+	s.checkIdle(&evt, now, out)
+	evt.Timestamp = now
+	s.recordEvent(evt)
+	if cycled {
+		s.labelIndex++
+	}
+	s.checkLap(out)
+}
+
+// checkIdle handles evt, a tick at now, coming more than -idle-after after
+// the event starting its lap: an "idle" event is recorded where the
+// threshold was crossed, or with -idle-flag, evt is flagged "idle". The
+// time paused is never idle, as the lap then starts from the "resume".
+func (s *Session) checkIdle(evt *Event, now time.Time, out io.Writer) {
+	if s.opts.IdleAfter <= 0 || len(s.Events) == 0 {
+		return
+	}
+	i := len(s.Events) - 1
+	for i > 0 && isAnnotation(s.Events[i].What) {
+		i--
+	}
+	if now.Sub(s.Events[i].Timestamp) <= s.opts.IdleAfter {
+		return
+	}
+	if s.opts.IdleFlag {
+		evt.Flag = flagIdle
+		fmt.Fprintf(out, "# Idle for over %s, flagged\n", formatDuration(s.opts.IdleAfter))
+		return
+	}
+	// after any annotation recorded since, to keep the events in order
+	at := s.Events[i].Timestamp.Add(s.opts.IdleAfter)
+	if last := s.Events[len(s.Events)-1].Timestamp; at.Before(last) {
+		at = last
+	}
+	s.recordEvent(Event{Timestamp: at, What: labelIdle, Source: sourceTimer})
+	fmt.Fprintf(out, "# Idle for %s, not counted in the lap\n", formatDuration(now.Sub(at)))
+}
+
+// recordSuspension records the "suspended" or "resumed" event of req, see
+// watchSuspend. Nothing is recorded before the session has started.
+func (s *Session) recordSuspension(req remoteLine, out io.Writer) {
+	if !s.started(io.Discard) {
+		return
+	}
+	s.recordEvent(Event{What: req.text, Attrs: req.attrs})
+	if req.text == labelSuspended {
+		fmt.Fprintln(out, "\n# Suspended")
+		return
+	}
+	n := len(s.Events)
+	if n < 2 || s.Events[n-2].What != labelSuspended {
+		fmt.Fprintln(out, "\n# Resumed")
+		return
+	}
+	msg := "# Resumed after " + formatDuration(s.Events[n-1].Timestamp.Sub(s.Events[n-2].Timestamp))
+	if _, excluded := excludedSuspension(s.Events[n-1]); excluded {
+		msg += ", not counted in the laps"
+	}
+	fmt.Fprintln(out, msg)
+}
+
+// Inputs that record an event with the label of the previous one
+const (
+	repeatShort = "."
+	repeatLong  = "!!"
+)
+
+// repeatLabel records an event with the label of the last event recorded
+// by user input. Like a typed label, it does not advance -labels.
+func (s *Session) repeatLabel(out io.Writer) {
+	label := ""
+	for i := len(s.Events) - 1; i >= 0 && label == ""; i-- {
+		if !isReserved(s.Events[i].What) {
+			label = s.Events[i].What
+		}
+	}
+	if label == "" {
+		fmt.Fprintln(out, "# No previous label to repeat, recording", s.plainLabel())
+		s.recordLabel(s.plainLabel(), out)
+		return
+	}
+	n := s.count()
+	s.recordLabel(label, out)
+	if s.count() > n {
+		fmt.Fprintf(out, "# Repeated: %s\n", label)
+	}
+}
+
+// again records a tick with the label, the category (see attrCategory) and
+// the other attributes of the previous event, unlike repeatLabel, which
+// takes the label only. Events recorded by the stopwatch itself, such as
+// marks and alerts, are not copied.
+func (s *Session) again(out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	if len(s.Events) == 0 {
+		fmt.Fprintln(out, "# Nothing to repeat yet, not recorded")
+		return
+	}
+	prev := s.Events[len(s.Events)-1]
+	if why := notRepeatable(prev.What); why != "" {
+		fmt.Fprintf(out, "# The previous event %q %s, not recorded\n", prev.What, why)
+		return
+	}
+	evt := Event{What: prev.What}
+	var words []string
+	for k, v := range prev.Attrs {
+		if k == attrSuppressed {
+			continue // counted by the sampler for that event only
+		}
+		if evt.Attrs == nil {
+			evt.Attrs = make(map[string]string)
+		}
+		evt.Attrs[k] = v
+		if k != attrCategory {
+			words = append(words, k+"="+v)
+		}
+	}
+	sort.Strings(words)
+	// the category right after the label, as it tags the event
+	if category, ok := evt.Attrs[attrCategory]; ok {
+		words = append([]string{attrCategory + "=" + category}, words...)
+	}
+	n := s.count()
+	s.recordTick(evt, out)
+	if s.count() > n {
+		fmt.Fprintf(out, "# Again: %s\n", strings.Join(append([]string{evt.What}, words...), " "))
+	}
+}
+
+// notRepeatable tells why an event labeled label can not be copied by
+// "again", or "" if it can
+func notRepeatable(label string) string {
+	switch {
+	case isSentinel(label):
+		return "starts the session"
+	case isMark(label):
+		return "is a milestone; use 'mark <name>' for another"
+	case isWarning(label):
+		return "is a -warn-at alert"
+	case isTimerEvent(label):
+		return "starts or stops a timer"
+	case isPauseControl(label):
+		return "is a pause control"
+	case isReset(label):
+		return "starts a lap group; use 'reset' for another"
+	case isReserved(label):
+		return "was recorded by the stopwatch itself"
+	}
+	return ""
+}
+
+// checkLap reports on the lap closed by the last event: a warning if it is
+// shorter than the minimum (the event is also flagged), and the deviation
+// from the target lap time
+func (s *Session) checkLap(out io.Writer) {
+	if s.opts.MinLap <= 0 && s.opts.Target <= 0 && s.opts.Ghost == nil {
+		return
+	}
+	laps := LapDurations(s.Events[s.groupStart:])
+	if len(laps) == 0 {
+		return
+	}
+	lap, evt := laps[len(laps)-1], &s.Events[len(s.Events)-1]
+	if s.opts.MinLap > 0 && lap < s.opts.MinLap {
+		evt.Flag = flagShort
+		msg := fmt.Sprintf("# WARNING: short lap %s (minimum %s)", formatDuration(lap), formatDuration(s.opts.MinLap))
+		fmt.Fprintln(out, colorize(msg, ansiRed, s.opts.Color))
+	}
+	if s.opts.Target > 0 {
+		dev := lap - s.opts.Target
+		secs := dev.Round(time.Millisecond).Seconds()
+		evt.VsTarget = &secs
+		color := ansiGreen
+		if dev > 0 {
+			color = ansiRed
+		}
+		fmt.Fprintf(out, "# Lap %d: %s (%s, cumulative %s)\n", len(laps), formatDuration(lap),
+			colorize(formatDeviation(dev), color, s.opts.Color), formatDeviation(CumulativeDeviation(laps, s.opts.Target)))
+	}
+	if s.opts.Ghost != nil {
+		if s.ghostRun == nil {
+			s.ghostRun = newGhostRun(s.opts.Ghost)
+		}
+		if dev, ok := s.ghostRun.lap(*evt, lap); ok {
+			color := ansiGreen
+			if dev > 0 {
+				color = ansiRed
+			}
+			fmt.Fprintf(out, "# Lap %d: %s (%s vs ghost, cumulative %s)\n", len(laps), formatDuration(lap),
+				colorize(formatDeviation(dev), color, s.opts.Color), formatDeviation(s.ghostRun.deviation))
+		}
+	}
+}
+
+// samples reports whether the ticks of the input being handled go through
+// opts.Sampler
+func (s *Session) samples() bool {
+	switch s.source {
+	case sourceStdin, "":
+		return s.opts.Sampler != nil && s.opts.SampleStdin
+	case sourceTimer:
+		return false
+	}
+	return s.opts.Sampler != nil
+}
+
+// debounced reports whether a tick at now falls within the debounce window
+// after the previous event. The window is global: it applies to whatever
+// event was recorded last.
+func (s *Session) debounced(now time.Time) bool {
+	if s.opts.Debounce <= 0 || len(s.Events) == 0 {
+		return false
+	}
+	// a heartbeat is not activity
+	i := len(s.Events) - 1
+	for i > 0 && s.Events[i].What == labelHeartbeat {
+		i--
+	}
+	return now.Sub(s.Events[i].Timestamp) < s.opts.Debounce
+}
+
+// numberPattern matches the numbers accepted as event values. Only "." is
+// accepted as the decimal separator, regardless of locale.
+var numberPattern = regexp.MustCompile(`^[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?$`)
+
+// splitValue splits a leading number off text
+func splitValue(text string) (*float64, string) {
+	first, rest := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		first, rest = text[:i], text[i+1:]
+	}
+	if !numberPattern.MatchString(first) {
+		return nil, text
+	}
+	v, err := strconv.ParseFloat(first, 64)
+	if err != nil {
+		return nil, text // out of range
+	}
+	return &v, strings.TrimSpace(rest)
+}
+
+// cmdComment shows the comment, or replaces it with arg
+func (s *Session) cmdComment(arg string, out io.Writer) {
+	if arg != "" {
+		s.Comment = arg
+	}
+	if s.Comment == "" {
+		fmt.Fprintln(out, "# Comment: (none)")
+		return
+	}
+	fmt.Fprintln(out, "# Comment:", s.Comment)
+}
+
+// cmdMark records a milestone named arg, an event labeled "mark:<arg>". It
+// is kept in the output, but it is not a tick: laps span over it, and the
+// statistics leave it out. Typed as "\mark <arg>", the line is a label
+// instead.
+func (s *Session) cmdMark(arg string, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	arg = s.cleanLabel(arg)
+	if arg == "" {
+		fmt.Fprintln(out, "# Usage: mark <name>")
+		return
+	}
+	s.record(labelMarkPrefix + arg)
+}
+
+// cmdPause stops counting time until the next resume
+func (s *Session) cmdPause(arg string, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	if s.paused {
+		fmt.Fprintln(out, "# Already paused")
+		return
+	}
+	s.record(labelPause)
+	s.paused, s.pausedAt = true, s.Events[len(s.Events)-1].Timestamp
+}
+
+// cmdResume continues after a pause
+func (s *Session) cmdResume(arg string, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	if !s.paused {
+		fmt.Fprintln(out, "# Not paused")
+		return
+	}
+	s.record(labelResume)
+	s.paused = false
+}
+
+// nextTimer returns the time until the next scheduled event: a -warn-at
+// threshold, a -budget running out, a -cycle phase transition, a -reaction
+// GO, a -heartbeat or the -until deadline
+func (s *Session) nextTimer() (time.Duration, bool) {
+	wait, ok := s.nextWarning()
+	if left, due := s.nextBudget(); due && (!ok || left < wait) {
+		wait, ok = left, true
+	}
+	if left, due := s.nextHeartbeat(); due && (!ok || left < wait) {
+		wait, ok = left, true
+	}
+	if _, left, cycling := s.currentPhase(); cycling && (!ok || left < wait) {
+		wait, ok = left, true
+	}
+	if left, due := s.nextGo(); due && (!ok || left < wait) {
+		wait, ok = left, true
+	}
+	if !s.opts.Until.IsZero() {
+		// Woken up at least every countdownInterval, as the wall clock
+		// may be stepped while waiting
+		left := s.opts.Until.Sub(s.now())
+		if left > countdownInterval {
+			left = countdownInterval
+		}
+		if !ok || left < wait {
+			wait, ok = left, true
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, ok
+}
+
+// checkTimers records the scheduled events that are due, and reports
+// whether any messages were written into out
+func (s *Session) checkTimers(out io.Writer) bool {
+	source, at := s.source, s.inputAt
+	s.source, s.inputAt = sourceTimer, time.Time{}
+	defer func() { s.source, s.inputAt = source, at }()
+	phases := s.checkPhases(out)
+	warnings := s.checkWarnings(out)
+	budgets := s.checkBudgets(out)
+	shown := s.checkGo(out)
+	s.checkHeartbeat()
+	return phases || warnings || budgets || shown
+}
+
+// expired reports whether the -until deadline has been reached
+func (s *Session) expired() bool {
+	// Until has no monotonic clock reading, so this compares wall clocks
+	return !s.opts.Until.IsZero() && !s.now().Before(s.opts.Until)
+}
+
+// nextWarning returns the time until the next -warn-at threshold is
+// crossed, if there is one
+func (s *Session) nextWarning() (time.Duration, bool) {
+	if s.armed || len(s.Events) == 0 || s.warned >= len(s.opts.WarnAt) {
+		return 0, false
+	}
+	elapsed := s.now().Sub(s.startAt)
+	wait := s.opts.WarnAt[s.warned] - elapsed
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// checkWarnings records a warning event for every threshold crossed since
+// the last check, and reports whether there were any. Thresholds already in
+// the past fire at once.
+func (s *Session) checkWarnings(out io.Writer) bool {
+	if s.armed || len(s.Events) == 0 {
+		return false
+	}
+	warned := s.warned
+	now := s.now()
+	elapsed := now.Sub(s.startAt)
+	for s.warned < len(s.opts.WarnAt) && s.opts.WarnAt[s.warned] <= elapsed {
+		threshold := formatDuration(s.opts.WarnAt[s.warned])
+		s.recordEvent(Event{Timestamp: now, What: labelWarnPrefix + threshold})
+		s.warned++
+		msg := fmt.Sprintf("\n# WARNING: %s elapsed", threshold)
+		fmt.Fprintln(out, colorize(msg, ansiBold+";"+ansiRed, s.opts.Color))
+		s.notify(fmt.Sprintf("Warning: %s elapsed", threshold), now, out)
+	}
+	return s.warned > warned
+}
+
+// cmdReset starts a new lap group, optionally named arg. The lap and event
+// counters shown restart from the reset, but sequence numbers continue.
+func (s *Session) cmdReset(arg string, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	if ComputeStats(s.Events[s.groupStart:]).Ticks == 0 {
+		fmt.Fprintln(out, "# No ticks since the start of the group, nothing to reset")
+		return
+	}
+	label := labelReset
+	if arg != "" {
+		label += ":" + arg
+	}
+	s.group++
+	s.groupName = arg
+	s.groupStart = len(s.Events)
+	s.record(label)
+}
+
+// cmdStart starts the named timer arg
+func (s *Session) cmdStart(arg string, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	if arg == "" {
+		fmt.Fprintln(out, "# Usage: start <timer>")
+		return
+	}
+	if s.timerIndex(arg) >= 0 {
+		fmt.Fprintf(out, "# WARNING: timer %q is already running\n", arg)
+		return
+	}
+	s.timers = append(s.timers, arg)
+	s.record(labelStartPrefix + arg)
+}
+
+// cmdStop stops the named timer arg
+func (s *Session) cmdStop(arg string, out io.Writer) {
+	if !s.started(out) {
+		return
+	}
+	if arg == "" {
+		fmt.Fprintln(out, "# Usage: stop <timer>")
+		return
+	}
+	i := s.timerIndex(arg)
+	if i < 0 {
+		fmt.Fprintf(out, "# WARNING: timer %q is not running\n", arg)
+		return
+	}
+	s.timers = append(s.timers[:i], s.timers[i+1:]...)
+	s.record(labelStopPrefix + arg)
+}
+
+// timerIndex returns the index of the running timer name, or -1
+func (s *Session) timerIndex(name string) int {
+	for i, t := range s.timers {
+		if t == name {
+			return i
+		}
+	}
+	return -1
+}
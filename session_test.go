@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionComment(t *testing.T) {
+	sess := newSession("from flag", collectOptions{})
+	var out bytes.Buffer
+
+	sess.handleLine("comment\n", &out)
+	if got := out.String(); got != "# Comment: from flag\n" {
+		t.Errorf("Unexpected output: %q", got)
+	}
+
+	out.Reset()
+	sess.handleLine("comment   measuring cold-start latency \n", &out)
+	if sess.Comment != "measuring cold-start latency" {
+		t.Errorf("Unexpected comment: %q", sess.Comment)
+	}
+	if got := out.String(); got != "# Comment: measuring cold-start latency\n" {
+		t.Errorf("Unexpected output: %q", got)
+	}
+	if len(sess.Events) != 0 {
+		t.Errorf("Expected commands not to record events, got %v", sess.Events)
+	}
+
+	out.Reset()
+	newSession("", collectOptions{}).handleLine("comment", &out)
+	if got := out.String(); got != "# Comment: (none)\n" {
+		t.Errorf("Unexpected output: %q", got)
+	}
+}
+
+func TestSessionLabels(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	var out bytes.Buffer
+	for _, line := range []string{"\n", "commentary\n", "  build done \n", "mark phase2-start\n",
+		`\mark foo` + "\n", `\\x` + "\n", "mark\n", "exit\n", "mark:x\n", `\exit`} {
+		sess.handleLine(line, &out)
+	}
+	var got []string
+	for i, evt := range sess.Events {
+		if evt.Seq != int64(i) {
+			t.Errorf("Unexpected sequence number in %+v", evt)
+		}
+		got = append(got, evt.What)
+	}
+	want := []string{labelTick, "commentary", "build done", "mark:phase2-start", "mark foo", `\x`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected labels %q, got %q", want, got)
+	}
+	wantOut := "# Usage: mark <name>\n" +
+		"# Label \"exit\" is reserved, not recorded\n" +
+		"# Label \"mark:x\" is reserved, not recorded\n" +
+		"# Label \"exit\" is reserved, not recorded\n"
+	if out.String() != wantOut {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestSplitValue(t *testing.T) {
+	for _, tc := range []struct {
+		text  string
+		value string
+		label string
+	}{
+		{"42.5 temperature check", "42.5", "temperature check"},
+		{"42", "42", ""},
+		{"-3\tcold", "-3", "cold"},
+		{".5e3 x", "500", "x"},
+		{"42,5 comma", "", "42,5 comma"},
+		{"1.2.3 version", "", "1.2.3 version"},
+		{"inf loop", "", "inf loop"},
+		{"0x10 hex", "", "0x10 hex"},
+		{"1e999 huge", "", "1e999 huge"},
+		{"", "", ""},
+	} {
+		value, label := splitValue(tc.text)
+		got := ""
+		if value != nil {
+			got = formatValue(*value)
+		}
+		if got != tc.value || label != tc.label {
+			t.Errorf("%q: expected %q %q, got %q %q", tc.text, tc.value, tc.label, got, label)
+		}
+	}
+}
+
+func TestSessionAttrs(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	var out bytes.Buffer
+	for _, line := range []string{
+		"tick build=release arch=arm64",
+		"1.5 x=y in the middle k=v=w",
+		"e=mc2",
+		"tick seq=1",
+		"tick =x",
+	} {
+		sess.handleLine(line, &out)
+	}
+	if len(sess.Events) != 3 {
+		t.Fatalf("Expected 3 events, got %v", sess.Events)
+	}
+	for i, want := range []Event{
+		{What: labelTick, Attrs: map[string]string{"build": "release", "arch": "arm64"}},
+		{What: "x=y in the middle", Attrs: map[string]string{"k": "v=w"}},
+		{What: labelTick, Attrs: map[string]string{"e": "mc2"}},
+	} {
+		got := sess.Events[i]
+		if got.What != want.What || !reflect.DeepEqual(got.Attrs, want.Attrs) {
+			t.Errorf("Event %d: expected %q %v, got %q %v", i, want.What, want.Attrs, got.What, got.Attrs)
+		}
+	}
+	wantOut := "# attribute name \"seq\" is reserved for a built-in column, not recorded\n" +
+		"# empty attribute name, not recorded\n"
+	if out.String() != wantOut {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+// fakeClock returns a clock starting at 2022-04-08 20:00 UTC that advances
+// by the durations in *steps, one per call
+func fakeClock(steps *[]time.Duration) func() time.Time {
+	t := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		if len(*steps) > 0 {
+			t = t.Add((*steps)[0])
+			*steps = (*steps)[1:]
+		}
+		return t
+	}
+}
+
+func TestSessionStartPaused(t *testing.T) {
+	steps := seconds(0, 10, 20, 3, 30, 5)
+	sess := newSession("", collectOptions{StartPaused: true})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+
+	sess.start()
+	if got := sess.prompt(); got != "# [PAUSED] Waiting for [2]> " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	sess.handleLine("", &out) // refused
+	sess.handleLine("resume", &out)
+	sess.handleLine("", &out)
+	sess.handleLine("pause", &out)
+	sess.handleLine("resume", &out)
+	sess.handleLine("", &out)
+	sess.record(labelExit)
+
+	if got := out.String(); got != "# Paused, not recorded; type 'resume' to continue\n" {
+		t.Errorf("Unexpected output: %q", got)
+	}
+	// enter, pause, resume at 10s, tick at 30s, pause at 33s, resume at 63s, tick at 68s, exit
+	s := ComputeStats(sess.Events)
+	expectLaps := seconds(20, 8)
+	if !reflect.DeepEqual(s.Laps, expectLaps) || s.Ticks != 2 {
+		t.Errorf("Expected laps %v and 2 ticks, got %v and %d", expectLaps, s.Laps, s.Ticks)
+	}
+	if s.Total != 68*time.Second || s.Active != 28*time.Second {
+		t.Errorf("Expected total 68s and active 28s, got %v and %v", s.Total, s.Active)
+	}
+}
+
+func TestSessionNeverResumed(t *testing.T) {
+	steps := seconds(0, 60)
+	sess := newSession("", collectOptions{StartPaused: true})
+	sess.now = fakeClock(&steps)
+	sess.start()
+	sess.record(labelExit)
+
+	s := ComputeStats(sess.Events)
+	if s.Total != time.Minute || s.Active != 0 || len(s.Laps) != 0 {
+		t.Errorf("Expected zero active time and no laps, got %+v", s)
+	}
+	var buf bytes.Buffer
+	if err := EncodeCSV(&buf, sess.Events, OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := UnmarshalEventsCSV(&buf); err != nil {
+		t.Errorf("Expected a valid file, got: %v", err)
+	}
+}
+
+func TestSessionResumeOnTick(t *testing.T) {
+	steps := seconds(0, 5, 2)
+	sess := newSession("", collectOptions{StartPaused: true, ResumeOnTick: true})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+	sess.start()
+	sess.handleLine("", &out)
+	sess.handleLine("", &out)
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.What)
+	}
+	want := []string{labelEnter, labelPause, labelResume, labelTick, labelTick}
+	if !reflect.DeepEqual(got, want) || sess.paused {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if laps := LapDurations(sess.Events); !reflect.DeepEqual(laps, seconds(0, 2)) {
+		t.Errorf("Unexpected laps: %v", laps)
+	}
+	for _, line := range []string{"resume", `\pause`} {
+		sess.handleLine(line, &out)
+	}
+	if out.String() != "# Not paused\n# Label \"pause\" is reserved, not recorded\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestSessionArm(t *testing.T) {
+	steps := seconds(7, 3)
+	sess := newSession("", collectOptions{Arm: true})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+
+	sess.start()
+	if got := sess.prompt(); got != "# Armed — press enter to start> " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	sess.handleLine("mark too-early", &out)
+	sess.handleLine("go", &out)
+	sess.handleLine("", &out)
+	sess.finish()
+
+	if out.String() != "# Not started yet; press enter to start\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	if len(sess.Events) != 4 || sess.Events[1].Seq != 1 || sess.Events[1].What != "go" {
+		t.Fatalf("Unexpected events: %v", sess.Events)
+	}
+	if !sess.Events[0].Timestamp.Equal(sess.Events[1].Timestamp) {
+		t.Errorf("Expected enter at the first tick, got %v", sess.Events)
+	}
+	s := ComputeStats(sess.Events)
+	if s.Total != 3*time.Second || !reflect.DeepEqual(s.Laps, seconds(0, 3)) {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+
+	// never armed
+	sess = newSession("", collectOptions{Arm: true})
+	sess.start()
+	sess.finish()
+	if len(sess.Events) != 2 || sess.Events[0].What != labelEnter || sess.Events[1].What != labelExit {
+		t.Errorf("Expected enter and exit, got %v", sess.Events)
+	}
+}
+
+func TestSessionDebounce(t *testing.T) {
+	for _, tc := range []struct {
+		window    time.Duration
+		labels    []string
+		debounced int
+	}{
+		{0, []string{labelEnter, "a", "b", "c", "mark:m", "d", "e"}, 0},
+		{200 * time.Millisecond, []string{labelEnter, "a", "c", "mark:m", "e"}, 2},
+	} {
+		// ticks at 1s, 1.03s, 1.3s; mark at 1.35s; ticks at 1.4s, 1.7s
+		steps := []time.Duration{0, time.Second, 30 * time.Millisecond, 270 * time.Millisecond,
+			50 * time.Millisecond, 50 * time.Millisecond, 300 * time.Millisecond}
+		sess := newSession("", collectOptions{Debounce: tc.window})
+		sess.now = fakeClock(&steps)
+		var out bytes.Buffer
+		sess.start()
+		for _, line := range []string{"a", "b", "c", "mark m", "d", "e"} {
+			sess.handleLine(line, &out)
+		}
+		var got []string
+		for _, evt := range sess.Events {
+			got = append(got, evt.What)
+		}
+		if !reflect.DeepEqual(got, tc.labels) {
+			t.Errorf("window %v: expected %q, got %q", tc.window, tc.labels, got)
+		}
+		if n := strings.Count(out.String(), "# (debounced)\n"); n != tc.debounced {
+			t.Errorf("window %v: expected %d notices, got %q", tc.window, tc.debounced, out.String())
+		}
+	}
+}
+
+func TestSessionMinLap(t *testing.T) {
+	// ticks at 6s, 8s (short), 8.1s (debounced), 14s
+	steps := []time.Duration{0, 6 * time.Second, 2 * time.Second, 100 * time.Millisecond, 5900 * time.Millisecond}
+	sess := newSession("", collectOptions{MinLap: 5 * time.Second, Debounce: 200 * time.Millisecond})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+	sess.start()
+	for i := 0; i < 4; i++ {
+		sess.handleLine("", &out)
+	}
+	var flags []string
+	for _, evt := range sess.Events {
+		flags = append(flags, evt.Flag)
+	}
+	if want := []string{"", "", flagShort, ""}; !reflect.DeepEqual(flags, want) {
+		t.Errorf("Expected flags %q, got %q", want, flags)
+	}
+	want := "# WARNING: short lap 2s (minimum 5s)\n# (debounced)\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestSessionTargetLap(t *testing.T) {
+	steps := seconds(0, 93.2, 40, 51)
+	sess := newSession("", collectOptions{Target: 90 * time.Second})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+	sess.start()
+	sess.handleLine("", &out)
+	sess.handleLine("mark halfway", &out)
+	sess.handleLine("", &out)
+	want := "# Lap 1: 1m33.2s (+3.2s, cumulative +3.2s)\n" +
+		"# Lap 2: 1m31s (+1s, cumulative +4.2s)\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.Cell("vs_target"))
+	}
+	if want := []string{"", "3.2", "", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected vs_target %q, got %q", want, got)
+	}
+
+	sess.finish()
+	var buf bytes.Buffer
+	if err := WriteTargetSummary(&buf, ComputeStats(sess.Events), 92*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Target: 1m32s, beaten: 1 of 2 laps, cumulative: +200ms\n"; buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestSessionWarnAt(t *testing.T) {
+	// started at 0, first check at 5m, 22m, 26m
+	steps := []time.Duration{0, 5 * time.Minute, 0, 10 * time.Minute, 7 * time.Minute, 0, 4 * time.Minute}
+	sess := newSession("", collectOptions{WarnAt: []time.Duration{20 * time.Minute, 25 * time.Minute}})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+	sess.start()
+
+	if wait, ok := sess.nextWarning(); !ok || wait != 15*time.Minute {
+		t.Errorf("Expected next warning in 15m, got %v %v", wait, ok)
+	}
+	sess.checkWarnings(&out) // 5m
+	sess.handleLine("", &out)
+	sess.checkWarnings(&out) // 22m
+	sess.checkWarnings(&out) // 22m
+	sess.handleLine("", &out)
+	sess.checkWarnings(&out) // 26m
+	if _, ok := sess.nextWarning(); ok {
+		t.Error("Expected no more warnings")
+	}
+
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.What)
+	}
+	want := []string{labelEnter, labelTick, "warn:20m0s", labelTick, "warn:25m0s"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if out.String() != "\n# WARNING: 20m0s elapsed\n\n# WARNING: 25m0s elapsed\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	s := ComputeStats(sess.Events)
+	if s.Ticks != 2 || !reflect.DeepEqual(s.Laps, []time.Duration{15 * time.Minute, 11 * time.Minute}) {
+		t.Errorf("Expected warnings not to affect laps, got %+v", s)
+	}
+}
+
+func TestDurationList(t *testing.T) {
+	var l durationList
+	for _, s := range []string{"25m", "20m", "1h"} {
+		if err := l.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Set("-1s"); err == nil {
+		t.Error("Expected negative duration to be rejected")
+	}
+	if got := l.sorted(); !reflect.DeepEqual(got, []time.Duration{20 * time.Minute, 25 * time.Minute, time.Hour}) {
+		t.Errorf("Unexpected order: %v", got)
+	}
+}
+
+func TestSessionReset(t *testing.T) {
+	steps := seconds(0, 1, 2, 5, 3, 4)
+	sess := newSession("", collectOptions{})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+	sess.start()
+	sess.handleLine("reset", &out) // nothing to reset
+	sess.handleLine("", &out)
+	sess.handleLine("", &out)
+	sess.handleLine("reset trial-2", &out)
+	if got := sess.prompt(); got != "# [trial-2] Waiting for [1]> " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	sess.handleLine("", &out)
+	sess.handleLine("", &out)
+	sess.finish()
+
+	if out.String() != "# No ticks since the start of the group, nothing to reset\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	var got []string
+	for i, evt := range sess.Events {
+		if evt.Seq != int64(i) {
+			t.Errorf("Expected continuous sequence numbers, got %v", sess.Events)
+		}
+		got = append(got, fmt.Sprintf("%d %s", evt.Group, evt.What))
+	}
+	want := []string{"0 enter", "0 tick", "0 tick", "1 reset:trial-2", "1 tick", "1 tick", "1 exit"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if laps := LapDurations(sess.Events); !reflect.DeepEqual(laps, seconds(1, 2, 3, 4)) {
+		t.Errorf("Expected the reset not to close a lap, got %v", laps)
+	}
+}
+
+func TestSessionNamedTimers(t *testing.T) {
+	steps := seconds(0, 1, 2, 3, 4, 5)
+	sess := newSession("", collectOptions{})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+	sess.start()
+	sess.handleLine("start compile", &out) // 1s
+	sess.handleLine("start tests", &out)   // 3s
+	sess.handleLine("start tests", &out)
+	if got := sess.prompt(); got != "# (compile, tests) Waiting for [3]> " {
+		t.Errorf("Unexpected prompt: %q", got)
+	}
+	sess.handleLine("stop compile", &out) // 6s
+	sess.handleLine("stop lint", &out)
+	sess.handleLine("", &out) // 10s
+	sess.finish()             // 15s
+
+	want := "# WARNING: timer \"tests\" is already running\n# WARNING: timer \"lint\" is not running\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	timers := NamedTimers(sess.Events)
+	expect := []TimerTotal{{"compile", 5 * time.Second, false}, {"tests", 12 * time.Second, true}}
+	if !reflect.DeepEqual(timers, expect) {
+		t.Errorf("Expected %v, got %v", expect, timers)
+	}
+	if laps := LapDurations(sess.Events); !reflect.DeepEqual(laps, seconds(10)) {
+		t.Errorf("Expected timer events not to affect laps, got %v", laps)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTimerSummary(&buf, timers); err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Timer compile: 5s\n# Timer tests: 12s (never stopped)\n"; buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestSessionUntil(t *testing.T) {
+	base := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	steps := []time.Duration{0, 0, 59*time.Second + 500*time.Millisecond, 0, 0, -time.Hour, 0, 2 * time.Hour}
+	sess := newSession("", collectOptions{Until: base.Add(time.Minute)})
+	sess.now = fakeClock(&steps)
+	sess.start()
+
+	// re-checked every second even when the deadline is far away, and
+	// exactly at the deadline when it is near
+	for i, want := range []time.Duration{time.Second, 500 * time.Millisecond} {
+		if wait, ok := sess.nextTimer(); !ok || wait != want {
+			t.Errorf("Check %d: expected to wait %v, got %v %v", i, want, wait, ok)
+		}
+		if sess.expired() {
+			t.Errorf("Check %d: expired too early", i)
+		}
+	}
+	// the clock stepping back an hour does not extend the wait
+	if wait, _ := sess.nextTimer(); wait != time.Second || sess.expired() {
+		t.Errorf("Expected to wait 1s after the clock step, got %v", wait)
+	}
+	if !sess.expired() {
+		t.Error("Expected the session to have expired")
+	}
+	if wait, _ := sess.nextTimer(); wait != 0 {
+		t.Errorf("Expected no wait past the deadline, got %v", wait)
+	}
+}
+
+func TestSessionRepeatLabel(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	var out bytes.Buffer
+	for _, line := range []string{".", "load", "mark x", ".", " !! ", "pause", ".", "resume", `\.`, ".", ". x"} {
+		sess.handleLine(line, &out)
+	}
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.What)
+	}
+	// marks and pause controls are skipped, and escaping records the text
+	want := []string{labelTick, "load", "mark:x", "load", "load", labelPause, labelResume, ".", ".", ". x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected labels %q, got %q", want, got)
+	}
+	wantOut := "# No previous label to repeat, recording tick\n" +
+		"# Repeated: load\n# Repeated: load\n" +
+		"# Paused, not recorded; type 'resume' to continue\n" +
+		"# Repeated: .\n"
+	if out.String() != wantOut {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	// repeating does not advance -labels
+	sess = newSession("", collectOptions{Labels: []string{"a", "b"}})
+	for _, line := range []string{"", ".", ""} {
+		sess.handleLine(line, &out)
+	}
+	got = got[:0]
+	for _, evt := range sess.Events {
+		got = append(got, evt.What)
+	}
+	if want := []string{"a", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected labels %q, got %q", want, got)
+	}
+}
+
+func TestSessionInputSource(t *testing.T) {
+	steps := []time.Duration{0, 2 * time.Second, time.Second}
+	sess := newSession("", collectOptions{})
+	sess.now = fakeClock(&steps)
+	sess.start()
+	t0 := sess.Events[0].Timestamp
+	var out bytes.Buffer
+
+	// received before the collector got to it
+	sess.handleInput(sourceTCP, t0.Add(time.Second), func() { sess.handleLine("a", &out) })
+	// received before the previous event: recorded now, to keep the order
+	sess.handleInput(sourceStdin, t0, func() { sess.handleLine("mark m", &out) })
+	sess.handleLine("b", &out)
+
+	want := []struct {
+		what, source string
+		offset       time.Duration
+	}{
+		{labelEnter, "", 0},
+		{"a", sourceTCP, time.Second},
+		{"mark:m", sourceStdin, 2 * time.Second},
+		{"b", "", 3 * time.Second},
+	}
+	if len(sess.Events) != len(want) {
+		t.Fatalf("Expected %d events, got %v", len(want), sess.Events)
+	}
+	for i, w := range want {
+		evt := sess.Events[i]
+		if evt.What != w.what || evt.Source != w.source || evt.Timestamp.Sub(t0) != w.offset {
+			t.Errorf("Event %d: expected %s from %q at +%v, got %+v", i, w.what, w.source, w.offset, evt)
+		}
+	}
+}
+
+func TestSessionKeepLast(t *testing.T) {
+	for _, keep := range []int{1, 3} {
+		sess := newSession("", collectOptions{KeepLast: keep})
+		sess.start()
+		var out bytes.Buffer
+		// enough ticks to wrap around the buffer of 2*keep events twice
+		for i := 1; i <= 4*keep+1; i++ {
+			evt, err := sess.recorded(&out, func(out io.Writer) { sess.handleLine(fmt.Sprint("t", i), out) })
+			if err != nil || evt.Seq != int64(i) {
+				t.Fatalf("keep %d: expected seq %d, got %d (%v)", keep, i, evt.Seq, err)
+			}
+			if len(sess.Events) > keep {
+				t.Fatalf("keep %d: %d events kept", keep, len(sess.Events))
+			}
+		}
+		sess.finish()
+
+		total := 4*keep + 3
+		if sess.Dropped != total-keep || len(sess.Events) != keep {
+			t.Errorf("keep %d: expected %d dropped and %d kept, got %d and %v", keep, total-keep, keep, sess.Dropped, sess.Events)
+		}
+		for i, evt := range sess.Events {
+			if want := int64(total - keep + i); evt.Seq != want {
+				t.Errorf("keep %d: event %d: expected seq %d, got %d", keep, i, want, evt.Seq)
+			}
+		}
+		if last := sess.Events[len(sess.Events)-1]; last.What != labelExit {
+			t.Errorf("keep %d: expected exit last, got %v", keep, last)
+		}
+	}
+}
+
+func TestSessionSeqStart(t *testing.T) {
+	start := int64(1) << 33
+	sess := newSession("", collectOptions{SeqStart: start})
+	sess.start()
+	var out bytes.Buffer
+	for _, line := range []string{"a", "b", "del! " + fmt.Sprint(start+1), "c"} {
+		sess.handleLine(line, &out)
+	}
+	sess.finish()
+	// renumbered without the deleted event, from the start
+	var seqs []int64
+	for _, evt := range sess.Events {
+		seqs = append(seqs, evt.Seq)
+	}
+	if want := []int64{start, start + 1, start + 2, start + 3}; !reflect.DeepEqual(seqs, want) {
+		t.Errorf("Expected %v, got %v (%q)", want, seqs, out.String())
+	}
+	if sess.startAt.IsZero() {
+		t.Error("Expected enter to start the session at any seq")
+	}
+
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if err := DumpEvents(path, sess.Events, OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, err := LoadCSV(path)
+	if err != nil || len(loaded) != len(seqs) || loaded[3].Seq != start+3 {
+		t.Errorf("Expected the seqs back, got %v, %v", loaded, err)
+	}
+	if v := validateFile(path); !v.Pass {
+		t.Errorf("Expected PASS, got %v", v)
+	}
+}
+
+func TestSessionKeepLastReset(t *testing.T) {
+	steps := seconds(0, 1, 1, 1, 1, 1)
+	sess := newSession("", collectOptions{KeepLast: 3})
+	sess.now = fakeClock(&steps)
+	sess.start()
+	var out bytes.Buffer
+	sess.handleLine("", &out)
+	sess.handleLine("reset", &out)
+	sess.handleLine("", &out)
+	// the group starts at the reset, which is still kept
+	if got := sess.Events[sess.groupStart].What; got != labelReset {
+		t.Errorf("Expected the group to start at the reset, got %q", got)
+	}
+	sess.handleLine("", &out)
+	sess.handleLine("", &out)
+	if sess.groupStart != 0 {
+		t.Errorf("Expected the group to start at the first event kept, got %d", sess.groupStart)
+	}
+	// elapsed times still count from "enter"
+	if sess.startAt.IsZero() || sess.Events[0].What == labelEnter {
+		t.Errorf("Expected enter to be dropped but remembered, got %v", sess.Events)
+	}
+}
+
+func TestDroppedComment(t *testing.T) {
+	tests := []struct {
+		comment string
+		dropped int
+		want    string
+	}{
+		{"run 1", 0, "run 1"},
+		{"", 3, "3 earlier events dropped by -keep-last"},
+		{"run 1", 3, "run 1 (3 earlier events dropped by -keep-last)"},
+	}
+	for _, tt := range tests {
+		if got := droppedComment(tt.comment, tt.dropped); got != tt.want {
+			t.Errorf("droppedComment(%q, %d): expected %q, got %q", tt.comment, tt.dropped, tt.want, got)
+		}
+	}
+}
+
+func TestSessionHelp(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	var out bytes.Buffer
+	sess.handleLine("help", &out)
+	help := out.String()
+	for _, cmd := range sessionCommands {
+		for _, name := range cmd.names {
+			if !strings.Contains(help, " "+name+" ") {
+				t.Errorf("Expected %q to be listed, got:\n%s", name, help)
+			}
+		}
+	}
+	out.Reset()
+	sess.handleLine("?", &out)
+	if out.String() != help {
+		t.Errorf("Expected ? to show the same help, got:\n%s", out.String())
+	}
+	if len(sess.Events) != 0 {
+		t.Errorf("Expected help to record nothing, got %v", sess.Events)
+	}
+	sess.handleLine(`\help`, &out)
+	if len(sess.Events) != 1 || sess.Events[0].What != "help" {
+		t.Errorf("Expected the escaped help to be a label, got %v", sess.Events)
+	}
+}
+
+func TestSessionTypoHint(t *testing.T) {
+	sess := newSession("", collectOptions{Labels: []string{"stpo"}})
+	for _, test := range []struct {
+		line string
+		hint string
+	}{
+		{"pasue", `did you mean "pause"`},
+		{"Resume", `did you mean "resume"`},
+		{"commnet here", `did you mean "comment"`},
+		{"rest", `did you mean "reset"`},
+		{"rest", ""}, // recorded already
+		{"stpo", ""}, // one of -labels
+		{"load", ""},
+		{"sotp", `did you mean "stop"`},
+		{"stap", ""}, // too short to tell
+	} {
+		var out bytes.Buffer
+		n := len(sess.Events)
+		sess.handleLine(test.line, &out)
+		if len(sess.Events) != n+1 || sess.Events[n].What != test.line {
+			t.Errorf("%q: expected to be recorded as a label, got %v", test.line, sess.Events[n:])
+		}
+		if got := out.String(); test.hint == "" && strings.Contains(got, "did you mean") {
+			t.Errorf("%q: expected no hint, got %q", test.line, got)
+		} else if !strings.Contains(got, test.hint) {
+			t.Errorf("%q: expected %q, got %q", test.line, test.hint, got)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0}, {"pause", "pause", 0}, {"", "stop", 4}, {"pasue", "pause", 1},
+		{"resme", "resume", 1}, {"reset", "rest", 1}, {"kitten", "sitting", 3}, {"ä", "a", 1},
+	} {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q): expected %d, got %d", test.a, test.b, test.want, got)
+		}
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	for _, flag := range []bool{false, true} {
+		sess := newSession("", collectOptions{IdleAfter: time.Minute, IdleFlag: flag})
+		now := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+		sess.now = func() time.Time { return now }
+		sess.start()
+		var out bytes.Buffer
+		now = now.Add(30 * time.Second)
+		sess.handleLine("a", &out)
+		now = now.Add(time.Minute)
+		sess.handleLine("b", &out) // exactly the threshold is not idle
+		now = now.Add(5 * time.Minute)
+		sess.handleLine("c", &out)
+		// a pause is never idle
+		sess.handleLine("pause", &out)
+		now = now.Add(time.Hour)
+		sess.handleLine("resume", &out)
+		now = now.Add(30 * time.Second)
+		sess.handleLine("d", &out)
+		sess.finish()
+
+		var got []string
+		for _, evt := range sess.Events {
+			got = append(got, evt.What+evt.Flag)
+		}
+		want := "enter a b idle c pause resume d exit"
+		wantOut := "# Idle for 4m0s, not counted in the lap\n"
+		if flag {
+			want, wantOut = "enter a b cidle pause resume d exit", "# Idle for over 1m0s, flagged\n"
+		}
+		if strings.Join(got, " ") != want || out.String() != wantOut {
+			t.Errorf("flag %v: expected %q and %q, got %q and %q", flag, want, wantOut, got, out.String())
+		}
+		if !flag {
+			if idle := sess.Events[3]; !idle.Timestamp.Equal(sess.Events[2].Timestamp.Add(time.Minute)) || idle.Source != sourceTimer {
+				t.Errorf("Expected the idle event a minute after b, got %+v", idle)
+			}
+		}
+	}
+
+	// an idle event is never before an event recorded already
+	sess := newSession("", collectOptions{IdleAfter: time.Minute, WarnAt: []time.Duration{90 * time.Second}})
+	now := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	sess.now = func() time.Time { return now }
+	sess.start()
+	now = now.Add(2 * time.Minute)
+	sess.checkTimers(io.Discard)
+	sess.handleLine("a", io.Discard)
+	if evts := sess.Events; len(evts) != 4 || evts[2].What != labelIdle || evts[2].Timestamp.Before(evts[1].Timestamp) {
+		t.Errorf("Expected the idle event after the warning, got %+v", evts)
+	}
+}
+
+func TestSessionAgain(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	var out bytes.Buffer
+	sess.handleLine("again", &out)
+	sess.start()
+	for _, line := range []string{"again", "42 build build=release category=ci arch=arm64", "again", "again x",
+		"mark m", "again", "pause", "again", "resume", "again", "reset", "again"} {
+		sess.handleLine(line, &out)
+	}
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, evt.What)
+	}
+	want := []string{labelEnter, "build", "build", "again x", "mark:m", labelPause, labelResume, labelReset}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected labels %q, got %q", want, got)
+	}
+	// the category and the other attributes are copied, the value is not
+	if evt := sess.Events[2]; !reflect.DeepEqual(evt.Attrs, sess.Events[1].Attrs) || evt.Value != nil {
+		t.Errorf("Expected a copy of the attributes only, got %+v", evt)
+	}
+	sess.Events[2].Attrs["arch"] = "x86"
+	if sess.Events[1].Attrs["arch"] != "arm64" {
+		t.Error("Expected the attributes to be copied, not shared")
+	}
+	wantOut := "# Nothing to repeat yet, not recorded\n" +
+		"# The previous event \"enter\" starts the session, not recorded\n" +
+		"# Again: build category=ci arch=arm64 build=release\n" +
+		"# The previous event \"mark:m\" is a milestone; use 'mark <name>' for another, not recorded\n" +
+		"# The previous event \"pause\" is a pause control, not recorded\n" +
+		"# The previous event \"resume\" is a pause control, not recorded\n" +
+		"# The previous event \"reset\" starts a lap group; use 'reset' for another, not recorded\n"
+	if out.String() != wantOut {
+		t.Errorf("Expected:\n%s\ngot:\n%s", wantOut, out.String())
+	}
+
+	// a copy is a tick like any other
+	sess = newSession("", collectOptions{MaxEvents: 2})
+	sess.start()
+	out.Reset()
+	for _, line := range []string{"a k=v", "again", "again"} {
+		sess.handleLine(line, &out)
+	}
+	if len(sess.Events) != 3 || !strings.HasSuffix(out.String(), "# Reached 2 events, not recorded\n") {
+		t.Errorf("Expected -max-events to apply, got %v, %q", sess.Events, out.String())
+	}
+}
+
+func TestSessionAgainDelete(t *testing.T) {
+	// there is no undo: a copy is taken back with del, and again then copies
+	// the event now before it
+	sess := newSession("", collectOptions{})
+	var out bytes.Buffer
+	sess.start()
+	for _, line := range []string{"a category=x", "b k=v", "again", "del! 3", "again", "del! 2", "del! 4", "again"} {
+		sess.handleLine(line, &out)
+	}
+	var got []string
+	for _, evt := range sess.Events {
+		got = append(got, fmt.Sprintf("%d %s %v", evt.Seq, evt.What, evt.Attrs))
+	}
+	want := []string{"0 enter map[]", "1 a map[category:x]", "5 a map[category:x]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if !strings.HasSuffix(out.String(), "# Again: a category=x\n") {
+		t.Errorf("Expected the copy of a, got %q", out.String())
+	}
+
+	// deleting down to enter leaves nothing to copy
+	sess.handleLine("del! 5", &out)
+	sess.handleLine("del! 1", &out)
+	out.Reset()
+	sess.handleLine("again", &out)
+	if want := "# The previous event \"enter\" starts the session, not recorded\n"; out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventSink mirrors the recorded events somewhere else than the output
+// file, e.g. into the system log. Sinks are best effort: failures are
+// reported, but they never interrupt recording.
+type eventSink interface {
+	// Send is called with every event as soon as it is recorded
+	Send(evt Event)
+	// Close is called at exit with the statistics of the session
+	Close(s Stats)
+}
+
+// editSink is an eventSink keeping the events recorded so far, which is
+// also told when they are edited afterwards
+type editSink interface {
+	eventSink
+	// Edited is called with the events of the session once some of them
+	// were deleted, moved or relabeled
+	Edited(events []Event)
+}
+
+// sinkKinds lists the optional sinks compiled in, by name, with a
+// description. The file implementing a sink registers it, so that
+// the list follows the build tags; see the version command.
+var sinkKinds = map[string]string{}
+
+// eventMessage formats evt as a single line of key=value pairs
+func eventMessage(evt Event, session string) string {
+	return fmt.Sprintf("seq=%d ts=%s what=%s session=%s", evt.Seq, evt.Timestamp.Format(time.RFC3339Nano),
+		strconv.Quote(evt.What), session)
+}
+
+// summaryMessage formats s as a single line of key=value pairs
+func summaryMessage(s Stats, session string) string {
+	return fmt.Sprintf("summary total=%s ticks=%d laps=%d session=%s", formatDuration(s.Total), s.Ticks,
+		len(s.Laps), session)
+}
+
+// sinkErrors reports the failures of a sink into out, only the first one
+// of each sink so that a broken sink does not flood the terminal
+type sinkErrors struct {
+	mu       sync.Mutex
+	out      io.Writer
+	name     string
+	reported bool
+}
+
+func (e *sinkErrors) report(err error) {
+	if err == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.reported {
+		fmt.Fprintf(e.out, "\n# WARNING: %s: %v (further errors are not shown)\n", e.name, err)
+		e.reported = true
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recordingSink collects the events sent to it
+type recordingSink struct {
+	events []Event
+}
+
+func (r *recordingSink) Send(evt Event) { r.events = append(r.events, evt) }
+func (r *recordingSink) Close(Stats)    {}
+
+func TestSessionSinks(t *testing.T) {
+	var sink recordingSink
+	sess := newSession("", collectOptions{Sinks: []eventSink{&sink}})
+	var out bytes.Buffer
+	sess.start()
+	sess.handleLine("", &out)
+	sess.handleLine("mark x", &out)
+	sess.finish()
+	if !reflect.DeepEqual(sink.events, sess.Events) {
+		t.Errorf("Expected the sink to get every event:\n%v\ngot:\n%v", sess.Events, sink.events)
+	}
+}
+
+func TestSinkErrors(t *testing.T) {
+	var out bytes.Buffer
+	errs := sinkErrors{out: &out, name: "syslog"}
+	errs.report(nil)
+	errs.report(errors.New("connection refused"))
+	errs.report(errors.New("again"))
+	if want := "\n# WARNING: syslog: connection refused (further errors are not shown)\n"; out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// slackTimeout limits the time spent posting to a Slack webhook
+const slackTimeout = 10 * time.Second
+
+// SlackPayload builds the JSON payload of a Slack incoming webhook message
+// summarizing events: the comment, the total duration and the lap
+// statistics, and up to laps rows of the lap table (none if laps is 0).
+func SlackPayload(events []Event, comment string, laps int) ([]byte, error) {
+	var b strings.Builder
+	if comment != "" {
+		fmt.Fprintf(&b, "*%s*\n", comment)
+	}
+	s := ComputeStats(events)
+	fmt.Fprintf(&b, "Total: %s, laps: %d", formatDuration(s.Total), len(s.Laps))
+	if len(s.Laps) > 0 {
+		fmt.Fprintf(&b, " (min: %s, avg: %s, max: %s)",
+			formatDuration(s.Min), formatDuration(s.Mean), formatDuration(s.Max))
+	}
+	if laps > 0 && len(s.Laps) > 0 {
+		b.WriteString("\n```\n")
+		for i, lap := range s.Laps {
+			if i == laps {
+				fmt.Fprintf(&b, "... %d more\n", len(s.Laps)-laps)
+				break
+			}
+			fmt.Fprintf(&b, "%4d  %s\n", i+1, formatDuration(lap))
+		}
+		b.WriteString("```")
+	}
+	return json.Marshal(map[string]string{"text": b.String()})
+}
+
+// postSlack posts payload to the webhook at url
+func postSlack(url string, payload []byte) error {
+	client := http.Client{Timeout: slackTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSlackPayload(t *testing.T) {
+	events := testEvents(seconds(2, 4, 3, 1)...)
+	for _, tc := range []struct {
+		comment string
+		laps    int
+		want    string
+	}{
+		{"", 0, "Total: 10s, laps: 3 (min: 2s, avg: 3s, max: 4s)"},
+		{"run 1", 2, "*run 1*\nTotal: 10s, laps: 3 (min: 2s, avg: 3s, max: 4s)\n```\n   1  2s\n   2  4s\n... 1 more\n```"},
+		{"", 5, "Total: 10s, laps: 3 (min: 2s, avg: 3s, max: 4s)\n```\n   1  2s\n   2  4s\n   3  3s\n```"},
+	} {
+		data, err := SlackPayload(events, tc.comment, tc.laps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]string
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg["text"] != tc.want {
+			t.Errorf("Expected:\n%s\ngot:\n%s", tc.want, msg["text"])
+		}
+	}
+	data, _ := SlackPayload(testEvents(seconds(1)...), "", 3)
+	if string(data) != `{"text":"Total: 1s, laps: 0"}` {
+		t.Errorf("Unexpected payload without laps: %s", data)
+	}
+}
+
+func TestPostSlack(t *testing.T) {
+	var got []byte
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "invalid_token")
+	}))
+	defer srv.Close()
+
+	if err := postSlack(srv.URL, []byte(`{"text":"hi"}`)); err != nil || string(got) != `{"text":"hi"}` {
+		t.Errorf("Expected the payload to be posted, got %q, %v", got, err)
+	}
+	status = http.StatusForbidden
+	if err := postSlack(srv.URL, nil); err == nil || err.Error() != "403 Forbidden: invalid_token" {
+		t.Errorf("Expected the status in the error, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// Characters used for drawing sparklines, from lowest to highest
+var (
+	sparkUnicode = []rune("▁▂▃▄▅▆▇█")
+	sparkASCII   = []rune("_.,-=+*#")
+)
+
+// SparkOptions controls the sparkline drawn into summaries and reports
+type SparkOptions struct {
+	Width int  // maximum number of characters; 0 disables the sparkline
+	ASCII bool // use ASCII characters instead of unicode block elements
+}
+
+// Sparkline renders laps in chronological order as a line of block
+// characters scaled between the shortest and the longest lap. If there are
+// more laps than width, consecutive laps are averaged so that the result
+// fits into width characters. Returns "" if there are fewer than two laps.
+func Sparkline(laps []time.Duration, width int, ascii bool) string {
+	if len(laps) < 2 || width <= 0 {
+		return ""
+	}
+	values := laps
+	if len(laps) > width {
+		values = make([]time.Duration, width)
+		for i := range values {
+			group := laps[i*len(laps)/width : (i+1)*len(laps)/width]
+			var sum time.Duration
+			for _, lap := range group {
+				sum += lap
+			}
+			values[i] = sum / time.Duration(len(group))
+		}
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	chars := sparkUnicode
+	if ascii {
+		chars = sparkASCII
+	}
+	var sb strings.Builder
+	for _, v := range values {
+		level := 0
+		if hi > lo {
+			top := float64(len(chars) - 1)
+			level = int(float64(v-lo)/float64(hi-lo)*top + 0.5)
+		}
+		sb.WriteRune(chars[level])
+	}
+	return sb.String()
+}
+
+// unicodeLocale reports whether the locale settings in the environment
+// indicate that the terminal can display UTF-8 encoded text.
+func unicodeLocale() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			v = strings.ToLower(v)
+			return strings.Contains(v, "utf-8") || strings.Contains(v, "utf8")
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		laps   []float64
+		width  int
+		ascii  bool
+		expect string
+	}{
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8}, 80, false, "▁▂▃▄▅▆▇█"},
+		{[]float64{1, 8, 1, 8}, 80, true, "_#_#"},
+		{[]float64{2, 2, 2}, 80, false, "▁▁▁"},
+		{[]float64{1, 3, 5, 7, 2, 4}, 3, false, "▁█▃"},
+		{[]float64{5}, 80, false, ""},
+		{nil, 80, false, ""},
+		{[]float64{1, 2}, 0, false, ""},
+	}
+	for _, test := range tests {
+		if got := Sparkline(seconds(test.laps...), test.width, test.ascii); got != test.expect {
+			t.Errorf("Sparkline(%v, %d, %v): expected %q, got %q", test.laps, test.width, test.ascii, test.expect, got)
+		}
+	}
+}
+
+func TestUnicodeLocale(t *testing.T) {
+	tests := []struct {
+		lcAll, lang string
+		expect      bool
+	}{
+		{"", "en_US.UTF-8", true},
+		{"", "fi_FI.utf8", true},
+		{"C", "en_US.UTF-8", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		t.Setenv("LC_ALL", test.lcAll)
+		t.Setenv("LC_CTYPE", "")
+		t.Setenv("LANG", test.lang)
+		if got := unicodeLocale(); got != test.expect {
+			t.Errorf("LC_ALL=%q LANG=%q: expected %v, got %v", test.lcAll, test.lang, test.expect, got)
+		}
+	}
+}
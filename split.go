@@ -0,0 +1,124 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// splitSessionName is the part of the file name of -split-by-label that
+// receives the events recorded by the collector itself, e.g. "enter",
+// "exit", pauses and marks
+const splitSessionName = "session"
+
+// splitNameMaxLength is the length, in characters, label file names are
+// cut to
+const splitNameMaxLength = 64
+
+// outputFile is one of the files DumpEvents writes the events into
+type outputFile struct {
+	path   string
+	events []Event
+}
+
+// outputFiles returns the files events are written into when dumped into
+// the file outFile: one per label with opts.SplitByLabel, see splitByLabel,
+// each then split further by rotateParts
+func outputFiles(outFile string, encode Encoder, events []Event, opts OutputOptions) ([]outputFile, error) {
+	split := []outputFile{{outFile, events}}
+	if opts.SplitByLabel {
+		split = splitByLabel(outFile, events)
+	}
+	var files []outputFile
+	for _, s := range split {
+		parts, err := rotateParts(encode, s.events, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i, part := range parts {
+			files = append(files, outputFile{rotatedName(s.path, i), part})
+		}
+	}
+	return files, nil
+}
+
+// splitByLabel groups events by label into the files "<base>.<label><ext>"
+// of path, see labelFileName. The events recorded by the collector itself
+// are written into "<base>.session<ext>", which comes first; the labels
+// follow in the order of their first event. The events keep their
+// sequence numbers, so the files can be merged back by them.
+func splitByLabel(path string, events []Event) []outputFile {
+	files := []outputFile{{path: splitName(path, splitSessionName)}}
+	index := map[string]int{"": 0}                   // label to files, "" for the session file
+	taken := map[string]bool{splitSessionName: true} // file names in lower case, for case insensitive file systems
+	for _, evt := range events {
+		label := evt.What
+		if isReserved(label) {
+			label = ""
+		}
+		i, ok := index[label]
+		if !ok {
+			name := labelFileName(label)
+			unique := name
+			for n := 2; taken[strings.ToLower(unique)]; n++ {
+				unique = fmt.Sprintf("%s-%d", name, n)
+			}
+			taken[strings.ToLower(unique)] = true
+			i = len(files)
+			index[label] = i
+			files = append(files, outputFile{path: splitName(path, unique)})
+		}
+		files[i].events = append(files[i].events, evt)
+	}
+	if len(files[0].events) == 0 {
+		files = files[1:]
+	}
+	return files
+}
+
+// labelFileName makes label safe to use in a file name: letters, digits,
+// '-' and '_' are kept and everything else becomes '_', e.g. "a/b c" is
+// "a_b_c". Long labels are cut to splitNameMaxLength characters.
+func labelFileName(label string) string {
+	name := []rune(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, label))
+	if len(name) > splitNameMaxLength {
+		name = name[:splitNameMaxLength]
+	}
+	if len(name) == 0 {
+		return "_"
+	}
+	return string(name)
+}
+
+// splitName returns the name of the file of -split-by-label named name:
+// "<base>.<name><ext>" of path, keeping a compression suffix last, e.g.
+// "out.csv.gz" becomes "out.<name>.csv.gz"
+func splitName(path, name string) string {
+	var suffix string
+	if c := compressionBySuffix(path); c != "" {
+		suffix = compressions[c].suffix
+		path = strings.TrimSuffix(path, suffix)
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext + suffix
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitByLabel(t *testing.T) {
+	events := testEvents(time.Second, time.Second, time.Second, time.Second, time.Second, time.Second, time.Second)
+	for i, label := range []string{"run", "a/b", "mark:half", "a?b", "session", "run"} {
+		events[i+1].What = label
+	}
+	files := splitByLabel("out/s.csv.gz", events)
+	want := map[string][]int64{
+		"out/s.session.csv.gz":   {0, 3, 7},
+		"out/s.run.csv.gz":       {1, 6},
+		"out/s.a_b.csv.gz":       {2},
+		"out/s.a_b-2.csv.gz":     {4},
+		"out/s.session-2.csv.gz": {5},
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.path)
+		var seqs []int64
+		for _, evt := range f.events {
+			seqs = append(seqs, evt.Seq)
+		}
+		if !reflect.DeepEqual(seqs, want[f.path]) {
+			t.Errorf("%s: expected the events %v, got %v", f.path, want[f.path], seqs)
+		}
+	}
+	if wantPaths := []string{"out/s.session.csv.gz", "out/s.run.csv.gz", "out/s.a_b.csv.gz",
+		"out/s.a_b-2.csv.gz", "out/s.session-2.csv.gz"}; !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("Expected the files %v, got %v", wantPaths, paths)
+	}
+
+	// without the collector events there is no session file
+	files = splitByLabel("out", events[1:2])
+	if len(files) != 1 || files[0].path != "out.run" {
+		t.Errorf("Expected a single file out.run, got %v", files)
+	}
+}
+
+func TestLabelFileName(t *testing.T) {
+	for label, want := range map[string]string{
+		"run 1": "run_1", "../etc": "___etc", "Kör-ä_2": "Kör-ä_2", "": "_",
+		strings.Repeat("x", 100): strings.Repeat("x", splitNameMaxLength),
+	} {
+		if got := labelFileName(label); got != want {
+			t.Errorf("%q: expected %q, got %q", label, want, got)
+		}
+	}
+}
+
+func TestDumpEventsSplitByLabel(t *testing.T) {
+	dir := t.TempDir()
+	events := testEvents(0, 0) // merged back by their sequence numbers
+	events[1].What = "run"
+	opts := OutputOptions{SplitByLabel: true, Comment: "run 1", RotateEvents: 1}
+	if err := DumpEvents(filepath.Join(dir, "out.csv"), events, opts); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if want := []string{"out.run.csv", "out.session.1.csv", "out.session.csv"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected the files %v, got %v", want, names)
+	}
+	loaded, comment, err := LoadCSV(filepath.Join(dir, "out.run.csv"))
+	if err != nil || comment != "run 1" || !reflect.DeepEqual(loaded, events[1:2]) {
+		t.Errorf("Unexpected events of the label: %v, %q, %v", loaded, comment, err)
+	}
+	if merged, _, err := LoadCSVFiles([]string{filepath.Join(dir, "out.*.csv")}); err != nil || !reflect.DeepEqual(merged, events) {
+		t.Errorf("Expected the events merged back, got %v, %v", merged, err)
+	}
+}
@@ -0,0 +1,538 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats contains summary statistics computed from a sequence of events.
+// The same computation is used by the exit summary and the report
+// subcommand, so the numbers always agree.
+type Stats struct {
+	Total  time.Duration   // time between the first and the last event
+	Active time.Duration   // Total minus the time spent paused
+	Ticks  int             // number of events recorded by user input, excluding marks
+	Marks  int             // number of milestones recorded with "mark"
+	Laps   []time.Duration // lap durations, in chronological order
+	Min    time.Duration   // shortest lap (zero if no laps)
+	Mean   time.Duration   // average lap (zero if no laps)
+	Max    time.Duration   // longest lap (zero if no laps)
+	StdDev time.Duration   // population standard deviation of laps
+}
+
+// LapDurations computes the duration of each lap in events. A lap is the
+// interval between an event and the previous event, and it is closed by any
+// event other than the "enter" and "exit" sentinels. The partial interval
+// closed by the "exit" event is therefore not a lap, and neither is the one
+// closed by a "reset", which starts a new group. Marks, warnings and named
+// timer events are skipped entirely, so a lap may span over any number of
+// them. Time spent paused is not counted in the laps, and neither is an
+// idle gap, from an "idle" event until the next one.
+func LapDurations(events []Event) []time.Duration {
+	var laps []time.Duration
+	forEachLap(events, func(_ Event, lap time.Duration) {
+		laps = append(laps, lap)
+	})
+	return laps
+}
+
+// forEachLap calls fn with each lap in events, as described in
+// LapDurations, and the event closing it
+func forEachLap(events []Event, fn func(evt Event, lap time.Duration)) {
+	forEachLapIndex(events, func(i int, lap time.Duration) {
+		fn(events[i], lap)
+	})
+}
+
+// forEachLapIndex is like forEachLap, but calls fn with the index of the
+// event closing each lap
+func forEachLapIndex(events []Event, fn func(i int, lap time.Duration)) {
+	if len(events) == 0 {
+		return
+	}
+	var p pauseTracker
+	var idleAt time.Time
+	start := events[0].Timestamp
+	for i := 1; i < len(events); i++ {
+		evt := events[i]
+		if p.track(evt) || isAnnotation(evt.What) {
+			continue
+		}
+		if isIdle(evt.What) {
+			idleAt = evt.Timestamp
+			continue
+		}
+		paused := p.until(evt.Timestamp)
+		if !idleAt.IsZero() {
+			paused += evt.Timestamp.Sub(idleAt)
+			idleAt = time.Time{}
+		}
+		if !isSentinel(evt.What) && !isReset(evt.What) && !isGo(evt.What) {
+			fn(i, evt.Timestamp.Sub(start)-paused)
+		}
+		start = evt.Timestamp
+		p.paused = 0
+	}
+}
+
+// lapSpan is a part of a lap without pauses or idle gaps
+type lapSpan struct {
+	start, end time.Time
+	closer     int // index of the event closing the lap
+}
+
+// lapSpans returns the laps in events, as described in LapDurations, as
+// spans of time: a lap over a pause or an idle gap is split around it into
+// spans closed by the same event
+func lapSpans(events []Event) []lapSpan {
+	if len(events) == 0 {
+		return nil
+	}
+	var spans, lap []lapSpan
+	start := events[0].Timestamp // of the current span; zero while paused or idle
+	var paused, idle, suspension bool
+	for i := 1; i < len(events); i++ {
+		evt := events[i]
+		t := evt.Timestamp
+		suspended, resumed := excludedSuspension(evt)
+		switch {
+		case suspended:
+			if !start.IsZero() {
+				lap = append(lap, lapSpan{start: start, end: t})
+				start, suspension = time.Time{}, true
+			}
+		case resumed:
+			if suspension {
+				start, suspension = t, false
+			}
+		case isAnnotation(evt.What):
+		case evt.What == labelPause || isIdle(evt.What):
+			if !start.IsZero() {
+				lap = append(lap, lapSpan{start: start, end: t})
+				start = time.Time{}
+			}
+			paused = paused || evt.What == labelPause
+			idle = idle || isIdle(evt.What)
+		case evt.What == labelResume:
+			if paused && !idle {
+				start = t
+			}
+			paused = false
+		default:
+			if !start.IsZero() {
+				lap = append(lap, lapSpan{start: start, end: t})
+			}
+			if !isSentinel(evt.What) && !isReset(evt.What) && !isGo(evt.What) {
+				for _, span := range lap {
+					span.closer = i
+					spans = append(spans, span)
+				}
+			}
+			lap, idle = nil, false
+			if start = t; paused {
+				start = time.Time{}
+			}
+		}
+	}
+	return spans
+}
+
+// LabelLaps are the laps closed by events with the same label
+type LabelLaps struct {
+	Label string
+	Laps  []time.Duration
+}
+
+// LapsByLabel groups the laps in events by the label of the event closing
+// each lap, in the order the labels first appear
+func LapsByLabel(events []Event) []LabelLaps {
+	var labels []LabelLaps
+	index := make(map[string]int)
+	forEachLap(events, func(evt Event, lap time.Duration) {
+		i, ok := index[evt.What]
+		if !ok {
+			i = len(labels)
+			index[evt.What] = i
+			labels = append(labels, LabelLaps{Label: evt.What})
+		}
+		labels[i].Laps = append(labels[i].Laps, lap)
+	})
+	return labels
+}
+
+// WriteLabelSummary writes the lap count, total and average of each label
+// into out, with the "# " prefix like WriteSummary
+func WriteLabelSummary(out io.Writer, labels []LabelLaps) error {
+	for _, ll := range labels {
+		var total time.Duration
+		for _, lap := range ll.Laps {
+			total += lap
+		}
+		_, err := fmt.Fprintf(out, "# Label %s: laps: %d, total: %s, avg: %s\n", ll.Label, len(ll.Laps),
+			formatDuration(total), formatDuration(total/time.Duration(len(ll.Laps))))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PausedDuration computes the total time spent paused in events. An
+// unfinished pause lasts until the last event.
+func PausedDuration(events []Event) time.Duration {
+	var p pauseTracker
+	for _, evt := range events {
+		p.track(evt)
+	}
+	if len(events) == 0 {
+		return 0
+	}
+	return p.until(events[len(events)-1].Timestamp)
+}
+
+// IdleDuration computes the idle time in events, and the number of idle
+// gaps: the time from each "idle" event until the next one that is not an
+// annotation, see -idle-after. With -idle-flag, each lap flagged "idle"
+// counts as idle as a whole.
+func IdleDuration(events []Event) (time.Duration, int) {
+	var idle time.Duration
+	gaps := 0
+	var idleAt time.Time
+	for _, evt := range events {
+		switch {
+		case isIdle(evt.What):
+			idleAt = evt.Timestamp
+		case isAnnotation(evt.What) || idleAt.IsZero():
+		default:
+			idle += evt.Timestamp.Sub(idleAt)
+			gaps++
+			idleAt = time.Time{}
+		}
+	}
+	forEachLap(events, func(evt Event, lap time.Duration) {
+		if evt.Flag == flagIdle {
+			idle += lap
+			gaps++
+		}
+	})
+	return idle, gaps
+}
+
+// pauseTracker accumulates paused time from "pause" and "resume" events,
+// and from the suspensions of -exclude-suspended
+type pauseTracker struct {
+	paused    time.Duration // accumulated paused time
+	pausedAt  time.Time     // start of the current pause, zero if running
+	suspended bool          // the current pause is a suspension, ended by "resumed" only
+}
+
+// track updates the state from evt, and reports whether it was a pause
+// control event. Repeated pauses and resumes are ignored.
+func (p *pauseTracker) track(evt Event) bool {
+	suspended, resumed := excludedSuspension(evt)
+	switch {
+	case evt.What == labelPause || suspended:
+		if p.pausedAt.IsZero() {
+			p.pausedAt, p.suspended = evt.Timestamp, suspended
+		}
+	case evt.What == labelResume || resumed:
+		if !p.pausedAt.IsZero() && (!resumed || p.suspended) {
+			p.paused += evt.Timestamp.Sub(p.pausedAt)
+			p.pausedAt = time.Time{}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// until returns the accumulated paused time, including an ongoing pause up
+// to t
+func (p *pauseTracker) until(t time.Time) time.Duration {
+	if !p.pausedAt.IsZero() {
+		p.paused += t.Sub(p.pausedAt)
+		p.pausedAt = t
+	}
+	return p.paused
+}
+
+// ComputeStats computes summary statistics from events
+func ComputeStats(events []Event) Stats {
+	var s Stats
+	if len(events) == 0 {
+		return s
+	}
+	s.Total = events[len(events)-1].Timestamp.Sub(events[0].Timestamp)
+	for _, evt := range events {
+		switch {
+		case isMark(evt.What):
+			s.Marks++
+		case !isReserved(evt.What):
+			s.Ticks++
+		}
+	}
+	s.Active = s.Total - PausedDuration(events)
+	s.Laps = LapDurations(events)
+	if len(s.Laps) == 0 {
+		return s
+	}
+	var sum time.Duration
+	s.Min, s.Max = s.Laps[0], s.Laps[0]
+	for _, lap := range s.Laps {
+		sum += lap
+		if lap < s.Min {
+			s.Min = lap
+		}
+		if lap > s.Max {
+			s.Max = lap
+		}
+	}
+	s.Mean = sum / time.Duration(len(s.Laps))
+	var sqsum float64
+	for _, lap := range s.Laps {
+		diff := float64(lap - s.Mean)
+		sqsum += diff * diff
+	}
+	s.StdDev = time.Duration(math.Sqrt(sqsum / float64(len(s.Laps))))
+	return s
+}
+
+// Percentile computes the p:th percentile (0 <= p <= 100) of laps using the
+// nearest-rank method: the result is the smallest lap such that at least p
+// percent of the laps are less than or equal to it. Percentiles finer than
+// the resolution of the data set are therefore clamped to the maximum.
+// The laps must be sorted in ascending order. Returns zero if laps is empty.
+func Percentile(laps []time.Duration, p float64) time.Duration {
+	if len(laps) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(laps))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(laps) {
+		rank = len(laps)
+	}
+	return laps[rank-1]
+}
+
+// Percentiles computes multiple percentiles of (possibly unsorted) laps.
+// See Percentile for the method.
+func Percentiles(laps []time.Duration, ps []float64) []time.Duration {
+	sorted := append([]time.Duration(nil), laps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	result := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		result[i] = Percentile(sorted, p)
+	}
+	return result
+}
+
+// ParsePercentiles parses a comma separated list of percentiles, such as
+// "50,95,99.9".
+func ParsePercentiles(spec string) ([]float64, error) {
+	var ps []float64
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		p, err := strconv.ParseFloat(field, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentile %q: must be a number between 0 and 100", field)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// formatPercentile renders p as a label such as "p50" or "p99.9"
+func formatPercentile(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// formatDuration renders a duration for human consumption, rounded to
+// milliseconds.
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// WriteSummary writes a short human readable summary of s into out,
+// optionally including a sparkline of the laps. Each line is prefixed with
+// "# ", like the other informational messages.
+func WriteSummary(out io.Writer, s Stats, spark SparkOptions) error {
+	return writeSummary(out, "# ", s, spark)
+}
+
+// WriteTargetSummary writes the number of laps in s that beat target into
+// out, prefixed like WriteSummary
+func WriteTargetSummary(out io.Writer, s Stats, target time.Duration) error {
+	beaten := 0
+	for _, lap := range s.Laps {
+		if lap < target {
+			beaten++
+		}
+	}
+	_, err := fmt.Fprintf(out, "# Target: %s, beaten: %d of %d laps, cumulative: %s\n",
+		formatDuration(target), beaten, len(s.Laps), formatDeviation(CumulativeDeviation(s.Laps, target)))
+	return err
+}
+
+// CumulativeDeviation returns the sum of the differences of laps to target;
+// positive when behind the target pace
+func CumulativeDeviation(laps []time.Duration, target time.Duration) time.Duration {
+	var total time.Duration
+	for _, lap := range laps {
+		total += lap - target
+	}
+	return total
+}
+
+// formatDeviation formats d like formatDuration, always with a sign
+func formatDeviation(d time.Duration) string {
+	if d < 0 {
+		return "-" + formatDuration(-d)
+	}
+	return "+" + formatDuration(d)
+}
+
+func writeSummary(out io.Writer, prefix string, s Stats, spark SparkOptions) error {
+	extra := ""
+	if s.Active != s.Total {
+		extra += fmt.Sprintf(", active: %s", formatDuration(s.Active))
+	}
+	if s.Marks > 0 {
+		extra += fmt.Sprintf(", marks: %d", s.Marks)
+	}
+	if _, err := fmt.Fprintf(out, "%sTotal: %s, ticks: %d%s\n", prefix, formatDuration(s.Total), s.Ticks, extra); err != nil {
+		return err
+	}
+	if len(s.Laps) == 0 {
+		_, err := fmt.Fprintf(out, "%sLaps: no laps recorded\n", prefix)
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%sLaps: %d, min: %s, avg: %s, max: %s\n", prefix, len(s.Laps),
+		formatDuration(s.Min), formatDuration(s.Mean), formatDuration(s.Max))
+	if err != nil {
+		return err
+	}
+	const label = "Trend: "
+	if line := Sparkline(s.Laps, spark.Width-len(prefix)-len(label), spark.ASCII); line != "" {
+		_, err = fmt.Fprintf(out, "%s%s%s\n", prefix, label, line)
+	}
+	return err
+}
+
+// WriteStatsFooter writes s into out as "# key: value" comment lines, with
+// the durations in the style of the duration columns of opts. The footer is
+// meant to be appended after the CSV records; the parser skips it.
+func WriteStatsFooter(out io.Writer, s Stats, opts OutputOptions) error {
+	for _, line := range statsFooterLines(s, opts) {
+		if _, err := fmt.Fprintf(out, "# %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsFooterLines formats s as "key: value" lines, without the comment
+// prefix. The durations are written like the duration columns, see
+// OutputOptions.cell: as seconds, or in Go style, followed by a line of the
+// seconds with secondsSuffix for durationStyleBoth.
+func statsFooterLines(s Stats, opts OutputOptions) []string {
+	var lines []string
+	duration := func(key string, d time.Duration) {
+		if !opts.goDurations() {
+			lines = append(lines, key+": "+formatSeconds(d.Seconds()))
+			return
+		}
+		lines = append(lines, key+": "+d.String())
+		if opts.DurationStyle == durationStyleBoth {
+			lines = append(lines, key+secondsSuffix+": "+formatSeconds(d.Seconds()))
+		}
+	}
+	duration("total", s.Total)
+	lines = append(lines, "ticks: "+fmt.Sprint(s.Ticks), "laps: "+fmt.Sprint(len(s.Laps)))
+	if len(s.Laps) > 0 {
+		duration("min_lap", s.Min)
+		duration("mean_lap", s.Mean)
+		duration("max_lap", s.Max)
+	}
+	return lines
+}
+
+// TimerTotal is the total duration of a named timer
+type TimerTotal struct {
+	Name    string
+	Total   time.Duration // sum of the start to stop intervals
+	Running bool          // started but never stopped; counted until the last event
+}
+
+// NamedTimers pairs the "start:<name>" and "stop:<name>" events in events,
+// and returns the timers in the order they were first started. Stops of
+// timers that are not running are ignored.
+func NamedTimers(events []Event) []TimerTotal {
+	var timers []TimerTotal
+	index := make(map[string]int)
+	started := make(map[string]time.Time)
+	for _, evt := range events {
+		if name := strings.TrimPrefix(evt.What, labelStartPrefix); name != evt.What {
+			if _, ok := index[name]; !ok {
+				index[name] = len(timers)
+				timers = append(timers, TimerTotal{Name: name})
+			}
+			if _, running := started[name]; !running {
+				started[name] = evt.Timestamp
+			}
+		} else if name := strings.TrimPrefix(evt.What, labelStopPrefix); name != evt.What {
+			if t0, running := started[name]; running {
+				timers[index[name]].Total += evt.Timestamp.Sub(t0)
+				delete(started, name)
+			}
+		}
+	}
+	for name, t0 := range started {
+		timer := &timers[index[name]]
+		timer.Total += events[len(events)-1].Timestamp.Sub(t0)
+		timer.Running = true
+	}
+	return timers
+}
+
+// WriteTimerSummary writes the total of each named timer into out, with
+// the "# " prefix like WriteSummary
+func WriteTimerSummary(out io.Writer, timers []TimerTotal) error {
+	return writeTimers(out, "# Timer ", timers)
+}
+
+func writeTimers(out io.Writer, prefix string, timers []TimerTotal) error {
+	for _, timer := range timers {
+		note := ""
+		if timer.Running {
+			note = " (never stopped)"
+		}
+		if _, err := fmt.Fprintf(out, "%s%s: %s%s\n", prefix, timer.Name, formatDuration(timer.Total), note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testEvents builds a session whose consecutive events are separated by the
+// given offsets. The first event is "enter", the last "exit" and the rest
+// are ticks.
+func testEvents(offsets ...time.Duration) []Event {
+	t0 := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	events := []Event{{Seq: 0, Timestamp: t0, What: labelEnter}}
+	for i, off := range offsets {
+		t0 = t0.Add(off)
+		what := labelTick
+		if i == len(offsets)-1 {
+			what = labelExit
+		}
+		events = append(events, Event{Seq: int64(i + 1), Timestamp: t0, What: what})
+	}
+	return events
+}
+
+func TestComputeStats(t *testing.T) {
+	s := ComputeStats(testEvents(time.Second, 3*time.Second, 2*time.Second, 500*time.Millisecond))
+	if s.Total != 6500*time.Millisecond {
+		t.Errorf("Total: expected 6.5s, got %v", s.Total)
+	}
+	if s.Ticks != 3 {
+		t.Errorf("Ticks: expected 3, got %d", s.Ticks)
+	}
+	expectLaps := []time.Duration{time.Second, 3 * time.Second, 2 * time.Second}
+	if !reflect.DeepEqual(expectLaps, s.Laps) {
+		t.Errorf("Laps: expected %v, got %v", expectLaps, s.Laps)
+	}
+	if s.Min != time.Second || s.Mean != 2*time.Second || s.Max != 3*time.Second {
+		t.Errorf("Min/Mean/Max: expected 1s/2s/3s, got %v/%v/%v", s.Min, s.Mean, s.Max)
+	}
+}
+
+func TestComputeStatsMarks(t *testing.T) {
+	events := testEvents(time.Second, 2*time.Second, 3*time.Second, time.Second)
+	events[2].What = labelMarkPrefix + "phase2"
+	s := ComputeStats(events)
+	if s.Ticks != 2 || s.Marks != 1 {
+		t.Errorf("Ticks/Marks: expected 2/1, got %d/%d", s.Ticks, s.Marks)
+	}
+	// the mark does not split the lap it falls in
+	expectLaps := []time.Duration{time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(expectLaps, s.Laps) {
+		t.Errorf("Laps: expected %v, got %v", expectLaps, s.Laps)
+	}
+}
+
+func TestWriteSummaryNoLaps(t *testing.T) {
+	for _, events := range [][]Event{nil, testEvents(), testEvents(2 * time.Second)} {
+		var buf bytes.Buffer
+		if err := WriteSummary(&buf, ComputeStats(events), SparkOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte("no laps recorded")) {
+			t.Errorf("Expected 'no laps recorded', got: %q", buf.String())
+		}
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	var buf bytes.Buffer
+	spark := SparkOptions{Width: 80, ASCII: true}
+	if err := WriteSummary(&buf, ComputeStats(testEvents(time.Second, 3*time.Second, time.Second)), spark); err != nil {
+		t.Fatal(err)
+	}
+	expect := "# Total: 5s, ticks: 2\n# Laps: 2, min: 1s, avg: 2s, max: 3s\n# Trend: _#\n"
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected: %q, got: %q", expect, got)
+	}
+}
+
+func seconds(secs ...float64) []time.Duration {
+	var ds []time.Duration
+	for _, s := range secs {
+		ds = append(ds, time.Duration(s*float64(time.Second)))
+	}
+	return ds
+}
+
+func TestPercentiles(t *testing.T) {
+	tests := []struct {
+		laps   []time.Duration
+		ps     []float64
+		expect []time.Duration
+	}{
+		{seconds(10, 9, 8, 7, 6, 5, 4, 3, 2, 1), []float64{0, 50, 90, 99, 99.9, 100}, seconds(1, 5, 9, 10, 10, 10)},
+		{seconds(3, 1, 2), []float64{50, 66, 67, 95}, seconds(2, 2, 3, 3)},
+		{seconds(4), []float64{1, 50, 99.9}, seconds(4, 4, 4)},
+		{nil, []float64{50}, []time.Duration{0}},
+	}
+	for _, test := range tests {
+		if got := Percentiles(test.laps, test.ps); !reflect.DeepEqual(test.expect, got) {
+			t.Errorf("Percentiles(%v, %v): expected %v, got %v", test.laps, test.ps, test.expect, got)
+		}
+	}
+}
+
+func TestStdDev(t *testing.T) {
+	s := ComputeStats(testEvents(append(seconds(2, 4, 4, 4, 5, 5, 7, 9), time.Second)...))
+	if s.StdDev != 2*time.Second {
+		t.Fatalf("Expected stddev of 2s, got %v", s.StdDev)
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	ps, err := ParsePercentiles("50, 95,99.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []float64{50, 95, 99.9}; !reflect.DeepEqual(expect, ps) {
+		t.Fatalf("Expected: %v, got: %v", expect, ps)
+	}
+	for _, spec := range []string{"abc", "101", "-1", "50,x"} {
+		if _, err := ParsePercentiles(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestIdleDuration(t *testing.T) {
+	// enter, a, idle, mark, b, c, exit
+	events := testEvents(seconds(2, 10, 5, 20, 3, 1)...)
+	events[2].What, events[3].What = labelIdle, labelMarkPrefix+"x"
+	if laps := LapDurations(events); !reflect.DeepEqual(laps, seconds(2, 10, 3)) {
+		t.Errorf("Expected the idle gap to be left out of the laps, got %v", laps)
+	}
+	if idle, gaps := IdleDuration(events); idle != 25*time.Second || gaps != 1 {
+		t.Errorf("Expected 25s idle in 1 gap, got %v in %d", idle, gaps)
+	}
+
+	events = testEvents(seconds(2, 10, 1)...)
+	events[2].Flag = flagIdle
+	if idle, gaps := IdleDuration(events); idle != 10*time.Second || gaps != 1 {
+		t.Errorf("Expected the flagged lap as idle, got %v in %d", idle, gaps)
+	}
+	if idle, gaps := IdleDuration(testEvents(seconds(1, 1)...)); idle != 0 || gaps != 0 {
+		t.Errorf("Expected no idle time, got %v in %d", idle, gaps)
+	}
+}
+
+func TestLapSpans(t *testing.T) {
+	// enter, a, pause, b, resume, c, pause, resume, idle, mark, d, reset,
+	// e, exit: the first pause goes on over b
+	events := testEvents(seconds(2, 3, 4, 5, 6, 1, 2, 7, 1, 2, 3, 4, 1)...)
+	for i, what := range []string{labelPause, "b", labelResume, "c", labelPause, labelResume, labelIdle,
+		labelMarkPrefix + "x", "d", labelReset} {
+		events[i+2].What = what
+	}
+	var laps []time.Duration
+	var closers []int
+	for _, span := range lapSpans(events) {
+		if n := len(closers); n > 0 && closers[n-1] == span.closer {
+			laps[n-1] += span.end.Sub(span.start)
+			continue
+		}
+		laps = append(laps, span.end.Sub(span.start))
+		closers = append(closers, span.closer)
+	}
+	if want := LapDurations(events); !reflect.DeepEqual(laps, want) {
+		t.Errorf("Expected the spans to add up to the laps %v, got %v", want, laps)
+	}
+	if !reflect.DeepEqual(closers, []int{1, 3, 5, 10, 12}) {
+		t.Errorf("Unexpected events closing the spans: %v", closers)
+	}
+	if spans := lapSpans(events); len(spans) != 6 {
+		t.Errorf("Expected the lap d split around the pause, got %v", spans)
+	}
+}
+
+func TestExcludedSuspension(t *testing.T) {
+	// enter, a, suspended, resumed, b, pause, suspended, resumed, resume,
+	// c, exit: the second suspension is within a pause
+	events := testEvents(seconds(1, 1, 3, 1, 1, 1, 4, 1, 2, 1)...)
+	for i, what := range []string{labelSuspended, labelResumed, "b", labelPause, labelSuspended, labelResumed,
+		labelResume, "c"} {
+		events[i+2].What = what
+	}
+	if laps := LapDurations(events); !reflect.DeepEqual(laps, seconds(1, 5, 3)) {
+		t.Errorf("Expected the suspensions to be counted by default, got %v", laps)
+	}
+	for _, i := range []int{2, 3, 6, 7} {
+		events[i].Attrs = map[string]string{attrExcluded: "true"}
+	}
+	if laps := LapDurations(events); !reflect.DeepEqual(laps, seconds(1, 2, 3)) {
+		t.Errorf("Expected the suspensions to be left out, got %v", laps)
+	}
+	if paused := PausedDuration(events); paused != 9*time.Second {
+		t.Errorf("Expected the suspensions and the pause once, 9s, got %v", paused)
+	}
+	var spans time.Duration
+	for _, span := range lapSpans(events) {
+		spans += span.end.Sub(span.start)
+	}
+	if spans != 6*time.Second {
+		t.Errorf("Expected the spans to leave out the suspensions, got %v", spans)
+	}
+}
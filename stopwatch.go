@@ -16,160 +16,936 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"os/signal"
 	"reflect"
-	"syscall"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
+// Labels of the events recorded automatically by the collector
+const (
+	labelEnter = "enter" // first event of every session
+	labelTick  = "tick"  // event recorded by user input
+	labelExit  = "exit"  // last event of every session
+
+	labelPause  = "pause"  // recorded by the "pause" command
+	labelResume = "resume" // recorded by the "resume" command
+
+	labelMarkPrefix   = "mark:"            // prefix of milestones recorded with the "mark" command
+	labelWarnPrefix   = "warn:"            // prefix of the events recorded when a -warn-at threshold is crossed
+	labelBudgetPrefix = "budget-exceeded:" // prefix of the events recorded when the -budget of a label runs out
+	labelReset        = "reset"            // recorded by the "reset" command, optionally followed by ":<name>"
+
+	labelStartPrefix = "start:" // prefix of the events starting a named timer
+	labelStopPrefix  = "stop:"  // prefix of the events stopping a named timer
+
+	labelPhasePrefix = "phase:" // prefix of the -cycle phase transition events
+
+	labelIdle = "idle" // recorded by -idle-after where an idle gap starts
+
+	labelHeartbeat = "heartbeat" // recorded every -heartbeat interval, to tell gaps in the recording from idle time
+
+	labelSuspended = "suspended" // recorded when the process is stopped, e.g. by ctrl-z; see watchSuspend
+	labelResumed   = "resumed"   // recorded when it is continued
+)
+
+// attrExcluded marks the "suspended" and "resumed" events of
+// -exclude-suspended: the time between them is not counted, like a pause
+const attrExcluded = "excluded"
+
+// isSentinel reports whether label is one of the session boundary labels
+func isSentinel(label string) bool {
+	return label == labelEnter || label == labelExit
+}
+
+// isMark reports whether label is a milestone. Milestones are part of the
+// timeline but do not start or close laps.
+func isMark(label string) bool {
+	return strings.HasPrefix(label, labelMarkPrefix)
+}
+
+// isWarning reports whether label was recorded for a -warn-at threshold.
+// Like marks, warnings do not start or close laps.
+func isWarning(label string) bool {
+	return strings.HasPrefix(label, labelWarnPrefix)
+}
+
+// isReset reports whether label starts a new lap group. Like "enter", a
+// reset starts a lap but does not close one.
+func isReset(label string) bool {
+	return label == labelReset || strings.HasPrefix(label, labelReset+":")
+}
+
+// isTimerEvent reports whether label starts or stops a named timer. Like
+// marks, these do not start or close laps.
+func isTimerEvent(label string) bool {
+	return strings.HasPrefix(label, labelStartPrefix) || strings.HasPrefix(label, labelStopPrefix)
+}
+
+// isAnnotation reports whether label is part of the timeline without
+// starting or closing laps
+func isAnnotation(label string) bool {
+	return isMark(label) || isWarning(label) || isTimerEvent(label) || strings.HasPrefix(label, labelPhasePrefix) ||
+		strings.HasPrefix(label, labelBudgetPrefix) ||
+		label == labelFalseStart || label == labelSuspended || label == labelResumed || label == labelHeartbeat
+}
+
+// excludedSuspension reports whether evt starts or ends a suspension of
+// -exclude-suspended. Other suspensions are annotations, and counted.
+func excludedSuspension(evt Event) (suspended, resumed bool) {
+	if evt.Attrs[attrExcluded] != "true" {
+		return false, false
+	}
+	return evt.What == labelSuspended, evt.What == labelResumed
+}
+
+// isPauseControl reports whether label is "pause" or "resume". Like marks,
+// these do not start or close laps; the time between them is not counted.
+func isPauseControl(label string) bool {
+	return label == labelPause || label == labelResume
+}
+
+// isIdle reports whether label starts an idle gap. The time from it until
+// the next event is not counted in the lap, and like marks, it does not
+// start or close laps.
+func isIdle(label string) bool {
+	return label == labelIdle
+}
+
+// isReserved reports whether label is recorded only by the collector
+// itself, and can not be typed as a label
+func isReserved(label string) bool {
+	return isSentinel(label) || isAnnotation(label) || isPauseControl(label) || isReset(label) || isGo(label) ||
+		isIdle(label)
+}
+
 // Event represents an event to be recorded
 type Event struct {
-	Seq       int       `csv:"seq"`  // sequence number of the event
-	Timestamp time.Time `csv:"ts"`   // when the event happened
-	What      string    `csv:"what"` // description of the event
+	Seq       int64          `csv:"seq"`                // sequence number of the event
+	Timestamp time.Time      `csv:"ts"`                 // when the event happened
+	Offset    *time.Duration `csv:"offset,optional"`    // time since the -since instant, negative before it
+	What      string         `csv:"what"`               // description of the event
+	Value     *float64       `csv:"value,optional"`     // measurement recorded with the event, if any
+	Flag      string         `csv:"flag,optional"`      // problem noticed while recording, e.g. "short"
+	VsTarget  *float64       `csv:"vs_target,optional"` // lap duration minus the target lap time, in seconds
+	Group     int            `csv:"group,optional"`     // lap group, incremented by the "reset" command
+	Phase     string         `csv:"phase,optional"`     // -cycle phase active when the event was recorded
+	Zone      string         `csv:"tz,optional"`        // local time zone name (or offset) when the event happened
+	ID        string         `csv:"id,optional"`        // unique identifier (ULID) of the event, if generated
+	Source    string         `csv:"source,optional"`    // input the event came from, e.g. "stdin" or "tcp"
+
+	Attrs map[string]string // key=value attributes typed with the event, see AttrColumns
 }
 
 // Row converts an Event into a slice of strings. Used for writing Event as CSV record.
+// Only the default columns are included; see Cells.
 func (e Event) Row() []string {
-	return []string{fmt.Sprintf("%d", e.Seq), e.Timestamp.Format(time.RFC3339Nano), e.What}
+	return e.Cells(defaultColumns)
+}
+
+// Cells converts the named columns of an Event into a slice of strings
+func (e Event) Cells(names []string) []string {
+	var buf [64]byte
+	var ends [16]int
+	row := make([]string, len(names))
+	OutputOptions{}.fillRows([][]string{row}, []Event{e}, names, buf[:0], ends[:0])
+	return row
+}
+
+// Cell returns the string representation of the named column of an Event.
+// Names other than the built-in columns are looked up in the attributes.
+func (e Event) Cell(name string) string {
+	switch name {
+	case "seq":
+		return strconv.FormatInt(e.Seq, 10)
+	case "ts":
+		return e.Timestamp.Format(time.RFC3339Nano)
+	case "what":
+		return e.What
+	case "ts_ns":
+		return strconv.FormatInt(e.Timestamp.UnixNano(), 10)
+	case "offset":
+		if e.Offset == nil {
+			return ""
+		}
+		return formatOffset(*e.Offset)
+	case "offset_s":
+		if e.Offset == nil {
+			return ""
+		}
+		return formatOffset(*e.Offset)
+	case "value":
+		if e.Value == nil {
+			return ""
+		}
+		return formatValue(*e.Value)
+	case "flag":
+		return e.Flag
+	case "vs_target", "vs_target_s":
+		if e.VsTarget == nil {
+			return ""
+		}
+		return formatSeconds(*e.VsTarget)
+	case "group":
+		return strconv.Itoa(e.Group)
+	case "phase":
+		return e.Phase
+	case "tz":
+		return e.Zone
+	case "id":
+		return e.ID
+	case "source":
+		return e.Source
+	case attrsColumn:
+		return marshalAttrs(e.Attrs)
+	}
+	return e.Attrs[name]
+}
+
+// SetCell parses value into the named column of an Event; the inverse of Cell.
+func (e *Event) SetCell(name, value string) (err error) {
+	switch name {
+	case "seq":
+		e.Seq, err = strconv.ParseInt(value, 10, 64)
+	case "ts":
+		e.Timestamp, err = parseTimestamp(value)
+	case "what":
+		e.What = value
+	case "ts_ns":
+		// Keeps the location of an already parsed "ts", so that the
+		// integer takes precedence without losing the UTC offset.
+		var ns int64
+		if ns, err = strconv.ParseInt(value, 10, 64); err == nil {
+			loc := time.UTC
+			if !e.Timestamp.IsZero() {
+				loc = e.Timestamp.Location()
+			}
+			e.Timestamp = time.Unix(0, ns).In(loc)
+		}
+	case "offset", "offset_s":
+		// the seconds of durationStyleBoth are parsed after the Go style
+		// duration, and an empty cell does not clear it
+		if name == "offset" {
+			e.Offset = nil
+		}
+		if value != "" {
+			var d time.Duration
+			if d, err = parseDurationCell(value); err == nil {
+				e.Offset = &d
+			}
+		}
+	case "value":
+		e.Value = nil
+		if value != "" {
+			var v float64
+			if v, err = strconv.ParseFloat(value, 64); err == nil {
+				e.Value = &v
+			}
+		}
+	case "flag":
+		e.Flag = value
+	case "group":
+		e.Group, err = strconv.Atoi(value)
+	case "phase":
+		e.Phase = value
+	case "vs_target", "vs_target_s":
+		if name == "vs_target" {
+			e.VsTarget = nil
+		}
+		if value != "" {
+			var v float64
+			if v, err = parseSecondsCell(value); err == nil {
+				e.VsTarget = &v
+			}
+		}
+	case "tz":
+		e.Zone = value
+	case "id":
+		e.ID = value
+	case "source":
+		e.Source = value
+	default:
+		return fmt.Errorf("unknown column %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+// parseTimestamp parses a "ts" cell: an RFC 3339 timestamp, or the number
+// of seconds since the Unix epoch, with an optional fraction
+func parseTimestamp(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return t, nil
+	}
+	secs, frac, _ := strings.Cut(value, ".")
+	if secs == "" || len(frac) > 9 || strings.Trim(secs+frac, "0123456789") != "" {
+		return t, err
+	}
+	sec, serr := strconv.ParseInt(secs, 10, 64)
+	nsec, _ := strconv.ParseInt((frac + "000000000")[:9], 10, 64)
+	if serr != nil {
+		return t, err
+	}
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// eventColumn describes a csv tagged field of Event
+type eventColumn struct {
+	name     string
+	optional bool         // only included in the output when explicitly enabled
+	kind     reflect.Kind // type of the field
+	nullable bool         // may be empty, written as null in JSON
+}
+
+// derivedColumns are optional columns computed from other fields of Event.
+// Each is placed right after the column it is derived from.
+var derivedColumns = map[string][]eventColumn{
+	"ts": {{name: "ts_ns", optional: true, kind: reflect.Int64}}, // Timestamp.UnixNano()
+
+	// the seconds of the Go style durations, see durationStyleBoth
+	"offset":    {{name: "offset" + secondsSuffix, optional: true, kind: reflect.Float64, nullable: true}},
+	"vs_target": {{name: "vs_target" + secondsSuffix, optional: true, kind: reflect.Float64, nullable: true}},
+}
+
+// eventColumns lists the csv tagged fields of Event in field order, along
+// with the derived columns
+func eventColumns() []eventColumn {
+	var cols []eventColumn
+	for _, f := range structFields(reflect.TypeOf(Event{})) {
+		cols = append(cols, eventColumn{name: f.name, optional: f.optional, kind: f.typ.Kind(), nullable: f.pointer})
+		cols = append(cols, derivedColumns[f.name]...)
+	}
+	return cols
 }
 
 // GetEventColumnNames produces a slice of column names from Event. Used for
-// writing CSV header.
+// writing CSV header. Only the default columns are included; see
+// EventColumnNames.
 func GetEventColumnNames() []string {
+	return ColumnNames[Event]()
+}
+
+// EventColumnNames produces the column names of the default columns and the
+// optional columns listed in optional, in the Event field order.
+func EventColumnNames(optional []string) []string {
+	enabled := make(map[string]bool)
+	for _, name := range optional {
+		enabled[name] = true
+	}
 	var hdr []string
-	etype := reflect.TypeOf(Event{})
-	for i := 0; i < etype.NumField(); i++ {
-		field := etype.Field(i)
-		if fval, ok := field.Tag.Lookup("csv"); ok {
-			hdr = append(hdr, fval)
+	for _, col := range eventColumns() {
+		if !col.optional || enabled[col.name] {
+			hdr = append(hdr, col.name)
 		}
 	}
 	return hdr
 }
 
+// formatValue formats an event value in the shortest form that parses back
+// to the same number
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// presentColumns are the optional columns written whenever the events
+// have data in them, see dataColumns
+var presentColumns = []struct {
+	name    string
+	present func(Event) bool
+}{
+	{"offset", func(e Event) bool { return e.Offset != nil }},
+	{"value", func(e Event) bool { return e.Value != nil }},
+	{"flag", func(e Event) bool { return e.Flag != "" }},
+	{"group", func(e Event) bool { return e.Group != 0 }},
+	{"phase", func(e Event) bool { return e.Phase != "" }},
+}
+
+// dataColumns returns the optional columns needed to hold data present in
+// events, in addition to columns. Offsets, values, flags, groups and phases
+// are never dropped just because their column was not requested.
+func dataColumns(events []Event, columns []string) []string {
+	enabled := make(map[string]bool)
+	for _, name := range columns {
+		enabled[name] = true
+	}
+	for _, col := range presentColumns {
+		if enabled[col.name] {
+			continue
+		}
+		for _, evt := range events {
+			if col.present(evt) {
+				columns = append(columns[:len(columns):len(columns)], col.name)
+				break
+			}
+		}
+	}
+	return columns
+}
+
 // EventsToRecords converts a sequence of events to string representation
 func EventsToRecords(events []Event) [][]string {
-	var rows [][]string
-	rows = append(rows, GetEventColumnNames())
-	for _, evt := range events {
-		rows = append(rows, evt.Row())
-	}
+	return EventsToRecordsWith(events, GetEventColumnNames())
+}
+
+// EventsToRecordsWith converts a sequence of events to string representation
+// containing the named columns
+func EventsToRecordsWith(events []Event, names []string) [][]string {
+	rows := make([][]string, 0, len(events)+1)
+	rows = append(rows, names)
+	rows = append(rows, newRows(len(events), len(names))...)
+	f := rowFiller{names: names}
+	f.fill(rows[1:], events)
 	return rows
 }
 
-// DumpCSV writes a sequence of records into output file in CSV mode.
-// Filenames "" and "-" are interpreted as stdout. Comment parameter (if non-empty)
-// will be written as "# <comment>" on the first line of the file.
-func DumpCSV(outFile string, events []Event, comment string) error {
-	if outFile == "-" || outFile == "" {
-		return MarshallEventsCSV(os.Stdout, events, comment)
+// OutputOptions controls how events are written into the output file
+type OutputOptions struct {
+	Format      string      // name of the output format (see formats); "" means CSV
+	Name        string      // name of the session, used by formats that support it
+	Comment     string      // if non-empty, written as "# <comment>" on the first line
+	Meta        []metaField // written as the metadata lines of metaPrefix, and the "meta" of JSON
+	StatsFooter bool        // append summary statistics as comment lines after the records
+	LaTeXFloat  bool        // wrap LaTeX tables in a table float with the comment as caption
+
+	Messages io.Writer // where formats report what they left out; nil discards
+
+	Template     *template.Template // executed by the "template" format, see ParseTemplateFile
+	LineTemplate *template.Template // with -stream, makes the line of each event instead of the format
+
+	Computed []ComputedColumn  // columns of -column, written after the attributes
+	Rename   map[string]string // header text of the renamed columns, see parseRenames
+	Filters  []eventFilter     // conditions of -filter, all met by the events written
+
+	Columns []string // optional columns to include, see EventColumnNames
+	Attrs   []string // attribute columns appended after the event columns, see AttrColumns
+
+	// How attributes are written by the table formats: "columns" (or "")
+	// writes one column per attribute, "json" a single "attrs" column
+	// containing a JSON object
+	AttrsStyle string
+
+	// If non-zero, add an "offset" column with the time of each event
+	// relative to this instant, see withOffsets. RelativeOnly leaves out
+	// the "ts" column.
+	Since        time.Time
+	RelativeOnly bool
+
+	// How the duration columns are written: "seconds" (or ""), "go" or
+	// "both", see durationStyles
+	DurationStyle string
+
+	// How the "ts" column is written: "rfc3339" (or "") or
+	// "offset-seconds", the seconds since Start, which is then written
+	// into a "# ts-start: " line; see prepareOutput
+	TSStyle string
+	Start   time.Time
+
+	// The order of the events: "asc" (or "") or "desc", the newest first,
+	// see prepareOutput; the seq of each event is kept
+	Order string
+
+	// Write only the first Head or the last Tail events, see limitEvents;
+	// 0 writes all
+	Head, Tail int
+
+	Redact *redactor // replaces the labels and attribute values, see -redact; nil writes them as they are
+
+	Checksum bool   // append a "# sha256: <hex>" line covering everything before it
+	SignKey  []byte // if non-nil, append a "# hmac-sha256: <hex>" line signed with this key
+
+	Passphrase []byte // if non-nil, encrypt the output with a key derived from this
+
+	// Compress the output in this format, see compressions; "" for none.
+	// The level is that of the format, 0 meaning its default.
+	Compress      string
+	CompressLevel int
+
+	Backup bool // rename an existing output file to a backup instead of overwriting it
+	MkDirs bool // create the missing directories of the output file
+
+	Manifest bool   // write a Manifest next to each output file once it is complete, see dumpFile
+	Session  string // ID of the recording session, for the Manifest
+
+	Upload uploadOptions // how the output is sent to an http:// or https:// URL, see uploadEvents
+
+	// Split the output into files of at most this many events or bytes,
+	// see rotateParts; zero values disable either limit
+	RotateEvents int
+	RotateSize   int64
+
+	SplitByLabel bool // write the events of each label into a file of its own, see splitByLabel
+	SplitDaily   bool // with -stream, start a file of its own for each day, see streamOutput.rollover
+
+	// CSV dialect; the zero values produce standard CSV
+	Delimiter       rune // field delimiter; 0 means ','
+	CRLF            bool // terminate lines with \r\n instead of \n
+	BOM             bool // start the output with a UTF-8 byte order mark
+	CommentAsRecord bool // write the comment as a first record padded to the column count, unquoted
+}
+
+// Validate checks that the options are supported by the chosen format
+func (opts OutputOptions) Validate() error {
+	if _, err := lookupEncoder(opts.Format); err != nil {
+		return err
 	}
-	f, err := os.Create(outFile)
-	if err != nil {
-		return fmt.Errorf("could not create file: %w", err)
+	if (opts.Checksum || opts.SignKey != nil) && opts.Format != "" && opts.Format != "csv" {
+		return fmt.Errorf("checksum and signature are only supported with the csv format")
 	}
-	defer f.Close()
-	return MarshallEventsCSV(f, events, comment)
+	if opts.RelativeOnly && opts.Since.IsZero() {
+		return fmt.Errorf("-relative-only requires -since")
+	}
+	if opts.Format == "template" && opts.Template == nil {
+		return fmt.Errorf("the template format requires -template-file")
+	} else if opts.Format != "template" && opts.Template != nil {
+		return fmt.Errorf("-template-file requires the template format")
+	}
+	switch opts.DurationStyle {
+	case "", durationStyleSeconds, durationStyleGo, durationStyleBoth:
+	default:
+		return fmt.Errorf("unknown duration style %q (available: %s)", opts.DurationStyle, strings.Join(durationStyles, ", "))
+	}
+	switch opts.TSStyle {
+	case "", tsStyleRFC3339:
+	case tsStyleOffsetSeconds:
+		if opts.Format != "" && opts.Format != "csv" {
+			return fmt.Errorf("-ts-style %s is only supported with the csv format", tsStyleOffsetSeconds)
+		}
+	default:
+		return fmt.Errorf("unknown ts style %q (available: %s)", opts.TSStyle, strings.Join(tsStyles, ", "))
+	}
+	if err := validateOrder(opts.Order, opts.Format); err != nil {
+		return err
+	}
+	if err := validateHeadTail(opts.Head, opts.Tail); err != nil {
+		return err
+	}
+	switch opts.AttrsStyle {
+	case "", attrsStyleColumns, attrsStyleJSON:
+	default:
+		return fmt.Errorf("unknown attribute style %q (available: %s, %s)", opts.AttrsStyle, attrsStyleColumns, attrsStyleJSON)
+	}
+	if _, ok := compressions[opts.Compress]; !ok && opts.Compress != "" && opts.Compress != compressNone {
+		return fmt.Errorf("unknown compression %q (available: %s, %s)", opts.Compress,
+			strings.Join(compressionNames(), ", "), compressNone)
+	}
+	return nil
+}
+
+// eol returns the line terminator to use
+func (opts OutputOptions) eol() string {
+	if opts.CRLF {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// Header returns the names of the columns to write
+func (opts OutputOptions) Header() []string {
+	if opts.AttrsStyle == attrsStyleJSON {
+		if len(opts.Attrs) == 0 {
+			return opts.eventColumnNames()
+		}
+		return append(opts.eventColumnNames(), attrsColumn)
+	}
+	return append(opts.eventColumnNames(), opts.Attrs...)
+}
+
+// eventColumnNames returns the names of the event columns to write, see
+// EventColumnNames; "ts" is left out with RelativeOnly, and with
+// durationStyleBoth, the duration columns are followed by their seconds
+func (opts OutputOptions) eventColumnNames() []string {
+	columns := opts.Columns
+	if opts.DurationStyle == durationStyleBoth {
+		for _, name := range opts.Columns {
+			if isDurationColumn(name) {
+				columns = append(columns[:len(columns):len(columns)], name+secondsSuffix)
+			}
+		}
+	}
+	names := EventColumnNames(columns)
+	if !opts.RelativeOnly {
+		return names
+	}
+	var kept []string
+	for _, name := range names {
+		if name != "ts" {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// ExcelPreset adjusts opts for Microsoft Excel: UTF-8 BOM, CRLF line endings,
+// the comment written as a padded record, and ';' as the delimiter unless
+// one was already chosen.
+func ExcelPreset(opts OutputOptions) OutputOptions {
+	opts.BOM = true
+	opts.CRLF = true
+	opts.CommentAsRecord = true
+	if opts.Delimiter == 0 {
+		opts.Delimiter = ';'
+	}
+	return opts
 }
 
+// utf8BOM is the UTF-8 encoded byte order mark
+const utf8BOM = "\xEF\xBB\xBF"
+
+// ParseDelimiter validates a CSV field delimiter given on the command line
+func ParseDelimiter(s string) (rune, error) {
+	runes := []rune(s)
+	if s == `\t` {
+		runes = []rune{'\t'}
+	}
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter %q: must be a single character other than quote or newline", s)
+	}
+	return runes[0], nil
+}
+
+// DumpCSV writes a sequence of records into output file in CSV mode,
+// regardless of opts.Format. See DumpEvents.
+func DumpCSV(outFile string, events []Event, opts OutputOptions) error {
+	opts.Format = "csv"
+	return DumpEvents(outFile, events, opts)
+}
+
+// MarshallEventsCSV writes events into out in CSV format. Comment parameter
+// (if non-empty) will be written as "# <comment>" on the first line.
 func MarshallEventsCSV(out io.Writer, events []Event, comment string) error {
+	return EncodeCSV(out, events, OutputOptions{Comment: comment})
+}
 
-	// convert records to text form
-	records := EventsToRecords(events)
+// EncodeCSV writes events into out in CSV format, as specified by opts.
+func EncodeCSV(out io.Writer, events []Event, opts OutputOptions) error {
+	encode := func(w io.Writer) error {
+		return encodeCSV(w, events, opts)
+	}
+	if opts.Checksum {
+		records := encode
+		encode = func(w io.Writer) error {
+			return writeWithChecksum(w, opts.eol(), records)
+		}
+	}
+	if opts.SignKey != nil {
+		unsigned := encode
+		encode = func(w io.Writer) error {
+			return writeWithSignature(w, opts.SignKey, opts.eol(), unsigned)
+		}
+	}
+	return encode(out)
+}
+
+// csvFlushRows is how many rows encodeCSV writes between flushes
+const csvFlushRows = 1024
+
+// encodeCSV writes the events one row at a time, so that long sessions are
+// not converted to text all at once, see EventsToRecordsWith
+func encodeCSV(out io.Writer, events []Event, opts OutputOptions) error {
+	header := opts.Header()
+
+	eol := opts.eol()
+	if opts.BOM {
+		if _, err := io.WriteString(out, utf8BOM); err != nil {
+			return err
+		}
+	}
 
 	w := csv.NewWriter(out)
-	if comment != "" {
-		_, err := fmt.Fprintf(out, "# %s\n", comment)
-		if err != nil {
+	w.UseCRLF = opts.CRLF
+	if opts.Delimiter != 0 {
+		w.Comma = opts.Delimiter
+	}
+	// the preamble, see csvPreamble
+	var preamble []string
+	if opts.Comment != "" {
+		preamble = append(preamble, "# "+opts.Comment)
+	}
+	preamble = append(preamble, formatSchemaLine())
+	if opts.startRelative() {
+		preamble = append(preamble, formatTSStart(opts.Start))
+	}
+	// after the ts-start, which older versions would skip with them
+	for _, f := range opts.Meta {
+		preamble = append(preamble, formatMetaLine(f))
+	}
+	// the lines padded as records are not quoted: the readers take any line
+	// starting with '#' as the preamble, and strip the padding
+	padding := ""
+	if opts.CommentAsRecord {
+		padding = strings.Repeat(string(w.Comma), len(header)-1)
+	}
+	for _, line := range preamble {
+		if _, err := fmt.Fprintf(out, "%s%s%s", line, padding, eol); err != nil {
 			return err
 		}
 	}
-	return w.WriteAll(records)
+	if err := w.Write(opts.headerText(header)); err != nil {
+		return err
+	}
+	// csv.Writer copies the fields, so the rows can be reused: they are
+	// filled and written csvFlushRows at a time, see rowFiller
+	n := len(events)
+	if n > csvFlushRows {
+		n = csvFlushRows
+	}
+	rows := newRows(n, len(header))
+	f := rowFiller{opts: opts, names: header}
+	for rest := events; len(rest) > 0; rest = rest[len(rows):] {
+		if len(rest) < len(rows) {
+			rows = rows[:len(rest)]
+		}
+		f.fill(rows, rest[:len(rows)])
+		for _, row := range rows {
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		if w.Flush(); w.Error() != nil {
+			return w.Error()
+		}
+	}
+	if w.Flush(); w.Error() != nil {
+		return w.Error()
+	}
+	if opts.StatsFooter {
+		for _, line := range statsFooterLines(ComputeStats(inRecordedOrder(events)), opts) {
+			if _, err := fmt.Fprintf(out, "# %s%s", line, eol); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectOptions controls how events are recorded by collect
+type collectOptions struct {
+	WithID bool // assign a ULID to every event
+
+	StartPaused  bool // pause right after the "enter" event
+	ResumeOnTick bool // a tick while paused resumes instead of being refused
+
+	AllowOutOfOrder bool // back-dated and shifted ticks may go past other events, see cmdTick and cmdShift
+	Arm             bool // record "enter" at the first tick instead of at startup
+
+	Debounce    time.Duration // ignore ticks this soon after the previous event; 0 disables
+	Sampler     sampler       // thins out the ticks of the remote and watched inputs; nil keeps all
+	SampleStdin bool          // also the ticks read from stdin, when it is not a terminal
+	MinLap      time.Duration // flag laps shorter than this as "short"; 0 disables
+	Target      time.Duration // target lap time to compare each lap against; 0 disables
+	Ghost       *ghost        // reference recording to compare each lap against, see -ghost; nil disables
+
+	WarnAt    []time.Duration // elapsed times at which to warn, in increasing order
+	Budgets   budgetList      // of -budget, the time each label may take; see checkBudgets
+	Cycle     []cyclePhase    // phases repeated from the start of the session, see ParseCycle
+	Until     time.Time       // stop the session at this wall clock time; zero disables
+	IdleAfter time.Duration   // record an "idle" event when a tick comes this long after its lap started; 0 disables
+	IdleFlag  bool            // flag the tick as "idle" instead
+	Heartbeat time.Duration   // record a "heartbeat" event at every multiple of this since "enter"; 0 disables
+	MaxEvents int             // stop the session after this many events, not counting "enter"; 0 disables
+	SeqStart  int64           // sequence number of the "enter" event, see -seq-start
+	Color     bool            // highlight warnings with ANSI colors
+	Bell      bool            // ring the terminal bell at phase changes and at GO
+
+	Reaction *reactionOptions // measure reaction times, see -reaction; nil disables
+
+	Notifier notifier // shows the warnings and phase changes on the desktop; nil disables
+	Name     string   // name of the session, the title of the notifications
+
+	Labels       []string // labels given to plain ticks in turn, see ParseLabels
+	LabelsNoWrap bool     // keep using the last label instead of starting over
+	LabelSteps   bool     // each label is used once, then ticks are plain again
+	LabelsStrict bool     // with LabelSteps, refuse ticks after the last label
+
+	// The labels of the plain ticks: TickLabel, or "tick" if empty, for
+	// the ticks of the sources without one in SourceLabels, see plainLabel.
+	// SourcePrefixes are prepended to every tick of their source.
+	TickLabel      string
+	SourceLabels   map[string]string
+	SourcePrefixes map[string]string
+
+	Abbrevs map[string]string // labels recorded for the keys typed alone, see ParseAbbrevs
+
+	SanitizeLabels bool // pass the labels of remote and watched inputs through sanitizeLabel
+	SanitizeStdin  bool // also the labels read from stdin
+	Normalize      bool // compose the labels into Unicode NFC, see normalizeNFC
+
+	Sinks    []eventSink // notified of every recorded event
+	KeepLast int         // keep only this many of the last events, see appendEvent; 0 keeps all
+
+	Control  bool               // the lines are -control json commands, see handleControl
+	NoPrompt bool               // show no prompts, as the output goes to stderr too or with -q
+	Prompt   *template.Template // the template of the prompt, see ParsePrompt; nil for defaultPrompt
+	Typed    func() string      // the line being typed, shown again after the prompt; see lineEditor
+
+	Save func(path string, events []Event, comment string) (string, error) // writes the snapshots of "save"; nil disables
+
+	UntilBar      bool // show the progress towards Until with the prompt
+	UntilBarWidth int  // width of the terminal the bar is drawn on; 0 writes lines instead
+}
+
+// inputLine is a line read from stdin, with the time it was read
+type inputLine struct {
+	text string
+	at   time.Time
 }
 
-func collect(ctx context.Context, tickChan <-chan struct{}) (events []Event) {
-	var ctr int
+// stdinMaxLine is the longest line read from stdin; a longer one ends the
+// session like EOF
+const stdinMaxLine = 1 << 20
 
-	// Print all info messages to stderr, as data might be printed to stdout
-	fmt.Fprintln(os.Stderr, "# Record: <enter>, Exit: <ctrl+d> or <ctrl+c>")
+// readLines passes the lines read from r on to lines, trimmed, until EOF or
+// until ctx is cancelled, and reports whether EOF was reached. A line read
+// as the collector stops is dropped instead of blocking forever. A last line
+// without a newline is passed on too.
+func readLines(ctx context.Context, r io.Reader, lines chan<- inputLine) bool {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, stdinMaxLine)
+	for scanner.Scan() {
+		// line received, notify collector
+		select {
+		case lines <- inputLine{text: strings.TrimSpace(scanner.Text()), at: time.Now()}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		fmt.Fprintf(os.Stderr, "\n# WARNING: line longer than %d bytes on stdin, stopping\n", stdinMaxLine)
+	}
+	return true
+}
 
-	tick := func(what string) {
-		events = append(events, Event{Seq: ctr, Timestamp: time.Now(), What: what})
-		ctr++
+// collect records events into sess until ctx is cancelled. Each line
+// received from lines is either an interactive command or a tick, or with
+// -control json, a JSON command.
+// Lines received from remote are recorded as ticks by the listeners
+// themselves, through a Stopwatch shared with them; see handleRemote.
+// The prompts and messages are written into msgs.
+func collect(ctx context.Context, lines <-chan inputLine, remote *remoteInput, sess *Session, msgs io.Writer) {
+	// The CLI prints all info messages to stderr, as data might be printed to stdout
+	if !sess.opts.NoPrompt {
+		fmt.Fprintln(msgs, "# Record: <enter>, Exit: <ctrl+d> or <ctrl+c>")
 	}
 
-	tick("enter")
+	sw := NewStopwatch(sess, msgs)
+	sw.do(func(sess *Session) {
+		sess.start()
+		sess.checkTimers(msgs)
+	})
+	remote.attach(sw.remote)
+	showPrompt := true
 loop:
 	for {
-		fmt.Fprintf(os.Stderr, "# Waiting for [%v]> ", ctr)
+		var done bool
+		var timerC <-chan time.Time
+		var timer *time.Timer
+		sw.do(func(sess *Session) {
+			if done = sess.expired() || sess.trialsDone() || sess.full(); done {
+				return
+			}
+			sess.drawPrompt(msgs, showPrompt)
+			if wait, ok := sess.nextTimer(); ok {
+				timer = time.NewTimer(wait)
+				timerC = timer.C
+			}
+		})
+		if done {
+			break
+		}
 		select {
 		case <-ctx.Done():
 			break loop // plain 'break' would break from select, not the loop.
-		case <-tickChan:
-			tick("tick")
+		case line := <-lines:
+			stop := false
+			sw.do(func(sess *Session) {
+				sess.checkTimers(msgs) // keep the events in order
+				sess.handleInput(sourceStdin, line.at, func() {
+					if !sess.opts.Control {
+						sess.handleLine(line.text, msgs)
+					} else {
+						stop = sess.handleControl(line.text, os.Stdout, msgs)
+					}
+				})
+			})
+			if stop {
+				break loop
+			}
+			showPrompt = true
+		case <-sw.changed:
+			showPrompt = true
+		case <-timerC:
+			showPrompt = false
 		}
+		if timer != nil {
+			timer.Stop()
+		}
+		sw.do(func(sess *Session) {
+			if sess.checkTimers(msgs) {
+				showPrompt = true
+			}
+		})
 	}
-	tick("exit")
+	sw.do(func(sess *Session) {
+		if sess.expired() {
+			fmt.Fprintln(msgs, "\n# Reached the -until time, stopping")
+			sess.notify("Reached the -until time, stopping", sess.now(), msgs)
+		}
+		if sess.trialsDone() {
+			fmt.Fprintf(msgs, "# All %d trials done, stopping\n", sess.trials)
+		}
+		if sess.full() {
+			fmt.Fprintf(msgs, "# Reached %d events, stopping\n", sess.opts.MaxEvents)
+		}
+	})
+	// lines received before the end are still recorded, so the lock is
+	// not held while waiting for the listeners
+	remote.stop()
+	sw.do(func(sess *Session) { sess.finish() })
 
 	// Make sure next print will be on a fresh line
-	fmt.Fprintln(os.Stderr, "")
-	return
+	if !sess.opts.NoPrompt {
+		fmt.Fprintln(msgs, "")
+	}
 }
 
 func main() {
-	outFile := flag.String("o", "", "Output file path (Optional, default: stdout)\n"+
-		"Values \"\" and \"-\" are interpreted as stdout")
-	outComment := flag.String("c", "", "Comment for the output file. Optional")
-	flag.Parse()
-
-	// capture signals and handle cancellation via Context
-	ctx, cancel := signal.NotifyContext(context.Background(),
-		syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
-
-	defer func() {
-		cancel()
-	}()
-
-	tickChan := make(chan struct{})
-
-	go func() {
-		for {
-			var s string
-			_, err := fmt.Scanln(&s)
-			/*
-			   pressing only enter will return err == "unexpected newline",
-			   but pressing ctrl-d will cause err == io.EOF
-			*/
-			if err == io.EOF {
-				// tell main loop we are done.
-				cancel()
-				return
-			}
-
-			// (new)line received, notify collector
-			tickChan <- struct{}{}
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok && cmd.run != nil {
+			os.Exit(cmd.run(os.Args[2:]))
 		}
-	}()
+	}
 
-	events := collect(ctx, tickChan)
+	// before anything else gets to open file descriptor 3
+	progressFile := openProgressFD()
 
-	// In case we exited loop due to a signal, the stdin goroutine
-	// is still running. Here we close stdin manually to signal the
-	// goroutine to exit. The goroutine will receive EOF, and call
-	// cancel() on the context (again?)
-	os.Stdin.Close()
+	flag.Usage = usage
+	ui := detectUI(os.Stdin, os.Stderr, isTerminal)
+	flags := defineFlags(flag.CommandLine, ui)
+	// once the flags are defined, so that the completion follows them
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		os.Exit(runCompletion(os.Args[2:], flag.CommandLine))
+	}
 
-	// Write events into file; either stdout or
-	if err := DumpCSV(*outFile, events, *outComment); err != nil {
-		fmt.Fprintln(os.Stderr, "ERROR: problem writing CSV:", err)
-		os.Exit(1)
+	flag.Parse()
+	if *flags.showVersion {
+		os.Exit(runVersion(nil))
+	}
+	s, err := newCLISession(flags, ui, progressFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(2)
 	}
-	os.Exit(0)
+	os.Exit(s.record())
 }
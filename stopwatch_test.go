@@ -1,8 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetEventHeader(t *testing.T) {
@@ -11,3 +19,213 @@ func TestGetEventHeader(t *testing.T) {
 		t.Fatalf("Expected: %q, got: %q", expect, got)
 	}
 }
+
+func TestEncodeCSVExcel(t *testing.T) {
+	var buf bytes.Buffer
+	events := testEvents(time.Second)
+	opts := ExcelPreset(OutputOptions{Comment: "run 1", StatsFooter: true})
+	if err := EncodeCSV(&buf, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	expect := "\xEF\xBB\xBF" +
+		"# run 1;;\r\n" +
+		"# stopwatch-schema: 2;;\r\n" +
+		"seq;ts;what\r\n" +
+		"0;2022-04-08T20:00:00Z;enter\r\n" +
+		"1;2022-04-08T20:00:01Z;exit\r\n" +
+		"# total: 1\r\n# ticks: 0\r\n# laps: 0\r\n"
+	if got := buf.String(); got != expect {
+		t.Fatalf("Expected: %q, got: %q", expect, got)
+	}
+}
+
+func TestEncodeCSVExcelComment(t *testing.T) {
+	// a comment Excel would have quoted, read back with the delimiter sniffed
+	// and the padding stripped
+	comment := `a;b,c "quoted";`
+	events := testEvents(time.Second)
+	for _, delim := range []rune{';', ',', '\t'} {
+		path := filepath.Join(t.TempDir(), "excel.csv")
+		if err := DumpEvents(path, events, ExcelPreset(OutputOptions{Comment: comment, Delimiter: delim})); err != nil {
+			t.Fatal(err)
+		}
+		got, p, err := loadCSV(path, ReadOptions{})
+		if err != nil {
+			t.Fatalf("%q: %v", delim, err)
+		}
+		if p.comment != comment {
+			t.Errorf("%q: expected comment %q, got %q", delim, comment, p.comment)
+		}
+		if len(got) != len(events) {
+			t.Errorf("%q: expected %d events, got %d", delim, len(events), len(got))
+		}
+	}
+}
+
+func TestExcelPresetKeepsDelimiter(t *testing.T) {
+	var buf bytes.Buffer
+	opts := ExcelPreset(OutputOptions{Delimiter: '\t'})
+	if err := EncodeCSV(&buf, testEvents(time.Second), opts); err != nil {
+		t.Fatal(err)
+	}
+	if expect := "\xEF\xBB\xBF# stopwatch-schema: 2\t\t\r\nseq\tts\twhat\r\n"; !strings.HasPrefix(buf.String(), expect) {
+		t.Fatalf("Expected prefix %q, got: %q", expect, buf.String())
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	for input, expect := range map[string]rune{",": ',', ";": ';', `\t`: '\t', "|": '|'} {
+		if got, err := ParseDelimiter(input); err != nil || got != expect {
+			t.Errorf("ParseDelimiter(%q): expected %q, got %q (err: %v)", input, expect, got, err)
+		}
+	}
+	for _, input := range []string{"", ",,", `"`, "\n"} {
+		if _, err := ParseDelimiter(input); err == nil {
+			t.Errorf("ParseDelimiter(%q): expected error", input)
+		}
+	}
+}
+
+// manyEvents returns n events with labels that need quoting in CSV
+func manyEvents(n int) []Event {
+	offsets := make([]time.Duration, n)
+	for i := range offsets {
+		offsets[i] = 10 * time.Millisecond
+	}
+	events := testEvents(offsets...)
+	for i := 1; i < len(events)-1; i += 3 {
+		events[i].What = fmt.Sprintf("lap %d, \"quoted\"", i)
+	}
+	return events
+}
+
+func TestMarshallEventsCSVStreamed(t *testing.T) {
+	events := manyEvents(3*csvFlushRows + 1)
+	var want bytes.Buffer
+	want.WriteString("# run\n# stopwatch-schema: 2\n")
+	if err := csv.NewWriter(&want).WriteAll(EventsToRecords(events)); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := MarshallEventsCSV(&got, events, "run"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("Output differs from the records written at once")
+	}
+}
+
+func BenchmarkMarshallEventsCSV(b *testing.B) {
+	events := manyEvents(100000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := MarshallEventsCSV(io.Discard, events, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFillRows(t *testing.T) {
+	events := manyEvents(2*rowFillChunk + 1)
+	v := 1.5
+	events[1].Value, events[1].Group, events[2].Seq = &v, 12, 1<<40
+	for _, opts := range []OutputOptions{
+		{Columns: []string{"ts_ns", "value", "group"}},
+		{Columns: []string{"value"}, TSStyle: tsStyleOffsetSeconds, Start: events[0].Timestamp, DurationStyle: durationStyleGo},
+	} {
+		header := opts.Header()
+		records := opts.records(events)
+		for j, evt := range events {
+			for i, name := range header {
+				if got, want := records[j+1][i], opts.cell(evt, name); got != want {
+					t.Fatalf("event %d: expected %s %q, got %q", j, name, want, got)
+				}
+			}
+		}
+	}
+	if got, want := events[2].Row(), []string{"1099511627776", "2022-04-08T20:00:00.02Z", events[2].What}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func BenchmarkRow(b *testing.B) {
+	events := manyEvents(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, evt := range events {
+			evt.Row()
+		}
+	}
+}
+
+func BenchmarkEventsToRecords(b *testing.B) {
+	events := manyEvents(100000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		EventsToRecords(events)
+	}
+}
+
+// BenchmarkMarshallEventsCSVRecords is the previous implementation of
+// MarshallEventsCSV, converting all the events before writing them
+func BenchmarkMarshallEventsCSVRecords(b *testing.B) {
+	events := manyEvents(100000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := csv.NewWriter(io.Discard).WriteAll(EventsToRecords(events)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadLinesCancelled(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := make(chan inputLine)
+	done := make(chan bool)
+	go func() { done <- readLines(ctx, r, lines) }()
+
+	// nobody reads the line: the send is pending until cancelled
+	if _, err := io.WriteString(w, "tick\n"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case eof := <-done:
+		if eof {
+			t.Error("Expected readLines to report cancellation, not EOF")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readLines did not return after cancellation")
+	}
+}
+
+func TestReadLinesEOF(t *testing.T) {
+	long := strings.Repeat("x", 100*1024)
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"a\nb\n", []string{"a", "b"}},
+		{"lap one  two\r\n  spaced out \n", []string{"lap one  two", "spaced out"}},
+		{"a\n\nunterminated", []string{"a", "", "unterminated"}},
+		{long + "\nb\n", []string{long, "b"}},
+		// too long: stops like EOF
+		{"a\n" + strings.Repeat("x", stdinMaxLine+1) + "\nb\n", []string{"a"}},
+	}
+	for _, tt := range tests {
+		lines := make(chan inputLine, 10)
+		if !readLines(context.Background(), strings.NewReader(tt.input), lines) {
+			t.Error("Expected readLines to report EOF")
+		}
+		close(lines)
+		var got []string
+		for line := range lines {
+			got = append(got, line.text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expected %.40q, got %.40q", tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Stopwatch records events into a Session from several goroutines at
+// once, e.g. the network listeners and the watchers next to collect.
+//
+// Every method is safe for concurrent use. The events are recorded one at
+// a time, in the order the calls get the lock, so their sequence numbers
+// are consecutive and their timestamps never go back. The events returned
+// are copies: they are not changed by the events recorded after them.
+type Stopwatch struct {
+	mu      sync.Mutex
+	sess    *Session
+	out     io.Writer     // messages of the session, e.g. "not recorded"
+	changed chan struct{} // signalled after an event is recorded by Lap or remote
+}
+
+// NewStopwatch returns a Stopwatch recording into sess, which it then
+// owns: sess must no longer be used without the Stopwatch lock, see do.
+// The messages of the session are written into out.
+func NewStopwatch(sess *Session, out io.Writer) *Stopwatch {
+	return &Stopwatch{sess: sess, out: out, changed: make(chan struct{}, 1)}
+}
+
+// Lap records label as is, like a watcher would, and returns the event
+// recorded with its sequence number. If nothing was recorded, e.g. the
+// label is reserved or the session is paused, the reason is returned as
+// the error.
+func (sw *Stopwatch) Lap(label string) (Event, error) {
+	return sw.record(remoteLine{text: label, at: time.Now(), exact: true})
+}
+
+// remote records the line received from outside the terminal, and returns
+// the reply to the sender, see handleRemote. Passed on to
+// remoteInput.attach.
+func (sw *Stopwatch) remote(req remoteLine) string {
+	return remoteReply(sw.record(req))
+}
+
+func (sw *Stopwatch) record(req remoteLine) (evt Event, err error) {
+	sw.do(func(sess *Session) {
+		sess.checkTimers(sw.out) // keep the events in order
+		sess.handleInput(req.source, req.at, func() {
+			evt, err = sess.remoteEvent(req, sw.out)
+		})
+	})
+	select {
+	case sw.changed <- struct{}{}:
+	default: // already signalled
+	}
+	return copyEvent(evt), err
+}
+
+// Snapshot returns a copy of the events recorded so far, which can be
+// encoded while the recording goes on. With -keep-last, only the events
+// kept are included.
+func (sw *Stopwatch) Snapshot() []Event {
+	var events []Event
+	sw.do(func(sess *Session) {
+		events = make([]Event, len(sess.Events))
+		for i, evt := range sess.Events {
+			events[i] = copyEvent(evt)
+		}
+	})
+	return events
+}
+
+// do calls fn with the session, holding the lock
+func (sw *Stopwatch) do(fn func(sess *Session)) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	fn(sw.sess)
+}
+
+// copyEvent returns evt with Attrs of its own. The values pointed to are
+// not changed once recorded, so they are shared.
+func copyEvent(evt Event) Event {
+	if evt.Attrs != nil {
+		attrs := make(map[string]string, len(evt.Attrs))
+		for k, v := range evt.Attrs {
+			attrs[k] = v
+		}
+		evt.Attrs = attrs
+	}
+	return evt
+}
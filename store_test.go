@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// captureRemote attaches in to a channel receiving the lines instead of
+// recording them, replying "ok" to each
+func captureRemote(in *remoteInput) <-chan remoteLine {
+	requests := make(chan remoteLine, 100)
+	in.attach(func(req remoteLine) string {
+		requests <- req
+		return "ok"
+	})
+	return requests
+}
+
+// expectNoRemote fails unless there are no lines left in requests
+func expectNoRemote(t *testing.T, requests <-chan remoteLine) {
+	t.Helper()
+	select {
+	case req := <-requests:
+		t.Errorf("Expected no more lines, got %+v", req)
+	default:
+	}
+}
+
+func TestStopwatchConcurrent(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	sess.start()
+	sw := NewStopwatch(sess, io.Discard)
+
+	const workers, laps = 16, 200
+	seqs := make(chan int64, workers*laps)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < laps; i++ {
+				evt, err := sw.Lap(fmt.Sprintf("w%d-%d", w, i))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if evt.What != fmt.Sprintf("w%d-%d", w, i) || evt.Timestamp.IsZero() {
+					t.Errorf("Expected the event recorded, got %+v", evt)
+				}
+				seqs <- evt.Seq
+			}
+		}(w)
+	}
+	// snapshots taken while recording are consistent
+	stop := make(chan struct{})
+	snapshots := make(chan error)
+	go func() {
+		for {
+			events := sw.Snapshot()
+			for i, evt := range events {
+				if evt.Seq != int64(i) || (i > 0 && evt.Timestamp.Before(events[i-1].Timestamp)) {
+					snapshots <- fmt.Errorf("unexpected event %d in a snapshot: %+v", i, evt)
+					return
+				}
+			}
+			select {
+			case <-stop:
+				snapshots <- nil
+				return
+			default:
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	if err := <-snapshots; err != nil {
+		t.Error(err)
+	}
+	close(seqs)
+
+	seen := make(map[int64]bool)
+	for seq := range seqs {
+		if seen[seq] || seq < 1 || seq > workers*laps {
+			t.Errorf("Unexpected seq %d", seq)
+		}
+		seen[seq] = true
+	}
+	if events := sw.Snapshot(); len(events) != workers*laps+1 || len(seen) != workers*laps {
+		t.Errorf("Expected %d events, got %d", workers*laps+1, len(events))
+	}
+}
+
+func TestStopwatchLap(t *testing.T) {
+	sess := newSession("", collectOptions{})
+	sw := NewStopwatch(sess, io.Discard)
+	sw.do(func(sess *Session) { sess.start() })
+	if _, err := sw.Lap("exit"); err == nil || err.Error() != `Label "exit" is reserved, not recorded` {
+		t.Errorf("Expected the reserved label to be refused, got %v", err)
+	}
+	if reply := sw.remote(remoteLine{text: "a=1"}); reply != "ok 1" {
+		t.Errorf("Expected ok 1, got %q", reply)
+	}
+
+	// a snapshot is not changed by what is recorded later
+	events := sw.Snapshot()
+	events[1].Attrs["a"] = "2"
+	sw.Lap("b")
+	if got := sw.Snapshot(); len(events) != 2 || len(got) != 3 || got[1].Attrs["a"] != "1" {
+		t.Errorf("Expected the snapshot to be a copy, got %v and %v", events, got)
+	}
+	select {
+	case <-sw.changed:
+	default:
+		t.Error("Expected the recording to be signalled")
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// streamOutput writes the events into the output as soon as they are
+// recorded, for -stream: as CSV, with the columns fixed at startup, as
+// NDJSON, or as the lines of a -line-template. A named pipe is opened once a reader appears on the other side,
+// and again whenever the reader goes away; the events are kept until they
+// are written. With -split-daily, the events go into a file per day.
+// A file that can no longer be written, or is removed, is opened again in
+// the background, and the events are kept until then; see lose.
+type streamOutput struct {
+	name string
+	fifo bool
+	opts OutputOptions
+	errs sinkErrors
+	stop chan struct{} // closed by Close, stopping the reopener
+
+	lineErrs sinkErrors // of executing the -line-template
+
+	loc *time.Location // with -split-daily, the time zone of the days
+	day string         // the day of the file open, YYYY-MM-DD
+
+	// with -line-template, the times of the first event and of the last
+	// one written, for the Elapsed and Delta of TemplateEvent
+	first, last time.Time
+
+	mu         sync.Mutex
+	out        io.Writer // nil while waiting for a reader
+	file       *os.File  // the file to close, unless inherited
+	path       string    // the path of file, if a regular file created by the stream
+	lost       bool      // the file at path could not be written, and is being opened again
+	needHeader bool      // the header is still to be written into out
+	pending    []Event   // recorded, but not yet written
+	closed     bool
+}
+
+// newStreamOutput opens the output outFile for streaming, see DumpEvents for
+// the names. Only the csv and ndjson formats can be streamed, unless
+// opts.LineTemplate is given. With a named
+// pipe, returns at once and waits for the reader in the background.
+func newStreamOutput(outFile string, opts OutputOptions, warn io.Writer) (*streamOutput, error) {
+	if opts.Format != "" && opts.Format != "csv" && opts.Format != "ndjson" && opts.LineTemplate == nil {
+		return nil, fmt.Errorf("-stream supports the csv and ndjson formats, not %q", opts.Format)
+	}
+	if opts.Head > 0 || opts.Tail > 0 {
+		return nil, errors.New("-stream writes every event, not only those of -head or -tail")
+	}
+	if opts.Order == orderDesc {
+		return nil, errors.New("-stream can not write -order desc: the newest event is not known until the session ends")
+	}
+	// the events to come may have any data, so every column is included
+	sample := Event{Value: new(float64), Flag: "-", Group: 1, Phase: "-"}
+	if !opts.Since.IsZero() {
+		sample.Offset = new(time.Duration)
+	}
+	opts.Columns = dataColumns([]Event{sample}, opts.Columns)
+	// and any attribute goes into the attrs column
+	opts.AttrsStyle, opts.Attrs = attrsStyleJSON, []string{attrsColumn}
+	opts.StatsFooter = false
+	s := &streamOutput{name: outFile, opts: opts, errs: sinkErrors{out: warn, name: "-stream"},
+		lineErrs: sinkErrors{out: warn, name: "-line-template"}, stop: make(chan struct{})}
+
+	if f, err := outputStream(outFile); err != nil {
+		return nil, err
+	} else if f != nil {
+		if opts.SplitDaily {
+			return nil, errors.New("-split-daily requires an output file")
+		}
+		return s, s.connect(f, nil)
+	}
+	if fi, err := os.Stat(outFile); err == nil && fi.Mode()&os.ModeNamedPipe != 0 {
+		if opts.SplitDaily {
+			return nil, errors.New("-split-daily can not write into a named pipe")
+		}
+		s.fifo = true
+		go s.open()
+		return s, nil
+	}
+	if opts.MkDirs {
+		if err := os.MkdirAll(filepath.Dir(outFile), 0o755); err != nil {
+			return nil, fmt.Errorf("could not create directory: %w", err)
+		}
+	}
+	if opts.SplitDaily {
+		// the first file is created for the day of the first event
+		s.loc = time.Local
+		return s, nil
+	}
+	f, err := os.Create(outFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not create file: %w", err)
+	}
+	s.path = outFile
+	return s, s.connect(f, f)
+}
+
+// open opens the named pipe, which blocks until a reader appears
+func (s *streamOutput) open() {
+	fmt.Fprintf(s.errs.out, "\n# Waiting for a reader on the pipe %s; the events are kept meanwhile\n", s.name)
+	f, err := os.OpenFile(s.name, os.O_WRONLY, 0)
+	if err != nil {
+		s.errs.report(err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		f.Close()
+		return
+	}
+	s.out, s.file, s.needHeader = f, f, true
+	s.flush()
+}
+
+// connect starts writing into out, closing file at the end
+func (s *streamOutput) connect(out io.Writer, file *os.File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out, s.file, s.needHeader = out, file, true
+	if !s.flush() {
+		if file != nil {
+			file.Close()
+		}
+		return fmt.Errorf("could not write the header into %s", s.name)
+	}
+	return nil
+}
+
+// flush writes the header if needed and the pending events, and reports
+// whether that succeeded. A named pipe whose reader went away is opened
+// again. Must be called with s.mu held.
+func (s *streamOutput) flush() bool {
+	if s.out == nil || (!s.needHeader && len(s.pending) == 0) {
+		return s.out != nil
+	}
+	// written into a removed file, the events would be lost silently
+	if s.path != "" && !s.present() {
+		s.lose(fmt.Errorf("the file was removed or replaced"))
+		return false
+	}
+	// written at once, so that a write either reaches the reader or not
+	var buf bytes.Buffer
+	if s.needHeader {
+		buf.Write(s.header())
+	}
+	s.encode(&buf, s.pending)
+	if _, err := s.out.Write(buf.Bytes()); err != nil {
+		if s.path != "" {
+			s.lose(err)
+			return false
+		}
+		if !s.fifo {
+			s.errs.report(err)
+			return false
+		}
+		fmt.Fprintf(s.errs.out, "\n# WARNING: the reader of %s went away\n", s.name)
+		s.file.Close()
+		s.out, s.file = nil, nil
+		if !s.closed {
+			go s.open()
+		}
+		return false
+	}
+	if len(s.pending) > 0 {
+		s.last = s.pending[len(s.pending)-1].Timestamp
+	}
+	s.needHeader, s.pending = false, s.pending[:0]
+	return true
+}
+
+// header returns the header of the output, written before the first event
+func (s *streamOutput) header() []byte {
+	var buf bytes.Buffer
+	if s.opts.Format != "ndjson" && s.opts.LineTemplate == nil {
+		encodeCSV(&buf, nil, s.opts)
+	}
+	return buf.Bytes()
+}
+
+// present reports whether the file at s.path is still the one written
+func (s *streamOutput) present() bool {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+	open, err := s.file.Stat()
+	return err == nil && os.SameFile(fi, open)
+}
+
+// lose gives up the file at s.path after err, keeping the events pending
+// in memory, and opens the file again in the background: see reopener and
+// openStreamTarget. The pending events are written once it is open. Must
+// be called with s.mu held.
+func (s *streamOutput) lose(err error) {
+	fmt.Fprintf(s.errs.out, "\n# WARNING: -stream: could not write %s: %v\n"+
+		"# WARNING: the events are kept in memory until the file can be written again\n", s.path, err)
+	s.file.Close()
+	s.out, s.file, s.lost = nil, nil, true
+	if !s.closed {
+		path := s.path
+		go s.reopen(path, reopener{min: reopenMinWait, max: reopenMaxWait, after: time.After, open: func() (*os.File, error) {
+			return openStreamTarget(path, s.header(), s.opts.MkDirs)
+		}})
+	}
+}
+
+// reopen opens the file path lost with r, see lose, and writes the events
+// kept meanwhile into it
+func (s *streamOutput) reopen(path string, r reopener) {
+	f := r.run(s.stop)
+	if f == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.lost || s.path != path {
+		// Close tried once more itself, or the day of -split-daily changed
+		f.Close()
+		return
+	}
+	s.resume(f)
+}
+
+// resume writes the events kept while the file at s.path was lost into f,
+// opened again at its end. Must be called with s.mu held.
+func (s *streamOutput) resume(f *os.File) {
+	n := len(s.pending)
+	s.out, s.file, s.needHeader, s.lost = f, f, false, false
+	if s.flush() {
+		fmt.Fprintf(s.errs.out, "\n# -stream: writing %s again, events kept meanwhile: %d\n", s.path, n)
+	}
+}
+
+// encode writes events into out without the header
+func (s *streamOutput) encode(out io.Writer, events []Event) {
+	if s.opts.LineTemplate != nil {
+		last := s.last
+		for _, evt := range events {
+			e := TemplateEvent{Event: evt, Elapsed: evt.Timestamp.Sub(s.first)}
+			if !last.IsZero() {
+				e.Delta = evt.Timestamp.Sub(last)
+			}
+			last = evt.Timestamp
+			if err := executeLine(out, s.opts.LineTemplate, e, s.opts.eol()); err != nil {
+				s.lineErrs.report(fmt.Errorf("event %d not written: %w", evt.Seq, err))
+			}
+		}
+		return
+	}
+	if s.opts.Format == "ndjson" {
+		EncodeNDJSON(out, events, s.opts)
+		return
+	}
+	w := csv.NewWriter(out)
+	w.UseCRLF = s.opts.CRLF
+	if s.opts.Delimiter != 0 {
+		w.Comma = s.opts.Delimiter
+	}
+	header := s.opts.Header()
+	row := make([]string, len(header))
+	for _, evt := range events {
+		s.opts.fillCells(evt, row, header)
+		w.Write(row)
+	}
+	w.Flush()
+}
+
+// Send implements eventSink
+func (s *streamOutput) Send(evt Event) {
+	if !s.opts.Since.IsZero() {
+		evt = withOffset(evt, s.opts.Since)
+	}
+	if !s.opts.keep(evt) {
+		return
+	}
+	if s.opts.Redact != nil {
+		evt = s.opts.Redact.event(evt)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.first.IsZero() {
+		s.first = evt.Timestamp
+	}
+	if s.loc != nil {
+		s.rollover(evt)
+	}
+	s.pending = append(s.pending, evt)
+	s.flush()
+}
+
+// rollover closes the file of the previous day and creates the file of the
+// day of evt, if that is another day: "<base>.<YYYY-MM-DD><ext>", see
+// splitName. Each file starts with the header and the comment. Must be
+// called with s.mu held.
+func (s *streamOutput) rollover(evt Event) {
+	day := evt.Timestamp.In(s.loc).Format("2006-01-02")
+	if day == s.day {
+		return
+	}
+	s.flush()
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			s.errs.report(err)
+		}
+		s.out, s.file = nil, nil
+	}
+	s.day = day
+	s.path, s.lost = "", false
+	f, err := os.Create(splitName(s.name, day))
+	if err != nil {
+		s.errs.report(fmt.Errorf("could not create file: %w", err))
+		return
+	}
+	s.out, s.file, s.path, s.needHeader = f, f, splitName(s.name, day), true
+}
+
+// Close implements eventSink. A lost file is tried once more, and the
+// events still pending are left for failed to report.
+func (s *streamOutput) Close(Stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		close(s.stop)
+	}
+	s.closed = true
+	if s.lost {
+		if f, err := openStreamTarget(s.path, s.header(), s.opts.MkDirs); err == nil {
+			s.resume(f)
+		}
+	}
+	s.flush()
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			s.errs.report(err)
+		}
+	}
+	s.out, s.file = nil, nil
+	if s.opts.Redact != nil {
+		if err := s.opts.Redact.writeMap(); err != nil {
+			s.errs.report(err)
+		}
+	}
+}
+
+// failed returns an error if some events could not be written
+func (s *streamOutput) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) > 0 {
+		return fmt.Errorf("the last %d events could not be streamed into %s", len(s.pending), s.name)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamOutputFile(t *testing.T) {
+	events := testEvents(time.Second, time.Second)
+	events[1].Attrs = map[string]string{"lane": "3"}
+	path := filepath.Join(t.TempDir(), "out.csv")
+	s, err := newStreamOutput(path, OutputOptions{Format: "csv", Comment: "run 1"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, evt := range events {
+		s.Send(evt)
+		b, _ := os.ReadFile(path)
+		if n := strings.Count(string(b), "\n"); n != i+4 {
+			t.Errorf("Expected each event to be written at once, got:\n%s", b)
+		}
+	}
+	s.Close(ComputeStats(events))
+	if err := s.failed(); err != nil {
+		t.Error(err)
+	}
+	want := "# run 1\n# stopwatch-schema: 2\nseq,ts,what,value,flag,group,phase,attrs\n" +
+		"0,2022-04-08T20:00:00Z,enter,,,0,,\n" +
+		`1,2022-04-08T20:00:01Z,tick,,,0,,"{""lane"":""3""}"` + "\n" +
+		"2,2022-04-08T20:00:02Z,exit,,,0,,\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, b)
+	}
+	// the columns are fixed, so the file reads back like any output
+	loaded, _, err := LoadCSV(path)
+	if err != nil || len(loaded) != 3 || loaded[1].Attrs["lane"] != "3" {
+		t.Errorf("Unexpected events read back: %v, %v", loaded, err)
+	}
+}
+
+func TestStreamOutputNDJSON(t *testing.T) {
+	events := testEvents(time.Second)
+	path := filepath.Join(t.TempDir(), "out.ndjson")
+	s, err := newStreamOutput(path, OutputOptions{Format: "ndjson"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, evt := range events {
+		s.Send(evt)
+	}
+	s.Close(ComputeStats(events))
+	var want bytes.Buffer
+	EncodeNDJSON(&want, events, s.opts)
+	if b, _ := os.ReadFile(path); string(b) != want.String() {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want.String(), b)
+	}
+
+	if _, err := newStreamOutput(path, OutputOptions{Format: "latex"}, io.Discard); err == nil {
+		t.Error("Expected error for a format that can not be streamed")
+	}
+}
+
+func TestStreamOutputSplitDaily(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv")
+	s, err := newStreamOutput(path, OutputOptions{Format: "csv", Comment: "long run", SplitDaily: true}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// midnight in +02:00 is 22:00 UTC
+	s.loc = time.FixedZone("EET", 2*60*60)
+	at := time.Date(2022, 4, 8, 21, 0, 0, 0, time.UTC)
+	events := []Event{{Seq: 0, Timestamp: at, What: labelEnter}}
+	for i, d := range []time.Duration{30 * time.Minute, 45 * time.Minute, 24 * time.Hour, time.Minute} {
+		at = at.Add(d)
+		events = append(events, Event{Seq: int64(i + 1), Timestamp: at, What: labelTick})
+	}
+	events[len(events)-1].What = labelExit
+	for _, evt := range events {
+		s.Send(evt)
+	}
+	s.Close(ComputeStats(events))
+	if err := s.failed(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Expected no file without a date")
+	}
+	for day, seqs := range map[string][]int64{"2022-04-08": {0, 1}, "2022-04-09": {2}, "2022-04-10": {3, 4}} {
+		name := filepath.Join(dir, "out."+day+".csv")
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(b), "# long run\n# stopwatch-schema: 2\nseq,ts,") {
+			t.Errorf("%s: expected the comment and the header, got:\n%s", name, b)
+		}
+		loaded, _, err := LoadCSV(name)
+		if err != nil || len(loaded) != len(seqs) {
+			t.Fatalf("%s: expected events %v, got %v, %v", name, seqs, loaded, err)
+		}
+		for i, evt := range loaded {
+			if evt.Seq != seqs[i] {
+				t.Errorf("%s: expected events %v, got %v", name, seqs, loaded)
+			}
+		}
+	}
+	// the days read back as one session
+	if all, _, err := LoadCSVFiles([]string{filepath.Join(dir, "out.*.csv")}); err != nil || len(all) != len(events) {
+		t.Errorf("Expected %d events from the daily files, got %v, %v", len(events), all, err)
+	}
+
+	if _, err := newStreamOutput("-", OutputOptions{SplitDaily: true}, io.Discard); err == nil {
+		t.Error("Expected an error for -split-daily into stdout")
+	}
+}
+
+func TestStreamOutputLineTemplate(t *testing.T) {
+	tmpl, err := ParseLineTemplate(`{{.Seq}} {{.Timestamp.Unix}} {{.What}} {{seconds .Elapsed}} {{seconds .Delta}}{{if eq .Seq 2}}{{.Nope}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := testEvents(time.Second, 500*time.Millisecond, time.Second)
+	events[1].What = "two\nlines"
+	path := filepath.Join(t.TempDir(), "out.txt")
+	var warn bytes.Buffer
+	s, err := newStreamOutput(path, OutputOptions{Format: "csv", Comment: "not written", LineTemplate: tmpl}, &warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, evt := range events {
+		s.Send(evt)
+	}
+	s.Close(ComputeStats(events))
+	if err := s.failed(); err != nil {
+		t.Error(err)
+	}
+	want := "0 1649448000 enter 0 0\n" +
+		`1 1649448001 two\nlines 1 1` + "\n" +
+		"3 1649448002 exit 2.5 1\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, b)
+	}
+	if !strings.Contains(warn.String(), "-line-template: event 2 not written") {
+		t.Errorf("Expected the failed event to be reported, got %q", warn.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer written and read from several goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStreamOutputLost(t *testing.T) {
+	defer func(min, max time.Duration) { reopenMinWait, reopenMaxWait = min, max }(reopenMinWait, reopenMaxWait)
+	reopenMinWait, reopenMaxWait = time.Millisecond, 10*time.Millisecond
+	events := testEvents(time.Second, time.Second, time.Second)
+	dir := filepath.Join(t.TempDir(), "runs")
+	os.Mkdir(dir, 0o755)
+	path := filepath.Join(dir, "out.csv")
+	var warn syncBuffer
+	s, err := newStreamOutput(path, OutputOptions{Format: "csv"}, &warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Send(events[0])
+	os.RemoveAll(dir)
+	s.Send(events[1])
+	s.Send(events[2])
+	if n := strings.Count(warn.String(), "WARNING: -stream: could not write"); n != 1 {
+		t.Errorf("Expected a single warning, got %q", warn.String())
+	}
+	// the file is created again once the directory is back
+	os.Mkdir(dir, 0o755)
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(warn.String(), "writing "+path+" again") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	s.Send(events[3])
+	s.Close(ComputeStats(events))
+	if err := s.failed(); err != nil {
+		t.Error(err)
+	}
+	want := "# stopwatch-schema: 2\nseq,ts,what,value,flag,group,phase,attrs\n" +
+		"1,2022-04-08T20:00:01Z,tick,,,0,,\n" +
+		"2,2022-04-08T20:00:02Z,tick,,,0,,\n" +
+		"3,2022-04-08T20:00:03Z,exit,,,0,,\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("Expected the events kept in the new file:\n%s\ngot:\n%s", want, b)
+	}
+	if !strings.Contains(warn.String(), "events kept meanwhile: 2") {
+		t.Errorf("Expected the resume to be reported, got %q", warn.String())
+	}
+
+	// still lost at the end: the events are left for the fallback
+	reopenMinWait = time.Hour
+	if s, err = newStreamOutput(path, OutputOptions{Format: "csv"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(dir)
+	for _, evt := range events {
+		s.Send(evt)
+	}
+	s.Close(ComputeStats(events))
+	if err := s.failed(); err == nil || !strings.Contains(err.Error(), "the last 4 events") {
+		t.Errorf("Expected the events to be reported as not streamed, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStreamOutputPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipe")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skip("no named pipes:", err)
+	}
+	events := testEvents(time.Second, time.Second, time.Second)
+	s, err := newStreamOutput(path, OutputOptions{Format: "csv"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// recorded before the reader appears
+	s.Send(events[0])
+
+	r, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewReader(r)
+	readLine := func() string {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+	if schema := readLine(); schema != formatSchemaLine()+"\n" {
+		t.Errorf("Expected the schema first, got %q", schema)
+	}
+	if header := readLine(); !strings.HasPrefix(header, "seq,ts,what") {
+		t.Errorf("Expected the header first, got %q", header)
+	}
+	if line := readLine(); !strings.HasPrefix(line, "0,") {
+		t.Errorf("Expected the event kept for the reader, got %q", line)
+	}
+	s.Send(events[1])
+	if line := readLine(); !strings.HasPrefix(line, "1,") {
+		t.Errorf("Expected the next event, got %q", line)
+	}
+
+	// the reader goes away: the events are kept for the next one
+	r.Close()
+	s.Send(events[2])
+	s.Close(ComputeStats(events))
+	if err := s.failed(); err == nil || !strings.Contains(err.Error(), "last 1 events") {
+		t.Errorf("Expected the unwritten event to be reported, got %v", err)
+	}
+	// a reader lets the stream waiting for one finish
+	if r, err := os.Open(path); err == nil {
+		r.Close()
+	}
+}
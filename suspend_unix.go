@@ -0,0 +1,93 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchSuspend records a "suspended" event when the process is stopped by
+// SIGTSTP, e.g. by ctrl-z, and a "resumed" event when it is continued,
+// marked with attrExcluded if exclude is set. The process is stopped once
+// the event is recorded, by raising SIGTSTP again with its default action;
+// meanwhile tty, if not nil, is left for the shell.
+func watchSuspend(in *remoteInput, exclude bool, tty *cbreakMode) {
+	stopped := make(chan os.Signal, 1)
+	continued := make(chan os.Signal, 1)
+	signal.Notify(stopped, syscall.SIGTSTP)
+	signal.Notify(continued, syscall.SIGCONT)
+	done := make(chan struct{})
+	in.stops = append(in.stops, func() {
+		signal.Stop(stopped)
+		signal.Stop(continued)
+		close(done)
+	})
+	record := func(label string) {
+		var attrs map[string]string
+		if exclude {
+			attrs = map[string]string{attrExcluded: "true"}
+		}
+		in.send(remoteLine{text: label, source: sourceSignal, at: time.Now(), attrs: attrs, suspension: true})
+	}
+	in.wg.Add(1)
+	go func() {
+		defer in.wg.Done()
+		for {
+			select {
+			case <-stopped:
+				record(labelSuspended)
+				tty.disable()
+				if !suspend(stopped, continued, done) {
+					return
+				}
+				tty.enable()
+				record(labelResumed)
+			case <-continued:
+				// stopped by SIGSTOP, which can not be caught
+				record(labelResumed)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// suspend stops the process by the default action of SIGTSTP, and returns
+// once it is continued, SIGTSTP being delivered to stopped again. Returns
+// false if done is closed first.
+func suspend(stopped, continued chan os.Signal, done <-chan struct{}) bool {
+	signal.Reset(syscall.SIGTSTP)
+	syscall.Kill(syscall.Getpid(), syscall.SIGTSTP)
+	// the kernel discards the signal in an orphaned process group, which
+	// is then not stopped at all
+	select {
+	case <-continued:
+	case <-time.After(time.Second):
+		// or stopped for longer, the SIGCONT being on its way
+		select {
+		case <-continued:
+		case <-time.After(100 * time.Millisecond):
+		}
+	case <-done:
+		return false
+	}
+	signal.Notify(stopped, syscall.SIGTSTP)
+	return true
+}
@@ -0,0 +1,47 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchSuspend(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to continue the test")
+	}
+	remote := newRemoteInput()
+	watchSuspend(remote, true, nil)
+	// the test is stopped, and continued by sh
+	cont := exec.Command("sh", "-c", fmt.Sprintf("sleep 0.5; kill -CONT %d", os.Getpid()))
+	events, err := Run(context.Background(), withRemote(remote), WithMaxEvents(2), WithTimeout(10*time.Second),
+		WithEventFunc(func(evt Event) {
+			if evt.What == labelEnter {
+				if err := cont.Start(); err != nil {
+					t.Error(err)
+					return
+				}
+				go syscall.Kill(syscall.Getpid(), syscall.SIGTSTP)
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cont.Wait()
+	if len(events) != 4 || events[1].What != labelSuspended || events[2].What != labelResumed ||
+		events[2].Attrs[attrExcluded] != "true" || events[2].Source != sourceSignal {
+		t.Fatalf("Expected the suspension to be recorded, got %+v", events)
+	}
+	if gap := events[2].Timestamp.Sub(events[1].Timestamp); gap < 400*time.Millisecond {
+		t.Errorf("Expected the process to be stopped, got a gap of %v", gap)
+	}
+	if s := ComputeStats(events); s.Active > s.Total-400*time.Millisecond {
+		t.Errorf("Expected the suspension to be left out of %v, got %v active", s.Total, s.Active)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func init() {
+	sinkKinds["syslog"] = "system log"
+}
+
+// syslogSink writes every event into the system log at the INFO level
+type syslogSink struct {
+	w       *syslog.Writer
+	session string
+	errs    sinkErrors
+}
+
+// newSyslogSink connects to the syslog daemon at raddr over network, or to
+// the local one if both are empty. Failures are reported into errOut.
+func newSyslogSink(network, raddr, tag, session string, errOut io.Writer) (eventSink, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w, session: session, errs: sinkErrors{out: errOut, name: "syslog"}}, nil
+}
+
+func (l *syslogSink) Send(evt Event) {
+	l.errs.report(l.w.Info(eventMessage(evt, l.session)))
+}
+
+func (l *syslogSink) Close(s Stats) {
+	l.errs.report(l.w.Info(summaryMessage(s, l.session)))
+	l.w.Close()
+}
@@ -0,0 +1,56 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogSink(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Skip("unix datagram sockets not available:", err)
+	}
+	defer conn.Close()
+
+	var errOut bytes.Buffer
+	sink, err := newSyslogSink("unixgram", addr, "stopwatch", "01SESSION", &errOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := testEvents(time.Second, 2*time.Second)
+	events[1].What = `say "hi"`
+	for _, evt := range events {
+		sink.Send(evt)
+	}
+	sink.Close(ComputeStats(events))
+
+	want := []string{
+		`seq=0 ts=2022-04-08T20:00:00Z what="enter" session=01SESSION`,
+		`seq=1 ts=2022-04-08T20:00:01Z what="say \"hi\"" session=01SESSION`,
+		`seq=2 ts=2022-04-08T20:00:03Z what="exit" session=01SESSION`,
+		`summary total=3s ticks=1 laps=1 session=01SESSION`,
+	}
+	buf := make([]byte, 1024)
+	for _, w := range want {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// <priority>timestamp hostname tag[pid]: message
+		msg := string(buf[:n])
+		if !strings.HasPrefix(msg, "<14>") || !strings.Contains(msg, " stopwatch[") || !strings.HasSuffix(msg, ": "+w+"\n") {
+			t.Errorf("Expected message %q, got %q", w, msg)
+		}
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("Unexpected errors: %q", errOut.String())
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tcpMaxLine      = 4096      // longer lines close the connection
+	tcpIdleTimeout  = time.Hour // connections idle for longer are closed
+	tcpReplyTimeout = 10 * time.Second
+	tcpDrainTimeout = 100 * time.Millisecond // to read the lines already sent at stop
+)
+
+// lineListener accepts TCP connections and passes each line received on
+// them to collect, as the label of a tick
+type lineListener struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	conns   map[net.Conn]bool
+	stopped bool
+}
+
+// listenLines starts accepting connections at the TCP address addr,
+// passing the lines on into in
+func listenLines(in *remoteInput, addr string) (*lineListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &lineListener{ln: ln, conns: make(map[net.Conn]bool)}
+	in.start("-tcp", l)
+	return l, nil
+}
+
+// Addr returns the address the listener accepts connections at
+func (l *lineListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// stop stops accepting connections and reading from them. The lines
+// already received are still passed on.
+func (l *lineListener) stop() {
+	l.mu.Lock()
+	l.stopped = true
+	l.ln.Close()
+	for conn := range l.conns {
+		conn.SetReadDeadline(time.Now().Add(tcpDrainTimeout))
+	}
+	l.mu.Unlock()
+}
+
+// run accepts connections until ctx is done, and returns once the lines
+// received on them have been passed on
+func (l *lineListener) run(ctx context.Context, emit func(req remoteLine) string) error {
+	go func() {
+		<-ctx.Done()
+		l.stop()
+	}()
+	var serving sync.WaitGroup
+	defer serving.Wait()
+	for {
+		conn, err := l.ln.Accept()
+		l.mu.Lock()
+		stopped := l.stopped
+		if err == nil && !stopped {
+			l.conns[conn] = true
+			serving.Add(1)
+		}
+		l.mu.Unlock()
+		switch {
+		case stopped:
+			if err == nil {
+				conn.Close()
+			}
+			return nil
+		case err != nil:
+			l.stop()
+			return err
+		}
+		go func() {
+			defer serving.Done()
+			l.serveConn(conn, emit)
+		}()
+	}
+}
+
+// serveConn passes the lines received on conn on to emit, each in turn,
+// and replies with the outcome
+func (l *lineListener) serveConn(conn net.Conn, emit func(req remoteLine) string) {
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReaderSize(conn, tcpMaxLine)
+	for {
+		l.mu.Lock()
+		if !l.stopped {
+			conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		}
+		l.mu.Unlock()
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			conn.SetWriteDeadline(time.Now().Add(tcpReplyTimeout))
+			fmt.Fprintf(conn, "error line longer than %d bytes\n", tcpMaxLine)
+			return
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return
+		}
+		reply := emit(remoteLine{text: strings.TrimSpace(string(line)), source: sourceTCP, at: time.Now()})
+		conn.SetWriteDeadline(time.Now().Add(tcpReplyTimeout))
+		if _, werr := fmt.Fprintln(conn, reply); werr != nil || err != nil {
+			return
+		}
+	}
+}
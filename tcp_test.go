@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestLineListener(t *testing.T) {
+	in := newRemoteInput()
+	l, err := listenLines(in, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := newSession("", collectOptions{})
+	sess.start()
+	in.attach(NewStopwatch(sess, io.Discard).remote)
+
+	// a long lived connection gets a reply per line
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for i, want := range []string{"ok 1\n", "ok 2\n"} {
+		io.WriteString(conn, []string{"first\n", "\r\n"}[i])
+		if got, err := r.ReadString('\n'); got != want {
+			t.Errorf("Expected %q, got %q (%v)", want, got, err)
+		}
+	}
+
+	// a one shot client may leave out the final newline
+	oneShot, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(oneShot, "last")
+	oneShot.(*net.TCPConn).CloseWrite()
+	if reply, _ := io.ReadAll(oneShot); string(reply) != "ok 3\n" {
+		t.Errorf("Expected ok 3, got %q", reply)
+	}
+	oneShot.Close()
+
+	// a line too long closes the connection, others are not affected
+	flood, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(flood, strings.Repeat("x", tcpMaxLine+1))
+	if reply, _ := io.ReadAll(flood); !strings.HasPrefix(string(reply), "error line longer") {
+		t.Errorf("Expected an error, got %q", reply)
+	}
+	flood.Close()
+	io.WriteString(conn, "after\n")
+	if got, _ := r.ReadString('\n'); got != "ok 4\n" {
+		t.Errorf("Expected ok 4, got %q", got)
+	}
+
+	// the lines already sent are recorded when the listener stops
+	io.WriteString(conn, "pending\n")
+	in.stop()
+	var got []string
+	for _, evt := range sess.Events[1:] {
+		got = append(got, evt.What)
+	}
+	if want := "first tick last after pending"; strings.Join(got, " ") != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is what the template of -format template is executed with
+type TemplateData struct {
+	Comment  string
+	Metadata TemplateMetadata
+	Events   []TemplateEvent
+	Stats    Stats
+}
+
+// TemplateMetadata describes the session of TemplateData
+type TemplateMetadata struct {
+	Name       string    // of the session, see -name
+	Start, End time.Time // of the first and the last event
+	Schema     int       // version of the CSV layout of this stopwatch, see schemaVersion
+	Columns    []string  // header of the events in the CSV output, with the names of -rename
+}
+
+// TemplateEvent is an event with the durations templates usually want
+type TemplateEvent struct {
+	Event
+	Elapsed time.Duration // since the first event
+	Delta   time.Duration // since the previous event, zero for the first one
+}
+
+// templateFuncs are the functions available to templates, in addition to
+// the builtin ones of text/template
+var templateFuncs = template.FuncMap{
+	// duration rounded to milliseconds, e.g. 1m23.4s
+	"duration": formatDuration,
+	// duration in seconds, e.g. 83.4
+	"seconds": func(d time.Duration) string { return formatSeconds(d.Seconds()) },
+	// time formatted with a Go layout: {{.Timestamp | time "15:04:05"}}
+	"time": func(layout string, t time.Time) string { return t.Format(layout) },
+	// value of an event, empty if it has none
+	"value": func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return formatValue(*v)
+	},
+}
+
+// ParseTemplateFile parses the template of -format template from path
+func ParseTemplateFile(path string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// NewTemplateData returns the data of events for templates
+func NewTemplateData(events []Event, opts OutputOptions) TemplateData {
+	data := TemplateData{
+		Comment:  opts.Comment,
+		Metadata: TemplateMetadata{Name: opts.Name, Schema: schemaVersion, Columns: opts.headerText(opts.Header())},
+		Stats:    ComputeStats(events),
+	}
+	for i, evt := range events {
+		e := TemplateEvent{Event: evt, Elapsed: evt.Timestamp.Sub(events[0].Timestamp)}
+		if i > 0 {
+			e.Delta = evt.Timestamp.Sub(events[i-1].Timestamp)
+		}
+		data.Events = append(data.Events, e)
+	}
+	if len(events) > 0 {
+		data.Metadata.Start, data.Metadata.End = events[0].Timestamp, events[len(events)-1].Timestamp
+	}
+	return data
+}
+
+// EncodeTemplate writes events into out by executing opts.Template with
+// their TemplateData
+func EncodeTemplate(out io.Writer, events []Event, opts OutputOptions) error {
+	if opts.Template == nil {
+		return fmt.Errorf("no template given, see -template-file")
+	}
+	w := bufio.NewWriter(out)
+	if err := opts.Template.Execute(w, NewTemplateData(events, opts)); err != nil {
+		return fmt.Errorf("could not execute template: %w", err)
+	}
+	return w.Flush()
+}
+
+// ParseLineTemplate parses the template of -line-template, which makes a
+// line of each TemplateEvent
+func ParseLineTemplate(text string) (*template.Template, error) {
+	return template.New("line").Funcs(templateFuncs).Parse(text)
+}
+
+// lineEscaper keeps the output of a -line-template on a single line
+var lineEscaper = strings.NewReplacer("\r", `\r`, "\n", `\n`)
+
+// executeLine writes the line tmpl makes of evt into out, terminated by
+// eol. A newline at the end of the output is dropped, and the line breaks
+// within it are escaped as \r and \n. Nothing is written if the template
+// fails.
+func executeLine(out io.Writer, tmpl *template.Template, evt TemplateEvent, eol string) error {
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, evt); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, lineEscaper.Replace(strings.TrimSuffix(buf.String(), "\n"))+eol)
+	return err
+}
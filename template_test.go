@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeTemplateFile writes events with the template file path
+func encodeTemplateFile(t *testing.T, path string, events []Event, opts OutputOptions) string {
+	t.Helper()
+	tmpl, err := ParseTemplateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Format, opts.Template = "template", tmpl
+	var buf bytes.Buffer
+	if err := EncodeTemplate(&buf, events, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestEncodeTemplateReport(t *testing.T) {
+	v := 1.5
+	events := testEvents(seconds(2, 3, 1)...)
+	events[2].What, events[2].Value = "lap 2", &v
+	got := encodeTemplateFile(t, "examples/report.tmpl", events, OutputOptions{Name: "run-1", Comment: "warm up"})
+	expect := `warm up
+Session run-1 from 2022-04-08 20:00:00 to 20:00:06
+
+   0  enter                0s         0s
+   1  tick                 2s         2s
+   2  lap 2                5s         3s  value 1.5
+   3  exit                 6s         1s
+
+Total 6s, 2 ticks, 2 laps: min 2s, avg 2.5s, max 3s
+`
+	if got != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestEncodeTemplateHTML(t *testing.T) {
+	events := testEvents(1500 * time.Millisecond)
+	events[1].What = "<b>exit</b>"
+	got := encodeTemplateFile(t, "examples/snippet.html.tmpl", events, OutputOptions{Comment: "Q&A"})
+	expect := `<table class="stopwatch">
+  <caption>Q&amp;A</caption>
+  <thead>
+    <tr><th>#</th><th>Time</th><th>Event</th><th>Lap (s)</th></tr>
+  </thead>
+  <tbody>
+    <tr><td>0</td><td><time datetime="2022-04-08T20:00:00Z">20:00:00</time></td><td>enter</td><td>0</td></tr>
+    <tr><td>1</td><td><time datetime="2022-04-08T20:00:01Z">20:00:01</time></td><td>&lt;b&gt;exit&lt;/b&gt;</td><td>1.5</td></tr>
+  </tbody>
+</table>
+`
+	if got != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestTemplateFileFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.tmpl")
+	os.WriteFile(path, []byte("{{.Comment"), 0o644)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	f := addOutputFlags(fs, "format")
+	fs.Parse([]string{"-format", "template", "-template-file", path})
+	if _, err := f.options(); err == nil || !strings.Contains(err.Error(), "bad.tmpl:1") {
+		t.Errorf("Expected the parse error with its line, got %v", err)
+	}
+
+	for _, args := range [][]string{{"-format", "template"}, {"-template-file", "examples/report.tmpl"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addOutputFlags(fs, "format")
+		fs.Parse(args)
+		if _, err := f.options(); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
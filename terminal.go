@@ -0,0 +1,68 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/term"
+)
+
+// isTerminal reports whether f is connected to a terminal. Other character
+// devices, such as /dev/null, are not terminals.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// defaultWidth is the output width assumed when it can not be detected
+const defaultWidth = 80
+
+// terminalWidth returns the width of the terminal connected to f. Falls back
+// to $COLUMNS and then to defaultWidth when the width can not be queried.
+func terminalWidth(f *os.File) int {
+	if w := terminalSize(f); w > 0 {
+		return w
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return defaultWidth
+}
+
+// ANSI SGR parameters used with colorize
+const (
+	ansiBold  = "1"
+	ansiRed   = "31"
+	ansiGreen = "32"
+)
+
+// useColor reports whether messages written to f may be colorized: f must
+// be a terminal, and $NO_COLOR must not be set (https://no-color.org). The
+// messages on stderr follow uiCapabilities.Color.
+func useColor(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// colorize wraps s in the ANSI escape sequence for code if enabled
+func colorize(s, code string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// terminalSize is not supported on this platform; returns 0.
+func terminalSize(f *os.File) int {
+	return 0
+}
+
+// cbreak is not supported on this platform; the lines are read as typed
+// into the terminal.
+func cbreak(f *os.File) (func(), error) {
+	return nil, errors.New("line editing is not supported on this platform")
+}
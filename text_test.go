@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestNormalizeNFC(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", "plain"},
+		{"Cafe\u0301", "Caf\u00e9"},
+		{"Caf\u00e9", "Caf\u00e9"},
+		// the marks are put into canonical order first: dot below, then circumflex
+		{"e\u0302\u0323", "\u1ec7"},
+		{"A\u0308\u0304", "\u01de"},
+		// Hangul jamo
+		{"\u1112\u1161\u11ab", "\ud55c"},
+		// no composition exists: kept as is
+		{"x\u0301", "x\u0301"},
+		// decomposed first, then composed again
+		{"\u00e9\u0323", "\u1eb9\u0301"},
+		{"\u1e0b\u0323", "\u1e0d\u0307"},
+		// the singletons
+		{"\u212b", "\u00c5"},
+		{"\u2126", "\u03a9"},
+		{"\U0001f3c3\u200d\u2640\ufe0f", "\U0001f3c3\u200d\u2640\ufe0f"},
+	}
+	for _, tt := range tests {
+		if got := normalizeNFC(tt.in); got != tt.want {
+			t.Errorf("normalizeNFC(%+q): expected %+q, got %+q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	for n := 0; n <= len("aé🏃"); n++ {
+		got := truncateUTF8("aé🏃", n)
+		if !utf8.ValidString(got) || len(got) > n {
+			t.Errorf("truncateUTF8(%d): got %+q", n, got)
+		}
+	}
+	if got := truncateUTF8("aé🏃", 6); got != "aé" {
+		t.Errorf("Expected aé, got %+q", got)
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+	}{
+		{"lap", 3},
+		{"Café", 4},
+		{"計時", 4},
+		{"ｿ", 1},
+		{"🏃 run", 6},
+		{"�", 1},
+	}
+	for _, tt := range tests {
+		if got := displayWidth(tt.text); got != tt.width {
+			t.Errorf("displayWidth(%q): expected %d, got %d", tt.text, tt.width, got)
+		}
+	}
+	if !sort.SliceIsSorted(wideRanges, func(i, j int) bool { return wideRanges[i].hi < wideRanges[j].lo }) {
+		t.Error("wideRanges is not sorted")
+	}
+}
+
+func TestSessionUnicodeLabels(t *testing.T) {
+	sess := newSession("", collectOptions{Normalize: true, SanitizeLabels: true})
+	sess.start()
+	var out bytes.Buffer
+	for _, line := range []string{"🏃 run", "Café", "計時", "bad \xff\xfe byte"} {
+		sess.handleInput(sourceTCP, time.Time{}, func() { sess.handleLine(line, &out) })
+	}
+	sess.finish()
+	want := []string{labelEnter, "\U0001f3c3 run", "Caf\u00e9", "計時", "bad \ufffd byte", labelExit}
+
+	// and back from CSV
+	var buf bytes.Buffer
+	if err := MarshallEventsCSV(&buf, sess.Events, ""); err != nil {
+		t.Fatal(err)
+	}
+	events, _, err := UnmarshalEventsCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %q, got %v", want, events)
+	}
+	for i, evt := range events {
+		if evt.What != want[i] {
+			t.Errorf("Event %d: expected %+q, got %+q", i, want[i], evt.What)
+		}
+	}
+}
+
+func TestEncodeOrgWide(t *testing.T) {
+	events := testEvents(time.Second)
+	events[1].What = "計時"
+	var buf bytes.Buffer
+	if err := EncodeOrg(&buf, events, OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// the columns line up on a terminal
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if n := displayWidth(string(line)); n != displayWidth("| seq | ts                   | what  |") {
+			t.Errorf("Misaligned line %q (%d columns)", line, n)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timeclockLayout is the date and time of a timeclock entry, in the local
+// time of the event
+const timeclockLayout = "2006/01/02 15:04:05"
+
+// timeclockEntry is a check-in into an account and the check-out
+type timeclockEntry struct {
+	account    string
+	start, end time.Time
+	notes      []string // how the entry was changed, written as comments
+	dropped    bool     // only the notes are written
+}
+
+// timeclockEntries returns the time spent in events as timeclock entries,
+// in chronological order: one per lap, from the event before it to the
+// event closing it, with the label (or the "category" attribute) as the
+// account, split around pauses and idle gaps as by lapSpans; and one per
+// named timer, from its start to its stop. Since a timeclock can be checked
+// into one account at a time, an entry starting before the previous one
+// ended is cut to start at that end, or left out if it ends by then, with
+// a note saying so.
+func timeclockEntries(events []Event) []timeclockEntry {
+	var entries []timeclockEntry
+	for _, span := range lapSpans(events) {
+		account := events[span.closer].What
+		if category := events[span.closer].Attrs[attrCategory]; category != "" {
+			account = category
+		}
+		entries = append(entries, timeclockEntry{account: account, start: span.start, end: span.end})
+	}
+	timers := make(map[string]time.Time)
+	for _, evt := range events {
+		if name := strings.TrimPrefix(evt.What, labelStartPrefix); name != evt.What {
+			if _, running := timers[name]; !running {
+				timers[name] = evt.Timestamp
+			}
+		} else if name := strings.TrimPrefix(evt.What, labelStopPrefix); name != evt.What {
+			if t0, running := timers[name]; running {
+				entries = append(entries, timeclockEntry{account: name, start: t0, end: evt.Timestamp})
+				delete(timers, name)
+			}
+		}
+	}
+	// the timers never stopped run until the end
+	for name, t0 := range timers {
+		entries = append(entries, timeclockEntry{account: name, start: t0, end: events[len(events)-1].Timestamp,
+			notes: []string{name + " was never stopped"}})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].start.Equal(entries[j].start) {
+			return entries[i].start.Before(entries[j].start)
+		}
+		return entries[i].account < entries[j].account // the timers above, in map order
+	})
+
+	var kept []timeclockEntry
+	var busyUntil time.Time
+	for _, e := range entries {
+		if e.start.Before(busyUntil) {
+			if !e.end.After(busyUntil) {
+				e.notes = append(e.notes, fmt.Sprintf("%s from %s to %s was left out, during the previous entry",
+					e.account, e.start.Format(timeclockLayout), e.end.Format(timeclockLayout)))
+				e.dropped = true
+				kept = append(kept, e)
+				continue
+			}
+			e.notes = append(e.notes, fmt.Sprintf("%s started at %s, during the previous entry",
+				e.account, e.start.Format(timeclockLayout)))
+			e.start = busyUntil
+		}
+		busyUntil = e.end
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// timeclockAccount makes name safe as a timeclock account: two spaces
+// would end the account, so runs of white space become a single space
+func timeclockAccount(name string) string {
+	if name = strings.Join(strings.Fields(name), " "); name == "" {
+		return labelTick
+	}
+	return name
+}
+
+// EncodeTimeclock writes events into out in the timeclock format read by
+// hledger and ledger: a check-in ("i") and a check-out ("o") line per
+// entry, see timeclockEntries, with the comment as the description of
+// each check-in.
+func EncodeTimeclock(out io.Writer, events []Event, opts OutputOptions) error {
+	w := bufio.NewWriter(out)
+	if opts.Name != "" {
+		fmt.Fprintf(w, "; %s\n", orgKeyword(opts.Name))
+	}
+	desc := ""
+	if opts.Comment != "" {
+		desc = "  " + strings.Join(strings.Fields(opts.Comment), " ")
+	}
+	for _, e := range timeclockEntries(events) {
+		for _, note := range e.notes {
+			fmt.Fprintf(w, "; %s\n", orgKeyword(note))
+		}
+		if e.dropped {
+			continue
+		}
+		fmt.Fprintf(w, "i %s %s%s\n", e.start.Format(timeclockLayout), timeclockAccount(e.account), desc)
+		fmt.Fprintf(w, "o %s\n", e.end.Format(timeclockLayout))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// timeclockEvents are the events of testdata/session.timeclock: a lap over
+// a pause, a lap with a category, named timers within a lap, overlapping
+// a lap and never stopped, and a reset
+func timeclockEvents() []Event {
+	events := testEvents(10*time.Minute, 5*time.Minute, 20*time.Minute, 5*time.Minute, 5*time.Minute, 5*time.Minute, 30*time.Minute,
+		5*time.Minute, 10*time.Minute, 20*time.Minute, time.Minute, 2*time.Minute, 10*time.Minute, time.Minute)
+	for i, what := range []string{labelEnter, labelPause, labelResume, "write  docs", labelStartPrefix + "coffee", labelStopPrefix + "coffee", "review", labelStartPrefix + "call",
+		"planning", labelStopPrefix + "call", labelReset, labelStartPrefix + "build", labelMarkPrefix + "lunch", "fix", labelExit} {
+		events[i].What = what
+	}
+	events[6].Attrs = map[string]string{attrCategory: "work:review"}
+	return events
+}
+
+func TestEncodeTimeclock(t *testing.T) {
+	expect, err := os.ReadFile("testdata/session.timeclock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeTimeclock(&buf, timeclockEvents(), OutputOptions{Name: "golden", Comment: "sprint\n12"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(expect) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+
+	buf.Reset()
+	if err := EncodeTimeclock(&buf, testEvents(time.Second), OutputOptions{}); err != nil || buf.Len() != 0 {
+		t.Errorf("Expected nothing for a session without laps, got %q, %v", buf.String(), err)
+	}
+}
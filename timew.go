@@ -0,0 +1,65 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// timewLayout is the UTC date and time of timewarrior intervals
+const timewLayout = "20060102T150405Z"
+
+// EncodeTimew writes events into out as the JSON array of intervals read by
+// "timew import": one interval per span of each lap (see lapSpans), tagged
+// with the label of the lap, and its "category" attribute when set. The
+// comment is the annotation of every interval. Timewarrior keeps whole
+// seconds, so intervals shorter than that would be empty; they are left out
+// and counted into opts.Messages.
+func EncodeTimew(out io.Writer, events []Event, opts OutputOptions) error {
+	w := bufio.NewWriter(out)
+	w.WriteString("[")
+	n, dropped := 0, 0
+	for _, span := range lapSpans(events) {
+		start, end := span.start.UTC().Format(timewLayout), span.end.UTC().Format(timewLayout)
+		if start == end {
+			dropped++
+			continue
+		}
+		evt := events[span.closer]
+		tags := []string{jsonString(evt.What)}
+		if category := evt.Attrs[attrCategory]; category != "" && category != evt.What {
+			tags = append(tags, jsonString(category))
+		}
+		if n++; n > 1 {
+			w.WriteString(",")
+		}
+		fmt.Fprintf(w, "\n{\"start\":%q,\"end\":%q,\"tags\":[%s]", start, end, strings.Join(tags, ","))
+		if opts.Comment != "" {
+			w.WriteString(",\"annotation\":" + jsonString(opts.Comment))
+		}
+		w.WriteString("}")
+	}
+	if n > 0 {
+		w.WriteString("\n")
+	}
+	w.WriteString("]\n")
+	if dropped > 0 && opts.Messages != nil {
+		fmt.Fprintf(opts.Messages, "# Left out intervals shorter than a second: %d\n", dropped)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEncodeTimew(t *testing.T) {
+	// enter, a, pause, resume, b, c (half a second), exit
+	events := testEvents(10*time.Minute, 5*time.Minute, 5*time.Minute, 20*time.Minute, 500*time.Millisecond, time.Minute)
+	for i, what := range []string{"a", labelPause, labelResume, "b", "c"} {
+		events[i+1].What = what
+	}
+	events[4].Attrs = map[string]string{attrCategory: "work"}
+	var buf, msgs bytes.Buffer
+	if err := EncodeTimew(&buf, events, OutputOptions{Comment: `say "hi"`, Messages: &msgs}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `[
+{"start":"20220408T200000Z","end":"20220408T201000Z","tags":["a"],"annotation":"say \"hi\""},
+{"start":"20220408T201000Z","end":"20220408T201500Z","tags":["b","work"],"annotation":"say \"hi\""},
+{"start":"20220408T202000Z","end":"20220408T204000Z","tags":["b","work"],"annotation":"say \"hi\""}
+]
+`
+	if got := buf.String(); got != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, got)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Error("Expected valid JSON")
+	}
+	if got := msgs.String(); got != "# Left out intervals shorter than a second: 1\n" {
+		t.Errorf("Expected the short lap to be reported, got %q", got)
+	}
+
+	buf.Reset()
+	if err := EncodeTimew(&buf, testEvents(time.Second), OutputOptions{}); err != nil || buf.String() != "[]\n" {
+		t.Errorf("Expected an empty array without laps, got %q, %v", buf.String(), err)
+	}
+}
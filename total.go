@@ -0,0 +1,227 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// totalFormats are the output formats of the total command
+var totalFormats = []string{"text", "json"}
+
+// The groups of total -group-by: the comment of each file, or the value of
+// an attribute given after groupByMeta
+const (
+	groupByComment = "comment"
+	groupByMeta    = "meta:"
+)
+
+func runTotal(args []string) int {
+	fs := newFlagSet("total", "<file.csv>...")
+	groupBy := fs.String("group-by", "", "Also total the files per '"+groupByComment+"', or per the value of an attribute\n"+
+		"given as "+groupByMeta+"<name>, taken from the first event of each file having it")
+	ignoreErrors := fs.Bool("ignore-errors", false, "Exit with 0 even if some of the files could not be read; they are still reported")
+	format := fs.String("format", "text", "Output format: 'text' for a line per file and the total, or 'json'")
+	completeValues(fs, "format", func() []string { return totalFormats })
+	completeValues(fs, "group-by", func() []string { return []string{groupByComment, groupByMeta} })
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown -format %q (available: %s)\n", *format, strings.Join(totalFormats, ", "))
+		return 2
+	}
+	if err := validateGroupBy(*groupBy); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: -group-by:", err)
+		return 2
+	}
+	files, err := expandPatterns(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	t := computeTotals(files, *groupBy)
+	for _, f := range t.Files {
+		if f.Error != "" {
+			fmt.Fprintf(os.Stderr, "ERROR: %s: %s; skipped\n", f.File, f.Error)
+		}
+	}
+	if *format == "json" {
+		out, _ := json.MarshalIndent(t, "", "  ")
+		fmt.Printf("%s\n", out)
+	} else if err := t.write(os.Stdout, *groupBy); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	if t.Failed > 0 && !*ignoreErrors {
+		return 1
+	}
+	return 0
+}
+
+// validateGroupBy checks a value of total -group-by; "" groups nothing
+func validateGroupBy(groupBy string) error {
+	if groupBy == "" || groupBy == groupByComment {
+		return nil
+	}
+	if !strings.HasPrefix(groupBy, groupByMeta) {
+		return fmt.Errorf("%q is neither %q nor %s<name>", groupBy, groupByComment, groupByMeta)
+	}
+	return validateAttrKey(groupBy[len(groupByMeta):])
+}
+
+// fileTotal is the active time of one file read by total, see
+// computeTotals; a file that could not be read has the Error
+type fileTotal struct {
+	File    string  `json:"file"`
+	Comment string  `json:"comment,omitempty"`
+	Group   string  `json:"group,omitempty"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+
+	active time.Duration
+}
+
+// groupTotal is the active time of the files of a group of -group-by
+type groupTotal struct {
+	Group   string  `json:"group"`
+	Files   int     `json:"files"`
+	Seconds float64 `json:"seconds"`
+
+	active time.Duration
+}
+
+// totals is the result of the total command
+type totals struct {
+	Files   []fileTotal  `json:"files"`
+	Groups  []groupTotal `json:"groups,omitempty"`
+	Seconds float64      `json:"seconds"`
+	Failed  int          `json:"failed"` // files that could not be read
+
+	active time.Duration
+}
+
+// computeTotals reads the recorded files and sums their active time, that
+// is, the time from the first event to the last without the pauses, see
+// ComputeStats. The files that can not be read are listed with the error
+// and left out of the sums. With groupBy, see validateGroupBy, the files are
+// also summed per group, the longest first.
+func computeTotals(files []string, groupBy string) totals {
+	t := totals{Files: []fileTotal{}}
+	index := make(map[string]int)
+	for _, path := range files {
+		f := fileTotal{File: path}
+		events, comment, err := LoadCSV(path)
+		if err != nil {
+			f.Error = err.Error()
+			t.Files = append(t.Files, f)
+			t.Failed++
+			continue
+		}
+		f.Comment = comment
+		f.active = ComputeStats(events).Active
+		f.Seconds = f.active.Seconds()
+		t.active += f.active
+		if groupBy != "" {
+			f.Group = fileGroup(events, comment, groupBy)
+			i, ok := index[f.Group]
+			if !ok {
+				i = len(t.Groups)
+				index[f.Group] = i
+				t.Groups = append(t.Groups, groupTotal{Group: f.Group})
+			}
+			t.Groups[i].Files++
+			t.Groups[i].active += f.active
+			t.Groups[i].Seconds = t.Groups[i].active.Seconds()
+		}
+		t.Files = append(t.Files, f)
+	}
+	sort.SliceStable(t.Groups, func(i, j int) bool { return t.Groups[i].active > t.Groups[j].active })
+	t.Seconds = t.active.Seconds()
+	return t
+}
+
+// fileGroup returns the group of -group-by of a file of the events and the
+// comment: the comment, or the first value of the attribute
+func fileGroup(events []Event, comment, groupBy string) string {
+	if groupBy == groupByComment {
+		return comment
+	}
+	key := groupBy[len(groupByMeta):]
+	for _, evt := range events {
+		if value, ok := evt.Attrs[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// write writes t into out as a table of the files, that of the groups of
+// groupBy, if any, and the grand total. The files that could not be read
+// are left out; see the Error.
+func (t totals) write(out io.Writer, groupBy string) error {
+	// the values of the comments and attributes are written as labels
+	value := func(s string) string {
+		if s = sanitizeLabel(s); s == "" {
+			return "-"
+		}
+		return s
+	}
+	rows := [][]string{{"file", "active"}}
+	if groupBy != "" {
+		rows[0] = []string{"file", groupBy, "active"}
+	}
+	for _, f := range t.Files {
+		if f.Error != "" {
+			continue
+		}
+		row := []string{sanitizeLabel(f.File)}
+		if groupBy != "" {
+			row = append(row, value(f.Group))
+		}
+		rows = append(rows, append(row, formatDuration(f.active)))
+	}
+	if err := writeTable(out, rows, len(rows[0])-1); err != nil {
+		return err
+	}
+	if groupBy != "" {
+		if _, err := fmt.Fprintf(out, "By %s:\n", groupBy); err != nil {
+			return err
+		}
+		rows = [][]string{{groupBy, "files", "active"}}
+		for _, g := range t.Groups {
+			rows = append(rows, []string{value(g.Group), fmt.Sprint(g.Files), formatDuration(g.active)})
+		}
+		if err := writeTable(out, rows, 1); err != nil {
+			return err
+		}
+	}
+	line := fmt.Sprintf("Total: %s, files: %d", formatDuration(t.active), len(t.Files)-t.Failed)
+	if t.Failed > 0 {
+		line += fmt.Sprintf(", failed: %d", t.Failed)
+	}
+	_, err := fmt.Fprintln(out, line)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputeTotals(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.csv")
+	events := testEvents(time.Minute, time.Hour)
+	events[1].Attrs = map[string]string{"project": "acme"}
+	if err := DumpEvents(a, events, OutputOptions{Comment: "week 1"}); err != nil {
+		t.Fatal(err)
+	}
+	b := filepath.Join(dir, "b.csv")
+	events = testEvents(10*time.Minute, 20*time.Minute, 5*time.Minute)
+	events[1].What, events[2].What = labelPause, labelResume
+	if err := DumpEvents(b, events, OutputOptions{Comment: "week 1"}); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.csv")
+	if err := os.WriteFile(bad, []byte("seq,ts,what\n0,yesterday,enter\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tot := computeTotals([]string{a, bad, b}, "")
+	if tot.active != time.Hour+16*time.Minute || tot.Seconds != 4560 || tot.Failed != 1 || len(tot.Files) != 3 {
+		t.Errorf("Unexpected totals: %+v", tot)
+	}
+	// the pause of the second file is left out
+	if f := tot.Files[2]; f.active != 16*time.Minute-time.Minute || f.Comment != "week 1" {
+		t.Errorf("Unexpected total of %s: %+v", b, f)
+	}
+	if tot.Files[1].Error == "" {
+		t.Errorf("Expected an error for %s, got %+v", bad, tot.Files[1])
+	}
+
+	for groupBy, want := range map[string][]groupTotal{
+		"comment":      {{Group: "week 1", Files: 2, Seconds: 4560}},
+		"meta:project": {{Group: "acme", Files: 1, Seconds: 3660}, {Group: "", Files: 1, Seconds: 900}},
+	} {
+		tot := computeTotals([]string{b, a}, groupBy)
+		if len(tot.Groups) != len(want) {
+			t.Fatalf("%s: expected %v, got %+v", groupBy, want, tot.Groups)
+		}
+		for i, g := range tot.Groups {
+			if g.Group != want[i].Group || g.Files != want[i].Files || g.Seconds != want[i].Seconds {
+				t.Errorf("%s: expected %v, got %+v", groupBy, want, tot.Groups)
+			}
+		}
+	}
+}
+
+func TestTotalsWrite(t *testing.T) {
+	tot := totals{
+		Files: []fileTotal{
+			{File: "a.csv", Group: "acme", active: time.Hour},
+			{File: "bad.csv", Error: "no"},
+			{File: "long-name.csv", active: 90 * time.Second},
+		},
+		Groups: []groupTotal{{Group: "acme", Files: 1, active: time.Hour}, {Files: 1, active: 90 * time.Second}},
+		Failed: 1,
+		active: time.Hour + 90*time.Second,
+	}
+	var buf bytes.Buffer
+	if err := tot.write(&buf, "meta:project"); err != nil {
+		t.Fatal(err)
+	}
+	want := "  file           meta:project  active\n" +
+		"  a.csv          acme          1h0m0s\n" +
+		"  long-name.csv  -              1m30s\n" +
+		"By meta:project:\n" +
+		"  meta:project  files  active\n" +
+		"  acme              1  1h0m0s\n" +
+		"  -                 1   1m30s\n" +
+		"Total: 1h1m30s, files: 2, failed: 1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestValidateGroupBy(t *testing.T) {
+	for _, value := range []string{"", "comment", "meta:project"} {
+		if err := validateGroupBy(value); err != nil {
+			t.Errorf("%q: unexpected error %v", value, err)
+		}
+	}
+	for _, value := range []string{"what", "meta:", "meta:a b"} {
+		if err := validateGroupBy(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// udpMaxPayload is the number of bytes of a datagram used as the label.
+// The rest is ignored, as is anything after the first line.
+const udpMaxPayload = 256
+
+// datagramListener records an event for each UDP datagram received, with
+// the payload as the label. Nothing is replied.
+type datagramListener struct {
+	conn       net.PacketConn
+	withSender bool // record the address of the sender
+}
+
+// listenDatagrams starts receiving datagrams at the UDP address addr,
+// passing the labels on into in
+func listenDatagrams(in *remoteInput, addr string, withSender bool) (*datagramListener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &datagramListener{conn: conn, withSender: withSender}
+	in.start("-udp", l)
+	return l, nil
+}
+
+// Addr returns the address the listener receives datagrams at
+func (l *datagramListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// run receives the datagrams until ctx is done, and then closes the
+// socket. A datagram being passed on is still recorded.
+func (l *datagramListener) run(ctx context.Context, emit func(req remoteLine) string) error {
+	defer l.conn.Close()
+	go func() {
+		<-ctx.Done()
+		l.conn.Close()
+	}()
+	buf := make([]byte, 64*1024) // a larger datagram would be truncated anyway
+	for {
+		n, from, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		text, _, _ := strings.Cut(validUTF8(truncateUTF8(string(buf[:n]), udpMaxPayload)), "\n")
+		req := remoteLine{text: strings.TrimSpace(text), source: sourceUDP, at: time.Now(), async: true}
+		if l.withSender {
+			req.attrs = map[string]string{"sender": from.String()}
+		}
+		emit(req)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDatagramListener(t *testing.T) {
+	in := newRemoteInput()
+	requests := captureRemote(in)
+	l, err := listenDatagrams(in, "127.0.0.1:0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payloads := []string{" sensor-1\r\nignored", "", strings.Repeat("x", udpMaxPayload+10)}
+	want := []string{"sensor-1", "", strings.Repeat("x", udpMaxPayload)}
+	for i, payload := range payloads {
+		if _, err := conn.Write([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case req := <-requests:
+			if req.text != want[i] || req.attrs["sender"] != conn.LocalAddr().String() || req.source != sourceUDP {
+				t.Errorf("Expected %q from %s, got %+v", want[i], conn.LocalAddr(), req)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a datagram")
+		}
+	}
+	in.stop()
+	expectNoRemote(t, requests)
+}
+
+func TestHandleRemote(t *testing.T) {
+	steps := []time.Duration{0, 10 * time.Second, time.Second}
+	sess := newSession("", collectOptions{Debounce: 5 * time.Second})
+	sess.now = fakeClock(&steps)
+	sess.start()
+	if reply := sess.handleRemote(remoteLine{text: "a", attrs: map[string]string{"sender": "10.0.0.1:9"}}, io.Discard); reply != "ok 1" {
+		t.Errorf("Expected ok 1, got %q", reply)
+	}
+	if evt := sess.Events[1]; evt.What != "a" || evt.Attrs["sender"] != "10.0.0.1:9" {
+		t.Errorf("Expected a from 10.0.0.1:9, got %+v", evt)
+	}
+	if reply := sess.handleRemote(remoteLine{text: "a"}, io.Discard); reply != "error (debounced)" {
+		t.Errorf("Expected the duplicate to be debounced, got %q", reply)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "os"
+
+// uiCapabilities is how the interface behaves, by whether stdin and stderr
+// are terminals. It is detected once at startup, see detectUI, and gives
+// the defaults of the flags overriding each part.
+type uiCapabilities struct {
+	StdinTTY  bool // someone is typing the ticks
+	StderrTTY bool // someone is reading the messages
+
+	Prompts   bool // the "# Waiting for" prompts, when both are terminals (-prompts)
+	Editor    bool // the lines typed with history and completion, see lineEditor (-line-editor)
+	Color     bool // ANSI colors on stderr, unless $NO_COLOR is set (-color)
+	Bell      bool // the terminal bell at phase changes and at GO (-bell)
+	LiveTimer bool // the -until progress bar drawn in place of the prompt (-until-bar)
+	Summary   bool // the summary at exit (-summary)
+	Width     int  // of stderr, for the progress bar and the sparkline, see terminalWidth
+}
+
+// detectUI returns the capabilities of the interface on stdin and stderr,
+// isTTY telling which of them are terminals. When stdin is not a terminal,
+// it is a pipe, see pipeMode; when stderr is not, it gets no prompts,
+// colors, bells or redrawn lines, only the messages, as in a log.
+func detectUI(stdin, stderr *os.File, isTTY func(*os.File) bool) uiCapabilities {
+	ui := uiCapabilities{StdinTTY: isTTY(stdin), StderrTTY: isTTY(stderr), Width: terminalWidth(stderr)}
+	if !ui.StderrTTY {
+		return ui
+	}
+	_, noColor := os.LookupEnv("NO_COLOR")
+	ui.Prompts, ui.Editor = ui.StdinTTY, ui.StdinTTY
+	ui.Color = !noColor
+	ui.Bell, ui.LiveTimer, ui.Summary = true, true, true
+	return ui
+}
+
+// pipeMode reports whether the ticks are read from a pipe or a file rather
+// than typed: their labels are then sanitized, and they may be sampled
+func (ui uiCapabilities) pipeMode() bool {
+	return !ui.StdinTTY
+}
+
+// bell returns the terminal bell, if enabled
+func bell(enabled bool) string {
+	if enabled {
+		return "\a"
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDetectUI(t *testing.T) {
+	stdin, stderr := os.NewFile(0, "stdin"), os.NewFile(2, "stderr")
+	t.Setenv("COLUMNS", "100")
+	for _, test := range []struct {
+		stdinTTY, stderrTTY bool
+		want                uiCapabilities
+	}{
+		{true, true, uiCapabilities{StdinTTY: true, StderrTTY: true, Prompts: true, Editor: true, Color: true,
+			Bell: true, LiveTimer: true, Summary: true, Width: 100}},
+		// ticks piped in, watched on the terminal
+		{false, true, uiCapabilities{StderrTTY: true, Color: true, Bell: true, LiveTimer: true, Summary: true, Width: 100}},
+		// typed, with the messages going into a log
+		{true, false, uiCapabilities{StdinTTY: true, Width: 100}},
+		{false, false, uiCapabilities{Width: 100}},
+	} {
+		isTTY := func(f *os.File) bool {
+			if f == stdin {
+				return test.stdinTTY
+			}
+			return test.stderrTTY
+		}
+		ui := detectUI(stdin, stderr, isTTY)
+		if ui != test.want {
+			t.Errorf("stdin %v, stderr %v: expected %+v, got %+v", test.stdinTTY, test.stderrTTY, test.want, ui)
+		}
+		if ui.pipeMode() == test.stdinTTY {
+			t.Errorf("stdin %v: expected pipe mode %v", test.stdinTTY, !test.stdinTTY)
+		}
+	}
+
+	t.Setenv("NO_COLOR", "")
+	if ui := detectUI(stdin, stderr, func(*os.File) bool { return true }); ui.Color || !ui.Prompts {
+		t.Errorf("Expected no colors with $NO_COLOR, got %+v", ui)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// crockfordAlphabet is the Crockford base32 alphabet used by ULIDs
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator produces ULIDs (https://github.com/ulid/spec): 48 bits of
+// millisecond timestamp followed by 80 bits of entropy, encoded as 26
+// characters of Crockford base32. IDs generated within the same millisecond
+// are made monotonic by incrementing the entropy of the previous ID, so they
+// are unique and sort in generation order. Safe for concurrent use.
+type ULIDGenerator struct {
+	mu      sync.Mutex
+	entropy io.Reader
+	lastMS  uint64
+	last    [10]byte // entropy of the previous ID
+}
+
+// NewULIDGenerator creates a generator reading entropy from the given reader
+func NewULIDGenerator(entropy io.Reader) *ULIDGenerator {
+	return &ULIDGenerator{entropy: entropy}
+}
+
+// errULIDOverflow is returned if more IDs are generated within one
+// millisecond than the entropy can accommodate
+var errULIDOverflow = errors.New("ulid: entropy overflow within millisecond")
+
+// New generates a ULID for the moment t
+func (g *ULIDGenerator) New(t time.Time) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(t.UnixMilli())
+	if ms <= g.lastMS && g.lastMS != 0 {
+		// same (or earlier, if the clock stepped back) millisecond: keep
+		// the previous timestamp and increment the entropy
+		ms = g.lastMS
+		i := len(g.last) - 1
+		for ; i >= 0; i-- {
+			g.last[i]++
+			if g.last[i] != 0 {
+				break
+			}
+		}
+		if i < 0 {
+			return "", errULIDOverflow
+		}
+	} else {
+		if _, err := io.ReadFull(g.entropy, g.last[:]); err != nil {
+			return "", err
+		}
+		g.lastMS = ms
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	copy(id[6:], g.last[:])
+	return encodeULID(id), nil
+}
+
+// encodeULID encodes 128 bits as 26 characters of Crockford base32
+func encodeULID(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	var out [26]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// defaultULIDs is the generator used by NewULID
+var defaultULIDs = NewULIDGenerator(rand.Reader)
+
+// NewULID generates a ULID for the moment t using crypto/rand entropy.
+// IDs are unique within the process, even for identical timestamps.
+func NewULID(t time.Time) (string, error) {
+	return defaultULIDs.New(t)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestULIDEncoding(t *testing.T) {
+	// all zero entropy; timestamp from the ULID specification examples
+	g := NewULIDGenerator(bytes.NewReader(make([]byte, 10)))
+	id, err := g.New(time.UnixMilli(1469918176385))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "01ARYZ6S410000000000000000"; id != expect {
+		t.Fatalf("Expected %q, got %q", expect, id)
+	}
+
+	g = NewULIDGenerator(bytes.NewReader(bytes.Repeat([]byte{0xff}, 10)))
+	id, err = g.New(time.UnixMilli(1<<48 - 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"; id != expect {
+		t.Fatalf("Expected %q, got %q", expect, id)
+	}
+}
+
+func TestULIDMonotonic(t *testing.T) {
+	g := NewULIDGenerator(bytes.NewReader(append(make([]byte, 9), 0xfe)))
+	ts := time.UnixMilli(1469918176385)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := g.New(ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	expect := []string{
+		"01ARYZ6S41000000000000007Y",
+		"01ARYZ6S41000000000000007Z",
+		"01ARYZ6S410000000000000080",
+	}
+	if strings.Join(ids, " ") != strings.Join(expect, " ") {
+		t.Fatalf("Expected %v, got %v", expect, ids)
+	}
+}
+
+func TestULIDOverflow(t *testing.T) {
+	g := NewULIDGenerator(bytes.NewReader(bytes.Repeat([]byte{0xff}, 10)))
+	ts := time.UnixMilli(1469918176385)
+	if _, err := g.New(ts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.New(ts); err != errULIDOverflow {
+		t.Fatalf("Expected overflow error, got %v", err)
+	}
+}
+
+func TestNewULIDUnique(t *testing.T) {
+	ts := time.Now()
+	seen := make(map[string]bool)
+	var ids []string
+	for i := 0; i < 1000; i++ {
+		id, err := NewULID(ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 26 {
+			t.Fatalf("Expected 26 characters, got %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("Duplicate ID %q", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Fatal("IDs generated for the same timestamp are not in order")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// untilBarMax is the widest -until progress bar drawn, in cells
+const untilBarMax = 40
+
+// renderProgressBar returns a bar showing how much of total has elapsed,
+// e.g. "[#####.....]  50%", at most width cells wide. The bar is left out
+// when width is too narrow for it, and the percentage too when width is
+// too narrow even for that. The percentage is rounded down, so that 100%
+// is shown only once the time is up.
+func renderProgressBar(elapsed, total time.Duration, width int) string {
+	percent := 100
+	if total > 0 && elapsed < total {
+		percent = 0
+		if elapsed > 0 {
+			percent = int(elapsed * 100 / total)
+		}
+	}
+	label := fmt.Sprintf("%3d%%", percent)
+	cells := width - len(label) - 3 // the brackets and a space
+	if cells < 1 {
+		if width < len(label) {
+			return ""
+		}
+		return label
+	}
+	filled := cells * percent / 100
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", cells-filled) + "] " + label
+}
+
+// untilElapsed returns the time since the start of the session, and the time
+// from the start until -until. The elapsed time stays put while paused.
+func (s *Session) untilElapsed() (time.Duration, time.Duration, bool) {
+	if s.opts.Until.IsZero() || s.armed || s.startAt.IsZero() {
+		return 0, 0, false
+	}
+	now := s.now()
+	if s.paused {
+		now = s.pausedAt
+	}
+	// Until has no monotonic clock reading, so these compare wall clocks
+	return now.Round(0).Sub(s.startAt.Round(0)), s.opts.Until.Sub(s.startAt.Round(0)), true
+}
+
+// untilBar returns the -until progress bar to show before the prompt, at
+// most width cells wide, or "" if there is none
+func (s *Session) untilBar(width int) string {
+	elapsed, total, ok := s.untilElapsed()
+	if !ok {
+		return ""
+	}
+	if width > untilBarMax {
+		width = untilBarMax
+	}
+	return renderProgressBar(elapsed, total, width)
+}
+
+// untilLines writes a line into out whenever another tenth of the time
+// until -until has elapsed, for when stderr is not a terminal that the bar
+// could be drawn on. Reports whether a line was written.
+func (s *Session) untilLines(out io.Writer) bool {
+	elapsed, total, ok := s.untilElapsed()
+	if !ok || total <= 0 {
+		return false
+	}
+	tenths := int(elapsed * 10 / total)
+	if tenths > 10 {
+		tenths = 10
+	}
+	if tenths > s.untilTenths {
+		s.untilTenths = tenths
+		left := total - elapsed
+		if left < 0 {
+			left = 0
+		}
+		fmt.Fprintf(out, "\n# %d%% of the time until %s elapsed, %s left\n", tenths*10,
+			s.opts.Until.Format("15:04:05"), formatDuration(left.Round(time.Second)))
+		return true
+	}
+	return false
+}
+
+// drawPrompt writes the prompt into out if fresh is set, i.e. when the
+// cursor is at the start of a new line. With the -until progress bar on a
+// terminal, the bar and the prompt are redrawn in place every time instead.
+func (s *Session) drawPrompt(out io.Writer, fresh bool) {
+	if s.opts.NoPrompt {
+		return
+	}
+	prompt := s.prompt()
+	typed := ""
+	if s.opts.Typed != nil {
+		typed = s.opts.Typed()
+	}
+	if s.opts.UntilBar && s.opts.UntilBarWidth > 0 {
+		if bar := s.untilBar(s.opts.UntilBarWidth - displayWidth(prompt) - 1); bar != "" {
+			fmt.Fprint(out, "\r\x1b[K"+bar+" "+prompt+typed)
+			return
+		}
+	}
+	if s.opts.UntilBar && s.untilLines(out) {
+		fresh = true
+	}
+	if fresh {
+		fmt.Fprint(out, prompt+typed)
+	}
+}
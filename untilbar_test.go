@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRenderProgressBar(t *testing.T) {
+	for _, test := range []struct {
+		elapsed, total time.Duration
+		width          int
+		want           string
+	}{
+		{0, time.Minute, 17, "[..........]   0%"},
+		{30 * time.Second, time.Minute, 17, "[#####.....]  50%"},
+		{59 * time.Second, time.Minute, 17, "[#########.]  98%"},
+		{time.Minute, time.Minute, 17, "[##########] 100%"},
+		{2 * time.Minute, time.Minute, 17, "[##########] 100%"},
+		{-time.Second, time.Minute, 17, "[..........]   0%"},
+		{time.Second, 0, 17, "[##########] 100%"},
+		{30 * time.Second, time.Minute, 8, "[.]  50%"},
+		{30 * time.Second, time.Minute, 9, "[#.]  50%"},
+		{30 * time.Second, time.Minute, 7, " 50%"},
+		{30 * time.Second, time.Minute, 4, " 50%"},
+		{30 * time.Second, time.Minute, 3, ""},
+		{30 * time.Second, time.Minute, 0, ""},
+		{30 * time.Second, time.Minute, -5, ""},
+	} {
+		got := renderProgressBar(test.elapsed, test.total, test.width)
+		if got != test.want {
+			t.Errorf("%v of %v in %d: expected %q, got %q", test.elapsed, test.total, test.width, test.want, got)
+		}
+		if len(got) > test.width && got != "" {
+			t.Errorf("%v of %v in %d: %q is too wide", test.elapsed, test.total, test.width, got)
+		}
+	}
+}
+
+func TestSessionUntilBar(t *testing.T) {
+	start := time.Date(2022, 4, 8, 20, 0, 0, 0, time.UTC)
+	steps := []time.Duration{0, 30 * time.Second, 0, 20 * time.Second}
+	sess := newSession("", collectOptions{Until: start.Add(100 * time.Second), UntilBar: true, UntilBarWidth: 50})
+	sess.now = fakeClock(&steps)
+	var out bytes.Buffer
+	sess.start()
+	sess.drawPrompt(&out, false) // at 30s
+	want := "\r\x1b[K[######.................]  30% # Waiting for [1]> "
+	if out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+	sess.handleLine("pause", &out)
+	out.Reset()
+	sess.drawPrompt(&out, true) // at 50s, paused
+	if want := "\r\x1b[K[####..........]  30% # [PAUSED] Waiting for [2]> "; out.String() != want {
+		t.Errorf("Expected the bar to stay put while paused, got %q", out.String())
+	}
+
+	// percentage lines, once per tenth
+	steps = []time.Duration{0, 25 * time.Second, time.Second, 80 * time.Second}
+	sess = newSession("", collectOptions{Until: start.Add(100 * time.Second), UntilBar: true})
+	sess.now = fakeClock(&steps)
+	out.Reset()
+	sess.start()
+	sess.drawPrompt(&out, true)  // at 25s
+	sess.drawPrompt(&out, false) // at 26s
+	sess.drawPrompt(&out, false) // at 106s
+	want = "\n# 20% of the time until 20:01:40 elapsed, 1m15s left\n# Waiting for [1]> " +
+		"\n# 100% of the time until 20:01:40 elapsed, 0s left\n# Waiting for [1]> "
+	if out.String() != want {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, out.String())
+	}
+
+	// nothing without -until, or with -q
+	for _, opts := range []collectOptions{{UntilBar: true}, {Until: start.Add(time.Minute), UntilBar: true, NoPrompt: true}} {
+		sess = newSession("", opts)
+		out.Reset()
+		sess.start()
+		sess.drawPrompt(&out, false)
+		if out.Len() != 0 {
+			t.Errorf("%+v: expected no output, got %q", opts, out.String())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The defaults of -upload-timeout and -upload-retries
+const (
+	defaultUploadTimeout = 30 * time.Second
+	defaultUploadRetries = 2
+)
+
+// uploadBackoff is the wait before the first retry of an upload, doubled
+// for each one after it
+var uploadBackoff = time.Second
+
+// The methods of -upload-method
+var uploadMethods = []string{http.MethodPut, http.MethodPost}
+
+// uploadOptions configures how the output is sent to an -o URL, see
+// uploadEvents
+type uploadOptions struct {
+	Method  string        // PUT (or "") or POST
+	Header  http.Header   // added to each request, replacing the defaults
+	Timeout time.Duration // of each attempt; 0 means defaultUploadTimeout
+	Retries int           // attempts after the first one failed
+}
+
+// method returns the HTTP method of the upload
+func (up uploadOptions) method() string {
+	if up.Method == "" {
+		return http.MethodPut
+	}
+	return up.Method
+}
+
+// urlScheme matches an output path given as a URL
+var urlScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// isUpload reports whether outFile is an http:// or https:// URL the
+// output is uploaded to instead of written into a file
+func isUpload(outFile string) bool {
+	lower := strings.ToLower(outFile)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// checkOutputURL returns an error if outFile is a URL that can not be
+// uploaded to. Only http:// and https:// are: an object store such as S3 is
+// uploaded to with a presigned https:// URL of the object.
+func checkOutputURL(outFile string) error {
+	if !urlScheme.MatchString(outFile) || isUpload(outFile) {
+		return nil
+	}
+	scheme, _, _ := strings.Cut(outFile, "://")
+	if strings.EqualFold(scheme, "s3") {
+		return fmt.Errorf("%s:// is not supported, give a presigned https:// URL of the object, see README", scheme)
+	}
+	return fmt.Errorf("%s:// is not supported, only http:// and https:// URLs are uploaded to", scheme)
+}
+
+// uploadName returns the file name in the path of the URL outFile, without
+// the query, e.g. for the suffixes of compression; "" if there is none
+func uploadName(outFile string) string {
+	u, err := url.Parse(outFile)
+	if err != nil || strings.HasSuffix(u.Path, "/") {
+		return ""
+	}
+	if name := path.Base(u.Path); name != "." && name != "/" {
+		return name
+	}
+	return ""
+}
+
+// parseUploadHeader parses a header of -upload-header, given as
+// "Name: value"
+func parseUploadHeader(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid header %q, expected Name: value", s)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// uploadContentType returns the Content-Type of the output opts writes:
+// that of the format, unless the output is compressed or encrypted
+func uploadContentType(opts OutputOptions) string {
+	switch {
+	case opts.Passphrase != nil:
+		return "application/octet-stream"
+	case opts.Compress != "":
+		return compressions[opts.Compress].media
+	}
+	name := opts.Format
+	if name == "" {
+		name = "csv"
+	}
+	if f, ok := formats[name]; ok {
+		return f.mediaType
+	}
+	return "application/octet-stream"
+}
+
+// uploadEvents encodes events like writeEncoded and sends the output to
+// the http:// or https:// URL target with opts.Upload: a PUT by default,
+// retried after a network error or a 5xx or 429 status, waiting
+// uploadBackoff first and twice as long before each retry after it
+func uploadEvents(target string, encode Encoder, events []Event, opts OutputOptions) error {
+	var body bytes.Buffer
+	if err := writeEncoded(&body, encode, events, opts); err != nil {
+		return err
+	}
+	up := opts.Upload
+	up.Method = up.method()
+	if up.Timeout == 0 {
+		up.Timeout = defaultUploadTimeout
+	}
+	client := http.Client{Timeout: up.Timeout}
+	wait := uploadBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := uploadOnce(&client, up, target, uploadContentType(opts), body.Bytes())
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= up.Retries {
+			if attempt > 0 {
+				return fmt.Errorf("upload failed after %d attempts: %w", attempt+1, err)
+			}
+			return fmt.Errorf("upload failed: %w", err)
+		}
+		if opts.Messages != nil {
+			fmt.Fprintf(opts.Messages, "# WARNING: upload failed: %v; retrying in %s\n", err, wait)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// uploadOnce sends body to target once, and reports whether a failure is
+// worth retrying
+func uploadOnce(client *http.Client, up uploadOptions, target, contentType string, body []byte) (bool, error) {
+	req, err := http.NewRequest(up.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, values := range up.Header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
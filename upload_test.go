@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpEventsUpload(t *testing.T) {
+	events := testEvents(time.Second, 2*time.Second)
+	var method, contentType, auth string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, contentType, auth = r.Method, r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	for _, opts := range []OutputOptions{
+		{Comment: "run 1"},
+		{Format: "ndjson", Upload: uploadOptions{Method: http.MethodPost, Header: http.Header{"Authorization": {"Bearer abc"}}}},
+		{Format: "json", Upload: uploadOptions{Header: http.Header{"Content-Type": {"text/plain"}}}},
+	} {
+		if err := DumpEvents(srv.URL+"/runs/out?sig=x", events, opts); err != nil {
+			t.Fatal(err)
+		}
+		// the body is the output of the encoder
+		var want bytes.Buffer
+		encode, prepared, prepOpts, _ := prepareOutput(events, opts)
+		if err := writeEncoded(&want, encode, prepared, prepOpts); err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want.String() {
+			t.Errorf("%s: expected the body\n%s\ngot\n%s", opts.Format, want.String(), body)
+		}
+		if method != opts.Upload.method() {
+			t.Errorf("%s: expected %s, got %s", opts.Format, opts.Upload.method(), method)
+		}
+		wantType := uploadContentType(opts)
+		if h := opts.Upload.Header.Get("Content-Type"); h != "" {
+			wantType = h
+		}
+		if contentType != wantType || auth != opts.Upload.Header.Get("Authorization") {
+			t.Errorf("%s: unexpected headers %q, %q", opts.Format, contentType, auth)
+		}
+	}
+
+	// the suffix of the path compresses, like that of a file
+	opts, err := withCompression(OutputOptions{}, srv.URL+"/out.csv.gz?sig=x")
+	if err != nil || opts.Compress != "gzip" {
+		t.Fatalf("Expected gzip by the suffix, got %q, %v", opts.Compress, err)
+	}
+	if err := DumpEvents(srv.URL+"/out.csv.gz?sig=x", events, opts); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/gzip" {
+		t.Errorf("Expected the compressed content type, got %q", contentType)
+	}
+	if zr, err := gzip.NewReader(bytes.NewReader(body)); err != nil {
+		t.Error(err)
+	} else if data, _ := io.ReadAll(zr); !strings.HasPrefix(string(data), "# stopwatch-schema: 2\n") {
+		t.Errorf("Unexpected uploaded data %q", data)
+	}
+}
+
+func TestUploadRetries(t *testing.T) {
+	defer func(d time.Duration) { uploadBackoff = d }(uploadBackoff)
+	uploadBackoff = time.Millisecond
+	events := testEvents(time.Second)
+	attempts := 0
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(status)
+			io.WriteString(w, "busy")
+		}
+	}))
+	defer srv.Close()
+
+	var warn bytes.Buffer
+	opts := OutputOptions{Messages: &warn, Upload: uploadOptions{Retries: 2}}
+	if err := DumpEvents(srv.URL, events, opts); err != nil || attempts != 3 {
+		t.Errorf("Expected the third attempt to succeed, got %d, %v", attempts, err)
+	}
+	if strings.Count(warn.String(), "retrying") != 2 {
+		t.Errorf("Expected the retries to be reported, got %q", warn.String())
+	}
+
+	attempts = 0
+	opts.Upload.Retries = 1
+	err := DumpEvents(srv.URL, events, opts)
+	if err == nil || attempts != 2 || err.Error() != "upload failed after 2 attempts: 503 Service Unavailable: busy" {
+		t.Errorf("Expected the upload to fail after 2 attempts, got %d, %v", attempts, err)
+	}
+	// a client error is not retried
+	attempts, status = 0, http.StatusForbidden
+	if err := DumpEvents(srv.URL, events, opts); err == nil || attempts != 1 {
+		t.Errorf("Expected a single attempt, got %d, %v", attempts, err)
+	}
+
+	// the fallback is named by the path of the URL
+	path, err := writeFallback(srv.URL+"/runs/out.csv?sig=x", events, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	if !strings.HasSuffix(path, "-out.csv") {
+		t.Errorf("Expected the name of the URL, got %s", path)
+	}
+	if loaded, _, err := LoadCSV(path); err != nil || len(loaded) != len(events) {
+		t.Errorf("Expected the events in the fallback, got %v, %v", loaded, err)
+	}
+}
+
+func TestCheckOutputURL(t *testing.T) {
+	for outFile, want := range map[string]string{
+		"out.csv": "", "https://example.com/x": "", "HTTP://example.com/x": "", "-": "",
+		"s3://bucket/key":    "s3:// is not supported, give a presigned https:// URL of the object, see README",
+		"ftp://example.com/": "ftp:// is not supported, only http:// and https:// URLs are uploaded to",
+	} {
+		got := ""
+		if err := checkOutputURL(outFile); err != nil {
+			got = err.Error()
+		}
+		if got != want {
+			t.Errorf("%s: expected %q, got %q", outFile, want, got)
+		}
+	}
+	if err := DumpEvents("s3://bucket/key", testEvents(), OutputOptions{}); err == nil {
+		t.Error("Expected an error for s3://")
+	}
+	for _, s := range []string{"Authorization", ": x", "Bad Name: x"} {
+		if _, _, err := parseUploadHeader(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+	if name, value, err := parseUploadHeader("X-Run:  a: b "); err != nil || name != "X-Run" || value != "a: b" {
+		t.Errorf("Unexpected header %q: %q, %v", name, value, err)
+	}
+}
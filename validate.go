@@ -0,0 +1,186 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// validateFormats are the output formats of the validate command
+var validateFormats = []string{"text", "json"}
+
+func runValidate(args []string) int {
+	fs := newFlagSet("validate", "<file.csv>...")
+	format := fs.String("format", "text", "Output format: 'text' for a line per file, or 'json'")
+	completeValues(fs, "format", func() []string { return validateFormats })
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown -format %q (available: %s)\n", *format, strings.Join(validateFormats, ", "))
+		return 2
+	}
+	files, err := expandPatterns(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+	status := 0
+	var results []validation
+	for _, path := range files {
+		v := validateFile(path)
+		if !v.Pass {
+			status = 1
+		}
+		results = append(results, v)
+	}
+	if *format == "json" {
+		out, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Printf("%s\n", out)
+		return status
+	}
+	for _, v := range results {
+		fmt.Println(v)
+	}
+	return status
+}
+
+// validation is the result of validating a file
+type validation struct {
+	File     string    `json:"file"`
+	Pass     bool      `json:"pass"`
+	Findings []finding `json:"findings"`
+}
+
+// finding is a problem found by validation
+type finding struct {
+	Line    int    `json:"line,omitempty"` // 0 if not of a line
+	Message string `json:"message"`
+}
+
+func (v validation) String() string {
+	if v.Pass {
+		return v.File + ": PASS"
+	}
+	var findings []string
+	for _, f := range v.Findings {
+		findings = append(findings, csvProblem{line: f.Line, text: f.Message}.String())
+	}
+	return v.File + ": FAIL: " + strings.Join(findings, "; ")
+}
+
+// validateFile checks that the named file, "-" meaning stdin, is a
+// recorded session that can be read as is: see parseLenient for the
+// problems of reading it, and checkInvariants for those of the events. A
+// file with a manifest of -manifest must also match it, see checkManifest.
+// Deviations from the canonical format that the program itself may write,
+// such as CRLF line endings or a checksum, are not problems.
+func validateFile(path string) validation {
+	v := validation{File: path, Findings: []finding{}}
+	data, err := readInput(path)
+	if err == nil {
+		var l *lenientCSV
+		if l, err = parseLenient(data); err == nil {
+			for _, p := range l.problems {
+				if !p.format {
+					v.Findings = append(v.Findings, finding{p.line, p.text})
+				}
+			}
+			events, lines := l.events, l.lines
+			if descending(events) {
+				// written with -order desc, checked in the order recorded
+				events, lines = reversed(events), append([]int(nil), lines...)
+				for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+					lines[i], lines[j] = lines[j], lines[i]
+				}
+			}
+			v.Findings = append(v.Findings, checkInvariants(events, lines)...)
+			if path != "-" {
+				if m, ok, merr := loadManifest(path); merr != nil {
+					v.Findings = append(v.Findings, finding{Message: merr.Error()})
+				} else if ok {
+					v.Findings = append(v.Findings, checkManifest(path, m, l.events)...)
+				}
+			}
+			sort.SliceStable(v.Findings, func(i, j int) bool { return v.Findings[i].Line < v.Findings[j].Line })
+		}
+	}
+	if err != nil {
+		v.Findings = append(v.Findings, finding{Message: err.Error()})
+	}
+	v.Pass = len(v.Findings) == 0
+	return v
+}
+
+// checkInvariants checks the events read from the lines of a file, in the
+// order of the file: the timestamps must not decrease, i.e. no duration is
+// negative, seq must count up from zero, and the session must start with
+// "enter" and end with "exit". A file without either sentinel, such as
+// those of -split-by-label, holds a part of a session: its seq must only
+// increase.
+func checkInvariants(events []Event, lines []int) []finding {
+	var findings []finding
+	add := func(i int, format string, a ...interface{}) {
+		findings = append(findings, finding{lines[i], fmt.Sprintf(format, a...)})
+	}
+	if len(events) == 0 {
+		return []finding{{Message: "no events"}}
+	}
+	part := true
+	for _, evt := range events {
+		if isSentinel(evt.What) {
+			part = false
+		}
+	}
+	last := len(events) - 1
+	var start int64 // the seq of "enter", see -seq-start
+	if events[0].What == labelEnter && events[0].Seq > 0 {
+		start = events[0].Seq
+	}
+	for i, evt := range events {
+		if i > 0 {
+			prev := events[i-1]
+			if d := evt.Timestamp.Sub(prev.Timestamp); d < 0 {
+				add(i, "timestamp %s is before that of line %d, a negative duration of %v",
+					evt.Cell("ts"), lines[i-1], d)
+			}
+			if part && evt.Seq <= prev.Seq {
+				add(i, "seq %d does not follow %d", evt.Seq, prev.Seq)
+			}
+		}
+		if !part && evt.Seq != start+int64(i) && (i == 0 || evt.Seq != events[i-1].Seq+1) {
+			// reported once per jump, not for every line after it
+			add(i, "seq %d, expected %d", evt.Seq, start+int64(i))
+		}
+		switch {
+		case part:
+		case i == 0 && evt.What != labelEnter:
+			add(i, "the first event is %q, expected %q", evt.What, labelEnter)
+		case i == last && evt.What != labelExit:
+			add(i, "the last event is %q, expected %q", evt.What, labelExit)
+		case i != 0 && i != last && isSentinel(evt.What):
+			add(i, "%q in the middle of the session", evt.What)
+		}
+	}
+	return findings
+}
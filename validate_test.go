@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateFile(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.csv")
+	if err := DumpEvents(good, testEvents(time.Second, time.Second), OutputOptions{Checksum: true, Comment: "run 1"}); err != nil {
+		t.Fatal(err)
+	}
+	excel := filepath.Join(dir, "excel.csv")
+	if err := DumpEvents(excel, testEvents(time.Second, time.Second), ExcelPreset(OutputOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{good, excel} {
+		if v := validateFile(path); !v.Pass || len(v.Findings) != 0 {
+			t.Errorf("%s: expected PASS, got %v", path, v)
+		}
+	}
+
+	for data, want := range map[string][]finding{
+		// interrupted, out of order and renumbered by hand
+		"seq,ts,what\n0,2022-04-08T20:00:00Z,enter\n1,2022-04-08T20:00:05Z,a\n1,2022-04-08T20:00:03Z,b\n": {
+			{4, "timestamp 2022-04-08T20:00:03Z is before that of line 3, a negative duration of -2s"},
+			{4, "seq 1, expected 2"},
+			{4, `the last event is "b", expected "exit"`},
+		},
+		"seq,ts,what,value\n0,2022-04-08T20:00:00Z,enter\n1,2022-04-08T20:00:01Z,exit,x\n": {
+			{2, "missing fields (1)"},
+			{3, `invalid value "x"`},
+		},
+		"seq,TS,what\n1,2022-04-08T20:00:00Z,tick\n0,2022-04-08T20:00:01Z,enter\n3,2022-04-08T20:00:02Z,exit\n": {
+			{0, `column "TS", expected "ts"`},
+			{2, "seq 1, expected 0"},
+			{2, `the first event is "tick", expected "enter"`},
+			{3, "seq 0, expected 1"},
+			{3, `"enter" in the middle of the session`},
+			{4, "seq 3, expected 2"},
+		},
+		// numbered from -seq-start
+		"seq,ts,what\n8589934592,2022-04-08T20:00:00Z,enter\n8589934594,2022-04-08T20:00:01Z,exit\n": {
+			{3, "seq 8589934594, expected 8589934593"},
+		},
+		// a part of a session, without the sentinels
+		"seq,ts,what\n3,2022-04-08T20:00:00Z,a\n5,2022-04-08T20:00:01Z,b\n5,2022-04-08T20:00:02Z,c\n": {
+			{4, "seq 5 does not follow 5"},
+		},
+		"seq,ts,what\n": {{0, "no events"}},
+		"a,b\n":         {{0, `missing header, and the rows do not have the default columns ["seq" "ts" "what"]`}},
+	} {
+		path := filepath.Join(dir, "bad.csv")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		v := validateFile(path)
+		if v.Pass || !reflect.DeepEqual(v.Findings, want) {
+			t.Errorf("%q: expected FAIL with %v, got %v", data, want, v.Findings)
+		}
+	}
+	if v := validateFile(filepath.Join(dir, "missing.csv")); v.Pass || len(v.Findings) != 1 {
+		t.Errorf("Expected a missing file to fail, got %v", v)
+	}
+}
+
+func TestValidationString(t *testing.T) {
+	v := validation{File: "a.csv", Findings: []finding{{3, "seq 1, expected 2"}, {0, "no events"}}}
+	if got, want := v.String(), "a.csv: FAIL: line 3: seq 1, expected 2; no events"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := (validation{File: "a.csv", Pass: true}).String(); got != "a.csv: PASS" {
+		t.Errorf("Expected PASS, got %q", got)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+func runVerify(args []string) int {
+	fs := newFlagSet("verify", "<file.csv>...")
+	keyFile := fs.String("key-file", "", "Verify the HMAC-SHA256 signature using the key read from this file")
+	completeFiles(fs, "key-file")
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	var key []byte
+	if *keyFile != "" {
+		var err error
+		if key, err = LoadKeyFile(*keyFile, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			return 2
+		}
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		if err := verifyFile(path, key); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: FAILED: %v\n", path, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: OK\n", path)
+	}
+	return status
+}
+
+// verifyFile checks the integrity lines at the end of the named file.
+// The name "-" is interpreted as stdin. If key is non-nil, the file must be
+// signed with it, and the checksum is verified only if present. Otherwise
+// the checksum is required, and any signature line is ignored.
+func verifyFile(path string, key []byte) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	return verifyData(data, key)
+}
+
+func verifyData(data, key []byte) error {
+	if key != nil {
+		body, err := VerifySignature(data, key)
+		if err != nil {
+			return err
+		}
+		if err := VerifyChecksum(body); err != errNoChecksum {
+			return err
+		}
+		return nil
+	}
+	if body, _, ok := splitTrailer(data, signaturePrefix); ok {
+		data = body
+	}
+	return VerifyChecksum(data)
+}
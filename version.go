@@ -0,0 +1,126 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// versionInfo describes the build of the program, see runVersion
+type versionInfo struct {
+	Module    string            `json:"module"`
+	Version   string            `json:"version"`
+	Revision  string            `json:"revision,omitempty"`
+	Modified  bool              `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	Time      string            `json:"time,omitempty"`     // commit time of the revision
+	GoVersion string            `json:"go"`
+	Platform  string            `json:"platform"`
+	Formats   []string          `json:"formats"`
+	Sinks     map[string]string `json:"sinks"`
+}
+
+// newVersionInfo collects the version information from the build info
+// embedded by the Go toolchain, if any, and from the registries of the
+// output formats and sinks
+func newVersionInfo(bi *debug.BuildInfo, ok bool) versionInfo {
+	v := versionInfo{
+		Module:    "github.com/MawKKe/stopwatch-go",
+		Version:   "unknown",
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Formats:   formatNames(),
+		Sinks:     sinkKinds,
+	}
+	if !ok {
+		return v
+	}
+	if bi.Main.Path != "" {
+		v.Module = bi.Main.Path
+	}
+	if bi.Main.Version != "" {
+		v.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		case "vcs.time":
+			v.Time = s.Value
+		}
+	}
+	return v
+}
+
+// writeText writes v into out in a human readable form
+func (v versionInfo) writeText(out io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", v.Module, v.Version)
+	if v.Revision != "" {
+		modified := ""
+		if v.Modified {
+			modified = " (modified)"
+		}
+		fmt.Fprintf(&b, "Revision: %s%s %s\n", v.Revision, modified, v.Time)
+	}
+	fmt.Fprintf(&b, "Go: %s %s\n", v.GoVersion, v.Platform)
+	fmt.Fprintf(&b, "Formats: %s\n", strings.Join(v.Formats, ", "))
+	var names []string
+	for name := range v.Sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b.WriteString("Sinks:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-8s %s\n", name, v.Sinks[name])
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func runVersion(args []string) int {
+	fs := newFlagSet("version", "")
+	format := fs.String("format", "text", "Output format: text or json")
+	completeValues(fs, "format", func() []string { return []string{"text", "json"} })
+	if ok, status := parseFlags(fs, args); !ok {
+		return status
+	}
+	if fs.NArg() != 0 || (*format != "text" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+	v := newVersionInfo(debug.ReadBuildInfo())
+	var err error
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(v)
+	} else {
+		err = v.writeText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestNewVersionInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Path: "github.com/MawKKe/stopwatch-go", Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123abc"},
+			{Key: "vcs.modified", Value: "true"},
+			{Key: "vcs.time", Value: "2022-04-08T20:00:00Z"},
+		},
+	}
+	v := newVersionInfo(bi, true)
+	if v.Version != "v1.2.3" || v.Revision != "0123abc" || !v.Modified || v.Time != "2022-04-08T20:00:00Z" {
+		t.Errorf("Unexpected version info %+v", v)
+	}
+	// from the registries, not a list of their own
+	if !reflect.DeepEqual(v.Formats, formatNames()) || !reflect.DeepEqual(v.Sinks, sinkKinds) {
+		t.Errorf("Expected the registered formats and sinks, got %v and %v", v.Formats, v.Sinks)
+	}
+
+	var buf bytes.Buffer
+	if err := v.writeText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"v1.2.3\n", "Revision: 0123abc (modified)", "Formats: csv, ics", "  http "} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in %q", want, buf.String())
+		}
+	}
+
+	if v := newVersionInfo(nil, false); v.Version != "unknown" || v.Revision != "" {
+		t.Errorf("Unexpected version info without build info: %+v", v)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How often the -watch-file files and the -watch-pid processes are checked,
+// by default for -watch-dir
+const (
+	watchInterval    = 100 * time.Millisecond
+	watchPIDInterval = 20 * time.Millisecond
+)
+
+// Prefixes of the labels of the events recorded by -watch-file and -watch-pid
+const (
+	labelFilePrefix    = "file:"
+	labelPIDExitPrefix = "pid-exit:"
+)
+
+// fileState is what is compared to notice a file changing
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileState, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fileState{}, nil
+	}
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{exists: true, modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// fileWatcher records an event when a file is modified, or created. The
+// files are polled, so several writes between two checks are recorded
+// once; for longer bursts, see -debounce.
+type fileWatcher struct {
+	paths    []string
+	states   []fileState
+	interval time.Duration
+	out      io.Writer // notes and errors
+}
+
+// watchFiles starts watching the files at paths, passing the events on
+// into in
+func watchFiles(in *remoteInput, paths []string, interval time.Duration, out io.Writer) *fileWatcher {
+	w := &fileWatcher{paths: paths, interval: interval, out: out}
+	for _, path := range paths {
+		state, err := statFile(path)
+		if err != nil {
+			fmt.Fprintf(out, "# WARNING: could not watch %s: %v\n", path, err)
+		} else if !state.exists {
+			fmt.Fprintf(out, "# %s does not exist yet, watching for it\n", path)
+		}
+		w.states = append(w.states, state)
+	}
+	in.start("-watch-file", w)
+	return w
+}
+
+func (w *fileWatcher) run(ctx context.Context, emit func(req remoteLine) string) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		for i, path := range w.paths {
+			state, err := statFile(path)
+			if err != nil {
+				continue // reported at start, or temporary
+			}
+			changed := state.exists && state != w.states[i]
+			w.states[i] = state
+			if changed {
+				emit(remoteLine{text: labelFilePrefix + path, source: sourceWatch, at: time.Now(), exact: true, async: true})
+			}
+		}
+	}
+}
+
+// dirWatcher records an event when a file appears in a directory, created
+// or renamed into it, labeled with the name of the file. Subdirectories are
+// not watched.
+type dirWatcher struct {
+	dir      string
+	seen     map[string]bool // the files present at the previous check
+	withPath bool            // add the path of the file as the "path" attribute
+	interval time.Duration
+	out      io.Writer
+}
+
+// watchDir starts watching the directory dir, passing the events on into
+// in. The files already present are recorded only if initial is set.
+func watchDir(in *remoteInput, dir string, initial, withPath bool, interval time.Duration, out io.Writer) (*dirWatcher, error) {
+	w := &dirWatcher{dir: dir, seen: make(map[string]bool), withPath: withPath, interval: interval, out: out}
+	names, err := w.files()
+	if err != nil {
+		return nil, err
+	}
+	if !initial {
+		for _, name := range names {
+			w.seen[name] = true
+		}
+	}
+	in.start("-watch-dir "+dir, w)
+	return w, nil
+}
+
+// files lists the names of the files in the directory, in sorted order
+func (w *dirWatcher) files() ([]string, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (w *dirWatcher) run(ctx context.Context, emit func(req remoteLine) string) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		names, err := w.files()
+		if err != nil && !failing {
+			fmt.Fprintf(w.out, "\n# WARNING: could not read %s: %v\n", w.dir, err)
+		}
+		failing = err != nil
+		if err == nil {
+			present := make(map[string]bool, len(names))
+			for _, name := range names {
+				present[name] = true
+				if w.seen[name] {
+					continue
+				}
+				req := remoteLine{text: name, source: sourceWatch, at: time.Now(), exact: true, async: true}
+				if w.withPath {
+					req.attrs = map[string]string{"path": filepath.Join(w.dir, name)}
+				}
+				emit(req)
+			}
+			w.seen = present
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// pidWatcher records an event when a process exits. The processes are
+// polled, so the event is recorded within watchPIDInterval of the exit.
+type pidWatcher struct {
+	pids     []int
+	interval time.Duration
+	onExit   func() // called once every process has exited, unless nil
+}
+
+// watchPIDs starts watching the processes pids, passing the events on
+// into in. A process not running is recorded at once.
+func watchPIDs(in *remoteInput, pids []int, interval time.Duration, onExit func(), out io.Writer) *pidWatcher {
+	w := &pidWatcher{interval: interval, onExit: onExit}
+	for _, pid := range pids {
+		alive, err := processAlive(pid)
+		switch {
+		case err != nil:
+			// e.g. EPERM: the exit is still noticed
+			fmt.Fprintf(out, "# Process %d exists, but belongs to another user (%v); watching it anyway\n", pid, err)
+		case !alive:
+			fmt.Fprintf(out, "# Process %d is not running, recording its exit now\n", pid)
+		}
+		w.pids = append(w.pids, pid)
+	}
+	in.start("-watch-pid", w)
+	return w
+}
+
+func (w *pidWatcher) run(ctx context.Context, emit func(req remoteLine) string) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		running := w.pids[:0]
+		for _, pid := range w.pids {
+			if alive, _ := processAlive(pid); alive {
+				running = append(running, pid)
+				continue
+			}
+			emit(remoteLine{text: fmt.Sprintf("%s%d", labelPIDExitPrefix, pid), source: sourceWatch, at: time.Now(), exact: true, async: true})
+		}
+		w.pids = running
+		if len(w.pids) == 0 {
+			if w.onExit != nil {
+				w.onExit()
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
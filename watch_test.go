@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	existing, missing := filepath.Join(dir, "app.bin"), filepath.Join(dir, "later.bin")
+	if err := os.WriteFile(existing, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	in := newRemoteInput()
+	requests := captureRemote(in)
+	var notes bytes.Buffer
+	watchFiles(in, []string{existing, missing}, 10*time.Millisecond, &notes)
+	if !strings.Contains(notes.String(), "later.bin does not exist yet") {
+		t.Errorf("Expected a note on the missing file, got %q", notes.String())
+	}
+	expect := func(path string) {
+		t.Helper()
+		select {
+		case req := <-requests:
+			if req.text != "file:"+path || !req.exact {
+				t.Errorf("Expected a change of %s, got %+v", path, req)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a change of %s", path)
+		}
+	}
+	if err := os.WriteFile(existing, []byte("v2 is longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect(existing)
+	if err := os.WriteFile(missing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect(missing)
+
+	in.stop()
+	expectNoRemote(t, requests)
+}
+
+func TestPIDWatcher(t *testing.T) {
+	// a child process exiting at once, reaped so that its PID goes away
+	child := exec.Command(os.Args[0], "-test.run=^$")
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	in := newRemoteInput()
+	requests := captureRemote(in)
+	exited := make(chan struct{})
+	var notes bytes.Buffer
+	watchPIDs(in, []int{child.Process.Pid, os.Getpid()}, time.Millisecond, func() { close(exited) }, &notes)
+	child.Wait()
+	select {
+	case req := <-requests:
+		if want := fmt.Sprintf("pid-exit:%d", child.Process.Pid); req.text != want || !req.exact {
+			t.Errorf("Expected %s, got %+v", want, req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the child to exit")
+	}
+	select {
+	case <-exited:
+		t.Error("Expected the test process to be watched still")
+	case <-time.After(20 * time.Millisecond):
+	}
+	in.stop()
+	expectNoRemote(t, requests)
+
+	// a process not running is recorded at once
+	in = newRemoteInput()
+	requests = captureRemote(in)
+	notes.Reset()
+	exited = make(chan struct{})
+	watchPIDs(in, []int{child.Process.Pid}, time.Hour, func() { close(exited) }, &notes)
+	if req := <-requests; !strings.HasPrefix(req.text, "pid-exit:") {
+		t.Errorf("Expected the exit, got %+v", req)
+	}
+	<-exited
+	if !strings.Contains(notes.String(), "is not running") {
+		t.Errorf("Expected a note on the process not running, got %q", notes.String())
+	}
+	in.stop()
+}
+
+func TestDirWatcher(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "old.dat"), nil, 0o644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+	expect := func(requests <-chan remoteLine, name string) {
+		t.Helper()
+		select {
+		case req := <-requests:
+			if req.text != name || !req.exact || req.attrs["path"] != filepath.Join(dir, name) {
+				t.Errorf("Expected %s, got %+v", name, req)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", name)
+		}
+	}
+
+	in := newRemoteInput()
+	requests := captureRemote(in)
+	if _, err := watchDir(in, dir, true, true, 10*time.Millisecond, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	expect(requests, "old.dat")
+	os.WriteFile(filepath.Join(dir, "sample-1.dat"), nil, 0o644)
+	expect(requests, "sample-1.dat")
+	os.Mkdir(filepath.Join(dir, "sub", "nested"), 0o755)
+	os.WriteFile(filepath.Join(dir, "sub", "nested.dat"), nil, 0o644)
+	os.Rename(filepath.Join(dir, "sub", "nested.dat"), filepath.Join(dir, "moved.dat"))
+	expect(requests, "moved.dat")
+	in.stop()
+	expectNoRemote(t, requests)
+
+	// the files present at startup are ignored by default
+	in = newRemoteInput()
+	requests = captureRemote(in)
+	watchDir(in, dir, false, true, 10*time.Millisecond, io.Discard)
+	os.WriteFile(filepath.Join(dir, "sample-2.dat"), nil, 0o644)
+	expect(requests, "sample-2.dat")
+	in.stop()
+
+	if _, err := watchDir(newRemoteInput(), filepath.Join(dir, "missing"), false, false, time.Second, io.Discard); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// localtimePath is the system time zone configuration on unix-like systems
+var localtimePath = "/etc/localtime"
+
+// localZoneName returns the IANA name of the local time zone (such as
+// "Europe/Helsinki"), looked up from $TZ or the /etc/localtime symlink. If
+// the name can not be determined, the numeric UTC offset in effect at t is
+// returned instead (such as "+03:00"). The lookup is repeated on every call
+// so that configuration changes during a session are noticed.
+func localZoneName(t time.Time) string {
+	if tz, ok := os.LookupEnv("TZ"); ok {
+		tz = strings.TrimPrefix(tz, ":")
+		if tz == "" {
+			return "UTC"
+		}
+		if !filepath.IsAbs(tz) {
+			if _, err := time.LoadLocation(tz); err == nil {
+				return tz
+			}
+		}
+	} else if target, err := filepath.EvalSymlinks(localtimePath); err == nil {
+		const marker = "zoneinfo/"
+		if i := strings.LastIndex(target, marker); i >= 0 {
+			return target[i+len(marker):]
+		}
+	}
+	return t.Format("-07:00")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalZoneNameFromTZ(t *testing.T) {
+	ts := time.Date(2022, 4, 8, 20, 0, 0, 0, time.FixedZone("", 3*3600))
+	tests := map[string]string{
+		"Europe/Helsinki":  "Europe/Helsinki",
+		":America/Chicago": "America/Chicago",
+		"":                 "UTC",
+		"Not/AZone":        "+03:00",
+	}
+	for tz, expect := range tests {
+		t.Setenv("TZ", tz)
+		if got := localZoneName(ts); got != expect {
+			t.Errorf("TZ=%q: expected %q, got %q", tz, expect, got)
+		}
+	}
+}
+
+func TestLocalZoneNameFromLocaltime(t *testing.T) {
+	tz, hadTZ := os.LookupEnv("TZ")
+	os.Unsetenv("TZ")
+	defer func() {
+		if hadTZ {
+			os.Setenv("TZ", tz)
+		}
+	}()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "zoneinfo", "Asia", "Tokyo")
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "localtime")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	defer func(orig string) { localtimePath = orig }(localtimePath)
+
+	localtimePath = link
+	if got := localZoneName(time.Now()); got != "Asia/Tokyo" {
+		t.Errorf("Expected %q, got %q", "Asia/Tokyo", got)
+	}
+	localtimePath = filepath.Join(dir, "missing")
+	ts := time.Date(2022, 4, 8, 20, 0, 0, 0, time.FixedZone("", -5*3600))
+	if got := localZoneName(ts); got != "-05:00" {
+		t.Errorf("Expected fallback to numeric offset, got %q", got)
+	}
+}
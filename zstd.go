@@ -0,0 +1,50 @@
+// Copyright 2022 Markus Holmström (MawKKe) markus@mawkke.fi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The zstd format for compressed output files, see compress.go. The levels
+// are those of the reference zstd command; the encoder maps them onto its
+// own few levels.
+
+const (
+	zstdMinLevel     = 1
+	zstdMaxLevel     = 19
+	zstdDefaultLevel = 3
+	zstdMaxWindow    = 1 << 27 // the largest window read, as the zstd command does by default
+)
+
+// newZstdWriter returns a writer compressing into out at one of the levels
+// zstdMinLevel to zstdMaxLevel. An empty output is still written as a
+// frame, so that it can be read back as zstd.
+func newZstdWriter(out io.Writer, level int) (io.WriteCloser, error) {
+	if level < zstdMinLevel || level > zstdMaxLevel {
+		return nil, fmt.Errorf("zstd level %d out of range %d-%d", level, zstdMinLevel, zstdMaxLevel)
+	}
+	return zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithZeroFrames(true))
+}
+
+// newZstdReader returns a reader decompressing the zstd frames of in, one
+// after another. It decodes in the calling goroutine, so that nothing is
+// left running when the reading stops early.
+func newZstdReader(in io.Reader) (io.Reader, error) {
+	return zstd.NewReader(in, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"testing"
+)
+
+// zstdTestInputs returns inputs exercising the kinds of blocks
+func zstdTestInputs() map[string][]byte {
+	var csv bytes.Buffer
+	for i := 0; csv.Len() < 3<<20; i++ { // past the window, and many blocks
+		fmt.Fprintf(&csv, "%d,2022-04-08T20:%02d:%02d.%09dZ,lap %d\n", i, i/60%60, i%60, i*7919%1000000000, i%17)
+	}
+	random := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(random)
+	return map[string][]byte{
+		"empty":  nil,
+		"byte":   []byte("a"),
+		"short":  []byte("seq,ts,what\n0,2022-04-08T20:00:00Z,enter\n"),
+		"same":   bytes.Repeat([]byte("x"), 300000),
+		"random": random,
+		"csv":    csv.Bytes(),
+		"utf8":   bytes.Repeat([]byte("kierros äöå — 漢字\n"), 5000),
+	}
+}
+
+func zstdCompress(t *testing.T, data []byte, level int) []byte {
+	var buf bytes.Buffer
+	z, err := newZstdWriter(&buf, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in uneven pieces
+	for rest := data; len(rest) > 0; {
+		n := 70001
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if _, err := z.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdDecompress(t *testing.T, compressed []byte) ([]byte, error) {
+	r, err := newZstdReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return io.ReadAll(r)
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	for name, data := range zstdTestInputs() {
+		for _, level := range []int{zstdMinLevel, zstdDefaultLevel, zstdMaxLevel} {
+			compressed := zstdCompress(t, data, level)
+			got, err := zstdDecompress(t, compressed)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s at level %d: got %d bytes of %d, %v", name, level, len(got), len(data), err)
+			}
+			if name == "csv" && len(compressed) > len(data)/2 {
+				t.Errorf("Expected the CSV to compress, got %d bytes of %d", len(compressed), len(data))
+			}
+		}
+	}
+	if _, err := newZstdWriter(io.Discard, zstdMaxLevel+1); err == nil {
+		t.Error("Expected error for a level out of range")
+	}
+}
+
+func TestZstdCommand(t *testing.T) {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd not installed")
+	}
+	for name, data := range zstdTestInputs() {
+		if len(data) > 1<<20 {
+			data = data[:1<<20] // enough for the command
+		}
+		// the command decodes ours
+		cmd := exec.Command(zstd, "-d", "-c")
+		cmd.Stdin = bytes.NewReader(zstdCompress(t, data, zstdDefaultLevel))
+		if got, err := cmd.Output(); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: zstd -d got %d bytes of %d, %v", name, len(got), len(data), err)
+		}
+		// and we decode the command's
+		for _, level := range []string{"-1", "-19"} {
+			cmd := exec.Command(zstd, level, "-c")
+			cmd.Stdin = bytes.NewReader(data)
+			compressed, err := cmd.Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := zstdDecompress(t, compressed); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s: zstd %s decoded into %d bytes of %d, %v", name, level, len(got), len(data), err)
+			}
+		}
+	}
+}
+
+func TestZstdReaderFrames(t *testing.T) {
+	one, two := zstdCompress(t, []byte("first\n"), 3), zstdCompress(t, []byte("second\n"), 3)
+	skippable := []byte{0x5a, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'x', 'y', 'z'}
+	in := append(append(append([]byte(nil), one...), skippable...), two...)
+	if got, err := zstdDecompress(t, in); err != nil || string(got) != "first\nsecond\n" {
+		t.Errorf("Expected both frames, got %q, %v", got, err)
+	}
+
+	corrupt := append([]byte(nil), one...)
+	corrupt[len(corrupt)-1] ^= 1 // the checksum
+	for name, in := range map[string][]byte{
+		"checksum":  corrupt,
+		"truncated": one[:len(one)-3],
+		"not zstd":  []byte("seq,ts,what\n"),
+	} {
+		if _, err := zstdDecompress(t, in); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}